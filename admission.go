@@ -0,0 +1,140 @@
+package ipfscluster
+
+import (
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/ipfs/ipfs-cluster/api"
+)
+
+// Names of the metrics with which peers announce the number of pin
+// operations they have pending (see Config.MaxPendingPins), the number
+// of items in error in their pin tracker and their number of goroutines.
+const (
+	pendingMetricName    = "pending"
+	pinErrorsMetricName  = "pinerrors"
+	goroutinesMetricName = "goroutines"
+)
+
+// trackerCounts returns the number of pins which are queued or being
+// pinned by the local tracker, and the number of items in error. Trackers
+// which are not StatusCounters need to list all their items for this.
+func (c *Cluster) trackerCounts() (pending, errors int) {
+	if sc, ok := c.tracker.(StatusCounter); ok {
+		pending = sc.StatusCount(api.TrackerStatusPinQueued) +
+			sc.StatusCount(api.TrackerStatusPinning)
+		errors = sc.StatusCount(api.TrackerStatusPinError) +
+			sc.StatusCount(api.TrackerStatusUnpinError)
+		return
+	}
+
+	for _, pinfo := range c.tracker.StatusAll() {
+		switch pinfo.Status {
+		case api.TrackerStatusPinQueued, api.TrackerStatusPinning:
+			pending++
+		case api.TrackerStatusPinError, api.TrackerStatusUnpinError:
+			errors++
+		}
+	}
+	return
+}
+
+// how often waitPendingPins checks the number of pending operations
+var pendingPinsCheckInterval = time.Second
+
+// waitPendingPins blocks until the local tracker has fewer than n pending
+// pin operations, or the peer shuts down.
+func (c *Cluster) waitPendingPins(n int) error {
+	for {
+		pending, _ := c.trackerCounts()
+		if pending < n {
+			return nil
+		}
+		c.logger.Debugf("waiting for %d pending pin operations to go under %d", pending, n)
+		select {
+		case <-c.ctx.Done():
+			return c.ctx.Err()
+		case <-time.After(pendingPinsCheckInterval):
+		}
+	}
+}
+
+// pushTrackerMetrics regularly publishes the number of pending pin
+// operations, of errors and of goroutines of this peer, so that the peers
+// receiving pin requests can report back-pressure and operators can
+// check the health of every peer. The leader logs an alert when the
+// number of pending operations in the cluster goes over
+// Config.MaxPendingPins.
+func (c *Cluster) pushTrackerMetrics() {
+	ticker := time.NewTicker(c.config.MonitorPingInterval)
+	defer ticker.Stop()
+	overloaded := false
+
+	for {
+		pending, errors := c.trackerCounts()
+		for name, n := range map[string]int{
+			pendingMetricName:    pending,
+			pinErrorsMetricName:  errors,
+			goroutinesMetricName: runtime.NumGoroutine(),
+		} {
+			metric := api.Metric{
+				Name:  name,
+				Peer:  c.id,
+				Value: strconv.Itoa(n),
+				Valid: true,
+			}
+			metric.SetTTL(c.pingMetricTTL())
+			c.monitor.PublishMetric(metric)
+		}
+
+		if leader, err := c.consensus.Leader(); err == nil && leader == c.id {
+			total := c.clusterPendingPins()
+			switch {
+			case c.config.MaxPendingPins <= 0:
+			case !overloaded && total > c.config.MaxPendingPins:
+				c.logger.Errorf("***** %d pending pin operations in the cluster, over the limit of %d *****", total, c.config.MaxPendingPins)
+				c.logger.Error("Pins will be reported with back-pressure until the queues shrink.")
+				overloaded = true
+			case overloaded && total <= c.config.MaxPendingPins:
+				c.logger.Infof("pending pin operations in the cluster back under the limit (%d)", total)
+				overloaded = false
+			}
+		}
+
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// clusterPendingPins returns the number of pending pin operations in the
+// cluster, as last announced by every peer.
+func (c *Cluster) clusterPendingPins() int {
+	var total int
+	for _, m := range c.Metrics(pendingMetricName) {
+		pending, err := strconv.Atoi(m.Value)
+		if err != nil {
+			continue
+		}
+		total += pending
+	}
+	return total
+}
+
+// BackPressure returns the number of pending pin operations in the
+// cluster when it is over Config.MaxPendingPins, and 0 otherwise. Pins
+// are committed to the shared state regardless: this only tells callers
+// that they should slow down.
+func (c *Cluster) BackPressure() int {
+	if c.config.MaxPendingPins <= 0 {
+		return 0
+	}
+	total := c.clusterPendingPins()
+	if total <= c.config.MaxPendingPins {
+		return 0
+	}
+	return total
+}
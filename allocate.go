@@ -3,6 +3,8 @@ package ipfscluster
 import (
 	"errors"
 	"fmt"
+	"sort"
+	"strconv"
 
 	cid "github.com/ipfs/go-cid"
 	peer "github.com/libp2p/go-libp2p-peer"
@@ -22,7 +24,8 @@ import (
 //   monitor component
 // * Divide the metrics between "current" (peers already pinning the CID)
 //   and "candidates" (peers that could pin the CID), as long as their metrics
-//   are valid.
+//   are valid. Peers whose metrics are marked as unallocatable (i.e. they
+//   are running out of disk space) are never candidates.
 // * Given the candidates:
 //   * Check if we are overpinning an item
 //   * Check if there are not enough candidates for the "needed" replication
@@ -35,12 +38,17 @@ import (
 //     * Take as many final candidates from the list as we can, until
 //       ReplicationFactorMax is reached. Error if there are less than
 //       ReplicationFactorMin.
+// * If no new allocations are needed and RebalanceAllocations is set,
+//   let the allocator rank current allocations along with the candidates
+//   and replace the worst current allocations with better candidates, as
+//   long as their metrics differ by more than the AllocationHysteresis.
 
 // allocate finds peers to allocate a hash using the informer and the monitor
 // it should only be used with valid replicationFactors (rplMin and rplMax
 // which are positive and rplMin <= rplMax).
 // It always returns allocations, but if no new allocations are needed,
-// it will return the current ones. Note that allocate() does not take
+// it will return the current ones (possibly rebalanced, see
+// Config.RebalanceAllocations). Note that allocate() does not take
 // into account if the given CID was previously in a "pin everywhere" mode,
 // and will consider such Pins as currently unallocated ones, providing
 // new allocations as available.
@@ -48,7 +56,7 @@ func (c *Cluster) allocate(hash *cid.Cid, rplMin, rplMax int, blacklist []peer.I
 	// Figure out who is holding the CID
 	currentPin, _ := c.getCurrentPin(hash)
 	currentAllocs := currentPin.Allocations
-	metrics := c.monitor.LatestMetrics(c.informer.Name())
+	metrics := c.Metrics(c.informer.Name())
 
 	currentMetrics := make(map[peer.ID]api.Metric)
 	candidatesMetrics := make(map[peer.ID]api.Metric)
@@ -64,6 +72,10 @@ func (c *Cluster) allocate(hash *cid.Cid, rplMin, rplMax int, blacklist []peer.I
 			continue
 		case containsPeer(currentAllocs, m.Peer):
 			currentMetrics[m.Peer] = m
+		case m.Unallocatable:
+			// discard peers which cannot take new content
+			c.logger.Debugf("%s is unallocatable", m.Peer.Pretty())
+			continue
 		case containsPeer(prioritylist, m.Peer):
 			priorityMetrics[m.Peer] = m
 		default:
@@ -100,13 +112,13 @@ func (c *Cluster) getCurrentPin(h *cid.Cid) (api.Pin, bool) {
 }
 
 // allocationError logs an allocation error
-func allocationError(hash *cid.Cid, needed, wanted int, candidatesValid []peer.ID) error {
-	logger.Errorf("Not enough candidates to allocate %s:", hash)
-	logger.Errorf("  Needed: %d", needed)
-	logger.Errorf("  Wanted: %d", wanted)
-	logger.Errorf("  Valid candidates: %d:", len(candidatesValid))
-	for _, c := range candidatesValid {
-		logger.Errorf("    - %s", c.Pretty())
+func (c *Cluster) allocationError(hash *cid.Cid, needed, wanted int, candidatesValid []peer.ID) error {
+	c.logger.Errorf("Not enough candidates to allocate %s:", hash)
+	c.logger.Errorf("  Needed: %d", needed)
+	c.logger.Errorf("  Wanted: %d", wanted)
+	c.logger.Errorf("  Valid candidates: %d:", len(candidatesValid))
+	for _, p := range candidatesValid {
+		c.logger.Errorf("    - %s", p.Pretty())
 	}
 	errorMsg := "not enough peers to allocate CID. "
 	errorMsg += fmt.Sprintf("Needed at least: %d. ", needed)
@@ -135,10 +147,10 @@ func (c *Cluster) obtainAllocations(
 	needed := rplMin - nCurrentValid // The minimum we need
 	wanted := rplMax - nCurrentValid // The maximum we want
 
-	logger.Debugf("obtainAllocations: current valid: %d", nCurrentValid)
-	logger.Debugf("obtainAllocations: candidates valid: %d", nCandidatesValid)
-	logger.Debugf("obtainAllocations: Needed: %d", needed)
-	logger.Debugf("obtainAllocations: Wanted: %d", wanted)
+	c.logger.Debugf("obtainAllocations: current valid: %d", nCurrentValid)
+	c.logger.Debugf("obtainAllocations: candidates valid: %d", nCandidatesValid)
+	c.logger.Debugf("obtainAllocations: Needed: %d", needed)
+	c.logger.Debugf("obtainAllocations: Wanted: %d", wanted)
 
 	// Reminder: rplMin <= rplMax AND >0
 
@@ -150,8 +162,14 @@ func (c *Cluster) obtainAllocations(
 	}
 
 	if needed <= 0 { // allocations are above minimal threshold
-		// We don't provide any new allocations
-		return nil, nil
+		// We don't provide any new allocations, but we may
+		// move some of the current ones to better candidates.
+		return c.rebalanceAllocations(
+			hash,
+			currentValidMetrics,
+			candidatesMetrics,
+			priorityMetrics,
+		)
 	}
 
 	if nCandidatesValid < needed { // not enough candidates
@@ -162,25 +180,25 @@ func (c *Cluster) obtainAllocations(
 		for k := range candidatesMetrics {
 			candidatesValid = append(candidatesValid, k)
 		}
-		return nil, allocationError(hash, needed, wanted, candidatesValid)
+		return nil, c.allocationError(hash, needed, wanted, candidatesValid)
 	}
 
 	// We can allocate from this point. Use the allocator to decide
 	// on the priority of candidates grab as many as "wanted"
 
 	// the allocator returns a list of peers ordered by priority
-	finalAllocs, err := c.allocator.Allocate(
+	finalAllocs, err := c.pinAllocator().Allocate(
 		hash, currentValidMetrics, candidatesMetrics, priorityMetrics)
 	if err != nil {
-		return nil, logError(err.Error())
+		return nil, c.logger.logError(err.Error())
 	}
 
-	logger.Debugf("obtainAllocations: allocate(): %s", finalAllocs)
+	c.logger.Debugf("obtainAllocations: allocate(): %s", finalAllocs)
 
 	// check that we have enough as the allocator may have returned
 	// less candidates than provided.
 	if got := len(finalAllocs); got < needed {
-		return nil, allocationError(hash, needed, wanted, finalAllocs)
+		return nil, c.allocationError(hash, needed, wanted, finalAllocs)
 	}
 
 	allocationsToUse := minInt(wanted, len(finalAllocs))
@@ -189,3 +207,136 @@ func (c *Cluster) obtainAllocations(
 	// along with the ones provided by the allocator
 	return append(validAllocations, finalAllocs[0:allocationsToUse]...), nil
 }
+
+// rebalanceAllocations asks the allocator to rank the current allocations
+// along with the candidates and returns the new allocations resulting from
+// stickyAllocations(). It returns nil when the current allocations
+// should be kept.
+func (c *Cluster) rebalanceAllocations(
+	hash *cid.Cid,
+	currentValidMetrics map[peer.ID]api.Metric,
+	candidatesMetrics map[peer.ID]api.Metric,
+	priorityMetrics map[peer.ID]api.Metric,
+) ([]peer.ID, error) {
+	if !c.config.RebalanceAllocations || len(currentValidMetrics) == 0 {
+		return nil, nil
+	}
+	hysteresis := c.config.AllocationHysteresis
+	if len(candidatesMetrics)+len(priorityMetrics) == 0 {
+		return nil, nil
+	}
+
+	metrics := make(map[peer.ID]api.Metric)
+	for k, m := range currentValidMetrics {
+		metrics[k] = m
+	}
+	for k, m := range candidatesMetrics {
+		metrics[k] = m
+	}
+
+	ranked, err := c.pinAllocator().Allocate(
+		hash, make(map[peer.ID]api.Metric), metrics, priorityMetrics)
+	if err != nil {
+		return nil, c.logger.logError(err.Error())
+	}
+
+	for k, m := range priorityMetrics {
+		metrics[k] = m
+	}
+
+	current := make([]peer.ID, 0, len(currentValidMetrics))
+	for k := range currentValidMetrics {
+		current = append(current, k)
+	}
+
+	allocs := stickyAllocations(ranked, current, metrics, hysteresis)
+	if allocs != nil {
+		c.logger.Infof("rebalancing allocations for %s: %s", hash, allocs)
+	}
+	return allocs, nil
+}
+
+// stickyAllocations takes a list of peers ranked by order of preference
+// and replaces the least preferred current allocations with the most
+// preferred peers which are not allocated. A replacement only happens
+// when the candidate ranks before the current allocation and their
+// numeric metric values differ by more than the given hysteresis
+// (a fraction of the largest value). Current allocations missing from
+// the ranking are always replaced first. It returns nil when no
+// allocation needs to change.
+func stickyAllocations(ranked, current []peer.ID, metrics map[peer.ID]api.Metric, hysteresis float64) []peer.ID {
+	rank := make(map[peer.ID]int, len(ranked))
+	for i, p := range ranked {
+		rank[p] = i
+	}
+	rankOf := func(p peer.ID) int {
+		if i, ok := rank[p]; ok {
+			return i
+		}
+		return len(ranked) // vetoed by the allocator: last
+	}
+
+	// worst current allocations first
+	worst := make([]peer.ID, len(current), len(current))
+	copy(worst, current)
+	sort.SliceStable(worst, func(i, j int) bool {
+		return rankOf(worst[i]) > rankOf(worst[j])
+	})
+
+	// best candidates first
+	var best []peer.ID
+	for _, p := range ranked {
+		if !containsPeer(current, p) {
+			best = append(best, p)
+		}
+	}
+
+	replaced := make(map[peer.ID]peer.ID)
+	for i := 0; i < len(worst) && i < len(best); i++ {
+		cur, cand := worst[i], best[i]
+		if rankOf(cand) > rankOf(cur) {
+			break
+		}
+		_, ok := rank[cur]
+		if ok && !metricsDiffer(metrics[cur], metrics[cand], hysteresis) {
+			break
+		}
+		replaced[cur] = cand
+	}
+
+	if len(replaced) == 0 {
+		return nil
+	}
+
+	allocs := make([]peer.ID, 0, len(current))
+	for _, p := range current {
+		if cand, ok := replaced[p]; ok {
+			allocs = append(allocs, cand)
+			continue
+		}
+		allocs = append(allocs, p)
+	}
+	return allocs
+}
+
+// metricsDiffer returns true when the numeric values of two metrics
+// differ by more than the given fraction of the largest one. Non-numeric
+// metrics never differ.
+func metricsDiffer(m1, m2 api.Metric, fraction float64) bool {
+	v1, err := strconv.ParseUint(m1.Value, 10, 64)
+	if err != nil {
+		return false
+	}
+	v2, err := strconv.ParseUint(m2.Value, 10, 64)
+	if err != nil {
+		return false
+	}
+	if v1 == v2 {
+		return false
+	}
+	max, diff := v1, v1-v2
+	if v2 > v1 {
+		max, diff = v2, v2-v1
+	}
+	return float64(diff)/float64(max) > fraction
+}
@@ -0,0 +1,130 @@
+package ipfscluster
+
+import (
+	"testing"
+
+	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/test"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+func testAllocMetrics(values map[peer.ID]string) map[peer.ID]api.Metric {
+	metrics := make(map[peer.ID]api.Metric)
+	for p, v := range values {
+		metrics[p] = api.Metric{
+			Name:  "test",
+			Peer:  p,
+			Value: v,
+			Valid: true,
+		}
+	}
+	return metrics
+}
+
+func TestStickyAllocations(t *testing.T) {
+	metrics := testAllocMetrics(map[peer.ID]string{
+		test.TestPeerID1: "1000",
+		test.TestPeerID2: "950",
+		test.TestPeerID3: "500",
+		test.TestPeerID4: "100",
+	})
+
+	// Descending order (i.e. free space)
+	ranked := []peer.ID{
+		test.TestPeerID1,
+		test.TestPeerID2,
+		test.TestPeerID3,
+		test.TestPeerID4,
+	}
+
+	t.Run("small difference keeps allocations", func(t *testing.T) {
+		current := []peer.ID{test.TestPeerID2}
+		allocs := stickyAllocations(ranked, current, metrics, 0.1)
+		if allocs != nil {
+			t.Error("expected no changes:", allocs)
+		}
+	})
+
+	t.Run("large difference moves allocations", func(t *testing.T) {
+		current := []peer.ID{test.TestPeerID2, test.TestPeerID4}
+		allocs := stickyAllocations(ranked, current, metrics, 0.1)
+		if len(allocs) != 2 ||
+			allocs[0] != test.TestPeerID2 ||
+			allocs[1] != test.TestPeerID1 {
+			t.Error("expected the worst allocation to move:", allocs)
+		}
+	})
+
+	t.Run("best peers already allocated", func(t *testing.T) {
+		current := []peer.ID{test.TestPeerID1, test.TestPeerID2}
+		allocs := stickyAllocations(ranked, current, metrics, 0)
+		if allocs != nil {
+			t.Error("expected no changes:", allocs)
+		}
+	})
+
+	t.Run("vetoed allocations are replaced", func(t *testing.T) {
+		current := []peer.ID{test.TestPeerID5}
+		allocs := stickyAllocations(ranked, current, metrics, 0.99)
+		if len(allocs) != 1 || allocs[0] != test.TestPeerID1 {
+			t.Error("expected vetoed allocation to be replaced:", allocs)
+		}
+	})
+}
+
+func TestStickyAllocationsMonotonic(t *testing.T) {
+	metrics := testAllocMetrics(map[peer.ID]string{
+		test.TestPeerID1: "1000",
+		test.TestPeerID2: "800",
+		test.TestPeerID3: "500",
+		test.TestPeerID4: "100",
+	})
+	ranked := []peer.ID{
+		test.TestPeerID1,
+		test.TestPeerID2,
+		test.TestPeerID3,
+		test.TestPeerID4,
+	}
+	current := []peer.ID{test.TestPeerID2, test.TestPeerID3, test.TestPeerID4}
+
+	moves := func(allocs []peer.ID) int {
+		n := 0
+		for i, p := range allocs {
+			if p != current[i] {
+				n++
+			}
+		}
+		return n
+	}
+
+	last := len(current) + 1
+	for _, h := range []float64{0, 0.1, 0.3, 0.6, 0.95, 1, 2} {
+		n := moves(stickyAllocations(ranked, current, metrics, h))
+		if n > last {
+			t.Errorf("a hysteresis of %.2f moved %d allocations, more than a smaller one (%d)", h, n, last)
+		}
+		last = n
+	}
+	if last != 0 {
+		t.Error("a hysteresis of 1 or more should keep valid allocations")
+	}
+}
+
+func TestMetricsDiffer(t *testing.T) {
+	metrics := testAllocMetrics(map[peer.ID]string{
+		test.TestPeerID1: "100",
+		test.TestPeerID2: "89",
+		test.TestPeerID3: "abc",
+	})
+
+	if !metricsDiffer(metrics[test.TestPeerID1], metrics[test.TestPeerID2], 0.1) {
+		t.Error("metrics should differ by more than 10%")
+	}
+	if metricsDiffer(metrics[test.TestPeerID2], metrics[test.TestPeerID1], 0.2) {
+		t.Error("metrics should not differ by more than 20%")
+	}
+	if metricsDiffer(metrics[test.TestPeerID1], metrics[test.TestPeerID3], 0) {
+		t.Error("non-numeric metrics should not differ")
+	}
+}
@@ -93,6 +93,25 @@ var testCases = []testcase{
 		current:  map[peer.ID]api.Metric{},
 		expected: []peer.ID{peer1},
 	},
+	{ // weights
+		candidates: map[peer.ID]api.Metric{
+			peer0: {
+				Name:   "some-metric",
+				Value:  "2",
+				Expire: inAMinute,
+				Valid:  true,
+			},
+			peer1: {
+				Name:   "some-metric",
+				Value:  "4",
+				Expire: inAMinute,
+				Valid:  true,
+				Weight: 4,
+			},
+		},
+		current:  map[peer.ID]api.Metric{},
+		expected: []peer.ID{peer1, peer0},
+	},
 }
 
 func Test(t *testing.T) {
@@ -0,0 +1,85 @@
+// Package rendezvousalloc implements an ipfscluster.PinAllocator which
+// returns allocations based on rendezvous hashing (highest random weight).
+// Every peer gets a score obtained by hashing the CID along with the peer ID
+// and peers with the highest scores are first in the list. Allocations are
+// thus deterministic: any peer can compute them without coordination, and
+// they are minimally disturbed when peers join or leave the cluster. Metric
+// values are ignored, but peers must provide a valid metric to be
+// considered.
+package rendezvousalloc
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"sort"
+
+	"github.com/ipfs/ipfs-cluster/api"
+
+	rpc "github.com/hsanjuan/go-libp2p-gorpc"
+	cid "github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+var logger = logging.Logger("rendezvousalloc")
+
+// RendezvousAllocator implements the PinAllocator interface using
+// rendezvous hashing.
+type RendezvousAllocator struct{}
+
+// NewAllocator returns an initialized RendezvousAllocator
+func NewAllocator() RendezvousAllocator {
+	return RendezvousAllocator{}
+}
+
+// SetClient does nothing in this allocator
+func (alloc RendezvousAllocator) SetClient(c *rpc.Client) {}
+
+// Shutdown does nothing in this allocator
+func (alloc RendezvousAllocator) Shutdown() error { return nil }
+
+// Allocate returns where to allocate a pin request. Priority peers come
+// first, and both priority peers and candidates are sorted by their
+// rendezvous score for the given CID (highest first). We do not pay
+// attention to the metrics of the currently allocated peers.
+func (alloc RendezvousAllocator) Allocate(c *cid.Cid, current,
+	candidates, priority map[peer.ID]api.Metric) ([]peer.ID, error) {
+	first := SortByScore(c, validPeers(priority))
+	last := SortByScore(c, validPeers(candidates))
+	return append(first, last...), nil
+}
+
+// Score returns the rendezvous score of a peer for the given CID.
+func Score(c *cid.Cid, p peer.ID) []byte {
+	h := sha256.New()
+	h.Write(c.Bytes())
+	h.Write([]byte(p))
+	return h.Sum(nil)
+}
+
+// SortByScore returns the given peers sorted by their rendezvous score
+// for the given CID, from highest to lowest.
+func SortByScore(c *cid.Cid, peers []peer.ID) []peer.ID {
+	scores := make(map[peer.ID][]byte, len(peers))
+	for _, p := range peers {
+		scores[p] = Score(c, p)
+	}
+
+	sorted := make([]peer.ID, len(peers), len(peers))
+	copy(sorted, peers)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(scores[sorted[i]], scores[sorted[j]]) > 0
+	})
+	return sorted
+}
+
+func validPeers(metrics map[peer.ID]api.Metric) []peer.ID {
+	peers := make([]peer.ID, 0, len(metrics))
+	for p, m := range metrics {
+		if m.Discard() {
+			continue
+		}
+		peers = append(peers, p)
+	}
+	return peers
+}
@@ -0,0 +1,101 @@
+package rendezvousalloc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ipfs/ipfs-cluster/api"
+
+	cid "github.com/ipfs/go-cid"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+var (
+	peer0       = peer.ID("QmUQ6Nsejt1SuZAu8yL8WgqQZHHAYreLVYYa4VPsLUCed7")
+	peer1       = peer.ID("QmUZ13osndQ5uL4tPWHXe3iBgBgq9gfewcBMSCAuMBsDJ6")
+	peer2       = peer.ID("QmPrSBATWGAN56fiiEWEhKX3L1F3mTghEQR7vQwaeo7zHi")
+	peer3       = peer.ID("QmPGDFvBkgWhvzEK9qaTWrWurSwqXNmhnK3hgELPdZZNPa")
+	testCid, _  = cid.Decode("QmP63DkAFEnDYNjDYBpyNDfttu1fvUw99x1brscPzpqmmq")
+	testCid2, _ = cid.Decode("QmP63DkAFEnDYNjDYBpyNDfttu1fvUw99x1brscPzpqmma")
+)
+
+var inAMinute = time.Now().Add(time.Minute).UnixNano()
+
+func testMetrics(valid bool, peers ...peer.ID) map[peer.ID]api.Metric {
+	metrics := make(map[peer.ID]api.Metric)
+	for _, p := range peers {
+		metrics[p] = api.Metric{
+			Name:   "some-metric",
+			Value:  "1",
+			Expire: inAMinute,
+			Valid:  valid,
+		}
+	}
+	return metrics
+}
+
+func TestAllocateDeterministic(t *testing.T) {
+	alloc := NewAllocator()
+	candidates := testMetrics(true, peer0, peer1, peer2, peer3)
+
+	res1, err := alloc.Allocate(testCid, nil, candidates, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res1) != 4 {
+		t.Fatal("expected 4 allocations")
+	}
+
+	for i := 0; i < 10; i++ {
+		res2, _ := alloc.Allocate(testCid, nil, candidates, nil)
+		for j := range res1 {
+			if res1[j] != res2[j] {
+				t.Fatal("allocations should be deterministic")
+			}
+		}
+	}
+}
+
+func TestAllocateMinimalDisruption(t *testing.T) {
+	alloc := NewAllocator()
+	for _, c := range []*cid.Cid{testCid, testCid2} {
+		all, _ := alloc.Allocate(c, nil, testMetrics(true, peer0, peer1, peer2, peer3), nil)
+
+		// Removing the last ranked peer does not change the order
+		// of the rest.
+		candidates := testMetrics(true, all[0:3]...)
+		res, _ := alloc.Allocate(c, nil, candidates, nil)
+		for i := range res {
+			if res[i] != all[i] {
+				t.Error("removing a peer should not affect the order of others")
+			}
+		}
+
+		// Removing the first ranked peer promotes the second.
+		candidates = testMetrics(true, all[1:]...)
+		res, _ = alloc.Allocate(c, nil, candidates, nil)
+		if res[0] != all[1] {
+			t.Error("expected the second peer to be first")
+		}
+	}
+}
+
+func TestAllocatePriorityAndInvalid(t *testing.T) {
+	alloc := NewAllocator()
+	candidates := testMetrics(true, peer0, peer1)
+	for p, m := range testMetrics(false, peer2) {
+		candidates[p] = m
+	}
+	priority := testMetrics(true, peer3)
+
+	res, err := alloc.Allocate(testCid, nil, candidates, priority)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 3 {
+		t.Fatal("expected invalid metrics to be discarded")
+	}
+	if res[0] != peer3 {
+		t.Error("priority peers should come first")
+	}
+}
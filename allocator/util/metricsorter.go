@@ -15,9 +15,11 @@ import (
 
 // SortNumeric returns a list of peers sorted by their metric values. If reverse
 // is false (true), peers will be sorted from smallest to largest (largest to
-// smallest) metric
+// smallest) metric. Metric weights make peers more attractive: values are
+// multiplied by the weight when sorting from largest to smallest, and
+// divided by it otherwise.
 func SortNumeric(candidates map[peer.ID]api.Metric, reverse bool) []peer.ID {
-	vMap := make(map[peer.ID]uint64)
+	vMap := make(map[peer.ID]float64)
 	peers := make([]peer.ID, 0, len(candidates))
 	for k, v := range candidates {
 		if v.Discard() {
@@ -28,7 +30,7 @@ func SortNumeric(candidates map[peer.ID]api.Metric, reverse bool) []peer.ID {
 			continue
 		}
 		peers = append(peers, k)
-		vMap[k] = val
+		vMap[k] = weighted(val, v.Weight, reverse)
 	}
 
 	sorter := &metricSorter{
@@ -40,10 +42,21 @@ func SortNumeric(candidates map[peer.ID]api.Metric, reverse bool) []peer.ID {
 	return sorter.peers
 }
 
+// weighted applies a metric weight to a value.
+func weighted(val uint64, weight float64, reverse bool) float64 {
+	if weight <= 0 {
+		return float64(val)
+	}
+	if reverse {
+		return float64(val) * weight
+	}
+	return float64(val) / weight
+}
+
 // metricSorter implements the sort.Sort interface
 type metricSorter struct {
 	peers   []peer.ID
-	m       map[peer.ID]uint64
+	m       map[peer.ID]float64
 	reverse bool
 }
 
@@ -0,0 +1,103 @@
+package api
+
+import "time"
+
+// PinsetChangeType tells whether a PinsetChange added or removed a pin.
+type PinsetChangeType string
+
+// PinsetChangeType values
+const (
+	PinsetChangeAdd    PinsetChangeType = "add"
+	PinsetChangeRemove PinsetChangeType = "remove"
+)
+
+// PinsetChange is a modification of the shared state as applied by a
+// cluster peer. Index is assigned by that peer and grows with every
+// change it applies, so it is only meaningful for the peer which
+// returned it and within the same epoch (see PinsetChanges).
+type PinsetChange struct {
+	Index uint64
+	Type  PinsetChangeType
+	Pin   Pin
+	Time  time.Time
+}
+
+// PinsetChanges is a batch of pinset changes following a given index.
+// LastIndex is the index of the last change applied by the peer. Epoch
+// identifies the log the indexes belong to: it changes every time the
+// peer starts, as indexes start from 0 again. When Resync is set, the
+// changes which were requested are no longer available (or the index is
+// unknown to the peer, i.e. after a restart): the whole pinset should be
+// read again and changes followed from LastIndex, within Epoch.
+type PinsetChanges struct {
+	Changes   []PinsetChange
+	Epoch     string
+	LastIndex uint64
+	Resync    bool
+}
+
+// PinsetChangeSerial is the serializable version of PinsetChange.
+type PinsetChangeSerial struct {
+	Index uint64           `json:"index"`
+	Type  PinsetChangeType `json:"type"`
+	Pin   PinSerial        `json:"pin"`
+	Time  string           `json:"time"`
+}
+
+// PinsetChangesSerial is the serializable version of PinsetChanges.
+type PinsetChangesSerial struct {
+	Changes   []PinsetChangeSerial `json:"changes"`
+	Epoch     string               `json:"epoch"`
+	LastIndex uint64               `json:"last_index"`
+	Resync    bool                 `json:"resync"`
+}
+
+// ToSerial converts a PinsetChanges to its serializable version.
+func (chs PinsetChanges) ToSerial() PinsetChangesSerial {
+	serial := PinsetChangesSerial{
+		Changes:   make([]PinsetChangeSerial, 0, len(chs.Changes)),
+		Epoch:     chs.Epoch,
+		LastIndex: chs.LastIndex,
+		Resync:    chs.Resync,
+	}
+	for _, ch := range chs.Changes {
+		serial.Changes = append(serial.Changes, PinsetChangeSerial{
+			Index: ch.Index,
+			Type:  ch.Type,
+			Pin:   ch.Pin.ToSerial(),
+			Time:  ch.Time.UTC().Format(time.RFC3339Nano),
+		})
+	}
+	return serial
+}
+
+// ToPinsetChanges converts a PinsetChangesSerial to its native version.
+func (chss PinsetChangesSerial) ToPinsetChanges() PinsetChanges {
+	chs := PinsetChanges{
+		Changes:   make([]PinsetChange, 0, len(chss.Changes)),
+		Epoch:     chss.Epoch,
+		LastIndex: chss.LastIndex,
+		Resync:    chss.Resync,
+	}
+	for _, chserial := range chss.Changes {
+		t, _ := time.Parse(time.RFC3339Nano, chserial.Time)
+		chs.Changes = append(chs.Changes, PinsetChange{
+			Index: chserial.Index,
+			Type:  chserial.Type,
+			Pin:   chserial.Pin.ToPin(),
+			Time:  t,
+		})
+	}
+	return chs
+}
+
+// PinsetChangesRequest asks for up to Limit pinset changes after the
+// Since index (all of them when Limit is not positive), waiting up to
+// Wait for new changes when there are none. When Epoch is set and does
+// not match the one of the peer, a resync is requested.
+type PinsetChangesRequest struct {
+	Epoch string
+	Since uint64
+	Limit int
+	Wait  time.Duration
+}
@@ -0,0 +1,174 @@
+package api
+
+import (
+	"sort"
+	"strings"
+)
+
+// PinsDiff reports the differences between two sets of pins, such as two
+// exports of the shared state taken before and after an upgrade.
+type PinsDiff struct {
+	Added   []Pin
+	Removed []Pin
+	Changed []PinChange
+}
+
+// PinChange is an item present in both sets of pins with different
+// options. Fields lists the options which differ.
+type PinChange struct {
+	Before Pin
+	After  Pin
+	Fields []string
+}
+
+// PinsDiffSerial is the serializable version of PinsDiff.
+type PinsDiffSerial struct {
+	Added   []PinSerial       `json:"added"`
+	Removed []PinSerial       `json:"removed"`
+	Changed []PinChangeSerial `json:"changed"`
+}
+
+// PinChangeSerial is the serializable version of PinChange.
+type PinChangeSerial struct {
+	Before PinSerial `json:"before"`
+	After  PinSerial `json:"after"`
+	Fields []string  `json:"fields"`
+}
+
+// ToSerial converts a PinsDiff to its serializable version.
+func (d PinsDiff) ToSerial() PinsDiffSerial {
+	serial := PinsDiffSerial{
+		Added:   make([]PinSerial, 0, len(d.Added)),
+		Removed: make([]PinSerial, 0, len(d.Removed)),
+		Changed: make([]PinChangeSerial, 0, len(d.Changed)),
+	}
+	for _, p := range d.Added {
+		serial.Added = append(serial.Added, p.ToSerial())
+	}
+	for _, p := range d.Removed {
+		serial.Removed = append(serial.Removed, p.ToSerial())
+	}
+	for _, ch := range d.Changed {
+		serial.Changed = append(serial.Changed, PinChangeSerial{
+			Before: ch.Before.ToSerial(),
+			After:  ch.After.ToSerial(),
+			Fields: ch.Fields,
+		})
+	}
+	return serial
+}
+
+// ToPinsDiff converts a PinsDiffSerial back to a PinsDiff.
+func (ds PinsDiffSerial) ToPinsDiff() PinsDiff {
+	d := PinsDiff{}
+	for _, p := range ds.Added {
+		d.Added = append(d.Added, p.ToPin())
+	}
+	for _, p := range ds.Removed {
+		d.Removed = append(d.Removed, p.ToPin())
+	}
+	for _, ch := range ds.Changed {
+		d.Changed = append(d.Changed, PinChange{
+			Before: ch.Before.ToPin(),
+			After:  ch.After.ToPin(),
+			Fields: ch.Fields,
+		})
+	}
+	return d
+}
+
+// Empty returns true when there are no differences.
+func (d PinsDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// DiffPins compares two sets of pins. Items are matched by their
+// canonical Cid, so that CIDv0 and CIDv1 forms of the same item are not
+// reported as added and removed. Results are sorted by Cid.
+func DiffPins(before, after []Pin) PinsDiff {
+	index := func(pins []Pin) (map[string]Pin, []string) {
+		m := make(map[string]Pin, len(pins))
+		keys := make([]string, 0, len(pins))
+		for _, p := range pins {
+			k := CanonicalCid(p.Cid).String()
+			if _, ok := m[k]; !ok {
+				keys = append(keys, k)
+			}
+			m[k] = p
+		}
+		sort.Strings(keys)
+		return m, keys
+	}
+
+	beforeMap, beforeKeys := index(before)
+	afterMap, afterKeys := index(after)
+
+	var d PinsDiff
+	for _, k := range beforeKeys {
+		b := beforeMap[k]
+		a, ok := afterMap[k]
+		if !ok {
+			d.Removed = append(d.Removed, b)
+			continue
+		}
+		if fields := changedPinFields(b, a); len(fields) > 0 {
+			d.Changed = append(d.Changed, PinChange{
+				Before: b,
+				After:  a,
+				Fields: fields,
+			})
+		}
+	}
+	for _, k := range afterKeys {
+		if _, ok := beforeMap[k]; !ok {
+			d.Added = append(d.Added, afterMap[k])
+		}
+	}
+	return d
+}
+
+// changedPinFields returns the names (as in PinSerial) of the options
+// which differ between two pins for the same item.
+func changedPinFields(p1, p2 Pin) []string {
+	s1 := p1.ToSerial()
+	s2 := p2.ToSerial()
+
+	var fields []string
+	if s1.Name != s2.Name {
+		fields = append(fields, "name")
+	}
+
+	sort.Strings(s1.Allocations)
+	sort.Strings(s2.Allocations)
+	if strings.Join(s1.Allocations, ",") != strings.Join(s2.Allocations, ",") {
+		fields = append(fields, "allocations")
+	}
+
+	if s1.ReplicationFactorMin != s2.ReplicationFactorMin {
+		fields = append(fields, "replication_factor_min")
+	}
+	if s1.ReplicationFactorMax != s2.ReplicationFactorMax {
+		fields = append(fields, "replication_factor_max")
+	}
+	if s1.Recursive != s2.Recursive {
+		fields = append(fields, "recursive")
+	}
+	if s1.Group != s2.Group {
+		fields = append(fields, "group")
+	}
+	if s1.Raw != s2.Raw {
+		fields = append(fields, "raw")
+	}
+
+	metaChanged := len(s1.Metadata) != len(s2.Metadata)
+	for k, v := range s1.Metadata {
+		if v2, ok := s2.Metadata[k]; !ok || v != v2 {
+			metaChanged = true
+			break
+		}
+	}
+	if metaChanged {
+		fields = append(fields, "metadata")
+	}
+	return fields
+}
@@ -0,0 +1,144 @@
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+// Endpoint groups which can be used as keys in Config.AccessControl.
+const (
+	// ACLGroupPins includes every modifying request outside
+	// the /peers endpoints (pin, unpin, sync, recover...).
+	ACLGroupPins = "pins"
+	// ACLGroupPeers includes every request to the /peers,
+	// /peerstore, /blacklist and /admin endpoints, and the
+	// requests which administer the whole cluster (secret
+	// rotation, shared configuration, pause and resume and
+	// state repair).
+	ACLGroupPeers = "peers"
+	// ACLGroupStatus includes every GET request outside the
+	// /peers endpoints (id, version, pin status, allocations...).
+	ACLGroupStatus = "status"
+)
+
+// Prefixes for the identities which can be used in
+// Config.AccessControl. ACLAnyone matches every request.
+const (
+	ACLUserPrefix = "user:"
+	ACLKeyPrefix  = "key:"
+	ACLCertPrefix = "cert:"
+	ACLPeerPrefix = "peer:"
+	ACLAnyone     = "*"
+)
+
+// aclGroup returns the endpoint group that a request with
+// the given method and route pattern belongs to.
+func aclGroup(method, pattern string) string {
+	switch {
+	case pattern == "/peers" || strings.HasPrefix(pattern, "/peers/"),
+		pattern == "/peerstore" || strings.HasPrefix(pattern, "/peerstore/"),
+		pattern == "/blacklist" || strings.HasPrefix(pattern, "/blacklist/"),
+		strings.HasPrefix(pattern, "/admin/"),
+		isAdminPattern(pattern):
+		return ACLGroupPeers
+	case method == "GET":
+		return ACLGroupStatus
+	default:
+		return ACLGroupPins
+	}
+}
+
+// isAdminPattern returns true for the routes outside /admin which
+// administer the whole cluster.
+func isAdminPattern(pattern string) bool {
+	switch pattern {
+	case "/secret/rotate", "/config/shared", "/pause", "/resume", "/health/state/repair":
+		return true
+	default:
+		return false
+	}
+}
+
+// validateACL checks that groups and identities in an
+// access control configuration are well formed.
+func validateACL(acl map[string][]string) error {
+	for group, ids := range acl {
+		switch group {
+		case ACLGroupPins, ACLGroupPeers, ACLGroupStatus:
+		default:
+			return fmt.Errorf("restapi.access_control: unknown endpoint group %q", group)
+		}
+
+		for _, id := range ids {
+			switch {
+			case id == ACLAnyone:
+			case strings.HasPrefix(id, ACLUserPrefix), strings.HasPrefix(id, ACLKeyPrefix),
+				strings.HasPrefix(id, ACLCertPrefix):
+			case strings.HasPrefix(id, ACLPeerPrefix):
+				_, err := peer.IDB58Decode(strings.TrimPrefix(id, ACLPeerPrefix))
+				if err != nil {
+					return fmt.Errorf("restapi.access_control: bad peer ID in %q: %s", id, err)
+				}
+			default:
+				return fmt.Errorf("restapi.access_control: unknown identity type in %q", id)
+			}
+		}
+	}
+	return nil
+}
+
+// requestIdentities returns the identities of the originator of a
+// request, in the form used by the access control configuration. The
+// basic auth username is only included when useBasicAuth is true, since
+// it is otherwise unverified.
+func requestIdentities(r *http.Request, useBasicAuth bool, apiKeys map[string]string) []string {
+	var ids []string
+	if useBasicAuth {
+		if user, _, ok := r.BasicAuth(); ok {
+			ids = append(ids, ACLUserPrefix+user)
+		}
+	}
+
+	if name := apiKeyClient(r, apiKeys); name != "" {
+		ids = append(ids, ACLKeyPrefix+name)
+	}
+
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		ids = append(ids, ACLCertPrefix+r.TLS.VerifiedChains[0][0].Subject.CommonName)
+	}
+
+	// Requests received through libp2p carry the
+	// peer ID of the sender as remote address.
+	if pid, err := peer.IDB58Decode(r.RemoteAddr); err == nil {
+		ids = append(ids, ACLPeerPrefix+peer.IDB58Encode(pid))
+	}
+	return ids
+}
+
+// accessControl wraps a handler so that it only runs when the originator
+// of the request is among the given allowed identities.
+func (api *API) accessControl(h http.HandlerFunc, group string, allowed []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ids := requestIdentities(r, api.config.BasicAuthCreds != nil, api.config.APIKeys)
+		for _, a := range allowed {
+			if a == ACLAnyone {
+				h.ServeHTTP(w, r)
+				return
+			}
+			for _, id := range ids {
+				if a == id {
+					h.ServeHTTP(w, r)
+					return
+				}
+			}
+		}
+		sendErrorResponse(
+			w,
+			http.StatusForbidden,
+			fmt.Sprintf("not authorized to use the %s endpoints", group),
+		)
+	}
+}
@@ -0,0 +1,207 @@
+package rest
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/test"
+
+	libp2p "github.com/libp2p/go-libp2p"
+	peer "github.com/libp2p/go-libp2p-peer"
+	peerstore "github.com/libp2p/go-libp2p-peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestACLGroup(t *testing.T) {
+	for _, r := range (&API{}).routes() {
+		g := aclGroup(r.Method, r.Pattern)
+		switch {
+		case strings.HasPrefix(r.Pattern, "/peers"), strings.HasPrefix(r.Pattern, "/blacklist"),
+			strings.HasPrefix(r.Pattern, "/admin/"), isAdminPattern(r.Pattern):
+			if g != ACLGroupPeers {
+				t.Errorf("%s should be in the peers group", r.Name)
+			}
+		case r.Method == "GET":
+			if g != ACLGroupStatus {
+				t.Errorf("%s should be in the status group", r.Name)
+			}
+		default:
+			if g != ACLGroupPins {
+				t.Errorf("%s should be in the pins group", r.Name)
+			}
+		}
+	}
+}
+
+func TestACLGroupAdmin(t *testing.T) {
+	for _, pattern := range []string{"/secret/rotate", "/config/shared", "/pause", "/resume", "/health/state/repair"} {
+		if g := aclGroup("POST", pattern); g != ACLGroupPeers {
+			t.Errorf("%s should be in the peers group, not in %s", pattern, g)
+		}
+	}
+}
+
+func TestValidateACL(t *testing.T) {
+	acl := map[string][]string{
+		ACLGroupPins:   {"user:alice", "cert:Bob"},
+		ACLGroupPeers:  {"peer:" + test.TestPeerID1.Pretty()},
+		ACLGroupStatus: {ACLAnyone},
+	}
+	if err := validateACL(acl); err != nil {
+		t.Error(err)
+	}
+
+	if validateACL(map[string][]string{"foo": {ACLAnyone}}) == nil {
+		t.Error("expected error with unknown group")
+	}
+	if validateACL(map[string][]string{ACLGroupPins: {"alice"}}) == nil {
+		t.Error("expected error with unknown identity type")
+	}
+	if validateACL(map[string][]string{ACLGroupPins: {"peer:abc"}}) == nil {
+		t.Error("expected error with bad peer ID")
+	}
+}
+
+func TestAPIAccessControl(t *testing.T) {
+	apiMAddr, _ := ma.NewMultiaddr("/ip4/127.0.0.1/tcp/0")
+	h, err := libp2p.New(context.Background(), libp2p.ListenAddrs(apiMAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientHost, err := libp2p.New(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientHost.Close()
+
+	cfg := &Config{}
+	cfg.Default()
+	cfg.HTTPListenAddr = apiMAddr
+	cfg.BasicAuthCreds = map[string]string{
+		"alice": "secret",
+		"bob":   "secret",
+	}
+	cfg.AccessControl = map[string][]string{
+		ACLGroupPins:  {"user:alice"},
+		ACLGroupPeers: {"peer:" + peer.IDB58Encode(clientHost.ID())},
+	}
+
+	rest, err := NewAPIWithHost(cfg, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rest.Shutdown()
+	rest.server.SetKeepAlivesEnabled(false)
+	rest.SetClient(test.NewMockRPCClient(t))
+
+	clientHost.Peerstore().AddAddrs(h.ID(), h.Addrs(), peerstore.PermanentAddrTTL)
+
+	c := httpClient(t, clientHost, false)
+	do := func(user, method, url string) int {
+		req, _ := http.NewRequest(method, url, nil)
+		req.SetBasicAuth(user, "secret")
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	pinPath := "/pins/" + test.TestCid1
+	if code := do("bob", "POST", httpURL(rest)+pinPath); code != http.StatusForbidden {
+		t.Errorf("bob should not be able to pin (%d)", code)
+	}
+	if code := do("alice", "POST", httpURL(rest)+pinPath); code != http.StatusAccepted {
+		t.Errorf("alice should be able to pin (%d)", code)
+	}
+	if code := do("bob", "GET", httpURL(rest)+"/id"); code != http.StatusOK {
+		t.Errorf("status endpoints should be open (%d)", code)
+	}
+	if code := do("bob", "GET", httpURL(rest)+"/peers"); code != http.StatusForbidden {
+		t.Errorf("peers endpoints should be restricted over http (%d)", code)
+	}
+	if code := do("bob", "GET", p2pURL(rest)+"/peers"); code != http.StatusOK {
+		t.Errorf("peers endpoints should be allowed to our peer (%d)", code)
+	}
+
+	var errResp api.Error
+	req, _ := http.NewRequest("DELETE", p2pURL(rest)+pinPath, nil)
+	req.SetBasicAuth("alice", "secret")
+	httpResp, err := c.Do(req)
+	processResp(t, httpResp, err, &errResp)
+	if errResp.Code != 0 {
+		t.Error("alice should be able to unpin over libp2p")
+	}
+}
+
+func TestAPIKeyAuthentication(t *testing.T) {
+	cfg := &Config{}
+	cfg.Default()
+	cfg.HTTPListenAddr, _ = ma.NewMultiaddr("/ip4/127.0.0.1/tcp/0")
+	cfg.BasicAuthCreds = map[string]string{
+		"alice": "secret",
+	}
+	cfg.APIKeys = map[string]string{
+		"ci": "ci-key",
+	}
+	cfg.AccessControl = map[string][]string{
+		ACLGroupPins: {"key:ci"},
+	}
+
+	rest, err := NewAPI(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rest.Shutdown()
+	rest.server.SetKeepAlivesEnabled(false)
+	rest.SetClient(test.NewMockRPCClient(t))
+
+	c := &http.Client{}
+	do := func(auth func(*http.Request), method, url string) int {
+		req, _ := http.NewRequest(method, url, nil)
+		if auth != nil {
+			auth(req)
+		}
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	bearer := func(key string) func(*http.Request) {
+		return func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer "+key)
+		}
+	}
+	alice := func(r *http.Request) {
+		r.SetBasicAuth("alice", "secret")
+	}
+
+	idURL := httpURL(rest) + "/id"
+	if code := do(nil, "GET", idURL); code != http.StatusUnauthorized {
+		t.Errorf("requests without credentials should be refused (%d)", code)
+	}
+	if code := do(bearer("bad-key"), "GET", idURL); code != http.StatusUnauthorized {
+		t.Errorf("requests with a bad api key should be refused (%d)", code)
+	}
+	if code := do(bearer("ci-key"), "GET", idURL); code != http.StatusOK {
+		t.Errorf("requests with a valid api key should be accepted (%d)", code)
+	}
+	if code := do(alice, "GET", idURL); code != http.StatusOK {
+		t.Errorf("basic auth should still be accepted (%d)", code)
+	}
+
+	pinURL := httpURL(rest) + "/pins/" + test.TestCid1
+	if code := do(alice, "POST", pinURL); code != http.StatusForbidden {
+		t.Errorf("alice should not be able to pin (%d)", code)
+	}
+	if code := do(bearer("ci-key"), "POST", pinURL); code != http.StatusAccepted {
+		t.Errorf("the ci key should be able to pin (%d)", code)
+	}
+}
@@ -33,11 +33,20 @@ type Config struct {
 	SSL bool
 	// Skip certificate verification (insecure)
 	NoVerifyCert bool
+	// Certificate and key files used to authenticate to APIs
+	// which require client certificates. Only valid with SSL.
+	ClientCertFile string
+	ClientKeyFile  string
 
 	// Username and password for basic authentication
 	Username string
 	Password string
 
+	// APIKey is sent as a bearer token to authenticate with APIs
+	// configured with api_keys. It is used instead of basic
+	// authentication when both are set.
+	APIKey string
+
 	// The ipfs-cluster REST API endpoint in multiaddress form
 	// (takes precedence over host:port). Only valid without PeerAddr.
 	APIAddr ma.Multiaddr
@@ -6,9 +6,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/url"
+	"strconv"
 	"time"
 
 	cid "github.com/ipfs/go-cid"
+	crypto "github.com/libp2p/go-libp2p-crypto"
 	peer "github.com/libp2p/go-libp2p-peer"
 	ma "github.com/multiformats/go-multiaddr"
 
@@ -22,7 +24,9 @@ func (c *Client) ID() (api.ID, error) {
 	return id.ToID(), err
 }
 
-// Peers requests ID information for all cluster peers.
+// Peers requests ID information for all cluster peers. The cluster may
+// answer with cached information (see api.ID.FetchedAt). Use QueryPeers
+// with Refresh to obtain fresh information.
 func (c *Client) Peers() ([]api.ID, error) {
 	var ids []api.IDSerial
 	err := c.do("GET", "/peers", nil, &ids)
@@ -33,6 +37,27 @@ func (c *Client) Peers() ([]api.ID, error) {
 	return result, err
 }
 
+// QueryPeers requests ID information for the cluster peers selected by
+// the given query. Peers are sorted by ID. When query.Local is set,
+// only the information known by the peer receiving the request
+// (addresses and last heartbeat) is returned. When query.Refresh is set,
+// every peer is asked for its ID instead of using cached information.
+func (c *Client) QueryPeers(query api.PeersQuery) ([]api.ID, error) {
+	q := url.Values{}
+	q.Set("offset", strconv.Itoa(query.Offset))
+	q.Set("limit", strconv.Itoa(query.Limit))
+	q.Set("local", strconv.FormatBool(query.Local))
+	q.Set("refresh", strconv.FormatBool(query.Refresh))
+
+	var ids []api.IDSerial
+	err := c.do("GET", "/peers?"+q.Encode(), nil, &ids)
+	result := make([]api.ID, len(ids))
+	for i, id := range ids {
+		result[i] = id.ToID()
+	}
+	return result, err
+}
+
 type peerAddBody struct {
 	Addr string `json:"peer_multiaddress"`
 }
@@ -51,9 +76,84 @@ func (c *Client) PeerAdd(addr ma.Multiaddr) (api.ID, error) {
 	return id.ToID(), err
 }
 
-// PeerRm removes a current peer from the cluster
-func (c *Client) PeerRm(id peer.ID) error {
-	return c.do("DELETE", fmt.Sprintf("/peers/%s", id.Pretty()), nil, nil)
+// PeerRm removes a current peer from the cluster. The removal is refused
+// when the remaining peers would not sustain a quorum, unless force is set.
+func (c *Client) PeerRm(id peer.ID, force bool) (api.PeerRemoval, error) {
+	var removal api.PeerRemoval
+	err := c.do("DELETE", fmt.Sprintf("/peers/%s?force=%t", id.Pretty(), force), nil, &removal)
+	return removal, err
+}
+
+type peerRotateBody struct {
+	NewPeerID string `json:"new_peer_id"`
+}
+
+type secretRotateBody struct {
+	Secret string `json:"secret"`
+}
+
+// PeerRotate replaces the ID of a cluster peer by a new one, re-allocating
+// its pins to the new ID, after the peer's private key has been changed.
+func (c *Client) PeerRotate(oldID, newID peer.ID) error {
+	body := peerRotateBody{newID.Pretty()}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.Encode(body)
+
+	return c.do("POST", fmt.Sprintf("/peers/%s/rotate", oldID.Pretty()), &buf, nil)
+}
+
+// TransferLeadership asks the current consensus leader to hand
+// leadership over to the given peer.
+func (c *Client) TransferLeadership(pid peer.ID) error {
+	return c.do("POST", fmt.Sprintf("/peers/%s/leader", pid.Pretty()), nil, nil)
+}
+
+// PeerstoreAddresses returns the addresses known by the peer for the
+// rest of cluster peers.
+func (c *Client) PeerstoreAddresses() ([]ma.Multiaddr, error) {
+	var addrs api.MultiaddrsSerial
+	err := c.do("GET", "/peerstore", nil, &addrs)
+	return addrs.ToMultiaddrs(), err
+}
+
+// PeerstoreAdd adds an address (including the /ipfs/<peerID> part) for
+// a cluster peer to the peerstore of the peer. The cluster peerset is not
+// modified.
+func (c *Client) PeerstoreAdd(addr ma.Multiaddr) error {
+	body := peerAddBody{addr.String()}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.Encode(body)
+
+	return c.do("POST", "/peerstore", &buf, nil)
+}
+
+// PeerstoreRm forgets the addresses of a cluster peer from the peerstore
+// of the peer, without removing it from the cluster.
+func (c *Client) PeerstoreRm(id peer.ID) error {
+	return c.do("DELETE", fmt.Sprintf("/peerstore/%s", id.Pretty()), nil, nil)
+}
+
+// PeerBlacklist returns the peers blacklisted by the peer.
+func (c *Client) PeerBlacklist() ([]peer.ID, error) {
+	var strs []string
+	err := c.do("GET", "/blacklist", nil, &strs)
+	return api.StringsToPeers(strs), err
+}
+
+// PeerBlacklistAdd blacklists a peer. The peer receiving the request
+// refuses to add it, to answer its requests and to include it in the
+// peerset, and removes it from the cluster if it is part of it.
+func (c *Client) PeerBlacklistAdd(id peer.ID) error {
+	return c.do("POST", fmt.Sprintf("/blacklist/%s", id.Pretty()), nil, nil)
+}
+
+// PeerBlacklistRm takes a peer out of the blacklist of the peer.
+func (c *Client) PeerBlacklistRm(id peer.ID) error {
+	return c.do("DELETE", fmt.Sprintf("/blacklist/%s", id.Pretty()), nil, nil)
 }
 
 // Pin tracks a Cid with the given replication factor and a name for
@@ -75,11 +175,175 @@ func (c *Client) Pin(ci *cid.Cid, replicationFactorMin, replicationFactorMax int
 	return err
 }
 
+// Prefetch asks the peers which would be allocated to a Cid with the
+// given replication factors to fetch it without pinning it. Nothing is
+// added to the cluster state. It returns the peers which started
+// fetching the content in the background.
+func (c *Client) Prefetch(ci *cid.Cid, replicationFactorMin, replicationFactorMax int) ([]peer.ID, error) {
+	var strs []string
+	err := c.do(
+		"POST",
+		fmt.Sprintf(
+			"/pins/%s/prefetch?replication_factor_min=%d&replication_factor_max=%d",
+			ci.String(),
+			replicationFactorMin,
+			replicationFactorMax,
+		),
+		nil,
+		&strs,
+	)
+	return api.StringsToPeers(strs), err
+}
+
+// PinsetChanges returns up to limit pinset changes (all of them when 0)
+// applied by the peer after the given index of the given epoch (any, when
+// empty). When there are none and wait is not 0, the peer waits up to that
+// time for new ones before answering. When the result has Resync set, the
+// pinset should be read again (i.e. with Allocations) and changes followed
+// from LastIndex in the returned Epoch.
+func (c *Client) PinsetChanges(epoch string, since uint64, limit int, wait time.Duration) (api.PinsetChanges, error) {
+	q := url.Values{}
+	if epoch != "" {
+		q.Set("epoch", epoch)
+	}
+	q.Set("since", strconv.FormatUint(since, 10))
+	q.Set("limit", strconv.Itoa(limit))
+	if wait > 0 {
+		q.Set("wait", wait.String())
+	}
+	var chs api.PinsetChangesSerial
+	err := c.do("GET", "/pins/changes?"+q.Encode(), nil, &chs)
+	return chs.ToPinsetChanges(), err
+}
+
+// PinWithMetadata works like Pin but attaches the given metadata
+// key-value pairs to the pin. They can be used to find the pin with
+// SearchPins.
+func (c *Client) PinWithMetadata(ci *cid.Cid, replicationFactorMin, replicationFactorMax int, name string, metadata map[string]string) error {
+	pin := pinWithOpts(ci, replicationFactorMin, replicationFactorMax, name)
+	pin.Metadata = metadata
+	_, err := c.PinWithOptions(pin)
+	return err
+}
+
+// PinInGroup works like Pin but restricts the allocations to the members
+// of the given peer group. A replication factor of -1 pins the Cid in all
+// the members of the group.
+func (c *Client) PinInGroup(ci *cid.Cid, replicationFactorMin, replicationFactorMax int, name, group string) error {
+	pin := pinWithOpts(ci, replicationFactorMin, replicationFactorMax, name)
+	pin.Group = group
+	_, err := c.PinWithOptions(pin)
+	return err
+}
+
+// PinRaw works like Pin but marks the content as raw blocks or IPLD
+// nodes which are not part of a UnixFS DAG (see api.Pin.Raw).
+func (c *Client) PinRaw(ci *cid.Cid, replicationFactorMin, replicationFactorMax int, name string) error {
+	pin := pinWithOpts(ci, replicationFactorMin, replicationFactorMax, name)
+	pin.Raw = true
+	_, err := c.PinWithOptions(pin)
+	return err
+}
+
+// PinWithOptions pins pin.Cid with the name, replication factors,
+// metadata, peer group and raw flag set in the given pin. Allocations
+// and any other fields are decided by the cluster, and the pin is always
+// recursive. When the pin is signed (see api.Pin.Sign), the signature is
+// sent along. It must have been made with Recursive set and without
+// Allocations, as the cluster checks it against the requested options.
+//
+// It returns the number of pin operations pending in the cluster when
+// it reports back pressure (see rest.BackPressureHeader), or 0.
+func (c *Client) PinWithOptions(pin api.Pin) (int, error) {
+	q := url.Values{}
+	q.Set("replication_factor_min", strconv.Itoa(pin.ReplicationFactorMin))
+	q.Set("replication_factor_max", strconv.Itoa(pin.ReplicationFactorMax))
+	q.Set("name", pin.Name)
+	if pin.Group != "" {
+		q.Set("group", pin.Group)
+	}
+	if pin.Raw {
+		q.Set("raw", "true")
+	}
+	for k, v := range pin.Metadata {
+		q.Set("meta-"+k, v)
+	}
+	if pin.Signer != nil {
+		ps := pin.ToSerial()
+		q.Set("signer", ps.Signer)
+		q.Set("signature", ps.Signature)
+		q.Set("nonce", ps.Nonce)
+		q.Set("expires", ps.Expires)
+	}
+
+	header, err := c.doHeader("POST", fmt.Sprintf("/pins/%s?%s", pin.Cid.String(), q.Encode()), nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	pending, _ := strconv.Atoi(header.Get(backPressureHeader))
+	return pending, nil
+}
+
+// pinWithOpts returns a recursive pin of the given Cid with the given
+// replication factors and name.
+func pinWithOpts(ci *cid.Cid, replicationFactorMin, replicationFactorMax int, name string) api.Pin {
+	return api.Pin{
+		Cid:                  ci,
+		Name:                 name,
+		ReplicationFactorMin: replicationFactorMin,
+		ReplicationFactorMax: replicationFactorMax,
+		Recursive:            true,
+	}
+}
+
 // Unpin untracks a Cid from cluster.
 func (c *Client) Unpin(ci *cid.Cid) error {
 	return c.do("DELETE", fmt.Sprintf("/pins/%s", ci.String()), nil, nil)
 }
 
+// PinSigned works like Pin but signs the request with the given key, as
+// required by clusters which only accept pins from authorized publishers.
+func (c *Client) PinSigned(ci *cid.Cid, replicationFactorMin, replicationFactorMax int, name string, key crypto.PrivKey) error {
+	pin, err := pinWithOpts(ci, replicationFactorMin, replicationFactorMax, name).Sign(api.PinOpPin, key)
+	if err != nil {
+		return err
+	}
+	_, err = c.PinWithOptions(pin)
+	return err
+}
+
+// UnpinSigned works like Unpin but signs the request with the given key.
+func (c *Client) UnpinSigned(ci *cid.Cid, key crypto.PrivKey) error {
+	pin, err := api.PinCid(ci).Sign(api.PinOpUnpin, key)
+	if err != nil {
+		return err
+	}
+	ps := pin.ToSerial()
+
+	return c.do(
+		"DELETE",
+		fmt.Sprintf(
+			"/pins/%s?%s",
+			ci.String(),
+			signatureQuery(ps),
+		),
+		nil,
+		nil,
+	)
+}
+
+// signatureQuery returns the query parameters which carry the signature
+// of a request.
+func signatureQuery(ps api.PinSerial) string {
+	return fmt.Sprintf(
+		"signer=%s&signature=%s&nonce=%s&expires=%s",
+		url.QueryEscape(ps.Signer),
+		url.QueryEscape(ps.Signature),
+		url.QueryEscape(ps.Nonce),
+		url.QueryEscape(ps.Expires),
+	)
+}
+
 // Allocations returns the consensus state listing all tracked items and
 // the peers that should be pinning them.
 func (c *Client) Allocations() ([]api.Pin, error) {
@@ -92,6 +356,48 @@ func (c *Client) Allocations() ([]api.Pin, error) {
 	return result, err
 }
 
+// SearchPins returns the pins in the shared state matching the given
+// query. Name and metadata values ending in "*" match by prefix.
+func (c *Client) SearchPins(query api.PinQuery) ([]api.Pin, error) {
+	var pins []api.PinSerial
+	err := c.do("GET", "/allocations?"+pinQueryValues(query).Encode(), nil, &pins)
+	result := make([]api.Pin, len(pins))
+	for i, p := range pins {
+		result[i] = p.ToPin()
+	}
+	return result, err
+}
+
+// UnpinMatching unpins all the pins in the shared state matching the
+// given query and returns them. The query must include a name or
+// metadata selector. When dryRun is true, the pins which would be
+// removed are returned but nothing is unpinned.
+func (c *Client) UnpinMatching(query api.PinQuery, dryRun bool) ([]api.Pin, error) {
+	q := pinQueryValues(query)
+	q.Set("dry_run", strconv.FormatBool(dryRun))
+
+	var pins []api.PinSerial
+	err := c.do("DELETE", "/pins?"+q.Encode(), nil, &pins)
+	result := make([]api.Pin, len(pins))
+	for i, p := range pins {
+		result[i] = p.ToPin()
+	}
+	return result, err
+}
+
+func pinQueryValues(query api.PinQuery) url.Values {
+	q := url.Values{}
+	if query.Name != "" {
+		q.Set("name", query.Name)
+	}
+	for k, v := range query.Metadata {
+		q.Set("meta-"+k, v)
+	}
+	q.Set("offset", strconv.Itoa(query.Offset))
+	q.Set("limit", strconv.Itoa(query.Limit))
+	return q
+}
+
 // Allocation returns the current allocations for a given Cid.
 func (c *Client) Allocation(ci *cid.Cid) (api.Pin, error) {
 	var pin api.PinSerial
@@ -108,6 +414,24 @@ func (c *Client) Status(ci *cid.Cid, local bool) (api.GlobalPinInfo, error) {
 	return gpi.ToGlobalPinInfo(), err
 }
 
+// PinDetail returns the status of a tracked Cid in every cluster peer along
+// with its entry in the shared state (allocations, replication factors,
+// metadata...). The Pin is nil when the Cid is not part of the shared state.
+func (c *Client) PinDetail(ci *cid.Cid) (api.PinDetail, error) {
+	var detail api.PinDetailSerial
+	err := c.do("GET", fmt.Sprintf("/pins/%s", ci.String()), nil, &detail)
+	return detail.ToPinDetail(), err
+}
+
+// DAGStats returns statistics about the DAG under a pinned Cid (number
+// of blocks, total size, depth and largest block), as obtained by one of
+// the peers it is allocated to.
+func (c *Client) DAGStats(ci *cid.Cid) (api.DAGStats, error) {
+	var stats api.DAGStatsSerial
+	err := c.do("GET", fmt.Sprintf("/pins/%s/dag", ci.String()), nil, &stats)
+	return stats.ToDAGStats(), err
+}
+
 // StatusAll gathers Status() for all tracked items.
 func (c *Client) StatusAll(local bool) ([]api.GlobalPinInfo, error) {
 	var gpis []api.GlobalPinInfoSerial
@@ -119,6 +443,87 @@ func (c *Client) StatusAll(local bool) ([]api.GlobalPinInfo, error) {
 	return result, err
 }
 
+// StatusShard returns the status of the tracked items which belong to
+// the given shard (see api.PinShard), so that the status of very large
+// pinsets can be fetched in chunks. If local is true, the status is
+// only fetched from the current peer.
+func (c *Client) StatusShard(shard api.PinShard, local bool) ([]api.GlobalPinInfo, error) {
+	var gpis []api.GlobalPinInfoSerial
+	err := c.do("GET", fmt.Sprintf("/pins?shard=%s&local=%t", shard, local), nil, &gpis)
+	result := make([]api.GlobalPinInfo, len(gpis))
+	for i, p := range gpis {
+		result[i] = p.ToGlobalPinInfo()
+	}
+	return result, err
+}
+
+// StatusSummary returns, for every cluster peer, how many items it
+// tracks in each status, without listing them. If local is true, only
+// the summary for the current peer is returned.
+func (c *Client) StatusSummary(local bool) ([]api.StatusSummary, error) {
+	var sums []api.StatusSummarySerial
+	err := c.do("GET", fmt.Sprintf("/pins/summary?local=%t", local), nil, &sums)
+	result := make([]api.StatusSummary, len(sums))
+	for i, s := range sums {
+		result[i] = s.ToStatusSummary()
+	}
+	return result, err
+}
+
+// FailureDomains returns, for every tracked item, the peers which have it
+// pinned grouped by the failure domain given by the value of the given
+// peer tag (i.e. "region").
+func (c *Client) FailureDomains(tag string) ([]api.FailureDomainInfo, error) {
+	var infos []api.FailureDomainInfoSerial
+	err := c.do("GET", "/pins/domains?tag="+url.QueryEscape(tag), nil, &infos)
+	result := make([]api.FailureDomainInfo, len(infos))
+	for i, info := range infos {
+		result[i] = info.ToFailureDomainInfo()
+	}
+	return result, err
+}
+
+// OrphanPins returns, for every cluster peer, the pins of its IPFS daemon
+// which are not part of the cluster state. When action is adopt or
+// remove, the orphan pins are also added to the cluster or unpinned from
+// the IPFS daemons. If local is true, only the current peer is checked.
+func (c *Client) OrphanPins(action api.OrphanAction, local bool) ([]api.OrphanPins, error) {
+	var res []api.OrphanPinsSerial
+	var err error
+	if action == api.OrphanActionNone {
+		err = c.do("GET", fmt.Sprintf("/pins/orphans?local=%t", local), nil, &res)
+	} else {
+		err = c.do("POST", fmt.Sprintf("/pins/orphans?action=%s&local=%t", action, local), nil, &res)
+	}
+	result := make([]api.OrphanPins, len(res))
+	for i, r := range res {
+		result[i] = r.ToOrphanPins()
+	}
+	return result, err
+}
+
+// StatusPartial works like Status but it only waits up to the given
+// timeout for cluster peers to answer. Peers which did not reply in time
+// are reported with the TrackerStatusTimedOut status.
+func (c *Client) StatusPartial(ci *cid.Cid, timeout time.Duration) (api.GlobalPinInfo, error) {
+	var gpi api.GlobalPinInfoSerial
+	err := c.do("GET", fmt.Sprintf("/pins/%s?timeout=%s", ci.String(), timeout), nil, &gpi)
+	return gpi.ToGlobalPinInfo(), err
+}
+
+// StatusAllPartial works like StatusAll but it only waits up to the given
+// timeout for cluster peers to answer. Peers which did not reply in time
+// are reported with the TrackerStatusTimedOut status.
+func (c *Client) StatusAllPartial(timeout time.Duration) ([]api.GlobalPinInfo, error) {
+	var gpis []api.GlobalPinInfoSerial
+	err := c.do("GET", fmt.Sprintf("/pins?timeout=%s", timeout), nil, &gpis)
+	result := make([]api.GlobalPinInfo, len(gpis))
+	for i, p := range gpis {
+		result[i] = p.ToGlobalPinInfo()
+	}
+	return result, err
+}
+
 // Sync makes sure the state of a Cid corresponds to the state reported by
 // the ipfs daemon, and returns it. If local is true, this operation only
 // happens on the current peer, otherwise it happens on every cluster peer.
@@ -164,6 +569,78 @@ func (c *Client) RecoverAll(local bool) ([]api.GlobalPinInfo, error) {
 	return result, err
 }
 
+// RecoverAllMatching triggers Recover() operations, in every peer, on the
+// tracked items whose status matches the given filter: "error" selects
+// every error status, while a status name (i.e. "pin_error") selects only
+// that one. At most concurrency items are recovered at the same time (0
+// lets the peer decide).
+func (c *Client) RecoverAllMatching(filter string, concurrency int) ([]api.GlobalPinInfo, error) {
+	var gpis []api.GlobalPinInfoSerial
+	path := fmt.Sprintf("/pins/recover?filter=%s&concurrency=%d", url.QueryEscape(filter), concurrency)
+	err := c.do("POST", path, nil, &gpis)
+	result := make([]api.GlobalPinInfo, len(gpis))
+	for i, p := range gpis {
+		result[i] = p.ToGlobalPinInfo()
+	}
+	return result, err
+}
+
+// RecoverAllAsync works like RecoverAllMatching, but it returns as soon as
+// the operation has started. The returned Job allows to follow its
+// progress and obtain its results (see Job()).
+func (c *Client) RecoverAllAsync(filter string, concurrency int) (api.Job, error) {
+	var job api.JobSerial
+	path := fmt.Sprintf("/pins/recover?async=true&filter=%s&concurrency=%d", url.QueryEscape(filter), concurrency)
+	err := c.do("POST", path, nil, &job)
+	return job.ToJob(), err
+}
+
+// SyncAllAsync works like SyncAll (in every peer), but it returns as soon
+// as the operation has started. The returned Job allows to follow its
+// progress and obtain its results (see Job()).
+func (c *Client) SyncAllAsync() (api.Job, error) {
+	var job api.JobSerial
+	err := c.do("POST", "/pins/sync?async=true", nil, &job)
+	return job.ToJob(), err
+}
+
+// StartJob starts the given job in the peer the client is connected to.
+// It allows to start jobs which have no synchronous counterpart, like
+// api.JobTypeRebalance and api.JobTypeImport.
+func (c *Client) StartJob(req api.JobRequest) (api.Job, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.Encode(req)
+
+	var job api.JobSerial
+	err := c.do("POST", "/jobs", &buf, &job)
+	return job.ToJob(), err
+}
+
+// Job returns a job started by the peer the client is connected to.
+func (c *Client) Job(id string) (api.Job, error) {
+	var job api.JobSerial
+	err := c.do("GET", fmt.Sprintf("/jobs/%s", id), nil, &job)
+	return job.ToJob(), err
+}
+
+// Jobs lists the jobs started by the peer the client is connected to,
+// newest first.
+func (c *Client) Jobs() ([]api.Job, error) {
+	var jobs []api.JobSerial
+	err := c.do("GET", "/jobs", nil, &jobs)
+	result := make([]api.Job, len(jobs))
+	for i, j := range jobs {
+		result[i] = j.ToJob()
+	}
+	return result, err
+}
+
+// CancelJob cancels a running job.
+func (c *Client) CancelJob(id string) error {
+	return c.do("DELETE", fmt.Sprintf("/jobs/%s", id), nil, nil)
+}
+
 // Version returns the ipfs-cluster peer's version.
 func (c *Client) Version() (api.Version, error) {
 	var ver api.Version
@@ -179,6 +656,166 @@ func (c *Client) GetConnectGraph() (api.ConnectGraphSerial, error) {
 	return graphS, err
 }
 
+// ConsensusState returns information about the consensus layer as seen
+// by the cluster peer: leader, term, last applied index and voters.
+func (c *Client) ConsensusState() (api.ConsensusState, error) {
+	var cs api.ConsensusStateSerial
+	err := c.do("GET", "/health/consensus", nil, &cs)
+	return cs.ToConsensusState(), err
+}
+
+// PeerHealth returns the health of every cluster peer as seen by the
+// peer monitor of the cluster peer: whether it is alive, its last
+// heartbeat and the latest values of its health metrics.
+func (c *Client) PeerHealth() ([]api.PeerHealth, error) {
+	var health []api.PeerHealthSerial
+	err := c.do("GET", "/health/peers", nil, &health)
+	result := make([]api.PeerHealth, len(health))
+	for i, ph := range health {
+		result[i] = ph.ToPeerHealth()
+	}
+	return result, err
+}
+
+// ResourceUsage returns the number of goroutines of the cluster peer and
+// the work handled by its subsystems (broadcasts, pin tracker, proxy).
+func (c *Client) ResourceUsage() (api.ResourceUsage, error) {
+	var usage api.ResourceUsage
+	err := c.do("GET", "/health/resources", nil, &usage)
+	return usage, err
+}
+
+// RPCStats returns the number of RPC requests that the cluster peer has
+// received from every other peer, for each method.
+func (c *Client) RPCStats() ([]api.RPCCallStats, error) {
+	var stats []api.RPCCallStatsSerial
+	err := c.do("GET", "/health/rpc", nil, &stats)
+	result := make([]api.RPCCallStats, len(stats))
+	for i, st := range stats {
+		result[i] = st.ToRPCCallStats()
+	}
+	return result, err
+}
+
+// Metrics returns the latest valid metrics of the given name (i.e.
+// "freespace" or "ping") received by the cluster peer from every peer.
+func (c *Client) Metrics(name string) ([]api.Metric, error) {
+	var metrics []api.MetricSerial
+	err := c.do("GET", fmt.Sprintf("/health/metrics/%s", name), nil, &metrics)
+	result := make([]api.Metric, len(metrics))
+	for i, m := range metrics {
+		result[i] = m.ToMetric()
+	}
+	return result, err
+}
+
+// RepairState asks the cluster peer to compare its shared state with the
+// leader's and to replace it with the leader's when they diverge.
+func (c *Client) RepairState() (api.StateRepair, error) {
+	var res api.StateRepair
+	err := c.do("POST", "/health/state/repair", nil, &res)
+	return res, err
+}
+
+// RotateSecret replaces the cluster secret in all cluster peers. The
+// secret is hex-encoded. When empty, a random one is generated. Peers
+// use the new secret after they are restarted.
+func (c *Client) RotateSecret(secret string) (api.SecretRotation, error) {
+	body := secretRotateBody{secret}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.Encode(body)
+
+	var res api.SecretRotationSerial
+	err := c.do("POST", "/secret/rotate", &buf, &res)
+	return res.ToSecretRotation(), err
+}
+
+// SharedConfig returns the cluster-wide configuration stored in the
+// shared state.
+func (c *Client) SharedConfig() (api.SharedConfig, error) {
+	var cfg api.SharedConfigSerial
+	err := c.do("GET", "/config/shared", nil, &cfg)
+	return cfg.ToSharedConfig(), err
+}
+
+// SetSharedConfig modifies the cluster-wide configuration stored in the
+// shared state, which all peers will then use. Only the given settings
+// (see api.SharedConfigFields) are taken from cfg. When none are given,
+// all of them are. Zero values make peers use their local configuration
+// for that setting.
+func (c *Client) SetSharedConfig(cfg api.SharedConfig, fields ...string) error {
+	if len(fields) == 0 {
+		fields = api.SharedConfigFields
+	}
+
+	var all map[string]interface{}
+	raw, err := json.Marshal(cfg.ToSerial())
+	if err != nil {
+		return err
+	}
+	json.Unmarshal(raw, &all)
+
+	body := make(map[string]interface{})
+	for _, f := range fields {
+		v, ok := all[f]
+		if !ok { // omitted zero value
+			v = sharedConfigZero(f)
+		}
+		body[f] = v
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.Encode(body)
+
+	return c.do("POST", "/config/shared", &buf, nil)
+}
+
+// sharedConfigZero returns the JSON zero value of a SharedConfig setting.
+func sharedConfigZero(field string) interface{} {
+	switch field {
+	case api.SharedConfigReplicationFactorMin, api.SharedConfigReplicationFactorMax:
+		return 0
+	case api.SharedConfigPaused:
+		return false
+	default:
+		return ""
+	}
+}
+
+// Faults returns the failure conditions simulated by the peer.
+func (c *Client) Faults() (api.Faults, error) {
+	var faults api.FaultsSerial
+	err := c.do("GET", "/admin/faults", nil, &faults)
+	return faults.ToFaults(), err
+}
+
+// InjectFaults makes the peer simulate the given failure conditions,
+// replacing any previous ones. Empty Faults clear them. The peer must
+// have fault injection enabled in its configuration.
+func (c *Client) InjectFaults(faults api.Faults) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.Encode(faults.ToSerial())
+
+	return c.do("POST", "/admin/faults", &buf, nil)
+}
+
+// Pause makes all cluster peers stop performing IPFS pin and unpin
+// operations until Resume is called. Pins and unpins are still added
+// to the shared state.
+func (c *Client) Pause() error {
+	return c.do("POST", "/pause", nil, nil)
+}
+
+// Resume lets all cluster peers perform IPFS pin
+// and unpin operations again after Pause.
+func (c *Client) Resume() error {
+	return c.do("POST", "/resume", nil, nil)
+}
+
 // WaitFor is a utility function that allows for a caller to
 // wait for a paticular status for a CID. It returns a channel
 // upon which the caller can wait for the targetStatus.
@@ -80,6 +80,26 @@ func TestPeers(t *testing.T) {
 	testClients(t, api, testF)
 }
 
+func TestQueryPeers(t *testing.T) {
+	tapi := testAPI(t)
+	defer shutdown(tapi)
+
+	testF := func(t *testing.T, c *Client) {
+		ids, err := c.QueryPeers(api.PeersQuery{Limit: 2, Local: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ids) != 2 {
+			t.Fatal("expected 2 peers")
+		}
+		if ids[0].LastHeartbeat.IsZero() {
+			t.Error("expected a last heartbeat")
+		}
+	}
+
+	testClients(t, tapi, testF)
+}
+
 func TestPeersWithError(t *testing.T) {
 	api := testAPI(t)
 	defer shutdown(api)
@@ -117,20 +137,132 @@ func TestPeerAdd(t *testing.T) {
 	testClients(t, api, testF)
 }
 
+func TestPeerBlacklist(t *testing.T) {
+	api := testAPI(t)
+	defer shutdown(api)
+
+	testF := func(t *testing.T, c *Client) {
+		peers, err := c.PeerBlacklist()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(peers) != 1 || peers[0] != test.TestPeerID3 {
+			t.Fatal("expected 1 blacklisted peer")
+		}
+
+		err = c.PeerBlacklistAdd(test.TestPeerID2)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = c.PeerBlacklistRm(test.TestPeerID3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = c.PeerBlacklistRm(test.TestPeerID2)
+		if err == nil {
+			t.Error("expected an error with a peer which is not blacklisted")
+		}
+	}
+
+	testClients(t, api, testF)
+}
+
+func TestPeerstore(t *testing.T) {
+	api := testAPI(t)
+	defer shutdown(api)
+
+	testF := func(t *testing.T, c *Client) {
+		addrs, err := c.PeerstoreAddresses()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(addrs) != 1 {
+			t.Fatal("expected 1 address")
+		}
+
+		addr, _ := ma.NewMultiaddr("/ip4/1.2.3.4/tcp/1234/ipfs/" + test.TestPeerID2.Pretty())
+		err = c.PeerstoreAdd(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = c.PeerstoreRm(test.TestPeerID2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = c.PeerstoreRm(test.TestPeerID3)
+		if err == nil {
+			t.Error("expected an error with a peer outside the cluster")
+		}
+	}
+
+	testClients(t, api, testF)
+}
+
 func TestPeerRm(t *testing.T) {
 	api := testAPI(t)
 	defer shutdown(api)
 
 	testF := func(t *testing.T, c *Client) {
-		err := c.PeerRm(test.TestPeerID1)
+		_, err := c.PeerRm(test.TestPeerID1, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = c.PeerRm(test.TestPeerID3, false)
+		if err == nil {
+			t.Error("expected an error when the removal breaks quorum")
+		}
+
+		removal, err := c.PeerRm(test.TestPeerID3, true)
+		if err != nil {
+			t.Error(err)
+		}
+		if len(removal.Warnings) != 1 {
+			t.Error("expected a removal warning")
+		}
+	}
+
+	testClients(t, api, testF)
+}
+
+func TestPeerRotate(t *testing.T) {
+	api := testAPI(t)
+	defer shutdown(api)
+
+	testF := func(t *testing.T, c *Client) {
+		err := c.PeerRotate(test.TestPeerID1, test.TestPeerID4)
 		if err != nil {
 			t.Fatal(err)
 		}
+		err = c.PeerRotate(test.TestPeerID2, test.TestPeerID4)
+		if err == nil {
+			t.Error("expected an error")
+		}
 	}
 
 	testClients(t, api, testF)
 }
 
+func TestTransferLeadership(t *testing.T) {
+	tapi := testAPI(t)
+	defer shutdown(tapi)
+
+	testF := func(t *testing.T, c *Client) {
+		err := c.TransferLeadership(test.TestPeerID2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = c.TransferLeadership(test.TestPeerID4)
+		if err == nil {
+			t.Error("expected an error")
+		}
+	}
+
+	testClients(t, tapi, testF)
+}
+
 func TestPin(t *testing.T) {
 	api := testAPI(t)
 	defer shutdown(api)
@@ -146,6 +278,169 @@ func TestPin(t *testing.T) {
 	testClients(t, api, testF)
 }
 
+func TestPinInGroup(t *testing.T) {
+	tapi := testAPI(t)
+	defer shutdown(tapi)
+
+	testF := func(t *testing.T, c *Client) {
+		ci, _ := cid.Decode(test.TestCid1)
+		err := c.PinInGroup(ci, -1, -1, "hello", "ssd-tier")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testClients(t, tapi, testF)
+}
+
+func TestPinRaw(t *testing.T) {
+	tapi := testAPI(t)
+	defer shutdown(tapi)
+
+	testF := func(t *testing.T, c *Client) {
+		ci, _ := cid.Decode(test.TestCid1)
+		err := c.PinRaw(ci, 0, 0, "hello")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testClients(t, tapi, testF)
+}
+
+func TestPinWithOptions(t *testing.T) {
+	tapi := testAPI(t)
+	defer shutdown(tapi)
+
+	testF := func(t *testing.T, c *Client) {
+		ci, _ := cid.Decode(test.TestCid1)
+		pending, err := c.PinWithOptions(api.Pin{
+			Cid:                  ci,
+			Name:                 "hello",
+			ReplicationFactorMin: 1,
+			ReplicationFactorMax: 2,
+			Metadata:             map[string]string{"owner": "alice"},
+			Raw:                  true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pending != 1500 {
+			t.Errorf("expected the reported back-pressure, got %d", pending)
+		}
+
+		ci2, _ := cid.Decode(test.ErrorCid)
+		_, err = c.PinWithOptions(api.PinCid(ci2))
+		if err == nil {
+			t.Error("expected an error")
+		}
+	}
+
+	testClients(t, tapi, testF)
+}
+
+func TestPinDetail(t *testing.T) {
+	tapi := testAPI(t)
+	defer shutdown(tapi)
+
+	testF := func(t *testing.T, c *Client) {
+		ci, _ := cid.Decode(test.TestCid1)
+		detail, err := c.PinDetail(ci)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !detail.Cid.Equals(ci) || len(detail.PeerMap) == 0 {
+			t.Error("expected the status of the pin")
+		}
+		if detail.Pin == nil || !detail.Pin.Cid.Equals(ci) {
+			t.Error("expected the shared state entry of the pin")
+		}
+	}
+
+	testClients(t, tapi, testF)
+}
+
+func TestDAGStats(t *testing.T) {
+	tapi := testAPI(t)
+	defer shutdown(tapi)
+
+	testF := func(t *testing.T, c *Client) {
+		ci, _ := cid.Decode(test.TestCid1)
+		stats, err := c.DAGStats(ci)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !stats.Cid.Equals(ci) || stats.Peer != test.TestPeerID1 {
+			t.Error("unexpected cid or peer")
+		}
+		if stats.Blocks != 3 || stats.TotalSize != 500 || stats.Depth != 3 {
+			t.Error("unexpected DAG stats")
+		}
+	}
+
+	testClients(t, tapi, testF)
+}
+
+func TestPrefetch(t *testing.T) {
+	api := testAPI(t)
+	defer shutdown(api)
+
+	testF := func(t *testing.T, c *Client) {
+		ci, _ := cid.Decode(test.TestCid1)
+		peers, err := c.Prefetch(ci, 2, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(peers) != 2 || peers[0] != test.TestPeerID1 {
+			t.Error("expected the peers which started prefetching")
+		}
+
+		ci2, _ := cid.Decode(test.ErrorCid)
+		_, err = c.Prefetch(ci2, 0, 0)
+		if err == nil {
+			t.Error("expected an error")
+		}
+	}
+
+	testClients(t, api, testF)
+}
+
+func TestPinsetChanges(t *testing.T) {
+	tapi := testAPI(t)
+	defer shutdown(tapi)
+
+	testF := func(t *testing.T, c *Client) {
+		chs, err := c.PinsetChanges("", 0, 0, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if chs.Resync || chs.LastIndex != 3 || len(chs.Changes) != 3 {
+			t.Fatalf("unexpected changes: %+v", chs)
+		}
+		if chs.Changes[2].Type != api.PinsetChangeRemove || chs.Changes[2].Pin.Cid.String() != test.TestCid1 {
+			t.Errorf("unexpected change: %+v", chs.Changes[2])
+		}
+
+		chs, err = c.PinsetChanges("", 10, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !chs.Resync {
+			t.Error("expected a resync")
+		}
+
+		chs, err = c.PinsetChanges("other", 1, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !chs.Resync || chs.Epoch != "mock" {
+			t.Error("expected a resync for a different epoch")
+		}
+	}
+
+	testClients(t, tapi, testF)
+}
+
 func TestUnpin(t *testing.T) {
 	api := testAPI(t)
 	defer shutdown(api)
@@ -232,6 +527,49 @@ func TestStatusAll(t *testing.T) {
 	testClients(t, api, testF)
 }
 
+func TestStatusShard(t *testing.T) {
+	tapi := testAPI(t)
+	defer shutdown(tapi)
+
+	testF := func(t *testing.T, c *Client) {
+		pins, err := c.StatusShard(api.PinShard{Index: 0, Count: 1}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pins) != 3 {
+			t.Error("the only shard should have all the items")
+		}
+	}
+
+	testClients(t, tapi, testF)
+}
+
+func TestStatusPartial(t *testing.T) {
+	api := testAPI(t)
+	defer shutdown(api)
+
+	testF := func(t *testing.T, c *Client) {
+		ci, _ := cid.Decode(test.TestCid1)
+		pin, err := c.StatusPartial(ci, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pin.Cid.String() != test.TestCid1 {
+			t.Error("should be same pin")
+		}
+
+		pins, err := c.StatusAllPartial(time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pins) == 0 {
+			t.Error("there should be some pins")
+		}
+	}
+
+	testClients(t, api, testF)
+}
+
 func TestSync(t *testing.T) {
 	api := testAPI(t)
 	defer shutdown(api)
@@ -295,13 +633,87 @@ func TestRecoverAll(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
+
+		gpis, err := c.RecoverAllMatching("error", 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(gpis) != 3 {
+			t.Error("expected 3 recovered items")
+		}
+
+		_, err = c.RecoverAllMatching("bad", 0)
+		if err == nil {
+			t.Error("expected an error with an invalid filter")
+		}
 	}
 
 	testClients(t, api, testF)
 }
 
-func TestGetConnectGraph(t *testing.T) {
-	api := testAPI(t)
+func TestJobs(t *testing.T) {
+	tapi := testAPI(t)
+	defer shutdown(tapi)
+
+	testF := func(t *testing.T, c *Client) {
+		job, err := c.RecoverAllAsync("error", 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.ID != test.TestJobID {
+			t.Error("unexpected job id")
+		}
+
+		_, err = c.SyncAllAsync()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		job, err = c.StartJob(api.JobRequest{Type: api.JobTypeRebalance})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Type != api.JobTypeRebalance {
+			t.Error("expected a rebalance job")
+		}
+
+		_, err = c.StartJob(api.JobRequest{Type: api.JobTypeImport})
+		if err == nil {
+			t.Error("expected an error importing no pins")
+		}
+
+		job, err = c.Job(test.TestJobID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Finished.IsZero() || len(job.Result) != 3 {
+			t.Error("expected a finished job with results")
+		}
+
+		jobs, err := c.Jobs()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(jobs) != 1 {
+			t.Error("expected 1 job")
+		}
+
+		err = c.CancelJob(test.TestJobID)
+		if err != nil {
+			t.Error(err)
+		}
+
+		_, err = c.Job("abc")
+		if err == nil {
+			t.Error("expected an error for an unknown job")
+		}
+	}
+
+	testClients(t, tapi, testF)
+}
+
+func TestGetConnectGraph(t *testing.T) {
+	api := testAPI(t)
 	defer shutdown(api)
 
 	testF := func(t *testing.T, c *Client) {
@@ -318,6 +730,314 @@ func TestGetConnectGraph(t *testing.T) {
 	testClients(t, api, testF)
 }
 
+func TestConsensusState(t *testing.T) {
+	api := testAPI(t)
+	defer shutdown(api)
+
+	testF := func(t *testing.T, c *Client) {
+		cs, err := c.ConsensusState()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cs.Leader != test.TestPeerID1 || !cs.HasQuorum || len(cs.Voters) != 3 {
+			t.Error("bad consensus state")
+		}
+	}
+
+	testClients(t, api, testF)
+}
+
+func TestPeerHealth(t *testing.T) {
+	api := testAPI(t)
+	defer shutdown(api)
+
+	testF := func(t *testing.T, c *Client) {
+		health, err := c.PeerHealth()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(health) != 2 {
+			t.Fatal("expected 2 entries")
+		}
+		if health[0].Peer != test.TestPeerID1 || !health[0].Alive || health[0].LastHeartbeat.IsZero() {
+			t.Error("bad health for the first peer")
+		}
+		if health[1].Alive {
+			t.Error("the second peer should not be alive")
+		}
+	}
+
+	testClients(t, api, testF)
+}
+
+func TestResourceUsage(t *testing.T) {
+	api := testAPI(t)
+	defer shutdown(api)
+
+	testF := func(t *testing.T, c *Client) {
+		usage, err := c.ResourceUsage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if usage.Goroutines != 120 || len(usage.Components) != 3 {
+			t.Error("bad resource usage")
+		}
+		if usage.Components[2].Component != "proxy" || usage.Components[2].Rejected != 3 {
+			t.Error("bad proxy usage")
+		}
+	}
+
+	testClients(t, api, testF)
+}
+
+func TestRepairState(t *testing.T) {
+	api := testAPI(t)
+	defer shutdown(api)
+
+	testF := func(t *testing.T, c *Client) {
+		res, err := c.RepairState()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.Diverged || res.Local.Checksum == res.Authoritative.Checksum {
+			t.Error("bad state repair result")
+		}
+	}
+
+	testClients(t, api, testF)
+}
+
+func TestRPCStats(t *testing.T) {
+	api := testAPI(t)
+	defer shutdown(api)
+
+	testF := func(t *testing.T, c *Client) {
+		stats, err := c.RPCStats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(stats) != 2 || stats[1].Method != "Cluster.Pin" || stats[1].Last.IsZero() {
+			t.Error("bad rpc stats")
+		}
+	}
+
+	testClients(t, api, testF)
+}
+
+func TestMetrics(t *testing.T) {
+	api := testAPI(t)
+	defer shutdown(api)
+
+	testF := func(t *testing.T, c *Client) {
+		metrics, err := c.Metrics("ping")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(metrics) != 1 || metrics[0].Name != "ping" || metrics[0].Peer != test.TestPeerID1 {
+			t.Error("bad metrics")
+		}
+	}
+
+	testClients(t, api, testF)
+}
+
+func TestStatusSummary(t *testing.T) {
+	tapi := testAPI(t)
+	defer shutdown(tapi)
+
+	testF := func(t *testing.T, c *Client) {
+		sums, err := c.StatusSummary(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sums) != 2 || sums[0].Peer != test.TestPeerID1 {
+			t.Fatal("bad status summary")
+		}
+		if sums[0].Counts[api.TrackerStatusPinned] != 2 {
+			t.Error("bad pinned count")
+		}
+	}
+
+	testClients(t, tapi, testF)
+}
+
+func TestFailureDomains(t *testing.T) {
+	tapi := testAPI(t)
+	defer shutdown(tapi)
+
+	testF := func(t *testing.T, c *Client) {
+		infos, err := c.FailureDomains("region")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(infos) != 1 || infos[0].Cid.String() != test.TestCid1 {
+			t.Fatal("bad failure domains")
+		}
+		if !infos[0].Concentrated || infos[0].Domains["eu-west"][1] != test.TestPeerID2 {
+			t.Error("bad failure domain info")
+		}
+	}
+
+	testClients(t, tapi, testF)
+}
+
+func TestPauseResume(t *testing.T) {
+	tapi := testAPI(t)
+	defer shutdown(tapi)
+
+	testF := func(t *testing.T, c *Client) {
+		err := c.Pause()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = c.Resume()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testClients(t, tapi, testF)
+}
+
+func TestOrphanPins(t *testing.T) {
+	tapi := testAPI(t)
+	defer shutdown(tapi)
+
+	testF := func(t *testing.T, c *Client) {
+		res, err := c.OrphanPins(api.OrphanActionNone, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res) != 2 || res[0].Peer != test.TestPeerID1 {
+			t.Fatal("bad orphan pins")
+		}
+		if len(res[0].Orphans) != 1 || res[0].Orphans[0].String() != test.TestCid3 {
+			t.Error("unexpected orphans")
+		}
+
+		res, err = c.OrphanPins(api.OrphanActionRemove, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res) != 1 || res[0].Action != api.OrphanActionRemove {
+			t.Error("expected orphans to be removed")
+		}
+	}
+
+	testClients(t, tapi, testF)
+}
+
+func TestSearchPins(t *testing.T) {
+	tapi := testAPI(t)
+	defer shutdown(tapi)
+
+	testF := func(t *testing.T, c *Client) {
+		pins, err := c.SearchPins(api.PinQuery{
+			Name:     "backup-2017-*",
+			Metadata: map[string]string{"tenant": "a"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pins) != 1 || pins[0].Cid.String() != test.TestCid1 {
+			t.Error("unexpected search results")
+		}
+	}
+
+	testClients(t, tapi, testF)
+}
+
+func TestUnpinMatching(t *testing.T) {
+	tapi := testAPI(t)
+	defer shutdown(tapi)
+
+	testF := func(t *testing.T, c *Client) {
+		pins, err := c.UnpinMatching(api.PinQuery{
+			Metadata: map[string]string{"tenant": "b"},
+		}, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pins) != 1 || pins[0].Cid.String() != test.TestCid2 {
+			t.Error("unexpected unpinned items")
+		}
+
+		_, err = c.UnpinMatching(api.PinQuery{}, false)
+		if err == nil {
+			t.Error("expected an error without a selector")
+		}
+	}
+
+	testClients(t, tapi, testF)
+}
+
+func TestSharedConfig(t *testing.T) {
+	tapi := testAPI(t)
+	defer shutdown(tapi)
+
+	testF := func(t *testing.T, c *Client) {
+		cfg, err := c.SharedConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.ReplicationFactorMax != 3 || cfg.StateSyncInterval != time.Minute {
+			t.Error("unexpected shared config")
+		}
+
+		cfg.Allocator = "descend"
+		err = c.SetSharedConfig(cfg)
+		if err != nil {
+			t.Error(err)
+		}
+
+		err = c.SetSharedConfig(api.SharedConfig{}, api.SharedConfigAllocator)
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	testClients(t, tapi, testF)
+}
+
+func TestFaults(t *testing.T) {
+	tapi := testAPI(t)
+	defer shutdown(tapi)
+
+	testF := func(t *testing.T, c *Client) {
+		faults, err := c.Faults()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !faults.IPFSErrors || faults.Duration != 10*time.Minute {
+			t.Error("unexpected faults")
+		}
+
+		err = c.InjectFaults(api.Faults{DropMetrics: true, Duration: time.Minute})
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	testClients(t, tapi, testF)
+}
+
+func TestRotateSecret(t *testing.T) {
+	api := testAPI(t)
+	defer shutdown(api)
+
+	testF := func(t *testing.T, c *Client) {
+		res, err := c.RotateSecret("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Updated) != 2 || res.Failed[test.TestPeerID3] == "" {
+			t.Error("bad secret rotation result")
+		}
+	}
+
+	testClients(t, api, testF)
+}
+
 type waitService struct {
 	l        sync.Mutex
 	pinStart time.Time
@@ -10,12 +10,22 @@ import (
 	"github.com/ipfs/ipfs-cluster/api"
 )
 
+// backPressureHeader is the header used by the REST API to report the
+// number of pending pin operations (rest.BackPressureHeader).
+const backPressureHeader = "X-Back-Pressure"
+
 func (c *Client) do(method, path string, body io.Reader, obj interface{}) error {
+	_, err := c.doHeader(method, path, body, obj)
+	return err
+}
+
+// doHeader works like do but also returns the headers of the response.
+func (c *Client) doHeader(method, path string, body io.Reader, obj interface{}) (http.Header, error) {
 	resp, err := c.doRequest(method, path, body)
 	if err != nil {
-		return &api.Error{Code: 0, Message: err.Error()}
+		return nil, &api.Error{Code: 0, Message: err.Error()}
 	}
-	return c.handleResponse(resp, obj)
+	return resp.Header, c.handleResponse(resp, obj)
 }
 
 func (c *Client) doRequest(method, path string, body io.Reader) (*http.Response, error) {
@@ -30,7 +40,10 @@ func (c *Client) doRequest(method, path string, body io.Reader) (*http.Response,
 		r.Close = true
 	}
 
-	if c.config.Username != "" {
+	switch {
+	case c.config.APIKey != "":
+		r.Header.Set("Authorization", "Bearer "+c.config.APIKey)
+	case c.config.Username != "":
 		r.SetBasicAuth(c.config.Username, c.config.Password)
 	}
 
@@ -49,6 +62,17 @@ func (c *Client) handleResponse(resp *http.Response, obj interface{}) error {
 	switch {
 	case resp.StatusCode == http.StatusAccepted:
 		logger.Debug("Request accepted")
+		// Some accepted requests describe the started operation
+		// (i.e. jobs).
+		if len(body) > 0 && obj != nil {
+			err = json.Unmarshal(body, obj)
+			if err != nil {
+				return &api.Error{
+					Code:    resp.StatusCode,
+					Message: err.Error(),
+				}
+			}
+		}
 	case resp.StatusCode == http.StatusNoContent:
 		logger.Debug("Request suceeded. Response has no content")
 	default:
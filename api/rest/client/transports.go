@@ -95,6 +95,14 @@ func (c *Client) enableTLS() error {
 		},
 		InsecureSkipVerify: c.config.NoVerifyCert,
 	}
+
+	if c.config.ClientCertFile != "" || c.config.ClientKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.config.ClientCertFile, c.config.ClientKeyFile)
+		if err != nil {
+			return errors.New("error loading client certificate/key: " + err.Error())
+		}
+		c.transport.TLSClientConfig.Certificates = []tls.Certificate{cert}
+	}
 	c.net = "https"
 	return nil
 }
@@ -2,10 +2,12 @@ package rest
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"path/filepath"
 	"time"
 
@@ -27,6 +29,14 @@ const (
 	DefaultIdleTimeout       = 120 * time.Second
 )
 
+// Permission scopes which can be granted to client certificates
+// in ClientCertScopes. Read allows GET requests. Write allows
+// any other method.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+)
+
 // Config is used to intialize the API object and allows to
 // customize the behaviour of it. It implements the config.ComponentConfig
 // interface.
@@ -36,6 +46,13 @@ type Config struct {
 	// Listen address for the HTTP REST API endpoint.
 	HTTPListenAddr ma.Multiaddr
 
+	// Optional listen address for an HTTP endpoint which only serves
+	// read-only (GET) requests, except DAG stats, which are too
+	// expensive to be exposed there. This
+	// allows, for example, to bind HTTPListenAddr to localhost while
+	// exposing reads to the local network.
+	HTTPReadOnlyListenAddr ma.Multiaddr
+
 	// TLS configuration for the HTTP listener
 	TLS *tls.Config
 
@@ -47,6 +64,27 @@ type Config struct {
 	// SSLKeyFile. We track it so we can write it in the JSON.
 	pathSSLKeyFile string
 
+	// pathClientCAFile is a path to a file with the PEM-encoded
+	// certificates of the authorities that sign client certificates.
+	// When set, clients connecting to the HTTP endpoint must present a
+	// valid certificate signed by one of them.
+	pathClientCAFile string
+
+	// ClientCertScopes maps the subject common name of client
+	// certificates to the permission scopes (ScopeRead, ScopeWrite)
+	// granted to them. When nil, any valid client certificate has
+	// full access. Otherwise, requests without a certificate (i.e. those
+	// arriving through libp2p) or from unlisted subjects are rejected.
+	ClientCertScopes map[string][]string
+
+	// AccessControl maps endpoint groups (ACLGroupPins, ACLGroupPeers,
+	// ACLGroupStatus) to the identities allowed to use them. Identities
+	// are basic auth usernames ("user:<name>"), client certificate
+	// subjects ("cert:<common name>"), libp2p peer IDs
+	// ("peer:<peer ID>") or "*" for anyone. Groups which are not
+	// listed can be used by anyone who is authenticated.
+	AccessControl map[string][]string
+
 	// Maximum duration before timing out reading a full request
 	ReadTimeout time.Duration
 
@@ -71,6 +109,12 @@ type Config struct {
 	// BasicAuthCreds is a map of username-password pairs
 	// which are authorized to use Basic Authentication
 	BasicAuthCreds map[string]string
+
+	// APIKeys is a map of client names and the API keys that they
+	// can use to authenticate, by sending an "Authorization: Bearer
+	// <key>" header, as an alternative to Basic Authentication. Client
+	// names can be used in AccessControl with the "key:" prefix.
+	APIKeys map[string]string
 }
 
 type jsonConfig struct {
@@ -78,16 +122,22 @@ type jsonConfig struct {
 	HTTPListenMultiaddress string `json:"http_listen_multiaddress"`
 	SSLCertFile            string `json:"ssl_cert_file,omitempty"`
 	SSLKeyFile             string `json:"ssl_key_file,omitempty"`
+	ClientCAFile           string `json:"client_ca_file,omitempty"`
 	ReadTimeout            string `json:"read_timeout"`
 	ReadHeaderTimeout      string `json:"read_header_timeout"`
 	WriteTimeout           string `json:"write_timeout"`
 	IdleTimeout            string `json:"idle_timeout"`
 
+	HTTPReadOnlyListenMultiaddress string `json:"http_read_only_listen_multiaddress,omitempty"`
+
 	Libp2pListenMultiaddress string `json:"libp2p_listen_multiaddress,omitempty"`
 	ID                       string `json:"id,omitempty"`
 	PrivateKey               string `json:"private_key,omitempty"`
 
-	BasicAuthCreds map[string]string `json:"basic_auth_credentials"`
+	BasicAuthCreds   map[string]string   `json:"basic_auth_credentials"`
+	APIKeys          map[string]string   `json:"api_keys,omitempty"`
+	ClientCertScopes map[string][]string `json:"client_cert_scopes,omitempty"`
+	AccessControl    map[string][]string `json:"access_control,omitempty"`
 }
 
 // ConfigKey returns a human-friendly identifier for this type of
@@ -101,8 +151,10 @@ func (cfg *Config) Default() error {
 	// http
 	httpListen, _ := ma.NewMultiaddr(DefaultHTTPListenAddr)
 	cfg.HTTPListenAddr = httpListen
+	cfg.HTTPReadOnlyListenAddr = nil
 	cfg.pathSSLCertFile = ""
 	cfg.pathSSLKeyFile = ""
+	cfg.pathClientCAFile = ""
 	cfg.ReadTimeout = DefaultReadTimeout
 	cfg.ReadHeaderTimeout = DefaultReadHeaderTimeout
 	cfg.WriteTimeout = DefaultWriteTimeout
@@ -115,6 +167,9 @@ func (cfg *Config) Default() error {
 
 	// Auth
 	cfg.BasicAuthCreds = nil
+	cfg.APIKeys = nil
+	cfg.ClientCertScopes = nil
+	cfg.AccessControl = nil
 
 	return nil
 }
@@ -135,11 +190,61 @@ func (cfg *Config) Validate() error {
 		return errors.New("restapi.basic_auth_creds should be null or have at least one entry")
 	case (cfg.pathSSLCertFile != "" || cfg.pathSSLKeyFile != "") && cfg.TLS == nil:
 		return errors.New("missing TLS configuration")
+	case cfg.pathClientCAFile != "" && (cfg.TLS == nil || cfg.TLS.ClientCAs == nil):
+		return errors.New("restapi.client_ca_file requires a TLS configuration")
+	case cfg.ClientCertScopes != nil && cfg.pathClientCAFile == "":
+		return errors.New("restapi.client_cert_scopes requires restapi.client_ca_file")
+	}
+
+	err := cfg.validateAPIKeys()
+	if err != nil {
+		return err
+	}
+
+	err = cfg.validateScopes()
+	if err != nil {
+		return err
+	}
+
+	err = validateACL(cfg.AccessControl)
+	if err != nil {
+		return err
 	}
 
 	return cfg.validateLibp2p()
 }
 
+func (cfg *Config) validateAPIKeys() error {
+	if cfg.APIKeys == nil {
+		return nil
+	}
+	if len(cfg.APIKeys) == 0 {
+		return errors.New("restapi.api_keys should be null or have at least one entry")
+	}
+	seen := make(map[string]string)
+	for name, key := range cfg.APIKeys {
+		if key == "" {
+			return fmt.Errorf("restapi.api_keys: empty key for %q", name)
+		}
+		if other, ok := seen[key]; ok {
+			return fmt.Errorf("restapi.api_keys: %q and %q have the same key", other, name)
+		}
+		seen[key] = name
+	}
+	return nil
+}
+
+func (cfg *Config) validateScopes() error {
+	for subject, scopes := range cfg.ClientCertScopes {
+		for _, s := range scopes {
+			if s != ScopeRead && s != ScopeWrite {
+				return fmt.Errorf("restapi.client_cert_scopes: unknown scope %q for %q", s, subject)
+			}
+		}
+	}
+	return nil
+}
+
 func (cfg *Config) validateLibp2p() error {
 	if cfg.ID != "" || cfg.PrivateKey != nil || cfg.Libp2pListenAddr != nil {
 		// if one is set, all should be
@@ -177,6 +282,9 @@ func (cfg *Config) LoadJSON(raw []byte) error {
 
 	// Other options
 	cfg.BasicAuthCreds = jcfg.BasicAuthCreds
+	cfg.APIKeys = jcfg.APIKeys
+	cfg.ClientCertScopes = jcfg.ClientCertScopes
+	cfg.AccessControl = jcfg.AccessControl
 
 	return cfg.Validate()
 }
@@ -200,6 +308,15 @@ func (cfg *Config) loadHTTPOptions(jcfg *jsonConfig) error {
 		cfg.HTTPListenAddr = httpAddr
 	}
 
+	if l := jcfg.HTTPReadOnlyListenMultiaddress; l != "" {
+		readOnlyAddr, err := ma.NewMultiaddr(l)
+		if err != nil {
+			err = fmt.Errorf("error parsing restapi.http_read_only_listen_multiaddress: %s", err)
+			return err
+		}
+		cfg.HTTPReadOnlyListenAddr = readOnlyAddr
+	}
+
 	err := cfg.tlsOptions(jcfg)
 	if err != nil {
 		return err
@@ -241,6 +358,17 @@ func (cfg *Config) tlsOptions(jcfg *jsonConfig) error {
 		return err
 	}
 	cfg.TLS = tlsCfg
+
+	if ca := jcfg.ClientCAFile; ca != "" {
+		cfg.pathClientCAFile = ca
+		if !filepath.IsAbs(ca) {
+			ca = filepath.Join(cfg.BaseDir, ca)
+		}
+		err = requireClientCerts(cfg.TLS, ca)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -290,11 +418,15 @@ func (cfg *Config) ToJSON() (raw []byte, err error) {
 		HTTPListenMultiaddress: cfg.HTTPListenAddr.String(),
 		SSLCertFile:            cfg.pathSSLCertFile,
 		SSLKeyFile:             cfg.pathSSLKeyFile,
+		ClientCAFile:           cfg.pathClientCAFile,
 		ReadTimeout:            cfg.ReadTimeout.String(),
 		ReadHeaderTimeout:      cfg.ReadHeaderTimeout.String(),
 		WriteTimeout:           cfg.WriteTimeout.String(),
 		IdleTimeout:            cfg.IdleTimeout.String(),
 		BasicAuthCreds:         cfg.BasicAuthCreds,
+		APIKeys:                cfg.APIKeys,
+		ClientCertScopes:       cfg.ClientCertScopes,
+		AccessControl:          cfg.AccessControl,
 	}
 
 	if cfg.ID != "" {
@@ -310,6 +442,9 @@ func (cfg *Config) ToJSON() (raw []byte, err error) {
 	if cfg.Libp2pListenAddr != nil {
 		jcfg.Libp2pListenMultiaddress = cfg.Libp2pListenAddr.String()
 	}
+	if cfg.HTTPReadOnlyListenAddr != nil {
+		jcfg.HTTPReadOnlyListenMultiaddress = cfg.HTTPReadOnlyListenAddr.String()
+	}
 
 	raw, err = config.DefaultJSONMarshal(jcfg)
 	return
@@ -334,3 +469,19 @@ func newTLSConfig(certFile, keyFile string) (*tls.Config, error) {
 		Certificates: []tls.Certificate{cert},
 	}, nil
 }
+
+// requireClientCerts modifies a TLS configuration so that clients must
+// present a certificate signed by one of the authorities in caFile.
+func requireClientCerts(tlsCfg *tls.Config, caFile string) error {
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return errors.New("Error loading client CA certificates: " + err.Error())
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return errors.New("no valid certificates found in " + caFile)
+	}
+	tlsCfg.ClientCAs = pool
+	tlsCfg.ClientAuth = tls.RequireAndVerifyClientCert
+	return nil
+}
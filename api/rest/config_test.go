@@ -1,6 +1,7 @@
 package rest
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"testing"
 	"time"
@@ -47,6 +48,26 @@ func TestLoadJSON(t *testing.T) {
 		t.Error("expected error decoding listen multiaddress")
 	}
 
+	j = &jsonConfig{}
+	json.Unmarshal(cfgJSON, j)
+	j.HTTPReadOnlyListenMultiaddress = "/ip4/0.0.0.0/tcp/9095"
+	tst, _ = json.Marshal(j)
+	err = cfg.LoadJSON(tst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.HTTPReadOnlyListenAddr == nil ||
+		cfg.HTTPReadOnlyListenAddr.String() != "/ip4/0.0.0.0/tcp/9095" {
+		t.Error("expected the read-only listen address")
+	}
+
+	j.HTTPReadOnlyListenMultiaddress = "abc"
+	tst, _ = json.Marshal(j)
+	err = cfg.LoadJSON(tst)
+	if err == nil {
+		t.Error("expected error decoding read-only listen multiaddress")
+	}
+
 	j = &jsonConfig{}
 	json.Unmarshal(cfgJSON, j)
 	j.ReadTimeout = "-1"
@@ -65,6 +86,24 @@ func TestLoadJSON(t *testing.T) {
 		t.Error("expected error with empty basic auth map")
 	}
 
+	j = &jsonConfig{}
+	json.Unmarshal(cfgJSON, j)
+	j.APIKeys = map[string]string{}
+	tst, _ = json.Marshal(j)
+	err = cfg.LoadJSON(tst)
+	if err == nil {
+		t.Error("expected error with empty api keys map")
+	}
+
+	j = &jsonConfig{}
+	json.Unmarshal(cfgJSON, j)
+	j.APIKeys = map[string]string{"ci": "abc", "backup": "abc"}
+	tst, _ = json.Marshal(j)
+	err = cfg.LoadJSON(tst)
+	if err == nil {
+		t.Error("expected error with a repeated api key")
+	}
+
 	j = &jsonConfig{}
 	json.Unmarshal(cfgJSON, j)
 	j.SSLCertFile = "abc"
@@ -186,3 +225,37 @@ func TestDefault(t *testing.T) {
 		t.Fatal("expected error validating")
 	}
 }
+
+func TestLoadJSONClientCerts(t *testing.T) {
+	j := &jsonConfig{}
+	json.Unmarshal(cfgJSON, j)
+	j.ClientCAFile = "test/server.crt"
+	j.ClientCertScopes = map[string][]string{
+		"Bob": {ScopeRead, ScopeWrite},
+	}
+	tst, _ := json.Marshal(j)
+
+	cfg := &Config{}
+	err := cfg.LoadJSON(tst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.TLS.ClientCAs == nil || cfg.TLS.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Error("client certificates should be required")
+	}
+
+	j.ClientCertScopes["Bob"] = []string{"admin"}
+	tst, _ = json.Marshal(j)
+	err = cfg.LoadJSON(tst)
+	if err == nil {
+		t.Error("expected error with unknown scope")
+	}
+
+	j.ClientCAFile = ""
+	j.ClientCertScopes["Bob"] = []string{ScopeRead}
+	tst, _ = json.Marshal(j)
+	err = cfg.LoadJSON(tst)
+	if err == nil {
+		t.Error("expected error with scopes but no client CA")
+	}
+}
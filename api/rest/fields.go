@@ -0,0 +1,145 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// GET requests accept a "fields" query parameter to obtain only some
+// of the fields of the returned objects, so that clients (i.e. UIs) do
+// not need to fetch whole responses. It is a comma-separated list of
+// JSON keys, where nested keys are separated by dots. When the response
+// is a list, the selection applies to every element. For example:
+//
+//   GET /allocations?fields=cid,name,allocations
+//   GET /id?fields=id,addresses,ipfs.id
+//
+// Selection only trims the response of a single endpoint. Clients which
+// need several resources (i.e. pins and the peers they are allocated to)
+// still make one request per resource.
+
+// fieldSelection is a tree of the selected JSON keys. A nil
+// fieldSelection for a key selects the whole value.
+type fieldSelection map[string]fieldSelection
+
+// parseFields builds a fieldSelection from the value of the "fields"
+// query parameter.
+func parseFields(fields string) fieldSelection {
+	sel := make(fieldSelection)
+	for _, f := range strings.Split(fields, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		cur := sel
+		keys := strings.Split(f, ".")
+		for i, k := range keys {
+			sub, ok := cur[k]
+			if ok && sub == nil { // whole value already selected
+				break
+			}
+			if i == len(keys)-1 {
+				cur[k] = nil
+				break
+			}
+			if !ok {
+				sub = make(fieldSelection)
+				cur[k] = sub
+			}
+			cur = sub
+		}
+	}
+	return sel
+}
+
+// apply returns the selected fields of a decoded JSON value.
+func (sel fieldSelection) apply(v interface{}) interface{} {
+	switch val := v.(type) {
+	case []interface{}:
+		out := make([]interface{}, len(val), len(val))
+		for i, e := range val {
+			out[i] = sel.apply(e)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{})
+		for k, sub := range sel {
+			fv, ok := val[k]
+			if !ok {
+				continue
+			}
+			if sub == nil {
+				out[k] = fv
+				continue
+			}
+			out[k] = sub.apply(fv)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// bufferedResponse is an http.ResponseWriter which keeps the
+// response in memory.
+type bufferedResponse struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func (br *bufferedResponse) Header() http.Header {
+	return br.header
+}
+
+func (br *bufferedResponse) Write(b []byte) (int, error) {
+	return br.body.Write(b)
+}
+
+func (br *bufferedResponse) WriteHeader(code int) {
+	br.code = code
+}
+
+// selectFields wraps a handler so that, when the request has a "fields"
+// query parameter, only the selected fields of successful JSON responses
+// are sent.
+func selectFields(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fields := r.URL.Query().Get("fields")
+		if fields == "" {
+			h(w, r)
+			return
+		}
+
+		br := &bufferedResponse{
+			header: make(http.Header),
+			code:   http.StatusOK,
+		}
+		h(br, r)
+
+		var v interface{}
+		dec := json.NewDecoder(bytes.NewReader(br.body.Bytes()))
+		dec.UseNumber()
+		if br.code >= 300 || dec.Decode(&v) != nil {
+			copyHeader(w.Header(), br.header)
+			w.WriteHeader(br.code)
+			w.Write(br.body.Bytes())
+			return
+		}
+
+		br.header.Del("Content-Type")
+		br.header.Del("Content-Length")
+		copyHeader(w.Header(), br.header)
+		sendJSONResponse(w, br.code, parseFields(fields).apply(v))
+	}
+}
+
+func copyHeader(dst, src http.Header) {
+	for k, vs := range src {
+		for _, v := range vs {
+			dst.Add(k, v)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package rest
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestFieldSelection(t *testing.T) {
+	var v interface{}
+	err := json.Unmarshal([]byte(`[
+  {"cid": "a", "name": "x", "peer": {"id": "p", "addresses": ["m"]}},
+  {"cid": "b", "name": "y", "peer": {"id": "q"}}
+]`), &v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, _ := json.Marshal(parseFields("cid, peer.id,missing").apply(v))
+	expected := `[{"cid":"a","peer":{"id":"p"}},{"cid":"b","peer":{"id":"q"}}]`
+	if string(out) != expected {
+		t.Errorf("unexpected selection: %s", out)
+	}
+
+	out, _ = json.Marshal(parseFields("peer.id,peer,name").apply(v))
+	expected = `[{"name":"x","peer":{"addresses":["m"],"id":"p"}},{"name":"y","peer":{"id":"q"}}]`
+	if string(out) != expected {
+		t.Errorf("selecting a whole object should include all its fields: %s", out)
+	}
+}
@@ -0,0 +1,56 @@
+package rest
+
+import (
+	"net"
+	"net/http"
+)
+
+// readOnlyListener wraps the listener of the read-only HTTP endpoint
+// (Config.HTTPReadOnlyListenAddr) so that the connections it accepts can
+// be told apart by the router, which refuses mutating requests on them.
+type readOnlyListener struct {
+	net.Listener
+}
+
+func (l readOnlyListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return readOnlyConn{c}, nil
+}
+
+// readOnlyConn marks its local address as belonging to the read-only
+// endpoint. The HTTP server exposes it in the request context
+// (http.LocalAddrContextKey).
+type readOnlyConn struct {
+	net.Conn
+}
+
+func (c readOnlyConn) LocalAddr() net.Addr {
+	return readOnlyAddr{c.Conn.LocalAddr()}
+}
+
+type readOnlyAddr struct {
+	net.Addr
+}
+
+// fromReadOnlyEndpoint returns true when the request arrived through the
+// read-only HTTP endpoint.
+func fromReadOnlyEndpoint(r *http.Request) bool {
+	_, ok := r.Context().Value(http.LocalAddrContextKey).(readOnlyAddr)
+	return ok
+}
+
+// refuseOnReadOnlyEndpoint wraps the handler of a mutating route (or of
+// one not served on the read-only endpoint) so that it answers with 403 Forbidden when called through the read-only HTTP
+// endpoint.
+func refuseOnReadOnlyEndpoint(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if fromReadOnlyEndpoint(r) {
+			sendErrorResponse(w, http.StatusForbidden, "this endpoint only serves read-only requests")
+			return
+		}
+		h(w, r)
+	}
+}
@@ -9,17 +9,23 @@ package rest
 
 import (
 	"context"
+	"crypto/subtle"
 	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	types "github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/logutil"
 
 	mux "github.com/gorilla/mux"
 	rpc "github.com/hsanjuan/go-libp2p-gorpc"
@@ -36,6 +42,21 @@ import (
 
 var logger = logging.Logger("restapi")
 
+// Query parameters starting with this prefix set pin metadata
+// (i.e. "meta-tenant=abc") or filter by it when searching pins.
+const metadataQueryPrefix = "meta-"
+
+// RequestIDHeader carries the ID of pin and unpin requests, which can be
+// used to follow them in the logs of all peers. Clients may provide their
+// own. Otherwise, one is generated and returned in the response.
+const RequestIDHeader = "X-Request-ID"
+
+// BackPressureHeader is set in the responses to pin requests when the
+// cluster has more pending pin operations than it should. Its value is
+// the number of pending operations. The pin is committed regardless, but
+// clients should slow down.
+const BackPressureHeader = "X-Back-Pressure"
+
 // Common errors
 var (
 	// ErrNoEndpointEnabled is returned when the API is created but
@@ -55,6 +76,7 @@ type API struct {
 	cancel func()
 
 	config *Config
+	logger *logutil.Logger
 
 	rpcClient *rpc.Client
 	rpcReady  chan struct{}
@@ -63,8 +85,12 @@ type API struct {
 	server *http.Server
 	host   host.Host
 
-	httpListener   net.Listener
-	libp2pListener net.Listener
+	httpListener         net.Listener
+	readOnlyHTTPListener net.Listener
+	libp2pListener       net.Listener
+
+	drainLock sync.RWMutex
+	draining  bool
 
 	shutdownLock sync.Mutex
 	shutdown     bool
@@ -82,6 +108,14 @@ type peerAddBody struct {
 	PeerMultiaddr string `json:"peer_multiaddress"`
 }
 
+type peerRotateBody struct {
+	NewPeerID string `json:"new_peer_id"`
+}
+
+type secretRotateBody struct {
+	Secret string `json:"secret"`
+}
+
 // NewAPI creates a new REST API component with the given configuration.
 func NewAPI(cfg *Config) (*API, error) {
 	return NewAPIWithHost(cfg, nil)
@@ -111,9 +145,10 @@ func NewAPIWithHost(cfg *Config, h host.Host) (*API, error) {
 		ctx:      ctx,
 		cancel:   cancel,
 		config:   cfg,
+		logger:   logutil.New(logger),
 		server:   s,
 		host:     h,
-		rpcReady: make(chan struct{}, 2),
+		rpcReady: make(chan struct{}, 3),
 	}
 	api.addRoutes(router)
 
@@ -129,7 +164,7 @@ func NewAPIWithHost(cfg *Config, h host.Host) (*API, error) {
 		return nil, err
 	}
 
-	if api.httpListener == nil && api.libp2pListener == nil {
+	if api.httpListener == nil && api.readOnlyHTTPListener == nil && api.libp2pListener == nil {
 		return nil, ErrNoEndpointsEnabled
 	}
 
@@ -138,26 +173,46 @@ func NewAPIWithHost(cfg *Config, h host.Host) (*API, error) {
 }
 
 func (api *API) setupHTTP() error {
-	if api.config.HTTPListenAddr == nil {
-		return nil
+	if api.config.HTTPListenAddr != nil {
+		l, err := api.listenHTTP(api.config.HTTPListenAddr, false)
+		if err != nil {
+			return err
+		}
+		api.httpListener = l
 	}
 
-	n, addr, err := manet.DialArgs(api.config.HTTPListenAddr)
-	if err != nil {
-		return err
+	if api.config.HTTPReadOnlyListenAddr != nil {
+		l, err := api.listenHTTP(api.config.HTTPReadOnlyListenAddr, true)
+		if err != nil {
+			if api.httpListener != nil {
+				api.httpListener.Close()
+			}
+			return err
+		}
+		api.readOnlyHTTPListener = l
 	}
+	return nil
+}
 
-	var l net.Listener
-	if api.config.TLS != nil {
-		l, err = tls.Listen(n, addr, api.config.TLS)
-	} else {
-		l, err = net.Listen(n, addr)
+func (api *API) listenHTTP(listenAddr ma.Multiaddr, readOnly bool) (net.Listener, error) {
+	n, addr, err := manet.DialArgs(listenAddr)
+	if err != nil {
+		return nil, err
 	}
+
+	l, err := net.Listen(n, addr)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	api.httpListener = l
-	return nil
+	// TLS wraps the read-only marking so that the server still
+	// sees *tls.Conn connections.
+	if readOnly {
+		l = readOnlyListener{l}
+	}
+	if api.config.TLS != nil {
+		l = tls.NewListener(l, api.config.TLS)
+	}
+	return l, nil
 }
 
 func (api *API) setupLibp2p(ctx context.Context) error {
@@ -198,6 +253,16 @@ func (api *API) HTTPAddress() (string, error) {
 	return api.httpListener.Addr().String(), nil
 }
 
+// ReadOnlyHTTPAddress returns the listening address of the read-only
+// HTTP(s) endpoint in host:port format. Returns error when it is not
+// enabled.
+func (api *API) ReadOnlyHTTPAddress() (string, error) {
+	if api.readOnlyHTTPListener == nil {
+		return "", ErrHTTPEndpointNotEnabled
+	}
+	return api.readOnlyHTTPListener.Addr().String(), nil
+}
+
 // Host returns the libp2p Host used by the API, if any.
 // The result is either the host provided during initialization,
 // a default Host created with options from the configuration object,
@@ -208,8 +273,25 @@ func (api *API) Host() host.Host {
 
 func (api *API) addRoutes(router *mux.Router) {
 	for _, route := range api.routes() {
-		if api.config.BasicAuthCreds != nil {
-			route.HandlerFunc = basicAuth(route.HandlerFunc, api.config.BasicAuthCreds)
+		readOnly := isReadOnly(route)
+		if readOnly {
+			route.HandlerFunc = selectFields(route.HandlerFunc)
+			if !servedOnReadOnlyEndpoint(route) {
+				route.HandlerFunc = refuseOnReadOnlyEndpoint(route.HandlerFunc)
+			}
+		} else {
+			route.HandlerFunc = api.refuseWhenDraining(route.HandlerFunc)
+			route.HandlerFunc = refuseOnReadOnlyEndpoint(route.HandlerFunc)
+		}
+		group := aclGroup(route.Method, route.Pattern)
+		if allowed, ok := api.config.AccessControl[group]; ok {
+			route.HandlerFunc = api.accessControl(route.HandlerFunc, group, allowed)
+		}
+		if api.config.BasicAuthCreds != nil || api.config.APIKeys != nil {
+			route.HandlerFunc = authenticate(route.HandlerFunc, api.config.BasicAuthCreds, api.config.APIKeys)
+		}
+		if api.config.ClientCertScopes != nil {
+			route.HandlerFunc = clientCertAuth(route.HandlerFunc, api.config.ClientCertScopes, readOnly)
 		}
 		router.
 			Methods(route.Method).
@@ -220,27 +302,73 @@ func (api *API) addRoutes(router *mux.Router) {
 	api.router = router
 }
 
-func basicAuth(h http.HandlerFunc, credentials map[string]string) http.HandlerFunc {
+// refuseWhenDraining wraps a handler so that it answers with
+// 503 Service Unavailable while the API is being drained.
+func (api *API) refuseWhenDraining(h http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
-		username, password, ok := r.BasicAuth()
-		if !ok {
-			resp, err := unauthorizedResp()
-			if err != nil {
-				logger.Error(err)
-				return
-			}
-			http.Error(w, resp, 401)
+		api.drainLock.RLock()
+		draining := api.draining
+		api.drainLock.RUnlock()
+		if draining {
+			sendErrorResponse(w, http.StatusServiceUnavailable, "the cluster peer is shutting down")
+			return
+		}
+		h(w, r)
+	}
+}
+
+// isReadOnly returns true for routes which do not modify anything
+// (GET requests).
+func isReadOnly(r route) bool {
+	return r.Method == "GET"
+}
+
+// servedOnReadOnlyEndpoint returns false for read-only routes which are
+// nevertheless too expensive to be exposed on the read-only HTTP
+// endpoint, like DAGStats, which walks whole DAGs on the IPFS daemon.
+func servedOnReadOnlyEndpoint(r route) bool {
+	return isReadOnly(r) && r.Name != "DAGStats"
+}
+
+// clientCertAuth wraps a handler so that it only runs when the subject
+// of the verified client certificate has been granted the scope needed
+// for the route (read for read-only routes, write otherwise).
+func clientCertAuth(h http.HandlerFunc, scopes map[string][]string, readOnly bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			sendErrorResponse(w, http.StatusForbidden, "a valid client certificate is required")
 			return
 		}
 
-		authorized := false
-		for u, p := range credentials {
-			if u == username && p == password {
-				authorized = true
+		needed := ScopeWrite
+		if readOnly {
+			needed = ScopeRead
+		}
+
+		subject := r.TLS.VerifiedChains[0][0].Subject.CommonName
+		for _, s := range scopes[subject] {
+			if s == needed {
+				h.ServeHTTP(w, r)
+				return
 			}
 		}
-		if !authorized {
+		sendErrorResponse(
+			w,
+			http.StatusForbidden,
+			fmt.Sprintf("%s does not have %s permissions", subject, needed),
+		)
+	}
+}
+
+// authenticate wraps a handler so that it only runs for requests with
+// valid Basic Authentication credentials or a valid API key.
+func authenticate(h http.HandlerFunc, credentials, apiKeys map[string]string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if credentials != nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
+		}
+
+		if !validBasicAuth(r, credentials) && apiKeyClient(r, apiKeys) == "" {
 			resp, err := unauthorizedResp()
 			if err != nil {
 				logger.Error(err)
@@ -253,6 +381,34 @@ func basicAuth(h http.HandlerFunc, credentials map[string]string) http.HandlerFu
 	}
 }
 
+// validBasicAuth returns true when the request carries Basic
+// Authentication credentials which are among the given ones.
+func validBasicAuth(r *http.Request, credentials map[string]string) bool {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	p, ok := credentials[username]
+	return ok && subtle.ConstantTimeCompare([]byte(p), []byte(password)) == 1
+}
+
+// apiKeyClient returns the name of the client owning the API key sent in
+// the Authorization header of the request, or an empty string when there
+// is none or it is not among the given ones.
+func apiKeyClient(r *http.Request, apiKeys map[string]string) string {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return ""
+	}
+	key := strings.TrimPrefix(auth, "Bearer ")
+	for name, k := range apiKeys {
+		if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+			return name
+		}
+	}
+	return ""
+}
+
 func unauthorizedResp() (string, error) {
 	apiError := types.Error{
 		Code:    401,
@@ -296,6 +452,54 @@ func (api *API) routes() []route {
 			"/peers/{peer}",
 			api.peerRemoveHandler,
 		},
+		{
+			"PeerRotate",
+			"POST",
+			"/peers/{peer}/rotate",
+			api.peerRotateHandler,
+		},
+		{
+			"TransferLeadership",
+			"POST",
+			"/peers/{peer}/leader",
+			api.transferLeadershipHandler,
+		},
+		{
+			"PeerstoreAddresses",
+			"GET",
+			"/peerstore",
+			api.peerstoreListHandler,
+		},
+		{
+			"PeerstoreAdd",
+			"POST",
+			"/peerstore",
+			api.peerstoreAddHandler,
+		},
+		{
+			"PeerstoreRemove",
+			"DELETE",
+			"/peerstore/{peer}",
+			api.peerstoreRemoveHandler,
+		},
+		{
+			"PeerBlacklist",
+			"GET",
+			"/blacklist",
+			api.blacklistListHandler,
+		},
+		{
+			"PeerBlacklistAdd",
+			"POST",
+			"/blacklist/{peer}",
+			api.blacklistAddHandler,
+		},
+		{
+			"PeerBlacklistRemove",
+			"DELETE",
+			"/blacklist/{peer}",
+			api.blacklistRemoveHandler,
+		},
 
 		{
 			"Allocations",
@@ -327,6 +531,42 @@ func (api *API) routes() []route {
 			"/pins/recover",
 			api.recoverAllHandler,
 		},
+		{
+			"UnpinMatching",
+			"DELETE",
+			"/pins",
+			api.unpinMatchingHandler,
+		},
+		{
+			"StatusSummary",
+			"GET",
+			"/pins/summary",
+			api.statusSummaryHandler,
+		},
+		{
+			"FailureDomains",
+			"GET",
+			"/pins/domains",
+			api.failureDomainsHandler,
+		},
+		{
+			"PinsetChanges",
+			"GET",
+			"/pins/changes",
+			api.pinsetChangesHandler,
+		},
+		{
+			"OrphanPins",
+			"GET",
+			"/pins/orphans",
+			api.orphanPinsHandler,
+		},
+		{
+			"ProcessOrphanPins",
+			"POST",
+			"/pins/orphans",
+			api.processOrphanPinsHandler,
+		},
 		{
 			"Status",
 			"GET",
@@ -345,6 +585,12 @@ func (api *API) routes() []route {
 			"/pins/{hash}",
 			api.unpinHandler,
 		},
+		{
+			"DAGStats",
+			"GET",
+			"/pins/{hash}/dag",
+			api.dagStatsHandler,
+		},
 		{
 			"Sync",
 			"POST",
@@ -357,12 +603,120 @@ func (api *API) routes() []route {
 			"/pins/{hash}/recover",
 			api.recoverHandler,
 		},
+		{
+			"Prefetch",
+			"POST",
+			"/pins/{hash}/prefetch",
+			api.prefetchHandler,
+		},
 		{
 			"ConnectionGraph",
 			"GET",
 			"/health/graph",
 			api.graphHandler,
 		},
+		{
+			"ConsensusState",
+			"GET",
+			"/health/consensus",
+			api.consensusStateHandler,
+		},
+		{
+			"PeerHealth",
+			"GET",
+			"/health/peers",
+			api.peerHealthHandler,
+		},
+		{
+			"ResourceUsage",
+			"GET",
+			"/health/resources",
+			api.resourceUsageHandler,
+		},
+		{
+			"RPCStats",
+			"GET",
+			"/health/rpc",
+			api.rpcStatsHandler,
+		},
+		{
+			"Metrics",
+			"GET",
+			"/health/metrics/{name}",
+			api.metricsHandler,
+		},
+		{
+			"RepairState",
+			"POST",
+			"/health/state/repair",
+			api.repairStateHandler,
+		},
+		{
+			"RotateSecret",
+			"POST",
+			"/secret/rotate",
+			api.rotateSecretHandler,
+		},
+		{
+			"SharedConfig",
+			"GET",
+			"/config/shared",
+			api.sharedConfigHandler,
+		},
+		{
+			"SetSharedConfig",
+			"POST",
+			"/config/shared",
+			api.setSharedConfigHandler,
+		},
+		{
+			"Faults",
+			"GET",
+			"/admin/faults",
+			api.faultsHandler,
+		},
+		{
+			"InjectFaults",
+			"POST",
+			"/admin/faults",
+			api.injectFaultsHandler,
+		},
+		{
+			"Pause",
+			"POST",
+			"/pause",
+			api.pauseHandler,
+		},
+		{
+			"Resume",
+			"POST",
+			"/resume",
+			api.resumeHandler,
+		},
+		{
+			"Jobs",
+			"GET",
+			"/jobs",
+			api.jobsHandler,
+		},
+		{
+			"StartJob",
+			"POST",
+			"/jobs",
+			api.startJobHandler,
+		},
+		{
+			"Job",
+			"GET",
+			"/jobs/{id}",
+			api.jobHandler,
+		},
+		{
+			"CancelJob",
+			"DELETE",
+			"/jobs/{id}",
+			api.cancelJobHandler,
+		},
 	}
 }
 
@@ -372,6 +726,11 @@ func (api *API) run() {
 		go api.runHTTPServer()
 	}
 
+	if api.readOnlyHTTPListener != nil {
+		api.wg.Add(1)
+		go api.runReadOnlyHTTPServer()
+	}
+
 	if api.libp2pListener != nil {
 		api.wg.Add(1)
 		go api.runLibp2pServer()
@@ -383,10 +742,22 @@ func (api *API) runHTTPServer() {
 	defer api.wg.Done()
 	<-api.rpcReady
 
-	logger.Infof("REST API (HTTP): %s", api.config.HTTPListenAddr)
+	api.logger.Infof("REST API (HTTP): %s", api.config.HTTPListenAddr)
 	err := api.server.Serve(api.httpListener)
 	if err != nil && !strings.Contains(err.Error(), "closed network connection") {
-		logger.Error(err)
+		api.logger.Error(err)
+	}
+}
+
+// runs in goroutine from run()
+func (api *API) runReadOnlyHTTPServer() {
+	defer api.wg.Done()
+	<-api.rpcReady
+
+	api.logger.Infof("REST API (HTTP, read-only): %s", api.config.HTTPReadOnlyListenAddr)
+	err := api.server.Serve(api.readOnlyHTTPListener)
+	if err != nil && !strings.Contains(err.Error(), "closed network connection") {
+		api.logger.Error(err)
 	}
 }
 
@@ -400,11 +771,11 @@ func (api *API) runLibp2pServer() {
 		listenMsg += fmt.Sprintf("        %s/ipfs/%s\n", a, api.host.ID().Pretty())
 	}
 
-	logger.Infof("REST API (libp2p-http): ENABLED. Listening on:\n%s\n", listenMsg)
+	api.logger.Infof("REST API (libp2p-http): ENABLED. Listening on:\n%s\n", listenMsg)
 
 	err := api.server.Serve(api.libp2pListener)
 	if err != nil && !strings.Contains(err.Error(), "context canceled") {
-		logger.Error(err)
+		api.logger.Error(err)
 	}
 }
 
@@ -414,11 +785,11 @@ func (api *API) Shutdown() error {
 	defer api.shutdownLock.Unlock()
 
 	if api.shutdown {
-		logger.Debug("already shutdown")
+		api.logger.Debug("already shutdown")
 		return nil
 	}
 
-	logger.Info("stopping Cluster API")
+	api.logger.Info("stopping Cluster API")
 
 	api.cancel()
 	close(api.rpcReady)
@@ -428,6 +799,9 @@ func (api *API) Shutdown() error {
 	if api.httpListener != nil {
 		api.httpListener.Close()
 	}
+	if api.readOnlyHTTPListener != nil {
+		api.readOnlyHTTPListener.Close()
+	}
 	if api.libp2pListener != nil {
 		api.libp2pListener.Close()
 	}
@@ -442,6 +816,21 @@ func (api *API) Shutdown() error {
 	return nil
 }
 
+// Drain makes the API refuse any new requests which would trigger work
+// (everything but GET requests) with a 503 error. It is used before
+// shutting down.
+func (api *API) Drain(ctx context.Context) error {
+	api.drainLock.Lock()
+	api.draining = true
+	api.drainLock.Unlock()
+	return nil
+}
+
+// SetLogPrefix sets the prefix of the messages logged by this component.
+func (api *API) SetLogPrefix(prefix string) {
+	api.logger.SetPrefix(prefix)
+}
+
 // SetClient makes the component ready to perform RPC
 // requests.
 func (api *API) SetClient(c *rpc.Client) {
@@ -450,6 +839,7 @@ func (api *API) SetClient(c *rpc.Client) {
 	// One notification for http server and one for libp2p server.
 	api.rpcReady <- struct{}{}
 	api.rpcReady <- struct{}{}
+	api.rpcReady <- struct{}{}
 }
 
 func (api *API) idHandler(w http.ResponseWriter, r *http.Request) {
@@ -484,87 +874,503 @@ func (api *API) graphHandler(w http.ResponseWriter, r *http.Request) {
 	sendResponse(w, err, graph)
 }
 
-func (api *API) peerListHandler(w http.ResponseWriter, r *http.Request) {
-	var peersSerial []types.IDSerial
+func (api *API) consensusStateHandler(w http.ResponseWriter, r *http.Request) {
+	var cs types.ConsensusStateSerial
 	err := api.rpcClient.Call("",
 		"Cluster",
-		"Peers",
+		"ConsensusState",
 		struct{}{},
-		&peersSerial)
+		&cs)
+	sendResponse(w, err, cs)
+}
 
-	sendResponse(w, err, peersSerial)
+func (api *API) peerHealthHandler(w http.ResponseWriter, r *http.Request) {
+	var health []types.PeerHealthSerial
+	err := api.rpcClient.Call("",
+		"Cluster",
+		"PeerHealth",
+		struct{}{},
+		&health)
+	sendResponse(w, err, health)
 }
 
-func (api *API) peerAddHandler(w http.ResponseWriter, r *http.Request) {
-	dec := json.NewDecoder(r.Body)
-	defer r.Body.Close()
+func (api *API) resourceUsageHandler(w http.ResponseWriter, r *http.Request) {
+	var usage types.ResourceUsage
+	err := api.rpcClient.Call("",
+		"Cluster",
+		"ResourceUsage",
+		struct{}{},
+		&usage)
+	sendResponse(w, err, usage)
+}
 
-	var addInfo peerAddBody
-	err := dec.Decode(&addInfo)
-	if err != nil {
-		sendErrorResponse(w, 400, "error decoding request body")
-		return
+func (api *API) rpcStatsHandler(w http.ResponseWriter, r *http.Request) {
+	var stats []types.RPCCallStatsSerial
+	err := api.rpcClient.Call("",
+		"Cluster",
+		"RPCStats",
+		struct{}{},
+		&stats)
+	sendResponse(w, err, stats)
+}
+
+func (api *API) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	var metrics []types.Metric
+	err := api.rpcClient.Call("",
+		"Cluster",
+		"Metrics",
+		name,
+		&metrics)
+	serials := make([]types.MetricSerial, len(metrics), len(metrics))
+	for i, m := range metrics {
+		serials[i] = m.ToSerial()
 	}
+	sendResponse(w, err, serials)
+}
 
-	mAddr, err := ma.NewMultiaddr(addInfo.PeerMultiaddr)
-	if err != nil {
-		sendErrorResponse(w, 400, "error decoding peer_multiaddress")
+func (api *API) repairStateHandler(w http.ResponseWriter, r *http.Request) {
+	var res types.StateRepair
+	err := api.rpcClient.Call("",
+		"Cluster",
+		"RepairState",
+		struct{}{},
+		&res)
+	sendResponse(w, err, res)
+}
+
+func (api *API) rotateSecretHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	// The body is optional: a random secret is generated otherwise
+	var body secretRotateBody
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil && err != io.EOF {
+		sendErrorResponse(w, 400, "error decoding request body")
 		return
 	}
 
-	var ids types.IDSerial
+	var res types.SecretRotationSerial
 	err = api.rpcClient.Call("",
 		"Cluster",
-		"PeerAdd",
-		types.MultiaddrToSerial(mAddr),
-		&ids)
-	sendResponse(w, err, ids)
+		"RotateSecret",
+		body.Secret,
+		&res)
+	sendResponse(w, err, res)
 }
 
-func (api *API) peerRemoveHandler(w http.ResponseWriter, r *http.Request) {
-	if p := parsePidOrError(w, r); p != "" {
-		err := api.rpcClient.Call("",
-			"Cluster",
-			"PeerRemove",
-			p,
-			&struct{}{})
-		sendEmptyResponse(w, err)
-	}
+func (api *API) sharedConfigHandler(w http.ResponseWriter, r *http.Request) {
+	var cfg types.SharedConfigSerial
+	err := api.rpcClient.Call("",
+		"Cluster",
+		"SharedConfig",
+		struct{}{},
+		&cfg)
+	sendResponse(w, err, cfg)
 }
 
-func (api *API) pinHandler(w http.ResponseWriter, r *http.Request) {
-	if ps := parseCidOrError(w, r); ps.Cid != "" {
-		logger.Debugf("rest api pinHandler: %s", ps.Cid)
-
-		err := api.rpcClient.Call("",
+// setSharedConfigHandler modifies the shared configuration settings
+// included in the request body. The rest are left untouched.
+func (api *API) setSharedConfigHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		sendErrorResponse(w, 400, "error reading request body")
+		return
+	}
+
+	var cfg types.SharedConfigSerial
+	var present map[string]json.RawMessage
+	if json.Unmarshal(body, &cfg) != nil || json.Unmarshal(body, &present) != nil {
+		sendErrorResponse(w, 400, "error decoding request body")
+		return
+	}
+	if cfg.StateSyncInterval != "" {
+		if _, err := time.ParseDuration(cfg.StateSyncInterval); err != nil {
+			sendErrorResponse(w, 400, "error parsing state_sync_interval")
+			return
+		}
+	}
+
+	update := types.SharedConfigUpdateSerial{Config: cfg}
+	for _, f := range types.SharedConfigFields {
+		if _, ok := present[f]; ok {
+			update.Fields = append(update.Fields, f)
+			delete(present, f)
+		}
+	}
+	for f := range present {
+		sendErrorResponse(w, 400, fmt.Sprintf("unknown shared configuration setting %q", f))
+		return
+	}
+	if len(update.Fields) == 0 {
+		sendErrorResponse(w, 400, "no shared configuration settings given")
+		return
+	}
+
+	err = api.rpcClient.Call("",
+		"Cluster",
+		"SetSharedConfig",
+		update,
+		&struct{}{})
+	sendEmptyResponse(w, err)
+}
+
+func (api *API) faultsHandler(w http.ResponseWriter, r *http.Request) {
+	var faults types.FaultsSerial
+	err := api.rpcClient.Call("",
+		"Cluster",
+		"Faults",
+		struct{}{},
+		&faults)
+	sendResponse(w, err, faults)
+}
+
+// injectFaultsHandler sets the faults simulated by the peer. Posting
+// no faults clears them.
+func (api *API) injectFaultsHandler(w http.ResponseWriter, r *http.Request) {
+	dec := json.NewDecoder(r.Body)
+	defer r.Body.Close()
+
+	var faults types.FaultsSerial
+	err := dec.Decode(&faults)
+	if err != nil {
+		sendErrorResponse(w, 400, "error decoding request body")
+		return
+	}
+	if faults.RPCDelay != "" {
+		if _, err := time.ParseDuration(faults.RPCDelay); err != nil {
+			sendErrorResponse(w, 400, "error parsing rpc_delay")
+			return
+		}
+	}
+	if faults.Duration != "" {
+		if _, err := time.ParseDuration(faults.Duration); err != nil {
+			sendErrorResponse(w, 400, "error parsing duration")
+			return
+		}
+	}
+
+	err = api.rpcClient.Call("",
+		"Cluster",
+		"InjectFaults",
+		faults,
+		&struct{}{})
+	sendEmptyResponse(w, err)
+}
+
+func (api *API) pauseHandler(w http.ResponseWriter, r *http.Request) {
+	err := api.rpcClient.Call("",
+		"Cluster",
+		"Pause",
+		struct{}{},
+		&struct{}{})
+	sendEmptyResponse(w, err)
+}
+
+func (api *API) resumeHandler(w http.ResponseWriter, r *http.Request) {
+	err := api.rpcClient.Call("",
+		"Cluster",
+		"Resume",
+		struct{}{},
+		&struct{}{})
+	sendEmptyResponse(w, err)
+}
+
+func (api *API) peerListHandler(w http.ResponseWriter, r *http.Request) {
+	queryValues := r.URL.Query()
+	if queryValues.Get("offset") == "" &&
+		queryValues.Get("limit") == "" &&
+		queryValues.Get("local") == "" &&
+		queryValues.Get("refresh") == "" {
+		var peersSerial []types.IDSerial
+		err := api.rpcClient.Call("",
+			"Cluster",
+			"Peers",
+			struct{}{},
+			&peersSerial)
+
+		sendResponse(w, err, peersSerial)
+		return
+	}
+
+	q, ok := parsePeersQueryOrError(w, r)
+	if !ok {
+		return
+	}
+	var peersSerial []types.IDSerial
+	err := api.rpcClient.Call("",
+		"Cluster",
+		"QueryPeers",
+		q,
+		&peersSerial)
+	sendResponse(w, err, peersSerial)
+}
+
+func (api *API) peerAddHandler(w http.ResponseWriter, r *http.Request) {
+	dec := json.NewDecoder(r.Body)
+	defer r.Body.Close()
+
+	var addInfo peerAddBody
+	err := dec.Decode(&addInfo)
+	if err != nil {
+		sendErrorResponse(w, 400, "error decoding request body")
+		return
+	}
+
+	mAddr, err := ma.NewMultiaddr(addInfo.PeerMultiaddr)
+	if err != nil {
+		sendErrorResponse(w, 400, "error decoding peer_multiaddress")
+		return
+	}
+
+	var ids types.IDSerial
+	err = api.rpcClient.Call("",
+		"Cluster",
+		"PeerAdd",
+		types.MultiaddrToSerial(mAddr),
+		&ids)
+	sendResponse(w, err, ids)
+}
+
+func (api *API) peerRemoveHandler(w http.ResponseWriter, r *http.Request) {
+	if p := parsePidOrError(w, r); p != "" {
+		method := "PeerRemove"
+		if r.URL.Query().Get("force") == "true" {
+			method = "PeerRemoveForce"
+		}
+		var removal types.PeerRemoval
+		err := api.rpcClient.Call("",
+			"Cluster",
+			method,
+			p,
+			&removal)
+		sendResponse(w, err, removal)
+	}
+}
+
+// peerstoreListHandler lists the addresses known by this peer for the
+// other cluster peers.
+func (api *API) peerstoreListHandler(w http.ResponseWriter, r *http.Request) {
+	var addrs types.MultiaddrsSerial
+	err := api.rpcClient.Call("",
+		"Cluster",
+		"PeerstoreAddresses",
+		struct{}{},
+		&addrs)
+	sendResponse(w, err, addrs)
+}
+
+// peerstoreAddHandler adds an address for a cluster peer to the
+// peerstore. It takes the same body as peerAddHandler, but the peerset
+// is not modified.
+func (api *API) peerstoreAddHandler(w http.ResponseWriter, r *http.Request) {
+	dec := json.NewDecoder(r.Body)
+	defer r.Body.Close()
+
+	var addInfo peerAddBody
+	err := dec.Decode(&addInfo)
+	if err != nil {
+		sendErrorResponse(w, 400, "error decoding request body")
+		return
+	}
+
+	mAddr, err := ma.NewMultiaddr(addInfo.PeerMultiaddr)
+	if err != nil {
+		sendErrorResponse(w, 400, "error decoding peer_multiaddress")
+		return
+	}
+	if _, _, err := types.Libp2pMultiaddrSplit(mAddr); err != nil {
+		sendErrorResponse(w, 400, "peer_multiaddress should include the peer ID: "+err.Error())
+		return
+	}
+
+	err = api.rpcClient.Call("",
+		"Cluster",
+		"PeerstoreAdd",
+		types.MultiaddrToSerial(mAddr),
+		&struct{}{})
+	sendEmptyResponse(w, err)
+}
+
+// peerstoreRemoveHandler forgets the addresses of a cluster peer
+// without removing it from the cluster.
+func (api *API) peerstoreRemoveHandler(w http.ResponseWriter, r *http.Request) {
+	if p := parsePidOrError(w, r); p != "" {
+		err := api.rpcClient.Call("",
+			"Cluster",
+			"PeerstoreRemove",
+			p,
+			&struct{}{})
+		sendEmptyResponse(w, err)
+	}
+}
+
+// blacklistListHandler lists the peers blacklisted by this peer.
+func (api *API) blacklistListHandler(w http.ResponseWriter, r *http.Request) {
+	var peers []peer.ID
+	err := api.rpcClient.Call("",
+		"Cluster",
+		"PeerBlacklist",
+		struct{}{},
+		&peers)
+	sendResponse(w, err, types.PeersToStrings(peers))
+}
+
+// blacklistAddHandler blacklists a peer, removing it from the cluster
+// when it is part of it.
+func (api *API) blacklistAddHandler(w http.ResponseWriter, r *http.Request) {
+	if p := parsePidOrError(w, r); p != "" {
+		err := api.rpcClient.Call("",
+			"Cluster",
+			"PeerBlacklistAdd",
+			p,
+			&struct{}{})
+		sendEmptyResponse(w, err)
+	}
+}
+
+// blacklistRemoveHandler takes a peer out of the blacklist.
+func (api *API) blacklistRemoveHandler(w http.ResponseWriter, r *http.Request) {
+	if p := parsePidOrError(w, r); p != "" {
+		err := api.rpcClient.Call("",
+			"Cluster",
+			"PeerBlacklistRemove",
+			p,
+			&struct{}{})
+		sendEmptyResponse(w, err)
+	}
+}
+
+func (api *API) peerRotateHandler(w http.ResponseWriter, r *http.Request) {
+	p := parsePidOrError(w, r)
+	if p == "" {
+		return
+	}
+
+	dec := json.NewDecoder(r.Body)
+	defer r.Body.Close()
+
+	var rotateInfo peerRotateBody
+	err := dec.Decode(&rotateInfo)
+	if err != nil {
+		sendErrorResponse(w, 400, "error decoding request body")
+		return
+	}
+
+	newID, err := peer.IDB58Decode(rotateInfo.NewPeerID)
+	if err != nil {
+		sendErrorResponse(w, 400, "error decoding new_peer_id: "+err.Error())
+		return
+	}
+
+	err = api.rpcClient.Call("",
+		"Cluster",
+		"PeerRotate",
+		types.PeerRotation{Old: p, New: newID}.ToSerial(),
+		&struct{}{})
+	sendEmptyResponse(w, err)
+}
+
+func (api *API) transferLeadershipHandler(w http.ResponseWriter, r *http.Request) {
+	if p := parsePidOrError(w, r); p != "" {
+		err := api.rpcClient.Call("",
+			"Cluster",
+			"TransferLeadership",
+			p,
+			&struct{}{})
+		sendEmptyResponse(w, err)
+	}
+}
+
+func (api *API) pinHandler(w http.ResponseWriter, r *http.Request) {
+	if ps := parseCidOrError(w, r); ps.Cid != "" {
+		setRequestID(w, r, &ps)
+		api.logger.Infof("rest api pinHandler: %s (request %s)", ps.Cid, ps.RequestID)
+
+		err := api.rpcClient.Call("",
 			"Cluster",
 			"Pin",
 			ps,
 			&struct{}{})
+		if err == nil {
+			var pending int
+			bpErr := api.rpcClient.Call("",
+				"Cluster",
+				"BackPressure",
+				struct{}{},
+				&pending)
+			if bpErr == nil && pending > 0 {
+				w.Header().Set(BackPressureHeader, strconv.Itoa(pending))
+			}
+		}
 		sendAcceptedResponse(w, err)
-		logger.Debug("rest api pinHandler done")
+		api.logger.Debug("rest api pinHandler done")
 	}
 }
 
 func (api *API) unpinHandler(w http.ResponseWriter, r *http.Request) {
 	if ps := parseCidOrError(w, r); ps.Cid != "" {
-		logger.Debugf("rest api unpinHandler: %s", ps.Cid)
+		setRequestID(w, r, &ps)
+		api.logger.Infof("rest api unpinHandler: %s (request %s)", ps.Cid, ps.RequestID)
 		err := api.rpcClient.Call("",
 			"Cluster",
 			"Unpin",
 			ps,
 			&struct{}{})
 		sendAcceptedResponse(w, err)
-		logger.Debug("rest api unpinHandler done")
+		api.logger.Debug("rest api unpinHandler done")
 	}
 }
 
+func (api *API) unpinMatchingHandler(w http.ResponseWriter, r *http.Request) {
+	q, ok := parsePinQueryOrError(w, r)
+	if !ok {
+		return
+	}
+	if q.IsEmpty() {
+		sendErrorResponse(w, 400, "a name or metadata selector (other than \"*\") is needed to unpin in bulk")
+		return
+	}
+
+	req := types.BulkUnpin{
+		Query:  q,
+		DryRun: r.URL.Query().Get("dry_run") == "true",
+	}
+	var pins []types.PinSerial
+	err := api.rpcClient.Call("",
+		"Cluster",
+		"UnpinMatching",
+		req,
+		&pins)
+	sendResponse(w, err, pins)
+}
+
 func (api *API) allocationsHandler(w http.ResponseWriter, r *http.Request) {
+	queryValues := r.URL.Query()
+	if len(queryValues) == 0 {
+		var pins []types.PinSerial
+		err := api.rpcClient.Call("",
+			"Cluster",
+			"Pins",
+			struct{}{},
+			&pins)
+		sendResponse(w, err, pins)
+		return
+	}
+
+	q, ok := parsePinQueryOrError(w, r)
+	if !ok {
+		return
+	}
+
 	var pins []types.PinSerial
 	err := api.rpcClient.Call("",
 		"Cluster",
-		"Pins",
-		struct{}{},
+		"SearchPins",
+		q,
 		&pins)
 	sendResponse(w, err, pins)
 }
@@ -588,8 +1394,27 @@ func (api *API) allocationHandler(w http.ResponseWriter, r *http.Request) {
 func (api *API) statusAllHandler(w http.ResponseWriter, r *http.Request) {
 	queryValues := r.URL.Query()
 	local := queryValues.Get("local")
+	timeout, ok := parseTimeoutOrError(w, r)
+	if !ok {
+		return
+	}
 
-	if local == "true" {
+	if shardStr := queryValues.Get("shard"); shardStr != "" {
+		shard, err := types.ParsePinShard(shardStr)
+		if err != nil {
+			sendErrorResponse(w, 400, err.Error())
+			return
+		}
+		if timeout > 0 {
+			sendErrorResponse(w, 400, "timeout cannot be used along with shard")
+			return
+		}
+		api.statusShard(w, shard, local == "true")
+		return
+	}
+
+	switch {
+	case local == "true":
 		var pinInfos []types.PinInfoSerial
 		err := api.rpcClient.Call("",
 			"Cluster",
@@ -597,7 +1422,15 @@ func (api *API) statusAllHandler(w http.ResponseWriter, r *http.Request) {
 			struct{}{},
 			&pinInfos)
 		sendResponse(w, err, pinInfosToGlobal(pinInfos))
-	} else {
+	case timeout > 0:
+		var pinInfos []types.GlobalPinInfoSerial
+		err := api.rpcClient.Call("",
+			"Cluster",
+			"StatusAllPartial",
+			timeout,
+			&pinInfos)
+		sendResponse(w, err, pinInfos)
+	default:
 		var pinInfos []types.GlobalPinInfoSerial
 		err := api.rpcClient.Call("",
 			"Cluster",
@@ -608,12 +1441,167 @@ func (api *API) statusAllHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// statusShard sends the status of the items which belong to the given
+// shard.
+func (api *API) statusShard(w http.ResponseWriter, shard types.PinShard, local bool) {
+	if local {
+		var pinInfos []types.PinInfoSerial
+		err := api.rpcClient.Call("",
+			"Cluster",
+			"StatusShardLocal",
+			shard,
+			&pinInfos)
+		sendResponse(w, err, pinInfosToGlobal(pinInfos))
+		return
+	}
+
+	var pinInfos []types.GlobalPinInfoSerial
+	err := api.rpcClient.Call("",
+		"Cluster",
+		"StatusShard",
+		shard,
+		&pinInfos)
+	sendResponse(w, err, pinInfos)
+}
+
+func (api *API) statusSummaryHandler(w http.ResponseWriter, r *http.Request) {
+	queryValues := r.URL.Query()
+	local := queryValues.Get("local")
+
+	if local == "true" {
+		var sum types.StatusSummarySerial
+		err := api.rpcClient.Call("",
+			"Cluster",
+			"StatusSummaryLocal",
+			struct{}{},
+			&sum)
+		sendResponse(w, err, []types.StatusSummarySerial{sum})
+	} else {
+		var sums []types.StatusSummarySerial
+		err := api.rpcClient.Call("",
+			"Cluster",
+			"StatusSummary",
+			struct{}{},
+			&sums)
+		sendResponse(w, err, sums)
+	}
+}
+
+func (api *API) failureDomainsHandler(w http.ResponseWriter, r *http.Request) {
+	tag := r.URL.Query().Get("tag")
+	if tag == "" {
+		sendErrorResponse(w, 400, "a peer tag is needed (i.e. ?tag=region)")
+		return
+	}
+
+	var infos []types.FailureDomainInfoSerial
+	err := api.rpcClient.Call("",
+		"Cluster",
+		"FailureDomains",
+		tag,
+		&infos)
+	sendResponse(w, err, infos)
+}
+
+// pinsetChangesHandler returns the pinset changes applied by this peer
+// after the "since" index of the "epoch", if given. With "wait", the
+// request is held until there are new changes or the given time passes.
+func (api *API) pinsetChangesHandler(w http.ResponseWriter, r *http.Request) {
+	queryValues := r.URL.Query()
+	req := types.PinsetChangesRequest{
+		Epoch: queryValues.Get("epoch"),
+	}
+
+	if v := queryValues.Get("since"); v != "" {
+		since, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			sendErrorResponse(w, 400, "error parsing since: "+err.Error())
+			return
+		}
+		req.Since = since
+	}
+
+	if v := queryValues.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 0 {
+			sendErrorResponse(w, 400, "error parsing limit: must be a positive integer")
+			return
+		}
+		req.Limit = limit
+	}
+
+	if v := queryValues.Get("wait"); v != "" {
+		wait, err := time.ParseDuration(v)
+		if err != nil || wait < 0 {
+			sendErrorResponse(w, 400, "error parsing wait: must be a positive duration")
+			return
+		}
+		// The response must be written before the server gives up.
+		if api.config.WriteTimeout > 0 && wait >= api.config.WriteTimeout {
+			sendErrorResponse(w, 400, "wait must be shorter than the write_timeout of the API")
+			return
+		}
+		req.Wait = wait
+	}
+
+	var chs types.PinsetChangesSerial
+	err := api.rpcClient.Call("",
+		"Cluster",
+		"PinsetChanges",
+		req,
+		&chs)
+	sendResponse(w, err, chs)
+}
+
+func (api *API) orphanPinsHandler(w http.ResponseWriter, r *http.Request) {
+	api.orphanPins(w, r, types.OrphanActionNone)
+}
+
+func (api *API) processOrphanPinsHandler(w http.ResponseWriter, r *http.Request) {
+	action := types.OrphanAction(r.URL.Query().Get("action"))
+	switch action {
+	case types.OrphanActionAdopt, types.OrphanActionRemove:
+	default:
+		sendErrorResponse(w, 400, "action must be adopt or remove")
+		return
+	}
+	api.orphanPins(w, r, action)
+}
+
+func (api *API) orphanPins(w http.ResponseWriter, r *http.Request, action types.OrphanAction) {
+	queryValues := r.URL.Query()
+	local := queryValues.Get("local")
+
+	if local == "true" {
+		var res types.OrphanPinsSerial
+		err := api.rpcClient.Call("",
+			"Cluster",
+			"OrphanPinsLocal",
+			action,
+			&res)
+		sendResponse(w, err, []types.OrphanPinsSerial{res})
+	} else {
+		var res []types.OrphanPinsSerial
+		err := api.rpcClient.Call("",
+			"Cluster",
+			"OrphanPins",
+			action,
+			&res)
+		sendResponse(w, err, res)
+	}
+}
+
 func (api *API) statusHandler(w http.ResponseWriter, r *http.Request) {
 	queryValues := r.URL.Query()
 	local := queryValues.Get("local")
+	timeout, ok := parseTimeoutOrError(w, r)
+	if !ok {
+		return
+	}
 
 	if ps := parseCidOrError(w, r); ps.Cid != "" {
-		if local == "true" {
+		switch {
+		case local == "true":
 			var pinInfo types.PinInfoSerial
 			err := api.rpcClient.Call("",
 				"Cluster",
@@ -621,18 +1609,42 @@ func (api *API) statusHandler(w http.ResponseWriter, r *http.Request) {
 				ps,
 				&pinInfo)
 			sendResponse(w, err, pinInfoToGlobal(pinInfo))
-		} else {
+		case timeout > 0:
 			var pinInfo types.GlobalPinInfoSerial
 			err := api.rpcClient.Call("",
 				"Cluster",
-				"Status",
-				ps,
+				"StatusPartial",
+				types.StatusRequestSerial{
+					Pin:     ps,
+					Timeout: timeout,
+				},
 				&pinInfo)
 			sendResponse(w, err, pinInfo)
+		default:
+			// Includes the shared state entry for the item
+			var detail types.PinDetailSerial
+			err := api.rpcClient.Call("",
+				"Cluster",
+				"PinDetail",
+				ps,
+				&detail)
+			sendResponse(w, err, detail)
 		}
 	}
 }
 
+func (api *API) dagStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if ps := parseCidOrError(w, r); ps.Cid != "" {
+		var stats types.DAGStatsSerial
+		err := api.rpcClient.Call("",
+			"Cluster",
+			"DAGStats",
+			ps,
+			&stats)
+		sendResponse(w, err, stats)
+	}
+}
+
 func (api *API) syncAllHandler(w http.ResponseWriter, r *http.Request) {
 	queryValues := r.URL.Query()
 	local := queryValues.Get("local")
@@ -645,6 +1657,8 @@ func (api *API) syncAllHandler(w http.ResponseWriter, r *http.Request) {
 			struct{}{},
 			&pinInfos)
 		sendResponse(w, err, pinInfosToGlobal(pinInfos))
+	} else if queryValues.Get("async") == "true" {
+		api.startJob(w, types.JobRequest{Type: types.JobTypeSyncAll})
 	} else {
 		var pinInfos []types.GlobalPinInfoSerial
 		err := api.rpcClient.Call("",
@@ -656,6 +1670,72 @@ func (api *API) syncAllHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// startJob starts a job and answers with 202 Accepted and the job,
+// which can be followed at /jobs/{id}.
+func (api *API) startJob(w http.ResponseWriter, req types.JobRequest) {
+	var job types.JobSerial
+	err := api.rpcClient.Call("",
+		"Cluster",
+		"StartJob",
+		req,
+		&job)
+	if checkRPCErr(w, err) {
+		w.Header().Set("Location", "/jobs/"+job.ID)
+		sendJSONResponse(w, http.StatusAccepted, job)
+	}
+}
+
+// startJobHandler starts the job described by the types.JobRequest in
+// the body. It allows to start jobs which have no synchronous endpoint,
+// like rebalancing or importing pins.
+func (api *API) startJobHandler(w http.ResponseWriter, r *http.Request) {
+	dec := json.NewDecoder(r.Body)
+	defer r.Body.Close()
+
+	var req types.JobRequest
+	err := dec.Decode(&req)
+	if err != nil {
+		sendErrorResponse(w, 400, "error decoding request body")
+		return
+	}
+	api.startJob(w, req)
+}
+
+func (api *API) jobsHandler(w http.ResponseWriter, r *http.Request) {
+	var jobs []types.JobSerial
+	err := api.rpcClient.Call("",
+		"Cluster",
+		"Jobs",
+		struct{}{},
+		&jobs)
+	sendResponse(w, err, jobs)
+}
+
+func (api *API) jobHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	var job types.JobSerial
+	err := api.rpcClient.Call("",
+		"Cluster",
+		"Job",
+		id,
+		&job)
+	if err != nil {
+		sendErrorResponse(w, http.StatusNotFound, err.Error())
+		return
+	}
+	sendJSONResponse(w, 200, job)
+}
+
+func (api *API) cancelJobHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	err := api.rpcClient.Call("",
+		"Cluster",
+		"CancelJob",
+		id,
+		&struct{}{})
+	sendAcceptedResponse(w, err)
+}
+
 func (api *API) syncHandler(w http.ResponseWriter, r *http.Request) {
 	queryValues := r.URL.Query()
 	local := queryValues.Get("local")
@@ -692,9 +1772,39 @@ func (api *API) recoverAllHandler(w http.ResponseWriter, r *http.Request) {
 			struct{}{},
 			&pinInfos)
 		sendResponse(w, err, pinInfosToGlobal(pinInfos))
-	} else {
-		sendErrorResponse(w, 400, "only requests with parameter local=true are supported")
+		return
+	}
+
+	req := types.RecoverAllRequest{
+		Filter: queryValues.Get("filter"),
+	}
+	if req.Filter == "" {
+		req.Filter = "error"
+	}
+	if v := queryValues.Get("concurrency"); v != "" {
+		concurrency, err := strconv.Atoi(v)
+		if err != nil || concurrency < 0 {
+			sendErrorResponse(w, 400, "error parsing concurrency")
+			return
+		}
+		req.Concurrency = concurrency
+	}
+
+	if queryValues.Get("async") == "true" {
+		api.startJob(w, types.JobRequest{
+			Type:              types.JobTypeRecoverAll,
+			RecoverAllRequest: req,
+		})
+		return
 	}
+
+	var gpis []types.GlobalPinInfoSerial
+	err := api.rpcClient.Call("",
+		"Cluster",
+		"RecoverAll",
+		req,
+		&gpis)
+	sendResponse(w, err, gpis)
 }
 
 func (api *API) recoverHandler(w http.ResponseWriter, r *http.Request) {
@@ -722,18 +1832,35 @@ func (api *API) recoverHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// prefetchHandler asks the peers which would be allocated to a Cid to
+// fetch it without pinning it. It takes the same replication factor
+// parameters as pinHandler and returns the peers which started fetching.
+func (api *API) prefetchHandler(w http.ResponseWriter, r *http.Request) {
+	if ps := parseCidOrError(w, r); ps.Cid != "" {
+		var peers []peer.ID
+		err := api.rpcClient.Call("",
+			"Cluster",
+			"Prefetch",
+			ps,
+			&peers)
+		sendResponse(w, err, types.PeersToStrings(peers))
+	}
+}
+
 func parseCidOrError(w http.ResponseWriter, r *http.Request) types.PinSerial {
 	vars := mux.Vars(r)
 	hash := vars["hash"]
 
-	_, err := cid.Decode(hash)
+	c, err := cid.Decode(hash)
 	if err != nil {
 		sendErrorResponse(w, 400, "error decoding Cid: "+err.Error())
 		return types.PinSerial{Cid: ""}
 	}
 
+	// Any multibase is accepted. Equivalent CIDv0 and CIDv1 forms
+	// refer to the same item.
 	pin := types.PinSerial{
-		Cid: hash,
+		Cid: types.CanonicalCid(c).String(),
 	}
 
 	queryValues := r.URL.Query()
@@ -753,10 +1880,114 @@ func parseCidOrError(w http.ResponseWriter, r *http.Request) types.PinSerial {
 	if rpl, err := strconv.Atoi(rplStrMax); err == nil {
 		pin.ReplicationFactorMax = rpl
 	}
+	pin.Group = queryValues.Get("group")
+	pin.Raw = queryValues.Get("raw") == "true"
+	pin.Signer = queryValues.Get("signer")
+	pin.Signature = queryValues.Get("signature")
+	pin.Nonce = queryValues.Get("nonce")
+	pin.Expires = queryValues.Get("expires")
+	pin.Metadata = parseMetadata(queryValues)
 
 	return pin
 }
 
+// setRequestID sets the ID of the request (see RequestIDHeader) on the
+// given pin and in the response headers.
+func setRequestID(w http.ResponseWriter, r *http.Request, ps *types.PinSerial) {
+	id := r.Header.Get(RequestIDHeader)
+	if id == "" {
+		id = types.NewRequestID()
+	}
+	ps.RequestID = id
+	w.Header().Set(RequestIDHeader, id)
+}
+
+// parsePinQueryOrError builds a PinQuery from the "name", "meta-<key>",
+// "offset" and "limit" query parameters.
+func parsePinQueryOrError(w http.ResponseWriter, r *http.Request) (types.PinQuery, bool) {
+	queryValues := r.URL.Query()
+	q := types.PinQuery{
+		Name:     queryValues.Get("name"),
+		Metadata: parseMetadata(queryValues),
+	}
+	var ok bool
+	q.Offset, q.Limit, ok = parsePaginationOrError(w, queryValues)
+	return q, ok
+}
+
+// parsePeersQueryOrError builds a PeersQuery from the offset, limit,
+// local and refresh query parameters. It sends an error response and returns
+// false when they cannot be parsed.
+func parsePeersQueryOrError(w http.ResponseWriter, r *http.Request) (types.PeersQuery, bool) {
+	queryValues := r.URL.Query()
+	q := types.PeersQuery{
+		Local:   queryValues.Get("local") == "true",
+		Refresh: queryValues.Get("refresh") == "true",
+	}
+	var ok bool
+	q.Offset, q.Limit, ok = parsePaginationOrError(w, queryValues)
+	return q, ok
+}
+
+// parsePaginationOrError parses the offset and limit query parameters,
+// which default to 0. It sends an error response and returns false when
+// they are not valid.
+func parsePaginationOrError(w http.ResponseWriter, queryValues url.Values) (int, int, bool) {
+	var offset, limit int
+	var err error
+	if v := queryValues.Get("offset"); v != "" {
+		offset, err = strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			sendErrorResponse(w, 400, "error parsing offset")
+			return 0, 0, false
+		}
+	}
+	if v := queryValues.Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 0 {
+			sendErrorResponse(w, 400, "error parsing limit")
+			return 0, 0, false
+		}
+	}
+	return offset, limit, true
+}
+
+// parseMetadata extracts the pin metadata from query parameters of the
+// form "meta-<key>=<value>". It returns nil when there are none.
+func parseMetadata(queryValues url.Values) map[string]string {
+	var meta map[string]string
+	for k := range queryValues {
+		if !strings.HasPrefix(k, metadataQueryPrefix) {
+			continue
+		}
+		key := strings.TrimPrefix(k, metadataQueryPrefix)
+		if key == "" {
+			continue
+		}
+		if meta == nil {
+			meta = make(map[string]string)
+		}
+		meta[key] = queryValues.Get(k)
+	}
+	return meta
+}
+
+// parseTimeoutOrError reads the optional "timeout" query parameter used
+// by partial status requests. It returns false when the parameter is
+// present but cannot be parsed (an error response has been sent then).
+func parseTimeoutOrError(w http.ResponseWriter, r *http.Request) (time.Duration, bool) {
+	timeoutStr := r.URL.Query().Get("timeout")
+	if timeoutStr == "" {
+		return 0, true
+	}
+	timeout, err := time.ParseDuration(timeoutStr)
+	if err != nil || timeout <= 0 {
+		sendErrorResponse(w, 400, "error parsing timeout: must be a positive duration")
+		return 0, false
+	}
+	return timeout, true
+}
+
 func parsePidOrError(w http.ResponseWriter, r *http.Request) peer.ID {
 	vars := mux.Vars(r)
 	idStr := vars["peer"]
@@ -221,6 +221,9 @@ func TestRestAPIIDEndpoint(t *testing.T) {
 		if id.ID != test.TestPeerID1.Pretty() {
 			t.Error("expected correct id")
 		}
+		if !id.IPFS.Reachable || id.IPFS.AgentVersion != test.IpfsMockAgentVersion {
+			t.Error("expected the ipfs daemon information")
+		}
 	}
 
 	httpstf := func(t *testing.T, url urlF) {
@@ -268,6 +271,45 @@ func TestAPIPeerstEndpoint(t *testing.T) {
 	testBothEndpoints(t, tf)
 }
 
+func TestAPIQueryPeersEndpoint(t *testing.T) {
+	rest := testAPI(t)
+	defer rest.Shutdown()
+
+	tf := func(t *testing.T, url urlF) {
+		var list []api.IDSerial
+		makeGet(t, rest, url(rest)+"/peers?offset=1&limit=1", &list)
+		if len(list) != 1 {
+			t.Fatal("expected 1 element")
+		}
+		if list[0].Peername == "" || list[0].LastHeartbeat == "" {
+			t.Error("expected full peer information with a last heartbeat")
+		}
+
+		var local []api.IDSerial
+		makeGet(t, rest, url(rest)+"/peers?local=true", &local)
+		if len(local) != 3 {
+			t.Fatal("expected 3 elements")
+		}
+		if local[0].Peername != "" {
+			t.Error("expected only locally-known information")
+		}
+
+		var refreshed []api.IDSerial
+		makeGet(t, rest, url(rest)+"/peers?refresh=true", &refreshed)
+		if len(refreshed) != 3 || refreshed[0].Peername == "" {
+			t.Error("expected the full information of 3 peers")
+		}
+
+		errResp := api.Error{}
+		makeGet(t, rest, url(rest)+"/peers?limit=abc", &errResp)
+		if errResp.Code != 400 {
+			t.Error("expected a bad request error for a bad limit")
+		}
+	}
+
+	testBothEndpoints(t, tf)
+}
+
 func TestAPIPeerAddEndpoint(t *testing.T) {
 	rest := testAPI(t)
 	defer rest.Shutdown()
@@ -308,6 +350,116 @@ func TestAPIPeerRemoveEndpoint(t *testing.T) {
 
 	tf := func(t *testing.T, url urlF) {
 		makeDelete(t, rest, url(rest)+"/peers/"+test.TestPeerID1.Pretty(), &struct{}{})
+
+		errResp := api.Error{}
+		makeDelete(t, rest, url(rest)+"/peers/"+test.TestPeerID3.Pretty(), &errResp)
+		if errResp.Code != 500 {
+			t.Error("expected an error when the removal breaks quorum")
+		}
+
+		var removal api.PeerRemoval
+		makeDelete(t, rest, url(rest)+"/peers/"+test.TestPeerID3.Pretty()+"?force=true", &removal)
+		if len(removal.Warnings) != 1 {
+			t.Error("expected the removal warnings in the response")
+		}
+	}
+
+	testBothEndpoints(t, tf)
+}
+
+func TestAPIBlacklistEndpoints(t *testing.T) {
+	rest := testAPI(t)
+	defer rest.Shutdown()
+
+	tf := func(t *testing.T, url urlF) {
+		var peers []string
+		makeGet(t, rest, url(rest)+"/blacklist", &peers)
+		if len(peers) != 1 || peers[0] != test.TestPeerID3.Pretty() {
+			t.Error("expected 1 blacklisted peer")
+		}
+
+		makePost(t, rest, url(rest)+"/blacklist/"+test.TestPeerID2.Pretty(), []byte{}, &struct{}{})
+
+		errResp := api.Error{}
+		makePost(t, rest, url(rest)+"/blacklist/abc", []byte{}, &errResp)
+		if errResp.Code != 400 {
+			t.Error("expected error with a bad peer ID")
+		}
+
+		makeDelete(t, rest, url(rest)+"/blacklist/"+test.TestPeerID3.Pretty(), &struct{}{})
+
+		errResp = api.Error{}
+		makeDelete(t, rest, url(rest)+"/blacklist/"+test.TestPeerID2.Pretty(), &errResp)
+		if errResp.Code != 500 {
+			t.Error("expected an error with a peer which is not blacklisted")
+		}
+	}
+
+	testBothEndpoints(t, tf)
+}
+
+func TestAPIPeerstoreEndpoints(t *testing.T) {
+	rest := testAPI(t)
+	defer rest.Shutdown()
+
+	tf := func(t *testing.T, url urlF) {
+		var addrs api.MultiaddrsSerial
+		makeGet(t, rest, url(rest)+"/peerstore", &addrs)
+		if len(addrs) != 1 {
+			t.Error("expected 1 address")
+		}
+
+		body := fmt.Sprintf("{\"peer_multiaddress\":\"/ip4/1.2.3.4/tcp/1234/ipfs/%s\"}", test.TestPeerID2.Pretty())
+		makePost(t, rest, url(rest)+"/peerstore", []byte(body), &struct{}{})
+
+		errResp := api.Error{}
+		makePost(t, rest, url(rest)+"/peerstore", []byte("{\"peer_multiaddress\": \"/ip4/1.2.3.4/tcp/1234\"}"), &errResp)
+		if errResp.Code != 400 {
+			t.Error("expected error with a multiaddress without peer ID")
+		}
+
+		makeDelete(t, rest, url(rest)+"/peerstore/"+test.TestPeerID2.Pretty(), &struct{}{})
+
+		errResp = api.Error{}
+		makeDelete(t, rest, url(rest)+"/peerstore/"+test.TestPeerID3.Pretty(), &errResp)
+		if errResp.Code != 500 {
+			t.Error("expected an error with a peer outside the cluster")
+		}
+	}
+
+	testBothEndpoints(t, tf)
+}
+
+func TestAPIPeerRotateEndpoint(t *testing.T) {
+	rest := testAPI(t)
+	defer rest.Shutdown()
+
+	tf := func(t *testing.T, url urlF) {
+		body := fmt.Sprintf("{\"new_peer_id\": \"%s\"}", test.TestPeerID4.Pretty())
+		makePost(t, rest, url(rest)+"/peers/"+test.TestPeerID1.Pretty()+"/rotate", []byte(body), &struct{}{})
+
+		errResp := api.Error{}
+		makePost(t, rest, url(rest)+"/peers/"+test.TestPeerID1.Pretty()+"/rotate", []byte("{\"new_peer_id\": \"abc\"}"), &errResp)
+		if errResp.Code != 400 {
+			t.Error("expected error with bad peer ID")
+		}
+	}
+
+	testBothEndpoints(t, tf)
+}
+
+func TestAPITransferLeadershipEndpoint(t *testing.T) {
+	rest := testAPI(t)
+	defer rest.Shutdown()
+
+	tf := func(t *testing.T, url urlF) {
+		makePost(t, rest, url(rest)+"/peers/"+test.TestPeerID2.Pretty()+"/leader", []byte{}, &struct{}{})
+
+		errResp := api.Error{}
+		makePost(t, rest, url(rest)+"/peers/"+test.TestPeerID4.Pretty()+"/leader", []byte{}, &errResp)
+		if errResp.Code != 500 {
+			t.Error("expected error transferring leadership to a non-peer")
+		}
 	}
 
 	testBothEndpoints(t, tf)
@@ -346,6 +498,316 @@ func TestConnectGraphEndpoint(t *testing.T) {
 	testBothEndpoints(t, tf)
 }
 
+func TestRepairStateEndpoint(t *testing.T) {
+	rest := testAPI(t)
+	defer rest.Shutdown()
+
+	tf := func(t *testing.T, url urlF) {
+		var res api.StateRepair
+		makePost(t, rest, url(rest)+"/health/state/repair", []byte{}, &res)
+		if !res.Diverged {
+			t.Error("expected a diverged state")
+		}
+		if res.Authoritative.Pins != 3 {
+			t.Error("unexpected authoritative state")
+		}
+	}
+
+	testBothEndpoints(t, tf)
+}
+
+func TestAPIRPCStatsEndpoint(t *testing.T) {
+	rest := testAPI(t)
+	defer rest.Shutdown()
+
+	tf := func(t *testing.T, url urlF) {
+		var stats []api.RPCCallStatsSerial
+		makeGet(t, rest, url(rest)+"/health/rpc", &stats)
+		if len(stats) != 2 {
+			t.Fatal("expected 2 entries")
+		}
+		if stats[0].Peer != test.TestPeerID2.Pretty() || stats[0].Count != 20 {
+			t.Error("unexpected rpc stats")
+		}
+	}
+
+	testBothEndpoints(t, tf)
+}
+
+func TestAPIPeerHealthEndpoint(t *testing.T) {
+	rest := testAPI(t)
+	defer rest.Shutdown()
+
+	tf := func(t *testing.T, url urlF) {
+		var health []api.PeerHealthSerial
+		makeGet(t, rest, url(rest)+"/health/peers", &health)
+		if len(health) != 2 {
+			t.Fatal("expected 2 entries")
+		}
+		if !health[0].Alive || health[0].Metrics["freespace"] != "1000" {
+			t.Error("unexpected health for the first peer")
+		}
+		if health[1].Alive {
+			t.Error("the second peer should not be alive")
+		}
+	}
+
+	testBothEndpoints(t, tf)
+}
+
+func TestAPIResourceUsageEndpoint(t *testing.T) {
+	rest := testAPI(t)
+	defer rest.Shutdown()
+
+	tf := func(t *testing.T, url urlF) {
+		var usage api.ResourceUsage
+		makeGet(t, rest, url(rest)+"/health/resources", &usage)
+		if usage.Goroutines != 120 {
+			t.Error("unexpected number of goroutines")
+		}
+		if len(usage.Components) != 3 || usage.Components[1].Queued != 5 {
+			t.Error("unexpected component usage")
+		}
+	}
+
+	testBothEndpoints(t, tf)
+}
+
+func TestAPIMetricsEndpoint(t *testing.T) {
+	rest := testAPI(t)
+	defer rest.Shutdown()
+
+	tf := func(t *testing.T, url urlF) {
+		var metrics []api.MetricSerial
+		makeGet(t, rest, url(rest)+"/health/metrics/freespace", &metrics)
+		if len(metrics) != 1 {
+			t.Fatal("expected 1 metric")
+		}
+		m := metrics[0]
+		if m.Name != "freespace" || m.Peer != test.TestPeerID1.Pretty() || m.Received == "" {
+			t.Error("unexpected metric")
+		}
+	}
+
+	testBothEndpoints(t, tf)
+}
+
+func TestAPIStatusSummaryEndpoint(t *testing.T) {
+	rest := testAPI(t)
+	defer rest.Shutdown()
+
+	tf := func(t *testing.T, url urlF) {
+		var sums []api.StatusSummarySerial
+		makeGet(t, rest, url(rest)+"/pins/summary", &sums)
+		if len(sums) != 2 {
+			t.Fatal("expected 2 summaries")
+		}
+		if sums[0].Counts["pinned"] != 2 || sums[1].Error == "" {
+			t.Error("unexpected status summary")
+		}
+
+		var localSums []api.StatusSummarySerial
+		makeGet(t, rest, url(rest)+"/pins/summary?local=true", &localSums)
+		if len(localSums) != 1 || localSums[0].Counts["pin_error"] != 1 {
+			t.Error("unexpected local status summary")
+		}
+	}
+
+	testBothEndpoints(t, tf)
+}
+
+func TestAPIFailureDomainsEndpoint(t *testing.T) {
+	rest := testAPI(t)
+	defer rest.Shutdown()
+
+	tf := func(t *testing.T, url urlF) {
+		var infos []api.FailureDomainInfoSerial
+		makeGet(t, rest, url(rest)+"/pins/domains?tag=region", &infos)
+		if len(infos) != 1 || !infos[0].Concentrated || len(infos[0].Domains["eu-west"]) != 2 {
+			t.Error("unexpected failure domains")
+		}
+
+		errResp := api.Error{}
+		makeGet(t, rest, url(rest)+"/pins/domains", &errResp)
+		if errResp.Code != 400 {
+			t.Error("expected a bad request without tag")
+		}
+	}
+
+	testBothEndpoints(t, tf)
+}
+
+func TestAPIPauseResumeEndpoints(t *testing.T) {
+	rest := testAPI(t)
+	defer rest.Shutdown()
+
+	tf := func(t *testing.T, url urlF) {
+		makePost(t, rest, url(rest)+"/pause", []byte{}, &struct{}{})
+		makePost(t, rest, url(rest)+"/resume", []byte{}, &struct{}{})
+	}
+
+	testBothEndpoints(t, tf)
+}
+
+func TestAPIOrphanPinsEndpoint(t *testing.T) {
+	rest := testAPI(t)
+	defer rest.Shutdown()
+
+	tf := func(t *testing.T, url urlF) {
+		var res []api.OrphanPinsSerial
+		makeGet(t, rest, url(rest)+"/pins/orphans", &res)
+		if len(res) != 2 || res[1].Error == "" {
+			t.Fatal("expected 2 results")
+		}
+		if len(res[0].Orphans) != 1 || res[0].Orphans[0] != test.TestCid3 {
+			t.Error("unexpected orphan pins")
+		}
+
+		var local []api.OrphanPinsSerial
+		makePost(t, rest, url(rest)+"/pins/orphans?action=adopt&local=true", []byte{}, &local)
+		if len(local) != 1 || local[0].Action != "adopt" {
+			t.Error("expected orphan pins to be adopted")
+		}
+
+		errResp := api.Error{}
+		makePost(t, rest, url(rest)+"/pins/orphans?action=bad", []byte{}, &errResp)
+		if errResp.Code != 400 {
+			t.Error("expected a bad request error for an unknown action")
+		}
+	}
+
+	testBothEndpoints(t, tf)
+}
+
+func TestAPISharedConfigEndpoint(t *testing.T) {
+	rest := testAPI(t)
+	defer rest.Shutdown()
+
+	tf := func(t *testing.T, url urlF) {
+		var cfg api.SharedConfigSerial
+		makeGet(t, rest, url(rest)+"/config/shared", &cfg)
+		if cfg.ReplicationFactorMin != 2 || cfg.StateSyncInterval != "1m0s" {
+			t.Error("unexpected shared config: ", cfg)
+		}
+
+		makePost(t, rest, url(rest)+"/config/shared", []byte(`{"allocator": "ascend"}`), &struct{}{})
+
+		errResp := api.Error{}
+		makePost(t, rest, url(rest)+"/config/shared", []byte(`{"state_sync_interval": "abc"}`), &errResp)
+		if errResp.Code != 400 {
+			t.Error("expected an error with a bad interval")
+		}
+
+		errResp = api.Error{}
+		makePost(t, rest, url(rest)+"/config/shared", []byte(`{"allocator": "random"}`), &errResp)
+		if errResp.Code != 500 {
+			t.Error("expected an error with a bad allocator")
+		}
+
+		errResp = api.Error{}
+		makePost(t, rest, url(rest)+"/config/shared", []byte(`{"allocatr": "ascend"}`), &errResp)
+		if errResp.Code != 400 {
+			t.Error("expected an error with an unknown setting")
+		}
+
+		errResp = api.Error{}
+		makePost(t, rest, url(rest)+"/config/shared", []byte(`{}`), &errResp)
+		if errResp.Code != 400 {
+			t.Error("expected an error without settings")
+		}
+	}
+
+	testBothEndpoints(t, tf)
+}
+
+func TestAPIFaultsEndpoint(t *testing.T) {
+	rest := testAPI(t)
+	defer rest.Shutdown()
+
+	tf := func(t *testing.T, url urlF) {
+		var faults api.FaultsSerial
+		makeGet(t, rest, url(rest)+"/admin/faults", &faults)
+		if !faults.IPFSErrors || faults.Until == "" {
+			t.Error("unexpected faults: ", faults)
+		}
+
+		makePost(t, rest, url(rest)+"/admin/faults", []byte(`{"rpc_delay": "2s", "duration": "1m"}`), &struct{}{})
+
+		errResp := api.Error{}
+		makePost(t, rest, url(rest)+"/admin/faults", []byte(`{"rpc_delay": "abc"}`), &errResp)
+		if errResp.Code != 400 {
+			t.Error("expected an error with a bad delay")
+		}
+	}
+
+	testBothEndpoints(t, tf)
+}
+
+func TestAPIRotateSecretEndpoint(t *testing.T) {
+	rest := testAPI(t)
+	defer rest.Shutdown()
+
+	tf := func(t *testing.T, url urlF) {
+		var res api.SecretRotationSerial
+		makePost(t, rest, url(rest)+"/secret/rotate", []byte{}, &res)
+		if len(res.Updated) != 2 || len(res.Failed) != 1 {
+			t.Error("unexpected secret rotation result")
+		}
+
+		var errResp api.Error
+		makePost(t, rest, url(rest)+"/secret/rotate", []byte(`{"secret":"abc"}`), &errResp)
+		if errResp.Code != 500 {
+			t.Error("expected an error with a bad secret")
+		}
+	}
+
+	testBothEndpoints(t, tf)
+}
+
+func TestConsensusStateEndpoint(t *testing.T) {
+	rest := testAPI(t)
+	defer rest.Shutdown()
+
+	tf := func(t *testing.T, url urlF) {
+		var cs api.ConsensusStateSerial
+		makeGet(t, rest, url(rest)+"/health/consensus", &cs)
+		if cs.Leader != test.TestPeerID1.Pretty() {
+			t.Error("unexpected leader")
+		}
+		if !cs.HasQuorum {
+			t.Error("expected quorum")
+		}
+		if len(cs.Voters) != 3 {
+			t.Error("unexpected number of voters")
+		}
+	}
+
+	testBothEndpoints(t, tf)
+}
+
+func TestAPIDrain(t *testing.T) {
+	rest := testAPI(t)
+	defer rest.Shutdown()
+
+	rest.Drain(context.Background())
+
+	tf := func(t *testing.T, url urlF) {
+		errResp := api.Error{}
+		makePost(t, rest, url(rest)+"/pins/"+test.TestCid1, []byte{}, &errResp)
+		if errResp.Code != 503 {
+			t.Error("expected 503 while draining")
+		}
+
+		var id api.IDSerial
+		makeGet(t, rest, url(rest)+"/id", &id)
+		if id.ID != test.TestPeerID1.Pretty() {
+			t.Error("GET requests should work while draining")
+		}
+	}
+
+	testBothEndpoints(t, tf)
+}
+
 func TestAPIPinEndpoint(t *testing.T) {
 	rest := testAPI(t)
 	defer rest.Shutdown()
@@ -369,6 +831,45 @@ func TestAPIPinEndpoint(t *testing.T) {
 	testBothEndpoints(t, tf)
 }
 
+func TestAPIPinRequestID(t *testing.T) {
+	rest := testAPI(t)
+	defer rest.Shutdown()
+
+	tf := func(t *testing.T, url urlF) {
+		h := makeHost(t, rest)
+		defer h.Close()
+		c := httpClient(t, h, false)
+
+		httpResp, err := c.Post(url(rest)+"/pins/"+test.TestCid1, "application/json", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		httpResp.Body.Close()
+		if httpResp.Header.Get(RequestIDHeader) == "" {
+			t.Error("expected a generated request ID")
+		}
+		if httpResp.StatusCode != http.StatusAccepted {
+			t.Error("pins should be accepted regardless of back-pressure")
+		}
+		if httpResp.Header.Get(BackPressureHeader) != "1500" {
+			t.Error("expected back-pressure to be reported")
+		}
+
+		req, _ := http.NewRequest("DELETE", url(rest)+"/pins/"+test.TestCid1, nil)
+		req.Header.Set(RequestIDHeader, "abcd")
+		httpResp, err = c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		httpResp.Body.Close()
+		if httpResp.Header.Get(RequestIDHeader) != "abcd" {
+			t.Error("expected the request ID provided by the client")
+		}
+	}
+
+	testBothEndpoints(t, tf)
+}
+
 func TestAPIUnpinEndpoint(t *testing.T) {
 	rest := testAPI(t)
 	defer rest.Shutdown()
@@ -409,6 +910,99 @@ func TestAPIAllocationsEndpoint(t *testing.T) {
 	testBothEndpoints(t, tf)
 }
 
+func TestAPIFieldSelection(t *testing.T) {
+	rest := testAPI(t)
+	defer rest.Shutdown()
+
+	tf := func(t *testing.T, url urlF) {
+		var pins []map[string]interface{}
+		makeGet(t, rest, url(rest)+"/allocations?fields=cid,name", &pins)
+		if len(pins) != 3 {
+			t.Fatal("expected 3 pins:", pins)
+		}
+		for _, p := range pins {
+			if len(p) != 2 || p["cid"] == nil {
+				t.Error("only cid and name should be returned:", p)
+			}
+		}
+
+		var id map[string]map[string]interface{}
+		makeGet(t, rest, url(rest)+"/id?fields=ipfs.agent_version", &id)
+		if len(id) != 1 || len(id["ipfs"]) != 1 ||
+			id["ipfs"]["agent_version"] != test.IpfsMockAgentVersion {
+			t.Error("unexpected nested selection:", id)
+		}
+
+		errResp := api.Error{}
+		makeGet(t, rest, url(rest)+"/allocations/"+test.ErrorCid+"?fields=cid", &errResp)
+		if errResp.Code != 404 {
+			t.Error("errors should be sent untouched")
+		}
+	}
+
+	testBothEndpoints(t, tf)
+}
+
+func TestAPISearchPinsEndpoint(t *testing.T) {
+	rest := testAPI(t)
+	defer rest.Shutdown()
+
+	tf := func(t *testing.T, url urlF) {
+		var resp []api.PinSerial
+		makeGet(t, rest, url(rest)+"/allocations?name=backup-2017-*", &resp)
+		if len(resp) != 2 || resp[0].Cid != test.TestCid1 {
+			t.Error("unexpected search results: ", resp)
+		}
+
+		var resp2 []api.PinSerial
+		makeGet(t, rest, url(rest)+"/allocations?name=backup-2017-*&meta-tenant=b", &resp2)
+		if len(resp2) != 1 || resp2[0].Cid != test.TestCid2 {
+			t.Error("unexpected search results: ", resp2)
+		}
+
+		var resp3 []api.PinSerial
+		makeGet(t, rest, url(rest)+"/allocations?name=backup-2017-*&limit=1&offset=1", &resp3)
+		if len(resp3) != 1 || resp3[0].Cid != test.TestCid2 {
+			t.Error("unexpected search results: ", resp3)
+		}
+
+		errResp := api.Error{}
+		makeGet(t, rest, url(rest)+"/allocations?limit=abc", &errResp)
+		if errResp.Code != 400 {
+			t.Error("should fail with bad limit")
+		}
+	}
+
+	testBothEndpoints(t, tf)
+}
+
+func TestAPIUnpinMatchingEndpoint(t *testing.T) {
+	rest := testAPI(t)
+	defer rest.Shutdown()
+
+	tf := func(t *testing.T, url urlF) {
+		var resp []api.PinSerial
+		makeDelete(t, rest, url(rest)+"/pins?name=backup-*&dry_run=true", &resp)
+		if len(resp) != 2 {
+			t.Error("unexpected unpinned items: ", resp)
+		}
+
+		errResp := api.Error{}
+		makeDelete(t, rest, url(rest)+"/pins", &errResp)
+		if errResp.Code != 400 {
+			t.Error("should fail without a selector")
+		}
+
+		errResp = api.Error{}
+		makeDelete(t, rest, url(rest)+"/pins?name=*", &errResp)
+		if errResp.Code != 400 {
+			t.Error("should fail with a wildcard-only selector")
+		}
+	}
+
+	testBothEndpoints(t, tf)
+}
+
 func TestAPIAllocationEndpoint(t *testing.T) {
 	rest := testAPI(t)
 	defer rest.Shutdown()
@@ -449,6 +1043,56 @@ func TestAPIStatusAllEndpoint(t *testing.T) {
 		if len(resp2) != 2 {
 			t.Errorf("unexpected statusAll+local resp:\n %+v", resp)
 		}
+
+		// Test timeout
+		var resp3 []api.GlobalPinInfoSerial
+		makeGet(t, rest, url(rest)+"/pins?timeout=2s", &resp3)
+		if len(resp3) != 3 {
+			t.Errorf("unexpected statusAll+timeout resp:\n %+v", resp3)
+		}
+
+		var errResp api.Error
+		makeGet(t, rest, url(rest)+"/pins?timeout=abc", &errResp)
+		if errResp.Code != 400 {
+			t.Error("expected error parsing timeout")
+		}
+	}
+
+	testBothEndpoints(t, tf)
+}
+
+func TestAPIStatusAllShardEndpoint(t *testing.T) {
+	rest := testAPI(t)
+	defer rest.Shutdown()
+
+	tf := func(t *testing.T, url urlF) {
+		total := 0
+		for i := 0; i < 2; i++ {
+			var resp []api.GlobalPinInfoSerial
+			makeGet(t, rest, fmt.Sprintf("%s/pins?shard=%d/2", url(rest), i), &resp)
+			total += len(resp)
+		}
+		if total != 3 {
+			t.Errorf("expected the 3 items spread in 2 shards, got %d", total)
+		}
+
+		var resp []api.GlobalPinInfoSerial
+		makeGet(t, rest, url(rest)+"/pins?shard=0/1&local=true", &resp)
+		if len(resp) != 2 {
+			t.Errorf("unexpected local shard resp:\n %+v", resp)
+		}
+
+		var errResp api.Error
+		makeGet(t, rest, url(rest)+"/pins?shard=2/2", &errResp)
+		if errResp.Code != 400 {
+			t.Error("expected error parsing shard")
+		}
+
+		var errResp2 api.Error
+		makeGet(t, rest, url(rest)+"/pins?shard=0/2&timeout=1s", &errResp2)
+		if errResp2.Code != 400 {
+			t.Error("expected an error using shard with timeout")
+		}
 	}
 
 	testBothEndpoints(t, tf)
@@ -473,6 +1117,14 @@ func TestAPIStatusEndpoint(t *testing.T) {
 			t.Error("expected different status")
 		}
 
+		// The shared state entry is included
+		var detail api.PinDetailSerial
+		makeGet(t, rest, url(rest)+"/pins/"+test.TestCid1, &detail)
+		if detail.Pin == nil || detail.Pin.Cid != test.TestCid1 ||
+			len(detail.Pin.Allocations) != 1 {
+			t.Error("expected the pin entry in the response")
+		}
+
 		// Test local=true
 		var resp2 api.GlobalPinInfoSerial
 		makeGet(t, rest, url(rest)+"/pins/"+test.TestCid1+"?local=true", &resp2)
@@ -492,6 +1144,32 @@ func TestAPIStatusEndpoint(t *testing.T) {
 	testBothEndpoints(t, tf)
 }
 
+func TestAPIDAGStatsEndpoint(t *testing.T) {
+	rest := testAPI(t)
+	defer rest.Shutdown()
+
+	tf := func(t *testing.T, url urlF) {
+		var resp api.DAGStatsSerial
+		makeGet(t, rest, url(rest)+"/pins/"+test.TestCid1+"/dag", &resp)
+		if resp.Cid != test.TestCid1 ||
+			resp.Peer != test.TestPeerID1.Pretty() ||
+			resp.Blocks != 3 ||
+			resp.TotalSize != 500 ||
+			resp.Depth != 3 ||
+			resp.LargestBlock != test.TestCid3 {
+			t.Errorf("unexpected DAG stats: %+v", resp)
+		}
+
+		errResp := api.Error{}
+		makeGet(t, rest, url(rest)+"/pins/"+test.ErrorCid+"/dag", &errResp)
+		if errResp.Message != test.ErrBadCid.Error() {
+			t.Error("expected different error: ", errResp.Message)
+		}
+	}
+
+	testBothEndpoints(t, tf)
+}
+
 func TestAPISyncAllEndpoint(t *testing.T) {
 	rest := testAPI(t)
 	defer rest.Shutdown()
@@ -556,6 +1234,64 @@ func TestAPISyncEndpoint(t *testing.T) {
 	testBothEndpoints(t, tf)
 }
 
+func TestAPIPrefetchEndpoint(t *testing.T) {
+	rest := testAPI(t)
+	defer rest.Shutdown()
+
+	tf := func(t *testing.T, url urlF) {
+		var peers []string
+		makePost(t, rest, url(rest)+"/pins/"+test.TestCid1+"/prefetch?replication_factor=2", []byte{}, &peers)
+		if len(peers) != 2 || peers[0] != test.TestPeerID1.Pretty() {
+			t.Error("expected the peers which started prefetching")
+		}
+
+		errResp := api.Error{}
+		makePost(t, rest, url(rest)+"/pins/"+test.ErrorCid+"/prefetch", []byte{}, &errResp)
+		if errResp.Message != test.ErrBadCid.Error() {
+			t.Error("expected different error: ", errResp.Message)
+		}
+	}
+
+	testBothEndpoints(t, tf)
+}
+
+func TestAPIPinsetChangesEndpoint(t *testing.T) {
+	rest := testAPI(t)
+	defer rest.Shutdown()
+
+	tf := func(t *testing.T, url urlF) {
+		var chs api.PinsetChangesSerial
+		makeGet(t, rest, url(rest)+"/pins/changes?since=1&limit=1&wait=1s", &chs)
+		if chs.Resync || chs.LastIndex != 3 || len(chs.Changes) != 1 {
+			t.Fatalf("unexpected changes: %+v", chs)
+		}
+		if chs.Changes[0].Index != 2 || chs.Changes[0].Type != api.PinsetChangeAdd ||
+			chs.Changes[0].Pin.Cid != test.TestCid2 {
+			t.Errorf("unexpected change: %+v", chs.Changes[0])
+		}
+
+		chs = api.PinsetChangesSerial{}
+		makeGet(t, rest, url(rest)+"/pins/changes?epoch=other&since=1", &chs)
+		if !chs.Resync || chs.Epoch != "mock" {
+			t.Errorf("expected a resync for a different epoch: %+v", chs)
+		}
+
+		errResp := api.Error{}
+		makeGet(t, rest, url(rest)+"/pins/changes?since=abc", &errResp)
+		if errResp.Code != 400 {
+			t.Error("expected a bad request error")
+		}
+
+		errResp = api.Error{}
+		makeGet(t, rest, url(rest)+"/pins/changes?wait=10m", &errResp)
+		if errResp.Code != 400 {
+			t.Error("expected an error for a wait longer than the write timeout")
+		}
+	}
+
+	testBothEndpoints(t, tf)
+}
+
 func TestAPIRecoverEndpoint(t *testing.T) {
 	rest := testAPI(t)
 	defer rest.Shutdown()
@@ -591,12 +1327,216 @@ func TestAPIRecoverAllEndpoint(t *testing.T) {
 			t.Fatal("bad response length")
 		}
 
+		var resp2 []api.GlobalPinInfoSerial
+		makePost(t, rest, url(rest)+"/pins/recover", []byte{}, &resp2)
+		if len(resp2) != 3 {
+			t.Error("expected all the items in error to be recovered")
+		}
+
+		var resp3 []api.GlobalPinInfoSerial
+		makePost(t, rest, url(rest)+"/pins/recover?filter=pin_error&concurrency=4", []byte{}, &resp3)
+		if len(resp3) != 3 {
+			t.Error("expected a filtered recover to work")
+		}
+
 		var errResp api.Error
-		makePost(t, rest, url(rest)+"/pins/recover", []byte{}, &errResp)
+		makePost(t, rest, url(rest)+"/pins/recover?concurrency=abc", []byte{}, &errResp)
 		if errResp.Code != 400 {
 			t.Error("expected a different error")
 		}
+
+		errResp = api.Error{}
+		makePost(t, rest, url(rest)+"/pins/recover?filter=bad", []byte{}, &errResp)
+		if errResp.Code != 500 {
+			t.Error("expected an error with an invalid filter")
+		}
+	}
+
+	testBothEndpoints(t, tf)
+}
+
+func TestAPIJobsEndpoint(t *testing.T) {
+	rest := testAPI(t)
+	defer rest.Shutdown()
+
+	tf := func(t *testing.T, url urlF) {
+		var job api.JobSerial
+		makePost(t, rest, url(rest)+"/pins/recover?async=true&filter=pin_error", []byte{}, &job)
+		if job.ID != test.TestJobID ||
+			job.Type != api.JobTypeRecoverAll ||
+			job.Status != api.JobStatusRunning {
+			t.Errorf("unexpected job: %+v", job)
+		}
+
+		var syncJob api.JobSerial
+		makePost(t, rest, url(rest)+"/pins/sync?async=true", []byte{}, &syncJob)
+		if syncJob.Type != api.JobTypeSyncAll {
+			t.Error("expected a sync_all job")
+		}
+
+		var rebalanceJob api.JobSerial
+		makePost(t, rest, url(rest)+"/jobs", []byte(`{"type":"rebalance"}`), &rebalanceJob)
+		if rebalanceJob.Type != api.JobTypeRebalance {
+			t.Error("expected a rebalance job")
+		}
+
+		var importJob api.JobSerial
+		body := fmt.Sprintf(`{"type":"import","pins":[{"cid":"%s"}]}`, test.TestCid1)
+		makePost(t, rest, url(rest)+"/jobs", []byte(body), &importJob)
+		if importJob.Type != api.JobTypeImport {
+			t.Error("expected an import job")
+		}
+
+		var jobErr api.Error
+		makePost(t, rest, url(rest)+"/jobs", []byte(`{"type":"import"}`), &jobErr)
+		if jobErr.Code != 500 {
+			t.Error("expected an error importing no pins")
+		}
+
+		var done api.JobSerial
+		makeGet(t, rest, url(rest)+"/jobs/"+test.TestJobID, &done)
+		if done.Status != api.JobStatusDone || len(done.Result) != 3 {
+			t.Errorf("unexpected job: %+v", done)
+		}
+
+		var jobs []api.JobSerial
+		makeGet(t, rest, url(rest)+"/jobs", &jobs)
+		if len(jobs) != 1 {
+			t.Error("expected 1 job")
+		}
+
+		makeDelete(t, rest, url(rest)+"/jobs/"+test.TestJobID, &struct{}{})
+
+		var errResp api.Error
+		makeGet(t, rest, url(rest)+"/jobs/abc", &errResp)
+		if errResp.Code != 404 {
+			t.Error("expected 404 for an unknown job")
+		}
+
+		errResp = api.Error{}
+		makeDelete(t, rest, url(rest)+"/jobs/abc", &errResp)
+		if errResp.Code != 500 {
+			t.Error("expected an error cancelling an unknown job")
+		}
 	}
 
 	testBothEndpoints(t, tf)
 }
+
+func TestAPIReadOnlyEndpoint(t *testing.T) {
+	apiMAddr, _ := ma.NewMultiaddr("/ip4/127.0.0.1/tcp/0")
+	h, err := libp2p.New(context.Background(), libp2p.ListenAddrs(apiMAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{}
+	cfg.Default()
+	cfg.HTTPListenAddr = apiMAddr
+	cfg.HTTPReadOnlyListenAddr = apiMAddr // another random port
+
+	rest, err := NewAPIWithHost(cfg, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rest.Shutdown()
+	rest.server.SetKeepAlivesEnabled(false)
+	rest.SetClient(test.NewMockRPCClient(t))
+
+	addr, _ := rest.HTTPAddress()
+	readOnlyAddr, err := rest.ReadOnlyHTTPAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	readOnlyURL := "http://" + readOnlyAddr
+
+	var id api.IDSerial
+	makeGet(t, rest, readOnlyURL+"/id", &id)
+	if id.ID != test.TestPeerID1.Pretty() {
+		t.Error("GET requests should work on the read-only endpoint")
+	}
+
+	var errResp api.Error
+	makePost(t, rest, readOnlyURL+"/pins/"+test.TestCid1, []byte{}, &errResp)
+	if errResp.Code != 403 {
+		t.Error("mutating requests should be refused on the read-only endpoint")
+	}
+
+	errResp = api.Error{}
+	makeDelete(t, rest, readOnlyURL+"/pins/"+test.TestCid1, &errResp)
+	if errResp.Code != 403 {
+		t.Error("mutating requests should be refused on the read-only endpoint")
+	}
+
+	errResp = api.Error{}
+	makeGet(t, rest, readOnlyURL+"/pins/"+test.TestCid1+"/dag", &errResp)
+	if errResp.Code != 403 {
+		t.Error("DAG stats should not be served on the read-only endpoint")
+	}
+
+	errResp = api.Error{}
+	makePost(t, rest, "http://"+addr+"/pins/"+test.TestCid1, []byte{}, &errResp)
+	if errResp.Code != 0 {
+		t.Error("mutating requests should work on the main endpoint")
+	}
+}
+
+func TestAPIClientCertScopes(t *testing.T) {
+	apiMAddr, _ := ma.NewMultiaddr("/ip4/127.0.0.1/tcp/0")
+	cfg := &Config{}
+	cfg.Default()
+	cfg.HTTPListenAddr = apiMAddr
+	cfg.pathSSLCertFile = SSLCertFile
+	cfg.pathSSLKeyFile = SSLKeyFile
+	cfg.pathClientCAFile = SSLCertFile
+	var err error
+	cfg.TLS, err = newTLSConfig(SSLCertFile, SSLKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = requireClientCerts(cfg.TLS, SSLCertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The test certificate subject is "Bob"
+	cfg.ClientCertScopes = map[string][]string{
+		"Bob": {ScopeRead},
+	}
+
+	rest, err := NewAPI(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rest.Shutdown()
+	rest.server.SetKeepAlivesEnabled(false)
+	rest.SetClient(test.NewMockRPCClient(t))
+
+	url := httpsURL(rest)
+
+	// No client certificate
+	c := httpClient(t, nil, true)
+	_, err = c.Get(url + "/id")
+	if err == nil {
+		t.Error("expected an error without a client certificate")
+	}
+
+	cert, err := tls.LoadX509KeyPair(SSLCertFile, SSLKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Transport.(*http.Transport).TLSClientConfig.Certificates = []tls.Certificate{cert}
+
+	var id api.IDSerial
+	httpResp, err := c.Get(url + "/id")
+	processResp(t, httpResp, err, &id)
+	if id.ID != test.TestPeerID1.Pretty() {
+		t.Error("expected correct id")
+	}
+
+	var errResp api.Error
+	httpResp, err = c.Post(url+"/pins/"+test.TestCid1, "application/json", bytes.NewReader([]byte{}))
+	processResp(t, httpResp, err, &errResp)
+	if errResp.Code != http.StatusForbidden {
+		t.Error("expected 403 without write scope")
+	}
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// PinShard selects one of Count buckets in which items are spread by
+// their Cid, so that the status of very large pinsets can be fetched in
+// bounded chunks (i.e. by several workers in parallel). An item belongs
+// to the bucket given by the 32-bit FNV-1a hash of the bytes of its
+// canonical Cid (see CanonicalCid), modulo Count.
+type PinShard struct {
+	Index int `json:"index"`
+	Count int `json:"count"`
+}
+
+// ParsePinShard parses a PinShard given as "index/count", i.e. "3/16".
+func ParsePinShard(s string) (PinShard, error) {
+	var shard PinShard
+	_, err := fmt.Sscanf(s, "%d/%d", &shard.Index, &shard.Count)
+	if err != nil || fmt.Sprintf("%d/%d", shard.Index, shard.Count) != s {
+		return PinShard{}, fmt.Errorf("bad shard %q: use index/count, i.e. 3/16", s)
+	}
+	return shard, shard.Validate()
+}
+
+// String returns the "index/count" form of the PinShard.
+func (s PinShard) String() string {
+	return fmt.Sprintf("%d/%d", s.Index, s.Count)
+}
+
+// Validate returns an error unless 0 <= Index < Count.
+func (s PinShard) Validate() error {
+	if s.Count <= 0 || s.Index < 0 || s.Index >= s.Count {
+		return errors.New("the shard index must be between 0 and the number of shards minus one")
+	}
+	return nil
+}
+
+// Contains returns true when the given Cid belongs to the shard.
+func (s PinShard) Contains(c *cid.Cid) bool {
+	if c == nil || s.Count <= 0 {
+		return false
+	}
+	h := fnv.New32a()
+	h.Write(CanonicalCid(c).Bytes())
+	return int(h.Sum32()%uint32(s.Count)) == s.Index
+}
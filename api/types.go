@@ -9,6 +9,10 @@
 package api
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"regexp"
 	"sort"
@@ -17,6 +21,7 @@ import (
 
 	cid "github.com/ipfs/go-cid"
 	logging "github.com/ipfs/go-log"
+	crypto "github.com/libp2p/go-libp2p-crypto"
 	peer "github.com/libp2p/go-libp2p-peer"
 	protocol "github.com/libp2p/go-libp2p-protocol"
 	ma "github.com/multiformats/go-multiaddr"
@@ -53,6 +58,12 @@ const (
 	TrackerStatusPinQueued
 	// The item has been queued for unpinning on the IPFS daemon
 	TrackerStatusUnpinQueued
+	// The cluster peer did not answer before the deadline of a
+	// partial status request
+	TrackerStatusTimedOut
+	// The item is no longer part of the cluster and will be unpinned
+	// from the IPFS daemon when the unpin grace period expires
+	TrackerStatusUnpinPending
 )
 
 // TrackerStatus represents the status of a tracked Cid in the PinTracker
@@ -70,6 +81,8 @@ var trackerStatusString = map[TrackerStatus]string{
 	TrackerStatusRemote:       "remote",
 	TrackerStatusPinQueued:    "pin_queued",
 	TrackerStatusUnpinQueued:  "unpin_queued",
+	TrackerStatusTimedOut:     "timed_out",
+	TrackerStatusUnpinPending: "unpin_pending",
 }
 
 // String converts a TrackerStatus into a readable string.
@@ -88,6 +101,17 @@ func TrackerStatusFromString(str string) TrackerStatus {
 	return TrackerStatusBug
 }
 
+// IsError returns true for the statuses which signal a failure: the
+// cluster peer did not answer or the IPFS pin or unpin operation failed.
+func (st TrackerStatus) IsError() bool {
+	switch st {
+	case TrackerStatusClusterError, TrackerStatusPinError, TrackerStatusUnpinError:
+		return true
+	default:
+		return false
+	}
+}
+
 // IPFSPinStatus values
 const (
 	IPFSPinStatusBug IPFSPinStatus = iota
@@ -115,11 +139,29 @@ func IPFSPinStatusFromString(t string) IPFSPinStatus {
 		return IPFSPinStatusDirect
 	case t == "recursive":
 		return IPFSPinStatusRecursive
+	case t == "unpinned":
+		return IPFSPinStatusUnpinned
+	case t == "error":
+		return IPFSPinStatusError
 	default:
 		return IPFSPinStatusBug
 	}
 }
 
+var ipfsPinStatusString = map[IPFSPinStatus]string{
+	IPFSPinStatusBug:       "bug",
+	IPFSPinStatusError:     "error",
+	IPFSPinStatusDirect:    "direct",
+	IPFSPinStatusRecursive: "recursive",
+	IPFSPinStatusIndirect:  "indirect",
+	IPFSPinStatusUnpinned:  "unpinned",
+}
+
+// String converts an IPFSPinStatus into a readable string.
+func (ips IPFSPinStatus) String() string {
+	return ipfsPinStatusString[ips]
+}
+
 // IsPinned returns true if the status is Direct or Recursive
 func (ips IPFSPinStatus) IsPinned() bool {
 	return ips == IPFSPinStatusDirect || ips == IPFSPinStatusRecursive
@@ -145,12 +187,34 @@ var ipfsPinStatus2TrackerStatusMap = map[IPFSPinStatus]TrackerStatus{
 type GlobalPinInfo struct {
 	Cid     *cid.Cid
 	PeerMap map[peer.ID]PinInfo
+	// Timestamp is the time at which the item was pinned in the
+	// cluster (see Pin.Timestamp). It is zero when unknown.
+	Timestamp time.Time
+
+	// Allocations are the peers which should hold the item according
+	// to the shared state (all of them when it is pinned everywhere),
+	// and PinnedBy are the peers which report it as pinned. Match is
+	// true when both sets are the same. Allocations is nil when the
+	// information is not available (i.e. local statuses).
+	Allocations []peer.ID
+	PinnedBy    []peer.ID
+	Match       bool
 }
 
 // GlobalPinInfoSerial is the serializable version of GlobalPinInfo.
 type GlobalPinInfoSerial struct {
-	Cid     string                   `json:"cid"`
-	PeerMap map[string]PinInfoSerial `json:"peer_map"`
+	Cid       string                   `json:"cid"`
+	PeerMap   map[string]PinInfoSerial `json:"peer_map"`
+	Timestamp string                   `json:"timestamp,omitempty"`
+
+	Allocations []string `json:"allocations,omitempty"`
+	PinnedBy    []string `json:"pinned_by,omitempty"`
+	Match       *bool    `json:"match,omitempty"`
+
+	// CidV0 (when there is one) and CidV1 are the equivalent forms of
+	// Cid, for information only.
+	CidV0 string `json:"cid_v0,omitempty"`
+	CidV1 string `json:"cid_v1,omitempty"`
 }
 
 // ToSerial converts a GlobalPinInfo to its serializable version.
@@ -158,11 +222,24 @@ func (gpi GlobalPinInfo) ToSerial() GlobalPinInfoSerial {
 	s := GlobalPinInfoSerial{}
 	if gpi.Cid != nil {
 		s.Cid = gpi.Cid.String()
+		if v0 := CanonicalCid(gpi.Cid); v0.Version() == 0 {
+			s.CidV0 = v0.String()
+		}
+		s.CidV1 = CidV1Base32(gpi.Cid)
+	}
+	if !gpi.Timestamp.IsZero() {
+		s.Timestamp = gpi.Timestamp.UTC().Format(time.RFC3339)
 	}
 	s.PeerMap = make(map[string]PinInfoSerial)
 	for k, v := range gpi.PeerMap {
 		s.PeerMap[peer.IDB58Encode(k)] = v.ToSerial()
 	}
+	if gpi.Allocations != nil {
+		match := gpi.Match
+		s.Allocations = PeersToStrings(gpi.Allocations)
+		s.PinnedBy = PeersToStrings(gpi.PinnedBy)
+		s.Match = &match
+	}
 	return s
 }
 
@@ -176,6 +253,9 @@ func (gpis GlobalPinInfoSerial) ToGlobalPinInfo() GlobalPinInfo {
 		Cid:     c,
 		PeerMap: make(map[peer.ID]PinInfo),
 	}
+	if gpis.Timestamp != "" {
+		gpi.Timestamp, _ = time.Parse(time.RFC3339, gpis.Timestamp)
+	}
 	for k, v := range gpis.PeerMap {
 		p, err := peer.IDB58Decode(k)
 		if err != nil {
@@ -183,9 +263,116 @@ func (gpis GlobalPinInfoSerial) ToGlobalPinInfo() GlobalPinInfo {
 		}
 		gpi.PeerMap[p] = v.ToPinInfo()
 	}
+	if gpis.Match != nil {
+		gpi.Allocations = StringsToPeers(gpis.Allocations)
+		gpi.PinnedBy = StringsToPeers(gpis.PinnedBy)
+		gpi.Match = *gpis.Match
+	}
 	return gpi
 }
 
+// PinDetail combines the status of an item in every cluster peer with its
+// entry in the shared state, telling both what the cluster is supposed to
+// do with the item and what it is actually doing.
+type PinDetail struct {
+	GlobalPinInfo
+	// Pin is the shared state entry for the item. It is nil
+	// when the item is not part of the shared state.
+	Pin *Pin
+}
+
+// PinDetailSerial is the serializable version of PinDetail. It is a
+// superset of GlobalPinInfoSerial.
+type PinDetailSerial struct {
+	GlobalPinInfoSerial
+	Pin *PinSerial `json:"pin,omitempty"`
+}
+
+// ToSerial converts a PinDetail to its serializable version.
+func (pd PinDetail) ToSerial() PinDetailSerial {
+	s := PinDetailSerial{
+		GlobalPinInfoSerial: pd.GlobalPinInfo.ToSerial(),
+	}
+	if pd.Pin != nil {
+		pin := pd.Pin.ToSerial()
+		s.Pin = &pin
+	}
+	return s
+}
+
+// ToPinDetail converts a PinDetailSerial to its native version.
+func (pds PinDetailSerial) ToPinDetail() PinDetail {
+	pd := PinDetail{
+		GlobalPinInfo: pds.GlobalPinInfoSerial.ToGlobalPinInfo(),
+	}
+	if pds.Pin != nil {
+		pin := pds.Pin.ToPin()
+		pd.Pin = &pin
+	}
+	return pd
+}
+
+// DAGStats holds statistics about the DAG under a pinned Cid, as
+// obtained by walking it in the IPFS daemon of a cluster peer.
+type DAGStats struct {
+	Cid  *cid.Cid
+	Peer peer.ID
+	// Blocks is the number of unique blocks in the DAG.
+	Blocks int
+	// TotalSize is the sum of the sizes of those blocks in bytes.
+	TotalSize uint64
+	// Depth is the number of blocks in the longest path from the root
+	// (1 for a single block).
+	Depth            int
+	LargestBlock     *cid.Cid
+	LargestBlockSize uint64
+}
+
+// DAGStatsSerial is the serializable version of DAGStats.
+type DAGStatsSerial struct {
+	Cid              string `json:"cid"`
+	Peer             string `json:"peer"`
+	Blocks           int    `json:"blocks"`
+	TotalSize        uint64 `json:"total_size"`
+	Depth            int    `json:"depth"`
+	LargestBlock     string `json:"largest_block"`
+	LargestBlockSize uint64 `json:"largest_block_size"`
+}
+
+// ToSerial converts a DAGStats to its serializable version.
+func (ds DAGStats) ToSerial() DAGStatsSerial {
+	s := DAGStatsSerial{
+		Peer:             peer.IDB58Encode(ds.Peer),
+		Blocks:           ds.Blocks,
+		TotalSize:        ds.TotalSize,
+		Depth:            ds.Depth,
+		LargestBlockSize: ds.LargestBlockSize,
+	}
+	if ds.Cid != nil {
+		s.Cid = ds.Cid.String()
+	}
+	if ds.LargestBlock != nil {
+		s.LargestBlock = ds.LargestBlock.String()
+	}
+	return s
+}
+
+// ToDAGStats converts a DAGStatsSerial to its native version.
+func (dss DAGStatsSerial) ToDAGStats() DAGStats {
+	c, _ := cid.Decode(dss.Cid)
+	p, _ := peer.IDB58Decode(dss.Peer)
+	largest, _ := cid.Decode(dss.LargestBlock)
+	return DAGStats{
+		Cid:              c,
+		Peer:             p,
+		Blocks:           dss.Blocks,
+		TotalSize:        dss.TotalSize,
+		Depth:            dss.Depth,
+		LargestBlock:     largest,
+		LargestBlockSize: dss.LargestBlockSize,
+	}
+}
+
 // PinInfo holds information about local pins.
 type PinInfo struct {
 	Cid    *cid.Cid
@@ -193,6 +380,55 @@ type PinInfo struct {
 	Status TrackerStatus
 	TS     time.Time
 	Error  string
+	// IPFSPinStatus is the type of the pin (recursive, direct...)
+	// last seen in the IPFS daemon. It is IPFSPinStatusBug when
+	// unknown (it is only obtained when syncing).
+	IPFSPinStatus IPFSPinStatus
+	// Size is the cumulative size of the pinned DAG in bytes, when
+	// known.
+	Size uint64
+	// History holds the last status transitions of the item in this
+	// peer, oldest first. It is only set when requesting the status
+	// of a single item.
+	History []StatusChange
+}
+
+// StatusChange records that a tracked item transitioned to a
+// TrackerStatus at a given time, along with the error message for
+// error statuses.
+type StatusChange struct {
+	Status TrackerStatus
+	TS     time.Time
+	Error  string
+}
+
+// StatusChangeSerial is the serializable version of StatusChange.
+type StatusChangeSerial struct {
+	Status string `json:"status"`
+	TS     string `json:"timestamp"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ToSerial converts a StatusChange to its serializable version.
+func (sc StatusChange) ToSerial() StatusChangeSerial {
+	return StatusChangeSerial{
+		Status: sc.Status.String(),
+		TS:     sc.TS.UTC().Format(time.RFC3339),
+		Error:  sc.Error,
+	}
+}
+
+// ToStatusChange converts a StatusChangeSerial to its native version.
+func (scs StatusChangeSerial) ToStatusChange() StatusChange {
+	ts, err := time.Parse(time.RFC3339, scs.TS)
+	if err != nil {
+		logger.Debug(scs.TS, err)
+	}
+	return StatusChange{
+		Status: TrackerStatusFromString(scs.Status),
+		TS:     ts,
+		Error:  scs.Error,
+	}
 }
 
 // PinInfoSerial is a serializable version of PinInfo.
@@ -203,6 +439,10 @@ type PinInfoSerial struct {
 	Status string `json:"status"`
 	TS     string `json:"timestamp"`
 	Error  string `json:"error"`
+
+	IPFSPinStatus string               `json:"ipfs_pin_status,omitempty"`
+	Size          uint64               `json:"size,omitempty"`
+	History       []StatusChangeSerial `json:"history,omitempty"`
 }
 
 // ToSerial converts a PinInfo to its serializable version.
@@ -216,12 +456,28 @@ func (pi PinInfo) ToSerial() PinInfoSerial {
 		p = peer.IDB58Encode(pi.Peer)
 	}
 
+	ips := ""
+	if pi.IPFSPinStatus != IPFSPinStatusBug {
+		ips = pi.IPFSPinStatus.String()
+	}
+
+	var history []StatusChangeSerial
+	if len(pi.History) > 0 {
+		history = make([]StatusChangeSerial, len(pi.History))
+		for i, sc := range pi.History {
+			history[i] = sc.ToSerial()
+		}
+	}
+
 	return PinInfoSerial{
-		Cid:    c,
-		Peer:   p,
-		Status: pi.Status.String(),
-		TS:     pi.TS.UTC().Format(time.RFC3339),
-		Error:  pi.Error,
+		Cid:           c,
+		Peer:          p,
+		Status:        pi.Status.String(),
+		TS:            pi.TS.UTC().Format(time.RFC3339),
+		Error:         pi.Error,
+		IPFSPinStatus: ips,
+		Size:          pi.Size,
+		History:       history,
 	}
 }
 
@@ -239,15 +495,190 @@ func (pis PinInfoSerial) ToPinInfo() PinInfo {
 	if err != nil {
 		logger.Debug(pis.TS, err)
 	}
+	var history []StatusChange
+	if len(pis.History) > 0 {
+		history = make([]StatusChange, len(pis.History))
+		for i, scs := range pis.History {
+			history[i] = scs.ToStatusChange()
+		}
+	}
+
 	return PinInfo{
-		Cid:    c,
-		Peer:   p,
-		Status: TrackerStatusFromString(pis.Status),
-		TS:     ts,
-		Error:  pis.Error,
+		Cid:           c,
+		Peer:          p,
+		Status:        TrackerStatusFromString(pis.Status),
+		TS:            ts,
+		Error:         pis.Error,
+		IPFSPinStatus: IPFSPinStatusFromString(pis.IPFSPinStatus),
+		Size:          pis.Size,
+		History:       history,
 	}
 }
 
+// PeerAddStage values
+const (
+	// The new peer answered and we know our address to it. When
+	// joining, the peer we join through is connected.
+	PeerAddStageReachable PeerAddStage = iota
+	// The new peer received the addresses of the current cluster peers
+	PeerAddStagePeersetPushed
+	// The new peer was added to the consensus peerset
+	PeerAddStageConsensus
+	// The new peer reports the same peerset as the peer adding it.
+	// Its state may still be catching up.
+	PeerAddStagePeersetSynced
+	// The joining peer has caught up with the shared state and synced
+	// its pin tracker with it. Only reported when joining.
+	PeerAddStageStateSynced
+)
+
+// PeerAddStage identifies a step of the process of adding a peer to the
+// cluster.
+type PeerAddStage int
+
+var peerAddStageString = map[PeerAddStage]string{
+	PeerAddStageReachable:     "reachable",
+	PeerAddStagePeersetPushed: "peerset_pushed",
+	PeerAddStageConsensus:     "logged_to_consensus",
+	PeerAddStagePeersetSynced: "peerset_synced",
+	PeerAddStageStateSynced:   "state_synced",
+}
+
+// String converts a PeerAddStage into a readable string.
+func (st PeerAddStage) String() string {
+	return peerAddStageString[st]
+}
+
+// PeerAddProgress is sent to signal that a peer being added to the cluster
+// has completed a stage of the process.
+type PeerAddProgress struct {
+	Peer  peer.ID
+	Stage PeerAddStage
+}
+
+// StatusRequestSerial carries the arguments for status requests which
+// only wait a limited amount of time for cluster peers to answer.
+type StatusRequestSerial struct {
+	Pin     PinSerial     `json:"pin"`
+	Timeout time.Duration `json:"timeout"`
+}
+
+// RecoverAllRequest carries the arguments for cluster-wide recover
+// operations. Filter selects the items to recover by their status in any
+// peer: "error" selects every error status, while a status name (i.e.
+// "pin_error") selects only that one. Concurrency limits how many items
+// are recovered at the same time.
+type RecoverAllRequest struct {
+	Filter      string `json:"filter"`
+	Concurrency int    `json:"concurrency"`
+}
+
+// JobType identifies a long-running operation which can run as a job.
+type JobType string
+
+// JobType values
+const (
+	// JobTypeRecoverAll runs Cluster.RecoverAll.
+	JobTypeRecoverAll JobType = "recover_all"
+	// JobTypeSyncAll runs Cluster.SyncAll.
+	JobTypeSyncAll JobType = "sync_all"
+	// JobTypeRebalance re-allocates every pin so that allocations are
+	// rebalanced (see Config.RebalanceAllocations).
+	JobTypeRebalance JobType = "rebalance"
+	// JobTypeImport pins every pin in JobRequest.Pins, ignoring
+	// their allocations.
+	JobTypeImport JobType = "import"
+)
+
+// JobStatus is the state of a job.
+type JobStatus string
+
+// JobStatus values
+const (
+	JobStatusRunning   JobStatus = "running"
+	JobStatusDone      JobStatus = "done"
+	JobStatusFailed    JobStatus = "failed"
+	JobStatusCancelled JobStatus = "cancelled"
+)
+
+// JobRequest describes a job to be started. The RecoverAllRequest
+// fields only apply to JobTypeRecoverAll jobs and Pins only to
+// JobTypeImport jobs.
+type JobRequest struct {
+	Type JobType `json:"type"`
+	RecoverAllRequest
+	Pins []PinSerial `json:"pins,omitempty"`
+}
+
+// Job holds the progress and, once finished, the results of a
+// long-running operation started by a cluster peer.
+type Job struct {
+	ID     string
+	Type   JobType
+	Status JobStatus
+	// Done and Total tell how many items (or steps) have been
+	// processed out of those which need to be.
+	Done     int
+	Total    int
+	Started  time.Time
+	Finished time.Time
+	Error    string
+	Result   []GlobalPinInfo
+}
+
+// JobSerial is the serializable version of Job.
+type JobSerial struct {
+	ID       string                `json:"id"`
+	Type     JobType               `json:"type"`
+	Status   JobStatus             `json:"status"`
+	Done     int                   `json:"done"`
+	Total    int                   `json:"total"`
+	Started  string                `json:"started"`
+	Finished string                `json:"finished,omitempty"`
+	Error    string                `json:"error,omitempty"`
+	Result   []GlobalPinInfoSerial `json:"result,omitempty"`
+}
+
+// ToSerial converts a Job to its serializable version.
+func (job Job) ToSerial() JobSerial {
+	s := JobSerial{
+		ID:      job.ID,
+		Type:    job.Type,
+		Status:  job.Status,
+		Done:    job.Done,
+		Total:   job.Total,
+		Started: job.Started.UTC().Format(time.RFC3339),
+		Error:   job.Error,
+	}
+	if !job.Finished.IsZero() {
+		s.Finished = job.Finished.UTC().Format(time.RFC3339)
+	}
+	for _, gpi := range job.Result {
+		s.Result = append(s.Result, gpi.ToSerial())
+	}
+	return s
+}
+
+// ToJob converts a JobSerial to its native version.
+func (js JobSerial) ToJob() Job {
+	job := Job{
+		ID:     js.ID,
+		Type:   js.Type,
+		Status: js.Status,
+		Done:   js.Done,
+		Total:  js.Total,
+		Error:  js.Error,
+	}
+	job.Started, _ = time.Parse(time.RFC3339, js.Started)
+	if js.Finished != "" {
+		job.Finished, _ = time.Parse(time.RFC3339, js.Finished)
+	}
+	for _, gpis := range js.Result {
+		job.Result = append(job.Result, gpis.ToGlobalPinInfo())
+	}
+	return job
+}
+
 // Version holds version information
 type Version struct {
 	Version string `json:"Version"`
@@ -257,14 +688,22 @@ type Version struct {
 type IPFSID struct {
 	ID        peer.ID
 	Addresses []ma.Multiaddr
+	// AgentVersion is the version string of the daemon
+	// (i.e. "go-ipfs/0.4.14/").
+	AgentVersion string
+	// Reachable is false when the daemon did not answer, in
+	// which case Error says why.
+	Reachable bool
 	Error     string
 }
 
 // IPFSIDSerial is the serializable IPFSID for RPC requests
 type IPFSIDSerial struct {
-	ID        string           `json:"id"`
-	Addresses MultiaddrsSerial `json:"addresses"`
-	Error     string           `json:"error"`
+	ID           string           `json:"id"`
+	Addresses    MultiaddrsSerial `json:"addresses"`
+	AgentVersion string           `json:"agent_version"`
+	Reachable    bool             `json:"reachable"`
+	Error        string           `json:"error"`
 }
 
 // ToSerial converts IPFSID to a go serializable object
@@ -275,9 +714,11 @@ func (id *IPFSID) ToSerial() IPFSIDSerial {
 	}
 
 	return IPFSIDSerial{
-		ID:        p,
-		Addresses: MultiaddrsToSerial(id.Addresses),
-		Error:     id.Error,
+		ID:           p,
+		Addresses:    MultiaddrsToSerial(id.Addresses),
+		AgentVersion: id.AgentVersion,
+		Reachable:    id.Reachable,
+		Error:        id.Error,
 	}
 }
 
@@ -288,6 +729,8 @@ func (ids *IPFSIDSerial) ToIPFSID() IPFSID {
 		id.ID = pID
 	}
 	id.Addresses = ids.Addresses.ToMultiaddrs()
+	id.AgentVersion = ids.AgentVersion
+	id.Reachable = ids.Reachable
 	id.Error = ids.Error
 	return id
 }
@@ -370,6 +813,469 @@ func deserializeLinkMap(LinksSerial map[string][]string) map[peer.ID][]peer.ID {
 	return Links
 }
 
+// ConsensusState holds information about the consensus layer as seen by
+// a cluster peer: who leads, the current term, how far the state has been
+// applied and which peers take part in votes.
+type ConsensusState struct {
+	Leader       peer.ID
+	Term         uint64
+	AppliedIndex uint64
+	Voters       []peer.ID
+	NonVoters    []peer.ID
+	// HasQuorum is true when the peer has recently been in contact
+	// with a majority of voters: with Raft, when it is the leader or
+	// it has heard from the leader within the heartbeat timeout. A
+	// known Leader alone does not mean that there is quorum, as it
+	// may be stale.
+	HasQuorum bool
+
+	// Metrics observed by this peer since it started. Frequent
+	// leader changes, growing latencies or a large log are signs
+	// of an unstable consensus.
+	CommitIndex      uint64
+	LeaderChanges    uint64
+	LastLeaderChange time.Time
+	CommitLatency    time.Duration // moving average
+	ApplyLatency     time.Duration // moving average
+	Snapshots        uint64
+	LogEntries       uint64 // entries since the last snapshot
+}
+
+// ConsensusStateSerial is the serializable ConsensusState counterpart
+// for RPC requests.
+type ConsensusStateSerial struct {
+	Leader       string   `json:"leader"`
+	Term         uint64   `json:"term"`
+	AppliedIndex uint64   `json:"applied_index"`
+	Voters       []string `json:"voters"`
+	NonVoters    []string `json:"non_voters"`
+	HasQuorum    bool     `json:"has_quorum"`
+
+	CommitIndex      uint64 `json:"commit_index"`
+	LeaderChanges    uint64 `json:"leader_changes"`
+	LastLeaderChange string `json:"last_leader_change,omitempty"`
+	CommitLatency    int64  `json:"commit_latency"` // nanoseconds
+	ApplyLatency     int64  `json:"apply_latency"`  // nanoseconds
+	Snapshots        uint64 `json:"snapshots"`
+	LogEntries       uint64 `json:"log_entries"`
+}
+
+// ToSerial converts a ConsensusState to its Go-serializable version.
+func (cs ConsensusState) ToSerial() ConsensusStateSerial {
+	var leader string
+	if cs.Leader != "" {
+		leader = peer.IDB58Encode(cs.Leader)
+	}
+	var lastLeaderChange string
+	if !cs.LastLeaderChange.IsZero() {
+		lastLeaderChange = cs.LastLeaderChange.UTC().Format(time.RFC3339)
+	}
+	return ConsensusStateSerial{
+		Leader:           leader,
+		Term:             cs.Term,
+		AppliedIndex:     cs.AppliedIndex,
+		Voters:           PeersToStrings(cs.Voters),
+		NonVoters:        PeersToStrings(cs.NonVoters),
+		HasQuorum:        cs.HasQuorum,
+		CommitIndex:      cs.CommitIndex,
+		LeaderChanges:    cs.LeaderChanges,
+		LastLeaderChange: lastLeaderChange,
+		CommitLatency:    int64(cs.CommitLatency),
+		ApplyLatency:     int64(cs.ApplyLatency),
+		Snapshots:        cs.Snapshots,
+		LogEntries:       cs.LogEntries,
+	}
+}
+
+// ToConsensusState converts a ConsensusStateSerial to a ConsensusState.
+func (css ConsensusStateSerial) ToConsensusState() ConsensusState {
+	leader, _ := peer.IDB58Decode(css.Leader)
+	var lastLeaderChange time.Time
+	if css.LastLeaderChange != "" {
+		lastLeaderChange, _ = time.Parse(time.RFC3339, css.LastLeaderChange)
+	}
+	return ConsensusState{
+		Leader:           leader,
+		Term:             css.Term,
+		AppliedIndex:     css.AppliedIndex,
+		Voters:           StringsToPeers(css.Voters),
+		NonVoters:        StringsToPeers(css.NonVoters),
+		HasQuorum:        css.HasQuorum,
+		CommitIndex:      css.CommitIndex,
+		LeaderChanges:    css.LeaderChanges,
+		LastLeaderChange: lastLeaderChange,
+		CommitLatency:    time.Duration(css.CommitLatency),
+		ApplyLatency:     time.Duration(css.ApplyLatency),
+		Snapshots:        css.Snapshots,
+		LogEntries:       css.LogEntries,
+	}
+}
+
+// PeerRotation describes the replacement of the ID of a cluster peer by a
+// new one, i.e. after the peer's private key has been changed.
+type PeerRotation struct {
+	Old peer.ID
+	New peer.ID
+}
+
+// PeerRotationSerial is the serializable PeerRotation counterpart for RPC
+// requests.
+type PeerRotationSerial struct {
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+// ToSerial converts a PeerRotation to its Go-serializable version.
+func (pr PeerRotation) ToSerial() PeerRotationSerial {
+	return PeerRotationSerial{
+		Old: peer.IDB58Encode(pr.Old),
+		New: peer.IDB58Encode(pr.New),
+	}
+}
+
+// ToPeerRotation converts a PeerRotationSerial to a PeerRotation.
+func (prs PeerRotationSerial) ToPeerRotation() PeerRotation {
+	oldID, _ := peer.IDB58Decode(prs.Old)
+	newID, _ := peer.IDB58Decode(prs.New)
+	return PeerRotation{
+		Old: oldID,
+		New: newID,
+	}
+}
+
+// SecretRotation describes the result of rotating the cluster secret:
+// the peers which switched to the new secret and the errors for those
+// which failed to.
+type SecretRotation struct {
+	Updated []peer.ID
+	Failed  map[peer.ID]string
+}
+
+// SecretRotationSerial is the serializable SecretRotation counterpart.
+type SecretRotationSerial struct {
+	Updated []string          `json:"updated"`
+	Failed  map[string]string `json:"failed"`
+}
+
+// ToSerial converts a SecretRotation to its Go-serializable version.
+func (sr SecretRotation) ToSerial() SecretRotationSerial {
+	failed := make(map[string]string)
+	for p, e := range sr.Failed {
+		failed[peer.IDB58Encode(p)] = e
+	}
+	return SecretRotationSerial{
+		Updated: PeersToStrings(sr.Updated),
+		Failed:  failed,
+	}
+}
+
+// ToSecretRotation converts a SecretRotationSerial to a SecretRotation.
+func (srs SecretRotationSerial) ToSecretRotation() SecretRotation {
+	failed := make(map[peer.ID]string)
+	for p, e := range srs.Failed {
+		pid, _ := peer.IDB58Decode(p)
+		failed[pid] = e
+	}
+	return SecretRotation{
+		Updated: StringsToPeers(srs.Updated),
+		Failed:  failed,
+	}
+}
+
+// RPCCallStats counts the RPC requests for a method received from a peer.
+type RPCCallStats struct {
+	Peer   peer.ID
+	Method string
+	Count  uint64
+	Last   time.Time
+}
+
+// RPCCallStatsSerial is the serializable RPCCallStats counterpart.
+type RPCCallStatsSerial struct {
+	Peer   string `json:"peer"`
+	Method string `json:"method"`
+	Count  uint64 `json:"count"`
+	Last   string `json:"last"`
+}
+
+// ToSerial converts a RPCCallStats to its Go-serializable version.
+func (st RPCCallStats) ToSerial() RPCCallStatsSerial {
+	return RPCCallStatsSerial{
+		Peer:   peer.IDB58Encode(st.Peer),
+		Method: st.Method,
+		Count:  st.Count,
+		Last:   st.Last.UTC().Format(time.RFC3339),
+	}
+}
+
+// ToRPCCallStats converts a RPCCallStatsSerial to a RPCCallStats.
+func (sts RPCCallStatsSerial) ToRPCCallStats() RPCCallStats {
+	p, _ := peer.IDB58Decode(sts.Peer)
+	last, _ := time.Parse(time.RFC3339, sts.Last)
+	return RPCCallStats{
+		Peer:   p,
+		Method: sts.Method,
+		Count:  sts.Count,
+		Last:   last,
+	}
+}
+
+// PeerHealth describes the health of a cluster peer as seen by the
+// peer monitor. A peer is Alive while its last heartbeat (ping metric)
+// has not expired. Metrics holds the latest values of the metrics which
+// tell about its health, by name.
+type PeerHealth struct {
+	Peer          peer.ID
+	Alive         bool
+	LastHeartbeat time.Time
+	Metrics       map[string]string
+}
+
+// PeerHealthSerial is the serializable PeerHealth counterpart.
+type PeerHealthSerial struct {
+	Peer          string            `json:"peer"`
+	Alive         bool              `json:"alive"`
+	LastHeartbeat string            `json:"last_heartbeat,omitempty"`
+	Metrics       map[string]string `json:"metrics,omitempty"`
+}
+
+// ToSerial converts a PeerHealth to its Go-serializable version.
+func (ph PeerHealth) ToSerial() PeerHealthSerial {
+	var heartbeat string
+	if !ph.LastHeartbeat.IsZero() {
+		heartbeat = ph.LastHeartbeat.UTC().Format(time.RFC3339)
+	}
+	return PeerHealthSerial{
+		Peer:          peer.IDB58Encode(ph.Peer),
+		Alive:         ph.Alive,
+		LastHeartbeat: heartbeat,
+		Metrics:       ph.Metrics,
+	}
+}
+
+// ToPeerHealth converts a PeerHealthSerial to a PeerHealth.
+func (phs PeerHealthSerial) ToPeerHealth() PeerHealth {
+	p, _ := peer.IDB58Decode(phs.Peer)
+	var heartbeat time.Time
+	if phs.LastHeartbeat != "" {
+		heartbeat, _ = time.Parse(time.RFC3339, phs.LastHeartbeat)
+	}
+	return PeerHealth{
+		Peer:          p,
+		Alive:         phs.Alive,
+		LastHeartbeat: heartbeat,
+		Metrics:       phs.Metrics,
+	}
+}
+
+// ComponentUsage reports the work handled by a subsystem of a peer:
+// the operations or requests in progress, those waiting to be processed
+// and the maximum number of concurrent ones allowed (0 means no limit).
+// Rejected counts the requests refused because the limit was reached.
+type ComponentUsage struct {
+	Component string `json:"component"`
+	Active    int    `json:"active"`
+	Queued    int    `json:"queued"`
+	Limit     int    `json:"limit"`
+	Rejected  uint64 `json:"rejected"`
+}
+
+// ResourceUsage reports the number of goroutines running in a peer and
+// the usage of its subsystems.
+type ResourceUsage struct {
+	Goroutines int              `json:"goroutines"`
+	Components []ComponentUsage `json:"components"`
+}
+
+// StatusSummary counts the items tracked by a peer in each
+// TrackerStatus. Error is set when the peer could not be contacted.
+type StatusSummary struct {
+	Peer   peer.ID
+	Counts map[TrackerStatus]int
+	Error  string
+}
+
+// StatusSummarySerial is the serializable StatusSummary counterpart.
+type StatusSummarySerial struct {
+	Peer   string         `json:"peer"`
+	Counts map[string]int `json:"counts"`
+	Error  string         `json:"error,omitempty"`
+}
+
+// ToSerial converts a StatusSummary to its Go-serializable version.
+func (ss StatusSummary) ToSerial() StatusSummarySerial {
+	counts := make(map[string]int, len(ss.Counts))
+	for st, n := range ss.Counts {
+		counts[st.String()] = n
+	}
+	return StatusSummarySerial{
+		Peer:   peer.IDB58Encode(ss.Peer),
+		Counts: counts,
+		Error:  ss.Error,
+	}
+}
+
+// ToStatusSummary converts a StatusSummarySerial to a StatusSummary.
+func (sss StatusSummarySerial) ToStatusSummary() StatusSummary {
+	p, _ := peer.IDB58Decode(sss.Peer)
+	counts := make(map[TrackerStatus]int, len(sss.Counts))
+	for st, n := range sss.Counts {
+		counts[TrackerStatusFromString(st)] = n
+	}
+	return StatusSummary{
+		Peer:   p,
+		Counts: counts,
+		Error:  sss.Error,
+	}
+}
+
+// OrphanAction indicates what to do with orphan pins: pins which exist
+// on an IPFS daemon but are not part of the cluster state.
+type OrphanAction string
+
+// OrphanAction values
+const (
+	// OrphanActionNone only reports orphan pins.
+	OrphanActionNone OrphanAction = ""
+	// OrphanActionAdopt adds orphan pins to the cluster state.
+	OrphanActionAdopt OrphanAction = "adopt"
+	// OrphanActionRemove unpins orphan pins from the IPFS daemon.
+	OrphanActionRemove OrphanAction = "remove"
+)
+
+// OrphanPins lists the orphan pins found on the IPFS daemon of a peer
+// and the action which was applied to them. Error is set when the
+// peer could not be contacted or the action failed for some pins.
+type OrphanPins struct {
+	Peer    peer.ID
+	Orphans []*cid.Cid
+	Action  OrphanAction
+	Error   string
+}
+
+// OrphanPinsSerial is the serializable OrphanPins counterpart.
+type OrphanPinsSerial struct {
+	Peer    string   `json:"peer"`
+	Orphans []string `json:"orphans"`
+	Action  string   `json:"action,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// ToSerial converts OrphanPins to its Go-serializable version.
+func (op OrphanPins) ToSerial() OrphanPinsSerial {
+	orphans := make([]string, 0, len(op.Orphans))
+	for _, c := range op.Orphans {
+		orphans = append(orphans, c.String())
+	}
+	return OrphanPinsSerial{
+		Peer:    peer.IDB58Encode(op.Peer),
+		Orphans: orphans,
+		Action:  string(op.Action),
+		Error:   op.Error,
+	}
+}
+
+// ToOrphanPins converts an OrphanPinsSerial to OrphanPins.
+// It will ignore any errors when parsing the fields.
+func (ops OrphanPinsSerial) ToOrphanPins() OrphanPins {
+	p, _ := peer.IDB58Decode(ops.Peer)
+	orphans := make([]*cid.Cid, 0, len(ops.Orphans))
+	for _, s := range ops.Orphans {
+		c, err := cid.Decode(s)
+		if err != nil {
+			logger.Debug(s, err)
+			continue
+		}
+		orphans = append(orphans, c)
+	}
+	return OrphanPins{
+		Peer:    p,
+		Orphans: orphans,
+		Action:  OrphanAction(ops.Action),
+		Error:   ops.Error,
+	}
+}
+
+// FailureDomainInfo shows how the peers which have an item pinned are
+// spread across failure domains. Domains are the values of a peer tag
+// (i.e. "region" or "rack", see ID.Tags). Peers without the tag are
+// grouped under the empty domain.
+type FailureDomainInfo struct {
+	Cid     *cid.Cid
+	Domains map[string][]peer.ID
+	// Concentrated is set when several peers have the item pinned but
+	// all of them are in the same domain.
+	Concentrated bool
+}
+
+// FailureDomainInfoSerial is the serializable FailureDomainInfo
+// counterpart.
+type FailureDomainInfoSerial struct {
+	Cid          string              `json:"cid"`
+	Domains      map[string][]string `json:"domains"`
+	Concentrated bool                `json:"concentrated"`
+}
+
+// ToSerial converts a FailureDomainInfo to its Go-serializable version.
+func (fdi FailureDomainInfo) ToSerial() FailureDomainInfoSerial {
+	c := ""
+	if fdi.Cid != nil {
+		c = fdi.Cid.String()
+	}
+	domains := make(map[string][]string, len(fdi.Domains))
+	for d, peers := range fdi.Domains {
+		domains[d] = PeersToStrings(peers)
+	}
+	return FailureDomainInfoSerial{
+		Cid:          c,
+		Domains:      domains,
+		Concentrated: fdi.Concentrated,
+	}
+}
+
+// ToFailureDomainInfo converts a FailureDomainInfoSerial to
+// FailureDomainInfo.
+func (fdis FailureDomainInfoSerial) ToFailureDomainInfo() FailureDomainInfo {
+	c, err := cid.Decode(fdis.Cid)
+	if err != nil {
+		logger.Debug(fdis.Cid, err)
+	}
+	domains := make(map[string][]peer.ID, len(fdis.Domains))
+	for d, peers := range fdis.Domains {
+		domains[d] = StringsToPeers(peers)
+	}
+	return FailureDomainInfo{
+		Cid:          c,
+		Domains:      domains,
+		Concentrated: fdis.Concentrated,
+	}
+}
+
+// StateChecksum summarizes the shared state as seen by a cluster peer,
+// so that it can be compared with the state of other peers. Checksums
+// are only comparable when taken at the same AppliedIndex.
+type StateChecksum struct {
+	Checksum     string `json:"checksum"`
+	AppliedIndex uint64 `json:"applied_index"`
+	Pins         int    `json:"pins"`
+}
+
+// StateRepair describes the result of a state repair operation. Diverged
+// is true when the local state did not match the authoritative one and
+// was replaced by it.
+type StateRepair struct {
+	Diverged      bool          `json:"diverged"`
+	Local         StateChecksum `json:"local"`
+	Authoritative StateChecksum `json:"authoritative"`
+}
+
+// PeerRemoval describes the result of removing a peer. Warnings explain
+// problems which did not prevent the removal, i.e. pins which will be
+// under-replicated.
+type PeerRemoval struct {
+	Warnings []string `json:"warnings,omitempty"`
+}
+
 // SwarmPeers lists an ipfs daemon's peers
 type SwarmPeers []peer.ID
 
@@ -398,6 +1304,19 @@ type ID struct {
 	Error                 string
 	IPFS                  IPFSID
 	Peername              string
+	// LastHeartbeat is the last time a ping metric from this peer
+	// was received by the peer providing the information.
+	LastHeartbeat time.Time
+	// FetchedAt is the time at which this information was obtained
+	// from the peer. It may be old when it is served from a cache.
+	FetchedAt time.Time
+	// Metrics holds, by name, the latest values of the metrics telling
+	// about the health of this peer (i.e. "freespace", "pending"), as
+	// received by the peer providing the information.
+	Metrics map[string]string
+	// Tags describe the peer, i.e. "region": "eu-west" (see
+	// FailureDomainInfo).
+	Tags map[string]string
 	//PublicKey          crypto.PubKey
 }
 
@@ -413,7 +1332,12 @@ type IDSerial struct {
 	Error                 string           `json:"error"`
 	IPFS                  IPFSIDSerial     `json:"ipfs"`
 	Peername              string           `json:"peername"`
+	LastHeartbeat         string           `json:"last_heartbeat,omitempty"`
+	FetchedAt             string           `json:"fetched_at,omitempty"`
 	//PublicKey          []byte
+
+	Metrics map[string]string `json:"metrics,omitempty"`
+	Tags    map[string]string `json:"tags,omitempty"`
 }
 
 // ToSerial converts an ID to its Go-serializable version
@@ -428,6 +1352,15 @@ func (id ID) ToSerial() IDSerial {
 		p = peer.IDB58Encode(id.ID)
 	}
 
+	heartbeat := ""
+	if !id.LastHeartbeat.IsZero() {
+		heartbeat = id.LastHeartbeat.UTC().Format(time.RFC3339)
+	}
+	fetchedAt := ""
+	if !id.FetchedAt.IsZero() {
+		fetchedAt = id.FetchedAt.UTC().Format(time.RFC3339)
+	}
+
 	return IDSerial{
 		ID:                    p,
 		Addresses:             MultiaddrsToSerial(id.Addresses),
@@ -439,7 +1372,11 @@ func (id ID) ToSerial() IDSerial {
 		Error:                 id.Error,
 		IPFS:                  id.IPFS.ToSerial(),
 		Peername:              id.Peername,
+		LastHeartbeat:         heartbeat,
+		FetchedAt:             fetchedAt,
 		//PublicKey:          pkey,
+		Metrics: id.Metrics,
+		Tags:    id.Tags,
 	}
 }
 
@@ -466,9 +1403,53 @@ func (ids IDSerial) ToID() ID {
 	id.Error = ids.Error
 	id.IPFS = ids.IPFS.ToIPFSID()
 	id.Peername = ids.Peername
+	if ids.LastHeartbeat != "" {
+		id.LastHeartbeat, _ = time.Parse(time.RFC3339, ids.LastHeartbeat)
+	}
+	if ids.FetchedAt != "" {
+		id.FetchedAt, _ = time.Parse(time.RFC3339, ids.FetchedAt)
+	}
+	id.Metrics = ids.Metrics
+	id.Tags = ids.Tags
 	return id
 }
 
+// PeersQuery describes which cluster peers should be listed. Peers are
+// sorted by ID, and Offset and Limit allow to paginate them. A Limit
+// of 0 means no limit.
+//
+// When Local is set, only the information known by the peer answering
+// the request (addresses and last heartbeat) is returned, instead of
+// asking every peer for its ID. Otherwise, cached IDs are returned
+// unless Refresh is set.
+type PeersQuery struct {
+	Offset  int  `json:"offset"`
+	Limit   int  `json:"limit"`
+	Local   bool `json:"local"`
+	Refresh bool `json:"refresh"`
+}
+
+// Paginate sorts the given peers and returns those in the page
+// selected by the query.
+func (q PeersQuery) Paginate(peers []peer.ID) []peer.ID {
+	sorted := make([]peer.ID, len(peers))
+	copy(sorted, peers)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+
+	if q.Offset > 0 {
+		if q.Offset >= len(sorted) {
+			return []peer.ID{}
+		}
+		sorted = sorted[q.Offset:]
+	}
+	if q.Limit > 0 && q.Limit < len(sorted) {
+		sorted = sorted[:q.Limit]
+	}
+	return sorted
+}
+
 // MultiaddrSerial is a Multiaddress in a serializable form
 type MultiaddrSerial string
 
@@ -515,6 +1496,12 @@ func (addrsS MultiaddrsSerial) ToMultiaddrs() []ma.Multiaddr {
 	return addrs
 }
 
+// ReplicationFactorEverywhere is the replication factor (min and max) of
+// pins which are allocated to all current and future cluster peers. Such
+// pins have no Allocations: every peer pins them, including peers which
+// join the cluster later.
+const ReplicationFactorEverywhere = -1
+
 // Pin is an argument that carries a Cid. It may carry more things in the
 // future.
 type Pin struct {
@@ -524,6 +1511,42 @@ type Pin struct {
 	ReplicationFactorMin int
 	ReplicationFactorMax int
 	Recursive            bool
+	// Metadata holds arbitrary key-value pairs attached to the pin by
+	// users, which can be used to search for pins (see PinQuery).
+	Metadata map[string]string
+	// Group restricts the allocations of the pin to the members of
+	// the named peer group (as defined in the cluster configuration).
+	Group string
+	// Raw marks pins whose content is not a UnixFS DAG, i.e. raw
+	// blocks or arbitrary IPLD nodes. The size and verification of
+	// these pins relies on "block stat" rather than on UnixFS-specific
+	// calls like "object stat".
+	Raw bool
+	// Timestamp is the time at which the item was first pinned in the
+	// cluster. AddedBy is the authorized publisher which signed that
+	// request, if any. Updating the pin does not modify them.
+	Timestamp time.Time
+	AddedBy   peer.ID
+
+	// Signer and Signature are optionally provided by clients to
+	// prove that a pin or unpin request comes from an authorized
+	// publisher (see Sign()). Nonce and Expires are signed along with
+	// the request and prevent it from being replayed. SignedPayload is
+	// set by the peer which verifies the request to what was signed, so
+	// that it can be verified again once the cluster has set the
+	// replication factors and allocations (see VerifySignedRequest()).
+	// None of them are stored in the shared state.
+	Signer        crypto.PubKey
+	Signature     []byte
+	Nonce         string
+	Expires       time.Time
+	SignedPayload []byte
+
+	// RequestID identifies the API request which triggered a pin or
+	// unpin operation. It travels along with the operation and is
+	// included in the logs of every peer involved, but it is not
+	// stored in the shared state.
+	RequestID string
 }
 
 // PinCid is a shorcut to create a Pin only with a Cid.  Default is for pin to
@@ -538,12 +1561,23 @@ func PinCid(c *cid.Cid) Pin {
 
 // PinSerial is a serializable version of Pin
 type PinSerial struct {
-	Cid                  string   `json:"cid"`
-	Name                 string   `json:"name"`
-	Allocations          []string `json:"allocations"`
-	ReplicationFactorMin int      `json:"replication_factor_min"`
-	ReplicationFactorMax int      `json:"replication_factor_max"`
-	Recursive            bool     `json:"recursive"`
+	Cid                  string            `json:"cid"`
+	Name                 string            `json:"name"`
+	Allocations          []string          `json:"allocations"`
+	ReplicationFactorMin int               `json:"replication_factor_min"`
+	ReplicationFactorMax int               `json:"replication_factor_max"`
+	Recursive            bool              `json:"recursive"`
+	Metadata             map[string]string `json:"metadata,omitempty"`
+	Group                string            `json:"group,omitempty"`
+	Raw                  bool              `json:"raw,omitempty"`
+	Timestamp            string            `json:"timestamp,omitempty"`
+	AddedBy              string            `json:"added_by,omitempty"`
+	Signer               string            `json:"signer,omitempty"`
+	Signature            string            `json:"signature,omitempty"`
+	Nonce                string            `json:"nonce,omitempty"`
+	Expires              string            `json:"expires,omitempty"`
+	SignedPayload        string            `json:"signed_payload,omitempty"`
+	RequestID            string            `json:"request_id,omitempty"`
 }
 
 // ToSerial converts a Pin to PinSerial.
@@ -556,6 +1590,29 @@ func (pin Pin) ToSerial() PinSerial {
 	n := pin.Name
 	allocs := PeersToStrings(pin.Allocations)
 
+	signer := ""
+	if pin.Signer != nil {
+		pkb, err := pin.Signer.Bytes()
+		if err == nil {
+			signer = base64.StdEncoding.EncodeToString(pkb)
+		}
+	}
+
+	ts := ""
+	if !pin.Timestamp.IsZero() {
+		ts = pin.Timestamp.UTC().Format(time.RFC3339)
+	}
+
+	addedBy := ""
+	if pin.AddedBy != "" {
+		addedBy = peer.IDB58Encode(pin.AddedBy)
+	}
+
+	expires := ""
+	if !pin.Expires.IsZero() {
+		expires = pin.Expires.UTC().Format(time.RFC3339Nano)
+	}
+
 	return PinSerial{
 		Cid:                  c,
 		Name:                 n,
@@ -563,12 +1620,34 @@ func (pin Pin) ToSerial() PinSerial {
 		ReplicationFactorMin: pin.ReplicationFactorMin,
 		ReplicationFactorMax: pin.ReplicationFactorMax,
 		Recursive:            pin.Recursive,
+		Metadata:             pin.Metadata,
+		Group:                pin.Group,
+		Raw:                  pin.Raw,
+		Timestamp:            ts,
+		AddedBy:              addedBy,
+		Signer:               signer,
+		Signature:            base64.StdEncoding.EncodeToString(pin.Signature),
+		Nonce:                pin.Nonce,
+		Expires:              expires,
+		SignedPayload:        base64.StdEncoding.EncodeToString(pin.SignedPayload),
+		RequestID:            pin.RequestID,
 	}
 }
 
+// IsPinEverywhere returns true when the pin should be pinned
+// by all cluster peers (see ReplicationFactorEverywhere). Pins
+// restricted to a peer group are pinned everywhere in the group
+// by allocating them to all of its members instead.
+func (pin Pin) IsPinEverywhere() bool {
+	return pin.ReplicationFactorMin == ReplicationFactorEverywhere &&
+		pin.ReplicationFactorMax == ReplicationFactorEverywhere &&
+		pin.Group == ""
+}
+
 // Equals checks if two pins are the same (with the same allocations).
 // If allocations are the same but in different order, they are still
 // considered equivalent.
+// Timestamp, AddedBy and RequestID are not compared.
 func (pin Pin) Equals(pin2 Pin) bool {
 	pin1s := pin.ToSerial()
 	pin2s := pin2.ToSerial()
@@ -585,6 +1664,14 @@ func (pin Pin) Equals(pin2 Pin) bool {
 		return false
 	}
 
+	if pin1s.Group != pin2s.Group {
+		return false
+	}
+
+	if pin1s.Raw != pin2s.Raw {
+		return false
+	}
+
 	sort.Strings(pin1s.Allocations)
 	sort.Strings(pin2s.Allocations)
 
@@ -599,6 +1686,15 @@ func (pin Pin) Equals(pin2 Pin) bool {
 	if pin1s.ReplicationFactorMin != pin2s.ReplicationFactorMin {
 		return false
 	}
+
+	if len(pin1s.Metadata) != len(pin2s.Metadata) {
+		return false
+	}
+	for k, v := range pin1s.Metadata {
+		if v2, ok := pin2s.Metadata[k]; !ok || v != v2 {
+			return false
+		}
+	}
 	return true
 }
 
@@ -609,6 +1705,57 @@ func (pins PinSerial) ToPin() Pin {
 		logger.Debug(pins.Cid, err)
 	}
 
+	var signer crypto.PubKey
+	if pins.Signer != "" {
+		pkb, err := base64.StdEncoding.DecodeString(pins.Signer)
+		if err == nil {
+			signer, err = crypto.UnmarshalPublicKey(pkb)
+		}
+		if err != nil {
+			logger.Debug(pins.Signer, err)
+		}
+	}
+
+	var sig []byte
+	if pins.Signature != "" {
+		sig, err = base64.StdEncoding.DecodeString(pins.Signature)
+		if err != nil {
+			logger.Debug(pins.Signature, err)
+		}
+	}
+
+	var payload []byte
+	if pins.SignedPayload != "" {
+		payload, err = base64.StdEncoding.DecodeString(pins.SignedPayload)
+		if err != nil {
+			logger.Debug(pins.SignedPayload, err)
+		}
+	}
+
+	var expires time.Time
+	if pins.Expires != "" {
+		expires, err = time.Parse(time.RFC3339Nano, pins.Expires)
+		if err != nil {
+			logger.Debug(pins.Expires, err)
+		}
+	}
+
+	var ts time.Time
+	if pins.Timestamp != "" {
+		ts, err = time.Parse(time.RFC3339, pins.Timestamp)
+		if err != nil {
+			logger.Debug(pins.Timestamp, err)
+		}
+	}
+
+	var addedBy peer.ID
+	if pins.AddedBy != "" {
+		addedBy, err = peer.IDB58Decode(pins.AddedBy)
+		if err != nil {
+			logger.Debug(pins.AddedBy, err)
+		}
+	}
+
 	return Pin{
 		Cid:                  c,
 		Name:                 pins.Name,
@@ -616,9 +1763,465 @@ func (pins PinSerial) ToPin() Pin {
 		ReplicationFactorMin: pins.ReplicationFactorMin,
 		ReplicationFactorMax: pins.ReplicationFactorMax,
 		Recursive:            pins.Recursive,
+		Metadata:             pins.Metadata,
+		Group:                pins.Group,
+		Raw:                  pins.Raw,
+		Timestamp:            ts,
+		AddedBy:              addedBy,
+		Signer:               signer,
+		Signature:            sig,
+		Nonce:                pins.Nonce,
+		Expires:              expires,
+		SignedPayload:        payload,
+		RequestID:            pins.RequestID,
 	}
 }
 
+// StripRequest returns a copy of the pin without the fields which
+// describe the request that produced it rather than the pin itself
+// (RequestID and the signature fields). They are not part of the shared
+// state.
+func (pin Pin) StripRequest() Pin {
+	pin.RequestID = ""
+	pin.Signer = nil
+	pin.Signature = nil
+	pin.Nonce = ""
+	pin.Expires = time.Time{}
+	pin.SignedPayload = nil
+	return pin
+}
+
+// PinQuery describes a search for pins in the shared state. Name and
+// Metadata values match exactly, unless they end in "*", in which case
+// they match values with the preceding prefix (i.e. "backup-2017-*").
+// Pins must match the Name (when set) and every Metadata entry.
+//
+// Results are sorted by name and Cid. Offset and Limit allow to
+// paginate them. A Limit of 0 means no limit.
+type PinQuery struct {
+	Name     string            `json:"name"`
+	Metadata map[string]string `json:"metadata"`
+	Offset   int               `json:"offset"`
+	Limit    int               `json:"limit"`
+}
+
+// Matches returns true if the given Pin matches the Name and Metadata
+// conditions of the query.
+func (q PinQuery) Matches(pin Pin) bool {
+	if q.Name != "" && !matchPattern(q.Name, pin.Name) {
+		return false
+	}
+	for k, pattern := range q.Metadata {
+		v, ok := pin.Metadata[k]
+		if !ok || !matchPattern(pattern, v) {
+			return false
+		}
+	}
+	return true
+}
+
+// Search returns the pins matching the query, sorted and paginated.
+func (q PinQuery) Search(pins []Pin) []Pin {
+	found := make([]Pin, 0)
+	for _, p := range pins {
+		if q.Matches(p) {
+			found = append(found, p)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].Name != found[j].Name {
+			return found[i].Name < found[j].Name
+		}
+		return found[i].Cid.String() < found[j].Cid.String()
+	})
+
+	if q.Offset > 0 {
+		if q.Offset >= len(found) {
+			return []Pin{}
+		}
+		found = found[q.Offset:]
+	}
+	if q.Limit > 0 && q.Limit < len(found) {
+		found = found[:q.Limit]
+	}
+	return found
+}
+
+// IsEmpty returns true when the query has no Name nor Metadata
+// conditions, or when all of them are empty or only a wildcard ("*"),
+// so that it would match every pin (or every pin with some metadata key).
+func (q PinQuery) IsEmpty() bool {
+	if isSelective(q.Name) {
+		return false
+	}
+	for _, pattern := range q.Metadata {
+		if isSelective(pattern) {
+			return false
+		}
+	}
+	return true
+}
+
+// isSelective returns true when a pattern has a non-empty value or
+// prefix to match.
+func isSelective(pattern string) bool {
+	return strings.TrimSuffix(pattern, "*") != ""
+}
+
+// BulkUnpin describes a request to unpin all the pins matching a query.
+// When DryRun is set, the matching pins are reported but not removed.
+type BulkUnpin struct {
+	Query  PinQuery `json:"query"`
+	DryRun bool     `json:"dry_run"`
+}
+
+// SharedConfig holds cluster-wide settings which are stored in the
+// shared state, so that every peer uses the same values. Zero values
+// mean that each peer uses the value from its own configuration.
+type SharedConfig struct {
+	// Default replication factors for pins which do not set them.
+	ReplicationFactorMin int
+	ReplicationFactorMax int
+	// Allocator names the allocator used to sort candidate peers:
+	// "ascend" or "descend" sort them by the metrics of the local
+	// informer, while "rendezvous" hashes the CID with the peer IDs.
+	Allocator string
+	// StateSyncInterval is how often peers perform a StateSync().
+	StateSyncInterval time.Duration
+	// Paused stops every peer from performing new IPFS pin and
+	// unpin operations. Pins and unpins are still added to the state.
+	Paused bool
+}
+
+// SharedConfigSerial is a serializable version of SharedConfig.
+type SharedConfigSerial struct {
+	ReplicationFactorMin int    `json:"replication_factor_min,omitempty"`
+	ReplicationFactorMax int    `json:"replication_factor_max,omitempty"`
+	Allocator            string `json:"allocator,omitempty"`
+	StateSyncInterval    string `json:"state_sync_interval,omitempty"`
+	Paused               bool   `json:"paused,omitempty"`
+}
+
+// ToSerial converts a SharedConfig to its Go-serializable version.
+func (cfg SharedConfig) ToSerial() SharedConfigSerial {
+	var interval string
+	if cfg.StateSyncInterval != 0 {
+		interval = cfg.StateSyncInterval.String()
+	}
+	return SharedConfigSerial{
+		ReplicationFactorMin: cfg.ReplicationFactorMin,
+		ReplicationFactorMax: cfg.ReplicationFactorMax,
+		Allocator:            cfg.Allocator,
+		StateSyncInterval:    interval,
+		Paused:               cfg.Paused,
+	}
+}
+
+// ToSharedConfig converts a SharedConfigSerial to its native form.
+// Invalid durations are ignored.
+func (cfgs SharedConfigSerial) ToSharedConfig() SharedConfig {
+	interval, _ := time.ParseDuration(cfgs.StateSyncInterval)
+	return SharedConfig{
+		ReplicationFactorMin: cfgs.ReplicationFactorMin,
+		ReplicationFactorMax: cfgs.ReplicationFactorMax,
+		Allocator:            cfgs.Allocator,
+		StateSyncInterval:    interval,
+		Paused:               cfgs.Paused,
+	}
+}
+
+// Names of the SharedConfig settings, as used in SharedConfigUpdate.
+const (
+	SharedConfigReplicationFactorMin = "replication_factor_min"
+	SharedConfigReplicationFactorMax = "replication_factor_max"
+	SharedConfigAllocator            = "allocator"
+	SharedConfigStateSyncInterval    = "state_sync_interval"
+	SharedConfigPaused               = "paused"
+)
+
+// SharedConfigFields lists the names of all the SharedConfig settings.
+var SharedConfigFields = []string{
+	SharedConfigReplicationFactorMin,
+	SharedConfigReplicationFactorMax,
+	SharedConfigAllocator,
+	SharedConfigStateSyncInterval,
+	SharedConfigPaused,
+}
+
+// SharedConfigUpdate describes a modification of the shared
+// configuration. Only the settings named in Fields are taken from Config,
+// while the rest keep their current values. An update without Fields
+// replaces the whole configuration.
+type SharedConfigUpdate struct {
+	Config SharedConfig
+	Fields []string
+}
+
+// SharedConfigUpdateSerial is a serializable version of SharedConfigUpdate.
+type SharedConfigUpdateSerial struct {
+	Config SharedConfigSerial `json:"config"`
+	Fields []string           `json:"fields,omitempty"`
+}
+
+// ToSerial converts a SharedConfigUpdate to its Go-serializable version.
+func (u SharedConfigUpdate) ToSerial() SharedConfigUpdateSerial {
+	return SharedConfigUpdateSerial{
+		Config: u.Config.ToSerial(),
+		Fields: u.Fields,
+	}
+}
+
+// ToSharedConfigUpdate converts a SharedConfigUpdateSerial to its
+// native form.
+func (us SharedConfigUpdateSerial) ToSharedConfigUpdate() SharedConfigUpdate {
+	return SharedConfigUpdate{
+		Config: us.Config.ToSharedConfig(),
+		Fields: us.Fields,
+	}
+}
+
+// Merge returns the configuration resulting from applying the
+// given update to cfg. Unknown field names are ignored.
+func (cfg SharedConfig) Merge(u SharedConfigUpdate) SharedConfig {
+	if len(u.Fields) == 0 {
+		return u.Config
+	}
+	for _, f := range u.Fields {
+		switch f {
+		case SharedConfigReplicationFactorMin:
+			cfg.ReplicationFactorMin = u.Config.ReplicationFactorMin
+		case SharedConfigReplicationFactorMax:
+			cfg.ReplicationFactorMax = u.Config.ReplicationFactorMax
+		case SharedConfigAllocator:
+			cfg.Allocator = u.Config.Allocator
+		case SharedConfigStateSyncInterval:
+			cfg.StateSyncInterval = u.Config.StateSyncInterval
+		case SharedConfigPaused:
+			cfg.Paused = u.Config.Paused
+		}
+	}
+	return cfg
+}
+
+// Faults describes the failure conditions simulated by a peer, when
+// fault injection is enabled in its configuration. They are meant to
+// rehearse failure handling and verify alerting in staging clusters.
+type Faults struct {
+	// IPFSErrors makes IPFS pin, unpin and pin ls requests fail.
+	IPFSErrors bool
+	// RPCDelay delays every RPC request received from other peers.
+	RPCDelay time.Duration
+	// DropMetrics stops the peer from publishing its metrics (including
+	// the ping heartbeats), so that other peers consider it down.
+	DropMetrics bool
+	// Duration is how long the faults last. Until is set by the peer to
+	// the time when they are cleared automatically.
+	Duration time.Duration
+	Until    time.Time
+}
+
+// FaultsSerial is a serializable version of Faults.
+type FaultsSerial struct {
+	IPFSErrors  bool   `json:"ipfs_errors"`
+	RPCDelay    string `json:"rpc_delay,omitempty"`
+	DropMetrics bool   `json:"drop_metrics"`
+	Duration    string `json:"duration,omitempty"`
+	Until       string `json:"until,omitempty"`
+}
+
+// Active returns true when any fault is being simulated.
+func (f Faults) Active() bool {
+	return f.IPFSErrors || f.RPCDelay > 0 || f.DropMetrics
+}
+
+// ToSerial converts Faults to its Go-serializable version.
+func (f Faults) ToSerial() FaultsSerial {
+	fs := FaultsSerial{
+		IPFSErrors:  f.IPFSErrors,
+		DropMetrics: f.DropMetrics,
+	}
+	if f.RPCDelay != 0 {
+		fs.RPCDelay = f.RPCDelay.String()
+	}
+	if f.Duration != 0 {
+		fs.Duration = f.Duration.String()
+	}
+	if !f.Until.IsZero() {
+		fs.Until = f.Until.UTC().Format(time.RFC3339)
+	}
+	return fs
+}
+
+// ToFaults converts a FaultsSerial to its native form. Invalid
+// durations are ignored.
+func (fs FaultsSerial) ToFaults() Faults {
+	delay, _ := time.ParseDuration(fs.RPCDelay)
+	duration, _ := time.ParseDuration(fs.Duration)
+	until, _ := time.Parse(time.RFC3339, fs.Until)
+	return Faults{
+		IPFSErrors:  fs.IPFSErrors,
+		RPCDelay:    delay,
+		DropMetrics: fs.DropMetrics,
+		Duration:    duration,
+		Until:       until,
+	}
+}
+
+func matchPattern(pattern, value string) bool {
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(value, strings.TrimSuffix(pattern, "*"))
+	}
+	return pattern == value
+}
+
+// Operations which can be signed by pin publishers.
+const (
+	PinOpPin   = "pin"
+	PinOpUnpin = "unpin"
+)
+
+// DefaultSignatureValidity is how long signed requests are valid for
+// when Sign() is used on a pin without Expires.
+var DefaultSignatureValidity = 5 * time.Minute
+
+// MaxSignatureValidity is the longest time before their expiry for which
+// signed requests are accepted. It bounds how long peers need to remember
+// the nonces of the requests they have seen.
+var MaxSignatureValidity = time.Hour
+
+// signedRequest is what publishers sign to authorize an operation with a
+// pin. Unpin operations only cover the Cid, Nonce and Expires.
+type signedRequest struct {
+	Op                   string            `json:"op"`
+	Cid                  string            `json:"cid"`
+	Name                 string            `json:"name,omitempty"`
+	Allocations          []string          `json:"allocations,omitempty"`
+	ReplicationFactorMin int               `json:"replication_factor_min,omitempty"`
+	ReplicationFactorMax int               `json:"replication_factor_max,omitempty"`
+	Recursive            bool              `json:"recursive,omitempty"`
+	Metadata             map[string]string `json:"metadata,omitempty"`
+	Group                string            `json:"group,omitempty"`
+	Raw                  bool              `json:"raw,omitempty"`
+	Nonce                string            `json:"nonce"`
+	Expires              string            `json:"expires"`
+}
+
+// signedRequest returns the request to be signed to authorize the given
+// operation with this pin.
+func (pin Pin) signedRequest(op string) signedRequest {
+	req := signedRequest{
+		Op:      op,
+		Nonce:   pin.Nonce,
+		Expires: pin.Expires.UTC().Format(time.RFC3339Nano),
+	}
+	if pin.Cid != nil {
+		req.Cid = CanonicalCid(pin.Cid).String()
+	}
+	if op == PinOpUnpin {
+		return req
+	}
+
+	allocs := PeersToStrings(pin.Allocations)
+	sort.Strings(allocs)
+	req.Name = pin.Name
+	req.Allocations = allocs
+	req.ReplicationFactorMin = pin.ReplicationFactorMin
+	req.ReplicationFactorMax = pin.ReplicationFactorMax
+	req.Recursive = pin.Recursive
+	req.Metadata = pin.Metadata
+	req.Group = pin.Group
+	req.Raw = pin.Raw
+	return req
+}
+
+// SigningPayload returns the bytes that are signed by a publisher to
+// authorize the given operation with this pin. They cover every field
+// set by the requester. The encoding is deterministic, as JSON objects
+// have their keys sorted.
+func (pin Pin) SigningPayload(op string) []byte {
+	payload, _ := json.Marshal(pin.signedRequest(op))
+	return payload
+}
+
+// Sign returns a copy of the pin with the Signer and Signature fields
+// set, authorizing the given operation (PinOpPin or PinOpUnpin) with it.
+// A random Nonce is set when empty, and Expires is set to
+// DefaultSignatureValidity from now when unset.
+func (pin Pin) Sign(op string, priv crypto.PrivKey) (Pin, error) {
+	if pin.Nonce == "" {
+		pin.Nonce = NewRequestID()
+	}
+	if pin.Expires.IsZero() {
+		pin.Expires = time.Now().Add(DefaultSignatureValidity)
+	}
+	sig, err := priv.Sign(pin.SigningPayload(op))
+	if err != nil {
+		return pin, err
+	}
+	pin.Signer = priv.GetPublic()
+	pin.Signature = sig
+	return pin, nil
+}
+
+// VerifySignature checks that the pin carries a valid, unexpired
+// signature for the given operation and returns the peer ID corresponding
+// to the signer. Every signed field must be as it was signed. Checking
+// that the Nonce has not been used before is up to the caller.
+func (pin Pin) VerifySignature(op string) (peer.ID, error) {
+	return pin.verify(pin.SigningPayload(op))
+}
+
+// VerifySignedRequest works like VerifySignature, but checks the
+// signature against SignedPayload. The pin must match the signed request,
+// except for the replication factors and the allocations, which are set
+// by the cluster once the request has been verified.
+func (pin Pin) VerifySignedRequest(op string) (peer.ID, error) {
+	if len(pin.SignedPayload) == 0 {
+		return "", errors.New("the request is not signed")
+	}
+	var signed signedRequest
+	err := json.Unmarshal(pin.SignedPayload, &signed)
+	if err != nil {
+		return "", fmt.Errorf("bad signed request: %s", err)
+	}
+
+	// Compare with the fields of the request as it was signed
+	req := pin.signedRequest(op)
+	req.Allocations = signed.Allocations
+	req.ReplicationFactorMin = signed.ReplicationFactorMin
+	req.ReplicationFactorMax = signed.ReplicationFactorMax
+	payload, _ := json.Marshal(req)
+	if !bytes.Equal(payload, pin.SignedPayload) {
+		return "", errors.New("the pin does not match the signed request")
+	}
+	return pin.verify(pin.SignedPayload)
+}
+
+func (pin Pin) verify(payload []byte) (peer.ID, error) {
+	if pin.Signer == nil || len(pin.Signature) == 0 {
+		return "", errors.New("the request is not signed")
+	}
+	if pin.Nonce == "" {
+		return "", errors.New("the signed request has no nonce")
+	}
+	now := time.Now()
+	if !pin.Expires.After(now) {
+		return "", errors.New("the signed request has expired")
+	}
+	if pin.Expires.After(now.Add(MaxSignatureValidity)) {
+		return "", fmt.Errorf("signed requests cannot be valid for longer than %s", MaxSignatureValidity)
+	}
+
+	ok, err := pin.Signer.Verify(payload, pin.Signature)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", errors.New("invalid signature")
+	}
+	return peer.IDFromPublicKey(pin.Signer)
+}
+
 // Metric transports information about a peer.ID. It is used to decide
 // pin allocations by a PinAllocator. IPFS cluster is agnostic to
 // the Value, which should be interpreted by the PinAllocator.
@@ -628,6 +2231,14 @@ type Metric struct {
 	Value  string
 	Expire int64 // UnixNano
 	Valid  bool  // if the metric is not valid it will be discarded
+	// Unallocatable is set by informers when the peer should not
+	// receive new allocations (i.e. it is running out of disk space).
+	Unallocatable bool
+	Received      int64 // UnixNano. Filled-in by the monitor.
+	// Weight scales the value of the metric when allocators sort
+	// peers, making the peer more attractive (> 1) or less attractive
+	// (< 1). 0 means no weight. Filled-in by Cluster.
+	Weight float64
 }
 
 // SetTTL sets Metric to expire after the given time.Duration
@@ -653,6 +2264,56 @@ func (m *Metric) Discard() bool {
 	return !m.Valid || m.Expired()
 }
 
+// MetricSerial is the serializable Metric counterpart.
+type MetricSerial struct {
+	Name          string  `json:"name"`
+	Peer          string  `json:"peer"`
+	Value         string  `json:"value"`
+	Expire        string  `json:"expire"`
+	Valid         bool    `json:"valid"`
+	Unallocatable bool    `json:"unallocatable,omitempty"`
+	Received      string  `json:"received,omitempty"`
+	Weight        float64 `json:"weight,omitempty"`
+}
+
+// ToSerial converts a Metric to its Go-serializable version.
+func (m Metric) ToSerial() MetricSerial {
+	var received string
+	if m.Received > 0 {
+		received = time.Unix(0, m.Received).UTC().Format(time.RFC3339)
+	}
+	return MetricSerial{
+		Name:          m.Name,
+		Peer:          peer.IDB58Encode(m.Peer),
+		Value:         m.Value,
+		Expire:        time.Unix(0, m.Expire).UTC().Format(time.RFC3339),
+		Valid:         m.Valid,
+		Unallocatable: m.Unallocatable,
+		Received:      received,
+		Weight:        m.Weight,
+	}
+}
+
+// ToMetric converts a MetricSerial to a Metric.
+func (ms MetricSerial) ToMetric() Metric {
+	p, _ := peer.IDB58Decode(ms.Peer)
+	m := Metric{
+		Name:          ms.Name,
+		Peer:          p,
+		Value:         ms.Value,
+		Valid:         ms.Valid,
+		Unallocatable: ms.Unallocatable,
+		Weight:        ms.Weight,
+	}
+	if expire, err := time.Parse(time.RFC3339, ms.Expire); err == nil {
+		m.Expire = expire.UnixNano()
+	}
+	if received, err := time.Parse(time.RFC3339, ms.Received); err == nil {
+		m.Received = received.UnixNano()
+	}
+	return m
+}
+
 // Alert carries alerting information about a peer. WIP.
 type Alert struct {
 	Peer       peer.ID
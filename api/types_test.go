@@ -6,6 +6,7 @@ import (
 	"time"
 
 	cid "github.com/ipfs/go-cid"
+	crypto "github.com/libp2p/go-libp2p-crypto"
 	peer "github.com/libp2p/go-libp2p-peer"
 	ma "github.com/multiformats/go-multiaddr"
 )
@@ -55,8 +56,15 @@ func TestGlobalPinInfoConv(t *testing.T) {
 				Peer:   testPeerID1,
 				Status: TrackerStatusPinned,
 				TS:     testTime,
+
+				IPFSPinStatus: IPFSPinStatusDirect,
+				History: []StatusChange{
+					{Status: TrackerStatusPinError, TS: testTime, Error: "an error"},
+					{Status: TrackerStatusPinned, TS: testTime},
+				},
 			},
 		},
+		Timestamp: testTime,
 	}
 
 	newgpi := gpi.ToSerial().ToGlobalPinInfo()
@@ -70,6 +78,29 @@ func TestGlobalPinInfoConv(t *testing.T) {
 	if !gpi.PeerMap[testPeerID1].TS.Equal(newgpi.PeerMap[testPeerID1].TS) {
 		t.Error("bad time")
 	}
+
+	if newgpi.PeerMap[testPeerID1].IPFSPinStatus != IPFSPinStatusDirect {
+		t.Error("bad ipfs pin status")
+	}
+
+	if !reflect.DeepEqual(gpi.PeerMap[testPeerID1].History, newgpi.PeerMap[testPeerID1].History) {
+		t.Error("bad status history")
+	}
+
+	if !gpi.Timestamp.Equal(newgpi.Timestamp) {
+		t.Error("bad pin timestamp")
+	}
+
+	if newgpi.Allocations != nil || gpi.ToSerial().Match != nil {
+		t.Error("allocation information should not be set")
+	}
+
+	gpi.Allocations = []peer.ID{testPeerID1, testPeerID2}
+	gpi.PinnedBy = []peer.ID{testPeerID1}
+	newgpi = gpi.ToSerial().ToGlobalPinInfo()
+	if len(newgpi.Allocations) != 2 || len(newgpi.PinnedBy) != 1 || newgpi.Match {
+		t.Error("bad allocation information")
+	}
 }
 
 func TestIDConv(t *testing.T) {
@@ -89,10 +120,15 @@ func TestIDConv(t *testing.T) {
 		RPCProtocolVersion:    "testp",
 		Error:                 "teste",
 		IPFS: IPFSID{
-			ID:        testPeerID2,
-			Addresses: []ma.Multiaddr{testMAddr3},
-			Error:     "abc",
+			ID:           testPeerID2,
+			Addresses:    []ma.Multiaddr{testMAddr3},
+			AgentVersion: "go-ipfs/0.4.14/",
+			Reachable:    true,
+			Error:        "abc",
 		},
+		LastHeartbeat: testTime,
+		FetchedAt:     testTime,
+		Metrics:       map[string]string{"pending": "3"},
 	}
 
 	newid := id.ToSerial().ToID()
@@ -130,6 +166,82 @@ func TestIDConv(t *testing.T) {
 	if id.IPFS.Error != newid.IPFS.Error {
 		t.Error("ipfs error mismatch")
 	}
+	if newid.IPFS.AgentVersion != "go-ipfs/0.4.14/" || !newid.IPFS.Reachable {
+		t.Error("ipfs agent version or reachability mismatch")
+	}
+	if !id.LastHeartbeat.Equal(newid.LastHeartbeat) {
+		t.Error("mismatching last heartbeat")
+	}
+	if !id.FetchedAt.Equal(newid.FetchedAt) {
+		t.Error("mismatching fetched at")
+	}
+	if newid.Metrics["pending"] != "3" {
+		t.Error("mismatching metrics")
+	}
+	if (ID{}).ToSerial().LastHeartbeat != "" {
+		t.Error("a zero last heartbeat should not be serialized")
+	}
+}
+
+func TestPinIsPinEverywhere(t *testing.T) {
+	pin := PinCid(testCid1)
+	if pin.IsPinEverywhere() {
+		t.Error("pins with default factors are not pinned everywhere")
+	}
+	pin.ReplicationFactorMin = ReplicationFactorEverywhere
+	pin.ReplicationFactorMax = ReplicationFactorEverywhere
+	if !pin.IsPinEverywhere() {
+		t.Error("the pin should be pinned everywhere")
+	}
+	pin.ReplicationFactorMax = 2
+	if pin.IsPinEverywhere() {
+		t.Error("both factors must be set to everywhere")
+	}
+	pin.ReplicationFactorMax = ReplicationFactorEverywhere
+	pin.Group = "ssd-tier"
+	if pin.IsPinEverywhere() {
+		t.Error("pins in a group are not pinned everywhere")
+	}
+}
+
+func TestOrphanPinsConv(t *testing.T) {
+	op := OrphanPins{
+		Peer:    testPeerID1,
+		Orphans: []*cid.Cid{testCid1},
+		Action:  OrphanActionAdopt,
+		Error:   "an error",
+	}
+	newop := op.ToSerial().ToOrphanPins()
+	if !reflect.DeepEqual(op, newop) {
+		t.Error("orphan pins did not survive the conversion")
+	}
+}
+
+func TestPeersQueryPaginate(t *testing.T) {
+	peers := []peer.ID{testPeerID2, testPeerID1}
+
+	page := PeersQuery{}.Paginate(peers)
+	if len(page) != 2 || page[0] != testPeerID1 || page[1] != testPeerID2 {
+		t.Error("peers should be sorted by ID")
+	}
+	if peers[0] != testPeerID2 {
+		t.Error("the original slice should not be modified")
+	}
+
+	page = PeersQuery{Offset: 1, Limit: 5}.Paginate(peers)
+	if len(page) != 1 || page[0] != testPeerID2 {
+		t.Error("bad offset")
+	}
+
+	page = PeersQuery{Limit: 1}.Paginate(peers)
+	if len(page) != 1 || page[0] != testPeerID1 {
+		t.Error("bad limit")
+	}
+
+	page = PeersQuery{Offset: 2}.Paginate(peers)
+	if page == nil || len(page) != 0 {
+		t.Error("expected an empty page")
+	}
 }
 
 func TestConnectGraphConv(t *testing.T) {
@@ -176,6 +288,96 @@ func TestMultiaddrConv(t *testing.T) {
 	}
 }
 
+func TestPinDetailConv(t *testing.T) {
+	pin := PinCid(testCid1)
+	pd := PinDetail{
+		GlobalPinInfo: GlobalPinInfo{
+			Cid: testCid1,
+			PeerMap: map[peer.ID]PinInfo{
+				testPeerID1: {
+					Cid:    testCid1,
+					Peer:   testPeerID1,
+					Status: TrackerStatusPinned,
+					TS:     testTime,
+				},
+			},
+		},
+		Pin: &pin,
+	}
+
+	newpd := pd.ToSerial().ToPinDetail()
+	if !newpd.Cid.Equals(testCid1) ||
+		newpd.PeerMap[testPeerID1].Status != TrackerStatusPinned {
+		t.Error("mismatching status")
+	}
+	if newpd.Pin == nil || !newpd.Pin.Equals(pin) {
+		t.Error("mismatching pin")
+	}
+
+	pd.Pin = nil
+	newpd = pd.ToSerial().ToPinDetail()
+	if newpd.Pin != nil {
+		t.Error("expected no pin")
+	}
+}
+
+func TestDAGStatsConv(t *testing.T) {
+	ds := DAGStats{
+		Cid:              testCid1,
+		Peer:             testPeerID1,
+		Blocks:           3,
+		TotalSize:        300,
+		Depth:            2,
+		LargestBlock:     testCid1,
+		LargestBlockSize: 200,
+	}
+
+	newds := ds.ToSerial().ToDAGStats()
+	if !newds.Cid.Equals(ds.Cid) ||
+		newds.Peer != ds.Peer ||
+		newds.Blocks != 3 ||
+		newds.TotalSize != 300 ||
+		newds.Depth != 2 ||
+		!newds.LargestBlock.Equals(testCid1) ||
+		newds.LargestBlockSize != 200 {
+		t.Error("mismatch")
+	}
+}
+
+func TestJobConv(t *testing.T) {
+	job := Job{
+		ID:       "abcd",
+		Type:     JobTypeRecoverAll,
+		Status:   JobStatusDone,
+		Done:     1,
+		Total:    1,
+		Started:  testTime,
+		Finished: testTime,
+		Result: []GlobalPinInfo{
+			{
+				Cid:     testCid1,
+				PeerMap: map[peer.ID]PinInfo{},
+			},
+		},
+	}
+
+	newjob := job.ToSerial().ToJob()
+	if newjob.ID != "abcd" ||
+		newjob.Type != JobTypeRecoverAll ||
+		newjob.Status != JobStatusDone ||
+		newjob.Done != 1 || newjob.Total != 1 ||
+		!newjob.Started.Equal(testTime) ||
+		!newjob.Finished.Equal(testTime) ||
+		len(newjob.Result) != 1 || !newjob.Result[0].Cid.Equals(testCid1) {
+		t.Error("mismatch")
+	}
+
+	job.Finished = time.Time{}
+	if job.ToSerial().Finished != "" {
+		t.Error("running jobs should have no finish time")
+	}
+}
+
 func TestPinConv(t *testing.T) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -188,17 +390,241 @@ func TestPinConv(t *testing.T) {
 		Allocations:          []peer.ID{testPeerID1},
 		ReplicationFactorMax: -1,
 		ReplicationFactorMin: -1,
+		Metadata:             map[string]string{"a": "b"},
+		Group:                "ssd-tier",
+		Raw:                  true,
+		Timestamp:            testTime,
+		AddedBy:              testPeerID2,
+		RequestID:            "abcd",
 	}
 
 	newc := c.ToSerial().ToPin()
 	if c.Cid.String() != newc.Cid.String() ||
 		c.Allocations[0] != newc.Allocations[0] ||
 		c.ReplicationFactorMin != newc.ReplicationFactorMin ||
-		c.ReplicationFactorMax != newc.ReplicationFactorMax {
+		c.ReplicationFactorMax != newc.ReplicationFactorMax ||
+		newc.Metadata["a"] != "b" ||
+		newc.Group != "ssd-tier" ||
+		!newc.Raw ||
+		!newc.Timestamp.Equal(testTime) ||
+		newc.AddedBy != testPeerID2 ||
+		newc.RequestID != "abcd" {
 		t.Error("mismatch")
 	}
 }
 
+func TestPinQuery(t *testing.T) {
+	c2, _ := cid.Decode("QmP63DkAFEnDYNjDYBpyNDfttu1fvUw99x1brscPzpqmma")
+	c3, _ := cid.Decode("QmP63DkAFEnDYNjDYBpyNDfttu1fvUw99x1brscPzpqmmb")
+	pins := []Pin{
+		{Cid: c3, Name: "other"},
+		{Cid: c2, Name: "backup-2017-02", Metadata: map[string]string{"tenant": "b"}},
+		{Cid: testCid1, Name: "backup-2017-01", Metadata: map[string]string{"tenant": "a"}},
+	}
+
+	res := PinQuery{Name: "backup-2017-*"}.Search(pins)
+	if len(res) != 2 || res[0].Name != "backup-2017-01" || res[1].Name != "backup-2017-02" {
+		t.Error("prefix search by name failed:", res)
+	}
+
+	res = PinQuery{Name: "backup-2017"}.Search(pins)
+	if len(res) != 0 {
+		t.Error("exact search should not match prefixes")
+	}
+
+	res = PinQuery{Name: "other"}.Search(pins)
+	if len(res) != 1 || !res[0].Cid.Equals(c3) {
+		t.Error("exact search by name failed")
+	}
+
+	res = PinQuery{Metadata: map[string]string{"tenant": "b"}}.Search(pins)
+	if len(res) != 1 || !res[0].Cid.Equals(c2) {
+		t.Error("search by metadata failed")
+	}
+
+	res = PinQuery{Name: "backup-*", Metadata: map[string]string{"tenant": "*"}}.Search(pins)
+	if len(res) != 2 {
+		t.Error("metadata prefix search failed")
+	}
+
+	res = PinQuery{Offset: 1, Limit: 1}.Search(pins)
+	if len(res) != 1 || res[0].Name != "backup-2017-02" {
+		t.Error("pagination failed")
+	}
+
+	res = PinQuery{Offset: 5}.Search(pins)
+	if len(res) != 0 {
+		t.Error("offset beyond results should return nothing")
+	}
+}
+
+func TestSharedConfigMerge(t *testing.T) {
+	cfg := SharedConfig{
+		ReplicationFactorMin: 2,
+		ReplicationFactorMax: 3,
+		Allocator:            "ascend",
+		Paused:               true,
+	}
+
+	merged := cfg.Merge(SharedConfigUpdate{
+		Config: SharedConfig{Allocator: "descend", ReplicationFactorMin: 5},
+		Fields: []string{SharedConfigAllocator, SharedConfigPaused},
+	})
+	expected := cfg
+	expected.Allocator = "descend"
+	expected.Paused = false
+	if merged != expected {
+		t.Errorf("unexpected merge result: %+v", merged)
+	}
+
+	replaced := cfg.Merge(SharedConfigUpdate{Config: SharedConfig{Allocator: "descend"}})
+	if replaced != (SharedConfig{Allocator: "descend"}) {
+		t.Errorf("an update without fields should replace everything: %+v", replaced)
+	}
+}
+
+func TestPinQueryIsEmpty(t *testing.T) {
+	empty := []PinQuery{
+		{},
+		{Name: "*"},
+		{Metadata: map[string]string{"tenant": "*"}},
+		{Name: "*", Metadata: map[string]string{"tenant": ""}},
+		{Limit: 10},
+	}
+	for i, q := range empty {
+		if !q.IsEmpty() {
+			t.Errorf("query %d should be empty: %+v", i, q)
+		}
+	}
+
+	selective := []PinQuery{
+		{Name: "backup-*"},
+		{Name: "backup"},
+		{Name: "*", Metadata: map[string]string{"tenant": "a*"}},
+	}
+	for i, q := range selective {
+		if q.IsEmpty() {
+			t.Errorf("query %d should not be empty: %+v", i, q)
+		}
+	}
+}
+
+func TestPinSign(t *testing.T) {
+	priv, pub, err := crypto.GenerateKeyPair(crypto.RSA, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, _ := peer.IDFromPublicKey(pub)
+
+	pin := PinCid(testCid1)
+	pin.Name = "abc"
+	signed, err := pin.Sign(PinOpPin, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// signatures survive serialization
+	signed = signed.ToSerial().ToPin()
+	signer, err := signed.VerifySignature(PinOpPin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signer != pid {
+		t.Error("unexpected signer")
+	}
+
+	if _, err := signed.VerifySignature(PinOpUnpin); err == nil {
+		t.Error("signature should not be valid for a different operation")
+	}
+
+	signed.Name = "def"
+	if _, err := signed.VerifySignature(PinOpPin); err == nil {
+		t.Error("signature should not be valid for a modified pin")
+	}
+
+	if _, err := pin.VerifySignature(PinOpPin); err == nil {
+		t.Error("unsigned pins should not verify")
+	}
+}
+
+func TestPinSignAllFields(t *testing.T) {
+	priv, _, err := crypto.GenerateKeyPair(crypto.RSA, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pin := PinCid(testCid1)
+	pin.Metadata = map[string]string{"a": "b"}
+	pin.Allocations = []peer.ID{testPeerID1}
+	signed, err := pin.Sign(PinOpPin, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signed.Nonce == "" || signed.Expires.IsZero() {
+		t.Fatal("Sign should set a nonce and an expiry")
+	}
+
+	tampered := []func(p *Pin){
+		func(p *Pin) { p.Metadata = map[string]string{"a": "c"} },
+		func(p *Pin) { p.Allocations = []peer.ID{testPeerID2} },
+		func(p *Pin) { p.Raw = true },
+		func(p *Pin) { p.Nonce = "other" },
+		func(p *Pin) { p.Expires = p.Expires.Add(time.Second) },
+	}
+	for i, f := range tampered {
+		p := signed
+		f(&p)
+		if _, err := p.VerifySignature(PinOpPin); err == nil {
+			t.Errorf("%d: signature should not be valid for a modified pin", i)
+		}
+	}
+
+	expired := pin
+	expired.Expires = time.Now().Add(-time.Second)
+	expired, _ = expired.Sign(PinOpPin, priv)
+	if _, err := expired.VerifySignature(PinOpPin); err == nil {
+		t.Error("expired requests should not verify")
+	}
+
+	tooLong := pin
+	tooLong.Expires = time.Now().Add(2 * MaxSignatureValidity)
+	tooLong, _ = tooLong.Sign(PinOpPin, priv)
+	if _, err := tooLong.VerifySignature(PinOpPin); err == nil {
+		t.Error("requests valid for too long should not verify")
+	}
+}
+
+func TestPinVerifySignedRequest(t *testing.T) {
+	priv, _, err := crypto.GenerateKeyPair(crypto.RSA, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pin := PinCid(testCid1)
+	pin.Name = "abc"
+	signed, _ := pin.Sign(PinOpPin, priv)
+	signed.SignedPayload = signed.SigningPayload(PinOpPin)
+
+	// The cluster sets allocations and replication factors
+	signed.Allocations = []peer.ID{testPeerID1}
+	signed.ReplicationFactorMin = 1
+	signed.ReplicationFactorMax = 2
+	signed = signed.ToSerial().ToPin()
+	if _, err := signed.VerifySignedRequest(PinOpPin); err != nil {
+		t.Fatal(err)
+	}
+
+	signed.Name = "def"
+	if _, err := signed.VerifySignedRequest(PinOpPin); err == nil {
+		t.Error("the pin should not match the signed request")
+	}
+
+	stripped := signed.StripRequest()
+	if stripped.Signer != nil || stripped.SignedPayload != nil || stripped.Nonce != "" {
+		t.Error("StripRequest should remove the signature fields")
+	}
+}
+
 func TestMetric(t *testing.T) {
 	m := Metric{
 		Name:  "hello",
@@ -238,3 +664,146 @@ func TestMetric(t *testing.T) {
 		t.Error("looks like a bad ttl")
 	}
 }
+
+func TestMetricConv(t *testing.T) {
+	m := Metric{
+		Name:          "freespace",
+		Peer:          testPeerID1,
+		Value:         "1000",
+		Expire:        testTime.UnixNano(),
+		Valid:         true,
+		Unallocatable: true,
+		Received:      testTime.UnixNano(),
+		Weight:        4,
+	}
+
+	newm := m.ToSerial().ToMetric()
+	if newm != m {
+		t.Errorf("metrics do not match: %+v %+v", m, newm)
+	}
+
+	if (Metric{}).ToSerial().Received != "" {
+		t.Error("unset reception time should be empty")
+	}
+}
+
+func TestCanonicalCid(t *testing.T) {
+	v1 := cid.NewCidV1(cid.DagProtobuf, testCid1.Hash())
+	if !CanonicalCid(v1).Equals(testCid1) {
+		t.Error("a dag-pb CIDv1 should be converted to CIDv0")
+	}
+	if !CanonicalCid(testCid1).Equals(testCid1) {
+		t.Error("a CIDv0 should not change")
+	}
+
+	raw := cid.NewCidV1(cid.Raw, testCid1.Hash())
+	if !CanonicalCid(raw).Equals(raw) {
+		t.Error("a raw CIDv1 has no CIDv0 form")
+	}
+
+	b32 := CidV1Base32(testCid1)
+	if b32[0] != 'b' {
+		t.Fatal("expected a base32 string:", b32)
+	}
+	c, err := cid.Decode(b32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Equals(v1) || !CanonicalCid(c).Equals(testCid1) {
+		t.Error("the base32 CIDv1 does not match")
+	}
+
+	gpis := GlobalPinInfo{Cid: v1}.ToSerial()
+	if gpis.CidV0 != testCid1.String() || gpis.CidV1 != b32 {
+		t.Error("expected both forms in the serialized GlobalPinInfo")
+	}
+}
+
+func TestDiffPins(t *testing.T) {
+	c2 := cid.NewCidV1(cid.Raw, testCid1.Hash())
+	c3 := cid.NewCidV1(cid.DagCBOR, testCid1.Hash())
+
+	p1 := PinCid(testCid1)
+	p1.Name = "a"
+	p1.Allocations = []peer.ID{testPeerID1, testPeerID2}
+	p2 := PinCid(c2)
+	p3 := PinCid(c3)
+
+	// same item under its CIDv1 form, with reordered allocations
+	p1b := PinCid(cid.NewCidV1(cid.DagProtobuf, testCid1.Hash()))
+	p1b.Name = "a"
+	p1b.Allocations = []peer.ID{testPeerID2, testPeerID1}
+
+	d := DiffPins([]Pin{p1, p2}, []Pin{p1b, p2})
+	if !d.Empty() {
+		t.Error("expected no differences")
+	}
+
+	p1b.Name = "b"
+	p1b.Metadata = map[string]string{"k": "v"}
+	d = DiffPins([]Pin{p1, p2}, []Pin{p1b, p3})
+	if len(d.Added) != 1 || !d.Added[0].Cid.Equals(c3) {
+		t.Error("expected one added pin")
+	}
+	if len(d.Removed) != 1 || !d.Removed[0].Cid.Equals(c2) {
+		t.Error("expected one removed pin")
+	}
+	if len(d.Changed) != 1 {
+		t.Fatal("expected one changed pin")
+	}
+	if !reflect.DeepEqual(d.Changed[0].Fields, []string{"name", "metadata"}) {
+		t.Error("unexpected changed fields:", d.Changed[0].Fields)
+	}
+
+	d2 := d.ToSerial().ToPinsDiff()
+	if len(d2.Added) != 1 || len(d2.Removed) != 1 || len(d2.Changed) != 1 {
+		t.Error("the serial conversion lost items")
+	}
+}
+
+func TestPinShard(t *testing.T) {
+	for _, bad := range []string{"", "3", "a/b", "4/4", "-1/4", "1/0", "1/4x"} {
+		if _, err := ParsePinShard(bad); err == nil {
+			t.Errorf("expected an error parsing %q", bad)
+		}
+	}
+	shard, err := ParsePinShard("1/4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shard.Index != 1 || shard.Count != 4 || shard.String() != "1/4" {
+		t.Error("unexpected shard")
+	}
+
+	prefix := testCid1.Prefix()
+	counts := make([]int, 4)
+	for i := 0; i < 100; i++ {
+		c, err := prefix.Sum([]byte{byte(i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := 0
+		for j := range counts {
+			if (PinShard{Index: j, Count: 4}).Contains(c) {
+				counts[j]++
+				n++
+			}
+		}
+		if n != 1 {
+			t.Fatalf("%s should belong to exactly one shard", c)
+		}
+	}
+	for j, n := range counts {
+		if n == 0 {
+			t.Errorf("shard %d should not be empty", j)
+		}
+	}
+
+	v1 := cid.NewCidV1(cid.DagProtobuf, testCid1.Hash())
+	for j := 0; j < 4; j++ {
+		s := PinShard{Index: j, Count: 4}
+		if s.Contains(v1) != s.Contains(testCid1) {
+			t.Error("both Cid forms should belong to the same shard")
+		}
+	}
+}
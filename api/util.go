@@ -1,12 +1,61 @@
 package api
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 
+	cid "github.com/ipfs/go-cid"
 	peer "github.com/libp2p/go-libp2p-peer"
 	ma "github.com/multiformats/go-multiaddr"
+	mbase "github.com/multiformats/go-multibase"
+	mh "github.com/multiformats/go-multihash"
 )
 
+// NewRequestID returns a random identifier for an API request (see
+// Pin.RequestID).
+func NewRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// CanonicalCid returns the form of a Cid used to identify items in the
+// shared state. A CIDv1 with an equivalent CIDv0 (dag-pb codec and
+// sha2-256 multihash) is converted to it, so that both forms refer to the
+// same item. Any other Cid is returned unchanged.
+func CanonicalCid(c *cid.Cid) *cid.Cid {
+	if c == nil || c.Version() == 0 {
+		return c
+	}
+	p := c.Prefix()
+	if p.Codec == cid.DagProtobuf && p.MhType == mh.SHA2_256 && p.MhLength == 32 {
+		return cid.NewCidV0(c.Hash())
+	}
+	return c
+}
+
+// CidV1 returns the CIDv1 form of a Cid.
+func CidV1(c *cid.Cid) *cid.Cid {
+	if c == nil || c.Version() == 1 {
+		return c
+	}
+	return cid.NewCidV1(c.Type(), c.Hash())
+}
+
+// CidV1Base32 returns the CIDv1 form of a Cid encoded with base32, as
+// used by recent IPFS versions.
+func CidV1Base32(c *cid.Cid) string {
+	if c == nil {
+		return ""
+	}
+	s, err := mbase.Encode(mbase.Base32, CidV1(c).Bytes())
+	if err != nil {
+		return ""
+	}
+	return s
+}
+
 // PeersToStrings IDB58Encodes a list of peers.
 func PeersToStrings(peers []peer.ID) []string {
 	strs := make([]string, len(peers))
@@ -0,0 +1,216 @@
+package ipfscluster
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+
+	"github.com/ipfs/ipfs-cluster/api"
+)
+
+// how long fetching Config.BlocklistURL may take
+var blocklistFetchTimeout = time.Minute
+
+// blocklist holds the Cids which this peer refuses to pin (see
+// Config.BlocklistFile and Config.BlocklistURL), by their canonical form.
+type blocklist struct {
+	mux  sync.RWMutex
+	cids map[string]struct{}
+}
+
+func newBlocklist() *blocklist {
+	return &blocklist{
+		cids: make(map[string]struct{}),
+	}
+}
+
+func (bl *blocklist) set(cids map[string]struct{}) {
+	bl.mux.Lock()
+	defer bl.mux.Unlock()
+	bl.cids = cids
+}
+
+func (bl *blocklist) has(c *cid.Cid) bool {
+	bl.mux.RLock()
+	defer bl.mux.RUnlock()
+	_, ok := bl.cids[api.CanonicalCid(c).String()]
+	return ok
+}
+
+func (bl *blocklist) len() int {
+	bl.mux.RLock()
+	defer bl.mux.RUnlock()
+	return len(bl.cids)
+}
+
+func errBlocklisted(c *cid.Cid) error {
+	return fmt.Errorf("%s is blocklisted", c)
+}
+
+// parseBlocklist reads one Cid per line into the given set. Empty lines
+// and lines starting with "#" are ignored.
+func parseBlocklist(r io.Reader, cids map[string]struct{}) error {
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		c, err := cid.Decode(line)
+		if err != nil {
+			return fmt.Errorf("line %d: %s", n, err)
+		}
+		cids[api.CanonicalCid(c).String()] = struct{}{}
+	}
+	return scanner.Err()
+}
+
+// loadBlocklist reads Config.BlocklistFile and Config.BlocklistURL and
+// replaces the current blocklist with their contents. The current
+// blocklist is kept if any of them cannot be read.
+func (c *Cluster) loadBlocklist() error {
+	cids := make(map[string]struct{})
+
+	if path := c.config.GetBlocklistPath(); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		err = parseBlocklist(f, cids)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("error parsing %s: %s", path, err)
+		}
+	}
+
+	if url := c.config.BlocklistURL; url != "" {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return err
+		}
+		client := &http.Client{Timeout: blocklistFetchTimeout}
+		resp, err := client.Do(req.WithContext(c.ctx))
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("error fetching %s: %s", url, resp.Status)
+		}
+		err = parseBlocklist(resp.Body, cids)
+		if err != nil {
+			return fmt.Errorf("error parsing %s: %s", url, err)
+		}
+	}
+
+	c.blocklist.set(cids)
+	return nil
+}
+
+// enforceBlocklist unpins every blocklisted item from the cluster. It
+// returns the number of items unpinned.
+func (c *Cluster) enforceBlocklist() (int, error) {
+	if c.blocklist.len() == 0 {
+		return 0, nil
+	}
+
+	cState, err := c.consensus.State()
+	if err != nil {
+		return 0, err
+	}
+
+	var n int
+	for _, pin := range cState.List() {
+		if !c.blocklist.has(pin.Cid) {
+			continue
+		}
+		c.logger.Warningf("unpinning %s: it is blocklisted", pin.Cid)
+		err := c.Unpin(pin.Cid)
+		if err != nil {
+			c.logger.Errorf("unpinning blocklisted %s: %s", pin.Cid, err)
+			continue
+		}
+		n++
+	}
+	return n, nil
+}
+
+// track makes the tracker track the given pin unless it is blocklisted
+// by this peer, which is checked for every pin coming from the shared
+// state, since other peers may not share the same blocklist. Blocklisted
+// items are untracked instead, which unpins them if they were pinned
+// before being blocklisted.
+func (c *Cluster) track(pin api.Pin) error {
+	if c.blocklist.has(pin.Cid) {
+		c.logger.Warningf("refusing to track %s: it is blocklisted", pin.Cid)
+		c.tracker.Untrack(pin.Cid)
+		return errBlocklisted(pin.Cid)
+	}
+	return c.tracker.Track(pin)
+}
+
+// untrackBlocklisted untracks the blocklisted items tracked by this
+// peer, so that they are unpinned locally even before the leader unpins
+// them from the cluster. It returns the number of items untracked.
+func (c *Cluster) untrackBlocklisted() int {
+	if c.blocklist.len() == 0 {
+		return 0
+	}
+	var n int
+	for _, pinfo := range c.tracker.StatusAll() {
+		if !c.blocklist.has(pinfo.Cid) {
+			continue
+		}
+		c.logger.Warningf("untracking %s: it is blocklisted", pinfo.Cid)
+		err := c.tracker.Untrack(pinfo.Cid)
+		if err != nil {
+			c.logger.Errorf("untracking blocklisted %s: %s", pinfo.Cid, err)
+			continue
+		}
+		n++
+	}
+	return n
+}
+
+// watchBlocklist loads the blocklist and reloads it every
+// Config.BlocklistUpdateInterval. After every load, every peer untracks
+// the blocklisted items it tracks and the leader unpins those which are
+// part of the shared state.
+func (c *Cluster) watchBlocklist() {
+	if c.config.BlocklistFile == "" && c.config.BlocklistURL == "" {
+		return
+	}
+
+	ticker := time.NewTicker(c.config.BlocklistUpdateInterval)
+	defer ticker.Stop()
+
+	for {
+		err := c.loadBlocklist()
+		if err != nil {
+			c.logger.Errorf("error loading the blocklist: %s", err)
+		} else {
+			c.logger.Debugf("blocklist loaded with %d items", c.blocklist.len())
+			c.untrackBlocklisted()
+		}
+
+		leader, err := c.consensus.Leader()
+		if err == nil && leader == c.id {
+			if _, err := c.enforceBlocklist(); err != nil {
+				c.logger.Error(err)
+			}
+		}
+
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package ipfscluster
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+
+	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/test"
+)
+
+func TestParseBlocklist(t *testing.T) {
+	h, _ := cid.Decode(test.TestCid1)
+	v1 := api.CidV1Base32(h)
+	list := fmt.Sprintf("# takedowns\n\n%s\n  %s  \n", v1, test.TestCid2)
+
+	cids := make(map[string]struct{})
+	err := parseBlocklist(strings.NewReader(list), cids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cids) != 2 {
+		t.Fatal("expected 2 blocklisted cids")
+	}
+	if _, ok := cids[test.TestCid1]; !ok {
+		t.Error("cids should be stored in their canonical form")
+	}
+
+	err = parseBlocklist(strings.NewReader("abc\n"), cids)
+	if err == nil {
+		t.Error("expected an error with a bad cid")
+	}
+}
+
+func TestClusterBlocklist(t *testing.T) {
+	dir := "blocklistFromTests"
+	os.MkdirAll(dir, 0700)
+	defer os.RemoveAll(dir)
+
+	cl, _, _, _, _ := testingCluster(t)
+	defer cleanRaft()
+	defer cl.Shutdown()
+
+	h1, _ := cid.Decode(test.TestCid1)
+	h2, _ := cid.Decode(test.TestCid2)
+	h3, _ := cid.Decode(test.TestCid3)
+	err := cl.Pin(api.PinCid(h1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintln(w, test.TestCid3)
+	}))
+	defer ts.Close()
+
+	ioutil.WriteFile(filepath.Join(dir, "blocklist"), []byte(test.TestCid1+"\n"+test.TestCid2+"\n"), 0600)
+	cl.config.SetBaseDir(dir)
+	cl.config.BlocklistFile = "blocklist"
+	cl.config.BlocklistURL = ts.URL
+
+	err = cl.loadBlocklist()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cl.blocklist.len() != 3 {
+		t.Fatal("expected 3 blocklisted cids")
+	}
+
+	for _, h := range []*cid.Cid{h2, h3} {
+		if err := cl.Pin(api.PinCid(h)); err == nil {
+			t.Errorf("%s is blocklisted and should not be pinned", h)
+		}
+	}
+
+	if err := cl.track(api.PinCid(h2)); err == nil {
+		t.Error("blocklisted items should not be tracked")
+	}
+	if n := cl.untrackBlocklisted(); n != 1 {
+		t.Errorf("expected 1 item untracked, got %d", n)
+	}
+
+	n, err := cl.enforceBlocklist()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("expected 1 item unpinned, got %d", n)
+	}
+	pinDelay()
+	if _, err := cl.PinGet(h1); err == nil {
+		t.Error("the blocklisted item should have been unpinned")
+	}
+
+	cl.config.BlocklistURL = ts.URL + "/missing"
+	err = cl.loadBlocklist()
+	if err == nil {
+		t.Error("expected an error when the blocklist url fails")
+	}
+	if cl.blocklist.len() != 3 {
+		t.Error("the previous blocklist should be kept")
+	}
+}
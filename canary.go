@@ -0,0 +1,265 @@
+package ipfscluster
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	peer "github.com/libp2p/go-libp2p-peer"
+
+	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/fsutil"
+)
+
+// How often the status of a canary pin is checked
+var canaryCheckInterval = time.Second
+
+var errCanaryAborted = errors.New("the pin was modified while waiting for the canary")
+
+// pendingCanaries holds the pins waiting for their canary, with all
+// their allocations. They are saved to Config.CanaryFile on every change
+// so that they can be extended after a restart.
+type pendingCanaries struct {
+	path string
+
+	mux  sync.Mutex
+	pins map[string]api.PinSerial
+}
+
+func newPendingCanaries(path string) *pendingCanaries {
+	return &pendingCanaries{
+		path: path,
+		pins: make(map[string]api.PinSerial),
+	}
+}
+
+// add records a pin and saves the pending pins.
+func (pc *pendingCanaries) add(pin api.Pin) error {
+	pc.mux.Lock()
+	defer pc.mux.Unlock()
+	key := pin.Cid.String()
+	pc.pins[key] = pin.ToSerial()
+	err := pc.save()
+	if err != nil {
+		delete(pc.pins, key)
+	}
+	return err
+}
+
+// remove forgets a pin and saves the pending pins.
+func (pc *pendingCanaries) remove(h *cid.Cid) error {
+	pc.mux.Lock()
+	defer pc.mux.Unlock()
+	key := h.String()
+	if _, ok := pc.pins[key]; !ok {
+		return nil
+	}
+	delete(pc.pins, key)
+	return pc.save()
+}
+
+// list returns the pending pins.
+func (pc *pendingCanaries) list() []api.Pin {
+	pc.mux.Lock()
+	defer pc.mux.Unlock()
+	pins := make([]api.Pin, 0, len(pc.pins))
+	for _, p := range pc.pins {
+		pins = append(pins, p.ToPin())
+	}
+	return pins
+}
+
+// load reads the pending pins file, if any, replacing the current
+// pending pins.
+func (pc *pendingCanaries) load() error {
+	if pc.path == "" {
+		return nil
+	}
+
+	raw, err := ioutil.ReadFile(pc.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var serials []api.PinSerial
+	err = json.Unmarshal(raw, &serials)
+	if err != nil {
+		return fmt.Errorf("error parsing %s: %s", pc.path, err)
+	}
+
+	pins := make(map[string]api.PinSerial)
+	for _, p := range serials {
+		pins[p.Cid] = p
+	}
+
+	pc.mux.Lock()
+	pc.pins = pins
+	pc.mux.Unlock()
+	return nil
+}
+
+// save writes the pending pins file. It must be called with the lock held.
+func (pc *pendingCanaries) save() error {
+	if pc.path == "" {
+		return nil
+	}
+
+	serials := make([]api.PinSerial, 0, len(pc.pins))
+	for _, p := range pc.pins {
+		serials = append(serials, p)
+	}
+	sort.Slice(serials, func(i, j int) bool {
+		return serials[i].Cid < serials[j].Cid
+	})
+	raw, err := json.MarshalIndent(serials, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return fsutil.WriteFileAtomic(pc.path, raw, 0600)
+}
+
+// pinCanary commits the given new pin allocated only to the first of its
+// allocations (the canary). The rest of the allocations are added once
+// the canary has pinned the content successfully (see Config.CanaryPinning).
+func (c *Cluster) pinCanary(pin api.Pin) error {
+	err := c.canaries.add(pin)
+	if err != nil {
+		return err
+	}
+
+	canaryPin := pin
+	canaryPin.Allocations = pin.Allocations[0:1]
+	c.logger.Infof("IPFS cluster pinning %s on canary %s", pin.Cid, canaryPin.Allocations[0].Pretty())
+	err = c.consensus.LogPin(canaryPin)
+	if err != nil {
+		c.canaries.remove(pin.Cid)
+		return err
+	}
+
+	c.wg.Add(1)
+	go c.extendCanaryPin(pin)
+	return nil
+}
+
+// resumeCanaries waits again for the canaries of the pins which were
+// pending when this peer was stopped.
+func (c *Cluster) resumeCanaries() {
+	for _, pin := range c.canaries.list() {
+		c.logger.Infof("resuming canary pin of %s", pin.Cid)
+		c.wg.Add(1)
+		go c.extendCanaryPin(pin)
+	}
+}
+
+// extendCanaryPin waits for the canary to pin the given pin and then
+// allocates it to the rest of its allocations. Pins which fail on the
+// canary are left allocated to it only and the failure is reported,
+// so that the content can be inspected there. The pin stays pending when
+// the peer shuts down in the meantime.
+func (c *Cluster) extendCanaryPin(pin api.Pin) {
+	defer c.wg.Done()
+
+	canary := pin.Allocations[0]
+	err := c.waitForCanary(pin, canary)
+	if err != nil && c.ctx.Err() != nil {
+		return // shutting down
+	}
+	defer func() {
+		if err := c.canaries.remove(pin.Cid); err != nil {
+			c.logger.Error(err)
+		}
+	}()
+
+	switch err {
+	case nil:
+	case errCanaryAborted:
+		c.logger.Warningf("canary pin %s: %s", pin.Cid, err)
+		return
+	default:
+		c.logger.Errorf("canary pin of %s on %s failed: %s", pin.Cid, canary.Pretty(), err)
+		c.logger.Errorf("%s will not be replicated further. It stays allocated to %s only. Pin it again to retry or unpin it", pin.Cid, canary.Pretty())
+		return
+	}
+
+	curr, ok := c.getCurrentPin(pin.Cid)
+	if !ok {
+		return
+	}
+	c.logger.Infof("canary pin of %s on %s succeeded. Extending it", pin.Cid, canary.Pretty())
+	_, err = c.pin(curr, []peer.ID{}, pin.Allocations)
+	if err != nil {
+		c.logger.Errorf("extending canary pin %s: %s", pin.Cid, err)
+	}
+}
+
+// waitForCanary waits until the canary reports the pin as pinned and
+// its IPFS daemon confirms it, up to Config.CanaryTimeout.
+func (c *Cluster) waitForCanary(pin api.Pin, canary peer.ID) error {
+	timeout := time.NewTimer(c.config.CanaryTimeout)
+	defer timeout.Stop()
+	ticker := time.NewTicker(canaryCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return c.ctx.Err()
+		case <-timeout.C:
+			return fmt.Errorf("timed out after %s", c.config.CanaryTimeout)
+		case <-ticker.C:
+		}
+
+		curr, ok := c.getCurrentPin(pin.Cid)
+		if !ok || len(curr.Allocations) != 1 || curr.Allocations[0] != canary {
+			return errCanaryAborted
+		}
+
+		var pinfo api.PinInfoSerial
+		err := c.rpcClient.Call(canary,
+			"Cluster",
+			"TrackerStatus",
+			pin.ToSerial(),
+			&pinfo)
+		if err != nil {
+			c.logger.Debugf("canary status for %s: %s", pin.Cid, err)
+			continue
+		}
+
+		switch pinfo.ToPinInfo().Status {
+		case api.TrackerStatusPinned:
+			return c.verifyCanary(pin, canary)
+		case api.TrackerStatusPinError:
+			return errors.New(pinfo.Error)
+		}
+	}
+}
+
+// verifyCanary checks that the IPFS daemon of the canary has the
+// content pinned with the right mode.
+func (c *Cluster) verifyCanary(pin api.Pin, canary peer.ID) error {
+	var ipfsStatus api.IPFSPinStatus
+	err := c.rpcClient.Call(canary,
+		"Cluster",
+		"IPFSPinLsCid",
+		pin.ToSerial(),
+		&ipfsStatus)
+	if err != nil {
+		return err
+	}
+
+	if pin.Recursive && ipfsStatus != api.IPFSPinStatusRecursive ||
+		!pin.Recursive && !ipfsStatus.IsPinned() {
+		return fmt.Errorf("the IPFS daemon of the canary reports the pin as %s", ipfsStatus)
+	}
+	return nil
+}
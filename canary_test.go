@@ -0,0 +1,65 @@
+package ipfscluster
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+	peer "github.com/libp2p/go-libp2p-peer"
+
+	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/test"
+)
+
+func TestPendingCanariesPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "canaries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, DefaultCanaryFile)
+
+	pc := newPendingCanaries(path)
+	err = pc.load()
+	if err != nil {
+		t.Fatal("a missing canary file should not be an error:", err)
+	}
+
+	h1, _ := cid.Decode(test.TestCid1)
+	h2, _ := cid.Decode(test.TestCid2)
+	pin := api.PinCid(h1)
+	pin.Allocations = []peer.ID{test.TestPeerID1, test.TestPeerID2}
+	err = pc.add(pin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc.add(api.PinCid(h2))
+
+	pc2 := newPendingCanaries(path)
+	err = pc2.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pins := pc2.list()
+	if len(pins) != 2 {
+		t.Fatalf("expected 2 pending canaries, got %d", len(pins))
+	}
+	for _, p := range pins {
+		if p.Cid.Equals(h1) && len(p.Allocations) != 2 {
+			t.Error("the allocations of the pending pin should have been saved")
+		}
+	}
+
+	err = pc2.remove(h1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc3 := newPendingCanaries(path)
+	pc3.load()
+	pins = pc3.list()
+	if len(pins) != 1 || !pins[0].Cid.Equals(h2) {
+		t.Error("the removal should have been saved")
+	}
+}
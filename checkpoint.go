@@ -0,0 +1,134 @@
+package ipfscluster
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+
+	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/fsutil"
+	"github.com/ipfs/ipfs-cluster/state"
+)
+
+// trackerCheckpoint is what gets saved to Config.TrackerCheckpointFile:
+// the items which this peer had pinned or tracked as remote, indexed by
+// Cid, with their tracker status.
+type trackerCheckpoint struct {
+	Time  time.Time         `json:"time"`
+	Items map[string]string `json:"items"`
+}
+
+// saveCheckpoint writes the tracker checkpoint. Only items which are
+// pinned or remote are saved, since any other status may not have
+// reached the IPFS daemon yet.
+func (c *Cluster) saveCheckpoint() error {
+	path := c.config.GetTrackerCheckpointPath()
+	if path == "" {
+		return nil
+	}
+
+	cp := trackerCheckpoint{
+		Time:  time.Now(),
+		Items: make(map[string]string),
+	}
+	for _, pinfo := range c.tracker.StatusAll() {
+		switch pinfo.Status {
+		case api.TrackerStatusPinned, api.TrackerStatusRemote:
+			cp.Items[pinfo.Cid.String()] = pinfo.Status.String()
+		}
+	}
+
+	raw, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+
+	return fsutil.WriteFileAtomic(path, raw, 0600)
+}
+
+// loadCheckpoint reads the tracker checkpoint. It returns nil when there
+// is none.
+func (c *Cluster) loadCheckpoint() (*trackerCheckpoint, error) {
+	path := c.config.GetTrackerCheckpointPath()
+	if path == "" {
+		return nil, nil
+	}
+
+	raw, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var cp trackerCheckpoint
+	err = json.Unmarshal(raw, &cp)
+	if err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
+
+// restoreCheckpoint is called on the first StateSync. Items in the shared
+// state which have the status recorded in the tracker checkpoint (that
+// is, whose allocation to this peer did not change) are restored in the
+// tracker without triggering any operation. The rest are left for
+// StateSync to track as usual. Items pinned according to the checkpoint
+// which are no longer in the shared state are untracked, so that they are
+// unpinned.
+func (c *Cluster) restoreCheckpoint(cState state.State) {
+	cp, err := c.loadCheckpoint()
+	if err != nil {
+		c.logger.Errorf("error loading the tracker checkpoint: %s", err)
+		return
+	}
+	if cp == nil {
+		return
+	}
+
+	var restored, removed int
+	for _, pin := range cState.List() {
+		key := pin.Cid.String()
+		st, ok := cp.Items[key]
+		if !ok {
+			continue
+		}
+		delete(cp.Items, key)
+
+		expected := api.TrackerStatusRemote
+		if containsPeer(pin.Allocations, c.id) || pin.IsPinEverywhere() {
+			expected = api.TrackerStatusPinned
+		}
+		if api.TrackerStatusFromString(st) != expected {
+			continue
+		}
+
+		err := c.tracker.Restore(pin, expected)
+		if err != nil {
+			c.logger.Errorf("restoring %s: %s", pin.Cid, err)
+			continue
+		}
+		restored++
+	}
+
+	// Whatever is left was unpinned while we were down.
+	for key, st := range cp.Items {
+		if api.TrackerStatusFromString(st) != api.TrackerStatusPinned {
+			continue
+		}
+		h, err := cid.Decode(key)
+		if err != nil {
+			continue
+		}
+		c.logger.Debugf("%s was unpinned since the tracker checkpoint", h)
+		c.tracker.Untrack(h)
+		removed++
+	}
+
+	c.logger.Infof("restored %d items from the tracker checkpoint of %s (%d removed since)",
+		restored, cp.Time.Format(time.RFC3339), removed)
+}
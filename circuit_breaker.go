@@ -0,0 +1,135 @@
+package ipfscluster
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+var errCircuitOpen = errors.New("peer skipped after repeated RPC failures (circuit open)")
+
+// peerBreakers keeps a circuit breaker for every peer which is contacted
+// in broadcasts. After Config.BreakerThreshold consecutive failed calls
+// the circuit of a peer opens and the peer is skipped for
+// Config.BreakerCooldown. Once it elapses, a call is let through again:
+// a success closes the circuit and a failure re-opens it.
+type peerBreakers struct {
+	logger    *instanceLogger
+	mux       sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	breakers  map[peer.ID]*breaker
+}
+
+type breaker struct {
+	failures  int
+	openUntil time.Time
+}
+
+func newPeerBreakers(log *instanceLogger, threshold int, cooldown time.Duration) *peerBreakers {
+	return &peerBreakers{
+		logger:    log,
+		threshold: threshold,
+		cooldown:  cooldown,
+		breakers:  make(map[peer.ID]*breaker),
+	}
+}
+
+// allow returns false when the circuit of the given peer is open.
+func (pb *peerBreakers) allow(p peer.ID) bool {
+	if pb.threshold <= 0 {
+		return true
+	}
+	pb.mux.Lock()
+	defer pb.mux.Unlock()
+	b, ok := pb.breakers[p]
+	return !ok || !time.Now().Before(b.openUntil)
+}
+
+// record registers the result of a call to the given peer.
+func (pb *peerBreakers) record(p peer.ID, err error) {
+	if pb.threshold <= 0 {
+		return
+	}
+	pb.mux.Lock()
+	defer pb.mux.Unlock()
+	if err == nil {
+		delete(pb.breakers, p)
+		return
+	}
+
+	b, ok := pb.breakers[p]
+	if !ok {
+		b = &breaker{}
+		pb.breakers[p] = b
+	}
+	b.failures++
+	if b.failures >= pb.threshold {
+		if b.failures == pb.threshold {
+			pb.logger.Warningf("%s failed %d consecutive calls: skipping it for %s", p.Pretty(), b.failures, pb.cooldown)
+		}
+		b.openUntil = time.Now().Add(pb.cooldown)
+	}
+}
+
+// multiCall works like rpcClient.MultiCall, but does not wait for the
+// peers whose circuit is open (see peerBreakers). They are skipped and
+// their error is set to errCircuitOpen. Calls to ourselves are not
+// subject to circuit breaking. Only Config.MaxConcurrentBroadcasts
+// multiCalls run at the same time.
+func (c *Cluster) multiCall(
+	ctxs []context.Context,
+	dests []peer.ID,
+	svcName, svcMethod string,
+	args interface{},
+	replies []interface{},
+) []error {
+	errs := make([]error, len(dests), len(dests))
+
+	if err := c.broadcasts.acquire(c.ctx); err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+	defer c.broadcasts.release()
+
+	var idxs []int
+	for i, p := range dests {
+		if p != c.id && !c.breakers.allow(p) {
+			errs[i] = errCircuitOpen
+			continue
+		}
+		idxs = append(idxs, i)
+	}
+
+	callCtxs := make([]context.Context, len(idxs), len(idxs))
+	callDests := make([]peer.ID, len(idxs), len(idxs))
+	callReplies := make([]interface{}, len(idxs), len(idxs))
+	for j, i := range idxs {
+		callCtxs[j] = ctxs[i]
+		callDests[j] = dests[i]
+		callReplies[j] = replies[i]
+	}
+
+	callErrs := c.rpcClient.MultiCall(
+		callCtxs,
+		callDests,
+		svcName,
+		svcMethod,
+		args,
+		callReplies,
+	)
+
+	for j, i := range idxs {
+		errs[i] = callErrs[j]
+		// Failures while shutting down do not count
+		if dests[i] != c.id && c.ctx.Err() == nil {
+			c.breakers.record(dests[i], callErrs[j])
+		}
+	}
+	return errs
+}
@@ -0,0 +1,51 @@
+package ipfscluster
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ipfs/ipfs-cluster/test"
+)
+
+func TestPeerBreakers(t *testing.T) {
+	pb := newPeerBreakers(nil, 2, 100*time.Millisecond)
+	p := test.TestPeerID1
+	err := errors.New("unreachable")
+
+	pb.record(p, err)
+	if !pb.allow(p) {
+		t.Fatal("circuit should be closed after a single failure")
+	}
+	pb.record(p, err)
+	if pb.allow(p) {
+		t.Fatal("circuit should be open after 2 failures")
+	}
+	if !pb.allow(test.TestPeerID2) {
+		t.Error("other peers should not be affected")
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if !pb.allow(p) {
+		t.Fatal("a call should be let through after the cooldown")
+	}
+	pb.record(p, err)
+	if pb.allow(p) {
+		t.Fatal("circuit should re-open when the call fails")
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	pb.record(p, nil)
+	pb.record(p, err)
+	if !pb.allow(p) {
+		t.Error("a success should close the circuit")
+	}
+
+	disabled := newPeerBreakers(nil, 0, time.Second)
+	for i := 0; i < 5; i++ {
+		disabled.record(p, err)
+	}
+	if !disabled.allow(p) {
+		t.Error("circuit breaking should be disabled with a 0 threshold")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
@@ -15,15 +16,26 @@ import (
 	rpc "github.com/hsanjuan/go-libp2p-gorpc"
 	cid "github.com/ipfs/go-cid"
 	host "github.com/libp2p/go-libp2p-host"
+	inet "github.com/libp2p/go-libp2p-net"
 	peer "github.com/libp2p/go-libp2p-peer"
 	ma "github.com/multiformats/go-multiaddr"
 )
 
-// ReadyTimeout specifies the time before giving up
-// during startup (waiting for consensus to be ready)
-// It may need adjustment according to timeouts in the
-// consensus layer.
-var ReadyTimeout = 30 * time.Second
+// How often the progress of the consensus is reported while waiting
+// for it to be ready.
+var consensusProgressInterval = 5 * time.Second
+
+// ReadyTimeout specifies the time before giving up during startup
+// (waiting for consensus to be ready). It is only used when changed from
+// its default value, in which case it takes precedence over
+// Config.ConsensusStartTimeout.
+//
+// Deprecated: use Config.ConsensusStartTimeout.
+var ReadyTimeout = DefaultConsensusStartTimeout
+
+// How often we check whether our removal from the peerset has been
+// applied when leaving the cluster
+var leaveCheckInterval = 200 * time.Millisecond
 
 // Cluster is the main IPFS cluster component. It provides
 // the go-API for it and orchestrates the components that make up the system.
@@ -31,12 +43,28 @@ type Cluster struct {
 	ctx    context.Context
 	cancel func()
 
-	id          peer.ID
-	config      *Config
-	host        host.Host
-	rpcServer   *rpc.Server
-	rpcClient   *rpc.Client
-	peerManager *pstoremgr.Manager
+	id            peer.ID
+	commit        string
+	logger        *instanceLogger
+	config        *Config
+	host          host.Host
+	rpcServer     *rpc.Server
+	rpcClient     *rpc.Client
+	rpcAudit      *rpcAudit
+	peerManager   *pstoremgr.Manager
+	peerIDCache   *peerIDCache
+	breakers      *peerBreakers
+	jobs          *jobManager
+	blocklist     *blocklist
+	nonces        *nonceCache
+	peerBlacklist *peerBlacklist
+	prefetches    *prefetches
+	statePacer    *transferPacer
+	faults        *faultInjector
+	broadcasts    *workLimiter
+	canaries      *pendingCanaries
+
+	checkpointOnce sync.Once
 
 	consensus Consensus
 	api       API
@@ -47,6 +75,9 @@ type Cluster struct {
 	allocator PinAllocator
 	informer  Informer
 
+	// allocators which can be selected in the shared configuration
+	allocators map[string]PinAllocator
+
 	shutdownLock sync.Mutex
 	shutdownB    bool
 	removed      bool
@@ -56,6 +87,9 @@ type Cluster struct {
 	wg           sync.WaitGroup
 
 	paMux sync.Mutex
+
+	secretMux     sync.Mutex
+	pendingSecret []byte
 }
 
 // NewCluster builds a new IPFS Cluster peer. It initializes a LibP2P host,
@@ -91,35 +125,78 @@ func NewCluster(
 		listenAddrs += fmt.Sprintf("        %s/ipfs/%s\n", addr, host.ID().Pretty())
 	}
 
-	if c := Commit; len(c) >= 8 {
-		logger.Infof("IPFS Cluster v%s-%s listening on:\n%s\n", Version, Commit[0:8], listenAddrs)
+	// Several instances may run in the same process. Keep the
+	// commit that this one was created with.
+	commit := Commit
+	log := newInstanceLogger(host.ID())
+	if len(commit) >= 8 {
+		log.Infof("IPFS Cluster v%s-%s listening on:\n%s\n", Version, commit[0:8], listenAddrs)
 	} else {
-		logger.Infof("IPFS Cluster v%s listening on:\n%s\n", Version, listenAddrs)
+		log.Infof("IPFS Cluster v%s listening on:\n%s\n", Version, listenAddrs)
 	}
 
 	peerManager := pstoremgr.New(host, cfg.GetPeerstorePath())
 
 	ctx, cancel := context.WithCancel(context.Background())
 	c := &Cluster{
-		ctx:         ctx,
-		cancel:      cancel,
-		id:          host.ID(),
-		config:      cfg,
-		host:        host,
-		consensus:   consensus,
-		api:         api,
-		ipfs:        ipfs,
-		state:       st,
-		tracker:     tracker,
-		monitor:     monitor,
-		allocator:   allocator,
-		informer:    informer,
-		peerManager: peerManager,
-		shutdownB:   false,
-		removed:     false,
-		doneCh:      make(chan struct{}),
-		readyCh:     make(chan struct{}),
-		readyB:      false,
+		ctx:           ctx,
+		cancel:        cancel,
+		id:            host.ID(),
+		commit:        commit,
+		logger:        log,
+		config:        cfg,
+		host:          host,
+		consensus:     consensus,
+		api:           api,
+		ipfs:          ipfs,
+		state:         st,
+		tracker:       tracker,
+		monitor:       monitor,
+		allocator:     allocator,
+		informer:      informer,
+		peerManager:   peerManager,
+		rpcAudit:      newRPCAudit(log, cfg.RPCAuditLog),
+		peerIDCache:   newPeerIDCache(),
+		breakers:      newPeerBreakers(log, cfg.BreakerThreshold, cfg.BreakerCooldown),
+		jobs:          newJobManager(),
+		blocklist:     newBlocklist(),
+		nonces:        newNonceCache(),
+		peerBlacklist: newPeerBlacklist(cfg.GetPeerBlacklistPath()),
+		prefetches:    newPrefetches(),
+		statePacer:    &transferPacer{logger: log, limit: cfg.StateTransferLimit},
+		faults:        &faultInjector{},
+		broadcasts:    newWorkLimiter("broadcasts", cfg.MaxConcurrentBroadcasts),
+		canaries:      newPendingCanaries(cfg.GetCanaryPath()),
+		allocators:    newSharedAllocators(),
+		shutdownB:     false,
+		removed:       false,
+		doneCh:        make(chan struct{}),
+		readyCh:       make(chan struct{}),
+		readyB:        false,
+	}
+
+	err = c.peerBlacklist.load()
+	if err != nil {
+		c.logger.Errorf("loading the peer blacklist: %s", err)
+		c.Shutdown()
+		return nil, err
+	}
+	err = c.canaries.load()
+	if err != nil {
+		c.logger.Errorf("loading the pending canary pins: %s", err)
+		c.Shutdown()
+		return nil, err
+	}
+	for _, comp := range []interface{}{consensus, api, ipfs, tracker, monitor, allocator, informer, peerManager} {
+		if lp, ok := comp.(LogPrefixer); ok {
+			lp.SetLogPrefix(log.prefix)
+		}
+	}
+	if pf, ok := consensus.(PeerFilterer); ok {
+		pf.SetPeerFilter(c.acceptPeer)
+	}
+	if cv, ok := consensus.(CommitVerifier); ok {
+		cv.SetCommitVerifier(c.isTrustedPeer, c.verifyCommit)
 	}
 
 	err = c.setupRPC()
@@ -130,14 +207,24 @@ func NewCluster(
 
 	c.setupRPCClients()
 	go func() {
-		c.ready(ReadyTimeout)
+		c.ready(c.consensusStartTimeout())
 		c.run()
 	}()
 	return c, nil
 }
 
 func (c *Cluster) setupRPC() error {
-	rpcServer := rpc.NewServer(c.host, RPCProtocol)
+	// The audit host records every incoming request and
+	// rejects those not allowed for the remote peer.
+	rpcServer := rpc.NewServer(
+		&auditHost{
+			Host:      c.host,
+			audit:     c.rpcAudit,
+			authorize: c.authorizeRPC,
+			delay:     c.faults.rpcDelay,
+		},
+		RPCProtocol,
+	)
 	err := rpcServer.RegisterName("Cluster", &RPCAPI{c})
 	if err != nil {
 		return err
@@ -160,21 +247,30 @@ func (c *Cluster) setupRPCClients() {
 
 // syncWatcher loops and triggers StateSync and SyncAllLocal from time to time
 func (c *Cluster) syncWatcher() {
-	stateSyncTicker := time.NewTicker(c.config.StateSyncInterval)
+	stateSyncInterval := c.stateSyncInterval()
+	stateSyncTicker := time.NewTicker(stateSyncInterval)
 	syncTicker := time.NewTicker(c.config.IPFSSyncInterval)
 
 	for {
 		select {
 		case <-stateSyncTicker.C:
-			logger.Debug("auto-triggering StateSync()")
+			c.logger.Debug("auto-triggering StateSync()")
 			c.StateSync()
 		case <-syncTicker.C:
-			logger.Debug("auto-triggering SyncAllLocal()")
+			c.logger.Debug("auto-triggering SyncAllLocal()")
 			c.SyncAllLocal()
 		case <-c.ctx.Done():
 			stateSyncTicker.Stop()
 			return
 		}
+
+		// The interval may have been changed in the shared config
+		if interval := c.stateSyncInterval(); interval != stateSyncInterval {
+			c.logger.Infof("state sync interval changed to %s", interval)
+			stateSyncInterval = interval
+			stateSyncTicker.Stop()
+			stateSyncTicker = time.NewTicker(stateSyncInterval)
+		}
 	}
 }
 
@@ -202,12 +298,18 @@ func (c *Cluster) pushInformerMetrics() {
 
 		metric := c.informer.GetMetric()
 		metric.Peer = c.id
+		metric.Weight = c.config.AllocationWeight
+
+		if c.faults.dropMetrics() {
+			timer.Reset(metric.GetTTL() / 2)
+			continue
+		}
 
 		err := c.monitor.PublishMetric(metric)
 
 		if err != nil {
 			if (retries % retryWarnMod) == 0 {
-				logger.Errorf("error broadcasting metric: %s", err)
+				c.logger.Errorf("error broadcasting metric: %s", err)
 				retries++
 			}
 			// retry sooner
@@ -229,8 +331,10 @@ func (c *Cluster) pushPingMetrics() {
 			Peer:  c.id,
 			Valid: true,
 		}
-		metric.SetTTL(c.config.MonitorPingInterval * 2)
-		c.monitor.PublishMetric(metric)
+		metric.SetTTL(c.pingMetricTTL())
+		if !c.faults.dropMetrics() {
+			c.monitor.PublishMetric(metric)
+		}
 
 		select {
 		case <-c.ctx.Done():
@@ -250,7 +354,7 @@ func (c *Cluster) alertsHandler() {
 			// only the leader handles alerts
 			leader, err := c.consensus.Leader()
 			if err == nil && leader == c.id {
-				logger.Warningf("Peer %s received alert for %s in %s", c.id, alrt.MetricName, alrt.Peer.Pretty())
+				c.logger.Warningf("Peer %s received alert for %s in %s", c.id, alrt.MetricName, alrt.Peer.Pretty())
 				switch alrt.MetricName {
 				case "ping":
 					c.repinFromPeer(alrt.Peer)
@@ -271,12 +375,12 @@ func (c *Cluster) watchPeers() {
 		case <-c.ctx.Done():
 			return
 		case <-ticker.C:
-			logger.Debugf("%s watching peers", c.id)
+			c.logger.Debugf("%s watching peers", c.id)
 			save := false
 			hasMe := false
 			peers, err := c.consensus.Peers()
 			if err != nil {
-				logger.Error(err)
+				c.logger.Error(err)
 				continue
 			}
 			for _, p := range peers {
@@ -298,14 +402,14 @@ func (c *Cluster) watchPeers() {
 			lastPeers = peers
 
 			if !hasMe {
-				logger.Infof("%s: removed from raft. Initiating shutdown", c.id.Pretty())
+				c.logger.Infof("%s: removed from raft. Initiating shutdown", c.id.Pretty())
 				c.removed = true
 				go c.Shutdown()
 				return
 			}
 
 			if save {
-				logger.Info("peerset change detected. Saving peers addresses")
+				c.logger.Info("peerset change detected. Saving peers addresses")
 				c.peerManager.SavePeerstoreForPeers(peers)
 			}
 		}
@@ -315,13 +419,13 @@ func (c *Cluster) watchPeers() {
 // find all Cids pinned to a given peer and triggers re-pins on them.
 func (c *Cluster) repinFromPeer(p peer.ID) {
 	if c.config.DisableRepinning {
-		logger.Warningf("repinning is disabled. Will not re-allocate cids from %s", p.Pretty())
+		c.logger.Warningf("repinning is disabled. Will not re-allocate cids from %s", p.Pretty())
 		return
 	}
 
 	cState, err := c.consensus.State()
 	if err != nil {
-		logger.Warning(err)
+		c.logger.Warning(err)
 		return
 	}
 	list := cState.List()
@@ -329,7 +433,7 @@ func (c *Cluster) repinFromPeer(p peer.ID) {
 		if containsPeer(pin.Allocations, p) {
 			ok, err := c.pin(pin, []peer.ID{p}, []peer.ID{}) // pin blacklisting this peer
 			if ok && err == nil {
-				logger.Infof("repinned %s out of %s", pin.Cid, p.Pretty())
+				c.logger.Infof("repinned %s out of %s", pin.Cid, p.Pretty())
 			}
 		}
 	}
@@ -342,6 +446,11 @@ func (c *Cluster) run() {
 	go c.pushInformerMetrics()
 	go c.watchPeers()
 	go c.alertsHandler()
+	go c.watchSplitBrain()
+	go c.watchTiers()
+	go c.pushTrackerMetrics()
+	go c.watchBlocklist()
+	c.resumeCanaries()
 }
 
 func (c *Cluster) ready(timeout time.Duration) {
@@ -349,10 +458,19 @@ func (c *Cluster) ready(timeout time.Duration) {
 	// may have a peerset and not find a leader so we cannot wait
 	// for it.
 	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	progress := time.NewTicker(consensusProgressInterval)
+	defer progress.Stop()
+	start := time.Now()
+
+WAIT:
 	select {
+	case <-progress.C:
+		c.logConsensusProgress(time.Since(start))
+		goto WAIT
 	case <-timer.C:
-		logger.Error("***** ipfs-cluster consensus start timed out (tips below) *****")
-		logger.Error(`
+		c.logger.Errorf("***** ipfs-cluster consensus start timed out after %s (tips below) *****", timeout)
+		c.logger.Error(`
 **************************************************
 This peer was not able to become part of the cluster.
 This might be due to one or several causes:
@@ -364,6 +482,8 @@ This might be due to one or several causes:
     sure to start enough peers so that a leader election can happen.
   - Check that the peer(s) you are trying to connect to is running the
     same version of IPFS-cluster.
+  - If the logs above show that the state was still being loaded, increase
+    cluster.consensus_start_timeout (large states or slow disks).
 **************************************************
 `)
 		c.Shutdown()
@@ -373,6 +493,19 @@ This might be due to one or several causes:
 		// it to the tracker. We ignore errors (normal when state
 		// doesn't exist in new peers).
 		c.StateSync()
+		if c.config.StartupCheck {
+			cState, err := c.consensus.State()
+			if err == nil {
+				_, _, err = c.startupCheck(cState)
+			}
+			if err != nil {
+				c.logger.Errorf("startup check failed: %s", err)
+				c.logger.Error("the local pins could not be verified and are reported as errored. Run \"ipfs-cluster-ctl sync\" once IPFS is reachable")
+			}
+			if c.ctx.Err() != nil {
+				return
+			}
+		}
 	case <-c.ctx.Done():
 		return
 	}
@@ -380,25 +513,50 @@ This might be due to one or several causes:
 	// Cluster is ready.
 	peers, err := c.consensus.Peers()
 	if err != nil {
-		logger.Error(err)
+		c.logger.Error(err)
 		c.Shutdown()
 		return
 	}
 
-	logger.Info("Cluster Peers (without including ourselves):")
+	c.logger.Info("Cluster Peers (without including ourselves):")
 	if len(peers) == 1 {
-		logger.Info("    - No other peers")
+		c.logger.Info("    - No other peers")
 	}
 
 	for _, p := range peers {
 		if p != c.id {
-			logger.Infof("    - %s", p.Pretty())
+			c.logger.Infof("    - %s", p.Pretty())
 		}
 	}
 
 	close(c.readyCh)
 	c.readyB = true
-	logger.Info("** IPFS Cluster is READY **")
+	c.logger.Info("** IPFS Cluster is READY **")
+}
+
+// consensusStartTimeout returns how long to wait for the consensus to be
+// ready, honoring the deprecated ReadyTimeout when it has been set.
+func (c *Cluster) consensusStartTimeout() time.Duration {
+	if ReadyTimeout != DefaultConsensusStartTimeout {
+		return ReadyTimeout
+	}
+	return c.config.ConsensusStartTimeout
+}
+
+// logConsensusProgress reports what the consensus is doing while
+// this peer waits for it to be ready.
+func (c *Cluster) logConsensusProgress(elapsed time.Duration) {
+	cs, err := c.consensus.Status()
+	if err != nil {
+		c.logger.Infof("waiting for consensus (%s): %s", elapsed.Round(time.Second), err)
+		return
+	}
+	leader := "unknown"
+	if cs.Leader != "" {
+		leader = cs.Leader.Pretty()
+	}
+	c.logger.Infof("waiting for consensus (%s): leader %s, replaying log: %d/%d entries applied",
+		elapsed.Round(time.Second), leader, cs.AppliedIndex, cs.CommitIndex)
 }
 
 // Ready returns a channel which signals when this peer is
@@ -407,17 +565,59 @@ func (c *Cluster) Ready() <-chan struct{} {
 	return c.readyCh
 }
 
+// leave removes this peer from the consensus peerset and waits, for up
+// to Config.LeaveTimeout, until its own consensus component no longer
+// lists it. Otherwise, shutting down the consensus right away may stop
+// the removal from being committed and the rest of the peers would
+// keep this peer in the peerset.
+func (c *Cluster) leave() {
+	c.logger.Warning("attempting to leave the cluster. This may take some seconds")
+	err := c.consensus.RmPeer(c.id)
+	if err != nil {
+		c.logger.Error("leaving cluster: " + err.Error())
+		return
+	}
+
+	timer := time.NewTimer(c.config.LeaveTimeout)
+	defer timer.Stop()
+	ticker := time.NewTicker(leaveCheckInterval)
+	defer ticker.Stop()
+	for {
+		peers, err := c.consensus.Peers()
+		if err == nil && !containsPeer(peers, c.id) {
+			c.logger.Info("this peer has left the cluster")
+			return
+		}
+		select {
+		case <-timer.C:
+			c.logger.Errorf("leaving cluster: the removal of this peer was not confirmed after %s", c.config.LeaveTimeout)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
 // Shutdown stops the IPFS cluster components
 func (c *Cluster) Shutdown() error {
 	c.shutdownLock.Lock()
 	defer c.shutdownLock.Unlock()
 
 	if c.shutdownB {
-		logger.Debug("Cluster is already shutdown")
+		c.logger.Debug("Cluster is already shutdown")
 		return nil
 	}
 
-	logger.Info("shutting down Cluster")
+	c.logger.Info("shutting down Cluster")
+
+	if c.readyB && c.config.ShutdownDrainTimeout > 0 {
+		c.drain()
+	}
+
+	if c.readyB {
+		if err := c.saveCheckpoint(); err != nil {
+			c.logger.Errorf("error saving the tracker checkpoint: %s", err)
+		}
+	}
 
 	// Only attempt to leave if:
 	// - consensus is initialized
@@ -428,17 +628,13 @@ func (c *Cluster) Shutdown() error {
 		_, err := c.consensus.Peers()
 		if err == nil {
 			// best effort
-			logger.Warning("attempting to leave the cluster. This may take some seconds")
-			err := c.consensus.RmPeer(c.id)
-			if err != nil {
-				logger.Error("leaving cluster: " + err.Error())
-			}
+			c.leave()
 		}
 	}
 
 	if con := c.consensus; con != nil {
 		if err := con.Shutdown(); err != nil {
-			logger.Errorf("error stopping consensus: %s", err)
+			c.logger.Errorf("error stopping consensus: %s", err)
 			return err
 		}
 	}
@@ -454,26 +650,26 @@ func (c *Cluster) Shutdown() error {
 	if c.removed && c.readyB {
 		err := c.consensus.Clean()
 		if err != nil {
-			logger.Error("cleaning consensus: ", err)
+			c.logger.Error("cleaning consensus: ", err)
 		}
 	}
 
 	if err := c.monitor.Shutdown(); err != nil {
-		logger.Errorf("error stopping monitor: %s", err)
+		c.logger.Errorf("error stopping monitor: %s", err)
 		return err
 	}
 
 	if err := c.api.Shutdown(); err != nil {
-		logger.Errorf("error stopping API: %s", err)
+		c.logger.Errorf("error stopping API: %s", err)
 		return err
 	}
 	if err := c.ipfs.Shutdown(); err != nil {
-		logger.Errorf("error stopping IPFS Connector: %s", err)
+		c.logger.Errorf("error stopping IPFS Connector: %s", err)
 		return err
 	}
 
 	if err := c.tracker.Shutdown(); err != nil {
-		logger.Errorf("error stopping PinTracker: %s", err)
+		c.logger.Errorf("error stopping PinTracker: %s", err)
 		return err
 	}
 
@@ -485,6 +681,25 @@ func (c *Cluster) Shutdown() error {
 	return nil
 }
 
+// drain gives the API and the PinTracker up to ShutdownDrainTimeout to
+// finish in-flight operations. The API is drained first so that it
+// refuses new work while the tracker finishes.
+func (c *Cluster) drain() {
+	ctx, cancel := context.WithTimeout(c.ctx, c.config.ShutdownDrainTimeout)
+	defer cancel()
+
+	c.logger.Infof("draining ongoing operations (up to %s)", c.config.ShutdownDrainTimeout)
+	for _, comp := range []Component{c.api, c.tracker} {
+		d, ok := comp.(Drainer)
+		if !ok {
+			continue
+		}
+		if err := d.Drain(ctx); err != nil {
+			c.logger.Warningf("draining: %s", err)
+		}
+	}
+}
+
 // Done provides a way to learn if the Peer has been shutdown
 // (for example, because it has been removed from the Cluster)
 func (c *Cluster) Done() <-chan struct{} {
@@ -495,16 +710,7 @@ func (c *Cluster) Done() <-chan struct{} {
 func (c *Cluster) ID() api.ID {
 	// ignore error since it is included in response object
 	ipfsID, _ := c.ipfs.ID()
-	var addrs []ma.Multiaddr
-
-	addrsSet := make(map[string]struct{}) // to filter dups
-	for _, addr := range c.host.Addrs() {
-		addrsSet[addr.String()] = struct{}{}
-	}
-	for k := range addrsSet {
-		addr, _ := ma.NewMultiaddr(k)
-		addrs = append(addrs, api.MustLibp2pMultiaddrJoin(addr, c.id))
-	}
+	addrs := c.hostAddresses()
 
 	peers := []peer.ID{}
 	// This method might get called very early by a remote peer
@@ -520,11 +726,27 @@ func (c *Cluster) ID() api.ID {
 		ClusterPeers:          peers,
 		ClusterPeersAddresses: c.peerManager.PeersAddresses(peers),
 		Version:               Version,
-		Commit:                Commit,
+		Commit:                c.commit,
 		RPCProtocolVersion:    RPCProtocol,
 		IPFS:                  ipfsID,
 		Peername:              c.config.Peername,
+		Tags:                  c.config.Tags,
+	}
+}
+
+// hostAddresses returns the listening addresses of this peer,
+// including the /ipfs/<peerID> part.
+func (c *Cluster) hostAddresses() []ma.Multiaddr {
+	var addrs []ma.Multiaddr
+	addrsSet := make(map[string]struct{}) // to filter dups
+	for _, addr := range c.host.Addrs() {
+		addrsSet[addr.String()] = struct{}{}
 	}
+	for k := range addrsSet {
+		addr, _ := ma.NewMultiaddr(k)
+		addrs = append(addrs, api.MustLibp2pMultiaddrJoin(addr, c.id))
+	}
+	return addrs
 }
 
 // PeerAdd adds a new peer to this Cluster.
@@ -534,12 +756,25 @@ func (c *Cluster) ID() api.ID {
 // list of peers). The new peer should be a single-peer cluster,
 // preferable without any relevant state.
 func (c *Cluster) PeerAdd(addr ma.Multiaddr) (api.ID, error) {
+	return c.PeerAddWithProgress(addr, nil)
+}
+
+// PeerAddWithProgress works like PeerAdd but it sends an
+// api.PeerAddProgress on the given channel every time a stage of the
+// process is completed, allowing to find out where a slow addition is
+// stuck. The channel must be read until it is closed, which happens when
+// the operation finishes. A nil channel disables progress reporting.
+func (c *Cluster) PeerAddWithProgress(addr ma.Multiaddr, progress chan<- api.PeerAddProgress) (api.ID, error) {
+	if progress != nil {
+		defer close(progress)
+	}
+
 	// starting 10 nodes on the same box for testing
 	// causes deadlock and a global lock here
 	// seems to help.
 	c.paMux.Lock()
 	defer c.paMux.Unlock()
-	logger.Debugf("peerAdd called with %s", addr)
+	c.logger.Debugf("peerAdd called with %s", addr)
 	pid, decapAddr, err := api.Libp2pMultiaddrSplit(addr)
 	if err != nil {
 		id := api.ID{
@@ -547,6 +782,11 @@ func (c *Cluster) PeerAdd(addr ma.Multiaddr) (api.ID, error) {
 		}
 		return id, err
 	}
+	if c.peerBlacklist.has(pid) {
+		err := errPeerBlacklisted(pid)
+		c.logger.Error(err)
+		return api.ID{ID: pid, Error: err.Error()}, err
+	}
 
 	// Figure out its real address if we have one
 	remoteAddr := getRemoteMultiaddr(c.host, pid, decapAddr)
@@ -554,7 +794,7 @@ func (c *Cluster) PeerAdd(addr ma.Multiaddr) (api.ID, error) {
 	// whisper address to everyone, including ourselves
 	peers, err := c.consensus.Peers()
 	if err != nil {
-		logger.Error(err)
+		c.logger.Error(err)
 		return api.ID{Error: err.Error()}, err
 	}
 
@@ -574,12 +814,12 @@ func (c *Cluster) PeerAdd(addr ma.Multiaddr) (api.ID, error) {
 	for i, e := range errs {
 		if e != nil {
 			brk = true
-			logger.Errorf("%s: %s", peers[i].Pretty(), e)
+			c.logger.Errorf("%s: %s", peers[i].Pretty(), e)
 		}
 	}
 	if brk {
 		msg := "error broadcasting new peer's address: all cluster members need to be healthy for this operation to succeed. Try removing any unhealthy peers. Check the logs for more information about the error."
-		logger.Error(msg)
+		c.logger.Error(msg)
 		id := api.ID{ID: pid, Error: "error broadcasting new peer's address"}
 		return id, errors.New(msg)
 	}
@@ -590,10 +830,11 @@ func (c *Cluster) PeerAdd(addr ma.Multiaddr) (api.ID, error) {
 	err = c.rpcClient.Call(pid, "Cluster",
 		"RemoteMultiaddrForPeer", c.id, &addrSerial)
 	if err != nil {
-		logger.Error(err)
+		c.logger.Error(err)
 		id := api.ID{ID: pid, Error: err.Error()}
 		return id, err
 	}
+	c.peerAddProgress(progress, pid, api.PeerAddStageReachable)
 
 	// Send cluster peers to the new peer.
 	clusterPeers := append(c.peerManager.PeersAddresses(peers),
@@ -604,16 +845,32 @@ func (c *Cluster) PeerAdd(addr ma.Multiaddr) (api.ID, error) {
 		api.MultiaddrsToSerial(clusterPeers),
 		&struct{}{})
 	if err != nil {
-		logger.Error(err)
+		c.logger.Error(err)
+	} else {
+		c.peerAddProgress(progress, pid, api.PeerAddStagePeersetPushed)
 	}
 
 	// Log the new peer in the log so everyone gets it.
 	err = c.consensus.AddPeer(pid)
 	if err != nil {
-		logger.Error(err)
+		c.logger.Error(err)
 		id := api.ID{ID: pid, Error: err.Error()}
 		return id, err
 	}
+	c.peerAddProgress(progress, pid, api.PeerAddStageConsensus)
+
+	// Send the pinset too, so the new peer does not need
+	// to wait for the consensus to catch up to start tracking.
+	// This may take long for large states, so it is not done
+	// in the PeerAdd path.
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		err := c.pushState(pid)
+		if err != nil {
+			c.logger.Warningf("could not push the state to %s: %s", pid.Pretty(), err)
+		}
+	}()
 
 	// Ask the new peer to connect its IPFS daemon to the rest
 	err = c.rpcClient.Call(pid,
@@ -622,7 +879,7 @@ func (c *Cluster) PeerAdd(addr ma.Multiaddr) (api.ID, error) {
 		struct{}{},
 		&struct{}{})
 	if err != nil {
-		logger.Error(err)
+		c.logger.Error(err)
 	}
 
 	id := api.ID{}
@@ -640,43 +897,172 @@ func (c *Cluster) PeerAdd(addr ma.Multiaddr) (api.ID, error) {
 		newNodePeers := id.ClusterPeers
 		added, removed := diffPeers(ownPeers, newNodePeers)
 		if len(added) == 0 && len(removed) == 0 {
-			break // the new peer has fully joined
+			// the new peer has fully joined
+			c.peerAddProgress(progress, pid, api.PeerAddStagePeersetSynced)
+			break
 		}
 		time.Sleep(200 * time.Millisecond)
-		logger.Debugf("%s addPeer: retrying to get ID from %s",
+		c.logger.Debugf("%s addPeer: retrying to get ID from %s",
 			c.id.Pretty(), pid.Pretty())
 	}
 	return id, nil
 }
 
+// peerAddProgress sends a progress notification, if progress is not nil,
+// unless the cluster is shutting down.
+func (c *Cluster) peerAddProgress(progress chan<- api.PeerAddProgress, pid peer.ID, stage api.PeerAddStage) {
+	if progress == nil {
+		return
+	}
+	c.logger.Debugf("peerAdd %s: %s", pid.Pretty(), stage)
+	select {
+	case progress <- api.PeerAddProgress{Peer: pid, Stage: stage}:
+	case <-c.ctx.Done():
+	}
+}
+
 // PeerRemove removes a peer from this Cluster.
 //
 // The peer will be removed from the consensus peerset, all it's content
 // will be re-pinned and the peer it will shut itself down.
-func (c *Cluster) PeerRemove(pid peer.ID) error {
+//
+// The removal is refused when the remaining peers would not be able to
+// sustain a quorum (see PeerRemoveForce). The result carries warnings
+// about the removal, i.e. when it leaves pins under-replicated.
+func (c *Cluster) PeerRemove(pid peer.ID) (api.PeerRemoval, error) {
+	err := c.validatePeerRemove(pid)
+	if err != nil {
+		c.logger.Error(err)
+		return api.PeerRemoval{}, err
+	}
+	return c.PeerRemoveForce(pid)
+}
+
+// PeerRemoveForce removes a peer from this Cluster like PeerRemove, but
+// without checking that the remaining peers can sustain a quorum.
+func (c *Cluster) PeerRemoveForce(pid peer.ID) (api.PeerRemoval, error) {
+	removal := api.PeerRemoval{}
+	if w := c.warnUnderReplicated(pid); w != "" {
+		removal.Warnings = append(removal.Warnings, w)
+	}
+
 	// We need to repin before removing the peer, otherwise, it won't
 	// be able to submit the pins.
-	logger.Infof("re-allocating all CIDs directly associated to %s", pid)
+	c.logger.Infof("re-allocating all CIDs directly associated to %s", pid)
 	c.repinFromPeer(pid)
 
 	err := c.consensus.RmPeer(pid)
 	if err != nil {
-		logger.Error(err)
+		c.logger.Error(err)
+		return removal, err
+	}
+
+	return removal, nil
+}
+
+// PeerRotate replaces the ID of a cluster peer by a new one, i.e. when
+// the peer's private key has been changed after being compromised. All
+// pins allocated to the old ID are re-allocated to the new one, so that
+// the content already pinned by the peer's ipfs daemon is not re-pinned
+// elsewhere, and the old ID is removed from the peerset. The peer should
+// then be started with its new key and join the cluster (bootstrap).
+//
+// The peer being rotated can be offline during the operation, as long as
+// the rest of the cluster has quorum. The steps are not atomic, but the
+// operation can safely be retried when it fails half-way.
+func (c *Cluster) PeerRotate(oldID, newID peer.ID) error {
+	c.logger.Infof("rotating peer ID %s -> %s", oldID.Pretty(), newID.Pretty())
+	if oldID == newID {
+		return errors.New("the old and the new peer IDs are the same")
+	}
+
+	cState, err := c.consensus.State()
+	if err != nil {
+		c.logger.Error(err)
 		return err
 	}
+	for _, pin := range cState.List() {
+		if !containsPeer(pin.Allocations, oldID) {
+			continue
+		}
+		allocs := make([]peer.ID, 0, len(pin.Allocations))
+		for _, p := range pin.Allocations {
+			if p == oldID {
+				p = newID
+			}
+			if !containsPeer(allocs, p) {
+				allocs = append(allocs, p)
+			}
+		}
+		pin.Allocations = allocs
+		err = c.consensus.LogPin(pin)
+		if err != nil {
+			c.logger.Errorf("re-allocating %s to %s: %s", pin.Cid, newID.Pretty(), err)
+			return err
+		}
+	}
 
+	err = c.consensus.RmPeer(oldID)
+	if err != nil {
+		c.logger.Error(err)
+		return err
+	}
+	c.logger.Infof("peer ID %s rotated to %s", oldID.Pretty(), newID.Pretty())
 	return nil
 }
 
+// TransferLeadership asks the current consensus leader to hand
+// leadership over to the given peer, i.e. before taking the leader
+// down for maintenance. Not all consensus components support it.
+func (c *Cluster) TransferLeadership(pid peer.ID) error {
+	lt, ok := c.consensus.(LeadershipTransferer)
+	if !ok {
+		return errors.New("the consensus component does not support leadership transfers")
+	}
+	c.logger.Infof("transferring consensus leadership to %s", pid.Pretty())
+	return lt.TransferLeadership(pid)
+}
+
+// PinsetChanges returns up to limit changes (adds and removes) applied to
+// the shared state by this peer after the given index, so that external
+// systems (i.e. search indexes) can follow the pinset. When there are no
+// changes yet, it waits up to the given time for new ones. Indexes belong
+// to the epoch returned with the changes: when a different one is given,
+// a resync is requested. Not all consensus components support it.
+func (c *Cluster) PinsetChanges(epoch string, since uint64, limit int, wait time.Duration) (api.PinsetChanges, error) {
+	cl, ok := c.consensus.(PinsetChangeLogger)
+	if !ok {
+		return api.PinsetChanges{}, errors.New("the consensus component does not keep a log of pinset changes")
+	}
+	return cl.PinsetChanges(epoch, since, limit, wait)
+}
+
 // Join adds this peer to an existing cluster. The calling peer should
-// be a single-peer cluster node. This is almost equivalent to calling
-// PeerAdd on the destination cluster.
+// be a single-peer cluster node with an empty shared state, otherwise an
+// error explaining why it cannot join is returned (pins from a previous
+// state can be added after joining with MergePins). This is almost
+// equivalent to calling PeerAdd on the destination cluster.
 func (c *Cluster) Join(addr ma.Multiaddr) error {
-	logger.Debugf("Join(%s)", addr)
+	return c.JoinWithProgress(addr, nil)
+}
+
+// JoinWithProgress works like Join but reports progress on the given
+// channel. Only the stages which this peer can observe are reported, as
+// they complete: PeerAddStageReachable once connected to the given peer,
+// PeerAddStageConsensus once the remote PeerAdd call has returned (it
+// returns after logging this peer to the consensus) and
+// PeerAddStageStateSynced once the shared state has caught up and been
+// synced to the pin tracker. The channel is closed when the operation
+// finishes.
+func (c *Cluster) JoinWithProgress(addr ma.Multiaddr, progress chan<- api.PeerAddProgress) error {
+	if progress != nil {
+		defer close(progress)
+	}
+	c.logger.Debugf("Join(%s)", addr)
 
 	pid, _, err := api.Libp2pMultiaddrSplit(addr)
 	if err != nil {
-		logger.Error(err)
+		c.logger.Error(err)
 		return err
 	}
 
@@ -685,29 +1071,61 @@ func (c *Cluster) Join(addr ma.Multiaddr) error {
 		return nil
 	}
 
+	if c.peerBlacklist.has(pid) {
+		err := errPeerBlacklisted(pid)
+		c.logger.Error(err)
+		return err
+	}
+
+	err = c.validateJoin(pid)
+	if err != nil {
+		c.logger.Error(err)
+		return err
+	}
+
 	// Add peer to peerstore so we can talk to it
 	c.peerManager.ImportPeer(addr, true)
+	if c.host.Network().Connectedness(pid) == inet.Connected {
+		c.peerAddProgress(progress, c.id, api.PeerAddStageReachable)
+	}
 
 	// Note that PeerAdd() on the remote peer will
 	// figure out what our real address is (obviously not
-	// ListenAddr).
+	// ListenAddr). Any other member of the cluster can add
+	// us when the given peer does not answer.
+	myAddr := api.MultiaddrToSerial(
+		api.MustLibp2pMultiaddrJoin(c.config.ListenAddr, c.id))
 	var myID api.IDSerial
 	err = c.rpcClient.Call(pid,
 		"Cluster",
 		"PeerAdd",
-		api.MultiaddrToSerial(
-			api.MustLibp2pMultiaddrJoin(c.config.ListenAddr, c.id)),
+		myAddr,
 		&myID)
 	if err != nil {
-		logger.Error(err)
+		c.logger.Errorf("adding this peer through %s: %s", pid.Pretty(), err)
+		for _, candidate := range c.joinCandidates(pid) {
+			err = c.rpcClient.Call(candidate,
+				"Cluster",
+				"PeerAdd",
+				myAddr,
+				&myID)
+			if err == nil {
+				c.logger.Warningf("%s did not answer. Joined through %s", pid.Pretty(), candidate.Pretty())
+				break
+			}
+			c.logger.Errorf("adding this peer through %s: %s", candidate.Pretty(), err)
+		}
+	}
+	if err != nil {
 		return err
 	}
+	c.peerAddProgress(progress, c.id, api.PeerAddStageConsensus)
 
 	// wait for leader and for state to catch up
 	// then sync
 	err = c.consensus.WaitForSync()
 	if err != nil {
-		logger.Error(err)
+		c.logger.Error(err)
 		return err
 	}
 
@@ -715,27 +1133,41 @@ func (c *Cluster) Join(addr ma.Multiaddr) error {
 	// peers or we won't notice.
 	peers, err := c.consensus.Peers()
 	if err != nil {
-		logger.Error(err)
+		c.logger.Error(err)
 	} else {
 		c.peerManager.SavePeerstoreForPeers(peers)
 	}
 
 	c.StateSync()
+	c.peerAddProgress(progress, c.id, api.PeerAddStageStateSynced)
 
-	logger.Infof("%s: joined %s's cluster", c.id.Pretty(), pid.Pretty())
+	c.logger.Infof("%s: joined %s's cluster", c.id.Pretty(), pid.Pretty())
 	return nil
 }
 
 // StateSync syncs the consensus state to the Pin Tracker, ensuring
 // that every Cid in the shared state is tracked and that the Pin Tracker
 // is not tracking more Cids than it should.
+//
+// The first call resumes from the tracker checkpoint, if any, so that
+// only the items which changed since are tracked again. Items are handed
+// to the tracker in batches of Config.StateSyncBatchSize, waiting for
+// the pending pin operations to go under that number before every batch.
 func (c *Cluster) StateSync() error {
 	cState, err := c.consensus.State()
 	if err != nil {
 		return err
 	}
 
-	logger.Debug("syncing state to tracker")
+	// Make sure the tracker is paused before tracking anything.
+	sharedCfg := cState.SharedConfig()
+	c.applySharedConfig(sharedCfg)
+
+	c.checkpointOnce.Do(func() {
+		c.restoreCheckpoint(cState)
+	})
+
+	c.logger.Debug("syncing state to tracker")
 	clusterPins := cState.List()
 
 	trackedPins := c.tracker.StatusAll()
@@ -744,13 +1176,24 @@ func (c *Cluster) StateSync() error {
 		trackedPinsMap[tpin.Cid.String()] = i
 	}
 
-	// Track items which are not tracked
+	// Track items which are not tracked. Queued items are not
+	// processed while paused, so there is no point in waiting then.
+	batch := c.config.StateSyncBatchSize
+	var n int
 	for _, pin := range clusterPins {
 		_, tracked := trackedPinsMap[pin.Cid.String()]
-		if !tracked {
-			logger.Debugf("StateSync: tracking %s, part of the shared state", pin.Cid)
-			c.tracker.Track(pin)
+		if tracked {
+			continue
+		}
+		if n > 0 && n%batch == 0 && !sharedCfg.Paused {
+			err := c.waitPendingPins(batch)
+			if err != nil {
+				return err
+			}
 		}
+		c.logger.Debugf("StateSync: tracking %s, part of the shared state", pin.Cid)
+		c.track(pin)
+		n++
 	}
 
 	// a. Untrack items which should not be tracked
@@ -760,21 +1203,25 @@ func (c *Cluster) StateSync() error {
 		pCid := p.Cid
 		currentPin := cState.Get(pCid)
 		has := cState.Has(pCid)
-		allocatedHere := containsPeer(currentPin.Allocations, c.id) || currentPin.ReplicationFactorMin == -1
+		allocatedHere := containsPeer(currentPin.Allocations, c.id) || currentPin.IsPinEverywhere()
 
 		switch {
 		case !has:
-			logger.Debugf("StateSync: Untracking %s, is not part of shared state", pCid)
+			c.logger.Debugf("StateSync: Untracking %s, is not part of shared state", pCid)
 			c.tracker.Untrack(pCid)
 		case p.Status == api.TrackerStatusRemote && allocatedHere:
-			logger.Debugf("StateSync: Tracking %s locally (currently remote)", pCid)
-			c.tracker.Track(currentPin)
+			c.logger.Debugf("StateSync: Tracking %s locally (currently remote)", pCid)
+			c.track(currentPin)
 		case p.Status == api.TrackerStatusPinned && !allocatedHere:
-			logger.Debugf("StateSync: Tracking %s as remote (currently local)", pCid)
-			c.tracker.Track(currentPin)
+			c.logger.Debugf("StateSync: Tracking %s as remote (currently local)", pCid)
+			c.track(currentPin)
 		}
 	}
 
+	err = c.saveCheckpoint()
+	if err != nil {
+		c.logger.Errorf("error saving the tracker checkpoint: %s", err)
+	}
 	return nil
 }
 
@@ -782,7 +1229,17 @@ func (c *Cluster) StateSync() error {
 // If an error happens, the slice will contain as much information as
 // could be fetched from other peers.
 func (c *Cluster) StatusAll() ([]api.GlobalPinInfo, error) {
-	return c.globalPinInfoSlice("TrackerStatusAll")
+	return c.globalPinInfoSlice(c.ctx, "TrackerStatusAll", struct{}{}, 0)
+}
+
+// StatusAllPartial works like StatusAll but it only waits up to the given
+// timeout for the cluster peers to answer. Peers which have not replied by
+// then are included in the results with TrackerStatusTimedOut.
+func (c *Cluster) StatusAllPartial(timeout time.Duration) ([]api.GlobalPinInfo, error) {
+	if timeout <= 0 {
+		return nil, errors.New("partial status requests need a positive timeout")
+	}
+	return c.globalPinInfoSlice(c.ctx, "TrackerStatusAll", struct{}{}, timeout)
 }
 
 // StatusAllLocal returns the PinInfo for all the tracked Cids in this peer.
@@ -790,11 +1247,99 @@ func (c *Cluster) StatusAllLocal() []api.PinInfo {
 	return c.tracker.StatusAll()
 }
 
+// StatusShard works like StatusAll but only returns the items which
+// belong to the given shard (see api.PinShard). Every peer only sends
+// the status of those items, which allows to audit very large pinsets in
+// bounded chunks.
+func (c *Cluster) StatusShard(shard api.PinShard) ([]api.GlobalPinInfo, error) {
+	if err := shard.Validate(); err != nil {
+		return nil, err
+	}
+	return c.globalPinInfoSlice(c.ctx, "StatusShardLocal", shard, 0)
+}
+
+// StatusShardLocal returns the PinInfo for the Cids tracked in this peer
+// which belong to the given shard.
+func (c *Cluster) StatusShardLocal(shard api.PinShard) ([]api.PinInfo, error) {
+	if err := shard.Validate(); err != nil {
+		return nil, err
+	}
+	var pinfos []api.PinInfo
+	for _, pinfo := range c.tracker.StatusAll() {
+		if shard.Contains(pinfo.Cid) {
+			pinfos = append(pinfos, pinfo)
+		}
+	}
+	return pinfos, nil
+}
+
 // Status returns the GlobalPinInfo for a given Cid as fetched from all
 // current peers. If an error happens, the GlobalPinInfo should contain
 // as much information as could be fetched from the other peers.
 func (c *Cluster) Status(h *cid.Cid) (api.GlobalPinInfo, error) {
-	return c.globalPinInfoCid("TrackerStatus", h)
+	return c.globalPinInfoCid("TrackerStatus", h, 0)
+}
+
+// PinDetail returns the status of the given Cid in all current peers, as
+// Status() does, along with its entry in the shared state, when it is part
+// of it.
+func (c *Cluster) PinDetail(h *cid.Cid) (api.PinDetail, error) {
+	gpi, err := c.Status(h)
+	detail := api.PinDetail{
+		GlobalPinInfo: gpi,
+	}
+	if err != nil {
+		return detail, err
+	}
+	if pin, ok := c.getCurrentPin(h); ok {
+		detail.Pin = &pin
+	}
+	return detail, nil
+}
+
+// DAGStats walks the DAG of a pinned Cid in the IPFS daemon of one of the
+// peers it is allocated to (this peer when possible) and returns
+// statistics about it. Allocations are tried in order until one of them
+// succeeds.
+func (c *Cluster) DAGStats(h *cid.Cid) (api.DAGStats, error) {
+	pin, err := c.PinGet(h)
+	if err != nil {
+		return api.DAGStats{}, err
+	}
+
+	candidates := []peer.ID{c.id}
+	if !pin.IsPinEverywhere() && !containsPeer(pin.Allocations, c.id) {
+		candidates = pin.Allocations
+	}
+	if len(candidates) == 0 {
+		return api.DAGStats{}, errors.New("the cid is not allocated to any peer")
+	}
+
+	for _, p := range candidates {
+		var stats api.DAGStatsSerial
+		err = c.rpcClient.Call(p,
+			"Cluster",
+			"IPFSDAGStats",
+			pin.ToSerial(),
+			&stats)
+		if err == nil {
+			res := stats.ToDAGStats()
+			res.Peer = p
+			return res, nil
+		}
+		c.logger.Errorf("obtaining DAG stats for %s from %s: %s", h, p.Pretty(), err)
+	}
+	return api.DAGStats{}, err
+}
+
+// StatusPartial works like Status but it only waits up to the given timeout
+// for the cluster peers to answer. Peers which have not replied by then
+// are included in the GlobalPinInfo with TrackerStatusTimedOut.
+func (c *Cluster) StatusPartial(h *cid.Cid, timeout time.Duration) (api.GlobalPinInfo, error) {
+	if timeout <= 0 {
+		return api.GlobalPinInfo{}, errors.New("partial status requests need a positive timeout")
+	}
+	return c.globalPinInfoCid("TrackerStatus", h, timeout)
 }
 
 // StatusLocal returns this peer's PinInfo for a given Cid.
@@ -807,7 +1352,7 @@ func (c *Cluster) StatusLocal(h *cid.Cid) api.PinInfo {
 // and returning the results as GlobalPinInfo. If an error happens, the slice
 // will contain as much information as could be fetched from the peers.
 func (c *Cluster) SyncAll() ([]api.GlobalPinInfo, error) {
-	return c.globalPinInfoSlice("SyncAllLocal")
+	return c.globalPinInfoSlice(c.ctx, "SyncAllLocal", struct{}{}, 0)
 }
 
 // SyncAllLocal makes sure that the current state for all tracked items
@@ -820,8 +1365,8 @@ func (c *Cluster) SyncAllLocal() ([]api.PinInfo, error) {
 	// Despite errors, tracker provides synced items that we can provide.
 	// They encapsulate the error.
 	if err != nil {
-		logger.Error("tracker.Sync() returned with error: ", err)
-		logger.Error("Is the ipfs daemon running?")
+		c.logger.Error("tracker.Sync() returned with error: ", err)
+		c.logger.Error("Is the ipfs daemon running?")
 	}
 	return syncedItems, err
 }
@@ -829,7 +1374,7 @@ func (c *Cluster) SyncAllLocal() ([]api.PinInfo, error) {
 // Sync triggers a SyncLocal() operation for a given Cid.
 // in all cluster peers.
 func (c *Cluster) Sync(h *cid.Cid) (api.GlobalPinInfo, error) {
-	return c.globalPinInfoCid("SyncLocal", h)
+	return c.globalPinInfoCid("SyncLocal", h, 0)
 }
 
 // SyncLocal performs a local sync operation for the given Cid. This will
@@ -841,8 +1386,8 @@ func (c *Cluster) SyncLocal(h *cid.Cid) (api.PinInfo, error) {
 	// Despite errors, trackers provides an updated PinInfo so
 	// we just log it.
 	if err != nil {
-		logger.Error("tracker.SyncCid() returned with error: ", err)
-		logger.Error("Is the ipfs daemon running?")
+		c.logger.Error("tracker.SyncCid() returned with error: ", err)
+		c.logger.Error("Is the ipfs daemon running?")
 	}
 	return pInfo, err
 }
@@ -856,7 +1401,110 @@ func (c *Cluster) RecoverAllLocal() ([]api.PinInfo, error) {
 // Recover triggers a recover operation for a given Cid in all
 // cluster peers.
 func (c *Cluster) Recover(h *cid.Cid) (api.GlobalPinInfo, error) {
-	return c.globalPinInfoCid("TrackerRecover", h)
+	return c.globalPinInfoCid("TrackerRecover", h, 0)
+}
+
+// RecoverAll triggers Recover operations for every tracked Cid whose
+// status in any cluster peer matches the given filter ("error" for any
+// error status, or a status name, see api.RecoverAllRequest). At most
+// concurrency items are recovered at the same time. Failures to recover an
+// item are logged and reflected in its returned GlobalPinInfo.
+func (c *Cluster) RecoverAll(filter string, concurrency int) ([]api.GlobalPinInfo, error) {
+	return c.recoverAll(c.ctx, filter, concurrency, nil)
+}
+
+// recoverAll implements RecoverAll. It stops recovering items when the
+// context is cancelled, returning those recovered so far, and reports
+// its progress to the given function when not nil.
+func (c *Cluster) recoverAll(
+	ctx context.Context,
+	filter string,
+	concurrency int,
+	progress func(done, total int),
+) ([]api.GlobalPinInfo, error) {
+	match, err := recoverFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	gpis, err := c.StatusAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var selected []*cid.Cid
+	for _, gpi := range gpis {
+		for _, pinfo := range gpi.PeerMap {
+			if match(pinfo.Status) {
+				selected = append(selected, gpi.Cid)
+				break
+			}
+		}
+	}
+	c.logger.Infof("recovering %d items (filter: %s)", len(selected), filter)
+	if progress != nil {
+		progress(0, len(selected))
+	}
+
+	results := make([]api.GlobalPinInfo, len(selected), len(selected))
+	recovered := make([]bool, len(selected), len(selected))
+	done := 0
+	var mux sync.Mutex
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, h := range selected {
+		sem <- struct{}{}
+		if ctx.Err() != nil {
+			<-sem
+			break
+		}
+		wg.Add(1)
+		go func(i int, h *cid.Cid) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			gpi, err := c.Recover(h)
+			if err != nil {
+				c.logger.Errorf("recovering %s: %s", h, err)
+			}
+			mux.Lock()
+			results[i] = gpi
+			recovered[i] = true
+			done++
+			if progress != nil {
+				progress(done, len(selected))
+			}
+			mux.Unlock()
+		}(i, h)
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		var partial []api.GlobalPinInfo
+		for i, gpi := range results {
+			if recovered[i] {
+				partial = append(partial, gpi)
+			}
+		}
+		return partial, err
+	}
+	return results, nil
+}
+
+// recoverFilter returns a function matching the statuses selected by
+// a RecoverAll filter. An empty filter means "error".
+func recoverFilter(filter string) (func(api.TrackerStatus) bool, error) {
+	switch filter {
+	case "", "error":
+		return func(st api.TrackerStatus) bool { return st.IsError() }, nil
+	}
+	want := api.TrackerStatusFromString(filter)
+	if want == api.TrackerStatusBug {
+		return nil, fmt.Errorf("invalid recover filter: %s", filter)
+	}
+	return func(st api.TrackerStatus) bool { return st == want }, nil
 }
 
 // RecoverLocal triggers a recover operation for a given Cid in this peer only.
@@ -872,13 +1520,19 @@ func (c *Cluster) RecoverLocal(h *cid.Cid) (api.PinInfo, error) {
 func (c *Cluster) Pins() []api.Pin {
 	cState, err := c.consensus.State()
 	if err != nil {
-		logger.Error(err)
+		c.logger.Error(err)
 		return []api.Pin{}
 	}
 
 	return cState.List()
 }
 
+// SearchPins returns the pins in the current global state which match
+// the given query (see api.PinQuery).
+func (c *Cluster) SearchPins(q api.PinQuery) []api.Pin {
+	return q.Search(c.Pins())
+}
+
 // PinGet returns information for a single Cid managed by Cluster.
 // The information is obtained from the current global state. The
 // returned api.Pin provides information about the allocations
@@ -909,6 +1563,9 @@ func (c *Cluster) PinGet(h *cid.Cid) (api.Pin, error) {
 // this set then the remaining peers are allocated in order from the rest of
 // the cluster.  Priority allocations are best effort.  If any priority peers
 // are unavailable then Pin will simply allocate from the rest of the cluster.
+//
+// Pins with a Group are only allocated to the members of that peer group
+// (see Config.PeerGroups).
 func (c *Cluster) Pin(pin api.Pin) error {
 	_, err := c.pin(pin, []peer.ID{}, pin.Allocations)
 	return err
@@ -922,14 +1579,46 @@ func (c *Cluster) pin(pin api.Pin, blacklist []peer.ID, prioritylist []peer.ID)
 	if pin.Cid == nil {
 		return false, errors.New("bad pin object")
 	}
+	if pin.RequestID == "" {
+		pin.RequestID = api.NewRequestID()
+	}
+	var addedBy peer.ID
+	if pin.Signer != nil {
+		// Keep what was signed so that the peer committing the
+		// pin can verify it after we modify it below.
+		if pin.SignedPayload == nil {
+			pin.SignedPayload = pin.SigningPayload(api.PinOpPin)
+		}
+		signer, err := pin.VerifySignedRequest(api.PinOpPin)
+		if err == nil {
+			addedBy = signer
+		} else {
+			pin = pin.StripRequest()
+		}
+	}
+	// Equivalent CIDv0 and CIDv1 forms are stored as the same item
+	pin.Cid = api.CanonicalCid(pin.Cid)
+	if c.blocklist.has(pin.Cid) {
+		return false, errBlocklisted(pin.Cid)
+	}
 	rplMin := pin.ReplicationFactorMin
 	rplMax := pin.ReplicationFactorMax
+
+	// Setting one of the factors to "everywhere" is enough,
+	// regardless of the default factors.
+	everywhere := api.ReplicationFactorEverywhere
+	if (rplMin == everywhere && rplMax == 0) || (rplMin == 0 && rplMax == everywhere) {
+		rplMin, rplMax = everywhere, everywhere
+		pin.ReplicationFactorMin, pin.ReplicationFactorMax = everywhere, everywhere
+	}
+
+	defaultMin, defaultMax := c.defaultReplicationFactors()
 	if rplMin == 0 {
-		rplMin = c.config.ReplicationFactorMin
+		rplMin = defaultMin
 		pin.ReplicationFactorMin = rplMin
 	}
 	if rplMax == 0 {
-		rplMax = c.config.ReplicationFactorMax
+		rplMax = defaultMax
 		pin.ReplicationFactorMax = rplMax
 	}
 
@@ -937,9 +1626,28 @@ func (c *Cluster) pin(pin api.Pin, blacklist []peer.ID, prioritylist []peer.ID)
 		return false, err
 	}
 
+	var groupMembers []peer.ID
+	if pin.Group != "" {
+		members, err := c.peerGroup(pin.Group)
+		if err != nil {
+			return false, err
+		}
+		outside, err := c.peersOutsideGroup(members)
+		if err != nil {
+			return false, err
+		}
+		groupMembers = members
+		blacklist = append(outside, blacklist...)
+	}
+
 	switch {
-	case rplMin == -1 && rplMax == -1:
+	case pin.IsPinEverywhere():
+		// Allocated to every current and future peer.
 		pin.Allocations = []peer.ID{}
+	case rplMin == everywhere && rplMax == everywhere:
+		// Pinned everywhere in a peer group: allocated
+		// to all its members.
+		pin.Allocations = groupMembers
 	default:
 		allocs, err := c.allocate(pin.Cid, rplMin, rplMax, blacklist, prioritylist)
 		if err != nil {
@@ -948,16 +1656,30 @@ func (c *Cluster) pin(pin api.Pin, blacklist []peer.ID, prioritylist []peer.ID)
 		pin.Allocations = allocs
 	}
 
-	if curr, _ := c.getCurrentPin(pin.Cid); curr.Equals(pin) {
+	curr, exists := c.getCurrentPin(pin.Cid)
+	if exists {
+		// Keep the details of when the pin was created
+		pin.Timestamp = curr.Timestamp
+		pin.AddedBy = curr.AddedBy
+	} else {
+		pin.Timestamp = time.Now()
+		pin.AddedBy = addedBy
+	}
+
+	if curr.Equals(pin) {
 		// skip pinning
-		logger.Debugf("pinning %s skipped: already correctly allocated", pin.Cid)
+		c.logger.Debugf("pinning %s skipped: already correctly allocated (request %s)", pin.Cid, pin.RequestID)
 		return false, nil
 	}
 
+	if !exists && c.config.CanaryPinning && len(pin.Allocations) > 1 {
+		return true, c.pinCanary(pin)
+	}
+
 	if len(pin.Allocations) == 0 {
-		logger.Infof("IPFS cluster pinning %s everywhere:", pin.Cid)
+		c.logger.Infof("IPFS cluster pinning %s everywhere (request %s)", pin.Cid, pin.RequestID)
 	} else {
-		logger.Infof("IPFS cluster pinning %s on %s:", pin.Cid, pin.Allocations)
+		c.logger.Infof("IPFS cluster pinning %s on %s (request %s)", pin.Cid, pin.Allocations, pin.RequestID)
 	}
 
 	return true, c.consensus.LogPin(pin)
@@ -970,10 +1692,25 @@ func (c *Cluster) pin(pin api.Pin, blacklist []peer.ID, prioritylist []peer.ID)
 // to the global state. Unpin does not reflect the success or failure
 // of underlying IPFS daemon unpinning operations.
 func (c *Cluster) Unpin(h *cid.Cid) error {
-	logger.Info("IPFS cluster unpinning:", h)
+	return c.unpin(api.Pin{Cid: h, RequestID: api.NewRequestID()})
+}
+
+// unpin is Unpin for the Cid of the given pin, which carries the ID of the
+// request (see Pin.RequestID) and, optionally, its signature.
+func (c *Cluster) unpin(req api.Pin) error {
+	h := api.CanonicalCid(req.Cid)
+	c.logger.Infof("IPFS cluster unpinning %s (request %s)", h, req.RequestID)
 
 	pin := api.Pin{
-		Cid: h,
+		Cid:       h,
+		RequestID: req.RequestID,
+	}
+	if req.Signer != nil {
+		pin.Signer = req.Signer
+		pin.Signature = req.Signature
+		pin.Nonce = req.Nonce
+		pin.Expires = req.Expires
+		pin.SignedPayload = pin.SigningPayload(api.PinOpUnpin)
 	}
 
 	err := c.consensus.LogUnpin(pin)
@@ -983,27 +1720,165 @@ func (c *Cluster) Unpin(h *cid.Cid) error {
 	return nil
 }
 
+// UnpinMatching unpins all the pins in the global state matching the
+// given query, and returns them. The query must select pins by Name or
+// Metadata. When dryRun is true, nothing is unpinned and the returned
+// pins are those which would have been removed.
+//
+// If unpinning any of the pins fails, UnpinMatching stops and returns
+// the pins which were unpinned until then, along with an error reporting
+// how many were.
+func (c *Cluster) UnpinMatching(q api.PinQuery, dryRun bool) ([]api.Pin, error) {
+	if q.IsEmpty() {
+		return nil, errors.New("a name or metadata selector (other than \"*\") is needed to unpin in bulk")
+	}
+
+	pins := c.SearchPins(q)
+	if dryRun {
+		return pins, nil
+	}
+
+	c.logger.Infof("IPFS cluster unpinning %d pins matching the selector", len(pins))
+	unpinned := make([]api.Pin, 0, len(pins))
+	for _, p := range pins {
+		err := c.Unpin(p.Cid)
+		if err != nil {
+			err = fmt.Errorf(
+				"unpinned %d of %d matching pins before failing: %s",
+				len(unpinned),
+				len(pins),
+				err,
+			)
+			c.logger.Error(err)
+			return unpinned, err
+		}
+		unpinned = append(unpinned, p)
+	}
+	return unpinned, nil
+}
+
+// verifyPublisher checks, when Config.AuthorizedPublishers is set, that
+// the given pin carries a valid signature for the operation made by one
+// of the authorized publishers, and that the request has not been seen
+// before.
+func (c *Cluster) verifyPublisher(op string, pin api.Pin) error {
+	if len(c.config.AuthorizedPublishers) == 0 {
+		return nil
+	}
+
+	signer, err := pin.VerifySignature(op)
+	if err != nil {
+		return fmt.Errorf("%s request rejected: %s", op, err)
+	}
+	if !containsPeer(c.config.AuthorizedPublishers, signer) {
+		return fmt.Errorf("%s request rejected: %s is not an authorized publisher", op, signer.Pretty())
+	}
+	err = c.nonces.use(pin.Nonce, pin.Expires)
+	if err != nil {
+		return fmt.Errorf("%s request rejected: %s", op, err)
+	}
+	return nil
+}
+
+// verifyCommit checks, when Config.AuthorizedPublishers is set, the
+// operations which other peers ask us to commit to the shared state. They
+// must carry the request signed by an authorized publisher, as verified
+// by the peer which received it. Updates of existing pins which keep
+// every field set by the publisher (i.e. re-allocations) need no
+// signature, since they are made by the cluster itself.
+func (c *Cluster) verifyCommit(op string, pin api.Pin) error {
+	if len(c.config.AuthorizedPublishers) == 0 {
+		return nil
+	}
+
+	if op == api.PinOpPin && pin.Signer == nil {
+		curr, exists := c.getCurrentPin(pin.Cid)
+		if exists && sameRequestedFields(curr, pin) {
+			return nil
+		}
+	}
+
+	signer, err := pin.VerifySignedRequest(op)
+	if err != nil {
+		return fmt.Errorf("%s commit rejected: %s", op, err)
+	}
+	if !containsPeer(c.config.AuthorizedPublishers, signer) {
+		return fmt.Errorf("%s commit rejected: %s is not an authorized publisher", op, signer.Pretty())
+	}
+	return c.nonces.use(pin.Nonce, pin.Expires)
+}
+
+// sameRequestedFields returns true when both pins have the same values in
+// the fields which are set by whoever requests the pin, rather than by
+// the cluster.
+func sameRequestedFields(pin1, pin2 api.Pin) bool {
+	pin1.Allocations = pin2.Allocations
+	pin1.ReplicationFactorMin = pin2.ReplicationFactorMin
+	pin1.ReplicationFactorMax = pin2.ReplicationFactorMax
+	return pin1.Equals(pin2)
+}
+
 // Version returns the current IPFS Cluster version.
 func (c *Cluster) Version() string {
 	return Version
 }
 
 // Peers returns the IDs of the members of this Cluster.
+//
+// The last ID obtained from every peer is cached, and Peers returns the
+// cached IDs while refreshing them in the background, so unresponsive
+// peers do not slow it down. Their FetchedAt field indicates when they
+// were obtained. Use QueryPeers with Refresh to get up-to-date IDs.
 func (c *Cluster) Peers() []api.ID {
 	members, err := c.consensus.Peers()
 	if err != nil {
-		logger.Error(err)
-		logger.Error("an empty list of peers will be returned")
+		c.logger.Error(err)
+		c.logger.Error("an empty list of peers will be returned")
 		return []api.ID{}
 	}
+	c.peerIDCache.retain(members)
+	return c.cachedPeersIDs(members)
+}
 
+// QueryPeers works like Peers, but the results are sorted by peer ID and
+// paginated as indicated by the query. Only the peers in the requested
+// page are contacted, and only when the query asks to Refresh their
+// cached IDs or they are not cached yet. When the query is Local, no peer is contacted and
+// the information is taken from the peerstore and the monitor of this
+// peer, which is useful in clusters with hundreds of peers.
+func (c *Cluster) QueryPeers(q api.PeersQuery) []api.ID {
+	members, err := c.consensus.Peers()
+	if err != nil {
+		c.logger.Error(err)
+		c.logger.Error("an empty list of peers will be returned")
+		return []api.ID{}
+	}
+	c.peerIDCache.retain(members)
+	members = q.Paginate(members)
+
+	if q.Local {
+		return c.localPeersIDs(members)
+	}
+
+	var peers []api.ID
+	if q.Refresh {
+		peers = c.fetchPeersIDs(members)
+	} else {
+		peers = c.cachedPeersIDs(members)
+	}
+	c.setPeersHealth(peers)
+	return peers
+}
+
+// peersIDs asks the given peers for their ID.
+func (c *Cluster) peersIDs(members []peer.ID) []api.ID {
 	peersSerial := make([]api.IDSerial, len(members), len(members))
 	peers := make([]api.ID, len(members), len(members))
 
 	ctxs, cancels := rpcutil.CtxsWithCancel(c.ctx, len(members))
 	defer rpcutil.MultiCancel(cancels)
 
-	errs := c.rpcClient.MultiCall(
+	errs := c.multiCall(
 		ctxs,
 		members,
 		"Cluster",
@@ -1025,7 +1900,132 @@ func (c *Cluster) Peers() []api.ID {
 	return peers
 }
 
-func (c *Cluster) globalPinInfoCid(method string, h *cid.Cid) (api.GlobalPinInfo, error) {
+// localPeersIDs builds the IDs of the given peers using only
+// the information known by this peer.
+func (c *Cluster) localPeersIDs(members []peer.ID) []api.ID {
+	peers := make([]api.ID, len(members), len(members))
+	for i, p := range members {
+		var addrs []ma.Multiaddr
+		if p == c.id {
+			addrs = c.hostAddresses()
+		} else {
+			addrs = c.peerManager.PeersAddresses([]peer.ID{p})
+		}
+		peers[i] = api.ID{
+			ID:        p,
+			Addresses: addrs,
+		}
+	}
+	c.setPeersHealth(peers)
+	return peers
+}
+
+// lastHeartbeats returns the time at which the last valid ping
+// metric of every peer was produced, according to our monitor.
+func (c *Cluster) lastHeartbeats() map[peer.ID]time.Time {
+	heartbeats := make(map[peer.ID]time.Time)
+	for _, m := range c.Metrics("ping") {
+		heartbeats[m.Peer] = time.Unix(0, m.Expire).Add(-c.pingMetricTTL())
+	}
+	return heartbeats
+}
+
+// setPeersHealth sets the LastHeartbeat of the given peers and the
+// latest values of the metrics which tell about their health: the
+// informer metric (i.e. free space), pending pins and pin errors.
+func (c *Cluster) setPeersHealth(peers []api.ID) {
+	heartbeats := c.lastHeartbeats()
+	values := c.healthMetrics()
+	for i := range peers {
+		peers[i].LastHeartbeat = heartbeats[peers[i].ID]
+		peers[i].Metrics = values[peers[i].ID]
+	}
+}
+
+// healthMetrics returns, for every peer, the latest valid values of the
+// metrics which tell about its health, by metric name.
+func (c *Cluster) healthMetrics() map[peer.ID]map[string]string {
+	names := []string{c.informer.Name(), pendingMetricName, pinErrorsMetricName, goroutinesMetricName}
+	values := make(map[peer.ID]map[string]string)
+	for _, name := range names {
+		for _, m := range c.Metrics(name) {
+			if values[m.Peer] == nil {
+				values[m.Peer] = make(map[string]string)
+			}
+			values[m.Peer][name] = m.Value
+		}
+	}
+	return values
+}
+
+// PeerHealth returns the health of every current cluster peer according
+// to the heartbeats and metrics collected by the peer monitor of this
+// peer. Peers whose heartbeat has expired are reported as not alive: an
+// alert is triggered for them and the leader re-allocates their pins.
+func (c *Cluster) PeerHealth() ([]api.PeerHealth, error) {
+	members, err := c.consensus.Peers()
+	if err != nil {
+		return nil, err
+	}
+
+	heartbeats := c.lastHeartbeats()
+	values := c.healthMetrics()
+	health := make([]api.PeerHealth, len(members), len(members))
+	for i, p := range members {
+		last, ok := heartbeats[p]
+		health[i] = api.PeerHealth{
+			Peer:          p,
+			Alive:         ok,
+			LastHeartbeat: last,
+			Metrics:       values[p],
+		}
+	}
+	return health, nil
+}
+
+// Metrics returns the latest valid metrics of the given name (i.e.
+// "freespace" or "ping") received from every current cluster peer.
+// Expired metrics, and metrics from peers which have left the cluster,
+// are not included.
+func (c *Cluster) Metrics(name string) []api.Metric {
+	return c.monitor.LatestMetrics(name)
+}
+
+// pingMetricTTL returns the TTL with which ping metrics are published.
+func (c *Cluster) pingMetricTTL() time.Duration {
+	return c.config.MonitorPingInterval * 2
+}
+
+// ConsensusState returns information about the consensus layer as seen
+// by this peer: the current leader, the raft term, the last applied index
+// and which peers are voters. It allows to check whether the cluster
+// has quorum.
+func (c *Cluster) ConsensusState() (api.ConsensusState, error) {
+	return c.consensus.Status()
+}
+
+// multiCallCtxs returns the contexts, derived from ctx, used for a
+// broadcast to n peers. When timeout is positive, the contexts expire
+// after it.
+func (c *Cluster) multiCallCtxs(ctx context.Context, n int, timeout time.Duration) ([]context.Context, []context.CancelFunc) {
+	if timeout > 0 {
+		return rpcutil.CtxsWithTimeout(ctx, n, timeout)
+	}
+	return rpcutil.CtxsWithCancel(ctx, n)
+}
+
+// timedOut returns true when the given error says that a request did not
+// complete before its deadline. Errors from remote peers arrive as strings,
+// so the message is compared too.
+func timedOut(err error) bool {
+	if err == nil {
+		return false
+	}
+	return err == context.DeadlineExceeded ||
+		strings.Contains(err.Error(), context.DeadlineExceeded.Error())
+}
+
+func (c *Cluster) globalPinInfoCid(method string, h *cid.Cid, timeout time.Duration) (api.GlobalPinInfo, error) {
 	pin := api.GlobalPinInfo{
 		Cid:     h,
 		PeerMap: make(map[peer.ID]api.PinInfo),
@@ -1033,7 +2033,7 @@ func (c *Cluster) globalPinInfoCid(method string, h *cid.Cid) (api.GlobalPinInfo
 
 	members, err := c.consensus.Peers()
 	if err != nil {
-		logger.Error(err)
+		c.logger.Error(err)
 		return api.GlobalPinInfo{}, err
 	}
 
@@ -1042,10 +2042,10 @@ func (c *Cluster) globalPinInfoCid(method string, h *cid.Cid) (api.GlobalPinInfo
 		Cid: h,
 	}
 
-	ctxs, cancels := rpcutil.CtxsWithCancel(c.ctx, len(members))
+	ctxs, cancels := c.multiCallCtxs(c.ctx, len(members), timeout)
 	defer rpcutil.MultiCancel(cancels)
 
-	errs := c.rpcClient.MultiCall(
+	errs := c.multiCall(
 		ctxs,
 		members,
 		"Cluster",
@@ -1070,10 +2070,22 @@ func (c *Cluster) globalPinInfoCid(method string, h *cid.Cid) (api.GlobalPinInfo
 
 		// Deal with error cases (err != nil): wrap errors in PinInfo
 
+		// The peer did not answer before the deadline.
+		if timeout > 0 && timedOut(e) {
+			pin.PeerMap[members[i]] = api.PinInfo{
+				Cid:    h,
+				Peer:   members[i],
+				Status: api.TrackerStatusTimedOut,
+				TS:     time.Now(),
+				Error:  e.Error(),
+			}
+			continue
+		}
+
 		// In this case, we had no answer at all. The contacted peer
 		// must be offline or unreachable.
 		if r.Status == api.TrackerStatusBug {
-			logger.Errorf("%s: error in broadcast response from %s: %s ", c.id, members[i], e)
+			c.logger.Errorf("%s: error in broadcast response from %s: %s ", c.id, members[i], e)
 			pin.PeerMap[members[i]] = api.PinInfo{
 				Cid:    h,
 				Peer:   members[i],
@@ -1088,30 +2100,36 @@ func (c *Cluster) globalPinInfoCid(method string, h *cid.Cid) (api.GlobalPinInfo
 		}
 	}
 
-	return pin, nil
+	infos := []api.GlobalPinInfo{pin}
+	c.setPinStateInfo(infos)
+	return infos[0], nil
 }
 
-func (c *Cluster) globalPinInfoSlice(method string) ([]api.GlobalPinInfo, error) {
+// globalPinInfoSlice calls the given method in every cluster peer and
+// merges their replies. When the context is cancelled before all peers
+// have answered, it returns what was obtained along with the context
+// error.
+func (c *Cluster) globalPinInfoSlice(ctx context.Context, method string, args interface{}, timeout time.Duration) ([]api.GlobalPinInfo, error) {
 	var infos []api.GlobalPinInfo
 	fullMap := make(map[string]api.GlobalPinInfo)
 
 	members, err := c.consensus.Peers()
 	if err != nil {
-		logger.Error(err)
+		c.logger.Error(err)
 		return []api.GlobalPinInfo{}, err
 	}
 
 	replies := make([][]api.PinInfoSerial, len(members), len(members))
 
-	ctxs, cancels := rpcutil.CtxsWithCancel(c.ctx, len(members))
+	ctxs, cancels := c.multiCallCtxs(ctx, len(members), timeout)
 	defer rpcutil.MultiCancel(cancels)
 
-	errs := c.rpcClient.MultiCall(
+	errs := c.multiCall(
 		ctxs,
 		members,
 		"Cluster",
 		method,
-		struct{}{},
+		args,
 		rpcutil.CopyPinInfoSerialSliceToIfaces(replies),
 	)
 
@@ -1133,43 +2151,104 @@ func (c *Cluster) globalPinInfoSlice(method string) ([]api.GlobalPinInfo, error)
 	}
 
 	erroredPeers := make(map[peer.ID]string)
+	timedOutPeers := make(map[peer.ID]string)
+	interrupted := false
 	for i, r := range replies {
-		if e := errs[i]; e != nil { // This error must come from not being able to contact that cluster member
-			logger.Errorf("%s: error in broadcast response from %s: %s ", c.id, members[i], e)
-			erroredPeers[members[i]] = e.Error()
-		} else {
+		e := errs[i]
+		switch {
+		case e == nil:
 			mergePins(r)
+		case ctx.Err() != nil:
+			// cancelled by the caller
+			interrupted = true
+			erroredPeers[members[i]] = e.Error()
+		case timeout > 0 && timedOut(e):
+			c.logger.Warningf("%s: %s did not answer before the deadline", c.id, members[i])
+			timedOutPeers[members[i]] = e.Error()
+		default: // This error must come from not being able to contact that cluster member
+			c.logger.Errorf("%s: error in broadcast response from %s: %s ", c.id, members[i], e)
+			erroredPeers[members[i]] = e.Error()
 		}
 	}
 
 	// Merge any errors
-	for p, msg := range erroredPeers {
-		for cidStr := range fullMap {
-			c, _ := cid.Decode(cidStr)
-			fullMap[cidStr].PeerMap[p] = api.PinInfo{
-				Cid:    c,
-				Peer:   p,
-				Status: api.TrackerStatusClusterError,
-				TS:     time.Now(),
-				Error:  msg,
+	mergeErrors := func(peers map[peer.ID]string, status api.TrackerStatus) {
+		for p, msg := range peers {
+			for cidStr := range fullMap {
+				c, _ := cid.Decode(cidStr)
+				fullMap[cidStr].PeerMap[p] = api.PinInfo{
+					Cid:    c,
+					Peer:   p,
+					Status: status,
+					TS:     time.Now(),
+					Error:  msg,
+				}
 			}
 		}
 	}
+	mergeErrors(erroredPeers, api.TrackerStatusClusterError)
+	mergeErrors(timedOutPeers, api.TrackerStatusTimedOut)
 
 	for _, v := range fullMap {
 		infos = append(infos, v)
 	}
 
+	c.setPinStateInfo(infos)
+	if interrupted {
+		return infos, ctx.Err()
+	}
 	return infos, nil
 }
 
+// setPinStateInfo completes the given GlobalPinInfos with the information
+// from the corresponding pins in the shared state: the Timestamp and the
+// Allocations, which are compared with the peers reporting the item as
+// pinned.
+func (c *Cluster) setPinStateInfo(infos []api.GlobalPinInfo) {
+	cState, err := c.consensus.State()
+	if err != nil {
+		c.logger.Debug(err)
+		return
+	}
+	for i := range infos {
+		if infos[i].Cid == nil {
+			continue
+		}
+
+		allocs := []peer.ID{}
+		if cState.Has(infos[i].Cid) {
+			pin := cState.Get(infos[i].Cid)
+			infos[i].Timestamp = pin.Timestamp
+			allocs = append(allocs, pin.Allocations...)
+			if len(allocs) == 0 { // pinned everywhere
+				for p := range infos[i].PeerMap {
+					allocs = append(allocs, p)
+				}
+			}
+		}
+
+		pinnedBy := []peer.ID{}
+		for p, pinfo := range infos[i].PeerMap {
+			if pinfo.Status == api.TrackerStatusPinned {
+				pinnedBy = append(pinnedBy, p)
+			}
+		}
+
+		sortPeers(allocs)
+		sortPeers(pinnedBy)
+		infos[i].Allocations = allocs
+		infos[i].PinnedBy = pinnedBy
+		infos[i].Match = samePeers(allocs, pinnedBy)
+	}
+}
+
 func (c *Cluster) getIDForPeer(pid peer.ID) (api.ID, error) {
 	idSerial := api.ID{ID: pid}.ToSerial()
 	err := c.rpcClient.Call(
 		pid, "Cluster", "ID", struct{}{}, &idSerial)
 	id := idSerial.ToID()
 	if err != nil {
-		logger.Error(err)
+		c.logger.Error(err)
 		id.Error = err.Error()
 	}
 	return id, err
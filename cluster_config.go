@@ -11,6 +11,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/ipfs/ipfs-cluster/api"
 	"github.com/ipfs/ipfs-cluster/config"
 
 	crypto "github.com/libp2p/go-libp2p-crypto"
@@ -23,17 +24,40 @@ const configKey = "cluster"
 
 // Configuration defaults
 const (
-	DefaultConfigCrypto        = crypto.RSA
-	DefaultConfigKeyLength     = 2048
-	DefaultListenAddr          = "/ip4/0.0.0.0/tcp/9096"
-	DefaultStateSyncInterval   = 600 * time.Second
-	DefaultIPFSSyncInterval    = 130 * time.Second
-	DefaultMonitorPingInterval = 15 * time.Second
-	DefaultPeerWatchInterval   = 5 * time.Second
-	DefaultReplicationFactor   = -1
-	DefaultLeaveOnShutdown     = false
-	DefaultDisableRepinning    = false
-	DefaultPeerstoreFile       = "peerstore"
+	DefaultConfigCrypto            = crypto.RSA
+	DefaultConfigKeyLength         = 2048
+	DefaultListenAddr              = "/ip4/0.0.0.0/tcp/9096"
+	DefaultStateSyncInterval       = 600 * time.Second
+	DefaultIPFSSyncInterval        = 130 * time.Second
+	DefaultMonitorPingInterval     = 15 * time.Second
+	DefaultPeerWatchInterval       = 5 * time.Second
+	DefaultReplicationFactor       = -1
+	DefaultLeaveOnShutdown         = false
+	DefaultLeaveTimeout            = 10 * time.Second
+	DefaultDisableRepinning        = false
+	DefaultPeerstoreFile           = "peerstore"
+	DefaultShutdownDrainTimeout    = 10 * time.Second
+	DefaultSplitBrainCheckInterval = 1 * time.Minute
+	DefaultRPCAuditLog             = false
+	DefaultRebalanceAllocations    = false
+	DefaultAllocationHysteresis    = 0.0
+	DefaultTierMigrationInterval   = 10 * time.Minute
+	DefaultTierMigrationBatch      = 10
+	DefaultCanaryPinning           = false
+	DefaultCanaryTimeout           = 1 * time.Hour
+	DefaultCanaryFile              = "canaries.json"
+	DefaultMaxPendingPins          = 0
+	DefaultAllocationWeight        = 1.0
+	DefaultBreakerThreshold        = 3
+	DefaultBreakerCooldown         = 30 * time.Second
+	DefaultTrackerCheckpointFile   = "tracker_checkpoint.json"
+	DefaultPeerBlacklistFile       = "peer_blacklist.json"
+	DefaultStateSyncBatchSize      = 1000
+	DefaultStartupCheck            = false
+	DefaultConsensusStartTimeout   = 30 * time.Second
+	DefaultStartupCheckConcurrency = 10
+	DefaultBlocklistUpdateInterval = 1 * time.Hour
+	DefaultMaxConcurrentBroadcasts = 0
 )
 
 // Config is the configuration object containing customizable variables to
@@ -57,15 +81,33 @@ type Config struct {
 	// 64 characters and contain only hexadecimal characters (`[0-9a-f]`).
 	Secret []byte
 
+	// protector is set by NewClusterHost when the host uses a private
+	// network, so that the secret can be rotated without a restart.
+	protector *swappableProtector
+
 	// Leave Cluster on shutdown. Politely informs other peers
 	// of the departure and removes itself from the consensus
 	// peer set. The Cluster size will be reduced by one.
 	LeaveOnShutdown bool
 
+	// LeaveTimeout is the maximum time that a peer leaving the
+	// cluster on shutdown waits for its removal to be committed and
+	// seen by its own consensus component before stopping it.
+	LeaveTimeout time.Duration
+
 	// Listen parameters for the Cluster libp2p Host. Used by
 	// the RPC and Consensus components.
 	ListenAddr ma.Multiaddr
 
+	// AnnounceAddrs, when set, replace the addresses of the libp2p Host
+	// as the ones advertised to other peers (in the ID and by the libp2p
+	// identify protocol). AppendAnnounceAddrs are advertised in addition
+	// and NoAnnounceAddrs are never advertised. This allows NATed or
+	// multi-interface peers to only publish reachable addresses.
+	AnnounceAddrs       []ma.Multiaddr
+	AppendAnnounceAddrs []ma.Multiaddr
+	NoAnnounceAddrs     []ma.Multiaddr
+
 	// Time between syncs of the consensus state to the
 	// tracker state. Normally states are synced anyway, but this helps
 	// when new nodes are joining the cluster. Reduce for faster
@@ -103,6 +145,22 @@ type Config struct {
 	// of this value.
 	MonitorPingInterval time.Duration
 
+	// RebalanceAllocations enables rebalancing the allocations of items
+	// when they are re-pinned: current allocations are replaced by the
+	// candidates preferred by the allocator (see AllocationHysteresis).
+	// When false (default), valid allocations are always kept.
+	RebalanceAllocations bool
+
+	// AllocationHysteresis reduces the churn caused by
+	// RebalanceAllocations. A current allocation is only replaced by
+	// a candidate preferred by the allocator when their metric values
+	// differ by more than this fraction (i.e. 0.1 for 10%), so that pins
+	// do not move between peers whose metrics oscillate around each
+	// other. Larger values mean fewer moves. With 0, every preferred
+	// candidate replaces a current allocation, and with 1 or more
+	// only allocations vetoed by the allocator are replaced.
+	AllocationHysteresis float64
+
 	// PeerWatchInterval is the frequency that we use to watch for changes
 	// in the consensus peerset and save new peers to the configuration
 	// file. This also affects how soon we realize that we have
@@ -118,29 +176,225 @@ type Config struct {
 	// Peerstore file specifies the file on which we persist the
 	// libp2p host peerstore addresses. This file is regularly saved.
 	PeerstoreFile string
+
+	// ShutdownDrainTimeout is the maximum time that the peer waits,
+	// when shutting down, for in-flight pin and unpin operations to
+	// finish. During this period the API refuses new work.
+	ShutdownDrainTimeout time.Duration
+
+	// SplitBrainCheckInterval is the frequency with which the peer
+	// compares the consensus state (leader and peerset) seen by every
+	// cluster peer, in order to detect and alert about split brain
+	// situations.
+	SplitBrainCheckInterval time.Duration
+
+	// AuthorizedPublishers lists the peer IDs corresponding to the keys
+	// allowed to sign pin and unpin requests. When set, requests
+	// received through the APIs must be signed by one of them (see
+	// api.Pin.Sign()). This allows clusters where many followers can
+	// submit requests to restrict pin management to a set of publishers.
+	AuthorizedPublishers []peer.ID
+
+	// RPCAuditLog enables logging every RPC request received from
+	// other peers. Counters for these requests are kept regardless
+	// (see Cluster.RPCStats()).
+	RPCAuditLog bool
+
+	// TrustedPeers lists the only peers which are allowed to originate
+	// consensus operations (pin, unpin, adding and removing peers)
+	// through this peer, for example when it is the leader, and whose
+	// updates are applied when using the CRDT consensus. Requests from
+	// other peers are rejected. When empty, all peers are trusted.
+	// This provides some protection when running semi-open clusters.
+	// Metrics are only accepted from trusted peers too, so that others
+	// cannot steer allocations: untrusted peers are not allocated pins.
+	TrustedPeers []peer.ID
+
+	// PeerGroups defines named groups of peers (i.e. "ssd-tier"). Pins
+	// which specify a group are only allocated to its members. Groups
+	// should be defined identically in all peers.
+	PeerGroups map[string][]peer.ID
+
+	// TierPolicies define when pins are automatically moved from a
+	// peer group to another (i.e. after some time). The leader checks
+	// them every TierMigrationInterval and migrates up to
+	// TierMigrationBatch pins each time.
+	TierPolicies          []TierPolicy
+	TierMigrationInterval time.Duration
+	TierMigrationBatch    int
+
+	// CanaryPinning makes new pins allocated to several peers be
+	// pinned first by a single one of them (the canary). The rest of
+	// the allocations are only added once the canary has pinned the
+	// content successfully. Pins which fail on the canary, or take
+	// longer than CanaryTimeout, stay allocated to the canary only and
+	// the failure is logged. This protects the cluster from
+	// pathological DAGs. Pins allocated everywhere are not affected.
+	CanaryPinning bool
+	CanaryTimeout time.Duration
+
+	// CanaryFile is the file, relative to BaseDir, in which the pins
+	// waiting for their canary are saved, so that they are extended
+	// when this peer restarts.
+	CanaryFile string
+
+	// MaxPendingPins caps the number of pin operations (queued or
+	// pinning) that the peers of the cluster should have pending, in
+	// total. Pins requested while over the cap are still committed, but
+	// the API reports back-pressure so that clients slow down. 0 means
+	// no limit.
+	MaxPendingPins int
+
+	// AllocationWeight scales how attractive this peer is to the
+	// allocator, i.e. a big machine can use 4 so that, with the same
+	// metric values, it is preferred over peers with weight 1. It
+	// is announced along with the informer metrics. Allocators which
+	// do not sort peers by their metric values ignore it.
+	AllocationWeight float64
+
+	// BreakerThreshold is the number of consecutive failed RPC calls
+	// after which a peer is skipped in broadcasts (i.e. Status or Sync
+	// for all pins) for BreakerCooldown. Its entries are reported
+	// as errored right away, rather than after waiting for the call
+	// to time out. 0 disables circuit breaking.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	// TrackerCheckpointFile is the file, relative to BaseDir, in which
+	// the items pinned or tracked as remote by this peer are saved
+	// after every StateSync and on shutdown. On restart, items whose
+	// pin did not change since are restored from it, rather than
+	// re-tracked, so that only the differences with the shared state
+	// cause pin and unpin operations.
+	TrackerCheckpointFile string
+
+	// PeerBlacklistFile is the file, relative to BaseDir, in which the
+	// IDs of the peers blacklisted with PeerBlacklistAdd are saved. This
+	// peer refuses to add blacklisted peers, to answer their RPC
+	// requests and to include them in the consensus peerset.
+	PeerBlacklistFile string
+
+	// StateSyncBatchSize is the number of items that StateSync hands to
+	// the tracker at once. Before handing the next batch, it waits until
+	// the tracker has fewer pending pin operations than this.
+	StateSyncBatchSize int
+
+	// StartupCheck enables a consistency check when the peer starts,
+	// right after the first StateSync. The items allocated to this peer
+	// in the shared state are compared with their last known status in
+	// the tracker and with the pins in the IPFS daemon. Discrepancies
+	// are repaired (re-pinning missing items) before the peer is
+	// declared ready. StartupCheckConcurrency items are repaired at
+	// the same time.
+	StartupCheck            bool
+	StartupCheckConcurrency int
+
+	// ConsensusStartTimeout specifies how long to wait for the
+	// consensus component to be ready (i.e. to catch up with the shared
+	// state) when starting. The peer shuts down when it is not ready by
+	// then. It should be increased for large states or slow disks.
+	ConsensusStartTimeout time.Duration
+
+	// BlocklistFile (relative to BaseDir, unless absolute) and
+	// BlocklistURL point to lists of Cids, one per line, which the
+	// cluster refuses to pin. Lines starting with "#" are ignored. Both
+	// are re-read every BlocklistUpdateInterval, and the leader then
+	// unpins any blocklisted item from the cluster. Blocklists should
+	// be the same in all peers.
+	BlocklistFile           string
+	BlocklistURL            string
+	BlocklistUpdateInterval time.Duration
+
+	// StateTransferLimit is the average number of bytes per second
+	// that this peer sends when transferring the shared state to other
+	// peers (the pins pushed to peers being added and the snapshots
+	// served to peers repairing their state). When many peers sync at
+	// once, it prevents these transfers from saturating the links used
+	// by consensus. 0 means no limit.
+	StateTransferLimit uint64
+
+	// MaxConcurrentBroadcasts caps the number of requests to all
+	// cluster peers (i.e. global status, sync or recover calls) that
+	// this peer performs at the same time. Every broadcast runs a
+	// goroutine per peer: further broadcasts wait until a running one
+	// finishes. 0 means no limit.
+	MaxConcurrentBroadcasts int
+
+	// EnableFaultInjection allows operators to make this peer simulate
+	// failures (IPFS errors, slow RPC, dropped metrics) through the
+	// API (see Cluster.InjectFaults). It should only be enabled in
+	// staging clusters.
+	EnableFaultInjection bool
+
+	// Tags describe this peer, i.e. {"region": "eu-west", "rack": "r12"}.
+	// They are included in its ID and used to report how pins are spread
+	// across failure domains (see Cluster.FailureDomains).
+	Tags map[string]string
 }
 
 // configJSON represents a Cluster configuration as it will look when it is
 // saved using JSON. Most configuration keys are converted into simple types
 // like strings, and key names aim to be self-explanatory for the user.
 type configJSON struct {
-	ID                   string   `json:"id"`
-	Peername             string   `json:"peername"`
-	PrivateKey           string   `json:"private_key"`
-	Secret               string   `json:"secret"`
-	Peers                []string `json:"peers,omitempty"`     // DEPRECATED
-	Bootstrap            []string `json:"bootstrap,omitempty"` // DEPRECATED
-	LeaveOnShutdown      bool     `json:"leave_on_shutdown"`
-	ListenMultiaddress   string   `json:"listen_multiaddress"`
-	StateSyncInterval    string   `json:"state_sync_interval"`
-	IPFSSyncInterval     string   `json:"ipfs_sync_interval"`
-	ReplicationFactor    int      `json:"replication_factor,omitempty"` // legacy
-	ReplicationFactorMin int      `json:"replication_factor_min"`
-	ReplicationFactorMax int      `json:"replication_factor_max"`
-	MonitorPingInterval  string   `json:"monitor_ping_interval"`
-	PeerWatchInterval    string   `json:"peer_watch_interval"`
-	DisableRepinning     bool     `json:"disable_repinning"`
-	PeerstoreFile        string   `json:"peerstore_file,omitempty"`
+	ID                      string   `json:"id"`
+	Peername                string   `json:"peername"`
+	PrivateKey              string   `json:"private_key"`
+	Secret                  string   `json:"secret"`
+	Peers                   []string `json:"peers,omitempty"`     // DEPRECATED
+	Bootstrap               []string `json:"bootstrap,omitempty"` // DEPRECATED
+	LeaveOnShutdown         bool     `json:"leave_on_shutdown"`
+	LeaveTimeout            string   `json:"leave_timeout"`
+	ListenMultiaddress      string   `json:"listen_multiaddress"`
+	AnnounceMultiaddress    []string `json:"announce_multiaddress,omitempty"`
+	AppendAnnounce          []string `json:"append_announce_multiaddress,omitempty"`
+	NoAnnounce              []string `json:"no_announce_multiaddress,omitempty"`
+	StateSyncInterval       string   `json:"state_sync_interval"`
+	IPFSSyncInterval        string   `json:"ipfs_sync_interval"`
+	ReplicationFactor       int      `json:"replication_factor,omitempty"` // legacy
+	ReplicationFactorMin    int      `json:"replication_factor_min"`
+	ReplicationFactorMax    int      `json:"replication_factor_max"`
+	MonitorPingInterval     string   `json:"monitor_ping_interval"`
+	RebalanceAllocations    bool     `json:"rebalance_allocations"`
+	AllocationHysteresis    float64  `json:"allocation_hysteresis"`
+	PeerWatchInterval       string   `json:"peer_watch_interval"`
+	DisableRepinning        bool     `json:"disable_repinning"`
+	PeerstoreFile           string   `json:"peerstore_file,omitempty"`
+	ShutdownDrainTimeout    string   `json:"shutdown_drain_timeout"`
+	SplitBrainCheckInterval string   `json:"split_brain_check_interval"`
+	AuthorizedPublishers    []string `json:"authorized_publishers,omitempty"`
+	RPCAuditLog             bool     `json:"rpc_audit_log"`
+	TrustedPeers            []string `json:"trusted_peers,omitempty"`
+
+	PeerGroups map[string][]string `json:"peer_groups,omitempty"`
+
+	TierPolicies          []tierPolicyJSON `json:"tier_policies,omitempty"`
+	TierMigrationInterval string           `json:"tier_migration_interval"`
+	TierMigrationBatch    int              `json:"tier_migration_batch"`
+	CanaryPinning         bool             `json:"canary_pinning"`
+	CanaryTimeout         string           `json:"canary_timeout"`
+	CanaryFile            string           `json:"canary_file,omitempty"`
+	MaxPendingPins        int              `json:"max_pending_pins"`
+	AllocationWeight      float64          `json:"allocation_weight"`
+	BreakerThreshold      int              `json:"breaker_threshold"`
+	BreakerCooldown       string           `json:"breaker_cooldown"`
+	TrackerCheckpointFile string           `json:"tracker_checkpoint_file,omitempty"`
+	PeerBlacklistFile     string           `json:"peer_blacklist_file,omitempty"`
+	StateSyncBatchSize    int              `json:"state_sync_batch_size"`
+
+	StartupCheck            bool `json:"startup_check"`
+	StartupCheckConcurrency int  `json:"startup_check_concurrency"`
+
+	ConsensusStartTimeout string `json:"consensus_start_timeout"`
+
+	BlocklistFile           string `json:"blocklist_file,omitempty"`
+	BlocklistURL            string `json:"blocklist_url,omitempty"`
+	BlocklistUpdateInterval string `json:"blocklist_update_interval"`
+
+	StateTransferLimit      uint64 `json:"state_transfer_limit"`
+	MaxConcurrentBroadcasts int    `json:"max_concurrent_broadcasts"`
+	EnableFaultInjection    bool   `json:"enable_fault_injection"`
+
+	Tags map[string]string `json:"tags,omitempty"`
 }
 
 // ConfigKey returns a human-readable string to identify
@@ -156,7 +410,24 @@ func (cfg *Config) ConfigKey() string {
 func (cfg *Config) Default() error {
 	cfg.setDefaults()
 
-	// pid and private key generation --
+	err := cfg.NewIdentity()
+	if err != nil {
+		return err
+	}
+
+	// cluster secret
+	clusterSecret, err := pnet.GenerateV1Bytes()
+	if err != nil {
+		return err
+	}
+	cfg.Secret = (*clusterSecret)[:]
+	// --
+	return nil
+}
+
+// NewIdentity generates a new random private key and sets
+// it, along with the corresponding peer ID, in the Config.
+func (cfg *Config) NewIdentity() error {
 	priv, pub, err := crypto.GenerateKeyPair(
 		DefaultConfigCrypto,
 		DefaultConfigKeyLength)
@@ -169,15 +440,6 @@ func (cfg *Config) Default() error {
 	}
 	cfg.ID = pid
 	cfg.PrivateKey = priv
-	// --
-
-	// cluster secret
-	clusterSecret, err := pnet.GenerateV1Bytes()
-	if err != nil {
-		return err
-	}
-	cfg.Secret = (*clusterSecret)[:]
-	// --
 	return nil
 }
 
@@ -212,10 +474,93 @@ func (cfg *Config) Validate() error {
 		return errors.New("cluster.monitoring_interval is invalid")
 	}
 
+	if cfg.AllocationHysteresis < 0 {
+		return errors.New("cluster.allocation_hysteresis is invalid")
+	}
+
 	if cfg.PeerWatchInterval <= 0 {
 		return errors.New("cluster.peer_watch_interval is invalid")
 	}
 
+	if cfg.LeaveTimeout <= 0 {
+		return errors.New("cluster.leave_timeout is invalid")
+	}
+
+	if cfg.ShutdownDrainTimeout < 0 {
+		return errors.New("cluster.shutdown_drain_timeout is invalid")
+	}
+
+	if cfg.SplitBrainCheckInterval <= 0 {
+		return errors.New("cluster.split_brain_check_interval is invalid")
+	}
+
+	for name, members := range cfg.PeerGroups {
+		if name == "" {
+			return errors.New("cluster.peer_groups: group names cannot be empty")
+		}
+		if len(members) == 0 {
+			return fmt.Errorf("cluster.peer_groups: group %q has no members", name)
+		}
+	}
+
+	if err := validateTierPolicies(cfg.TierPolicies, cfg.PeerGroups); err != nil {
+		return err
+	}
+
+	if cfg.TierMigrationInterval <= 0 {
+		return errors.New("cluster.tier_migration_interval is invalid")
+	}
+
+	if cfg.TierMigrationBatch <= 0 {
+		return errors.New("cluster.tier_migration_batch is invalid")
+	}
+
+	if cfg.CanaryTimeout <= 0 {
+		return errors.New("cluster.canary_timeout is invalid")
+	}
+
+	if cfg.MaxPendingPins < 0 {
+		return errors.New("cluster.max_pending_pins is invalid")
+	}
+
+	if cfg.AllocationWeight <= 0 {
+		return errors.New("cluster.allocation_weight must be positive")
+	}
+
+	if cfg.BreakerThreshold < 0 {
+		return errors.New("cluster.breaker_threshold is invalid")
+	}
+
+	if cfg.BreakerCooldown <= 0 {
+		return errors.New("cluster.breaker_cooldown is invalid")
+	}
+
+	if cfg.StateSyncBatchSize <= 0 {
+		return errors.New("cluster.state_sync_batch_size is invalid")
+	}
+
+	if cfg.ConsensusStartTimeout <= 0 {
+		return errors.New("cluster.consensus_start_timeout is invalid")
+	}
+
+	if cfg.StartupCheckConcurrency <= 0 {
+		return errors.New("cluster.startup_check_concurrency is invalid")
+	}
+
+	if cfg.BlocklistUpdateInterval <= 0 {
+		return errors.New("cluster.blocklist_update_interval is invalid")
+	}
+
+	if cfg.MaxConcurrentBroadcasts < 0 {
+		return errors.New("cluster.max_concurrent_broadcasts is invalid")
+	}
+
+	for k := range cfg.Tags {
+		if k == "" {
+			return errors.New("cluster.tags: tag names cannot be empty")
+		}
+	}
+
 	rfMax := cfg.ReplicationFactorMax
 	rfMin := cfg.ReplicationFactorMin
 
@@ -257,15 +602,50 @@ func (cfg *Config) setDefaults() {
 
 	addr, _ := ma.NewMultiaddr(DefaultListenAddr)
 	cfg.ListenAddr = addr
+	cfg.AnnounceAddrs = nil
+	cfg.AppendAnnounceAddrs = nil
+	cfg.NoAnnounceAddrs = nil
 	cfg.LeaveOnShutdown = DefaultLeaveOnShutdown
 	cfg.StateSyncInterval = DefaultStateSyncInterval
 	cfg.IPFSSyncInterval = DefaultIPFSSyncInterval
 	cfg.ReplicationFactorMin = DefaultReplicationFactor
 	cfg.ReplicationFactorMax = DefaultReplicationFactor
 	cfg.MonitorPingInterval = DefaultMonitorPingInterval
+	cfg.RebalanceAllocations = DefaultRebalanceAllocations
+	cfg.AllocationHysteresis = DefaultAllocationHysteresis
 	cfg.PeerWatchInterval = DefaultPeerWatchInterval
 	cfg.DisableRepinning = DefaultDisableRepinning
 	cfg.PeerstoreFile = "" // empty so it gets ommited.
+	cfg.ShutdownDrainTimeout = DefaultShutdownDrainTimeout
+	cfg.LeaveTimeout = DefaultLeaveTimeout
+	cfg.AuthorizedPublishers = nil
+	cfg.RPCAuditLog = DefaultRPCAuditLog
+	cfg.TrustedPeers = nil
+	cfg.PeerGroups = nil
+	cfg.SplitBrainCheckInterval = DefaultSplitBrainCheckInterval
+	cfg.TierPolicies = nil
+	cfg.TierMigrationInterval = DefaultTierMigrationInterval
+	cfg.TierMigrationBatch = DefaultTierMigrationBatch
+	cfg.CanaryPinning = DefaultCanaryPinning
+	cfg.CanaryTimeout = DefaultCanaryTimeout
+	cfg.CanaryFile = "" // empty so it gets omitted.
+	cfg.MaxPendingPins = DefaultMaxPendingPins
+	cfg.AllocationWeight = DefaultAllocationWeight
+	cfg.BreakerThreshold = DefaultBreakerThreshold
+	cfg.BreakerCooldown = DefaultBreakerCooldown
+	cfg.TrackerCheckpointFile = "" // empty so it gets omitted.
+	cfg.PeerBlacklistFile = ""     // empty so it gets omitted.
+	cfg.StateSyncBatchSize = DefaultStateSyncBatchSize
+	cfg.StartupCheck = DefaultStartupCheck
+	cfg.StartupCheckConcurrency = DefaultStartupCheckConcurrency
+	cfg.ConsensusStartTimeout = DefaultConsensusStartTimeout
+	cfg.BlocklistFile = ""
+	cfg.BlocklistURL = ""
+	cfg.BlocklistUpdateInterval = DefaultBlocklistUpdateInterval
+	cfg.StateTransferLimit = 0
+	cfg.MaxConcurrentBroadcasts = DefaultMaxConcurrentBroadcasts
+	cfg.EnableFaultInjection = false
+	cfg.Tags = nil
 }
 
 // LoadJSON receives a raw json-formatted configuration and
@@ -346,6 +726,19 @@ for more information.`)
 	}
 	cfg.ListenAddr = clusterAddr
 
+	cfg.AnnounceAddrs, err = parseMultiaddrs(jcfg.AnnounceMultiaddress)
+	if err != nil {
+		return fmt.Errorf("error parsing announce_multiaddress: %s", err)
+	}
+	cfg.AppendAnnounceAddrs, err = parseMultiaddrs(jcfg.AppendAnnounce)
+	if err != nil {
+		return fmt.Errorf("error parsing append_announce_multiaddress: %s", err)
+	}
+	cfg.NoAnnounceAddrs, err = parseMultiaddrs(jcfg.NoAnnounce)
+	if err != nil {
+		return fmt.Errorf("error parsing no_announce_multiaddress: %s", err)
+	}
+
 	rplMin := jcfg.ReplicationFactorMin
 	rplMax := jcfg.ReplicationFactorMax
 	if jcfg.ReplicationFactor != 0 { // read min and max
@@ -359,14 +752,89 @@ for more information.`)
 	ipfsSyncInterval := parseDuration(jcfg.IPFSSyncInterval)
 	monitorPingInterval := parseDuration(jcfg.MonitorPingInterval)
 	peerWatchInterval := parseDuration(jcfg.PeerWatchInterval)
+	shutdownDrainTimeout := parseDuration(jcfg.ShutdownDrainTimeout)
+	leaveTimeout := parseDuration(jcfg.LeaveTimeout)
+	splitBrainCheckInterval := parseDuration(jcfg.SplitBrainCheckInterval)
+	tierMigrationInterval := parseDuration(jcfg.TierMigrationInterval)
+	canaryTimeout := parseDuration(jcfg.CanaryTimeout)
+	breakerCooldown := parseDuration(jcfg.BreakerCooldown)
+	blocklistUpdateInterval := parseDuration(jcfg.BlocklistUpdateInterval)
+	consensusStartTimeout := parseDuration(jcfg.ConsensusStartTimeout)
 
 	config.SetIfNotDefault(stateSyncInterval, &cfg.StateSyncInterval)
 	config.SetIfNotDefault(ipfsSyncInterval, &cfg.IPFSSyncInterval)
 	config.SetIfNotDefault(monitorPingInterval, &cfg.MonitorPingInterval)
 	config.SetIfNotDefault(peerWatchInterval, &cfg.PeerWatchInterval)
-
+	config.SetIfNotDefault(shutdownDrainTimeout, &cfg.ShutdownDrainTimeout)
+	config.SetIfNotDefault(leaveTimeout, &cfg.LeaveTimeout)
+	config.SetIfNotDefault(splitBrainCheckInterval, &cfg.SplitBrainCheckInterval)
+	config.SetIfNotDefault(tierMigrationInterval, &cfg.TierMigrationInterval)
+	config.SetIfNotDefault(jcfg.TierMigrationBatch, &cfg.TierMigrationBatch)
+	config.SetIfNotDefault(canaryTimeout, &cfg.CanaryTimeout)
+	config.SetIfNotDefault(jcfg.CanaryFile, &cfg.CanaryFile)
+	config.SetIfNotDefault(jcfg.AllocationWeight, &cfg.AllocationWeight)
+	config.SetIfNotDefault(breakerCooldown, &cfg.BreakerCooldown)
+	config.SetIfNotDefault(jcfg.TrackerCheckpointFile, &cfg.TrackerCheckpointFile)
+	config.SetIfNotDefault(jcfg.PeerBlacklistFile, &cfg.PeerBlacklistFile)
+	config.SetIfNotDefault(jcfg.StateSyncBatchSize, &cfg.StateSyncBatchSize)
+	config.SetIfNotDefault(jcfg.StartupCheckConcurrency, &cfg.StartupCheckConcurrency)
+	config.SetIfNotDefault(consensusStartTimeout, &cfg.ConsensusStartTimeout)
+	config.SetIfNotDefault(jcfg.BlocklistFile, &cfg.BlocklistFile)
+	config.SetIfNotDefault(jcfg.BlocklistURL, &cfg.BlocklistURL)
+	config.SetIfNotDefault(blocklistUpdateInterval, &cfg.BlocklistUpdateInterval)
+
+	cfg.RebalanceAllocations = jcfg.RebalanceAllocations
+	cfg.AllocationHysteresis = jcfg.AllocationHysteresis
 	cfg.LeaveOnShutdown = jcfg.LeaveOnShutdown
 	cfg.DisableRepinning = jcfg.DisableRepinning
+	cfg.RPCAuditLog = jcfg.RPCAuditLog
+	cfg.StartupCheck = jcfg.StartupCheck
+	cfg.StateTransferLimit = jcfg.StateTransferLimit
+	cfg.MaxConcurrentBroadcasts = jcfg.MaxConcurrentBroadcasts
+	cfg.EnableFaultInjection = jcfg.EnableFaultInjection
+	cfg.CanaryPinning = jcfg.CanaryPinning
+	cfg.MaxPendingPins = jcfg.MaxPendingPins
+	cfg.BreakerThreshold = jcfg.BreakerThreshold
+	cfg.Tags = jcfg.Tags
+
+	for _, p := range jcfg.AuthorizedPublishers {
+		pid, err := peer.IDB58Decode(p)
+		if err != nil {
+			return fmt.Errorf("error parsing cluster.authorized_publishers: %s", err)
+		}
+		cfg.AuthorizedPublishers = append(cfg.AuthorizedPublishers, pid)
+	}
+
+	for _, p := range jcfg.TrustedPeers {
+		pid, err := peer.IDB58Decode(p)
+		if err != nil {
+			return fmt.Errorf("error parsing cluster.trusted_peers: %s", err)
+		}
+		cfg.TrustedPeers = append(cfg.TrustedPeers, pid)
+	}
+
+	if len(jcfg.PeerGroups) > 0 {
+		cfg.PeerGroups = make(map[string][]peer.ID)
+	}
+	for name, members := range jcfg.PeerGroups {
+		pids := make([]peer.ID, 0, len(members))
+		for _, p := range members {
+			pid, err := peer.IDB58Decode(p)
+			if err != nil {
+				return fmt.Errorf("error parsing cluster.peer_groups: %s", err)
+			}
+			pids = append(pids, pid)
+		}
+		cfg.PeerGroups[name] = pids
+	}
+
+	for _, jtp := range jcfg.TierPolicies {
+		tp, err := jtp.toTierPolicy()
+		if err != nil {
+			return err
+		}
+		cfg.TierPolicies = append(cfg.TierPolicies, tp)
+	}
 
 	return cfg.Validate()
 }
@@ -398,12 +866,54 @@ func (cfg *Config) ToJSON() (raw []byte, err error) {
 	jcfg.ReplicationFactorMax = cfg.ReplicationFactorMax
 	jcfg.LeaveOnShutdown = cfg.LeaveOnShutdown
 	jcfg.ListenMultiaddress = cfg.ListenAddr.String()
+	jcfg.AnnounceMultiaddress = multiaddrsToStrings(cfg.AnnounceAddrs)
+	jcfg.AppendAnnounce = multiaddrsToStrings(cfg.AppendAnnounceAddrs)
+	jcfg.NoAnnounce = multiaddrsToStrings(cfg.NoAnnounceAddrs)
 	jcfg.StateSyncInterval = cfg.StateSyncInterval.String()
 	jcfg.IPFSSyncInterval = cfg.IPFSSyncInterval.String()
 	jcfg.MonitorPingInterval = cfg.MonitorPingInterval.String()
+	jcfg.RebalanceAllocations = cfg.RebalanceAllocations
+	jcfg.AllocationHysteresis = cfg.AllocationHysteresis
 	jcfg.PeerWatchInterval = cfg.PeerWatchInterval.String()
 	jcfg.DisableRepinning = cfg.DisableRepinning
 	jcfg.PeerstoreFile = cfg.PeerstoreFile
+	jcfg.ShutdownDrainTimeout = cfg.ShutdownDrainTimeout.String()
+	jcfg.LeaveTimeout = cfg.LeaveTimeout.String()
+	jcfg.SplitBrainCheckInterval = cfg.SplitBrainCheckInterval.String()
+	jcfg.AuthorizedPublishers = api.PeersToStrings(cfg.AuthorizedPublishers)
+	jcfg.RPCAuditLog = cfg.RPCAuditLog
+	jcfg.TrustedPeers = api.PeersToStrings(cfg.TrustedPeers)
+	if len(cfg.PeerGroups) > 0 {
+		jcfg.PeerGroups = make(map[string][]string)
+	}
+	for name, members := range cfg.PeerGroups {
+		jcfg.PeerGroups[name] = api.PeersToStrings(members)
+	}
+	for _, tp := range cfg.TierPolicies {
+		jcfg.TierPolicies = append(jcfg.TierPolicies, tp.toJSON())
+	}
+	jcfg.TierMigrationInterval = cfg.TierMigrationInterval.String()
+	jcfg.TierMigrationBatch = cfg.TierMigrationBatch
+	jcfg.CanaryPinning = cfg.CanaryPinning
+	jcfg.CanaryTimeout = cfg.CanaryTimeout.String()
+	jcfg.CanaryFile = cfg.CanaryFile
+	jcfg.MaxPendingPins = cfg.MaxPendingPins
+	jcfg.AllocationWeight = cfg.AllocationWeight
+	jcfg.BreakerThreshold = cfg.BreakerThreshold
+	jcfg.BreakerCooldown = cfg.BreakerCooldown.String()
+	jcfg.TrackerCheckpointFile = cfg.TrackerCheckpointFile
+	jcfg.PeerBlacklistFile = cfg.PeerBlacklistFile
+	jcfg.StateSyncBatchSize = cfg.StateSyncBatchSize
+	jcfg.StartupCheck = cfg.StartupCheck
+	jcfg.StartupCheckConcurrency = cfg.StartupCheckConcurrency
+	jcfg.ConsensusStartTimeout = cfg.ConsensusStartTimeout.String()
+	jcfg.BlocklistFile = cfg.BlocklistFile
+	jcfg.BlocklistURL = cfg.BlocklistURL
+	jcfg.BlocklistUpdateInterval = cfg.BlocklistUpdateInterval.String()
+	jcfg.StateTransferLimit = cfg.StateTransferLimit
+	jcfg.MaxConcurrentBroadcasts = cfg.MaxConcurrentBroadcasts
+	jcfg.EnableFaultInjection = cfg.EnableFaultInjection
+	jcfg.Tags = cfg.Tags
 
 	raw, err = json.MarshalIndent(jcfg, "", "    ")
 	return
@@ -426,6 +936,67 @@ func (cfg *Config) GetPeerstorePath() string {
 	return filepath.Join(cfg.BaseDir, filename)
 }
 
+// GetTrackerCheckpointPath returns the full path of the
+// TrackerCheckpointFile, obtained by concatenating that value
+// with BaseDir of the configuration, if set.
+// An empty string is returned when BaseDir is not set.
+func (cfg *Config) GetTrackerCheckpointPath() string {
+	if cfg.BaseDir == "" {
+		return ""
+	}
+
+	filename := DefaultTrackerCheckpointFile
+	if cfg.TrackerCheckpointFile != "" {
+		filename = cfg.TrackerCheckpointFile
+	}
+
+	return filepath.Join(cfg.BaseDir, filename)
+}
+
+// GetPeerBlacklistPath returns the full path of the PeerBlacklistFile,
+// obtained by concatenating that value with BaseDir of the configuration,
+// if set. An empty string is returned when BaseDir is not set, in which
+// case the blacklist is not persisted.
+func (cfg *Config) GetPeerBlacklistPath() string {
+	if cfg.BaseDir == "" {
+		return ""
+	}
+
+	filename := DefaultPeerBlacklistFile
+	if cfg.PeerBlacklistFile != "" {
+		filename = cfg.PeerBlacklistFile
+	}
+
+	return filepath.Join(cfg.BaseDir, filename)
+}
+
+// GetCanaryPath returns the full path of the CanaryFile, obtained by
+// concatenating that value with BaseDir of the configuration, if set.
+// An empty string is returned when BaseDir is not set, in which case
+// pending canary pins are not persisted.
+func (cfg *Config) GetCanaryPath() string {
+	if cfg.BaseDir == "" {
+		return ""
+	}
+
+	filename := DefaultCanaryFile
+	if cfg.CanaryFile != "" {
+		filename = cfg.CanaryFile
+	}
+
+	return filepath.Join(cfg.BaseDir, filename)
+}
+
+// GetBlocklistPath returns the full path of the BlocklistFile. Relative
+// paths are joined with BaseDir, if set. An empty string is returned when
+// there is no BlocklistFile.
+func (cfg *Config) GetBlocklistPath() string {
+	if cfg.BlocklistFile == "" || cfg.BaseDir == "" || filepath.IsAbs(cfg.BlocklistFile) {
+		return cfg.BlocklistFile
+	}
+	return filepath.Join(cfg.BaseDir, cfg.BlocklistFile)
+}
+
 // DecodeClusterSecret parses a hex-encoded string, checks that it is exactly
 // 32 bytes long and returns its value as a byte-slice.x
 func DecodeClusterSecret(hexSecret string) ([]byte, error) {
@@ -443,3 +1014,23 @@ func DecodeClusterSecret(hexSecret string) ([]byte, error) {
 		return nil, fmt.Errorf("input secret is %d bytes, cluster secret should be 32", secretLen)
 	}
 }
+
+func parseMultiaddrs(strs []string) ([]ma.Multiaddr, error) {
+	var addrs []ma.Multiaddr
+	for _, s := range strs {
+		addr, err := ma.NewMultiaddr(s)
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs, nil
+}
+
+func multiaddrsToStrings(addrs []ma.Multiaddr) []string {
+	var strs []string
+	for _, addr := range addrs {
+		strs = append(strs, addr.String())
+	}
+	return strs
+}
@@ -3,6 +3,9 @@ package ipfscluster
 import (
 	"encoding/json"
 	"testing"
+	"time"
+
+	peer "github.com/libp2p/go-libp2p-peer"
 )
 
 var ccfgTestJSON = []byte(`
@@ -12,13 +15,49 @@ var ccfgTestJSON = []byte(`
         "private_key": "CAASqAkwggSkAgEAAoIBAQDpT16IRF6bb9tHsCbQ7M+nb2aI8sz8xyt8PoAWM42ki+SNoESIxKb4UhFxixKvtEdGxNE6aUUVc8kFk6wTStJ/X3IGiMetwkXiFiUxabUF/8A6SyvnSVDm+wFuavugpVrZikjLcfrf2xOVgnG3deQQvd/qbAv14jTwMFl+T+8d/cXBo8Mn/leLZCQun/EJEnkXP5MjgNI8XcWUE4NnH3E0ESSm6Pkm8MhMDZ2fmzNgqEyJ0GVinNgSml3Pyha3PBSj5LRczLip/ie4QkKx5OHvX2L3sNv/JIUHse5HSbjZ1c/4oGCYMVTYCykWiczrxBUOlcr8RwnZLOm4n2bCt5ZhAgMBAAECggEAVkePwfzmr7zR7tTpxeGNeXHtDUAdJm3RWwUSASPXgb5qKyXVsm5nAPX4lXDE3E1i/nzSkzNS5PgIoxNVU10cMxZs6JW0okFx7oYaAwgAddN6lxQtjD7EuGaixN6zZ1k/G6vT98iS6i3uNCAlRZ9HVBmjsOF8GtYolZqLvfZ5izEVFlLVq/BCs7Y5OrDrbGmn3XupfitVWYExV0BrHpobDjsx2fYdTZkmPpSSvXNcm4Iq2AXVQzoqAfGo7+qsuLCZtVlyTfVKQjMvE2ffzN1dQunxixOvev/fz4WSjGnRpC6QLn6Oqps9+VxQKqKuXXqUJC+U45DuvA94Of9MvZfAAQKBgQD7xmXueXRBMr2+0WftybAV024ap0cXFrCAu+KWC1SUddCfkiV7e5w+kRJx6RH1cg4cyyCL8yhHZ99Z5V0Mxa/b/usuHMadXPyX5szVI7dOGgIC9q8IijN7B7GMFAXc8+qC7kivehJzjQghpRRAqvRzjDls4gmbNPhbH1jUiU124QKBgQDtOaW5/fOEtOq0yWbDLkLdjImct6oKMLhENL6yeIKjMYgifzHb2adk7rWG3qcMrdgaFtDVfqv8UmMEkzk7bSkovMVj3SkLzMz84ii1SkSfyaCXgt/UOzDkqAUYB0cXMppYA7jxHa2OY8oEHdBgmyJXdLdzJxCp851AoTlRUSePgQKBgQCQgKgUHOUaXnMEx88sbOuBO14gMg3dNIqM+Ejt8QbURmI8k3arzqA4UK8Tbb9+7b0nzXWanS5q/TT1tWyYXgW28DIuvxlHTA01aaP6WItmagrphIelERzG6f1+9ib/T4czKmvROvDIHROjq8lZ7ERs5Pg4g+sbh2VbdzxWj49EQQKBgFEna36ZVfmMOs7mJ3WWGeHY9ira2hzqVd9fe+1qNKbHhx7mDJR9fTqWPxuIh/Vac5dZPtAKqaOEO8OQ6f9edLou+ggT3LrgsS/B3tNGOPvA6mNqrk/Yf/15TWTO+I8DDLIXc+lokbsogC+wU1z5NWJd13RZZOX/JUi63vTmonYBAoGBAIpglLCH2sPXfmguO6p8QcQcv4RjAU1c0GP4P5PNN3Wzo0ItydVd2LHJb6MdmL6ypeiwNklzPFwTeRlKTPmVxJ+QPg1ct/3tAURN/D40GYw9ojDhqmdSl4HW4d6gHS2lYzSFeU5jkG49y5nirOOoEgHy95wghkh6BfpwHujYJGw4",
         "secret": "2588b80d5cb05374fa142aed6cbb047d1f4ef8ef15e37eba68c65b9d30df67ed",
         "leave_on_shutdown": true,
+        "leave_timeout": "20s",
         "listen_multiaddress": "/ip4/127.0.0.1/tcp/10000",
         "state_sync_interval": "1m0s",
         "ipfs_sync_interval": "2m10s",
         "replication_factor_min": 5,
         "replication_factor_max": 5,
         "monitor_ping_interval": "2s",
-        "disable_repinning": true
+        "rebalance_allocations": true,
+        "allocation_hysteresis": 0.2,
+        "disable_repinning": true,
+        "shutdown_drain_timeout": "5s",
+        "peer_groups": {
+            "ssd-tier": ["QmUfSFm12eYCaRdypg48m8RqkXfLW7A2ZeGZb2skeHHDGA"],
+            "archive-tier": ["QmUfSFm12eYCaRdypg48m8RqkXfLW7A2ZeGZb2skeHHDGA"]
+        },
+        "tier_policies": [
+            {
+                "from": "ssd-tier",
+                "to": "archive-tier",
+                "after": "2160h"
+            }
+        ],
+        "tier_migration_batch": 5,
+        "canary_pinning": true,
+        "canary_timeout": "30m",
+        "max_pending_pins": 500,
+        "allocation_weight": 4,
+        "breaker_threshold": 5,
+        "breaker_cooldown": "1m",
+        "state_sync_batch_size": 200,
+        "startup_check": true,
+        "consensus_start_timeout": "5m",
+        "announce_multiaddress": ["/dns4/cluster.example.org/tcp/9096"],
+        "no_announce_multiaddress": ["/ip4/127.0.0.1/tcp/9096"],
+        "startup_check_concurrency": 4,
+        "blocklist_url": "https://example.org/denylist.txt",
+        "blocklist_update_interval": "10m",
+        "state_transfer_limit": 1048576,
+        "max_concurrent_broadcasts": 16,
+        "enable_fault_injection": true,
+        "tags": {
+            "region": "eu-west"
+        }
 }
 `)
 
@@ -41,6 +80,78 @@ func TestLoadJSON(t *testing.T) {
 		t.Error("expected disable_repinning to be true")
 	}
 
+	if cfg.LeaveTimeout != 20*time.Second {
+		t.Error("expected leave_timeout to be 20s")
+	}
+
+	if !cfg.RebalanceAllocations || cfg.AllocationHysteresis != 0.2 {
+		t.Error("expected rebalance_allocations with an allocation_hysteresis of 0.2")
+	}
+
+	if len(cfg.PeerGroups["ssd-tier"]) != 1 {
+		t.Error("expected one peer in the ssd-tier group")
+	}
+
+	if len(cfg.TierPolicies) != 1 || cfg.TierPolicies[0].After != 2160*time.Hour {
+		t.Error("expected a tier policy migrating after 90 days")
+	}
+
+	if !cfg.CanaryPinning || cfg.CanaryTimeout != 30*time.Minute {
+		t.Error("expected canary pinning with a 30m timeout")
+	}
+
+	if cfg.MaxPendingPins != 500 {
+		t.Error("expected a limit of 500 pending pins")
+	}
+
+	if cfg.AllocationWeight != 4 {
+		t.Error("expected an allocation weight of 4")
+	}
+
+	if cfg.BreakerThreshold != 5 || cfg.BreakerCooldown != time.Minute {
+		t.Error("unexpected circuit breaker settings")
+	}
+
+	if cfg.BlocklistURL == "" || cfg.BlocklistUpdateInterval != 10*time.Minute {
+		t.Error("unexpected blocklist settings")
+	}
+
+	if cfg.StateTransferLimit != 1048576 {
+		t.Error("state_transfer_limit not preserved")
+	}
+
+	if cfg.MaxConcurrentBroadcasts != 16 {
+		t.Error("max_concurrent_broadcasts not preserved")
+	}
+
+	if !cfg.EnableFaultInjection {
+		t.Error("expected fault injection to be enabled")
+	}
+
+	if cfg.Tags["region"] != "eu-west" {
+		t.Error("expected the region tag")
+	}
+
+	if cfg.StateSyncBatchSize != 200 {
+		t.Error("expected a state sync batch size of 200")
+	}
+
+	if !cfg.StartupCheck || cfg.StartupCheckConcurrency != 4 {
+		t.Error("unexpected startup check settings")
+	}
+
+	if cfg.ConsensusStartTimeout != 5*time.Minute {
+		t.Error("consensus_start_timeout not preserved")
+	}
+
+	if len(cfg.AnnounceAddrs) != 1 || len(cfg.AppendAnnounceAddrs) != 0 || len(cfg.NoAnnounceAddrs) != 1 {
+		t.Error("unexpected announce addresses")
+	}
+
+	if cfg.TierMigrationBatch != 5 || cfg.TierMigrationInterval != DefaultTierMigrationInterval {
+		t.Error("unexpected tier migration settings")
+	}
+
 	j := &configJSON{}
 
 	json.Unmarshal(ccfgTestJSON, j)
@@ -69,6 +180,15 @@ func TestLoadJSON(t *testing.T) {
 		t.Error("expected error parsing private key")
 	}
 
+	j = &configJSON{}
+	json.Unmarshal(ccfgTestJSON, j)
+	j.AppendAnnounce = []string{"abc"}
+	tst, _ = json.Marshal(j)
+	err = cfg.LoadJSON(tst)
+	if err == nil {
+		t.Error("expected error parsing append_announce_multiaddress")
+	}
+
 	j = &configJSON{}
 	json.Unmarshal(ccfgTestJSON, j)
 	j.ListenMultiaddress = "abc"
@@ -135,6 +255,15 @@ func TestLoadJSON(t *testing.T) {
 	if cfg.ReplicationFactorMin != -1 || cfg.ReplicationFactorMax != -1 {
 		t.Error("expected default replication factors")
 	}
+
+	j = &configJSON{}
+	json.Unmarshal(ccfgTestJSON, j)
+	j.AuthorizedPublishers = []string{"abc"}
+	tst, _ = json.Marshal(j)
+	err = cfg.LoadJSON(tst)
+	if err == nil {
+		t.Error("expected error decoding authorized_publishers")
+	}
 }
 
 func TestToJSON(t *testing.T) {
@@ -185,4 +314,71 @@ func TestValidate(t *testing.T) {
 	if cfg.Validate() == nil {
 		t.Fatal("expected error validating")
 	}
+
+	cfg.Default()
+	cfg.ShutdownDrainTimeout = -1
+	if cfg.Validate() == nil {
+		t.Fatal("expected error validating")
+	}
+
+	cfg.Default()
+	cfg.LeaveTimeout = 0
+	if cfg.Validate() == nil {
+		t.Fatal("expected error validating")
+	}
+
+	cfg.Default()
+	cfg.AllocationHysteresis = -0.1
+	if cfg.Validate() == nil {
+		t.Fatal("expected error validating")
+	}
+
+	cfg.Default()
+	cfg.PeerGroups = map[string][]peer.ID{"empty": nil}
+	if cfg.Validate() == nil {
+		t.Fatal("expected error validating")
+	}
+
+	cfg.Default()
+	cfg.TierPolicies = []TierPolicy{{From: "a", To: "b", After: time.Hour}}
+	if cfg.Validate() == nil {
+		t.Fatal("expected error validating")
+	}
+
+	cfg.Default()
+	cfg.PeerGroups = map[string][]peer.ID{"a": {cfg.ID}, "b": {cfg.ID}}
+	cfg.TierPolicies = []TierPolicy{{From: "a", To: "b"}}
+	if cfg.Validate() == nil {
+		t.Fatal("expected error validating")
+	}
+
+	cfg.Default()
+	cfg.TierMigrationBatch = 0
+	if cfg.Validate() == nil {
+		t.Fatal("expected error validating")
+	}
+
+	cfg.Default()
+	cfg.CanaryTimeout = 0
+	if cfg.Validate() == nil {
+		t.Fatal("expected error validating")
+	}
+
+	cfg.Default()
+	cfg.MaxPendingPins = -1
+	if cfg.Validate() == nil {
+		t.Fatal("expected error validating")
+	}
+
+	cfg.Default()
+	cfg.AllocationWeight = 0
+	if cfg.Validate() == nil {
+		t.Fatal("expected error validating")
+	}
+
+	cfg.Default()
+	cfg.BreakerThreshold = -1
+	if cfg.Validate() == nil {
+		t.Fatal("expected error validating")
+	}
 }
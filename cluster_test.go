@@ -2,7 +2,9 @@ package ipfscluster
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
@@ -18,6 +20,7 @@ import (
 
 	rpc "github.com/hsanjuan/go-libp2p-gorpc"
 	cid "github.com/ipfs/go-cid"
+	crypto "github.com/libp2p/go-libp2p-crypto"
 	peer "github.com/libp2p/go-libp2p-peer"
 )
 
@@ -48,7 +51,8 @@ func (ipfs *mockConnector) ID() (api.IPFSID, error) {
 		return api.IPFSID{}, errors.New("")
 	}
 	return api.IPFSID{
-		ID: test.TestPeerID1,
+		ID:        test.TestPeerID1,
+		Reachable: true,
 	}, nil
 }
 
@@ -66,6 +70,13 @@ func (ipfs *mockConnector) Unpin(ctx context.Context, c *cid.Cid) error {
 	return nil
 }
 
+func (ipfs *mockConnector) Prefetch(ctx context.Context, c *cid.Cid) error {
+	if ipfs.returnError {
+		return errors.New("")
+	}
+	return nil
+}
+
 func (ipfs *mockConnector) PinLsCid(ctx context.Context, c *cid.Cid) (api.IPFSPinStatus, error) {
 	if ipfs.returnError {
 		return api.IPFSPinStatusError, errors.New("")
@@ -89,6 +100,11 @@ func (ipfs *mockConnector) ConnectSwarms() error                          { retu
 func (ipfs *mockConnector) ConfigKey(keypath string) (interface{}, error) { return nil, nil }
 func (ipfs *mockConnector) FreeSpace() (uint64, error)                    { return 100, nil }
 func (ipfs *mockConnector) RepoSize() (uint64, error)                     { return 0, nil }
+func (ipfs *mockConnector) CumulativeSize(c *cid.Cid) (uint64, error)     { return 0, nil }
+func (ipfs *mockConnector) BlockSize(c *cid.Cid) (uint64, error)          { return 0, nil }
+func (ipfs *mockConnector) DAGStats(c *cid.Cid) (api.DAGStats, error) {
+	return api.DAGStats{Cid: c, Blocks: 1, TotalSize: 10, Depth: 1, LargestBlock: c, LargestBlockSize: 10}, nil
+}
 
 func testingCluster(t *testing.T) (*Cluster, *mockAPI, *mockConnector, *mapstate.MapState, *maptracker.MapPinTracker) {
 	clusterCfg, _, _, consensusCfg, trackerCfg, bmonCfg, psmonCfg, _ := testingConfigs()
@@ -107,14 +123,14 @@ func testingCluster(t *testing.T) (*Cluster, *mockAPI, *mockConnector, *mapstate
 
 	bmonCfg.CheckInterval = 2 * time.Second
 	psmonCfg.CheckInterval = 2 * time.Second
-	mon := makeMonitor(t, host, bmonCfg, psmonCfg)
+	mon := makeMonitor(t, host, nil, bmonCfg, psmonCfg)
 
 	alloc := ascendalloc.NewAllocator()
 	numpinCfg := &numpin.Config{}
 	numpinCfg.Default()
 	inf, _ := numpin.NewInformer(numpinCfg)
 
-	ReadyTimeout = consensusCfg.WaitForLeaderTimeout + 1*time.Second
+	clusterCfg.ConsensusStartTimeout = consensusCfg.WaitForLeaderTimeout + 1*time.Second
 
 	cl, err := NewCluster(
 		host,
@@ -185,6 +201,128 @@ func TestClusterStateSync(t *testing.T) {
 	}
 }
 
+func TestClusterStateSyncCheckpoint(t *testing.T) {
+	dir := "checkpointFromTests"
+	os.MkdirAll(dir, 0700)
+	defer os.RemoveAll(dir)
+
+	cleanRaft()
+	cl, _, _, _, _ := testingCluster(t)
+	defer cleanRaft()
+	defer cl.Shutdown()
+	cl.config.SetBaseDir(dir)
+
+	c1, _ := cid.Decode(test.TestCid1)
+	c2, _ := cid.Decode(test.TestCid2)
+	err := cl.Pin(api.PinCid(c1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinDelay()
+
+	err = cl.StateSync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp, err := cl.loadCheckpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp == nil || cp.Items[test.TestCid1] != api.TrackerStatusPinned.String() {
+		t.Fatal("expected the pinned item in the checkpoint")
+	}
+
+	// Pretend that c2 was pinned before restarting and unpinned
+	// from the cluster since.
+	cp.Items[test.TestCid2] = api.TrackerStatusPinned.String()
+	raw, _ := json.Marshal(cp)
+	ioutil.WriteFile(cl.config.GetTrackerCheckpointPath(), raw, 0600)
+
+	_, _, _, _, trackerCfg, _, _, _ := testingConfigs()
+	tracker := maptracker.NewMapPinTracker(trackerCfg, cl.id)
+	tracker.SetClient(cl.rpcClient)
+	defer tracker.Shutdown()
+	cl.tracker = tracker
+
+	cState, _ := cl.consensus.State()
+	cl.restoreCheckpoint(cState)
+
+	// restored right away, without pinning
+	if st := tracker.Status(c1).Status; st != api.TrackerStatusPinned {
+		t.Errorf("expected %s to be restored as pinned: %s", c1, st)
+	}
+	if st := tracker.Status(c2).Status; st == api.TrackerStatusPinned {
+		t.Errorf("expected %s to be unpinned", c2)
+	}
+}
+
+func TestClusterStartupCheck(t *testing.T) {
+	cleanRaft()
+	cl, _, ipfs, _, tracker := testingCluster(t)
+	defer cleanRaft()
+	defer cl.Shutdown()
+
+	c, _ := cid.Decode(test.TestCid1)
+	err := cl.Pin(api.PinCid(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinDelay()
+
+	// The mock IPFS daemon has nothing pinned, but reports the
+	// item as pinned when asked for it.
+	cState, _ := cl.consensus.State()
+	repaired, failed, err := cl.startupCheck(cState)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repaired != 1 || failed != 0 {
+		t.Errorf("expected 1 item repaired: %d repaired, %d failed", repaired, failed)
+	}
+	if st := tracker.Status(c).Status; st != api.TrackerStatusPinned {
+		t.Errorf("expected %s to be pinned: %s", c, st)
+	}
+
+	startupCheckRetryDelay = 10 * time.Millisecond
+	defer func() { startupCheckRetryDelay = 2 * time.Second }()
+	ipfs.returnError = true
+	repaired, failed, err = cl.startupCheck(cState)
+	if err == nil {
+		t.Error("expected an error when IPFS cannot be reached")
+	}
+	if repaired != 0 || failed != 0 {
+		t.Error("expected no repairs when IPFS cannot be reached")
+	}
+	if st := tracker.Status(c).Status; st != api.TrackerStatusPinError {
+		t.Errorf("expected %s to be errored: %s", c, st)
+	}
+}
+
+func TestNeedsRepair(t *testing.T) {
+	c, _ := cid.Decode(test.TestCid1)
+	pin := api.PinCid(c)
+	pin.Recursive = true
+
+	testcases := []struct {
+		st     api.TrackerStatus
+		ips    api.IPFSPinStatus
+		repair bool
+	}{
+		{api.TrackerStatusPinned, api.IPFSPinStatusRecursive, false},
+		{api.TrackerStatusPinned, api.IPFSPinStatusUnpinned, true},
+		{api.TrackerStatusPinned, api.IPFSPinStatusDirect, true},
+		{api.TrackerStatusPinning, api.IPFSPinStatusUnpinned, false},
+		{api.TrackerStatusPinError, api.IPFSPinStatusRecursive, true},
+		{api.TrackerStatusPinError, api.IPFSPinStatusUnpinned, true},
+	}
+
+	for _, tc := range testcases {
+		if needsRepair(pin, tc.st, tc.ips) != tc.repair {
+			t.Errorf("%s/%s: expected repair to be %t", tc.st, tc.ips, tc.repair)
+		}
+	}
+}
+
 func TestClusterID(t *testing.T) {
 	cl, _, _, _, _ := testingCluster(t)
 	defer cleanRaft()
@@ -199,11 +337,25 @@ func TestClusterID(t *testing.T) {
 	if id.Version != Version {
 		t.Error("version should match current version")
 	}
+	if id.Commit != Commit {
+		t.Error("commit should match the one at creation")
+	}
 	//if id.PublicKey == nil {
 	//	t.Error("publicKey should not be empty")
 	//}
 }
 
+func TestInstanceLoggers(t *testing.T) {
+	l1 := newInstanceLogger(test.TestPeerID1)
+	l2 := newInstanceLogger(test.TestPeerID2)
+	if l1.prefix == l2.prefix {
+		t.Error("peers should log with different prefixes")
+	}
+	if l1.prefix != newInstanceLogger(test.TestPeerID1).prefix {
+		t.Error("prefixes should be stable")
+	}
+}
+
 func TestClusterPin(t *testing.T) {
 	cl, _, _, _, _ := testingCluster(t)
 	defer cleanRaft()
@@ -227,6 +379,359 @@ func TestClusterPin(t *testing.T) {
 	}
 }
 
+func TestClusterPrefetch(t *testing.T) {
+	cl, _, _, _, _ := testingCluster(t)
+	defer cleanRaft()
+	defer cl.Shutdown()
+
+	c, _ := cid.Decode(test.TestCid1)
+	peers, err := cl.Prefetch(api.PinCid(c))
+	if err != nil {
+		t.Fatal("prefetch should have worked:", err)
+	}
+	if len(peers) != 1 || peers[0] != cl.id {
+		t.Error("expected the content to be prefetched by the only peer")
+	}
+	if len(cl.Pins()) != 0 {
+		t.Error("prefetching should not pin anything")
+	}
+
+	_, err = cl.Prefetch(api.Pin{
+		Cid:                  c,
+		ReplicationFactorMin: 2,
+		ReplicationFactorMax: 2,
+	})
+	if err == nil {
+		t.Error("expected an error as there are not enough peers")
+	}
+}
+
+func TestClusterPinsetChanges(t *testing.T) {
+	cl, _, _, _, _ := testingCluster(t)
+	defer cleanRaft()
+	defer cl.Shutdown()
+
+	c, _ := cid.Decode(test.TestCid1)
+	err := cl.Pin(api.PinCid(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cl.Unpin(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chs, err := cl.PinsetChanges("", 0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chs.Changes) != 2 || chs.LastIndex != 2 {
+		t.Fatalf("expected a pin and an unpin: %+v", chs)
+	}
+	if chs.Changes[0].Type != api.PinsetChangeAdd || chs.Changes[1].Type != api.PinsetChangeRemove {
+		t.Error("unexpected change types")
+	}
+
+	chs, err = cl.PinsetChanges("", 1, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chs.Changes) != 1 || chs.Changes[0].Index != 2 {
+		t.Error("expected only the unpin")
+	}
+}
+
+func TestClusterBackPressure(t *testing.T) {
+	cl, _, _, _, _ := testingCluster(t)
+	defer cleanRaft()
+	defer cl.Shutdown()
+
+	metric := api.Metric{
+		Name:  pendingMetricName,
+		Peer:  cl.id,
+		Value: "5",
+		Valid: true,
+	}
+	metric.SetTTL(time.Minute)
+	cl.monitor.LogMetric(metric)
+
+	if cl.BackPressure() != 0 {
+		t.Error("no limit should mean no back-pressure")
+	}
+
+	cl.config.MaxPendingPins = 10
+	if cl.BackPressure() != 0 {
+		t.Error("the cluster is under the limit")
+	}
+
+	cl.config.MaxPendingPins = 2
+	if cl.BackPressure() != 5 {
+		t.Error("expected the cluster to report back-pressure")
+	}
+}
+
+func TestClusterTrustedPeersRPC(t *testing.T) {
+	cl, _, _, _, _ := testingCluster(t)
+	defer cleanRaft()
+	defer cl.Shutdown()
+
+	cl.config.TrustedPeers = []peer.ID{test.TestPeerID2}
+
+	methods := []string{
+		"Cluster.Join",
+		"Cluster.Track",
+		"Cluster.Untrack",
+		"Cluster.IPFSPin",
+		"Cluster.IPFSUnpin",
+		"Cluster.RotateSecret",
+		"Cluster.CommitSecretRotation",
+		"Cluster.ApplySharedConfig",
+		"Cluster.RepairState",
+		"Cluster.PeerstoreAdd",
+		"Cluster.PeerBlacklistRemove",
+		"Cluster.StartJob",
+		"Cluster.CancelJob",
+		"Cluster.RecoverAll",
+		"Cluster.PrefetchLocal",
+		"Cluster.ConsensusLogUnpin",
+		"Cluster.Prefetch",
+		"Cluster.Recover",
+		"Cluster.RecoverLocal",
+		"Cluster.RecoverAllLocal",
+		"Cluster.TrackerRecover",
+		"Cluster.TrackerRecoverAll",
+		"Cluster.Sync",
+		"Cluster.SyncLocal",
+		"Cluster.SyncAll",
+		"Cluster.SyncAllLocal",
+		"Cluster.PeerManagerAddPeer",
+		"Cluster.PeerManagerImportAddresses",
+		"Cluster.IPFSConnectSwarms",
+		"Cluster.PeerMonitorLogMetric",
+	}
+	for _, m := range methods {
+		if cl.authorizeRPC(test.TestPeerID3, m) {
+			t.Errorf("untrusted peers should not be able to call %s", m)
+		}
+		if !cl.authorizeRPC(test.TestPeerID2, m) {
+			t.Errorf("trusted peers should be able to call %s", m)
+		}
+		if !cl.authorizeRPC(cl.id, m) {
+			t.Errorf("the peer should be able to call %s on itself", m)
+		}
+	}
+
+	if !cl.authorizeRPC(test.TestPeerID3, "Cluster.StatusAllLocal") {
+		t.Error("untrusted peers should be able to call read-only methods")
+	}
+}
+
+func TestClusterAuthorizedPublishers(t *testing.T) {
+	cl, _, _, _, _ := testingCluster(t)
+	defer cleanRaft()
+	defer cl.Shutdown()
+
+	priv, pub, err := crypto.GenerateKeyPair(crypto.RSA, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publisher, _ := peer.IDFromPublicKey(pub)
+	cl.config.AuthorizedPublishers = []peer.ID{publisher}
+	rpcapi := &RPCAPI{cl}
+	ctx := context.Background()
+
+	c, _ := cid.Decode(test.TestCid1)
+	err = rpcapi.Pin(ctx, api.PinCid(c).ToSerial(), &struct{}{})
+	if err == nil {
+		t.Error("unsigned pins should be rejected")
+	}
+
+	other, _, _ := crypto.GenerateKeyPair(crypto.RSA, 2048)
+	signed, _ := api.PinCid(c).Sign(api.PinOpPin, other)
+	err = rpcapi.Pin(ctx, signed.ToSerial(), &struct{}{})
+	if err == nil {
+		t.Error("pins signed by unauthorized keys should be rejected")
+	}
+
+	signed, _ = api.PinCid(c).Sign(api.PinOpPin, priv)
+	err = rpcapi.Pin(ctx, signed.ToSerial(), &struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pin, err := cl.PinGet(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pin.Signer != nil || pin.Signature != nil {
+		t.Error("signatures should not be stored in the state")
+	}
+	if pin.AddedBy != publisher {
+		t.Error("the publisher should be recorded in the pin")
+	}
+
+	err = rpcapi.Unpin(ctx, signed.ToSerial(), &struct{}{})
+	if err == nil {
+		t.Error("pin signatures should not authorize unpinning")
+	}
+
+	signed, _ = api.PinCid(c).Sign(api.PinOpUnpin, priv)
+	err = rpcapi.Unpin(ctx, signed.ToSerial(), &struct{}{})
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = rpcapi.Unpin(ctx, signed.ToSerial(), &struct{}{})
+	if err == nil {
+		t.Error("replayed requests should be rejected")
+	}
+
+	// Commits from other peers must carry the signed request too
+	err = rpcapi.ConsensusLogPin(ctx, api.PinCid(c).ToSerial(), &struct{}{})
+	if err == nil {
+		t.Error("unsigned commits of new pins should be rejected")
+	}
+
+	signed, _ = api.PinCid(c).Sign(api.PinOpPin, priv)
+	signed.SignedPayload = signed.SigningPayload(api.PinOpPin)
+	signed.ReplicationFactorMin = -1
+	signed.ReplicationFactorMax = -1
+	err = rpcapi.ConsensusLogPin(ctx, signed.ToSerial(), &struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Re-allocations made by the cluster keep the requested fields
+	pin, _ = cl.PinGet(c)
+	pin.ReplicationFactorMin = 1
+	pin.ReplicationFactorMax = 1
+	pin.Allocations = []peer.ID{cl.id}
+	err = rpcapi.ConsensusLogPin(ctx, pin.ToSerial(), &struct{}{})
+	if err != nil {
+		t.Error(err)
+	}
+	pin.Name = "renamed"
+	err = rpcapi.ConsensusLogPin(ctx, pin.ToSerial(), &struct{}{})
+	if err == nil {
+		t.Error("unsigned changes to requested fields should be rejected")
+	}
+
+	_, err = cl.MergePins([]api.Pin{api.PinCid(c)})
+	if err == nil {
+		t.Error("pins should not be merged without signatures")
+	}
+}
+
+func TestClusterPinTimestamp(t *testing.T) {
+	cl, _, _, _, _ := testingCluster(t)
+	defer cleanRaft()
+	defer cl.Shutdown()
+
+	before := time.Now().Add(-time.Second)
+	c, _ := cid.Decode(test.TestCid1)
+	err := cl.Pin(api.PinCid(c))
+	if err != nil {
+		t.Fatal("pin should have worked:", err)
+	}
+
+	pin, err := cl.PinGet(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pin.Timestamp.Before(before) || pin.Timestamp.After(time.Now()) {
+		t.Error("unexpected pin timestamp:", pin.Timestamp)
+	}
+	if pin.AddedBy != "" {
+		t.Error("unsigned pins have no publisher")
+	}
+
+	// Updating the pin keeps the timestamp
+	time.Sleep(time.Second)
+	updated := api.PinCid(c)
+	updated.Name = "updated"
+	err = cl.Pin(updated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pin2, err := cl.PinGet(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pin2.Name != "updated" || !pin2.Timestamp.Equal(pin.Timestamp) {
+		t.Error("the pin timestamp should not change when updating it")
+	}
+
+	gpi, err := cl.Status(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !gpi.Timestamp.Equal(pin.Timestamp) {
+		t.Error("the status should include the pin timestamp")
+	}
+}
+
+func TestClusterPinDetail(t *testing.T) {
+	cl, _, _, _, _ := testingCluster(t)
+	defer cleanRaft()
+	defer cl.Shutdown()
+
+	c, _ := cid.Decode(test.TestCid1)
+	detail, err := cl.PinDetail(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if detail.Pin != nil {
+		t.Error("the item is not pinned yet")
+	}
+
+	pin := api.PinCid(c)
+	pin.Name = "detailed"
+	err = cl.Pin(pin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinDelay()
+
+	detail, err = cl.PinDetail(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if detail.Pin == nil || detail.Pin.Name != "detailed" {
+		t.Error("expected the shared state entry")
+	}
+	if detail.PeerMap[cl.id].Status != api.TrackerStatusPinned {
+		t.Error("expected the status of the item")
+	}
+}
+
+func TestClusterDAGStats(t *testing.T) {
+	cl, _, _, _, _ := testingCluster(t)
+	defer cleanRaft()
+	defer cl.Shutdown()
+
+	c, _ := cid.Decode(test.TestCid1)
+	_, err := cl.DAGStats(c)
+	if err == nil {
+		t.Error("expected an error for an item which is not pinned")
+	}
+
+	err = cl.Pin(api.PinCid(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinDelay()
+
+	stats, err := cl.DAGStats(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Peer != cl.id {
+		t.Error("expected the stats to come from this peer")
+	}
+	if !stats.Cid.Equals(c) || stats.Blocks != 1 || stats.Depth != 1 {
+		t.Error("unexpected stats")
+	}
+}
+
 func TestClusterPins(t *testing.T) {
 	cl, _, _, _, _ := testingCluster(t)
 	defer cleanRaft()
@@ -247,6 +752,88 @@ func TestClusterPins(t *testing.T) {
 	}
 }
 
+func TestClusterSearchPins(t *testing.T) {
+	cl, _, _, _, _ := testingCluster(t)
+	defer cleanRaft()
+	defer cl.Shutdown()
+
+	c1, _ := cid.Decode(test.TestCid1)
+	c2, _ := cid.Decode(test.TestCid2)
+	pin1 := api.PinCid(c1)
+	pin1.Name = "backup-2017-01"
+	pin1.Metadata = map[string]string{"tenant": "a"}
+	pin2 := api.PinCid(c2)
+	pin2.Name = "other"
+	for _, p := range []api.Pin{pin1, pin2} {
+		err := cl.Pin(p)
+		if err != nil {
+			t.Fatal("pin should have worked:", err)
+		}
+	}
+
+	pins := cl.SearchPins(api.PinQuery{Name: "backup-*"})
+	if len(pins) != 1 || !pins[0].Cid.Equals(c1) {
+		t.Fatal("expected to find one pin by name")
+	}
+	if pins[0].Metadata["tenant"] != "a" {
+		t.Error("metadata should have been kept in the state")
+	}
+
+	pins = cl.SearchPins(api.PinQuery{Metadata: map[string]string{"tenant": "b"}})
+	if len(pins) != 0 {
+		t.Error("no pins should match")
+	}
+}
+
+func TestClusterUnpinMatching(t *testing.T) {
+	cl, _, _, _, _ := testingCluster(t)
+	defer cleanRaft()
+	defer cl.Shutdown()
+
+	c1, _ := cid.Decode(test.TestCid1)
+	c2, _ := cid.Decode(test.TestCid2)
+	c3, _ := cid.Decode(test.TestCid3)
+	names := []string{"dataset-1", "dataset-2", "other"}
+	for i, c := range []*cid.Cid{c1, c2, c3} {
+		pin := api.PinCid(c)
+		pin.Name = names[i]
+		err := cl.Pin(pin)
+		if err != nil {
+			t.Fatal("pin should have worked:", err)
+		}
+	}
+
+	_, err := cl.UnpinMatching(api.PinQuery{}, false)
+	if err == nil {
+		t.Fatal("expected an error with an empty selector")
+	}
+
+	_, err = cl.UnpinMatching(api.PinQuery{Name: "*"}, false)
+	if err == nil || len(cl.Pins()) != 3 {
+		t.Fatal("expected an error with a wildcard-only selector")
+	}
+
+	pins, err := cl.UnpinMatching(api.PinQuery{Name: "dataset-*"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pins) != 2 || len(cl.Pins()) != 3 {
+		t.Fatal("dry-run should list 2 pins and remove nothing")
+	}
+
+	pins, err = cl.UnpinMatching(api.PinQuery{Name: "dataset-*"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pins) != 2 {
+		t.Fatal("expected 2 unpinned items")
+	}
+	left := cl.Pins()
+	if len(left) != 1 || !left[0].Cid.Equals(c3) {
+		t.Error("only the non-matching pin should be left")
+	}
+}
+
 func TestClusterPinGet(t *testing.T) {
 	cl, _, _, _, _ := testingCluster(t)
 	defer cleanRaft()
@@ -308,6 +895,40 @@ func TestClusterPeers(t *testing.T) {
 	}
 }
 
+func TestClusterPeersCache(t *testing.T) {
+	cl, _, _, _, _ := testingCluster(t)
+	defer cleanRaft()
+	defer cl.Shutdown()
+
+	peers := cl.Peers()
+	if len(peers) != 1 || peers[0].Error != "" {
+		t.Fatal("expected 1 peer")
+	}
+	fetchedAt := peers[0].FetchedAt
+	if fetchedAt.IsZero() {
+		t.Fatal("expected a FetchedAt timestamp")
+	}
+
+	// Wait for the background refresh triggered by this call.
+	cl.Peers()
+	time.Sleep(time.Second)
+
+	cached := cl.Peers()
+	if !cached[0].FetchedAt.After(fetchedAt) {
+		t.Error("the cached ID should have been refreshed")
+	}
+
+	fresh := cl.QueryPeers(api.PeersQuery{Refresh: true})
+	if len(fresh) != 1 || fresh[0].FetchedAt.Before(cached[0].FetchedAt) {
+		t.Error("expected a refreshed ID")
+	}
+
+	cl.peerIDCache.retain(nil)
+	if _, ok := cl.peerIDCache.get(cl.id); ok {
+		t.Error("the entry should have been removed from the cache")
+	}
+}
+
 func TestVersion(t *testing.T) {
 	cl, _, _, _, _ := testingCluster(t)
 	defer cleanRaft()
@@ -341,3 +962,203 @@ func TestClusterRecoverAllLocal(t *testing.T) {
 		t.Error("the pin should have been recovered")
 	}
 }
+
+func TestClusterStatusShard(t *testing.T) {
+	cl, _, _, _, _ := testingCluster(t)
+	defer cleanRaft()
+	defer cl.Shutdown()
+
+	for _, c := range []string{test.TestCid1, test.TestCid2, test.TestCid3} {
+		h, _ := cid.Decode(c)
+		err := cl.Pin(api.PinCid(h))
+		if err != nil {
+			t.Fatal("pin should have worked:", err)
+		}
+	}
+	pinDelay()
+
+	seen := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		shard := api.PinShard{Index: i, Count: 3}
+		gpis, err := cl.StatusShard(shard)
+		if err != nil {
+			t.Fatal(err)
+		}
+		local, err := cl.StatusShardLocal(shard)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(local) != len(gpis) {
+			t.Error("the local and global status should have the same items")
+		}
+		for _, gpi := range gpis {
+			if !shard.Contains(gpi.Cid) || seen[gpi.Cid.String()] {
+				t.Errorf("%s returned in the wrong shard", gpi.Cid)
+			}
+			seen[gpi.Cid.String()] = true
+		}
+	}
+	if len(seen) != 3 {
+		t.Error("every item should belong to a shard")
+	}
+
+	_, err := cl.StatusShard(api.PinShard{Index: 3, Count: 3})
+	if err == nil {
+		t.Error("expected an error with an invalid shard")
+	}
+}
+
+func TestClusterSplitBrain(t *testing.T) {
+	cl, _, _, _, _ := testingCluster(t)
+	defer cleanRaft()
+	defer cl.Shutdown()
+
+	problems, err := cl.SplitBrain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 0 {
+		t.Error("no split brain expected in a single peer cluster:", problems)
+	}
+}
+
+func TestClusterPeerRotate(t *testing.T) {
+	cl, _, _, _, _ := testingCluster(t)
+	defer cleanRaft()
+	defer cl.Shutdown()
+
+	c, _ := cid.Decode(test.TestCid1)
+	pin := api.PinCid(c)
+	pin.ReplicationFactorMin = 2
+	pin.ReplicationFactorMax = 2
+	pin.Allocations = []peer.ID{cl.id, test.TestPeerID2}
+	err := cl.consensus.LogPin(pin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = cl.PeerRotate(test.TestPeerID2, test.TestPeerID2)
+	if err == nil {
+		t.Error("expected an error rotating to the same ID")
+	}
+
+	err = cl.PeerRotate(test.TestPeerID2, test.TestPeerID3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err := cl.PinGet(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	allocs := rotated.Allocations
+	if len(allocs) != 2 || !containsPeer(allocs, test.TestPeerID3) || containsPeer(allocs, test.TestPeerID2) {
+		t.Error("the pin should have been re-allocated to the new peer ID:", allocs)
+	}
+}
+
+func TestClusterRepairState(t *testing.T) {
+	cl, _, _, _, _ := testingCluster(t)
+	defer cleanRaft()
+	defer cl.Shutdown()
+
+	// The only peer is the leader, so there is nothing to repair
+	res, err := cl.RepairState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Diverged {
+		t.Error("the leader's state cannot diverge")
+	}
+}
+
+func TestStateChecksum(t *testing.T) {
+	c1, _ := cid.Decode(test.TestCid1)
+	c2, _ := cid.Decode(test.TestCid2)
+
+	st1 := mapstate.NewMapState()
+	st1.Add(api.PinCid(c1))
+	st1.Add(api.PinCid(c2))
+	st2 := mapstate.NewMapState()
+	st2.Add(api.PinCid(c2))
+	st2.Add(api.PinCid(c1))
+
+	sum1, n, err := stateChecksum(st1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Error("expected 2 pins")
+	}
+	sum2, _, _ := stateChecksum(st2)
+	if sum1 != sum2 {
+		t.Error("checksums should not depend on insertion order")
+	}
+
+	st2.Rm(c1)
+	sum2, _, _ = stateChecksum(st2)
+	if sum1 == sum2 {
+		t.Error("checksums should differ")
+	}
+}
+
+func TestSplitBrainProblems(t *testing.T) {
+	members := []peer.ID{test.TestPeerID1, test.TestPeerID2, test.TestPeerID3}
+	all := []peer.ID{test.TestPeerID1, test.TestPeerID2, test.TestPeerID3}
+	errs := []error{nil, nil, nil}
+
+	healthy := []api.ConsensusState{
+		{Leader: test.TestPeerID1, Voters: all},
+		{Leader: test.TestPeerID1, Voters: all},
+		{Leader: test.TestPeerID1, Voters: all},
+	}
+	if p := splitBrainProblems(test.TestPeerID1, members, healthy, errs); len(p) != 0 {
+		t.Error("expected no problems:", p)
+	}
+
+	split := []api.ConsensusState{
+		{Leader: test.TestPeerID1, Voters: all},
+		{Leader: test.TestPeerID1, Voters: all},
+		{Leader: test.TestPeerID3, Voters: []peer.ID{test.TestPeerID3}},
+	}
+	if p := splitBrainProblems(test.TestPeerID1, members, split, errs); len(p) != 2 {
+		t.Error("expected 2 problems:", p)
+	}
+
+	// Errored peers are ignored
+	errs[2] = errors.New("unreachable")
+	if p := splitBrainProblems(test.TestPeerID1, members, split, errs); len(p) != 0 {
+		t.Error("expected no problems:", p)
+	}
+}
+
+func TestTimedOut(t *testing.T) {
+	if timedOut(nil) {
+		t.Error("nil is not a timeout")
+	}
+	if !timedOut(context.DeadlineExceeded) {
+		t.Error("DeadlineExceeded should be a timeout")
+	}
+	if !timedOut(errors.New("rpc: context deadline exceeded")) {
+		t.Error("remote deadline errors should be a timeout")
+	}
+	if timedOut(errors.New("dial backoff")) {
+		t.Error("other errors are not a timeout")
+	}
+}
+
+func TestConsensusStartTimeout(t *testing.T) {
+	cfg := &Config{}
+	cfg.Default()
+	cfg.ConsensusStartTimeout = time.Minute
+	c := &Cluster{config: cfg}
+	if c.consensusStartTimeout() != time.Minute {
+		t.Error("expected the configured timeout")
+	}
+
+	ReadyTimeout = 5 * time.Second
+	defer func() { ReadyTimeout = DefaultConsensusStartTimeout }()
+	if c.consensusStartTimeout() != 5*time.Second {
+		t.Error("a modified ReadyTimeout should take precedence")
+	}
+}
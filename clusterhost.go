@@ -3,6 +3,8 @@ package ipfscluster
 import (
 	"context"
 	"encoding/hex"
+	"net"
+	"sync"
 
 	libp2p "github.com/libp2p/go-libp2p"
 	host "github.com/libp2p/go-libp2p-host"
@@ -13,18 +15,21 @@ import (
 
 // NewClusterHost creates a libp2p Host with the options from the
 // provided cluster configuration.
+//
+// When the configuration has a secret, the host is protected by a private
+// network whose secret can be replaced while it runs (see RotateSecret).
 func NewClusterHost(ctx context.Context, cfg *Config) (host.Host, error) {
 	var prot ipnet.Protector
-	var err error
 
 	// Create protector if we have a secret.
 	if cfg.Secret != nil && len(cfg.Secret) > 0 {
-		var key [32]byte
-		copy(key[:], cfg.Secret)
-		prot, err = pnet.NewV1ProtectorFromBytes(&key)
+		sp := &swappableProtector{}
+		err := sp.setSecret(cfg.Secret)
 		if err != nil {
 			return nil, err
 		}
+		cfg.protector = sp
+		prot = sp
 	}
 
 	return libp2p.New(
@@ -33,9 +38,74 @@ func NewClusterHost(ctx context.Context, cfg *Config) (host.Host, error) {
 		libp2p.ListenAddrs([]ma.Multiaddr{cfg.ListenAddr}...),
 		libp2p.PrivateNetwork(prot),
 		libp2p.NATPortMap(),
+		libp2p.AddrsFactory(announceAddrsFactory(cfg)),
 	)
 }
 
+// swappableProtector is a private network protector whose secret can be
+// replaced at runtime. New connections are protected with the current
+// secret, while already established ones are kept.
+type swappableProtector struct {
+	mux  sync.RWMutex
+	prot ipnet.Protector
+}
+
+func (sp *swappableProtector) setSecret(secret []byte) error {
+	var key [32]byte
+	copy(key[:], secret)
+	prot, err := pnet.NewV1ProtectorFromBytes(&key)
+	if err != nil {
+		return err
+	}
+
+	sp.mux.Lock()
+	defer sp.mux.Unlock()
+	sp.prot = prot
+	return nil
+}
+
+func (sp *swappableProtector) current() ipnet.Protector {
+	sp.mux.RLock()
+	defer sp.mux.RUnlock()
+	return sp.prot
+}
+
+// Protect wraps the connection with the current secret.
+func (sp *swappableProtector) Protect(conn net.Conn) (net.Conn, error) {
+	return sp.current().Protect(conn)
+}
+
+// Fingerprint returns the fingerprint of the current secret.
+func (sp *swappableProtector) Fingerprint() [32]byte {
+	return sp.current().Fingerprint()
+}
+
+// announceAddrsFactory returns a function which, given the addresses of
+// the host, returns those to advertise to other peers according to the
+// AnnounceAddrs, AppendAnnounceAddrs and NoAnnounceAddrs options.
+func announceAddrsFactory(cfg *Config) func([]ma.Multiaddr) []ma.Multiaddr {
+	exclude := make(map[string]struct{})
+	for _, addr := range cfg.NoAnnounceAddrs {
+		exclude[addr.String()] = struct{}{}
+	}
+
+	return func(addrs []ma.Multiaddr) []ma.Multiaddr {
+		if len(cfg.AnnounceAddrs) > 0 {
+			addrs = cfg.AnnounceAddrs
+		}
+		addrs = append(addrs[:len(addrs):len(addrs)], cfg.AppendAnnounceAddrs...)
+
+		var announce []ma.Multiaddr
+		for _, addr := range addrs {
+			if _, ok := exclude[addr.String()]; ok {
+				continue
+			}
+			announce = append(announce, addr)
+		}
+		return announce
+	}
+}
+
 // EncodeProtectorKey converts a byte slice to its hex string representation.
 func EncodeProtectorKey(secretBytes []byte) string {
 	return hex.EncodeToString(secretBytes)
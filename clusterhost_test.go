@@ -0,0 +1,37 @@
+package ipfscluster
+
+import (
+	"testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestAnnounceAddrsFactory(t *testing.T) {
+	local, _ := ma.NewMultiaddr("/ip4/127.0.0.1/tcp/9096")
+	private, _ := ma.NewMultiaddr("/ip4/192.168.1.2/tcp/9096")
+	public, _ := ma.NewMultiaddr("/ip4/1.2.3.4/tcp/9096")
+	dns, _ := ma.NewMultiaddr("/dns4/cluster.example.org/tcp/9096")
+	hostAddrs := []ma.Multiaddr{local, private}
+
+	cfg := &Config{}
+	addrs := announceAddrsFactory(cfg)(hostAddrs)
+	if len(addrs) != 2 {
+		t.Fatal("expected all host addresses by default")
+	}
+
+	cfg.NoAnnounceAddrs = []ma.Multiaddr{local}
+	cfg.AppendAnnounceAddrs = []ma.Multiaddr{public}
+	addrs = announceAddrsFactory(cfg)(hostAddrs)
+	if len(addrs) != 2 || !addrs[0].Equal(private) || !addrs[1].Equal(public) {
+		t.Errorf("unexpected addresses: %s", addrs)
+	}
+	if len(hostAddrs) != 2 {
+		t.Error("the host addresses should not be modified")
+	}
+
+	cfg.AnnounceAddrs = []ma.Multiaddr{dns}
+	addrs = announceAddrsFactory(cfg)(hostAddrs)
+	if len(addrs) != 2 || !addrs[0].Equal(dns) || !addrs[1].Equal(public) {
+		t.Errorf("unexpected addresses: %s", addrs)
+	}
+}
@@ -2,6 +2,7 @@ package ipfscluster
 
 import (
 	"github.com/ipfs/ipfs-cluster/api/rest"
+	"github.com/ipfs/ipfs-cluster/consensus/crdt"
 	"github.com/ipfs/ipfs-cluster/consensus/raft"
 	"github.com/ipfs/ipfs-cluster/informer/disk"
 	"github.com/ipfs/ipfs-cluster/ipfsconn/ipfshttp"
@@ -72,6 +73,13 @@ var testingTrackerCfg = []byte(`
 }
 `)
 
+var testingCRDTCfg = []byte(`{
+    "topic_name": "ipfs-cluster-crdt-tests",
+    "rebroadcast_interval": "200ms",
+    "peer_timeout": "2s",
+    "sync_timeout": "5s"
+}`)
+
 var testingMonCfg = []byte(`{
     "check_interval": "300ms"
 }`)
@@ -95,6 +103,12 @@ func testingConfigs() (*Config, *rest.Config, *ipfshttp.Config, *raft.Config, *m
 	return clusterCfg, apiCfg, ipfsCfg, consensusCfg, trackerCfg, basicmonCfg, pubsubmonCfg, diskInfCfg
 }
 
+func testingCRDTConfig() *crdt.Config {
+	cfg := &crdt.Config{}
+	cfg.LoadJSON(testingCRDTCfg)
+	return cfg
+}
+
 func testingEmptyConfigs() (*Config, *rest.Config, *ipfshttp.Config, *raft.Config, *maptracker.Config, *basic.Config, *pubsubmon.Config, *disk.Config) {
 	clusterCfg := &Config{}
 	apiCfg := &rest.Config{}
@@ -38,7 +38,7 @@ func (c *Cluster) ConnectGraph() (api.ConnectGraph, error) {
 		p := members[i]
 		cg.ClusterLinks[p] = make([]peer.ID, 0)
 		if err != nil { // Only setting cluster connections when no error occurs
-			logger.Debugf("RPC error reaching cluster peer %s: %s", p.Pretty(), err.Error())
+			c.logger.Debugf("RPC error reaching cluster peer %s: %s", p.Pretty(), err.Error())
 			continue
 		}
 
@@ -46,7 +46,7 @@ func (c *Cluster) ConnectGraph() (api.ConnectGraph, error) {
 
 		// IPFS connections
 		if !selfConnection {
-			logger.Warningf("cluster peer %s not its own peer.  No ipfs info ", p.Pretty())
+			c.logger.Warningf("cluster peer %s not its own peer.  No ipfs info ", p.Pretty())
 			continue
 		}
 		c.recordIPFSLinks(&cg, pID)
@@ -61,7 +61,7 @@ func (c *Cluster) recordClusterLinks(cg *api.ConnectGraph, p peer.ID, sPeers []a
 	for _, sID := range sPeers {
 		id := sID.ToID()
 		if id.Error != "" {
-			logger.Debugf("Peer %s errored connecting to its peer %s", p.Pretty(), id.ID.Pretty())
+			c.logger.Debugf("Peer %s errored connecting to its peer %s", p.Pretty(), id.ID.Pretty())
 			continue
 		}
 		if id.ID == p {
@@ -77,11 +77,11 @@ func (c *Cluster) recordClusterLinks(cg *api.ConnectGraph, p peer.ID, sPeers []a
 func (c *Cluster) recordIPFSLinks(cg *api.ConnectGraph, pID api.ID) {
 	ipfsID := pID.IPFS.ID
 	if pID.IPFS.Error != "" { // Only setting ipfs connections when no error occurs
-		logger.Warningf("ipfs id: %s has error: %s. Skipping swarm connections", ipfsID.Pretty(), pID.IPFS.Error)
+		c.logger.Warningf("ipfs id: %s has error: %s. Skipping swarm connections", ipfsID.Pretty(), pID.IPFS.Error)
 		return
 	}
 	if _, ok := cg.IPFSLinks[pID.ID]; ok {
-		logger.Warningf("ipfs id: %s already recorded, one ipfs daemon in use by multiple cluster peers", ipfsID.Pretty())
+		c.logger.Warningf("ipfs id: %s already recorded, one ipfs daemon in use by multiple cluster peers", ipfsID.Pretty())
 	}
 	cg.ClustertoIPFS[pID.ID] = ipfsID
 	cg.IPFSLinks[ipfsID] = make([]peer.ID, 0)
@@ -0,0 +1,164 @@
+package crdt
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"time"
+
+	"github.com/ipfs/ipfs-cluster/config"
+)
+
+var configKey = "crdt"
+
+// Configuration defaults. The DefaultSyncTimeout is kept well below the
+// default cluster.consensus_start_timeout (30s): a peer which cannot sync
+// in time starts anyway, but one which is not ready by then shuts down.
+var (
+	DefaultDataSubFolder       = "crdt"
+	DefaultTopicName           = "ipfs-cluster-crdt"
+	DefaultRebroadcastInterval = 10 * time.Second
+	DefaultPeerTimeout         = 45 * time.Second
+	DefaultSyncTimeout         = 20 * time.Second
+	DefaultTombstoneExpiry     = 24 * time.Hour
+)
+
+// Config allows to configure the CRDT Consensus component for
+// ipfs-cluster. Config implements the ComponentConfig interface.
+type Config struct {
+	config.Saver
+
+	// A folder to store the pinset of this peer.
+	DataFolder string
+
+	// TopicName is the PubSub topic used to broadcast heartbeats.
+	// All the peers of a cluster must use the same one.
+	TopicName string
+
+	// RebroadcastInterval specifies how often a peer announces
+	// itself and a summary of its pinset to the rest of the cluster.
+	// Peers with a different pinset exchange their full pinsets.
+	RebroadcastInterval time.Duration
+
+	// PeerTimeout specifies how long a peer which is no longer
+	// heard from is considered part of the cluster.
+	PeerTimeout time.Duration
+
+	// SyncTimeout specifies how long to wait for the pinset of this
+	// peer to match the one of other peers before declaring it ready.
+	SyncTimeout time.Duration
+
+	// TombstoneExpiry specifies how long unpins are remembered. Peers
+	// which have been offline for longer than this may bring back
+	// pins which were removed in the meantime and should be started
+	// with a clean pinset.
+	TombstoneExpiry time.Duration
+}
+
+type jsonConfig struct {
+	DataFolder          string `json:"data_folder,omitempty"`
+	TopicName           string `json:"topic_name"`
+	RebroadcastInterval string `json:"rebroadcast_interval"`
+	PeerTimeout         string `json:"peer_timeout"`
+	SyncTimeout         string `json:"sync_timeout"`
+	TombstoneExpiry     string `json:"tombstone_expiry"`
+}
+
+// ConfigKey returns a human-friendly indentifier for this Config.
+func (cfg *Config) ConfigKey() string {
+	return configKey
+}
+
+// Default initializes this configuration with working defaults.
+func (cfg *Config) Default() error {
+	cfg.DataFolder = "" // empty so it gets omitted
+	cfg.TopicName = DefaultTopicName
+	cfg.RebroadcastInterval = DefaultRebroadcastInterval
+	cfg.PeerTimeout = DefaultPeerTimeout
+	cfg.SyncTimeout = DefaultSyncTimeout
+	cfg.TombstoneExpiry = DefaultTombstoneExpiry
+	return nil
+}
+
+// Validate checks that this configuration has working values,
+// at least in appearance.
+func (cfg *Config) Validate() error {
+	if cfg.TopicName == "" {
+		return errors.New("crdt.topic_name is empty")
+	}
+
+	if cfg.RebroadcastInterval <= 0 {
+		return errors.New("crdt.rebroadcast_interval is invalid")
+	}
+
+	if cfg.PeerTimeout <= cfg.RebroadcastInterval {
+		return errors.New("crdt.peer_timeout should be larger than crdt.rebroadcast_interval")
+	}
+
+	if cfg.SyncTimeout <= 0 {
+		return errors.New("crdt.sync_timeout is invalid")
+	}
+
+	if cfg.TombstoneExpiry <= cfg.PeerTimeout {
+		return errors.New("crdt.tombstone_expiry should be larger than crdt.peer_timeout")
+	}
+	return nil
+}
+
+// LoadJSON parses a json-encoded configuration (see jsonConfig).
+// The Config will have default values for all fields not explicited
+// in the given json object.
+func (cfg *Config) LoadJSON(raw []byte) error {
+	jcfg := &jsonConfig{}
+	err := json.Unmarshal(raw, jcfg)
+	if err != nil {
+		logger.Error("Error unmarshaling crdt config")
+		return err
+	}
+
+	cfg.Default()
+
+	parseDuration := func(txt string) time.Duration {
+		d, _ := time.ParseDuration(txt)
+		if txt != "" && d == 0 {
+			logger.Warningf("%s is not a valid duration. Default will be used", txt)
+		}
+		return d
+	}
+
+	rebroadcastInterval := parseDuration(jcfg.RebroadcastInterval)
+	peerTimeout := parseDuration(jcfg.PeerTimeout)
+	syncTimeout := parseDuration(jcfg.SyncTimeout)
+	tombstoneExpiry := parseDuration(jcfg.TombstoneExpiry)
+
+	config.SetIfNotDefault(jcfg.DataFolder, &cfg.DataFolder)
+	config.SetIfNotDefault(jcfg.TopicName, &cfg.TopicName)
+	config.SetIfNotDefault(rebroadcastInterval, &cfg.RebroadcastInterval)
+	config.SetIfNotDefault(peerTimeout, &cfg.PeerTimeout)
+	config.SetIfNotDefault(syncTimeout, &cfg.SyncTimeout)
+	config.SetIfNotDefault(tombstoneExpiry, &cfg.TombstoneExpiry)
+
+	return cfg.Validate()
+}
+
+// ToJSON returns the pretty JSON representation of a Config.
+func (cfg *Config) ToJSON() ([]byte, error) {
+	jcfg := &jsonConfig{
+		DataFolder:          cfg.DataFolder,
+		TopicName:           cfg.TopicName,
+		RebroadcastInterval: cfg.RebroadcastInterval.String(),
+		PeerTimeout:         cfg.PeerTimeout.String(),
+		SyncTimeout:         cfg.SyncTimeout.String(),
+		TombstoneExpiry:     cfg.TombstoneExpiry.String(),
+	}
+	return config.DefaultJSONMarshal(jcfg)
+}
+
+// GetDataFolder returns the folder where the pinset of this peer is
+// stored.
+func (cfg *Config) GetDataFolder() string {
+	if cfg.DataFolder == "" {
+		return filepath.Join(cfg.BaseDir, DefaultDataSubFolder)
+	}
+	return cfg.DataFolder
+}
@@ -0,0 +1,89 @@
+package crdt
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+var cfgJSON = []byte(`
+{
+    "topic_name": "test-topic",
+    "rebroadcast_interval": "5s",
+    "peer_timeout": "20s",
+    "sync_timeout": "10s"
+}
+`)
+
+func TestLoadJSON(t *testing.T) {
+	cfg := &Config{}
+	err := cfg.LoadJSON(cfgJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.TopicName != "test-topic" || cfg.RebroadcastInterval != 5*time.Second {
+		t.Error("values not preserved")
+	}
+
+	j := &jsonConfig{}
+	json.Unmarshal(cfgJSON, j)
+	j.PeerTimeout = "1s"
+	tst, _ := json.Marshal(j)
+	err = cfg.LoadJSON(tst)
+	if err == nil {
+		t.Error("expected error: peer_timeout lower than rebroadcast_interval")
+	}
+
+	json.Unmarshal(cfgJSON, j)
+	j.SyncTimeout = "abc"
+	tst, _ = json.Marshal(j)
+	err = cfg.LoadJSON(tst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.SyncTimeout != DefaultSyncTimeout {
+		t.Error("expected default sync_timeout")
+	}
+}
+
+func TestToJSON(t *testing.T) {
+	cfg := &Config{}
+	cfg.LoadJSON(cfgJSON)
+	newjson, err := cfg.ToJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg = &Config{}
+	err = cfg.LoadJSON(newjson)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.PeerTimeout != 20*time.Second {
+		t.Error("peer_timeout not preserved")
+	}
+}
+
+func TestDefault(t *testing.T) {
+	cfg := &Config{}
+	cfg.Default()
+	if cfg.Validate() != nil {
+		t.Fatal("error validating")
+	}
+
+	cfg.RebroadcastInterval = 0
+	if cfg.Validate() == nil {
+		t.Fatal("expected error validating")
+	}
+
+	cfg.Default()
+	cfg.TopicName = ""
+	if cfg.Validate() == nil {
+		t.Fatal("expected error validating")
+	}
+
+	cfg.Default()
+	cfg.TombstoneExpiry = cfg.PeerTimeout
+	if cfg.Validate() == nil {
+		t.Fatal("expected error validating")
+	}
+}
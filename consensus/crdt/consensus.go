@@ -0,0 +1,1074 @@
+// Package crdt implements a Consensus component for IPFS Cluster which
+// keeps the shared state in a CRDT pinset. Unlike Raft, it needs neither a
+// leader nor a stable peerset: peers join and leave the cluster just by
+// starting to send heartbeats on a PubSub topic and going away, and every
+// peer can modify the shared state at any time. Updates are sent to the
+// known peers over direct libp2p streams, which authenticate the sender.
+// Peers are eventually consistent: they periodically compare a summary of
+// their pinsets and exchange them when they differ.
+package crdt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/fsutil"
+	"github.com/ipfs/ipfs-cluster/logutil"
+	"github.com/ipfs/ipfs-cluster/state"
+
+	rpc "github.com/hsanjuan/go-libp2p-gorpc"
+	logging "github.com/ipfs/go-log"
+	floodsub "github.com/libp2p/go-floodsub"
+	host "github.com/libp2p/go-libp2p-host"
+	inet "github.com/libp2p/go-libp2p-net"
+	peer "github.com/libp2p/go-libp2p-peer"
+	protocol "github.com/libp2p/go-libp2p-protocol"
+	msgpack "github.com/multiformats/go-multicodec/msgpack"
+)
+
+var logger = logging.Logger("consensus")
+
+var msgpackHandle = msgpack.DefaultMsgpackHandle()
+
+// StateProtocol is the libp2p protocol used by peers to fetch the full
+// pinset of another peer.
+var StateProtocol = protocol.ID("/ipfscluster/crdt/state/1.0.0")
+
+// UpdateProtocol is the libp2p protocol used by peers to send pinset
+// updates and peer removals to each other.
+var UpdateProtocol = protocol.ID("/ipfscluster/crdt/update/1.0.0")
+
+// PinsetFileName is the name of the file, in the data folder, which
+// holds the pinset of this peer.
+var PinsetFileName = "pinset.msgpack"
+
+// How often WaitForSync checks whether the pinset is up to date
+var syncCheckInterval = 200 * time.Millisecond
+
+type messageType int
+
+// Types of messages. Only heartbeats are sent on the PubSub topic, as
+// its messages carry no proof of who sent them.
+const (
+	msgUpdate messageType = iota + 1
+	msgHeartbeat
+	msgRmPeer
+)
+
+// message is what peers send each other. Updates carry pinset entries,
+// heartbeats a summary of the pinset of the sender and peer removals
+// the peer which has been removed.
+type message struct {
+	Type    messageType
+	Peer    string
+	Clock   uint64
+	Entries []entry
+	Config  configEntry
+	Hash    uint64
+	Target  string
+}
+
+type peerInfo struct {
+	lastSeen time.Time
+	hasHash  bool
+	hash     uint64
+}
+
+// Consensus handles the work of keeping a shared-state between
+// the peers of an IPFS Cluster, as well as modifying that state and
+// applying any updates in a thread-safe manner.
+type Consensus struct {
+	ctx    context.Context
+	cancel func()
+	config *Config
+	logger *logutil.Logger
+
+	host         host.Host
+	pubsub       *floodsub.PubSub
+	subscription *floodsub.Subscription
+
+	rpcClient *rpc.Client
+	rpcReady  chan struct{}
+	readyCh   chan struct{}
+	started   time.Time
+
+	// protects the pinset and keeps the state in line with it
+	mu     sync.Mutex
+	pinset *pinset
+	state  state.State
+	dirty  bool // pinset changed since it was last saved
+
+	// changes applied to the state, see PinsetChanges
+	changes *state.ChangeLog
+
+	peersMu    sync.RWMutex
+	peers      map[peer.ID]*peerInfo
+	acceptPeer func(peer.ID) bool // see SetPeerFilter
+	trusted    func(peer.ID) bool // see SetCommitVerifier
+	verify     func(string, api.Pin) error
+	rmPeers    map[peer.ID]time.Time // recently removed peers
+	removed    bool                  // this peer was removed
+	pulling    bool
+
+	shutdownLock sync.Mutex
+	shutdown     bool
+	wg           sync.WaitGroup
+}
+
+// NewConsensus builds a new ClusterConsensus component using a CRDT
+// pinset. Heartbeats are sent using the given PubSub instance. The state
+// is made to match the pinset stored in the data folder, if any.
+func NewConsensus(
+	h host.Host,
+	pubsub *floodsub.PubSub,
+	cfg *Config,
+	st state.State,
+) (*Consensus, error) {
+	err := cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	cc := &Consensus{
+		ctx:      ctx,
+		cancel:   cancel,
+		config:   cfg,
+		logger:   logutil.New(logger),
+		host:     h,
+		pubsub:   pubsub,
+		rpcReady: make(chan struct{}, 1),
+		readyCh:  make(chan struct{}, 1),
+		pinset:   newPinset(cfg.TombstoneExpiry),
+		state:    st,
+		changes:  state.NewChangeLog(0),
+		peers:    make(map[peer.ID]*peerInfo),
+		rmPeers:  make(map[peer.ID]time.Time),
+		acceptPeer: func(peer.ID) bool {
+			return true
+		},
+		trusted: func(peer.ID) bool {
+			return true
+		},
+		verify: func(string, api.Pin) error {
+			return nil
+		},
+	}
+	cc.logger.SetPrefix(logutil.PeerPrefix(h.ID()))
+
+	err = cc.load()
+	if err != nil {
+		cc.logger.Error("error loading the pinset: ", err)
+		cancel()
+		return nil, err
+	}
+
+	subscription, err := pubsub.Subscribe(cfg.TopicName)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	cc.subscription = subscription
+
+	h.SetStreamHandler(StateProtocol, cc.handleStateStream)
+	h.SetStreamHandler(UpdateProtocol, cc.handleUpdateStream)
+
+	cc.wg.Add(1)
+	go cc.run()
+	return cc, nil
+}
+
+// run starts processing updates once RPC is available and signals the
+// component as ready once the pinset has been synced with other peers.
+func (cc *Consensus) run() {
+	defer cc.wg.Done()
+	select {
+	case <-cc.ctx.Done():
+		return
+	case <-cc.rpcReady:
+	}
+
+	cc.started = time.Now()
+	// run is counted in the WaitGroup, so Shutdown cannot be waiting
+	// on it yet.
+	cc.wg.Add(2)
+	go cc.handleMessages()
+	go cc.rebroadcast()
+
+	err := cc.WaitForSync()
+	if err != nil {
+		// The pinset will converge eventually, we do not need
+		// to block the peer.
+		cc.logger.Warning(err)
+	}
+	cc.logger.Debug("consensus ready")
+	cc.readyCh <- struct{}{}
+}
+
+// Shutdown stops the component so it will not process any
+// more updates. The pinset is saved to disk.
+func (cc *Consensus) Shutdown() error {
+	cc.shutdownLock.Lock()
+	defer cc.shutdownLock.Unlock()
+
+	if cc.shutdown {
+		cc.logger.Debug("already shutdown")
+		return nil
+	}
+
+	cc.logger.Info("stopping Consensus component")
+
+	cc.cancel()
+	cc.subscription.Cancel()
+	cc.host.RemoveStreamHandler(StateProtocol)
+	cc.host.RemoveStreamHandler(UpdateProtocol)
+	cc.wg.Wait()
+
+	err := cc.save()
+	if err != nil {
+		cc.logger.Error(err)
+	}
+
+	cc.shutdown = true
+	return nil
+}
+
+// SetPeerFilter sets a function which decides which peers may be part
+// of the peerset. Messages and pinset requests from the peers for which
+// it returns false are ignored.
+func (cc *Consensus) SetPeerFilter(accept func(peer.ID) bool) {
+	cc.peersMu.Lock()
+	defer cc.peersMu.Unlock()
+	cc.acceptPeer = accept
+	for pid := range cc.peers {
+		if !accept(pid) {
+			delete(cc.peers, pid)
+		}
+	}
+}
+
+func (cc *Consensus) accepted(pid peer.ID) bool {
+	cc.peersMu.RLock()
+	defer cc.peersMu.RUnlock()
+	return cc.acceptPeer(pid)
+}
+
+// SetCommitVerifier sets the checks made on the updates received from
+// other peers, which are applied without going through the Consensus
+// RPC methods. Updates, peer removals and full pinsets are only accepted
+// from the peers for which trusted returns true. The pins and unpins in
+// updates are applied only when verify does not return an error for
+// them.
+func (cc *Consensus) SetCommitVerifier(trusted func(peer.ID) bool, verify func(op string, pin api.Pin) error) {
+	cc.peersMu.Lock()
+	defer cc.peersMu.Unlock()
+	cc.trusted = trusted
+	cc.verify = verify
+}
+
+func (cc *Consensus) isTrusted(pid peer.ID) bool {
+	cc.peersMu.RLock()
+	defer cc.peersMu.RUnlock()
+	return cc.trusted(pid)
+}
+
+// SetLogPrefix sets the prefix of the messages logged by this component.
+func (cc *Consensus) SetLogPrefix(prefix string) {
+	cc.logger.SetPrefix(prefix)
+}
+
+// SetClient makes the component ready to perform RPC requets
+func (cc *Consensus) SetClient(c *rpc.Client) {
+	cc.rpcClient = c
+	select {
+	case cc.rpcReady <- struct{}{}:
+	default: // already signaled
+	}
+}
+
+// Ready returns a channel which is signaled when the Consensus
+// has synced its pinset with other peers and is ready to use.
+func (cc *Consensus) Ready() <-chan struct{} {
+	return cc.readyCh
+}
+
+// WaitForSync waits until the pinset of this peer matches the one
+// announced by any other peer, or until a full rebroadcast interval
+// has passed without hearing from any peer.
+func (cc *Consensus) WaitForSync() error {
+	ctx, cancel := context.WithTimeout(cc.ctx, cc.config.SyncTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(syncCheckInterval)
+	defer ticker.Stop()
+	for !cc.synced() {
+		select {
+		case <-ctx.Done():
+			return errors.New("timed out waiting for the pinset to sync with other peers")
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+func (cc *Consensus) synced() bool {
+	cc.mu.Lock()
+	hash := cc.pinset.hash
+	cc.mu.Unlock()
+
+	cc.peersMu.RLock()
+	defer cc.peersMu.RUnlock()
+	alive := 0
+	for pid, pi := range cc.peers {
+		if time.Since(pi.lastSeen) >= cc.config.PeerTimeout || !cc.acceptPeer(pid) {
+			continue
+		}
+		alive++
+		if pi.hasHash && pi.hash == hash {
+			return true
+		}
+	}
+	return alive == 0 && time.Since(cc.started) > cc.config.RebroadcastInterval
+}
+
+// handleMessages processes the messages received in the subscribed topic.
+func (cc *Consensus) handleMessages() {
+	defer cc.wg.Done()
+	for {
+		select {
+		case <-cc.ctx.Done():
+			return
+		default:
+			msg, err := cc.subscription.Next(cc.ctx)
+			if err != nil { // context cancelled enters here
+				continue
+			}
+
+			from, err := peer.IDFromBytes(msg.GetFrom())
+			if err != nil {
+				cc.logger.Errorf("bad sender in crdt message: %s", err)
+				continue
+			}
+
+			buf := bytes.NewBuffer(msg.GetData())
+			dec := msgpack.Multicodec(msgpackHandle).Decoder(buf)
+			m := message{}
+			err = dec.Decode(&m)
+			if err != nil {
+				cc.logger.Error(err)
+				continue
+			}
+			// The sender of PubSub messages can be forged.
+			if m.Type != msgHeartbeat {
+				cc.logger.Warningf("ignoring crdt message of type %d received over PubSub", m.Type)
+				continue
+			}
+			cc.handleMessage(from, m)
+		}
+	}
+}
+
+// handleUpdateStream processes an update or peer removal sent by the
+// peer which opened the stream.
+func (cc *Consensus) handleUpdateStream(s inet.Stream) {
+	defer s.Close()
+	from := s.Conn().RemotePeer()
+	s.SetDeadline(time.Now().Add(cc.config.SyncTimeout))
+
+	dec := msgpack.Multicodec(msgpackHandle).Decoder(s)
+	m := message{}
+	err := dec.Decode(&m)
+	if err != nil {
+		cc.logger.Errorf("error reading crdt message from %s: %s", from.Pretty(), err)
+		return
+	}
+	if m.Type == msgHeartbeat {
+		cc.logger.Warningf("ignoring crdt heartbeat from %s received over a stream", from.Pretty())
+		return
+	}
+	cc.handleMessage(from, m)
+}
+
+// handleMessage processes a message sent by the given peer. Messages
+// claiming to come from a different peer are dropped.
+func (cc *Consensus) handleMessage(from peer.ID, m message) {
+	if m.Peer != peer.IDB58Encode(from) {
+		cc.logger.Warningf("dropping crdt message from %s claiming to come from %s", from.Pretty(), m.Peer)
+		return
+	}
+	if from == cc.host.ID() { // our own messages
+		return
+	}
+	if !cc.accepted(from) {
+		cc.logger.Debugf("ignoring crdt message from filtered peer %s", from.Pretty())
+		return
+	}
+
+	switch m.Type {
+	case msgUpdate:
+		cc.seen(from, nil)
+		if !cc.isTrusted(from) {
+			cc.logger.Warningf("ignoring crdt update from untrusted peer %s", from.Pretty())
+			return
+		}
+		cc.merge(cc.verifyUpdate(from, m))
+	case msgHeartbeat:
+		cc.seen(from, &m.Hash)
+		cc.mu.Lock()
+		cc.pinset.witness(m.Clock)
+		mismatch := cc.pinset.hash != m.Hash
+		cc.mu.Unlock()
+		// Full pinsets are only taken from trusted peers, as
+		// they may carry updates made by anyone.
+		if mismatch && cc.isTrusted(from) {
+			go cc.pull(from)
+		}
+	case msgRmPeer:
+		cc.seen(from, nil)
+		if !cc.isTrusted(from) {
+			cc.logger.Warningf("ignoring peer removal from untrusted peer %s", from.Pretty())
+			return
+		}
+		target, err := peer.IDB58Decode(m.Target)
+		if err != nil {
+			cc.logger.Error(err)
+			return
+		}
+		cc.forgetPeer(target)
+	default:
+		cc.logger.Error("unknown crdt message type. Ignoring")
+	}
+}
+
+// verifyUpdate returns the update message without the entries which were
+// not made by the peer which sent it or which fail verification.
+func (cc *Consensus) verifyUpdate(from peer.ID, m message) message {
+	cc.peersMu.RLock()
+	verify := cc.verify
+	cc.peersMu.RUnlock()
+
+	entries := make([]entry, 0, len(m.Entries))
+	for _, e := range m.Entries {
+		if e.Peer != m.Peer {
+			cc.logger.Warningf("dropping update of %s not made by %s", e.Key, from.Pretty())
+			continue
+		}
+		op := api.PinOpPin
+		if e.Deleted {
+			op = api.PinOpUnpin
+		}
+		err := verify(op, e.Pin.ToPin())
+		if err != nil {
+			cc.logger.Warningf("dropping update of %s from %s: %s", e.Key, from.Pretty(), err)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	m.Entries = entries
+	if m.Config.Clock > 0 && m.Config.Peer != m.Peer {
+		cc.logger.Warningf("dropping configuration update not made by %s", from.Pretty())
+		m.Config = configEntry{}
+	}
+	return m
+}
+
+// seen records that we have heard from a peer and, for heartbeats,
+// the hash of its pinset.
+func (cc *Consensus) seen(pid peer.ID, hash *uint64) {
+	cc.peersMu.Lock()
+	defer cc.peersMu.Unlock()
+
+	if t, ok := cc.rmPeers[pid]; ok && time.Since(t) < cc.config.PeerTimeout {
+		return
+	}
+	delete(cc.rmPeers, pid)
+
+	pi, ok := cc.peers[pid]
+	if !ok {
+		cc.logger.Infof("new crdt peer: %s", pid.Pretty())
+		pi = &peerInfo{}
+		cc.peers[pid] = pi
+	}
+	pi.lastSeen = time.Now()
+	if hash != nil {
+		pi.hasHash = true
+		pi.hash = *hash
+	}
+}
+
+// forgetPeer removes a peer from the peerset. Its messages will not
+// make it a member again until PeerTimeout has passed.
+func (cc *Consensus) forgetPeer(pid peer.ID) {
+	cc.peersMu.Lock()
+	defer cc.peersMu.Unlock()
+	delete(cc.peers, pid)
+	cc.rmPeers[pid] = time.Now()
+	if pid == cc.host.ID() {
+		cc.removed = true
+	}
+}
+
+// rebroadcast periodically sends heartbeats, expires old tombstones and
+// saves the pinset.
+func (cc *Consensus) rebroadcast() {
+	defer cc.wg.Done()
+	ticker := time.NewTicker(cc.config.RebroadcastInterval)
+	defer ticker.Stop()
+
+	for {
+		cc.mu.Lock()
+		if n := cc.pinset.expireTombstones(time.Now()); n > 0 {
+			cc.logger.Debugf("%d expired tombstones removed from the pinset", n)
+			cc.dirty = true
+		}
+		hb := message{
+			Type:  msgHeartbeat,
+			Peer:  peer.IDB58Encode(cc.host.ID()),
+			Clock: cc.pinset.clock,
+			Hash:  cc.pinset.hash,
+		}
+		cc.mu.Unlock()
+		err := cc.publish(hb)
+		if err != nil {
+			cc.logger.Error(err)
+		}
+
+		err = cc.save()
+		if err != nil {
+			cc.logger.Error(err)
+		}
+
+		select {
+		case <-cc.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func encodeMessage(m message) ([]byte, error) {
+	var b bytes.Buffer
+	enc := msgpack.Multicodec(msgpackHandle).Encoder(&b)
+	err := enc.Encode(m)
+	return b.Bytes(), err
+}
+
+func (cc *Consensus) publish(m message) error {
+	b, err := encodeMessage(m)
+	if err != nil {
+		return err
+	}
+	return cc.pubsub.Publish(cc.config.TopicName, b)
+}
+
+// recipients returns the peers which updates are sent to: those heard
+// of within PeerTimeout. Peers which miss updates get them by pulling
+// the pinset after the next heartbeat.
+func (cc *Consensus) recipients() []peer.ID {
+	cc.peersMu.RLock()
+	defer cc.peersMu.RUnlock()
+	var peers []peer.ID
+	for pid, pi := range cc.peers {
+		if time.Since(pi.lastSeen) < cc.config.PeerTimeout && cc.acceptPeer(pid) {
+			peers = append(peers, pid)
+		}
+	}
+	return peers
+}
+
+// send delivers a message to the given peers over UpdateProtocol
+// streams. It does not wait for the message to be delivered.
+func (cc *Consensus) send(peers []peer.ID, m message) error {
+	b, err := encodeMessage(m)
+	if err != nil {
+		return err
+	}
+
+	cc.shutdownLock.Lock()
+	defer cc.shutdownLock.Unlock()
+	if cc.shutdown {
+		return errors.New("consensus is shutdown")
+	}
+	cc.wg.Add(len(peers))
+	for _, pid := range peers {
+		go func(pid peer.ID) {
+			defer cc.wg.Done()
+			err := cc.sendTo(pid, b)
+			if err != nil {
+				cc.logger.Warningf("error sending crdt message to %s: %s", pid.Pretty(), err)
+			}
+		}(pid)
+	}
+	return nil
+}
+
+func (cc *Consensus) sendTo(pid peer.ID, b []byte) error {
+	ctx, cancel := context.WithTimeout(cc.ctx, cc.config.SyncTimeout)
+	defer cancel()
+	s, err := cc.host.NewStream(ctx, pid, UpdateProtocol)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	s.SetDeadline(time.Now().Add(cc.config.SyncTimeout))
+	_, err = s.Write(b)
+	return err
+}
+
+// pull fetches the full pinset of a peer and merges it. Only one
+// pull runs at a time.
+func (cc *Consensus) pull(pid peer.ID) {
+	cc.peersMu.Lock()
+	if cc.pulling {
+		cc.peersMu.Unlock()
+		return
+	}
+	cc.pulling = true
+	cc.peersMu.Unlock()
+
+	defer func() {
+		cc.peersMu.Lock()
+		cc.pulling = false
+		cc.peersMu.Unlock()
+	}()
+
+	cc.logger.Debugf("fetching the pinset of %s", pid.Pretty())
+	ctx, cancel := context.WithTimeout(cc.ctx, cc.config.SyncTimeout)
+	defer cancel()
+	s, err := cc.host.NewStream(ctx, pid, StateProtocol)
+	if err != nil {
+		cc.logger.Errorf("error fetching the pinset of %s: %s", pid.Pretty(), err)
+		return
+	}
+	defer s.Close()
+	s.SetDeadline(time.Now().Add(cc.config.SyncTimeout))
+
+	dec := msgpack.Multicodec(msgpackHandle).Decoder(s)
+	m := message{}
+	err = dec.Decode(&m)
+	if err != nil {
+		cc.logger.Errorf("error fetching the pinset of %s: %s", pid.Pretty(), err)
+		return
+	}
+	cc.merge(m)
+}
+
+// handleStateStream sends our full pinset to the peer which opened
+// the stream.
+func (cc *Consensus) handleStateStream(s inet.Stream) {
+	defer s.Close()
+	if !cc.accepted(s.Conn().RemotePeer()) {
+		cc.logger.Warningf("refusing to send the pinset to filtered peer %s", s.Conn().RemotePeer().Pretty())
+		return
+	}
+	s.SetDeadline(time.Now().Add(cc.config.SyncTimeout))
+
+	cc.mu.Lock()
+	m := cc.fullUpdate()
+	cc.mu.Unlock()
+
+	enc := msgpack.Multicodec(msgpackHandle).Encoder(s)
+	err := enc.Encode(m)
+	if err != nil {
+		cc.logger.Errorf("error sending the pinset to %s: %s", s.Conn().RemotePeer().Pretty(), err)
+	}
+}
+
+// fullUpdate returns an update message with the whole pinset. The
+// caller must hold the lock.
+func (cc *Consensus) fullUpdate() message {
+	return message{
+		Type:    msgUpdate,
+		Peer:    peer.IDB58Encode(cc.host.ID()),
+		Clock:   cc.pinset.clock,
+		Entries: cc.pinset.list(),
+		Config:  cc.pinset.config,
+	}
+}
+
+// merge adds the entries in an update message to the pinset and
+// applies those which win to the state.
+func (cc *Consensus) merge(m message) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	cc.pinset.witness(m.Clock)
+	for _, e := range m.Entries {
+		prev, existed, applied := cc.pinset.merge(e)
+		if !applied {
+			continue
+		}
+		cc.dirty = true
+		cc.apply(prev, existed, e)
+	}
+
+	if m.Config.Clock > 0 && cc.pinset.mergeConfig(m.Config) {
+		cc.dirty = true
+		cc.applyConfig(m.Config)
+	}
+}
+
+// apply updates the state after an entry has replaced another one
+// in the pinset and lets the PinTracker know. The caller must hold
+// the lock.
+func (cc *Consensus) apply(prev entry, existed bool, e entry) {
+	if e.Deleted {
+		if !existed || prev.Deleted {
+			return
+		}
+		pin := prev.pin()
+		cc.logger.Debugf("applying unpin %s", pin.Cid)
+		err := cc.state.Rm(pin.Cid)
+		if err != nil {
+			cc.logger.Error(err)
+			return
+		}
+		cc.changes.Record(api.PinsetChangeRemove, pin)
+		// Async, we let the PinTracker take care of any problems
+		cc.rpcGo("Untrack", pin.ToSerial())
+		return
+	}
+
+	pin := e.pin()
+	cc.logger.Debugf("applying pin %s", pin.Cid)
+	err := cc.state.Add(pin)
+	if err != nil {
+		cc.logger.Error(err)
+		return
+	}
+	cc.changes.Record(api.PinsetChangeAdd, pin)
+	// Async, we let the PinTracker take care of any problems
+	cc.rpcGo("Track", pin.ToSerial())
+}
+
+// applyConfig updates the cluster-wide configuration in the state and
+// lets the peer apply any changes. The caller must hold the lock.
+func (cc *Consensus) applyConfig(c configEntry) {
+	err := cc.state.SetSharedConfig(c.Config.ToSharedConfig())
+	if err != nil {
+		cc.logger.Error(err)
+		return
+	}
+	cc.rpcGo("ApplySharedConfig", c.Config)
+}
+
+func (cc *Consensus) rpcGo(method string, arg interface{}) {
+	if cc.rpcClient == nil {
+		return
+	}
+	cc.rpcClient.Go("",
+		"Cluster",
+		method,
+		arg,
+		&struct{}{},
+		nil)
+}
+
+// commit adds a local update to the pinset, applies it and broadcasts
+// it to the rest of peers.
+func (cc *Consensus) commit(e entry) error {
+	cc.mu.Lock()
+	prev, existed := cc.pinset.entries[e.Key]
+	if isNoOp(prev, existed, e) {
+		cc.mu.Unlock()
+		cc.logger.Debugf("skipping no-op update of %s", e.Key)
+		return nil
+	}
+	e.Clock = cc.pinset.tick()
+	e.Peer = peer.IDB58Encode(cc.host.ID())
+	e.Timestamp = time.Now().Unix()
+	cc.pinset.merge(e)
+	cc.dirty = true
+	cc.apply(prev, existed, e)
+	cc.mu.Unlock()
+
+	return cc.send(cc.recipients(), message{
+		Type:    msgUpdate,
+		Peer:    e.Peer,
+		Clock:   e.Clock,
+		Entries: []entry{e},
+	})
+}
+
+// isNoOp returns true when replacing prev with e would leave the state
+// untouched: pinning something which is already pinned with the same
+// options or unpinning something which is not pinned.
+func isNoOp(prev entry, existed bool, e entry) bool {
+	if e.Deleted {
+		return !existed || prev.Deleted
+	}
+	return existed && !prev.Deleted && prev.pin().Equals(e.pin())
+}
+
+// LogPin adds a Cid to the shared state of the cluster. The entry keeps
+// the signed request, if any, so that other peers can verify it.
+func (cc *Consensus) LogPin(pin api.Pin) error {
+	err := cc.commit(entry{Key: pin.Cid.String(), Pin: pin.ToSerial()})
+	if err != nil {
+		return err
+	}
+	cc.logger.Infof("pin committed to global state: %s (request %s)", pin.Cid, pin.RequestID)
+	return nil
+}
+
+// LogUnpin removes a Cid from the shared state of the cluster.
+func (cc *Consensus) LogUnpin(pin api.Pin) error {
+	err := cc.commit(entry{Key: pin.Cid.String(), Pin: pin.ToSerial(), Deleted: true})
+	if err != nil {
+		return err
+	}
+	cc.logger.Infof("unpin committed to global state: %s (request %s)", pin.Cid, pin.RequestID)
+	return nil
+}
+
+// LogSharedConfig modifies the cluster-wide configuration stored in the
+// shared state. The update is merged with the configuration known by this
+// peer. The shared configuration is a single last-writer-wins entry, so
+// concurrent updates made through different peers may still overwrite
+// each other.
+func (cc *Consensus) LogSharedConfig(update api.SharedConfigUpdate) error {
+	cc.mu.Lock()
+	current := cc.pinset.config.Config.ToSharedConfig()
+	cfg := current.Merge(update)
+	if cc.pinset.config.Clock > 0 && current == cfg {
+		cc.mu.Unlock()
+		return nil
+	}
+	c := configEntry{
+		Config: cfg.ToSerial(),
+		Clock:  cc.pinset.tick(),
+		Peer:   peer.IDB58Encode(cc.host.ID()),
+	}
+	cc.pinset.mergeConfig(c)
+	cc.dirty = true
+	cc.applyConfig(c)
+	cc.mu.Unlock()
+
+	err := cc.send(cc.recipients(), message{
+		Type:   msgUpdate,
+		Peer:   c.Peer,
+		Clock:  c.Clock,
+		Config: c,
+	})
+	if err != nil {
+		return err
+	}
+	cc.logger.Infof("shared configuration committed to global state: %+v", c.Config)
+	return nil
+}
+
+// AddPeer makes a peer part of the peerset right away. Peers do not
+// need to be added though: they become members as soon as they are
+// heard of.
+func (cc *Consensus) AddPeer(pid peer.ID) error {
+	cc.peersMu.Lock()
+	defer cc.peersMu.Unlock()
+	if !cc.acceptPeer(pid) {
+		return fmt.Errorf("peer %s is not accepted in the peerset", pid.Pretty())
+	}
+	delete(cc.rmPeers, pid)
+	if _, ok := cc.peers[pid]; !ok {
+		cc.peers[pid] = &peerInfo{lastSeen: time.Now()}
+	}
+	cc.logger.Infof("peer added: %s", pid.Pretty())
+	return nil
+}
+
+// RmPeer removes a peer from the peerset and tells the rest of peers to
+// do so. A removed peer which is still running will notice and stop
+// listing itself as a member.
+func (cc *Consensus) RmPeer(pid peer.ID) error {
+	peers := cc.recipients() // including the removed peer
+	cc.forgetPeer(pid)
+	err := cc.send(peers, message{
+		Type:   msgRmPeer,
+		Peer:   peer.IDB58Encode(cc.host.ID()),
+		Target: peer.IDB58Encode(pid),
+	})
+	if err != nil {
+		return err
+	}
+	cc.logger.Infof("peer removed: %s", pid.Pretty())
+	return nil
+}
+
+// State returns the current shared state as known by this peer.
+func (cc *Consensus) State() (state.State, error) {
+	return cc.state, nil
+}
+
+// Leader returns the first peer (as sorted by Peers()) in the peerset.
+// There is no leader in this consensus, but this peer takes care of the
+// tasks which should only run in one cluster peer. It returns an error
+// when the peerset is empty.
+func (cc *Consensus) Leader() (peer.ID, error) {
+	peers, err := cc.Peers()
+	if err != nil {
+		return "", err
+	}
+	if len(peers) == 0 {
+		return "", errors.New("no peers in the peerset")
+	}
+	return peers[0], nil
+}
+
+// Clean removes the pinset stored on disk. Next time a peer starts with
+// an empty one.
+func (cc *Consensus) Clean() error {
+	if !cc.shutdown {
+		return errors.New("consensus component is not shutdown")
+	}
+	return os.RemoveAll(cc.config.GetDataFolder())
+}
+
+// Peers returns this peer and those heard of within PeerTimeout.
+// The list will be sorted alphabetically.
+func (cc *Consensus) Peers() ([]peer.ID, error) {
+	if cc.shutdown {
+		return nil, errors.New("consensus is shutdown")
+	}
+
+	cc.peersMu.RLock()
+	defer cc.peersMu.RUnlock()
+	peers := []peer.ID{}
+	if !cc.removed {
+		peers = append(peers, cc.host.ID())
+	}
+	for pid, pi := range cc.peers {
+		if time.Since(pi.lastSeen) < cc.config.PeerTimeout && cc.acceptPeer(pid) {
+			peers = append(peers, pid)
+		}
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		return peer.IDB58Encode(peers[i]) < peer.IDB58Encode(peers[j])
+	})
+	return peers, nil
+}
+
+// PinsetChanges returns up to limit pinset changes applied by this peer
+// after the given index in the given epoch. When there are none, it waits
+// up to the given time for new ones. The log starts with a new epoch every
+// time the peer starts.
+func (cc *Consensus) PinsetChanges(epoch string, since uint64, limit int, wait time.Duration) (api.PinsetChanges, error) {
+	return cc.changes.Wait(cc.ctx, epoch, since, limit, wait), nil
+}
+
+// Status returns information about the consensus as seen by this peer.
+// All peers are voters and the Leader is the one given by Leader().
+// The AppliedIndex and CommitIndex are the logical clock of this peer and
+// LogEntries is the number of entries in the pinset, tombstones included.
+func (cc *Consensus) Status() (api.ConsensusState, error) {
+	var cs api.ConsensusState
+	peers, err := cc.Peers()
+	if err != nil {
+		return cs, err
+	}
+	cs.Voters = peers
+	if len(peers) > 0 {
+		cs.Leader = peers[0]
+		cs.HasQuorum = true
+	}
+
+	cc.mu.Lock()
+	cs.AppliedIndex = cc.pinset.clock
+	cs.CommitIndex = cc.pinset.clock
+	cs.LogEntries = uint64(len(cc.pinset.entries))
+	cc.mu.Unlock()
+	return cs, nil
+}
+
+// load reads the pinset stored in the data folder, if any, and makes
+// the state match it.
+func (cc *Consensus) load() error {
+	path := filepath.Join(cc.config.GetDataFolder(), PinsetFileName)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	dec := msgpack.Multicodec(msgpackHandle).Decoder(bytes.NewBuffer(data))
+	m := message{}
+	err = dec.Decode(&m)
+	if err != nil {
+		return err
+	}
+
+	cc.pinset.witness(m.Clock)
+	for _, e := range m.Entries {
+		cc.pinset.merge(e)
+	}
+	cc.pinset.mergeConfig(m.Config)
+
+	for _, pin := range cc.state.List() {
+		e, ok := cc.pinset.entries[pin.Cid.String()]
+		if !ok || e.Deleted {
+			err := cc.state.Rm(pin.Cid)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, e := range cc.pinset.entries {
+		if e.Deleted {
+			continue
+		}
+		err := cc.state.Add(e.pin())
+		if err != nil {
+			return err
+		}
+	}
+	if cc.pinset.config.Clock > 0 {
+		return cc.state.SetSharedConfig(cc.pinset.config.Config.ToSharedConfig())
+	}
+	return nil
+}
+
+// save writes the pinset to the data folder when it has changed.
+func (cc *Consensus) save() error {
+	cc.mu.Lock()
+	if !cc.dirty {
+		cc.mu.Unlock()
+		return nil
+	}
+	m := cc.fullUpdate()
+	cc.dirty = false
+	cc.mu.Unlock()
+
+	err := cc.writePinset(m)
+	if err != nil {
+		cc.mu.Lock()
+		cc.dirty = true
+		cc.mu.Unlock()
+	}
+	return err
+}
+
+func (cc *Consensus) writePinset(m message) error {
+	folder := cc.config.GetDataFolder()
+	err := os.MkdirAll(folder, 0700)
+	if err != nil {
+		return err
+	}
+
+	var b bytes.Buffer
+	enc := msgpack.Multicodec(msgpackHandle).Encoder(&b)
+	err = enc.Encode(m)
+	if err != nil {
+		return err
+	}
+
+	return fsutil.WriteFileAtomic(filepath.Join(folder, PinsetFileName), b.Bytes(), 0600)
+}
@@ -0,0 +1,400 @@
+package crdt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/state/mapstate"
+	"github.com/ipfs/ipfs-cluster/test"
+
+	cid "github.com/ipfs/go-cid"
+	floodsub "github.com/libp2p/go-floodsub"
+	libp2p "github.com/libp2p/go-libp2p"
+	host "github.com/libp2p/go-libp2p-host"
+	peer "github.com/libp2p/go-libp2p-peer"
+	peerstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+func cleanCRDT(idn int) {
+	os.RemoveAll(fmt.Sprintf("crdtFolderFromTests-%d", idn))
+}
+
+func makeTestingHost(t *testing.T) host.Host {
+	h, err := libp2p.New(
+		context.Background(),
+		libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func testingConfig(idn int) *Config {
+	cfg := &Config{}
+	cfg.Default()
+	cfg.DataFolder = fmt.Sprintf("crdtFolderFromTests-%d", idn)
+	cfg.RebroadcastInterval = 200 * time.Millisecond
+	cfg.PeerTimeout = time.Second
+	cfg.SyncTimeout = 2 * time.Second
+	return cfg
+}
+
+func testingConsensusWithHost(t *testing.T, idn int, h host.Host) *Consensus {
+	pubsub, err := floodsub.NewFloodSub(context.Background(), h)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cc, err := NewConsensus(h, pubsub, testingConfig(idn), mapstate.NewMapState())
+	if err != nil {
+		t.Fatal("cannot create Consensus:", err)
+	}
+	cc.SetClient(test.NewMockRPCClientWithHost(t, h))
+	<-cc.Ready()
+	return cc
+}
+
+func testingConsensus(t *testing.T, idn int) *Consensus {
+	cleanCRDT(idn)
+	return testingConsensusWithHost(t, idn, makeTestingHost(t))
+}
+
+func shutdownConsensus(cc *Consensus) {
+	cc.Shutdown()
+	cc.host.Close()
+}
+
+func testPin(c *cid.Cid) api.Pin {
+	pin := api.PinCid(c)
+	pin.ReplicationFactorMin = -1
+	pin.ReplicationFactorMax = -1
+	return pin
+}
+
+// testUpdate returns an update message with a single entry made by the
+// given peer.
+func testUpdate(pid peer.ID, pin api.Pin, deleted bool, clock uint64) message {
+	p := peer.IDB58Encode(pid)
+	return message{
+		Type:  msgUpdate,
+		Peer:  p,
+		Clock: clock,
+		Entries: []entry{
+			{
+				Key:       pin.Cid.String(),
+				Pin:       pin.ToSerial(),
+				Deleted:   deleted,
+				Clock:     clock,
+				Peer:      p,
+				Timestamp: time.Now().Unix(),
+			},
+		},
+	}
+}
+
+func waitFor(t *testing.T, what string, f func() bool) {
+	deadline := time.Now().Add(10 * time.Second)
+	for !f() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestShutdownConsensus(t *testing.T) {
+	cc := testingConsensus(t, 1)
+	defer cleanCRDT(1)
+	defer cc.host.Close()
+	err := cc.Shutdown()
+	if err != nil {
+		t.Fatal("Consensus cannot shutdown:", err)
+	}
+	err = cc.Shutdown() // should be fine to shutdown twice
+	if err != nil {
+		t.Fatal("Consensus should be able to shutdown several times")
+	}
+	cc.SetClient(nil) // should not panic nor block
+}
+
+func TestConsensusPinUnpin(t *testing.T) {
+	cc := testingConsensus(t, 1)
+	defer cleanCRDT(1)
+	defer shutdownConsensus(cc)
+
+	c, _ := cid.Decode(test.TestCid1)
+	err := cc.LogPin(testPin(c))
+	if err != nil {
+		t.Fatal("error pinning:", err)
+	}
+
+	st, _ := cc.State()
+	if !st.Has(c) {
+		t.Fatal("the state should have the pin")
+	}
+
+	err = cc.LogUnpin(testPin(c))
+	if err != nil {
+		t.Fatal("error unpinning:", err)
+	}
+	if st.Has(c) {
+		t.Error("the pin should have been removed")
+	}
+
+	changes, _ := cc.PinsetChanges("", 0, 0, 0)
+	if len(changes.Changes) != 2 {
+		t.Errorf("expected 2 changes, got %d", len(changes.Changes))
+	}
+}
+
+func TestConsensusMerge(t *testing.T) {
+	cc := testingConsensus(t, 1)
+	defer cleanCRDT(1)
+	defer shutdownConsensus(cc)
+
+	c, _ := cid.Decode(test.TestCid1)
+	st, _ := cc.State()
+
+	cc.handleMessage(test.TestPeerID1, testUpdate(test.TestPeerID1, testPin(c), false, 5))
+	if !st.Has(c) {
+		t.Fatal("the merged pin should be in the state")
+	}
+
+	// older unpin
+	cc.handleMessage(test.TestPeerID2, testUpdate(test.TestPeerID2, testPin(c), true, 4))
+	if !st.Has(c) {
+		t.Fatal("an older unpin should not be applied")
+	}
+
+	cc.handleMessage(test.TestPeerID2, testUpdate(test.TestPeerID2, testPin(c), true, 6))
+	if st.Has(c) {
+		t.Fatal("a newer unpin should be applied")
+	}
+
+	cc.mu.Lock()
+	clock := cc.pinset.clock
+	cc.mu.Unlock()
+	if clock != 6 {
+		t.Errorf("the clock should be 6, not %d", clock)
+	}
+}
+
+func TestConsensusRejectedUpdates(t *testing.T) {
+	cc := testingConsensus(t, 1)
+	defer cleanCRDT(1)
+	defer shutdownConsensus(cc)
+
+	c, _ := cid.Decode(test.TestCid1)
+	st, _ := cc.State()
+
+	// sender does not match the peer in the message
+	cc.handleMessage(test.TestPeerID2, testUpdate(test.TestPeerID1, testPin(c), false, 1))
+	if st.Has(c) {
+		t.Fatal("messages from spoofed senders should be dropped")
+	}
+
+	// entries made by another peer
+	m := testUpdate(test.TestPeerID1, testPin(c), false, 1)
+	m.Peer = peer.IDB58Encode(test.TestPeerID2)
+	cc.handleMessage(test.TestPeerID2, m)
+	if st.Has(c) {
+		t.Fatal("entries not made by the sender should be dropped")
+	}
+
+	cc.SetCommitVerifier(
+		func(pid peer.ID) bool { return pid != test.TestPeerID3 },
+		func(op string, pin api.Pin) error {
+			if pin.Name != "signed" {
+				return errors.New("not signed")
+			}
+			return nil
+		},
+	)
+
+	signed := testPin(c)
+	signed.Name = "signed"
+	cc.handleMessage(test.TestPeerID3, testUpdate(test.TestPeerID3, signed, false, 1))
+	if st.Has(c) {
+		t.Fatal("updates from untrusted peers should be dropped")
+	}
+
+	cc.handleMessage(test.TestPeerID1, testUpdate(test.TestPeerID1, testPin(c), false, 1))
+	if st.Has(c) {
+		t.Fatal("updates failing verification should be dropped")
+	}
+
+	cc.handleMessage(test.TestPeerID1, testUpdate(test.TestPeerID1, signed, false, 1))
+	if !st.Has(c) {
+		t.Fatal("verified updates from trusted peers should be applied")
+	}
+}
+
+func TestConsensusRmPeer(t *testing.T) {
+	cc := testingConsensus(t, 1)
+	defer cleanCRDT(1)
+	defer shutdownConsensus(cc)
+
+	cc.SetCommitVerifier(
+		func(pid peer.ID) bool { return pid != test.TestPeerID3 },
+		func(string, api.Pin) error { return nil },
+	)
+
+	cc.AddPeer(test.TestPeerID1)
+	cc.AddPeer(test.TestPeerID2)
+	peers, _ := cc.Peers()
+	if len(peers) != 3 {
+		t.Fatal("expected 3 peers")
+	}
+
+	rmPeer := func(from, target peer.ID) {
+		cc.handleMessage(from, message{
+			Type:   msgRmPeer,
+			Peer:   peer.IDB58Encode(from),
+			Target: peer.IDB58Encode(target),
+		})
+	}
+
+	rmPeer(test.TestPeerID3, test.TestPeerID1)
+	peers, _ = cc.Peers()
+	if len(peers) != 4 { // peer 3 is seen
+		t.Fatal("untrusted peers should not remove peers")
+	}
+
+	rmPeer(test.TestPeerID2, test.TestPeerID1)
+	peers, _ = cc.Peers()
+	for _, p := range peers {
+		if p == test.TestPeerID1 {
+			t.Fatal("peer should have been removed")
+		}
+	}
+
+	// Messages from removed peers do not make them members
+	// again for a while.
+	c, _ := cid.Decode(test.TestCid1)
+	cc.handleMessage(test.TestPeerID1, testUpdate(test.TestPeerID1, testPin(c), false, 1))
+	peers, _ = cc.Peers()
+	for _, p := range peers {
+		if p == test.TestPeerID1 {
+			t.Fatal("removed peer should not be back yet")
+		}
+	}
+
+	err := cc.RmPeer(cc.host.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	peers, _ = cc.Peers()
+	for _, p := range peers {
+		if p == cc.host.ID() {
+			t.Fatal("removed peer should not list itself")
+		}
+	}
+}
+
+func TestConsensusLoadSave(t *testing.T) {
+	cc := testingConsensus(t, 1)
+	defer cleanCRDT(1)
+
+	c1, _ := cid.Decode(test.TestCid1)
+	c2, _ := cid.Decode(test.TestCid2)
+	cc.LogPin(testPin(c1))
+	cc.LogPin(testPin(c2))
+	cc.LogUnpin(testPin(c2))
+	shutdownConsensus(cc)
+
+	cc = testingConsensusWithHost(t, 1, makeTestingHost(t))
+	defer shutdownConsensus(cc)
+
+	st, _ := cc.State()
+	if !st.Has(c1) || st.Has(c2) {
+		t.Fatal("the state should match the saved pinset")
+	}
+	cc.mu.Lock()
+	n := len(cc.pinset.entries)
+	cc.mu.Unlock()
+	if n != 2 {
+		t.Error("the pinset should keep the tombstone")
+	}
+}
+
+func connectHosts(t *testing.T, h1, h2 host.Host) {
+	h1.Peerstore().AddAddrs(h2.ID(), h2.Addrs(), peerstore.PermanentAddrTTL)
+	err := h1.Connect(context.Background(), peerstore.PeerInfo{ID: h2.ID(), Addrs: h2.Addrs()})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConsensusTwoPeers(t *testing.T) {
+	cleanCRDT(1)
+	cleanCRDT(2)
+	defer cleanCRDT(1)
+	defer cleanCRDT(2)
+
+	// pinned before the second peer is around, so it needs to
+	// pull the pinset.
+	cc1 := testingConsensus(t, 1)
+	defer shutdownConsensus(cc1)
+	c1, _ := cid.Decode(test.TestCid1)
+	err := cc1.LogPin(testPin(c1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h2 := makeTestingHost(t)
+	connectHosts(t, h2, cc1.host)
+	cc2 := testingConsensusWithHost(t, 2, h2)
+	defer shutdownConsensus(cc2)
+
+	st1, _ := cc1.State()
+	st2, _ := cc2.State()
+	waitFor(t, "the pinset to be pulled", func() bool {
+		return st2.Has(c1)
+	})
+
+	// updates are sent to the known peers
+	c2, _ := cid.Decode(test.TestCid2)
+	err = cc2.LogPin(testPin(c2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cc1.LogUnpin(testPin(c1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the pinsets to converge", func() bool {
+		return st1.Has(c2) && !st2.Has(c1)
+	})
+
+	waitFor(t, "the peers to know each other", func() bool {
+		peers, _ := cc1.Peers()
+		return len(peers) == 2
+	})
+	cc1.mu.Lock()
+	hash1 := cc1.pinset.hash
+	cc1.mu.Unlock()
+	cc2.mu.Lock()
+	hash2 := cc2.pinset.hash
+	cc2.mu.Unlock()
+	if hash1 != hash2 {
+		t.Error("both peers should have the same pinset")
+	}
+
+	// Updates published on PubSub are ignored, as anyone can
+	// forge their sender.
+	c3, _ := cid.Decode(test.TestCid3)
+	err = cc2.publish(testUpdate(cc2.host.ID(), testPin(c3), false, 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	if st1.Has(c3) {
+		t.Error("updates received over PubSub should be ignored")
+	}
+}
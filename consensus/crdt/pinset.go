@@ -0,0 +1,175 @@
+package crdt
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"time"
+
+	"github.com/ipfs/ipfs-cluster/api"
+)
+
+// entry is the value held for a Cid in the pinset. The pinset is a
+// last-writer-wins map: among two entries for the same Cid, the one with
+// the highest (Clock, Peer) pair wins. Unpins are recorded as entries with
+// Deleted set (tombstones) so that they can win over older pins.
+// Timestamp is the time (in unix seconds) when the update was made, used
+// to expire tombstones.
+type entry struct {
+	Key       string
+	Pin       api.PinSerial
+	Deleted   bool
+	Clock     uint64
+	Peer      string
+	Timestamp int64
+}
+
+// configEntry is the last-writer-wins register holding the cluster-wide
+// configuration. A zero Clock means it was never set.
+type configEntry struct {
+	Config api.SharedConfigSerial
+	Clock  uint64
+	Peer   string
+}
+
+// newer returns true when an update made at (clock, peer) wins over
+// one made at (clock2, peer2).
+func newer(clock uint64, peer string, clock2 uint64, peer2 string) bool {
+	if clock != clock2 {
+		return clock > clock2
+	}
+	return peer > peer2
+}
+
+// pin returns the pin held by the entry as stored in the state: request
+// IDs and signatures are kept in the entry so that other peers can verify
+// the update, but they are not part of the shared state.
+func (e entry) pin() api.Pin {
+	return e.Pin.ToPin().StripRequest()
+}
+
+func (e entry) digest() uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(e.Key))
+	h.Write([]byte(e.Peer))
+	var b [9]byte
+	binary.BigEndian.PutUint64(b[:8], e.Clock)
+	if e.Deleted {
+		b[8] = 1
+	}
+	h.Write(b[:])
+	return h.Sum64()
+}
+
+func (c configEntry) digest() uint64 {
+	if c.Clock == 0 {
+		return 0
+	}
+	return entry{Key: "/config", Clock: c.Clock, Peer: c.Peer}.digest()
+}
+
+// pinset is a state-based CRDT: merging is commutative, associative and
+// idempotent, so peers which have received the same updates, in any
+// order and any number of times, hold the same pinset. It is not
+// thread-safe.
+//
+// Tombstones are dropped once they are older than tombstoneExpiry (when
+// not 0), and expired tombstones for Cids which are not in the pinset
+// are not merged, so that peers drop them at about the same time.
+// A peer which missed an unpin for longer than that may bring the pin
+// back.
+type pinset struct {
+	clock           uint64 // lamport clock
+	entries         map[string]entry
+	config          configEntry
+	tombstoneExpiry time.Duration
+
+	// hash summarizes the pinset in an order-independent way (xor
+	// of the digests of every entry), so that two peers can compare
+	// their pinsets cheaply.
+	hash uint64
+}
+
+func newPinset(tombstoneExpiry time.Duration) *pinset {
+	return &pinset{
+		entries:         make(map[string]entry),
+		tombstoneExpiry: tombstoneExpiry,
+	}
+}
+
+// tick advances the clock for a local update and returns it.
+func (ps *pinset) tick() uint64 {
+	ps.clock++
+	return ps.clock
+}
+
+// witness advances the clock past one seen in an update from
+// another peer.
+func (ps *pinset) witness(clock uint64) {
+	if clock > ps.clock {
+		ps.clock = clock
+	}
+}
+
+// merge adds an entry to the pinset, unless the one already held for
+// the same Cid is newer. It returns the previous entry, if any, and
+// whether the given one was applied.
+func (ps *pinset) merge(e entry) (prev entry, existed bool, applied bool) {
+	ps.witness(e.Clock)
+	prev, existed = ps.entries[e.Key]
+	if existed && !newer(e.Clock, e.Peer, prev.Clock, prev.Peer) {
+		return prev, existed, false
+	}
+	if !existed && ps.expired(e, time.Now()) {
+		return prev, existed, false
+	}
+	if existed {
+		ps.hash ^= prev.digest()
+	}
+	ps.entries[e.Key] = e
+	ps.hash ^= e.digest()
+	return prev, existed, true
+}
+
+// mergeConfig sets the cluster-wide configuration, unless the one
+// already held is newer. It returns whether it was applied.
+func (ps *pinset) mergeConfig(c configEntry) bool {
+	ps.witness(c.Clock)
+	if !newer(c.Clock, c.Peer, ps.config.Clock, ps.config.Peer) {
+		return false
+	}
+	ps.hash ^= ps.config.digest()
+	ps.config = c
+	ps.hash ^= c.digest()
+	return true
+}
+
+// expired returns true for tombstones older than tombstoneExpiry.
+func (ps *pinset) expired(e entry, now time.Time) bool {
+	if !e.Deleted || ps.tombstoneExpiry == 0 {
+		return false
+	}
+	return now.Sub(time.Unix(e.Timestamp, 0)) > ps.tombstoneExpiry
+}
+
+// expireTombstones removes the expired tombstones from the pinset and
+// returns how many were removed.
+func (ps *pinset) expireTombstones(now time.Time) int {
+	n := 0
+	for k, e := range ps.entries {
+		if ps.expired(e, now) {
+			delete(ps.entries, k)
+			ps.hash ^= e.digest()
+			n++
+		}
+	}
+	return n
+}
+
+// list returns all the entries in the pinset, tombstones included.
+func (ps *pinset) list() []entry {
+	entries := make([]entry, 0, len(ps.entries))
+	for _, e := range ps.entries {
+		entries = append(entries, e)
+	}
+	return entries
+}
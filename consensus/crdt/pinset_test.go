@@ -0,0 +1,126 @@
+package crdt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/test"
+)
+
+func testEntry(key string, deleted bool, clock uint64, peer string) entry {
+	return entry{
+		Key:     key,
+		Pin:     api.PinSerial{Cid: key},
+		Deleted: deleted,
+		Clock:   clock,
+		Peer:    peer,
+	}
+}
+
+func TestPinsetMergeOrder(t *testing.T) {
+	entries := []entry{
+		testEntry(test.TestCid1, false, 1, "a"),
+		testEntry(test.TestCid1, true, 2, "b"),
+		testEntry(test.TestCid2, false, 3, "a"),
+		testEntry(test.TestCid2, true, 3, "b"),
+		testEntry(test.TestCid3, true, 1, "a"),
+		testEntry(test.TestCid3, false, 4, "a"),
+	}
+
+	ps1 := newPinset(0)
+	for _, e := range entries {
+		ps1.merge(e)
+	}
+
+	ps2 := newPinset(0)
+	for i := len(entries) - 1; i >= 0; i-- {
+		ps2.merge(entries[i])
+		ps2.merge(entries[i]) // idempotent
+	}
+
+	if ps1.hash != ps2.hash {
+		t.Fatal("pinsets should have the same hash")
+	}
+	if ps1.clock != 4 || ps2.clock != 4 {
+		t.Error("clocks should have been advanced")
+	}
+
+	for key, e := range ps1.entries {
+		e2 := ps2.entries[key]
+		if e.Clock != e2.Clock || e.Peer != e2.Peer || e.Deleted != e2.Deleted {
+			t.Errorf("%s: entries differ: %+v, %+v", key, e, e2)
+		}
+	}
+
+	if !ps1.entries[test.TestCid1].Deleted {
+		t.Error("newer unpin should win")
+	}
+	if !ps1.entries[test.TestCid2].Deleted {
+		t.Error("higher peer should win on equal clocks")
+	}
+	if ps1.entries[test.TestCid3].Deleted {
+		t.Error("newer pin should win")
+	}
+}
+
+func TestPinsetMergeConfig(t *testing.T) {
+	ps := newPinset(0)
+	empty := ps.hash
+	c1 := configEntry{Config: api.SharedConfigSerial{Allocator: "ascend"}, Clock: 2, Peer: "a"}
+	c2 := configEntry{Config: api.SharedConfigSerial{Allocator: "descend"}, Clock: 1, Peer: "b"}
+	if !ps.mergeConfig(c1) {
+		t.Fatal("config should have been applied")
+	}
+	if ps.mergeConfig(c2) {
+		t.Error("older config should not be applied")
+	}
+	if ps.config.Config.Allocator != "ascend" {
+		t.Error("wrong config")
+	}
+	if ps.hash == empty {
+		t.Error("hash should have changed")
+	}
+}
+
+func TestPinsetExpireTombstones(t *testing.T) {
+	ps := newPinset(time.Hour)
+	now := time.Now()
+	empty := ps.hash
+
+	old := testEntry(test.TestCid1, true, 1, "a")
+	old.Timestamp = now.Add(-2 * time.Hour).Unix()
+	_, _, applied := ps.merge(old)
+	if applied {
+		t.Error("expired tombstones for unknown Cids should not be merged")
+	}
+
+	pin := testEntry(test.TestCid1, false, 1, "a")
+	ps.merge(pin)
+	tomb := testEntry(test.TestCid1, true, 2, "a")
+	tomb.Timestamp = now.Add(-2 * time.Hour).Unix()
+	_, _, applied = ps.merge(tomb)
+	if !applied {
+		t.Fatal("expired tombstones should still remove existing pins")
+	}
+
+	recent := testEntry(test.TestCid2, true, 3, "a")
+	recent.Timestamp = now.Unix()
+	ps.merge(recent)
+
+	n := ps.expireTombstones(now)
+	if n != 1 {
+		t.Fatalf("expected 1 expired tombstone, got %d", n)
+	}
+	if _, ok := ps.entries[test.TestCid1]; ok {
+		t.Error("expired tombstone should have been removed")
+	}
+	if _, ok := ps.entries[test.TestCid2]; !ok {
+		t.Error("recent tombstone should be kept")
+	}
+
+	ps.expireTombstones(now.Add(2 * time.Hour))
+	if len(ps.entries) != 0 || ps.hash != empty {
+		t.Error("pinset should be empty and have the empty hash")
+	}
+}
@@ -0,0 +1,226 @@
+package raft
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	hraft "github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb"
+)
+
+// Keys used by Raft in the stable store (see hashicorp/raft).
+var raftStableKeys = [][]byte{
+	[]byte("CurrentTerm"),
+	[]byte("LastVoteTerm"),
+	[]byte("LastVoteCand"),
+}
+
+// How many log entries are copied at once when compacting.
+var compactBatchSize = 256
+
+// Compact validates and compacts the Raft data folder of a stopped
+// peer. It checks that the log store can be read and that there is a
+// valid snapshot, and rewrites the log store keeping only the entries
+// after the latest valid snapshot (plus Config.RaftConfig.TrailingLogs)
+// so that the disk space used by older entries is reclaimed.
+//
+// When prune is set, it also removes every snapshot other than the
+// latest valid one, along with the backups of the data folder (see
+// Config.BackupsRotate).
+func Compact(cfg *Config, prune bool) error {
+	df := cfg.GetDataFolder()
+	if _, err := os.Stat(df); os.IsNotExist(err) {
+		return fmt.Errorf("the Raft data folder (%s) does not exist", df)
+	}
+
+	key, err := cfg.GetEncryptionKey()
+	if err != nil {
+		return err
+	}
+	meta, _, err := latestValidSnapshot(df, key)
+	if err != nil {
+		return err
+	}
+
+	var upTo uint64
+	if meta == nil {
+		logger.Warning("no valid snapshots found: all log entries will be kept")
+	} else {
+		logger.Infof("latest valid snapshot: %s (index %d)", meta.ID, meta.Index)
+		upTo = meta.Index
+		if cfg.RaftConfig != nil {
+			if upTo > cfg.RaftConfig.TrailingLogs {
+				upTo -= cfg.RaftConfig.TrailingLogs
+			} else {
+				upTo = 0
+			}
+		}
+	}
+
+	err = compactLogStore(filepath.Join(df, "raft.db"), upTo)
+	if err != nil {
+		return err
+	}
+
+	if !prune {
+		return nil
+	}
+	if meta != nil {
+		err = pruneSnapshots(df, meta.ID)
+		if err != nil {
+			return err
+		}
+	}
+	dbh := newDataBackupHelper(df, cfg.BackupsRotate)
+	for _, b := range dbh.listBackups() {
+		logger.Infof("removing data folder backup %s", b)
+		err = os.RemoveAll(b)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compactLogStore rewrites the BoltDB store at the given path, copying
+// the stable store values and the log entries with an index above upTo.
+// Entries are copied as stored, so encrypted entries stay encrypted.
+func compactLogStore(path string, upTo uint64) error {
+	before, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	old, err := raftboltdb.NewBoltStore(path)
+	if err != nil {
+		return fmt.Errorf("the Raft log store is not readable: %s", err)
+	}
+	defer old.Close()
+
+	first, err := old.FirstIndex()
+	if err != nil {
+		return err
+	}
+	last, err := old.LastIndex()
+	if err != nil {
+		return err
+	}
+
+	tmpPath := path + ".compact"
+	os.Remove(tmpPath)
+	compacted, err := raftboltdb.NewBoltStore(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	err = copyLogStore(old, compacted, first, last, upTo)
+	compacted.Close()
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	old.Close()
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		return err
+	}
+
+	after, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	logger.Infof(
+		"Raft log store compacted: %d bytes -> %d bytes",
+		before.Size(),
+		after.Size(),
+	)
+	return nil
+}
+
+func copyLogStore(src, dst *raftboltdb.BoltStore, first, last, upTo uint64) error {
+	for _, k := range raftStableKeys {
+		v, err := src.Get(k)
+		if err != nil {
+			continue // not set
+		}
+		err = dst.Set(k, v)
+		if err != nil {
+			return err
+		}
+	}
+
+	if last == 0 { // empty log
+		return nil
+	}
+	start := first
+	if upTo >= start {
+		start = upTo + 1
+	}
+
+	batch := make([]*hraft.Log, 0, compactBatchSize)
+	for i := start; i <= last; i++ {
+		entry := &hraft.Log{}
+		err := src.GetLog(i, entry)
+		if err != nil {
+			return fmt.Errorf("reading log entry %d: %s", i, err)
+		}
+		batch = append(batch, entry)
+		if len(batch) == compactBatchSize {
+			err = dst.StoreLogs(batch)
+			if err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	if len(batch) > 0 {
+		err := dst.StoreLogs(batch)
+		if err != nil {
+			return err
+		}
+	}
+
+	if start > last {
+		logger.Infof("no log entries after index %d", upTo)
+	} else {
+		logger.Infof("kept log entries %d to %d (out of %d to %d)", start, last, first, last)
+	}
+	return nil
+}
+
+// pruneSnapshots removes everything in the snapshots folder apart from
+// the snapshot with the given ID: older snapshots, along with corrupted
+// and unfinished ones.
+func pruneSnapshots(dataFolder, keepID string) error {
+	snapFolder := filepath.Join(dataFolder, "snapshots")
+	entries, err := ioutil.ReadDir(snapFolder)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for _, e := range entries {
+		if e.Name() == keepID {
+			found = true
+		}
+	}
+	if !found {
+		return errors.New("the snapshot to keep was not found")
+	}
+
+	for _, e := range entries {
+		if e.Name() == keepID {
+			continue
+		}
+		logger.Infof("removing snapshot %s", e.Name())
+		err := os.RemoveAll(filepath.Join(snapFolder, e.Name()))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,10 +1,14 @@
 package raft
 
 import (
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
+	"os/exec"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/ipfs/ipfs-cluster/api"
@@ -26,8 +30,16 @@ var (
 	DefaultNetworkTimeout       = 10 * time.Second
 	DefaultCommitRetryDelay     = 200 * time.Millisecond
 	DefaultBackupsRotate        = 6
+	DefaultRestartBackoff       = 1 * time.Second
+	DefaultMaxRestartBackoff    = 1 * time.Minute
+	DefaultQuorumLossTimeout    = 20 * time.Second
 )
 
+// MinSnapshotTransferLimit is the lowest SnapshotTransferLimit allowed
+// (64KiB/s). Slower transfers would take too long for peers to ever
+// catch up with the log.
+const MinSnapshotTransferLimit = 64 * 1024
+
 // Config allows to configure the Raft Consensus component for ipfs-cluster.
 // The component's configuration section is represented by ConfigJSON.
 // Config implements the ComponentConfig interface.
@@ -58,6 +70,43 @@ type Config struct {
 	// BackupsRotate specifies the maximum number of Raft's DataFolder
 	// copies that we keep as backups (renaming) after cleanup.
 	BackupsRotate int
+	// RestartBackoff is how long we wait before retrying a failed
+	// attempt to restart Raft after it stopped unexpectedly. The wait
+	// doubles on every attempt, up to MaxRestartBackoff.
+	RestartBackoff time.Duration
+	// MaxRestartBackoff is the maximum wait between attempts to restart
+	// Raft.
+	MaxRestartBackoff time.Duration
+	// QuorumLossTimeout is how long we can go without a known leader
+	// before considering that quorum has been lost. In that case, the
+	// peer enters read-only mode and operations modifying the shared
+	// state fail immediately with ErrNoQuorum.
+	QuorumLossTimeout time.Duration
+	// CleanCorruptedState allows the peer to start with a clean state
+	// when the Raft data folder cannot be read and there is no valid
+	// snapshot to recover from. The corrupted folder is backed up. This
+	// option is not saved in the configuration file and must be set
+	// explicitly by the operator (ipfs-cluster-service daemon
+	// --clean-corrupted-state).
+	CleanCorruptedState bool
+	// EncryptionKey, when set, is used to encrypt the Raft log entries
+	// and the state snapshots stored in the data folder (AES-256-GCM).
+	// It must be 32 bytes long. Enabling or disabling encryption on a
+	// peer with existing state requires exporting the state before and
+	// importing it after the change.
+	EncryptionKey []byte
+	// EncryptionKeyCommand is a shell command which prints the
+	// hex-encoded EncryptionKey on its standard output. It allows to
+	// obtain the key from an external key management service so that
+	// it is not stored along with the data. It is run every time the
+	// key is needed and takes precedence over EncryptionKey.
+	EncryptionKeyCommand string
+	// SnapshotTransferLimit is the maximum number of bytes per second
+	// used to send snapshots to peers which are too far behind the
+	// log. It keeps snapshot transfers from starving heartbeats, which
+	// causes leadership to flap. 0 means no limit. Otherwise, it must
+	// be at least MinSnapshotTransferLimit.
+	SnapshotTransferLimit uint64
 
 	// A Hashicorp Raft's configuration object.
 	RaftConfig *hraft.Config
@@ -95,6 +144,22 @@ type jsonConfig struct {
 	// copies that we keep as backups (renaming) after cleanup.
 	BackupsRotate int `json:"backups_rotate"`
 
+	// How long to wait before retrying to restart a failed Raft,
+	// doubled on every attempt up to MaxRestartBackoff
+	RestartBackoff    string `json:"restart_backoff"`
+	MaxRestartBackoff string `json:"max_restart_backoff"`
+
+	// How long without a leader before entering read-only mode
+	QuorumLossTimeout string `json:"quorum_loss_timeout"`
+
+	// Hex-encoded key to encrypt the data folder, or a command
+	// printing it.
+	EncryptionKey        string `json:"encryption_key,omitempty"`
+	EncryptionKeyCommand string `json:"encryption_key_command,omitempty"`
+
+	// Bytes per second to send snapshots to other peers
+	SnapshotTransferLimit uint64 `json:"snapshot_transfer_limit"`
+
 	// HeartbeatTimeout specifies the time in follower state without
 	// a leader before we attempt an election.
 	HeartbeatTimeout string `json:"heartbeat_timeout,omitempty"`
@@ -165,6 +230,26 @@ func (cfg *Config) Validate() error {
 		return errors.New("backups_rotate should be larger than 0")
 	}
 
+	if cfg.RestartBackoff <= 0 {
+		return errors.New("restart_backoff is invalid")
+	}
+
+	if cfg.MaxRestartBackoff < cfg.RestartBackoff {
+		return errors.New("max_restart_backoff should be larger than restart_backoff")
+	}
+
+	if cfg.QuorumLossTimeout <= 0 {
+		return errors.New("quorum_loss_timeout is invalid")
+	}
+
+	if l := cfg.SnapshotTransferLimit; l != 0 && l < MinSnapshotTransferLimit {
+		return fmt.Errorf("snapshot_transfer_limit should be 0 or at least %d", MinSnapshotTransferLimit)
+	}
+
+	if l := len(cfg.EncryptionKey); l != 0 && l != EncryptionKeySize {
+		return fmt.Errorf("encryption_key should be %d bytes long", EncryptionKeySize)
+	}
+
 	return hraft.ValidateConfig(cfg.RaftConfig)
 }
 
@@ -193,6 +278,9 @@ func (cfg *Config) LoadJSON(raw []byte) error {
 	waitForLeaderTimeout := parseDuration(jcfg.WaitForLeaderTimeout)
 	networkTimeout := parseDuration(jcfg.NetworkTimeout)
 	commitRetryDelay := parseDuration(jcfg.CommitRetryDelay)
+	restartBackoff := parseDuration(jcfg.RestartBackoff)
+	maxRestartBackoff := parseDuration(jcfg.MaxRestartBackoff)
+	quorumLossTimeout := parseDuration(jcfg.QuorumLossTimeout)
 	heartbeatTimeout := parseDuration(jcfg.HeartbeatTimeout)
 	electionTimeout := parseDuration(jcfg.ElectionTimeout)
 	commitTimeout := parseDuration(jcfg.CommitTimeout)
@@ -209,6 +297,9 @@ func (cfg *Config) LoadJSON(raw []byte) error {
 	cfg.CommitRetries = jcfg.CommitRetries
 	config.SetIfNotDefault(commitRetryDelay, &cfg.CommitRetryDelay)
 	config.SetIfNotDefault(jcfg.BackupsRotate, &cfg.BackupsRotate)
+	config.SetIfNotDefault(restartBackoff, &cfg.RestartBackoff)
+	config.SetIfNotDefault(maxRestartBackoff, &cfg.MaxRestartBackoff)
+	config.SetIfNotDefault(quorumLossTimeout, &cfg.QuorumLossTimeout)
 
 	// Raft values
 	config.SetIfNotDefault(heartbeatTimeout, &cfg.RaftConfig.HeartbeatTimeout)
@@ -221,6 +312,17 @@ func (cfg *Config) LoadJSON(raw []byte) error {
 	config.SetIfNotDefault(leaderLeaseTimeout, &cfg.RaftConfig.LeaderLeaseTimeout)
 
 	cfg.InitPeerset = api.StringsToPeers(jcfg.InitPeerset)
+
+	if jcfg.EncryptionKey != "" {
+		key, err := hex.DecodeString(jcfg.EncryptionKey)
+		if err != nil {
+			return fmt.Errorf("error decoding encryption_key: %s", err)
+		}
+		cfg.EncryptionKey = key
+	}
+	cfg.EncryptionKeyCommand = jcfg.EncryptionKeyCommand
+	cfg.SnapshotTransferLimit = jcfg.SnapshotTransferLimit
+
 	return cfg.Validate()
 }
 
@@ -234,6 +336,9 @@ func (cfg *Config) ToJSON() ([]byte, error) {
 		CommitRetries:        cfg.CommitRetries,
 		CommitRetryDelay:     cfg.CommitRetryDelay.String(),
 		BackupsRotate:        cfg.BackupsRotate,
+		RestartBackoff:       cfg.RestartBackoff.String(),
+		MaxRestartBackoff:    cfg.MaxRestartBackoff.String(),
+		QuorumLossTimeout:    cfg.QuorumLossTimeout.String(),
 		HeartbeatTimeout:     cfg.RaftConfig.HeartbeatTimeout.String(),
 		ElectionTimeout:      cfg.RaftConfig.ElectionTimeout.String(),
 		CommitTimeout:        cfg.RaftConfig.CommitTimeout.String(),
@@ -242,6 +347,12 @@ func (cfg *Config) ToJSON() ([]byte, error) {
 		SnapshotInterval:     cfg.RaftConfig.SnapshotInterval.String(),
 		SnapshotThreshold:    cfg.RaftConfig.SnapshotThreshold,
 		LeaderLeaseTimeout:   cfg.RaftConfig.LeaderLeaseTimeout.String(),
+		EncryptionKeyCommand: cfg.EncryptionKeyCommand,
+	}
+	jcfg.SnapshotTransferLimit = cfg.SnapshotTransferLimit
+
+	if len(cfg.EncryptionKey) > 0 {
+		jcfg.EncryptionKey = hex.EncodeToString(cfg.EncryptionKey)
 	}
 
 	return config.DefaultJSONMarshal(jcfg)
@@ -256,6 +367,12 @@ func (cfg *Config) Default() error {
 	cfg.CommitRetries = DefaultCommitRetries
 	cfg.CommitRetryDelay = DefaultCommitRetryDelay
 	cfg.BackupsRotate = DefaultBackupsRotate
+	cfg.RestartBackoff = DefaultRestartBackoff
+	cfg.MaxRestartBackoff = DefaultMaxRestartBackoff
+	cfg.QuorumLossTimeout = DefaultQuorumLossTimeout
+	cfg.EncryptionKey = nil
+	cfg.EncryptionKeyCommand = ""
+	cfg.SnapshotTransferLimit = 0
 	cfg.RaftConfig = hraft.DefaultConfig()
 
 	// These options are imposed over any Default Raft Config.
@@ -275,3 +392,25 @@ func (cfg *Config) GetDataFolder() string {
 	}
 	return cfg.DataFolder
 }
+
+// GetEncryptionKey returns the key used to encrypt the Raft data folder,
+// running EncryptionKeyCommand when set. It returns nil when encryption
+// is disabled.
+func (cfg *Config) GetEncryptionKey() ([]byte, error) {
+	if cfg.EncryptionKeyCommand == "" {
+		return cfg.EncryptionKey, nil
+	}
+
+	out, err := exec.Command("sh", "-c", cfg.EncryptionKeyCommand).Output()
+	if err != nil {
+		return nil, fmt.Errorf("error running encryption_key_command: %s", err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(out)))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding key from encryption_key_command: %s", err)
+	}
+	if len(key) != EncryptionKeySize {
+		return nil, fmt.Errorf("encryption_key_command should provide a %d bytes key", EncryptionKeySize)
+	}
+	return key, nil
+}
@@ -15,6 +15,9 @@ var cfgJSON = []byte(`
     "commit_retries": 1,
     "commit_retry_delay": "200ms",
     "backups_rotate": 5,
+    "restart_backoff": "2s",
+    "max_restart_backoff": "30s",
+    "quorum_loss_timeout": "20s",
     "heartbeat_timeout": "1s",
     "election_timeout": "1s",
     "commit_timeout": "50ms",
@@ -22,7 +25,8 @@ var cfgJSON = []byte(`
     "trailing_logs": 10240,
     "snapshot_interval": "2m0s",
     "snapshot_threshold": 8192,
-    "leader_lease_timeout": "500ms"
+    "leader_lease_timeout": "500ms",
+    "snapshot_transfer_limit": 1048576
 }
 `)
 
@@ -32,6 +36,9 @@ func TestLoadJSON(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	if cfg.SnapshotTransferLimit != 1048576 {
+		t.Error("snapshot_transfer_limit not preserved")
+	}
 
 	j := &jsonConfig{}
 	json.Unmarshal(cfgJSON, j)
@@ -55,6 +62,40 @@ func TestLoadJSON(t *testing.T) {
 	}
 }
 
+func TestLoadJSONEncryptionKey(t *testing.T) {
+	cfg := &Config{}
+	j := &jsonConfig{}
+	json.Unmarshal(cfgJSON, j)
+	j.EncryptionKey = "abcd"
+	tst, _ := json.Marshal(j)
+	err := cfg.LoadJSON(tst)
+	if err == nil {
+		t.Error("expected error with a short encryption_key")
+	}
+
+	j.EncryptionKey = "zz"
+	tst, _ = json.Marshal(j)
+	err = cfg.LoadJSON(tst)
+	if err == nil {
+		t.Error("expected error decoding encryption_key")
+	}
+
+	j.EncryptionKey = ""
+	j.EncryptionKeyCommand = "echo 4242424242424242424242424242424242424242424242424242424242424242"
+	tst, _ = json.Marshal(j)
+	err = cfg.LoadJSON(tst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := cfg.GetEncryptionKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(key) != EncryptionKeySize || key[0] != 0x42 {
+		t.Error("unexpected key from encryption_key_command")
+	}
+}
+
 func TestToJSON(t *testing.T) {
 	cfg := &Config{}
 	cfg.LoadJSON(cfgJSON)
@@ -105,4 +146,22 @@ func TestDefault(t *testing.T) {
 	if cfg.Validate() == nil {
 		t.Fatal("expected error validating")
 	}
+
+	cfg.Default()
+	cfg.MaxRestartBackoff = cfg.RestartBackoff / 2
+	if cfg.Validate() == nil {
+		t.Fatal("expected error validating")
+	}
+
+	cfg.Default()
+	cfg.QuorumLossTimeout = 0
+	if cfg.Validate() == nil {
+		t.Fatal("expected error validating")
+	}
+
+	cfg.Default()
+	cfg.SnapshotTransferLimit = 1000
+	if cfg.Validate() == nil {
+		t.Fatal("expected error validating")
+	}
 }
@@ -11,12 +11,15 @@ import (
 	"time"
 
 	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/logutil"
 	"github.com/ipfs/ipfs-cluster/state"
 
+	hraft "github.com/hashicorp/raft"
 	rpc "github.com/hsanjuan/go-libp2p-gorpc"
 	logging "github.com/ipfs/go-log"
 	consensus "github.com/libp2p/go-libp2p-consensus"
 	host "github.com/libp2p/go-libp2p-host"
+	inet "github.com/libp2p/go-libp2p-net"
 	peer "github.com/libp2p/go-libp2p-peer"
 	libp2praft "github.com/libp2p/go-libp2p-raft"
 	ma "github.com/multiformats/go-multiaddr"
@@ -24,6 +27,13 @@ import (
 
 var logger = logging.Logger("consensus")
 
+// ErrNoQuorum is returned by operations which modify the shared state or
+// the peerset while the consensus has lost quorum (see QuorumLossTimeout).
+var ErrNoQuorum = errors.New("no quorum: a majority of cluster peers cannot be reached. The cluster is in read-only mode")
+
+// How often we check whether a leader is known
+var quorumCheckInterval = time.Second
+
 // Consensus handles the work of keeping a shared-state between
 // the peers of an IPFS Cluster, as well as modifying that state and
 // applying any updates in a thread-safe manner.
@@ -32,12 +42,35 @@ type Consensus struct {
 	cancel func()
 	config *Config
 
-	host host.Host
+	host   host.Host
+	logger *logutil.Logger
 
-	consensus consensus.OpLogConsensus
-	actor     consensus.Actor
+	consensus *libp2praft.Consensus
 	baseOp    *LogOp
-	raft      *raftWrapper
+
+	// raft and actor are replaced when restarting after a failure
+	raftMux    sync.RWMutex
+	actor      consensus.Actor
+	raft       *raftWrapper
+	restarting bool
+
+	quorumMux sync.RWMutex
+	noQuorum  bool
+
+	// see SetPeerFilter. filteredWarned holds the filtered peers in
+	// the peerset which have been reported already.
+	filterMux      sync.RWMutex
+	acceptPeer     func(peer.ID) bool
+	filteredWarned map[string]struct{}
+
+	metrics raftMetrics
+
+	// changes applied to the state, see PinsetChanges
+	changes *state.ChangeLog
+
+	// applyMux is held while operations are applied to the state
+	// and while the state is repaired (see RepairState)
+	applyMux sync.Mutex
 
 	rpcClient *rpc.Client
 	rpcReady  chan struct{}
@@ -55,7 +88,7 @@ type Consensus struct {
 func NewConsensus(
 	host host.Host,
 	cfg *Config,
-	state state.State,
+	st state.State,
 	staging bool, // this peer must not be bootstrapped if no state exists
 ) (*Consensus, error) {
 	err := cfg.Validate()
@@ -64,12 +97,14 @@ func NewConsensus(
 	}
 
 	baseOp := &LogOp{}
+	log := logutil.New(logger)
+	log.SetPrefix(logutil.PeerPrefix(host.ID()))
 
-	logger.Debug("starting Consensus and waiting for a leader...")
-	consensus := libp2praft.NewOpLog(state, baseOp)
-	raft, err := newRaftWrapper(host, cfg, consensus.FSM(), staging)
+	log.Debug("starting Consensus and waiting for a leader...")
+	consensus := libp2praft.NewOpLog(st, baseOp)
+	raft, err := newRaftWrapper(host, cfg, consensus.FSM(), staging, log)
 	if err != nil {
-		logger.Error("error creating raft: ", err)
+		log.Error("error creating raft: ", err)
 		return nil, err
 	}
 	actor := libp2praft.NewActor(raft.raft)
@@ -82,10 +117,12 @@ func NewConsensus(
 		cancel:    cancel,
 		config:    cfg,
 		host:      host,
+		logger:    log,
 		consensus: consensus,
 		actor:     actor,
 		baseOp:    baseOp,
 		raft:      raft,
+		changes:   state.NewChangeLog(0),
 		rpcReady:  make(chan struct{}, 1),
 		readyCh:   make(chan struct{}, 1),
 	}
@@ -96,6 +133,11 @@ func NewConsensus(
 	return cc, nil
 }
 
+// SetLogPrefix sets the prefix of the messages logged by this component.
+func (cc *Consensus) SetLogPrefix(prefix string) {
+	cc.logger.SetPrefix(prefix)
+}
+
 // WaitForSync waits for a leader and for the state to be up to date, then returns.
 func (cc *Consensus) WaitForSync() error {
 	leaderCtx, cancel := context.WithTimeout(
@@ -117,17 +159,17 @@ func (cc *Consensus) WaitForSync() error {
 	// up to date state. Otherwise, we might return too early (see
 	// https://github.com/ipfs/ipfs-cluster/issues/378)
 
-	_, err := cc.raft.WaitForLeader(leaderCtx)
+	_, err := cc.getRaft().WaitForLeader(leaderCtx)
 	if err != nil {
 		return errors.New("error waiting for leader: " + err.Error())
 	}
 
-	err = cc.raft.WaitForVoter(cc.ctx)
+	err = cc.getRaft().WaitForVoter(cc.ctx)
 	if err != nil {
 		return errors.New("error waiting to become a Voter: " + err.Error())
 	}
 
-	err = cc.raft.WaitForUpdates(cc.ctx)
+	err = cc.getRaft().WaitForUpdates(cc.ctx)
 	if err != nil {
 		return errors.New("error waiting for consensus updates: " + err.Error())
 	}
@@ -149,7 +191,7 @@ func (cc *Consensus) finishBootstrap() {
 
 	// Sometimes bootstrap is a no-op. It only applies when
 	// no state exists and staging=false.
-	_, err := cc.raft.Bootstrap()
+	_, err := cc.getRaft().Bootstrap()
 	if err != nil {
 		return
 	}
@@ -158,29 +200,179 @@ func (cc *Consensus) finishBootstrap() {
 	if err != nil {
 		return
 	}
-	logger.Debug("Raft state is now up to date")
-	logger.Debug("consensus ready")
+	cc.logger.Debug("Raft state is now up to date")
+	cc.logger.Debug("consensus ready")
 	cc.readyCh <- struct{}{}
+
+	go cc.supervise()
+	go cc.watchQuorum()
+}
+
+// getRaft returns the raftWrapper currently in use.
+func (cc *Consensus) getRaft() *raftWrapper {
+	cc.raftMux.RLock()
+	defer cc.raftMux.RUnlock()
+	return cc.raft
+}
+
+// supervise watches Raft and restarts it, with an exponential backoff,
+// when it stops without the component having been shutdown, for example
+// due to an I/O error in the Raft stores. Other cluster subsystems keep
+// running meanwhile and Status() reports the consensus as restarting.
+func (cc *Consensus) supervise() {
+	ticker := time.NewTicker(superviseInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-cc.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if cc.getRaft().Alive() {
+			continue
+		}
+
+		cc.logger.Error("raft has stopped unexpectedly. Restarting consensus")
+		cc.raftMux.Lock()
+		cc.restarting = true
+		cc.raftMux.Unlock()
+
+		backoff := cc.config.RestartBackoff
+		for {
+			restarted, err := cc.restartRaft()
+			if err == nil {
+				if restarted {
+					cc.logger.Info("consensus restarted successfully")
+				}
+				break
+			}
+			cc.logger.Errorf("restarting consensus: %s. Retrying in %s", err, backoff)
+			select {
+			case <-cc.ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			if backoff > cc.config.MaxRestartBackoff {
+				backoff = cc.config.MaxRestartBackoff
+			}
+		}
+
+		cc.raftMux.Lock()
+		cc.restarting = false
+		cc.raftMux.Unlock()
+	}
+}
+
+// watchQuorum regularly checks whether Raft knows about a leader. When no
+// leader has been known for QuorumLossTimeout, quorum is considered lost:
+// an alert is logged and the peer switches to read-only mode until a
+// leader is elected again. Leader changes and snapshots are recorded
+// in the consensus metrics along the way, and filtered peers are removed
+// from the peerset (see SetPeerFilter).
+func (cc *Consensus) watchQuorum() {
+	ticker := time.NewTicker(quorumCheckInterval)
+	defer ticker.Stop()
+	lastLeader := time.Now()
+
+	for {
+		select {
+		case <-cc.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		leader := cc.getRaft().Leader()
+		cc.metrics.observeLeader(leader)
+		cc.enforcePeerFilter(leader)
+		_, _, snapshotIndex := cc.getRaft().LogIndexes()
+		cc.metrics.observeSnapshot(snapshotIndex)
+
+		if leader != "" {
+			lastLeader = time.Now()
+			if cc.hasNoQuorum() {
+				cc.logger.Info("consensus quorum recovered. Leaving read-only mode")
+				cc.setNoQuorum(false)
+			}
+			continue
+		}
+
+		if !cc.hasNoQuorum() && time.Since(lastLeader) > cc.config.QuorumLossTimeout {
+			cc.logger.Error("***** consensus quorum lost *****")
+			cc.logger.Errorf("No leader has been known for %s. A majority of cluster", cc.config.QuorumLossTimeout)
+			cc.logger.Error("peers cannot be reached. Entering read-only mode: status queries")
+			cc.logger.Error("and already pinned content keep working, but pinning, unpinning")
+			cc.logger.Error("and peerset changes will fail until quorum is recovered.")
+			cc.setNoQuorum(true)
+		}
+	}
+}
+
+func (cc *Consensus) hasNoQuorum() bool {
+	cc.quorumMux.RLock()
+	defer cc.quorumMux.RUnlock()
+	return cc.noQuorum
+}
+
+func (cc *Consensus) setNoQuorum(b bool) {
+	cc.quorumMux.Lock()
+	defer cc.quorumMux.Unlock()
+	cc.noQuorum = b
+}
+
+// restartRaft closes the current Raft instance and creates a new one,
+// re-using the same FSM (and shared state). It returns false when the
+// component is shutdown in the meantime.
+func (cc *Consensus) restartRaft() (bool, error) {
+	cc.shutdownLock.Lock()
+	defer cc.shutdownLock.Unlock()
+
+	if cc.shutdown {
+		return false, nil
+	}
+
+	if err := cc.getRaft().Close(); err != nil {
+		cc.logger.Warningf("closing failed raft: %s", err)
+	}
+
+	// We have state already, so this peer never needs to
+	// be bootstrapped as staging.
+	raft, err := newRaftWrapper(cc.host, cc.config, cc.consensus.FSM(), false, cc.logger)
+	if err != nil {
+		return false, err
+	}
+	actor := libp2praft.NewActor(raft.raft)
+
+	cc.raftMux.Lock()
+	cc.raft = raft
+	cc.actor = actor
+	cc.consensus.SetActor(actor)
+	cc.raftMux.Unlock()
+	return true, nil
 }
 
 // Shutdown stops the component so it will not process any
 // more updates. The underlying consensus is permanently
 // shutdown, along with the libp2p transport.
 func (cc *Consensus) Shutdown() error {
+	cc.handOverLeadership()
+
 	cc.shutdownLock.Lock()
 	defer cc.shutdownLock.Unlock()
 
 	if cc.shutdown {
-		logger.Debug("already shutdown")
+		cc.logger.Debug("already shutdown")
 		return nil
 	}
 
-	logger.Info("stopping Consensus component")
+	cc.logger.Info("stopping Consensus component")
 
 	// Raft Shutdown
-	err := cc.raft.Shutdown()
+	err := cc.getRaft().Shutdown()
 	if err != nil {
-		logger.Error(err)
+		cc.logger.Error(err)
 	}
 
 	if cc.config.hostShutdown {
@@ -193,6 +385,41 @@ func (cc *Consensus) Shutdown() error {
 	return nil
 }
 
+// handOverLeadership transfers the leadership to a connected voter when
+// this peer is the leader, so that the rest of the peers do not need to
+// wait for an election timeout before accepting new operations. Failures
+// are only logged, as the peers will elect a new leader anyways.
+func (cc *Consensus) handOverLeadership() {
+	cc.shutdownLock.Lock()
+	shutdown := cc.shutdown
+	cc.shutdownLock.Unlock()
+	if shutdown {
+		return
+	}
+
+	if leader, err := cc.Leader(); err != nil || leader != cc.host.ID() {
+		return
+	}
+
+	status, err := cc.Status()
+	if err != nil {
+		cc.logger.Error(err)
+		return
+	}
+	for _, p := range status.Voters {
+		if p == cc.host.ID() || cc.host.Network().Connectedness(p) != inet.Connected {
+			continue
+		}
+		cc.logger.Infof("this peer is the Raft leader and is shutting down. Handing leadership over to %s", p.Pretty())
+		err := cc.TransferLeadership(p)
+		if err != nil {
+			cc.logger.Warningf("could not hand leadership over to %s: %s", p.Pretty(), err)
+		}
+		return
+	}
+	cc.logger.Info("this peer is the Raft leader and is shutting down. A new leader will be elected")
+}
+
 // SetClient makes the component ready to perform RPC requets
 func (cc *Consensus) SetClient(c *rpc.Client) {
 	cc.rpcClient = c
@@ -218,19 +445,25 @@ func (cc *Consensus) op(pin api.Pin, t LogOpType) *LogOp {
 func (cc *Consensus) redirectToLeader(method string, arg interface{}) (bool, error) {
 	var finalErr error
 
+	// Fail fast rather than waiting for a leader which
+	// cannot be elected.
+	if cc.hasNoQuorum() {
+		return false, ErrNoQuorum
+	}
+
 	// Retry redirects
 	for i := 0; i <= cc.config.CommitRetries; i++ {
-		logger.Debugf("redirect try %d", i)
+		cc.logger.Debugf("redirect try %d", i)
 		leader, err := cc.Leader()
 
 		// No leader, wait for one
 		if err != nil {
-			logger.Warning("there seems to be no leader. Waiting for one")
+			cc.logger.Warning("there seems to be no leader. Waiting for one")
 			rctx, cancel := context.WithTimeout(
 				cc.ctx,
 				cc.config.WaitForLeaderTimeout)
 			defer cancel()
-			pidstr, err := cc.raft.WaitForLeader(rctx)
+			pidstr, err := cc.getRaft().WaitForLeader(rctx)
 
 			// means we timed out waiting for a leader
 			// we don't retry in this case
@@ -248,7 +481,7 @@ func (cc *Consensus) redirectToLeader(method string, arg interface{}) (bool, err
 			return false, nil
 		}
 
-		logger.Debugf("redirecting %s to leader: %s", method, leader.Pretty())
+		cc.logger.Debugf("redirecting %s to leader: %s", method, leader.Pretty())
 		finalErr = cc.rpcClient.Call(
 			leader,
 			"Cluster",
@@ -256,8 +489,8 @@ func (cc *Consensus) redirectToLeader(method string, arg interface{}) (bool, err
 			arg,
 			&struct{}{})
 		if finalErr != nil {
-			logger.Error(finalErr)
-			logger.Error("retrying to redirect request to leader")
+			cc.logger.Error(finalErr)
+			cc.logger.Error("retrying to redirect request to leader")
 			time.Sleep(2 * cc.config.RaftConfig.HeartbeatTimeout)
 			continue
 		}
@@ -272,11 +505,11 @@ func (cc *Consensus) redirectToLeader(method string, arg interface{}) (bool, err
 func (cc *Consensus) commit(op *LogOp, rpcOp string, redirectArg interface{}) error {
 	var finalErr error
 	for i := 0; i <= cc.config.CommitRetries; i++ {
-		logger.Debugf("attempt #%d: committing %+v", i, op)
+		cc.logger.Debugf("attempt #%d: committing %+v", i, op)
 
 		// this means we are retrying
 		if finalErr != nil {
-			logger.Errorf("retrying upon failed commit (retry %d): %s ",
+			cc.logger.Errorf("retrying upon failed commit (retry %d): %s ",
 				i, finalErr)
 		}
 
@@ -290,19 +523,30 @@ func (cc *Consensus) commit(op *LogOp, rpcOp string, redirectArg interface{}) er
 
 		// Being here means we are the LEADER. We can commit.
 
+		// Avoid growing the log with operations which do
+		// not change anything.
+		if cc.isNoOp(op) {
+			cc.logger.Debugf("skipping commit of no-op operation: %+v", op)
+			return nil
+		}
+
 		// now commit the changes to our state
 		cc.shutdownLock.Lock() // do not shut down while committing
+		start := time.Now()
 		_, finalErr = cc.consensus.CommitOp(op)
 		cc.shutdownLock.Unlock()
 		if finalErr != nil {
 			goto RETRY
 		}
+		cc.metrics.observeCommit(time.Since(start))
 
 		switch op.Type {
 		case LogOpPin:
-			logger.Infof("pin committed to global state: %s", op.Cid.Cid)
+			cc.logger.Infof("pin committed to global state: %s (request %s)", op.Cid.Cid, op.Cid.RequestID)
 		case LogOpUnpin:
-			logger.Infof("unpin committed to global state: %s", op.Cid.Cid)
+			cc.logger.Infof("unpin committed to global state: %s (request %s)", op.Cid.Cid, op.Cid.RequestID)
+		case LogOpSharedConfig:
+			cc.logger.Infof("shared configuration committed to global state: %+v", op.Config)
 		}
 		break
 
@@ -312,6 +556,30 @@ func (cc *Consensus) commit(op *LogOp, rpcOp string, redirectArg interface{}) er
 	return finalErr
 }
 
+// isNoOp returns true when applying the given operation would leave
+// the current state untouched: pinning something which is already pinned
+// with the same options or unpinning something which is not pinned.
+func (cc *Consensus) isNoOp(op *LogOp) bool {
+	st, err := cc.State()
+	if err != nil {
+		return false
+	}
+
+	switch op.Type {
+	case LogOpPin:
+		pin := op.Cid.ToPin()
+		return st.Has(pin.Cid) && st.Get(pin.Cid).Equals(pin)
+	case LogOpUnpin:
+		pin := op.Cid.ToPin()
+		return !st.Has(pin.Cid)
+	case LogOpSharedConfig:
+		cfg := st.SharedConfig()
+		return cfg.Merge(op.configUpdate()) == cfg
+	default:
+		return false
+	}
+}
+
 // LogPin submits a Cid to the shared state of the cluster. It will forward
 // the operation to the leader if this is not it.
 func (cc *Consensus) LogPin(pin api.Pin) error {
@@ -333,14 +601,33 @@ func (cc *Consensus) LogUnpin(pin api.Pin) error {
 	return nil
 }
 
+// LogSharedConfig modifies the cluster-wide configuration stored in the
+// shared state. The update is merged with the configuration when it is
+// applied, so concurrent updates of different settings do not overwrite
+// each other. It will forward the operation to the leader if this is
+// not it.
+func (cc *Consensus) LogSharedConfig(update api.SharedConfigUpdate) error {
+	us := update.ToSerial()
+	op := &LogOp{
+		Config:       us.Config,
+		ConfigFields: us.Fields,
+		Type:         LogOpSharedConfig,
+	}
+	return cc.commit(op, "ConsensusLogSharedConfig", us)
+}
+
 // AddPeer adds a new peer to participate in this consensus. It will
-// forward the operation to the leader if this is not it.
+// forward the operation to the leader if this is not it. Peers rejected
+// by the filter set with SetPeerFilter are not added.
 func (cc *Consensus) AddPeer(pid peer.ID) error {
+	if !cc.accepted(pid) {
+		return errPeerFiltered(pid)
+	}
 	var finalErr error
 	for i := 0; i <= cc.config.CommitRetries; i++ {
-		logger.Debugf("attempt #%d: AddPeer %s", i, pid.Pretty())
+		cc.logger.Debugf("attempt #%d: AddPeer %s", i, pid.Pretty())
 		if finalErr != nil {
-			logger.Errorf("retrying to add peer. Attempt #%d failed: %s", i, finalErr)
+			cc.logger.Errorf("retrying to add peer. Attempt #%d failed: %s", i, finalErr)
 		}
 		ok, err := cc.redirectToLeader("ConsensusAddPeer", pid)
 		if err != nil || ok {
@@ -348,13 +635,13 @@ func (cc *Consensus) AddPeer(pid peer.ID) error {
 		}
 		// Being here means we are the leader and can commit
 		cc.shutdownLock.Lock() // do not shutdown while committing
-		finalErr = cc.raft.AddPeer(peer.IDB58Encode(pid))
+		finalErr = cc.getRaft().AddPeer(peer.IDB58Encode(pid))
 		cc.shutdownLock.Unlock()
 		if finalErr != nil {
 			time.Sleep(cc.config.CommitRetryDelay)
 			continue
 		}
-		logger.Infof("peer added to Raft: %s", pid.Pretty())
+		cc.logger.Infof("peer added to Raft: %s", pid.Pretty())
 		break
 	}
 	return finalErr
@@ -365,9 +652,9 @@ func (cc *Consensus) AddPeer(pid peer.ID) error {
 func (cc *Consensus) RmPeer(pid peer.ID) error {
 	var finalErr error
 	for i := 0; i <= cc.config.CommitRetries; i++ {
-		logger.Debugf("attempt #%d: RmPeer %s", i, pid.Pretty())
+		cc.logger.Debugf("attempt #%d: RmPeer %s", i, pid.Pretty())
 		if finalErr != nil {
-			logger.Errorf("retrying to remove peer. Attempt #%d failed: %s", i, finalErr)
+			cc.logger.Errorf("retrying to remove peer. Attempt #%d failed: %s", i, finalErr)
 		}
 		ok, err := cc.redirectToLeader("ConsensusRmPeer", pid)
 		if err != nil || ok {
@@ -375,13 +662,13 @@ func (cc *Consensus) RmPeer(pid peer.ID) error {
 		}
 		// Being here means we are the leader and can commit
 		cc.shutdownLock.Lock() // do not shutdown while committing
-		finalErr = cc.raft.RemovePeer(peer.IDB58Encode(pid))
+		finalErr = cc.getRaft().RemovePeer(peer.IDB58Encode(pid))
 		cc.shutdownLock.Unlock()
 		if finalErr != nil {
 			time.Sleep(cc.config.CommitRetryDelay)
 			continue
 		}
-		logger.Infof("peer removed from Raft: %s", pid.Pretty())
+		cc.logger.Infof("peer removed from Raft: %s", pid.Pretty())
 		break
 	}
 	return finalErr
@@ -403,11 +690,109 @@ func (cc *Consensus) State() (state.State, error) {
 	return state, nil
 }
 
+// TransferLeadership makes the current leader hand leadership over to
+// the given peer, which must be connected to it. It will forward the
+// operation to the leader if this is not it. The peers demoted during the
+// transfer are made voters again afterwards, also when the transfer
+// fails.
+func (cc *Consensus) TransferLeadership(pid peer.ID) error {
+	ok, err := cc.redirectToLeader("ConsensusTransferLeadership", pid)
+	if err != nil || ok {
+		return err
+	}
+
+	// Being here means we are the leader. The target will be the only
+	// voter left, so it must be able to win the election.
+	if pid != cc.host.ID() && cc.host.Network().Connectedness(pid) != inet.Connected {
+		return fmt.Errorf("cannot transfer leadership to %s: not connected", pid.Pretty())
+	}
+
+	cc.shutdownLock.Lock() // do not shutdown while transferring
+	demoted, err := cc.getRaft().TransferLeadership(peer.IDB58Encode(pid))
+	cc.shutdownLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	transferErr := cc.waitForLeader(pid)
+	restoreErr := cc.restoreVoters(demoted)
+	if transferErr != nil {
+		cc.logger.Error(transferErr)
+		return transferErr
+	}
+	if restoreErr != nil {
+		return restoreErr
+	}
+	cc.logger.Infof("raft leadership transferred to %s", pid.Pretty())
+	return nil
+}
+
+// waitForLeader waits until the given peer has become the leader, or
+// fails if another peer did or if it takes longer than
+// WaitForLeaderTimeout.
+func (cc *Consensus) waitForLeader(pid peer.ID) error {
+	ctx, cancel := context.WithTimeout(cc.ctx, cc.config.WaitForLeaderTimeout)
+	defer cancel()
+	for {
+		leader, err := cc.Leader()
+		if err == nil && leader != cc.host.ID() {
+			if leader != pid {
+				return fmt.Errorf("%s became the leader instead of %s", leader.Pretty(), pid.Pretty())
+			}
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for %s to become the leader", pid.Pretty())
+		case <-time.After(cc.config.RaftConfig.HeartbeatTimeout):
+		}
+	}
+}
+
+// restoreVoters makes voters again of the peers demoted during a
+// leadership transfer. Promotions are retried (i.e. while a leader is
+// elected) until WaitForLeaderTimeout expires.
+func (cc *Consensus) restoreVoters(demoted []string) error {
+	ctx, cancel := context.WithTimeout(cc.ctx, cc.config.WaitForLeaderTimeout)
+	defer cancel()
+
+	pending := demoted
+	for {
+		var failed []string
+		var lastErr error
+		for _, p := range pending {
+			id, err := peer.IDB58Decode(p)
+			if err != nil {
+				cc.logger.Error(err)
+				continue
+			}
+			if err := cc.AddPeer(id); err != nil {
+				failed = append(failed, p)
+				lastErr = err
+			}
+		}
+		if len(failed) == 0 {
+			return nil
+		}
+		pending = failed
+
+		select {
+		case <-ctx.Done():
+			err := fmt.Errorf("could not promote %v back to voters: %s", pending, lastErr)
+			cc.logger.Error(err)
+			return err
+		case <-time.After(cc.config.RaftConfig.HeartbeatTimeout):
+		}
+	}
+}
+
 // Leader returns the peerID of the Leader of the
 // cluster. It returns an error when there is no leader.
 func (cc *Consensus) Leader() (peer.ID, error) {
 	// Note the hard-dependency on raft here...
+	cc.raftMux.RLock()
 	raftactor := cc.actor.(*libp2praft.Actor)
+	cc.raftMux.RUnlock()
 	return raftactor.Leader()
 }
 
@@ -418,7 +803,7 @@ func (cc *Consensus) Clean() error {
 		return errors.New("consensus component is not shutdown")
 	}
 
-	err := cc.raft.Clean()
+	err := cc.getRaft().Clean()
 	if err != nil {
 		return err
 	}
@@ -435,6 +820,45 @@ func (cc *Consensus) Rollback(state state.State) error {
 	return cc.consensus.Rollback(state)
 }
 
+// RepairState makes the local copy of the shared state identical to the
+// given one by adding, replacing and removing the differing entries. No log
+// operations are applied while doing so. It does not commit anything: the
+// given state is expected to be the leader's, which is authoritative.
+func (cc *Consensus) RepairState(authoritative state.State) error {
+	st, err := cc.State()
+	if err != nil {
+		return err
+	}
+
+	cc.applyMux.Lock()
+	defer cc.applyMux.Unlock()
+
+	for _, pin := range st.List() {
+		if authoritative.Has(pin.Cid) {
+			continue
+		}
+		if err := st.Rm(pin.Cid); err != nil {
+			return err
+		}
+		cc.changes.Record(api.PinsetChangeRemove, pin)
+	}
+
+	for _, pin := range authoritative.List() {
+		if st.Has(pin.Cid) && st.Get(pin.Cid).Equals(pin) {
+			continue
+		}
+		if err := st.Add(pin); err != nil {
+			return err
+		}
+		cc.changes.Record(api.PinsetChangeAdd, pin)
+	}
+
+	if cfg := authoritative.SharedConfig(); st.SharedConfig() != cfg {
+		return st.SetSharedConfig(cfg)
+	}
+	return nil
+}
+
 // Peers return the current list of peers in the consensus.
 // The list will be sorted alphabetically.
 func (cc *Consensus) Peers() ([]peer.ID, error) {
@@ -442,7 +866,7 @@ func (cc *Consensus) Peers() ([]peer.ID, error) {
 		return nil, errors.New("consensus is shutdown")
 	}
 	peers := []peer.ID{}
-	raftPeers, err := cc.raft.Peers()
+	raftPeers, err := cc.getRaft().Peers()
 	if err != nil {
 		return nil, fmt.Errorf("cannot retrieve list of peers: %s", err)
 	}
@@ -459,6 +883,84 @@ func (cc *Consensus) Peers() ([]peer.ID, error) {
 	return peers, nil
 }
 
+// Status returns information about the Raft consensus as seen by
+// this peer: current leader and term, last applied index, the
+// voting status of every peer in the Raft configuration and metrics
+// about leader changes, latencies, snapshots and the size of the log.
+func (cc *Consensus) Status() (api.ConsensusState, error) {
+	var cs api.ConsensusState
+	if cc.shutdown {
+		return cs, errors.New("consensus is shutdown")
+	}
+
+	cc.raftMux.RLock()
+	restarting := cc.restarting
+	cc.raftMux.RUnlock()
+	if restarting {
+		return cs, errors.New("consensus is restarting after a failure")
+	}
+
+	servers, err := cc.getRaft().Servers()
+	if err != nil {
+		return cs, fmt.Errorf("cannot retrieve raft configuration: %s", err)
+	}
+
+	term, err := cc.getRaft().Term()
+	if err != nil {
+		return cs, fmt.Errorf("cannot retrieve raft term: %s", err)
+	}
+
+	for _, srv := range servers {
+		pid, err := peer.IDB58Decode(string(srv.ID))
+		if err != nil {
+			return cs, fmt.Errorf("could not decode peer: %s", err)
+		}
+		if srv.Suffrage == hraft.Voter {
+			cs.Voters = append(cs.Voters, pid)
+		} else {
+			cs.NonVoters = append(cs.NonVoters, pid)
+		}
+	}
+
+	// An unknown leader is not an error here, it is precisely
+	// what operators want to find out. A known leader may be stale
+	// though (i.e. in a partitioned follower), so quorum is derived
+	// from the contact with it.
+	if leader := cc.getRaft().Leader(); leader != "" {
+		pid, err := peer.IDB58Decode(leader)
+		if err == nil {
+			cs.Leader = pid
+		}
+	}
+	cs.HasQuorum = cc.getRaft().HasQuorum()
+	cs.Term = term
+	cs.AppliedIndex = cc.getRaft().AppliedIndex()
+
+	commitIndex, lastIndex, snapshotIndex := cc.getRaft().LogIndexes()
+	cs.CommitIndex = commitIndex
+	if lastIndex > snapshotIndex {
+		cs.LogEntries = lastIndex - snapshotIndex
+	}
+
+	cc.metrics.mux.Lock()
+	cs.LeaderChanges = cc.metrics.leaderChanges
+	cs.LastLeaderChange = cc.metrics.lastLeaderChange
+	cs.CommitLatency = cc.metrics.commitLatency
+	cs.ApplyLatency = cc.metrics.applyLatency
+	cs.Snapshots = cc.metrics.snapshots
+	cc.metrics.mux.Unlock()
+	return cs, nil
+}
+
+// PinsetChanges returns up to limit pinset changes applied by this peer
+// after the given index in the given epoch. When there are none, it waits
+// up to the given time for new ones. The log starts with a new epoch every
+// time the peer starts, and the changes replayed from the Raft log are
+// recorded again.
+func (cc *Consensus) PinsetChanges(epoch string, since uint64, limit int, wait time.Duration) (api.PinsetChanges, error) {
+	return cc.changes.Wait(cc.ctx, epoch, since, limit, wait), nil
+}
+
 func parsePIDFromMultiaddr(addr ma.Multiaddr) string {
 	pidstr, err := addr.ValueForProtocol(ma.P_IPFS)
 	if err != nil {
@@ -3,7 +3,9 @@ package raft
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -14,6 +16,7 @@ import (
 	cid "github.com/ipfs/go-cid"
 	libp2p "github.com/libp2p/go-libp2p"
 	host "github.com/libp2p/go-libp2p-host"
+	peer "github.com/libp2p/go-libp2p-peer"
 	peerstore "github.com/libp2p/go-libp2p-peerstore"
 	ma "github.com/multiformats/go-multiaddr"
 )
@@ -119,6 +122,173 @@ func TestConsensusUnpin(t *testing.T) {
 	}
 }
 
+func TestConsensusPinsetChanges(t *testing.T) {
+	cc := testingConsensus(t, 1)
+	defer cleanRaft(1)
+	defer cc.Shutdown()
+
+	c1, _ := cid.Decode(test.TestCid1)
+	c2, _ := cid.Decode(test.TestCid2)
+	pin1 := api.Pin{Cid: c1, ReplicationFactorMin: -1, ReplicationFactorMax: -1}
+	pin1.Metadata = map[string]string{"owner": "test"}
+	pin2 := api.Pin{Cid: c2, ReplicationFactorMin: -1, ReplicationFactorMax: -1}
+	cc.LogPin(pin1)
+	cc.LogPin(pin2)
+	cc.LogUnpin(api.PinCid(c1))
+	time.Sleep(250 * time.Millisecond)
+
+	chs, err := cc.PinsetChanges("", 0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chs.Resync || chs.LastIndex != 3 || len(chs.Changes) != 3 {
+		t.Fatalf("expected 3 changes: %+v", chs)
+	}
+	if chs.Changes[0].Type != api.PinsetChangeAdd || !chs.Changes[0].Pin.Cid.Equals(c1) ||
+		chs.Changes[1].Type != api.PinsetChangeAdd || !chs.Changes[1].Pin.Cid.Equals(c2) ||
+		chs.Changes[2].Type != api.PinsetChangeRemove || !chs.Changes[2].Pin.Cid.Equals(c1) {
+		t.Errorf("unexpected changes: %+v", chs.Changes)
+	}
+	if chs.Changes[2].Pin.Metadata["owner"] != "test" {
+		t.Error("removals should carry the removed pin")
+	}
+	if chs.Epoch == "" {
+		t.Error("the changes should have an epoch")
+	}
+
+	go func() {
+		time.Sleep(250 * time.Millisecond)
+		cc.LogPin(pin1)
+	}()
+	chs, err = cc.PinsetChanges(chs.Epoch, 3, 0, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chs.Changes) != 1 || chs.Changes[0].Index != 4 {
+		t.Errorf("expected to wait for the new change: %+v", chs)
+	}
+}
+
+func TestConsensusRepairState(t *testing.T) {
+	cc := testingConsensus(t, 1)
+	defer cleanRaft(1)
+	defer cc.Shutdown()
+
+	c1, _ := cid.Decode(test.TestCid1)
+	c2, _ := cid.Decode(test.TestCid2)
+	err := cc.LogPin(api.Pin{Cid: c1, ReplicationFactorMin: -1, ReplicationFactorMax: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(250 * time.Millisecond)
+
+	authoritative := mapstate.NewMapState()
+	authoritative.Add(api.Pin{Cid: c2, ReplicationFactorMin: -1, ReplicationFactorMax: -1})
+	authoritative.SetSharedConfig(api.SharedConfig{ReplicationFactorMin: 2, ReplicationFactorMax: 3})
+
+	err = cc.RepairState(authoritative)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := cc.State()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Has(c1) || !st.Has(c2) {
+		t.Error("the state should be identical to the authoritative one")
+	}
+	if st.SharedConfig().ReplicationFactorMax != 3 {
+		t.Error("the shared configuration should have been repaired")
+	}
+
+	changes, err := cc.PinsetChanges("", 0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes.Changes) != 3 {
+		t.Error("expected the repair to be recorded in the change log:", changes.Changes)
+	}
+}
+
+func TestConsensusSharedConfig(t *testing.T) {
+	cc := testingConsensus(t, 1)
+	defer cleanRaft(1)
+	defer cc.Shutdown()
+
+	cfg := api.SharedConfig{
+		ReplicationFactorMin: 1,
+		ReplicationFactorMax: 2,
+		StateSyncInterval:    time.Minute,
+	}
+	err := cc.LogSharedConfig(api.SharedConfigUpdate{Config: cfg})
+	if err != nil {
+		t.Fatal("the operation did not make it to the log:", err)
+	}
+
+	time.Sleep(250 * time.Millisecond)
+	st, err := cc.State()
+	if err != nil {
+		t.Fatal("error getting state:", err)
+	}
+	if st.SharedConfig() != cfg {
+		t.Error("the shared config should be in the state")
+	}
+
+	err = cc.LogSharedConfig(api.SharedConfigUpdate{
+		Config: api.SharedConfig{Allocator: "ascend"},
+		Fields: []string{api.SharedConfigAllocator},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(250 * time.Millisecond)
+	st, _ = cc.State()
+	cfg.Allocator = "ascend"
+	if st.SharedConfig() != cfg {
+		t.Error("only the allocator should have been modified:", st.SharedConfig())
+	}
+}
+
+func TestConsensusSkipNoOps(t *testing.T) {
+	cc := testingConsensus(t, 1)
+	defer cleanRaft(1)
+	defer cc.Shutdown()
+
+	c, _ := cid.Decode(test.TestCid1)
+	pin := api.Pin{Cid: c, ReplicationFactorMin: -1, ReplicationFactorMax: -1}
+	err := cc.LogPin(pin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := cc.getRaft().AppliedIndex()
+
+	// Same pin again
+	err = cc.LogPin(pin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Unpin something not pinned
+	c2, _ := cid.Decode(test.TestCid2)
+	err = cc.LogUnpin(api.PinCid(c2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cc.getRaft().AppliedIndex() != index {
+		t.Error("no-op operations should not have been committed")
+	}
+
+	// Different options are not a no-op
+	pin.Name = "changed"
+	err = cc.LogPin(pin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cc.getRaft().AppliedIndex() == index {
+		t.Error("the modified pin should have been committed")
+	}
+}
+
 func TestConsensusAddPeer(t *testing.T) {
 	cc := testingConsensus(t, 1)
 	cc2 := testingConsensus(t, 2)
@@ -202,6 +372,106 @@ func TestConsensusRmPeer(t *testing.T) {
 	}
 }
 
+func TestConsensusRestart(t *testing.T) {
+	superviseInterval = 100 * time.Millisecond
+	defer func() { superviseInterval = 2 * time.Second }()
+
+	cc := testingConsensus(t, 1)
+	defer cleanRaft(1)
+	defer cc.Shutdown()
+
+	// Simulate a failure
+	err := cc.getRaft().raft.Shutdown().Error()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for !cc.getRaft().Alive() {
+		select {
+		case <-ctx.Done():
+			t.Fatal("consensus was not restarted")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	err = cc.WaitForSync()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, _ := cid.Decode(test.TestCid1)
+	err = cc.LogPin(api.Pin{Cid: c, ReplicationFactorMin: -1, ReplicationFactorMax: -1})
+	if err != nil {
+		t.Error("the operation did not make it to the log after restart:", err)
+	}
+}
+
+func TestConsensusNoQuorum(t *testing.T) {
+	cc := testingConsensus(t, 1)
+	defer cleanRaft(1)
+	defer cc.Shutdown()
+
+	cc.setNoQuorum(true)
+	c, _ := cid.Decode(test.TestCid1)
+	err := cc.LogPin(api.Pin{Cid: c, ReplicationFactorMin: -1, ReplicationFactorMax: -1})
+	if err != ErrNoQuorum {
+		t.Fatal("expected ErrNoQuorum:", err)
+	}
+
+	st, err := cc.State()
+	if err != nil {
+		t.Fatal("the state should be readable without quorum:", err)
+	}
+	if st.Has(c) {
+		t.Error("the pin should not have been committed")
+	}
+}
+
+func TestConsensusQuorumLoss(t *testing.T) {
+	quorumCheckInterval = 100 * time.Millisecond
+	defer func() { quorumCheckInterval = time.Second }()
+
+	cc := testingConsensus(t, 1)
+	cc2 := testingConsensus(t, 2)
+	defer cleanRaft(1)
+	defer cleanRaft(2)
+	defer cc.Shutdown()
+	defer cc2.Shutdown()
+	cc2.config.QuorumLossTimeout = time.Second
+
+	cc.host.Peerstore().AddAddr(cc2.host.ID(), consensusListenAddr(cc2), peerstore.PermanentAddrTTL)
+	err := cc.AddPeer(cc2.host.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err = cc2.raft.WaitForPeer(ctx, cc.host.ID().Pretty(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// With one of two voters gone, no leader can be elected
+	cc.Shutdown()
+
+	for !cc2.hasNoQuorum() {
+		select {
+		case <-ctx.Done():
+			t.Fatal("quorum loss was not detected")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	c, _ := cid.Decode(test.TestCid1)
+	err = cc2.LogPin(api.Pin{Cid: c, ReplicationFactorMin: -1, ReplicationFactorMax: -1})
+	if err != ErrNoQuorum {
+		t.Error("expected ErrNoQuorum:", err)
+	}
+}
+
 func TestConsensusLeader(t *testing.T) {
 	cc := testingConsensus(t, 1)
 	pID := cc.host.ID()
@@ -217,6 +487,80 @@ func TestConsensusLeader(t *testing.T) {
 	}
 }
 
+func TestConsensusStatus(t *testing.T) {
+	cc := testingConsensus(t, 1)
+	pID := cc.host.ID()
+	defer cleanRaft(1)
+	defer cc.Shutdown()
+
+	cs, err := cc.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cs.Leader != pID {
+		t.Errorf("expected %s but the leader appears as %s", pID, cs.Leader)
+	}
+	if !cs.HasQuorum {
+		t.Error("single peer should have quorum")
+	}
+	if cs.Term == 0 {
+		t.Error("expected a term greater than 0")
+	}
+	if len(cs.Voters) != 1 || cs.Voters[0] != pID {
+		t.Error("expected ourselves as only voter")
+	}
+	if len(cs.NonVoters) != 0 {
+		t.Error("expected no non-voters")
+	}
+}
+
+func TestConsensusStatusMetrics(t *testing.T) {
+	quorumCheckInterval = 100 * time.Millisecond
+	defer func() { quorumCheckInterval = time.Second }()
+
+	cc := testingConsensus(t, 1)
+	defer cleanRaft(1)
+	defer cc.Shutdown()
+
+	c, _ := cid.Decode(test.TestCid1)
+	err := cc.LogPin(api.Pin{Cid: c, ReplicationFactorMin: -1, ReplicationFactorMax: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(250 * time.Millisecond)
+
+	cs, err := cc.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cs.LeaderChanges != 1 || cs.LastLeaderChange.IsZero() {
+		t.Errorf("expected a single leader change, got %d", cs.LeaderChanges)
+	}
+	if cs.CommitLatency <= 0 || cs.ApplyLatency <= 0 {
+		t.Error("expected commit and apply latencies")
+	}
+	if cs.CommitIndex == 0 || cs.LogEntries == 0 {
+		t.Error("expected a commit index and log entries")
+	}
+
+	err = cc.raft.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(250 * time.Millisecond)
+
+	cs, err = cc.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cs.Snapshots != 1 {
+		t.Errorf("expected 1 snapshot, got %d", cs.Snapshots)
+	}
+	if cs.LogEntries != 0 {
+		t.Errorf("expected no log entries after a snapshot, got %d", cs.LogEntries)
+	}
+}
+
 func TestRaftLatestSnapshot(t *testing.T) {
 	cc := testingConsensus(t, 1)
 	defer cleanRaft(1)
@@ -279,3 +623,209 @@ func TestRaftLatestSnapshot(t *testing.T) {
 		t.Fatal("Latest snapshot not read")
 	}
 }
+
+func TestCompact(t *testing.T) {
+	cc := testingConsensus(t, 1)
+	defer cleanRaft(1)
+	defer os.RemoveAll("raftFolderFromTests-1.old.0")
+	cfg := cc.config
+	cfg.RaftConfig.TrailingLogs = 0
+
+	c1, _ := cid.Decode(test.TestCid1)
+	err := cc.LogPin(api.Pin{Cid: c1, ReplicationFactorMin: -1, ReplicationFactorMax: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(250 * time.Millisecond)
+	err = cc.raft.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, _ := cid.Decode(test.TestCid2)
+	err = cc.LogPin(api.Pin{Cid: c2, ReplicationFactorMin: -1, ReplicationFactorMax: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(250 * time.Millisecond)
+	err = cc.Shutdown() // takes a snapshot
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a backup to prune
+	err = os.MkdirAll("raftFolderFromTests-1.old.0", 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Compact(cfg, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snaps, err := ioutil.ReadDir(filepath.Join(cfg.GetDataFolder(), "snapshots"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snaps) != 1 {
+		t.Errorf("expected a single snapshot after pruning, got %d", len(snaps))
+	}
+	if _, err := os.Stat("raftFolderFromTests-1.old.0"); !os.IsNotExist(err) {
+		t.Error("backups should have been pruned")
+	}
+
+	st := mapstate.NewMapState()
+	cc, err = NewConsensus(makeTestingHost(t), cfg, st, false)
+	if err != nil {
+		t.Fatal("consensus should start from a compacted folder:", err)
+	}
+	cc.SetClient(test.NewMockRPCClientWithHost(t, cc.host))
+	<-cc.Ready()
+
+	if !st.Has(c1) || !st.Has(c2) {
+		t.Error("the state should be preserved")
+	}
+	cc.Shutdown()
+
+	corruptRaftDB(t, cfg)
+	if Compact(cfg, false) == nil {
+		t.Error("expected an error compacting a corrupted log store")
+	}
+}
+
+func corruptRaftDB(t *testing.T, cfg *Config) {
+	err := ioutil.WriteFile(
+		filepath.Join(cfg.GetDataFolder(), "raft.db"),
+		[]byte("corrupted"),
+		0600,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecoverCorruptedDataFolder(t *testing.T) {
+	cc := testingConsensus(t, 1)
+	defer cleanRaft(1)
+	defer os.RemoveAll("raftFolderFromTests-1.old.0")
+	cfg := cc.config
+
+	c1, _ := cid.Decode(test.TestCid1)
+	err := cc.LogPin(api.Pin{Cid: c1, ReplicationFactorMin: -1, ReplicationFactorMax: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(250 * time.Millisecond)
+	err = cc.Shutdown() // takes a snapshot
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	corruptRaftDB(t, cfg)
+
+	st := mapstate.NewMapState()
+	cc, err = NewConsensus(makeTestingHost(t), cfg, st, false)
+	if err != nil {
+		t.Fatal("consensus should have recovered from the snapshot:", err)
+	}
+	defer cc.Shutdown()
+	cc.SetClient(test.NewMockRPCClientWithHost(t, cc.host))
+	<-cc.Ready()
+
+	if !st.Has(c1) {
+		t.Error("the state should have been recovered from the snapshot")
+	}
+	if _, err := os.Stat("raftFolderFromTests-1.old.0"); err != nil {
+		t.Error("the corrupted folder should have been backed up")
+	}
+}
+
+func TestRecoverCorruptedDataFolderNoSnapshot(t *testing.T) {
+	cleanRaft(1)
+	defer cleanRaft(1)
+	defer os.RemoveAll("raftFolderFromTests-1.old.0")
+
+	cfg := &Config{}
+	cfg.Default()
+	cfg.DataFolder = "raftFolderFromTests-1"
+	err := makeDataFolder(cfg.GetDataFolder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	corruptRaftDB(t, cfg)
+
+	err = recoverDataFolder(cfg)
+	if err != errCorruptedState {
+		t.Fatal("expected errCorruptedState:", err)
+	}
+
+	cfg.CleanCorruptedState = true
+	err = recoverDataFolder(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.GetDataFolder(), "raft.db")); !os.IsNotExist(err) {
+		t.Error("the data folder should be clean")
+	}
+	if _, err := os.Stat("raftFolderFromTests-1.old.0"); err != nil {
+		t.Error("the corrupted folder should have been backed up")
+	}
+}
+
+func TestNoRecoveryWithoutCorruption(t *testing.T) {
+	cleanRaft(1)
+	defer cleanRaft(1)
+	defer os.RemoveAll("raftFolderFromTests-1.old.0")
+
+	cfg := &Config{}
+	cfg.Default()
+	cfg.DataFolder = "raftFolderFromTests-1"
+	cfg.CleanCorruptedState = true
+
+	// raft.db cannot be opened, but its contents are not corrupted
+	err := os.MkdirAll(filepath.Join(cfg.GetDataFolder(), "raft.db"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewConsensus(makeTestingHost(t), cfg, mapstate.NewMapState(), false)
+	if err == nil {
+		t.Fatal("expected an error opening the raft database")
+	}
+	if _, err := os.Stat("raftFolderFromTests-1.old.0"); !os.IsNotExist(err) {
+		t.Error("the data folder should not have been backed up")
+	}
+}
+
+func TestConsensusPeerFilter(t *testing.T) {
+	cc := testingConsensus(t, 1)
+	cc2 := testingConsensus(t, 2)
+	defer cleanRaft(1)
+	defer cleanRaft(2)
+	defer cc.Shutdown()
+	defer cc2.Shutdown()
+
+	cc.host.Peerstore().AddAddr(cc2.host.ID(), consensusListenAddr(cc2), peerstore.PermanentAddrTTL)
+	err := cc.AddPeer(cc2.host.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cc.SetPeerFilter(func(pid peer.ID) bool {
+		return pid != cc2.host.ID()
+	})
+	err = cc.AddPeer(cc2.host.ID())
+	if err == nil {
+		t.Error("filtered peers should not be added")
+	}
+
+	// cc is the leader, so it removes the filtered peer
+	cc.enforcePeerFilter(cc.getRaft().Leader())
+	peers, err := cc.Peers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peers) != 1 || peers[0] != cc.host.ID() {
+		t.Error("the filtered peer should have been removed")
+	}
+}
@@ -0,0 +1,181 @@
+package raft
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"io"
+	"io/ioutil"
+
+	hraft "github.com/hashicorp/raft"
+)
+
+// EncryptionKeySize is the size of the keys used to encrypt the Raft
+// data folder (AES-256).
+const EncryptionKeySize = 32
+
+var errCiphertextTooShort = errors.New("encrypted raft data is too short")
+
+// cipherBox seals and opens data with AES-GCM. Sealed data is prefixed
+// by the random nonce used to encrypt it.
+type cipherBox struct {
+	aead cipher.AEAD
+}
+
+func newCipherBox(key []byte) (*cipherBox, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &cipherBox{aead: aead}, nil
+}
+
+func (b *cipherBox) seal(plain []byte) ([]byte, error) {
+	nonce := make([]byte, b.aead.NonceSize(), b.aead.NonceSize()+len(plain)+b.aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return b.aead.Seal(nonce, nonce, plain, nil), nil
+}
+
+func (b *cipherBox) open(sealed []byte) ([]byte, error) {
+	n := b.aead.NonceSize()
+	if len(sealed) < n {
+		return nil, errCiphertextTooShort
+	}
+	return b.aead.Open(nil, sealed[:n], sealed[n:], nil)
+}
+
+// encryptedLogStore encrypts the data of every log entry before
+// handing it to the wrapped LogStore, and decrypts it when read.
+type encryptedLogStore struct {
+	hraft.LogStore
+	box *cipherBox
+}
+
+func (s *encryptedLogStore) GetLog(index uint64, log *hraft.Log) error {
+	err := s.LogStore.GetLog(index, log)
+	if err != nil {
+		return err
+	}
+	log.Data, err = s.box.open(log.Data)
+	return err
+}
+
+func (s *encryptedLogStore) StoreLog(log *hraft.Log) error {
+	return s.StoreLogs([]*hraft.Log{log})
+}
+
+func (s *encryptedLogStore) StoreLogs(logs []*hraft.Log) error {
+	// Logs may be kept in memory by the caller (i.e. the LogCache),
+	// so we must not modify them.
+	sealed := make([]*hraft.Log, len(logs), len(logs))
+	for i, l := range logs {
+		cp := *l
+		data, err := s.box.seal(l.Data)
+		if err != nil {
+			return err
+		}
+		cp.Data = data
+		sealed[i] = &cp
+	}
+	return s.LogStore.StoreLogs(sealed)
+}
+
+// encryptedSnapshotStore encrypts snapshots before they are written
+// to the wrapped SnapshotStore and decrypts them when opened.
+type encryptedSnapshotStore struct {
+	hraft.SnapshotStore
+	box *cipherBox
+}
+
+func (s *encryptedSnapshotStore) Create(
+	version hraft.SnapshotVersion,
+	index, term uint64,
+	configuration hraft.Configuration,
+	configurationIndex uint64,
+	trans hraft.Transport,
+) (hraft.SnapshotSink, error) {
+	sink, err := s.SnapshotStore.Create(
+		version,
+		index,
+		term,
+		configuration,
+		configurationIndex,
+		trans,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedSnapshotSink{SnapshotSink: sink, box: s.box}, nil
+}
+
+func (s *encryptedSnapshotStore) Open(id string) (*hraft.SnapshotMeta, io.ReadCloser, error) {
+	meta, r, err := s.SnapshotStore.Open(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer r.Close()
+	sealed, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	plain, err := s.box.open(sealed)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Raft checks that snapshots sent to other peers have the size
+	// in the metadata, so it must match the decrypted contents.
+	plainMeta := *meta
+	plainMeta.Size = int64(len(plain))
+	return &plainMeta, ioutil.NopCloser(bytes.NewReader(plain)), nil
+}
+
+// encryptedSnapshotSink buffers the snapshot and writes it encrypted
+// to the wrapped sink when closed.
+type encryptedSnapshotSink struct {
+	hraft.SnapshotSink
+	box *cipherBox
+	buf bytes.Buffer
+}
+
+func (s *encryptedSnapshotSink) Write(p []byte) (int, error) {
+	return s.buf.Write(p)
+}
+
+func (s *encryptedSnapshotSink) Close() error {
+	sealed, err := s.box.seal(s.buf.Bytes())
+	if err != nil {
+		s.SnapshotSink.Cancel()
+		return err
+	}
+	_, err = s.SnapshotSink.Write(sealed)
+	if err != nil {
+		s.SnapshotSink.Cancel()
+		return err
+	}
+	return s.SnapshotSink.Close()
+}
+
+// newSnapshotStore returns a file snapshot store for the given folder,
+// which encrypts snapshots when a key is provided.
+func newSnapshotStore(folder string, key []byte) (hraft.SnapshotStore, error) {
+	store, err := hraft.NewFileSnapshotStoreWithLogger(folder, RaftMaxSnapshots, raftStdLogger)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) == 0 {
+		return store, nil
+	}
+	box, err := newCipherBox(key)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedSnapshotStore{SnapshotStore: store, box: box}, nil
+}
@@ -0,0 +1,103 @@
+package raft
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	hraft "github.com/hashicorp/raft"
+)
+
+var testEncryptionKey = bytes.Repeat([]byte{0x42}, EncryptionKeySize)
+
+func TestEncryptedLogStore(t *testing.T) {
+	box, err := newCipherBox(testEncryptionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inmem := hraft.NewInmemStore()
+	store := &encryptedLogStore{LogStore: inmem, box: box}
+
+	data := []byte("QmXZrtE5jQwXNqCJMfHUTQkvhQ4ZAnqMnmzFMJfLewuabc")
+	l := &hraft.Log{Index: 1, Term: 1, Type: hraft.LogCommand, Data: data}
+	err = store.StoreLog(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(l.Data, data) {
+		t.Error("stored log should not be modified")
+	}
+
+	var raw hraft.Log
+	err = inmem.GetLog(1, &raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw.Data, data) {
+		t.Error("log data should be encrypted in the underlying store")
+	}
+
+	var got hraft.Log
+	err = store.GetLog(1, &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Data, data) {
+		t.Error("decrypted log data does not match")
+	}
+
+	otherBox, _ := newCipherBox(bytes.Repeat([]byte{0x01}, EncryptionKeySize))
+	other := &encryptedLogStore{LogStore: inmem, box: otherBox}
+	err = other.GetLog(1, &got)
+	if err == nil {
+		t.Error("expected an error decrypting with the wrong key")
+	}
+}
+
+func TestEncryptedSnapshotStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "raft-encrypted-snapshots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := newSnapshotStore(dir, testEncryptionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte("QmXZrtE5jQwXNqCJMfHUTQkvhQ4ZAnqMnmzFMJfLewuabc")
+	_, trans := hraft.NewInmemTransport("")
+	sink, err := store.Create(1, 10, 2, hraft.Configuration{}, 1, trans)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.Write(data)
+	err = sink.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta, snap, err := latestValidSnapshot(dir, testEncryptionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta == nil || meta.Index != 10 {
+		t.Fatal("expected to find the snapshot")
+	}
+	if !bytes.Equal(snap, data) {
+		t.Error("decrypted snapshot does not match")
+	}
+	if meta.Size != int64(len(data)) {
+		t.Error("snapshot size should be that of the decrypted contents")
+	}
+
+	_, raw, err := latestValidSnapshot(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, data) {
+		t.Error("snapshot should be encrypted on disk")
+	}
+}
@@ -2,6 +2,7 @@ package raft
 
 import (
 	"errors"
+	"time"
 
 	"github.com/ipfs/ipfs-cluster/api"
 	"github.com/ipfs/ipfs-cluster/state"
@@ -13,6 +14,7 @@ import (
 const (
 	LogOpPin = iota + 1
 	LogOpUnpin
+	LogOpSharedConfig
 )
 
 // LogOpType expresses the type of a consensus Operation
@@ -22,9 +24,13 @@ type LogOpType int
 // It implements the consensus.Op interface and it is used by the
 // Consensus component.
 type LogOp struct {
-	Cid       api.PinSerial
-	Type      LogOpType
-	consensus *Consensus
+	Cid    api.PinSerial
+	Config api.SharedConfigSerial
+	// ConfigFields names the settings modified by a LogOpSharedConfig
+	// operation. When empty, Config replaces the whole configuration.
+	ConfigFields []string
+	Type         LogOpType
+	consensus    *Consensus
 }
 
 // ApplyTo applies the operation to the State
@@ -36,12 +42,25 @@ func (op *LogOp) ApplyTo(cstate consensus.State) (consensus.State, error) {
 		panic("received unexpected state type")
 	}
 
+	op.consensus.applyMux.Lock()
+	defer op.consensus.applyMux.Unlock()
+
+	start := time.Now()
+	defer func() {
+		op.consensus.metrics.observeApply(time.Since(start))
+	}()
+
 	switch op.Type {
 	case LogOpPin:
-		err = state.Add(op.Cid.ToPin())
+		pin := op.Cid.ToPin()
+		op.consensus.logger.Debugf("applying pin %s (request %s)", pin.Cid, pin.RequestID)
+		// Request IDs and signatures are not part of the shared state
+		pin = pin.StripRequest()
+		err = state.Add(pin)
 		if err != nil {
 			goto ROLLBACK
 		}
+		op.consensus.changes.Record(api.PinsetChangeAdd, pin)
 		// Async, we let the PinTracker take care of any problems
 		op.consensus.rpcClient.Go("",
 			"Cluster",
@@ -50,10 +69,14 @@ func (op *LogOp) ApplyTo(cstate consensus.State) (consensus.State, error) {
 			&struct{}{},
 			nil)
 	case LogOpUnpin:
+		op.consensus.logger.Debugf("applying unpin %s (request %s)", op.Cid.Cid, op.Cid.RequestID)
+		// Record the pin as it was in the state, with its metadata
+		removed := state.Get(op.Cid.ToPin().Cid)
 		err = state.Rm(op.Cid.ToPin().Cid)
 		if err != nil {
 			goto ROLLBACK
 		}
+		op.consensus.changes.Record(api.PinsetChangeRemove, removed)
 		// Async, we let the PinTracker take care of any problems
 		op.consensus.rpcClient.Go("",
 			"Cluster",
@@ -61,9 +84,21 @@ func (op *LogOp) ApplyTo(cstate consensus.State) (consensus.State, error) {
 			op.Cid,
 			&struct{}{},
 			nil)
-
+	case LogOpSharedConfig:
+		cfg := state.SharedConfig().Merge(op.configUpdate())
+		err = state.SetSharedConfig(cfg)
+		if err != nil {
+			goto ROLLBACK
+		}
+		// Async, let the peer apply any changes
+		op.consensus.rpcClient.Go("",
+			"Cluster",
+			"ApplySharedConfig",
+			cfg.ToSerial(),
+			&struct{}{},
+			nil)
 	default:
-		logger.Error("unknown LogOp type. Ignoring")
+		op.consensus.logger.Error("unknown LogOp type. Ignoring")
 	}
 	return state, nil
 
@@ -72,6 +107,15 @@ ROLLBACK:
 	// and therefore we need to request a rollback to the
 	// cluster to the previous state. This operation can only be performed
 	// by the cluster leader.
-	logger.Error("Rollbacks are not implemented")
+	op.consensus.logger.Error("Rollbacks are not implemented")
 	return nil, errors.New("a rollback may be necessary. Reason: " + err.Error())
 }
+
+// configUpdate returns the shared configuration update carried
+// by a LogOpSharedConfig operation.
+func (op *LogOp) configUpdate() api.SharedConfigUpdate {
+	return api.SharedConfigUpdate{
+		Config: op.Config.ToSharedConfig(),
+		Fields: op.ConfigFields,
+	}
+}
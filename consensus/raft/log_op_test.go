@@ -13,7 +13,7 @@ import (
 func TestApplyToPin(t *testing.T) {
 	cc := testingConsensus(t, 1)
 	op := &LogOp{
-		Cid:       api.PinSerial{Cid: test.TestCid1},
+		Cid:       api.PinSerial{Cid: test.TestCid1, RequestID: "abcd"},
 		Type:      LogOpPin,
 		consensus: cc,
 	}
@@ -26,6 +26,9 @@ func TestApplyToPin(t *testing.T) {
 	if len(pins) != 1 || pins[0].Cid.String() != test.TestCid1 {
 		t.Error("the state was not modified correctly")
 	}
+	if len(pins) == 1 && pins[0].RequestID != "" {
+		t.Error("request IDs should not be stored in the state")
+	}
 }
 
 func TestApplyToUnpin(t *testing.T) {
@@ -48,6 +51,24 @@ func TestApplyToUnpin(t *testing.T) {
 	}
 }
 
+func TestApplyToSharedConfig(t *testing.T) {
+	cc := testingConsensus(t, 1)
+	op := &LogOp{
+		Config:    api.SharedConfigSerial{ReplicationFactorMin: 2, Allocator: "ascend"},
+		Type:      LogOpSharedConfig,
+		consensus: cc,
+	}
+	defer cleanRaft(1)
+	defer cc.Shutdown()
+
+	st := mapstate.NewMapState()
+	op.ApplyTo(st)
+	cfg := st.SharedConfig()
+	if cfg.ReplicationFactorMin != 2 || cfg.Allocator != "ascend" {
+		t.Error("the state was not modified correctly")
+	}
+}
+
 func TestApplyToBadState(t *testing.T) {
 	defer func() {
 		if r := recover(); r == nil {
@@ -0,0 +1,72 @@
+package raft
+
+import (
+	"sync"
+	"time"
+)
+
+// Weight of the last observation in the moving averages of the
+// commit and apply latencies.
+const latencyAlpha = 0.2
+
+// raftMetrics keeps track of the Raft activity which helps spotting an
+// unstable consensus: frequent leader changes, slow commits or a log
+// which is not snapshotted. The zero value is ready to use.
+type raftMetrics struct {
+	mux sync.Mutex
+
+	leader           string
+	leaderChanges    uint64
+	lastLeaderChange time.Time
+
+	commitLatency time.Duration
+	applyLatency  time.Duration
+
+	lastSnapshotIndex uint64
+	snapshots         uint64
+}
+
+// observeLeader registers the leader currently known. Changes to a new
+// leader are counted. Losing the leader is not a change on its own.
+func (m *raftMetrics) observeLeader(leader string) {
+	if leader == "" {
+		return
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if leader != m.leader {
+		m.leader = leader
+		m.leaderChanges++
+		m.lastLeaderChange = time.Now()
+	}
+}
+
+// observeSnapshot registers the index of the last snapshot and counts
+// a new snapshot whenever it moves forward.
+func (m *raftMetrics) observeSnapshot(index uint64) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if index > m.lastSnapshotIndex {
+		m.lastSnapshotIndex = index
+		m.snapshots++
+	}
+}
+
+func (m *raftMetrics) observeCommit(d time.Duration) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.commitLatency = movingAverage(m.commitLatency, d)
+}
+
+func (m *raftMetrics) observeApply(d time.Duration) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.applyLatency = movingAverage(m.applyLatency, d)
+}
+
+func movingAverage(avg, d time.Duration) time.Duration {
+	if avg == 0 {
+		return d
+	}
+	return time.Duration(latencyAlpha*float64(d) + (1-latencyAlpha)*float64(avg))
+}
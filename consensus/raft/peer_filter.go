@@ -0,0 +1,67 @@
+package raft
+
+import (
+	"fmt"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+// SetPeerFilter sets a function which decides which peers may be part
+// of the peerset. This peer refuses to add the peers for which it returns
+// false and, while it is the leader, removes them from the Raft peerset.
+// Filters are local to every peer: while another peer is the leader, this
+// one can only report the filtered peers which are part of the peerset.
+func (cc *Consensus) SetPeerFilter(accept func(peer.ID) bool) {
+	cc.filterMux.Lock()
+	defer cc.filterMux.Unlock()
+	cc.acceptPeer = accept
+}
+
+func (cc *Consensus) accepted(pid peer.ID) bool {
+	cc.filterMux.RLock()
+	defer cc.filterMux.RUnlock()
+	if cc.acceptPeer == nil {
+		return true
+	}
+	return cc.acceptPeer(pid)
+}
+
+func errPeerFiltered(pid peer.ID) error {
+	return fmt.Errorf("peer %s is not accepted in the peerset", pid.Pretty())
+}
+
+// enforcePeerFilter removes the filtered peers from the Raft peerset when
+// this peer is the leader. Otherwise it logs an error for every filtered
+// peer found in the peerset, once.
+func (cc *Consensus) enforcePeerFilter(leader string) {
+	raftPeers, err := cc.getRaft().Peers()
+	if err != nil {
+		return
+	}
+
+	self := peer.IDB58Encode(cc.host.ID())
+	filtered := make(map[string]struct{})
+	for _, p := range raftPeers {
+		pid, err := peer.IDB58Decode(p)
+		if err != nil || cc.accepted(pid) {
+			continue
+		}
+		filtered[p] = struct{}{}
+
+		if leader == self {
+			cc.logger.Warningf("removing filtered peer %s from the Raft peerset", pid.Pretty())
+			cc.shutdownLock.Lock() // do not shutdown while committing
+			err := cc.getRaft().RemovePeer(p)
+			cc.shutdownLock.Unlock()
+			if err != nil {
+				cc.logger.Errorf("removing filtered peer %s: %s", pid.Pretty(), err)
+			}
+			continue
+		}
+
+		if _, ok := cc.filteredWarned[p]; !ok {
+			cc.logger.Errorf("filtered peer %s is part of the Raft peerset. It will be removed only when this peer becomes the leader, or when it is removed through the leader", pid.Pretty())
+		}
+	}
+	cc.filteredWarned = filtered
+}
@@ -5,16 +5,20 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
 	"time"
 
+	"github.com/boltdb/bolt"
 	hraft "github.com/hashicorp/raft"
 	raftboltdb "github.com/hashicorp/raft-boltdb"
 	host "github.com/libp2p/go-libp2p-host"
 	peer "github.com/libp2p/go-libp2p-peer"
 	p2praft "github.com/libp2p/go-libp2p-raft"
 
+	"github.com/ipfs/ipfs-cluster/logutil"
 	"github.com/ipfs/ipfs-cluster/state"
 )
 
@@ -26,6 +30,10 @@ var errBadRaftState = errors.New("cluster peers do not match raft peers")
 // the peer set, which won't happen
 var errWaitingForSelf = errors.New("waiting for ourselves to depart")
 
+// errCorruptedState is returned when the Raft data folder cannot be read
+// and there is no valid snapshot to recover from.
+var errCorruptedState = errors.New("raft data folder is corrupted and no valid snapshot was found")
+
 // RaftMaxSnapshots indicates how many snapshots to keep in the consensus data
 // folder.
 // TODO: Maybe include this in Config. Not sure how useful it is to touch
@@ -45,9 +53,15 @@ const sixtyfour = uint64(^uint(0)) == ^uint64(0)
 var waitForUpdatesShutdownTimeout = 5 * time.Second
 var waitForUpdatesInterval = 100 * time.Millisecond
 
+// How often WaitForUpdates reports progress while replaying the log
+var waitForUpdatesReportInterval = 5 * time.Second
+
 // How many times to retry snapshotting when shutting down
 var maxShutdownSnapshotRetries = 5
 
+// How often we check that Raft is still running
+var superviseInterval = 2 * time.Second
+
 // raftWrapper wraps the hraft.Raft object and related things like the
 // different stores used or the hraft.Configuration.
 // Its methods provide functionality for working with Raft.
@@ -62,6 +76,7 @@ type raftWrapper struct {
 	stableStore   hraft.StableStore
 	boltdb        *raftboltdb.BoltStore
 	staging       bool
+	logger        *logutil.Logger
 }
 
 // newRaftWrapper creates a Raft instance and initializes
@@ -72,12 +87,14 @@ func newRaftWrapper(
 	cfg *Config,
 	fsm hraft.FSM,
 	staging bool,
+	log *logutil.Logger,
 ) (*raftWrapper, error) {
 
 	raftW := &raftWrapper{}
 	raftW.config = cfg
 	raftW.host = host
 	raftW.staging = staging
+	raftW.logger = log
 	// Set correct LocalID
 	cfg.RaftConfig.LocalID = hraft.ServerID(peer.IDB58Encode(host.ID()))
 
@@ -94,26 +111,166 @@ func newRaftWrapper(
 		return nil, err
 	}
 
-	err = raftW.makeStores()
+	corrupted, err := raftW.makeRaft(fsm)
+	if err == nil {
+		return raftW, nil
+	}
+	if !corrupted {
+		log.Error("initializing raft: ", err)
+		raftW.transport.Close()
+		return nil, err
+	}
+
+	log.Errorf("the Raft data folder (%s) is corrupted: %s", df, err)
+	err = recoverDataFolder(cfg)
+	if err != nil {
+		raftW.transport.Close()
+		return nil, err
+	}
+
+	_, err = raftW.makeRaft(fsm)
 	if err != nil {
+		log.Error("initializing raft: ", err)
+		raftW.transport.Close()
 		return nil, err
 	}
+	return raftW, nil
+}
+
+// makeRaft opens the stores and creates the Raft instance. Corrupted
+// data may cause the underlying libraries to panic. Those panics are
+// returned as errors. The returned boolean is true when the error was
+// caused by unreadable data in the data folder, and false for any other
+// problem (i.e. a bad configuration or a locked or inaccessible database),
+// which must not trigger a recovery of the folder.
+func (rw *raftWrapper) makeRaft(fsm hraft.FSM) (corrupted bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			corrupted = true
+			err = fmt.Errorf("panic loading Raft data: %v", r)
+		}
+		if err != nil && rw.boltdb != nil {
+			rw.boltdb.Close()
+			rw.boltdb = nil
+		}
+	}()
+
+	err = hraft.ValidateConfig(rw.config.RaftConfig)
+	if err != nil {
+		return false, err
+	}
+
+	err = rw.makeStores()
+	if err != nil {
+		return isCorruptedBoltDB(err), err
+	}
 
-	logger.Debug("creating Raft")
-	raftW.raft, err = hraft.NewRaft(
-		cfg.RaftConfig,
+	rw.logger.Debug("creating Raft")
+	rw.raft, err = hraft.NewRaft(
+		rw.config.RaftConfig,
 		fsm,
-		raftW.logStore,
-		raftW.stableStore,
-		raftW.snapshotStore,
-		raftW.transport,
+		rw.logStore,
+		rw.stableStore,
+		rw.snapshotStore,
+		rw.transport,
 	)
 	if err != nil {
-		logger.Error("initializing raft: ", err)
-		return nil, err
+		// The configuration is valid and the stores are open,
+		// so Raft could not read the logs or the snapshots.
+		return true, err
 	}
 
-	return raftW, nil
+	// Any snapshot opened from now on is sent to another peer.
+	if ts, ok := rw.snapshotStore.(*throttledSnapshotStore); ok {
+		ts.enable()
+	}
+	return false, nil
+}
+
+// isCorruptedBoltDB returns true for the errors returned when opening
+// a BoltDB file whose contents are not valid.
+func isCorruptedBoltDB(err error) bool {
+	switch err {
+	case bolt.ErrInvalid, bolt.ErrVersionMismatch, bolt.ErrChecksum:
+		return true
+	default:
+		return false
+	}
+}
+
+// recoverDataFolder is called when the Raft data folder cannot be
+// loaded. It backs up the folder and creates a new one containing only
+// the most recent valid snapshot. Any log entries after that snapshot
+// will be retrieved from the rest of the cluster. When there are no
+// valid snapshots, a clean data folder is created only if
+// CleanCorruptedState is set. Otherwise, an error is returned.
+func recoverDataFolder(cfg *Config) error {
+	df := cfg.GetDataFolder()
+	key, err := cfg.GetEncryptionKey()
+	if err != nil {
+		return err
+	}
+	meta, snap, err := latestValidSnapshot(df, key)
+	if err != nil {
+		logger.Error(err)
+	}
+
+	if meta == nil && !cfg.CleanCorruptedState {
+		logger.Error("the Raft data folder is unreadable and no valid snapshots were found.")
+		logger.Error("Start the peer allowing to clean corrupted state to back it up")
+		logger.Error("and start from an empty state.")
+		return errCorruptedState
+	}
+
+	logger.Warningf("backing up the corrupted Raft data folder (%s)", df)
+	dbh := newDataBackupHelper(df, cfg.BackupsRotate)
+	err = dbh.makeBackup()
+	if err != nil {
+		return err
+	}
+	err = makeDataFolder(df)
+	if err != nil {
+		return err
+	}
+
+	if meta == nil {
+		logger.Warning("**************************************************************")
+		logger.Warning("The Raft data folder was corrupted and no valid snapshots were")
+		logger.Warning("found. Starting with a CLEAN state. A backup of the corrupted")
+		logger.Warningf("data is kept in %s.", dbh.makeName(0))
+		logger.Warning("**************************************************************")
+		return nil
+	}
+
+	logger.Warningf(
+		"recovering Raft state from snapshot %s (index %d, term %d)",
+		meta.ID,
+		meta.Index,
+		meta.Term,
+	)
+	snapshotStore, err := newSnapshotStore(df, key)
+	if err != nil {
+		return err
+	}
+	_, dummyTransport := hraft.NewInmemTransport("")
+
+	sink, err := snapshotStore.Create(
+		meta.Version,
+		meta.Index,
+		meta.Term,
+		meta.Configuration,
+		meta.ConfigurationIndex,
+		dummyTransport,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = sink.Write(snap)
+	if err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
 }
 
 // makeDataFolder creates the folder that is meant
@@ -141,7 +298,7 @@ func makeDataFolder(folder string) error {
 }
 
 func (rw *raftWrapper) makeTransport() (err error) {
-	logger.Debug("creating libp2p Raft transport")
+	rw.logger.Debug("creating libp2p Raft transport")
 	rw.transport, err = p2praft.NewLibp2pTransport(
 		rw.host,
 		rw.config.NetworkTimeout,
@@ -150,29 +307,44 @@ func (rw *raftWrapper) makeTransport() (err error) {
 }
 
 func (rw *raftWrapper) makeStores() error {
-	logger.Debug("creating BoltDB store")
+	key, err := rw.config.GetEncryptionKey()
+	if err != nil {
+		return err
+	}
+
+	rw.logger.Debug("creating BoltDB store")
 	df := rw.config.GetDataFolder()
 	store, err := raftboltdb.NewBoltStore(filepath.Join(df, "raft.db"))
 	if err != nil {
 		return err
 	}
 
+	var logStore hraft.LogStore = store
+	if len(key) > 0 {
+		rw.logger.Debug("encrypting raft log entries")
+		box, err := newCipherBox(key)
+		if err != nil {
+			store.Close()
+			return err
+		}
+		logStore = &encryptedLogStore{LogStore: store, box: box}
+	}
+
 	// wraps the store in a LogCache to improve performance.
 	// See consul/agent/consul/server.go
-	cacheStore, err := hraft.NewLogCache(RaftLogCacheSize, store)
+	cacheStore, err := hraft.NewLogCache(RaftLogCacheSize, logStore)
 	if err != nil {
 		return err
 	}
 
-	logger.Debug("creating raft snapshot store")
-	snapstore, err := hraft.NewFileSnapshotStoreWithLogger(
-		df,
-		RaftMaxSnapshots,
-		raftStdLogger,
-	)
+	rw.logger.Debug("creating raft snapshot store")
+	snapstore, err := newSnapshotStore(df, key)
 	if err != nil {
 		return err
 	}
+	if limit := rw.config.SnapshotTransferLimit; limit > 0 {
+		snapstore = &throttledSnapshotStore{SnapshotStore: snapstore, limit: limit}
+	}
 
 	rw.logStore = cacheStore
 	rw.stableStore = store
@@ -186,7 +358,7 @@ func (rw *raftWrapper) makeStores() error {
 // and we are not setting up a staging peer. It returns if Raft
 // was boostrapped (true) and an error.
 func (rw *raftWrapper) Bootstrap() (bool, error) {
-	logger.Debug("checking for existing raft states")
+	rw.logger.Debug("checking for existing raft states")
 	hasState, err := hraft.HasExistingState(
 		rw.logStore,
 		rw.stableStore,
@@ -197,13 +369,13 @@ func (rw *raftWrapper) Bootstrap() (bool, error) {
 	}
 
 	if hasState {
-		logger.Debug("raft cluster is already initialized")
+		rw.logger.Debug("raft cluster is already initialized")
 
 		// Inform the user that we are working with a pre-existing peerset
-		logger.Info("existing Raft state found! raft.InitPeerset will be ignored")
+		rw.logger.Info("existing Raft state found! raft.InitPeerset will be ignored")
 		cf := rw.raft.GetConfiguration()
 		if err := cf.Error(); err != nil {
-			logger.Debug(err)
+			rw.logger.Debug(err)
 			return false, err
 		}
 		currentCfg := cf.Configuration()
@@ -212,13 +384,13 @@ func (rw *raftWrapper) Bootstrap() (bool, error) {
 			srvs += fmt.Sprintf("        %s\n", s.ID)
 		}
 
-		logger.Debugf("Current Raft Peerset:\n%s\n", srvs)
+		rw.logger.Debugf("Current Raft Peerset:\n%s\n", srvs)
 		return false, nil
 	}
 
 	if rw.staging {
-		logger.Debug("staging servers do not need initialization")
-		logger.Info("peer is ready to join a cluster")
+		rw.logger.Debug("staging servers do not need initialization")
+		rw.logger.Info("peer is ready to join a cluster")
 		return false, nil
 	}
 
@@ -227,11 +399,11 @@ func (rw *raftWrapper) Bootstrap() (bool, error) {
 		voters += fmt.Sprintf("        %s\n", s.ID)
 	}
 
-	logger.Infof("initializing raft cluster with the following voters:\n%s\n", voters)
+	rw.logger.Infof("initializing raft cluster with the following voters:\n%s\n", voters)
 
 	future := rw.raft.BootstrapCluster(rw.serverConfig)
 	if err := future.Error(); err != nil {
-		logger.Error("bootstrapping cluster: ", err)
+		rw.logger.Error("bootstrapping cluster: ", err)
 		return true, err
 	}
 	return true, nil
@@ -283,14 +455,14 @@ func (rw *raftWrapper) WaitForLeader(ctx context.Context) (string, error) {
 			// switch obs.Data.(type) {
 			// case hraft.LeaderObservation:
 			// 	lObs := obs.Data.(hraft.LeaderObservation)
-			// 	logger.Infof("Raft Leader elected: %s",
+			// 	rw.logger.Infof("Raft Leader elected: %s",
 			// 		lObs.Leader)
 			// 	return string(lObs.Leader), nil
 			// }
 		case <-ticker.C:
 			if l := rw.raft.Leader(); l != "" {
-				logger.Debug("waitForleaderTimer")
-				logger.Infof("Current Raft Leader: %s", l)
+				rw.logger.Debug("waitForleaderTimer")
+				rw.logger.Infof("Current Raft Leader: %s", l)
 				ticker.Stop()
 				return string(l), nil
 			}
@@ -301,7 +473,7 @@ func (rw *raftWrapper) WaitForLeader(ctx context.Context) (string, error) {
 }
 
 func (rw *raftWrapper) WaitForVoter(ctx context.Context) error {
-	logger.Debug("waiting until we are promoted to a voter")
+	rw.logger.Debug("waiting until we are promoted to a voter")
 
 	pid := hraft.ServerID(peer.IDB58Encode(rw.host.ID()))
 	for {
@@ -334,7 +506,8 @@ func isVoter(srvID hraft.ServerID, cfg hraft.Configuration) bool {
 
 // WaitForUpdates holds until Raft has synced to the last index in the log
 func (rw *raftWrapper) WaitForUpdates(ctx context.Context) error {
-	logger.Debug("Raft state is catching up to the latest known version. Please wait...")
+	rw.logger.Debug("Raft state is catching up to the latest known version. Please wait...")
+	lastReport := time.Now()
 	for {
 		select {
 		case <-ctx.Done():
@@ -342,11 +515,17 @@ func (rw *raftWrapper) WaitForUpdates(ctx context.Context) error {
 		default:
 			lai := rw.raft.AppliedIndex()
 			li := rw.raft.LastIndex()
-			logger.Debugf("current Raft index: %d/%d",
+			rw.logger.Debugf("current Raft index: %d/%d",
 				lai, li)
 			if lai == li {
 				return nil
 			}
+			// Let operators know that we are not stuck when
+			// there is a long log to replay.
+			if time.Since(lastReport) >= waitForUpdatesReportInterval {
+				rw.logger.Infof("replaying log: %d/%d entries applied", lai, li)
+				lastReport = time.Now()
+			}
 			time.Sleep(waitForUpdatesInterval)
 		}
 	}
@@ -411,7 +590,7 @@ func (rw *raftWrapper) snapshotOnShutdown() error {
 		err := rw.WaitForUpdates(ctx)
 		cancel()
 		if err != nil {
-			logger.Warning("timed out waiting for state updates before shutdown. Snapshotting may fail")
+			rw.logger.Warning("timed out waiting for state updates before shutdown. Snapshotting may fail")
 			done = true // let's not wait for updates again
 		}
 
@@ -426,7 +605,7 @@ func (rw *raftWrapper) snapshotOnShutdown() error {
 		if done {
 			break
 		}
-		logger.Warningf("retrying to snapshot (%d/%d)...", i+1, maxShutdownSnapshotRetries)
+		rw.logger.Warningf("retrying to snapshot (%d/%d)...", i+1, maxShutdownSnapshotRetries)
 	}
 	return err
 }
@@ -458,16 +637,48 @@ func (rw *raftWrapper) Shutdown() error {
 	return nil
 }
 
+// Alive returns false when Raft has stopped.
+func (rw *raftWrapper) Alive() bool {
+	return rw.raft.State() != hraft.Shutdown
+}
+
+// Close stops Raft, without taking a snapshot, and releases the
+// stores and the transport so that a new raftWrapper can be created
+// in their place.
+func (rw *raftWrapper) Close() error {
+	errMsgs := ""
+
+	err := rw.raft.Shutdown().Error()
+	if err != nil {
+		errMsgs += "could not shutdown raft: " + err.Error() + ".\n"
+	}
+
+	err = rw.transport.Close()
+	if err != nil {
+		errMsgs += "could not close transport: " + err.Error() + ".\n"
+	}
+
+	err = rw.boltdb.Close()
+	if err != nil {
+		errMsgs += "could not close boltdb: " + err.Error()
+	}
+
+	if errMsgs != "" {
+		return errors.New(errMsgs)
+	}
+	return nil
+}
+
 // AddPeer adds a peer to Raft
 func (rw *raftWrapper) AddPeer(peer string) error {
 	// Check that we don't have it to not waste
-	// log entries if so.
-	peers, err := rw.Peers()
-	if err != nil {
+	// log entries if so. Non-voters are promoted.
+	configFuture := rw.raft.GetConfiguration()
+	if err := configFuture.Error(); err != nil {
 		return err
 	}
-	if find(peers, peer) {
-		logger.Infof("%s is already a raft peer", peer)
+	if isVoter(hraft.ServerID(peer), configFuture.Configuration()) {
+		rw.logger.Infof("%s is already a raft peer", peer)
 		return nil
 	}
 
@@ -476,9 +687,9 @@ func (rw *raftWrapper) AddPeer(peer string) error {
 		hraft.ServerAddress(peer),
 		0,
 		0) // TODO: Extra cfg value?
-	err = future.Error()
+	err := future.Error()
 	if err != nil {
-		logger.Error("raft cannot add peer: ", err)
+		rw.logger.Error("raft cannot add peer: ", err)
 	}
 	return err
 }
@@ -492,7 +703,7 @@ func (rw *raftWrapper) RemovePeer(peer string) error {
 		return err
 	}
 	if !find(peers, peer) {
-		logger.Infof("%s is not among raft peers", peer)
+		rw.logger.Infof("%s is not among raft peers", peer)
 		return nil
 	}
 
@@ -506,13 +717,73 @@ func (rw *raftWrapper) RemovePeer(peer string) error {
 		0) // TODO: Extra cfg value?
 	err = rmFuture.Error()
 	if err != nil {
-		logger.Error("raft cannot remove peer: ", err)
+		rw.logger.Error("raft cannot remove peer: ", err)
 		return err
 	}
 
 	return nil
 }
 
+// TransferLeadership makes this peer, which must be the leader, give up
+// leadership in favour of the given voter. This version of Raft cannot
+// transfer leadership natively, so every other voter is demoted first,
+// leaving the target as the only possible candidate, and then this peer
+// demotes itself, which makes it step down. It returns the peers which
+// were demoted along the way, which should be promoted again once the
+// target has become the leader.
+func (rw *raftWrapper) TransferLeadership(target string) ([]string, error) {
+	self := peer.IDB58Encode(rw.host.ID())
+	if rw.Leader() != self {
+		return nil, errors.New("only the leader can transfer leadership")
+	}
+	if target == self {
+		return nil, errors.New("this peer is already the leader")
+	}
+
+	configFuture := rw.raft.GetConfiguration()
+	if err := configFuture.Error(); err != nil {
+		return nil, err
+	}
+	cfg := configFuture.Configuration()
+	if !isVoter(hraft.ServerID(target), cfg) {
+		return nil, fmt.Errorf("%s is not a voting raft peer", target)
+	}
+
+	var others []string
+	for _, server := range cfg.Servers {
+		id := string(server.ID)
+		if server.Suffrage != hraft.Voter || id == target || id == self {
+			continue
+		}
+		others = append(others, id)
+	}
+	// ourselves last, so that we keep leading until the end
+	others = append(others, self)
+
+	demoted := make([]string, 0, len(others))
+	for _, id := range others {
+		err := rw.raft.DemoteVoter(hraft.ServerID(id), 0, 0).Error()
+		if err != nil {
+			rw.logger.Errorf("raft cannot demote %s: %s", id, err)
+			rw.promote(demoted)
+			return nil, err
+		}
+		demoted = append(demoted, id)
+	}
+	return demoted, nil
+}
+
+// promote makes voters of the given peers again. It is used to undo
+// an unfinished leadership transfer.
+func (rw *raftWrapper) promote(peers []string) {
+	for _, id := range peers {
+		err := rw.raft.AddVoter(hraft.ServerID(id), hraft.ServerAddress(id), 0, 0).Error()
+		if err != nil {
+			rw.logger.Errorf("raft cannot promote %s: %s", id, err)
+		}
+	}
+}
+
 // Leader returns Raft's leader. It may be an empty string if
 // there is no leader or it is unknown.
 func (rw *raftWrapper) Leader() string {
@@ -534,11 +805,53 @@ func (rw *raftWrapper) Peers() ([]string, error) {
 	return ids, nil
 }
 
+// HasQuorum returns true when this peer is the leader, as Raft leaders
+// step down when they lose contact with a majority of voters for longer
+// than the LeaderLeaseTimeout, or when it has heard from the leader
+// within the last HeartbeatTimeout.
+func (rw *raftWrapper) HasQuorum() bool {
+	if rw.raft.State() == hraft.Leader {
+		return true
+	}
+	last := rw.raft.LastContact()
+	return !last.IsZero() && time.Since(last) < rw.config.RaftConfig.HeartbeatTimeout
+}
+
+// Servers returns the servers in the current Raft configuration, along
+// with their suffrage.
+func (rw *raftWrapper) Servers() ([]hraft.Server, error) {
+	configFuture := rw.raft.GetConfiguration()
+	if err := configFuture.Error(); err != nil {
+		return nil, err
+	}
+	return configFuture.Configuration().Servers, nil
+}
+
+// Term returns the current Raft term.
+func (rw *raftWrapper) Term() (uint64, error) {
+	return strconv.ParseUint(rw.raft.Stats()["term"], 10, 64)
+}
+
+// AppliedIndex returns the index of the last log entry applied to the FSM.
+func (rw *raftWrapper) AppliedIndex() uint64 {
+	return rw.raft.AppliedIndex()
+}
+
+// LogIndexes returns the commit index, the index of the last log entry
+// and the index of the last snapshot, as reported by Raft.
+func (rw *raftWrapper) LogIndexes() (commit, last, snapshot uint64) {
+	stats := rw.raft.Stats()
+	commit, _ = strconv.ParseUint(stats["commit_index"], 10, 64)
+	last, _ = strconv.ParseUint(stats["last_log_index"], 10, 64)
+	snapshot, _ = strconv.ParseUint(stats["last_snapshot_index"], 10, 64)
+	return
+}
+
 // latestSnapshot looks for the most recent raft snapshot stored at the
 // provided basedir.  It returns the snapshot's metadata, and a reader
-// to the snapshot's bytes
-func latestSnapshot(raftDataFolder string) (*hraft.SnapshotMeta, io.ReadCloser, error) {
-	store, err := hraft.NewFileSnapshotStore(raftDataFolder, RaftMaxSnapshots, nil)
+// to the snapshot's bytes, decrypted with the given key when set.
+func latestSnapshot(raftDataFolder string, key []byte) (*hraft.SnapshotMeta, io.ReadCloser, error) {
+	store, err := newSnapshotStore(raftDataFolder, key)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -556,6 +869,36 @@ func latestSnapshot(raftDataFolder string) (*hraft.SnapshotMeta, io.ReadCloser,
 	return meta, r, nil
 }
 
+// latestValidSnapshot returns the metadata and the contents of the most
+// recent snapshot in the given folder which can be fully read. It
+// returns nil metadata when no such snapshot exists.
+func latestValidSnapshot(raftDataFolder string, key []byte) (*hraft.SnapshotMeta, []byte, error) {
+	store, err := newSnapshotStore(raftDataFolder, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	snapMetas, err := store.List()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, sm := range snapMetas {
+		// Open verifies the snapshot checksum
+		meta, r, err := store.Open(sm.ID)
+		if err != nil {
+			logger.Warningf("skipping snapshot %s: %s", sm.ID, err)
+			continue
+		}
+		snap, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			logger.Warningf("skipping snapshot %s: %s", sm.ID, err)
+			continue
+		}
+		return meta, snap, nil
+	}
+	return nil, nil, nil
+}
+
 // LastStateRaw returns the bytes of the last snapshot stored, its metadata,
 // and a flag indicating whether any snapshot was found.
 func LastStateRaw(cfg *Config) (io.Reader, bool, error) {
@@ -566,7 +909,11 @@ func LastStateRaw(cfg *Config) (io.Reader, bool, error) {
 		return nil, false, nil
 	}
 
-	meta, r, err := latestSnapshot(dataFolder)
+	key, err := cfg.GetEncryptionKey()
+	if err != nil {
+		return nil, false, err
+	}
+	meta, r, err := latestSnapshot(dataFolder, key)
 	if err != nil {
 		return nil, false, err
 	}
@@ -586,12 +933,16 @@ func SnapshotSave(cfg *Config, newState state.State, pids []peer.ID) error {
 	if err != nil {
 		return err
 	}
+	key, err := cfg.GetEncryptionKey()
+	if err != nil {
+		return err
+	}
 	dataFolder := cfg.GetDataFolder()
 	err = makeDataFolder(dataFolder)
 	if err != nil {
 		return err
 	}
-	meta, _, err := latestSnapshot(dataFolder)
+	meta, _, err := latestSnapshot(dataFolder, key)
 	if err != nil {
 		return err
 	}
@@ -616,7 +967,7 @@ func SnapshotSave(cfg *Config, newState state.State, pids []peer.ID) error {
 		srvCfg = makeServerConf(pids)
 	}
 
-	snapshotStore, err := hraft.NewFileSnapshotStoreWithLogger(dataFolder, RaftMaxSnapshots, nil)
+	snapshotStore, err := newSnapshotStore(dataFolder, key)
 	if err != nil {
 		return err
 	}
@@ -641,7 +992,8 @@ func SnapshotSave(cfg *Config, newState state.State, pids []peer.ID) error {
 
 // CleanupRaft moves the current data folder to a backup location
 func CleanupRaft(dataFolder string, keep int) error {
-	meta, _, err := latestSnapshot(dataFolder)
+	// Only the metadata is needed, so snapshots are not decrypted.
+	meta, _, err := latestSnapshot(dataFolder, nil)
 	if meta == nil && err == nil {
 		// no snapshots at all. Avoid creating backups
 		// from empty state folders.
@@ -0,0 +1,66 @@
+package raft
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+
+	hraft "github.com/hashicorp/raft"
+)
+
+// throttledSnapshotStore limits the rate at which snapshots are read
+// once enabled. Raft opens snapshots to restore them on start and to
+// send them to peers which are too far behind afterwards, so enabling it
+// after Raft has started only throttles the transfers to other peers.
+// These would otherwise compete with heartbeats for the bandwidth
+// between peers and may cause leadership to flap.
+type throttledSnapshotStore struct {
+	hraft.SnapshotStore
+	limit   uint64 // bytes per second
+	enabled int32
+}
+
+func (s *throttledSnapshotStore) enable() {
+	atomic.StoreInt32(&s.enabled, 1)
+}
+
+func (s *throttledSnapshotStore) Open(id string) (*hraft.SnapshotMeta, io.ReadCloser, error) {
+	meta, r, err := s.SnapshotStore.Open(id)
+	if err != nil || atomic.LoadInt32(&s.enabled) == 0 {
+		return meta, r, err
+	}
+	logger.Debugf("sending snapshot %s at %d bytes/s", id, s.limit)
+	return meta, newThrottledReader(r, s.limit), nil
+}
+
+// throttledReader delays reads so that, on average, no more than limit
+// bytes per second are read.
+type throttledReader struct {
+	io.ReadCloser
+	limit uint64
+	start time.Time
+	read  uint64
+}
+
+func newThrottledReader(r io.ReadCloser, limit uint64) *throttledReader {
+	return &throttledReader{
+		ReadCloser: r,
+		limit:      limit,
+		start:      time.Now(),
+	}
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	// Read at most a tenth of a second worth of data at once
+	// so that the transfer is smooth.
+	if max := r.limit/10 + 1; uint64(len(p)) > max {
+		p = p[:max]
+	}
+	n, err := r.ReadCloser.Read(p)
+	r.read += uint64(n)
+	due := r.start.Add(time.Duration(float64(r.read) / float64(r.limit) * float64(time.Second)))
+	if d := time.Until(due); d > 0 {
+		time.Sleep(d)
+	}
+	return n, err
+}
@@ -0,0 +1,25 @@
+package raft
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestThrottledReader(t *testing.T) {
+	data := make([]byte, 1000)
+	r := newThrottledReader(ioutil.NopCloser(bytes.NewReader(data)), 2000)
+
+	start := time.Now()
+	read, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(read, data) {
+		t.Error("the contents should not change")
+	}
+	if time.Since(start) < 400*time.Millisecond {
+		t.Error("reading 1000 bytes at 2000 bytes/s should take around 500ms")
+	}
+}
@@ -0,0 +1,51 @@
+package ipfscluster
+
+import (
+	"errors"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+
+	"github.com/ipfs/ipfs-cluster/api"
+)
+
+// FailureDomains reports, for every tracked item, how the peers which
+// have it pinned are spread across the failure domains given by a peer
+// tag (i.e. "region" or "rack", see Config.Tags). Items pinned by several
+// peers which are all in the same domain are flagged as concentrated,
+// since losing that domain loses every replica, even if the replication
+// factor is satisfied.
+func (c *Cluster) FailureDomains(tag string) ([]api.FailureDomainInfo, error) {
+	if tag == "" {
+		return nil, errors.New("a peer tag is needed to define failure domains")
+	}
+
+	domains := make(map[peer.ID]string)
+	for _, id := range c.Peers() {
+		domains[id.ID] = id.Tags[tag]
+	}
+
+	gpis, err := c.StatusAll()
+	if err != nil {
+		return nil, err
+	}
+	return failureDomains(gpis, domains), nil
+}
+
+// failureDomains groups the peers which have every item pinned by the
+// domain they belong to.
+func failureDomains(gpis []api.GlobalPinInfo, domains map[peer.ID]string) []api.FailureDomainInfo {
+	infos := make([]api.FailureDomainInfo, 0, len(gpis))
+	for _, gpi := range gpis {
+		info := api.FailureDomainInfo{
+			Cid:     gpi.Cid,
+			Domains: make(map[string][]peer.ID),
+		}
+		for _, p := range gpi.PinnedBy {
+			d := domains[p]
+			info.Domains[d] = append(info.Domains[d], p)
+		}
+		info.Concentrated = len(gpi.PinnedBy) > 1 && len(info.Domains) == 1
+		infos = append(infos, info)
+	}
+	return infos
+}
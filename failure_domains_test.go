@@ -0,0 +1,42 @@
+package ipfscluster
+
+import (
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+	peer "github.com/libp2p/go-libp2p-peer"
+
+	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/test"
+)
+
+func TestFailureDomains(t *testing.T) {
+	h1, _ := cid.Decode(test.TestCid1)
+	h2, _ := cid.Decode(test.TestCid2)
+	h3, _ := cid.Decode(test.TestCid3)
+
+	domains := map[peer.ID]string{
+		test.TestPeerID1: "eu-west",
+		test.TestPeerID2: "eu-west",
+		test.TestPeerID3: "us-east",
+	}
+	gpis := []api.GlobalPinInfo{
+		{Cid: h1, PinnedBy: []peer.ID{test.TestPeerID1, test.TestPeerID2}},
+		{Cid: h2, PinnedBy: []peer.ID{test.TestPeerID1, test.TestPeerID3}},
+		{Cid: h3, PinnedBy: []peer.ID{test.TestPeerID4}},
+	}
+
+	infos := failureDomains(gpis, domains)
+	if len(infos) != 3 {
+		t.Fatal("expected 3 items")
+	}
+	if !infos[0].Concentrated || len(infos[0].Domains["eu-west"]) != 2 {
+		t.Error("the first item should be concentrated in eu-west")
+	}
+	if infos[1].Concentrated || len(infos[1].Domains) != 2 {
+		t.Error("the second item should be spread over 2 domains")
+	}
+	if infos[2].Concentrated || len(infos[2].Domains[""]) != 1 {
+		t.Error("the third item is pinned by an untagged peer only")
+	}
+}
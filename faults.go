@@ -0,0 +1,96 @@
+package ipfscluster
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ipfs/ipfs-cluster/api"
+)
+
+// DefaultFaultsDuration is how long injected faults last when no
+// duration is given.
+var DefaultFaultsDuration = 10 * time.Minute
+
+var errFaultInjectionDisabled = errors.New("fault injection is not enabled in the configuration of this peer")
+
+// errInjectedIPFS is returned by IPFS requests while Faults.IPFSErrors
+// is set.
+var errInjectedIPFS = errors.New("injected fault: ipfs request failed")
+
+// faultInjector holds the faults currently simulated by this peer. They
+// are cleared once their time is up.
+type faultInjector struct {
+	mux    sync.RWMutex
+	faults api.Faults
+}
+
+func (fi *faultInjector) set(f api.Faults) {
+	fi.mux.Lock()
+	defer fi.mux.Unlock()
+	fi.faults = f
+}
+
+// get returns the current faults, or none if they have expired.
+func (fi *faultInjector) get() api.Faults {
+	fi.mux.RLock()
+	defer fi.mux.RUnlock()
+	if fi.faults.Until.IsZero() || time.Now().After(fi.faults.Until) {
+		return api.Faults{}
+	}
+	return fi.faults
+}
+
+func (fi *faultInjector) ipfsError() error {
+	if fi.get().IPFSErrors {
+		return errInjectedIPFS
+	}
+	return nil
+}
+
+func (fi *faultInjector) rpcDelay() time.Duration {
+	return fi.get().RPCDelay
+}
+
+func (fi *faultInjector) dropMetrics() bool {
+	return fi.get().DropMetrics
+}
+
+// InjectFaults makes this peer simulate the given failure conditions for
+// the given duration (DefaultFaultsDuration if unset), replacing any
+// previous ones. Passing no faults clears them. It is refused unless
+// Config.EnableFaultInjection is set.
+func (c *Cluster) InjectFaults(f api.Faults) error {
+	if !c.config.EnableFaultInjection {
+		return errFaultInjectionDisabled
+	}
+	if f.RPCDelay < 0 || f.Duration < 0 {
+		return errors.New("fault durations cannot be negative")
+	}
+
+	if !f.Active() {
+		c.faults.set(api.Faults{})
+		c.logger.Warning("injected faults cleared")
+		return nil
+	}
+
+	if f.Duration == 0 {
+		f.Duration = DefaultFaultsDuration
+	}
+	f.Until = time.Now().Add(f.Duration)
+	c.faults.set(f)
+	c.logger.Warningf(
+		"injecting faults until %s: ipfs errors: %t, rpc delay: %s, drop metrics: %t",
+		f.Until.Format(time.RFC3339),
+		f.IPFSErrors,
+		f.RPCDelay,
+		f.DropMetrics,
+	)
+	return nil
+}
+
+// Faults returns the failure conditions currently simulated by this
+// peer.
+func (c *Cluster) Faults() api.Faults {
+	return c.faults.get()
+}
@@ -0,0 +1,68 @@
+package ipfscluster
+
+import (
+	"testing"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+
+	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/test"
+)
+
+func TestClusterInjectFaults(t *testing.T) {
+	cl, _, _, _, _ := testingCluster(t)
+	defer cleanRaft()
+	defer cl.Shutdown()
+
+	err := cl.InjectFaults(api.Faults{IPFSErrors: true})
+	if err != errFaultInjectionDisabled {
+		t.Fatal("fault injection should be disabled by default")
+	}
+
+	cl.config.EnableFaultInjection = true
+	err = cl.InjectFaults(api.Faults{IPFSErrors: true, DropMetrics: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	faults := cl.Faults()
+	if !faults.IPFSErrors || !faults.DropMetrics {
+		t.Error("expected the injected faults")
+	}
+	if faults.Duration != DefaultFaultsDuration || faults.Until.IsZero() {
+		t.Error("expected the default duration")
+	}
+
+	h, _ := cid.Decode(test.TestCid1)
+	err = cl.Pin(api.PinCid(h))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinDelay()
+	if st := cl.StatusLocal(h).Status; st != api.TrackerStatusPinError {
+		t.Errorf("expected a pin error, got %s", st)
+	}
+
+	err = cl.InjectFaults(api.Faults{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cl.Faults().Active() {
+		t.Error("faults should have been cleared")
+	}
+
+	cl.faults.set(api.Faults{
+		RPCDelay: time.Second,
+		Until:    time.Now().Add(-time.Second),
+	})
+	if cl.faults.rpcDelay() != 0 {
+		t.Error("expired faults should not apply")
+	}
+
+	if cl.authorizeRPC(test.TestPeerID2, "Cluster.InjectFaults") {
+		t.Error("other peers should not be able to inject faults")
+	}
+	if !cl.authorizeRPC(cl.id, "Cluster.InjectFaults") {
+		t.Error("the peer should be able to inject faults in itself")
+	}
+}
@@ -0,0 +1,41 @@
+package fsutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsutil")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "file.json")
+
+	for _, data := range []string{"first", "second"} {
+		err = WriteFileAtomic(path, []byte(data), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		read, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(read) != data {
+			t.Errorf("expected %q, got %q", data, read)
+		}
+	}
+
+	_, err = os.Stat(path + ".tmp")
+	if !os.IsNotExist(err) {
+		t.Error("the temporary file should not be left behind")
+	}
+
+	err = WriteFileAtomic(filepath.Join(dir, "missing", "file.json"), []byte("x"), 0600)
+	if err == nil {
+		t.Error("expected an error writing to a missing folder")
+	}
+}
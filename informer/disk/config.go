@@ -14,6 +14,8 @@ const configKey = "disk"
 const (
 	DefaultMetricTTL  = 30 * time.Second
 	DefaultMetricType = MetricFreeSpace
+	// Disabled
+	DefaultMinFreeSpace = 0
 )
 
 // String returns a string representation for MetricType.
@@ -34,11 +36,16 @@ type Config struct {
 
 	MetricTTL time.Duration
 	Type      MetricType
+	// MinFreeSpace is the amount of free space (in bytes) in the ipfs
+	// repository below which this peer is marked as unallocatable, so
+	// that it does not receive new pins. 0 disables the check.
+	MinFreeSpace uint64
 }
 
 type jsonConfig struct {
-	MetricTTL string `json:"metric_ttl"`
-	Type      string `json:"metric_type"`
+	MetricTTL    string `json:"metric_ttl"`
+	Type         string `json:"metric_type"`
+	MinFreeSpace uint64 `json:"min_free_space"`
 }
 
 // ConfigKey returns a human-friendly identifier for this type of Metric.
@@ -50,6 +57,7 @@ func (cfg *Config) ConfigKey() string {
 func (cfg *Config) Default() error {
 	cfg.MetricTTL = DefaultMetricTTL
 	cfg.Type = DefaultMetricType
+	cfg.MinFreeSpace = DefaultMinFreeSpace
 	return nil
 }
 
@@ -78,6 +86,7 @@ func (cfg *Config) LoadJSON(raw []byte) error {
 
 	t, _ := time.ParseDuration(jcfg.MetricTTL)
 	cfg.MetricTTL = t
+	cfg.MinFreeSpace = jcfg.MinFreeSpace
 
 	switch jcfg.Type {
 	case "reposize":
@@ -98,6 +107,7 @@ func (cfg *Config) ToJSON() (raw []byte, err error) {
 
 	jcfg.MetricTTL = cfg.MetricTTL.String()
 	jcfg.Type = cfg.Type.String()
+	jcfg.MinFreeSpace = cfg.MinFreeSpace
 
 	raw, err = config.DefaultJSONMarshal(jcfg)
 	return
@@ -8,7 +8,8 @@ import (
 var cfgJSON = []byte(`
 {
     "metric_ttl": "1s",
-    "metric_type": "freespace"  
+    "metric_type": "freespace",
+    "min_free_space": 1000000
 }
 `)
 
@@ -18,6 +19,9 @@ func TestLoadJSON(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	if cfg.MinFreeSpace != 1000000 {
+		t.Error("expected min_free_space to be loaded")
+	}
 
 	j := &jsonConfig{}
 
@@ -9,6 +9,7 @@ import (
 	logging "github.com/ipfs/go-log"
 
 	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/logutil"
 )
 
 // MetricType identifies the type of metric to fetch from the IPFS daemon.
@@ -34,6 +35,7 @@ var metricToRPC = map[MetricType]string{
 type Informer struct {
 	config    *Config
 	rpcClient *rpc.Client
+	logger    *logutil.Logger
 }
 
 // NewInformer returns an initialized informer using the given InformerConfig.
@@ -45,6 +47,7 @@ func NewInformer(cfg *Config) (*Informer, error) {
 
 	return &Informer{
 		config: cfg,
+		logger: logutil.New(logger),
 	}, nil
 }
 
@@ -53,6 +56,11 @@ func (disk *Informer) Name() string {
 	return disk.config.Type.String()
 }
 
+// SetLogPrefix sets the prefix of the messages logged by this component.
+func (disk *Informer) SetLogPrefix(prefix string) {
+	disk.logger.SetPrefix(prefix)
+}
+
 // SetClient provides us with an rpc.Client which allows
 // contacting other components in the cluster.
 func (disk *Informer) SetClient(c *rpc.Client) {
@@ -84,7 +92,7 @@ func (disk *Informer) GetMetric() api.Metric {
 		struct{}{},
 		&metric)
 	if err != nil {
-		logger.Error(err)
+		disk.logger.Error(err)
 		valid = false
 	}
 
@@ -94,6 +102,38 @@ func (disk *Informer) GetMetric() api.Metric {
 		Valid: valid,
 	}
 
+	if valid && disk.config.MinFreeSpace > 0 {
+		m.Unallocatable = disk.lowFreeSpace(metric)
+	}
+
 	m.SetTTL(disk.config.MetricTTL)
 	return m
 }
+
+// lowFreeSpace returns true when the free space in the ipfs repository is
+// below MinFreeSpace. The given metric is re-used when it already
+// provides the free space.
+func (disk *Informer) lowFreeSpace(metric uint64) bool {
+	free := metric
+	if disk.config.Type != MetricFreeSpace {
+		err := disk.rpcClient.Call("",
+			"Cluster",
+			"IPFSFreeSpace",
+			struct{}{},
+			&free)
+		if err != nil {
+			disk.logger.Error(err)
+			return false
+		}
+	}
+
+	if free < disk.config.MinFreeSpace {
+		disk.logger.Warningf(
+			"ipfs repository free space (%d bytes) is below min_free_space (%d bytes). Refusing new allocations",
+			free,
+			disk.config.MinFreeSpace,
+		)
+		return true
+	}
+	return false
+}
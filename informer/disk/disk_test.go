@@ -125,6 +125,34 @@ func TestRepoSize(t *testing.T) {
 	}
 }
 
+func TestMinFreeSpace(t *testing.T) {
+	cfg := &Config{}
+	cfg.Default()
+	cfg.Type = MetricRepoSize
+	cfg.MinFreeSpace = 100000
+
+	inf, err := NewInformer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inf.Shutdown()
+	inf.SetClient(test.NewMockRPCClient(t))
+	// The mock client reports 98000 bytes free
+	m := inf.GetMetric()
+	if !m.Valid {
+		t.Error("metric should be valid")
+	}
+	if !m.Unallocatable {
+		t.Error("peer should be unallocatable")
+	}
+
+	cfg.MinFreeSpace = 1000
+	m = inf.GetMetric()
+	if m.Unallocatable {
+		t.Error("peer should be allocatable")
+	}
+}
+
 func TestWithErrors(t *testing.T) {
 	cfg := &Config{}
 	cfg.Default()
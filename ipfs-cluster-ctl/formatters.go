@@ -5,9 +5,13 @@ import (
 	"errors"
 	"fmt"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/ipfs/ipfs-cluster/api"
+
+	peer "github.com/libp2p/go-libp2p-peer"
 )
 
 func jsonFormatObject(resp interface{}) {
@@ -18,12 +22,40 @@ func jsonFormatObject(resp interface{}) {
 		jsonFormatPrint(resp.(api.ID).ToSerial())
 	case api.GlobalPinInfo:
 		jsonFormatPrint(resp.(api.GlobalPinInfo).ToSerial())
+	case api.PinDetail:
+		jsonFormatPrint(resp.(api.PinDetail).ToSerial())
+	case api.DAGStats:
+		jsonFormatPrint(resp.(api.DAGStats).ToSerial())
+	case api.Job:
+		jsonFormatPrint(resp.(api.Job).ToSerial())
 	case api.Pin:
 		jsonFormatPrint(resp.(api.Pin).ToSerial())
 	case api.Version:
 		jsonFormatPrint(resp.(api.Version))
 	case api.Error:
 		jsonFormatPrint(resp.(api.Error))
+	case api.ConsensusState:
+		jsonFormatPrint(resp.(api.ConsensusState).ToSerial())
+	case api.StateRepair:
+		jsonFormatPrint(resp.(api.StateRepair))
+	case api.PeerRemoval:
+		jsonFormatPrint(resp.(api.PeerRemoval))
+	case api.SecretRotation:
+		jsonFormatPrint(resp.(api.SecretRotation).ToSerial())
+	case api.SharedConfig:
+		jsonFormatPrint(resp.(api.SharedConfig).ToSerial())
+	case api.MultiaddrsSerial:
+		jsonFormatPrint(resp)
+	case api.PinsDiff:
+		jsonFormatPrint(resp.(api.PinsDiff).ToSerial())
+	case api.PinsetChanges:
+		jsonFormatPrint(resp.(api.PinsetChanges).ToSerial())
+	case api.Faults:
+		jsonFormatPrint(resp.(api.Faults).ToSerial())
+	case api.ResourceUsage:
+		jsonFormatPrint(resp)
+	case []peer.ID:
+		jsonFormatPrint(api.PeersToStrings(resp.([]peer.ID)))
 	case []api.ID:
 		r := resp.([]api.ID)
 		serials := make([]api.IDSerial, len(r), len(r))
@@ -46,6 +78,55 @@ func jsonFormatObject(resp interface{}) {
 			serials[i] = item.ToSerial()
 		}
 		jsonFormatPrint(serials)
+	case []api.RPCCallStats:
+		r := resp.([]api.RPCCallStats)
+		serials := make([]api.RPCCallStatsSerial, len(r), len(r))
+		for i, item := range r {
+			serials[i] = item.ToSerial()
+		}
+		jsonFormatPrint(serials)
+	case []api.PeerHealth:
+		r := resp.([]api.PeerHealth)
+		serials := make([]api.PeerHealthSerial, len(r), len(r))
+		for i, item := range r {
+			serials[i] = item.ToSerial()
+		}
+		jsonFormatPrint(serials)
+	case []api.Job:
+		r := resp.([]api.Job)
+		serials := make([]api.JobSerial, len(r), len(r))
+		for i, item := range r {
+			serials[i] = item.ToSerial()
+		}
+		jsonFormatPrint(serials)
+	case []api.Metric:
+		r := resp.([]api.Metric)
+		serials := make([]api.MetricSerial, len(r), len(r))
+		for i, item := range r {
+			serials[i] = item.ToSerial()
+		}
+		jsonFormatPrint(serials)
+	case []api.StatusSummary:
+		r := resp.([]api.StatusSummary)
+		serials := make([]api.StatusSummarySerial, len(r), len(r))
+		for i, item := range r {
+			serials[i] = item.ToSerial()
+		}
+		jsonFormatPrint(serials)
+	case []api.OrphanPins:
+		r := resp.([]api.OrphanPins)
+		serials := make([]api.OrphanPinsSerial, len(r), len(r))
+		for i, item := range r {
+			serials[i] = item.ToSerial()
+		}
+		jsonFormatPrint(serials)
+	case []api.FailureDomainInfo:
+		r := resp.([]api.FailureDomainInfo)
+		serials := make([]api.FailureDomainInfoSerial, len(r), len(r))
+		for i, item := range r {
+			serials[i] = item.ToSerial()
+		}
+		jsonFormatPrint(serials)
 	default:
 		checkErr("", errors.New("unsupported type returned"))
 	}
@@ -67,6 +148,15 @@ func textFormatObject(resp interface{}) {
 	case api.GlobalPinInfo:
 		serial := resp.(api.GlobalPinInfo).ToSerial()
 		textFormatPrintGPInfo(&serial)
+	case api.PinDetail:
+		serial := resp.(api.PinDetail).ToSerial()
+		textFormatPrintPinDetail(&serial)
+	case api.DAGStats:
+		serial := resp.(api.DAGStats).ToSerial()
+		textFormatPrintDAGStats(&serial)
+	case api.Job:
+		serial := resp.(api.Job).ToSerial()
+		textFormatPrintJob(&serial)
 	case api.Pin:
 		serial := resp.(api.Pin).ToSerial()
 		textFormatPrintPin(&serial)
@@ -76,6 +166,41 @@ func textFormatObject(resp interface{}) {
 	case api.Error:
 		serial := resp.(api.Error)
 		textFormatPrintError(&serial)
+	case api.ConsensusState:
+		serial := resp.(api.ConsensusState).ToSerial()
+		textFormatPrintConsensusState(&serial)
+	case api.StateRepair:
+		serial := resp.(api.StateRepair)
+		textFormatPrintStateRepair(&serial)
+	case api.PeerRemoval:
+		serial := resp.(api.PeerRemoval)
+		textFormatPrintPeerRemoval(&serial)
+	case api.SecretRotation:
+		serial := resp.(api.SecretRotation).ToSerial()
+		textFormatPrintSecretRotation(&serial)
+	case api.SharedConfig:
+		serial := resp.(api.SharedConfig).ToSerial()
+		textFormatPrintSharedConfig(&serial)
+	case api.MultiaddrsSerial:
+		for _, a := range resp.(api.MultiaddrsSerial) {
+			fmt.Println(a)
+		}
+	case api.PinsDiff:
+		serial := resp.(api.PinsDiff).ToSerial()
+		textFormatPrintPinsDiff(&serial)
+	case api.PinsetChanges:
+		serial := resp.(api.PinsetChanges).ToSerial()
+		textFormatPrintPinsetChanges(&serial)
+	case api.Faults:
+		serial := resp.(api.Faults).ToSerial()
+		textFormatPrintFaults(&serial)
+	case api.ResourceUsage:
+		serial := resp.(api.ResourceUsage)
+		textFormatPrintResourceUsage(&serial)
+	case []peer.ID:
+		for _, p := range resp.([]peer.ID) {
+			fmt.Println(p.Pretty())
+		}
 	case []api.ID:
 		for _, item := range resp.([]api.ID) {
 			textFormatObject(item)
@@ -89,6 +214,40 @@ func textFormatObject(resp interface{}) {
 		for _, item := range resp.([]api.Pin) {
 			textFormatObject(item)
 		}
+	case []api.RPCCallStats:
+		for _, item := range resp.([]api.RPCCallStats) {
+			serial := item.ToSerial()
+			textFormatPrintRPCCallStats(&serial)
+		}
+	case []api.PeerHealth:
+		for _, item := range resp.([]api.PeerHealth) {
+			serial := item.ToSerial()
+			textFormatPrintPeerHealth(&serial)
+		}
+	case []api.Job:
+		for _, item := range resp.([]api.Job) {
+			serial := item.ToSerial()
+			textFormatPrintJob(&serial)
+		}
+	case []api.Metric:
+		for _, item := range resp.([]api.Metric) {
+			textFormatPrintMetric(&item)
+		}
+	case []api.StatusSummary:
+		for _, item := range resp.([]api.StatusSummary) {
+			serial := item.ToSerial()
+			textFormatPrintStatusSummary(&serial)
+		}
+	case []api.OrphanPins:
+		for _, item := range resp.([]api.OrphanPins) {
+			serial := item.ToSerial()
+			textFormatPrintOrphanPins(&serial)
+		}
+	case []api.FailureDomainInfo:
+		for _, item := range resp.([]api.FailureDomainInfo) {
+			serial := item.ToSerial()
+			textFormatPrintFailureDomainInfo(&serial)
+		}
 	default:
 		checkErr("", errors.New("unsupported type returned"))
 	}
@@ -100,16 +259,47 @@ func textFormatPrintIDSerial(obj *api.IDSerial) {
 		return
 	}
 
-	fmt.Printf("%s | %s | Sees %d other peers\n", obj.ID, obj.Peername, len(obj.ClusterPeers)-1)
 	addrs := make(sort.StringSlice, 0, len(obj.Addresses))
 	for _, a := range obj.Addresses {
 		addrs = append(addrs, string(a))
 	}
 	addrs.Sort()
+
+	// Locally-known peer information only carries
+	// the addresses, the last heartbeat and metrics.
+	if obj.Version == "" {
+		fmt.Printf("%s | Last heartbeat: %s\n", obj.ID, heartbeatString(obj.LastHeartbeat))
+		if len(obj.Metrics) > 0 {
+			fmt.Printf("  > Health: %s\n", metricsString(obj.Metrics))
+		}
+		fmt.Println("  > Addresses:")
+		for _, a := range addrs {
+			fmt.Printf("    - %s\n", a)
+		}
+		return
+	}
+
+	fmt.Printf("%s | %s | Sees %d other peers\n", obj.ID, obj.Peername, len(obj.ClusterPeers)-1)
+	if obj.LastHeartbeat != "" {
+		fmt.Printf("  > Last heartbeat: %s\n", heartbeatString(obj.LastHeartbeat))
+	}
+	if len(obj.Metrics) > 0 {
+		fmt.Printf("  > Health: %s\n", metricsString(obj.Metrics))
+	}
+	if len(obj.Tags) > 0 {
+		fmt.Printf("  > Tags: %s\n", metricsString(obj.Tags))
+	}
+	if obj.FetchedAt != "" {
+		fmt.Printf("  > Information from: %s\n", obj.FetchedAt)
+	}
 	fmt.Println("  > Addresses:")
 	for _, a := range addrs {
 		fmt.Printf("    - %s\n", a)
 	}
+	if !obj.IPFS.Reachable && obj.IPFS.Error != "" {
+		fmt.Printf("  > IPFS UNREACHABLE: %s\n", obj.IPFS.Error)
+		return
+	}
 	if obj.IPFS.Error != "" {
 		fmt.Printf("  > IPFS ERROR: %s\n", obj.IPFS.Error)
 		return
@@ -120,27 +310,128 @@ func textFormatPrintIDSerial(obj *api.IDSerial) {
 		ipfsAddrs = append(ipfsAddrs, string(a))
 	}
 	ipfsAddrs.Sort()
-	fmt.Printf("  > IPFS: %s\n", obj.IPFS.ID)
+	if obj.IPFS.AgentVersion != "" {
+		fmt.Printf("  > IPFS: %s (%s)\n", obj.IPFS.ID, obj.IPFS.AgentVersion)
+	} else {
+		fmt.Printf("  > IPFS: %s\n", obj.IPFS.ID)
+	}
 	for _, a := range ipfsAddrs {
 		fmt.Printf("    - %s\n", a)
 	}
 }
 
+func heartbeatString(t string) string {
+	if t == "" {
+		return "unknown"
+	}
+	ts, err := time.Parse(time.RFC3339, t)
+	if err != nil {
+		return t
+	}
+	return fmt.Sprintf("%s (%s ago)", t, time.Since(ts).Truncate(time.Second))
+}
+
+// metricsString formats peer metrics as "name: value" pairs
+// sorted by name.
+func metricsString(metrics map[string]string) string {
+	names := make(sort.StringSlice, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	names.Sort()
+	values := make([]string, len(names), len(names))
+	for i, name := range names {
+		values[i] = fmt.Sprintf("%s: %s", name, metrics[name])
+	}
+	return strings.Join(values, " | ")
+}
+
 func textFormatPrintGPInfo(obj *api.GlobalPinInfoSerial) {
-	fmt.Printf("%s :\n", obj.Cid)
+	if obj.Timestamp != "" {
+		fmt.Printf("%s : (pinned %s)\n", obj.Cid, obj.Timestamp)
+	} else {
+		fmt.Printf("%s :\n", obj.Cid)
+	}
+	if obj.CidV1 != "" && obj.CidV1 != obj.Cid {
+		fmt.Printf("    CIDv1: %s\n", obj.CidV1)
+	}
 	peers := make(sort.StringSlice, 0, len(obj.PeerMap))
 	for k := range obj.PeerMap {
 		peers = append(peers, k)
 	}
 	peers.Sort()
 
+	if obj.Match != nil && !*obj.Match {
+		fmt.Printf("    ! Allocation mismatch: allocated to %d peers, pinned by %d\n",
+			len(obj.Allocations), len(obj.PinnedBy))
+	}
+
 	for _, k := range peers {
 		v := obj.PeerMap[k]
 		if v.Error != "" {
 			fmt.Printf("    > Peer %s : ERROR | %s\n", k, v.Error)
 			continue
 		}
-		fmt.Printf("    > Peer %s : %s | %s\n", k, strings.ToUpper(v.Status), v.TS)
+		status := strings.ToUpper(v.Status)
+		if v.IPFSPinStatus != "" {
+			status = fmt.Sprintf("%s (%s)", status, v.IPFSPinStatus)
+		}
+		if v.Size > 0 {
+			fmt.Printf("    > Peer %s : %s | %s | %d bytes\n", k, status, v.TS, v.Size)
+			continue
+		}
+		fmt.Printf("    > Peer %s : %s | %s\n", k, status, v.TS)
+	}
+}
+
+func textFormatPrintPinDetail(obj *api.PinDetailSerial) {
+	if obj.Pin != nil {
+		textFormatPrintPin(obj.Pin)
+	} else {
+		fmt.Printf("%s | not part of the shared state\n", obj.Cid)
+	}
+	textFormatPrintGPInfo(&obj.GlobalPinInfoSerial)
+
+	peers := make(sort.StringSlice, 0, len(obj.PeerMap))
+	for k := range obj.PeerMap {
+		peers = append(peers, k)
+	}
+	peers.Sort()
+	for _, k := range peers {
+		history := obj.PeerMap[k].History
+		if len(history) == 0 {
+			continue
+		}
+		fmt.Printf("    > Peer %s history:\n", k)
+		for _, sc := range history {
+			if sc.Error != "" {
+				fmt.Printf("        - %s | %s | %s\n", sc.TS, strings.ToUpper(sc.Status), sc.Error)
+				continue
+			}
+			fmt.Printf("        - %s | %s\n", sc.TS, strings.ToUpper(sc.Status))
+		}
+	}
+}
+
+func textFormatPrintDAGStats(obj *api.DAGStatsSerial) {
+	fmt.Printf("%s (walked by %s):\n", obj.Cid, obj.Peer)
+	fmt.Printf("  > Blocks: %d\n", obj.Blocks)
+	fmt.Printf("  > Total size: %d bytes\n", obj.TotalSize)
+	fmt.Printf("  > Depth: %d\n", obj.Depth)
+	fmt.Printf("  > Largest block: %s (%d bytes)\n", obj.LargestBlock, obj.LargestBlockSize)
+}
+
+func textFormatPrintJob(obj *api.JobSerial) {
+	fmt.Printf("%s | %s | %s | %d/%d | started: %s", obj.ID, obj.Type, obj.Status, obj.Done, obj.Total, obj.Started)
+	if obj.Finished != "" {
+		fmt.Printf(" | finished: %s", obj.Finished)
+	}
+	fmt.Println()
+	if obj.Error != "" {
+		fmt.Printf("  > ERROR: %s\n", obj.Error)
+	}
+	for _, gpi := range obj.Result {
+		textFormatPrintGPInfo(&gpi)
 	}
 }
 
@@ -160,16 +451,276 @@ func textFormatPrintVersion(obj *api.Version) {
 
 func textFormatPrintPin(obj *api.PinSerial) {
 	fmt.Printf("%s | %s | ", obj.Cid, obj.Name)
+	if obj.Group != "" {
+		fmt.Printf("Group: %s | ", obj.Group)
+	}
+	if obj.Raw {
+		fmt.Printf("Raw | ")
+	}
 
-	if obj.ReplicationFactorMin < 0 {
-		fmt.Printf("Repl. Factor: -1 | Allocations: [everywhere]\n")
+	if obj.ReplicationFactorMin < 0 && obj.Group == "" {
+		fmt.Printf("Repl. Factor: -1 | Allocations: [everywhere]")
 	} else {
 		var sortAlloc sort.StringSlice = obj.Allocations
 		sortAlloc.Sort()
-		fmt.Printf("Repl. Factor: %d--%d | Allocations: %s\n",
+		fmt.Printf("Repl. Factor: %d--%d | Allocations: %s",
 			obj.ReplicationFactorMin, obj.ReplicationFactorMax,
 			sortAlloc)
 	}
+
+	if obj.Timestamp != "" {
+		fmt.Printf(" | Added: %s", obj.Timestamp)
+		if obj.AddedBy != "" {
+			fmt.Printf(" by %s", obj.AddedBy)
+		}
+	}
+	fmt.Println()
+}
+
+func textFormatPrintPinsDiff(obj *api.PinsDiffSerial) {
+	for _, p := range obj.Added {
+		fmt.Printf("+ ")
+		textFormatPrintPin(&p)
+	}
+	for _, p := range obj.Removed {
+		fmt.Printf("- ")
+		textFormatPrintPin(&p)
+	}
+	for _, ch := range obj.Changed {
+		fmt.Printf("~ %s | Changed: %s\n", ch.After.Cid, strings.Join(ch.Fields, ", "))
+		fmt.Printf("    before: ")
+		textFormatPrintPin(&ch.Before)
+		fmt.Printf("    after:  ")
+		textFormatPrintPin(&ch.After)
+	}
+	fmt.Printf("%d added, %d removed, %d changed\n",
+		len(obj.Added), len(obj.Removed), len(obj.Changed))
+}
+
+func textFormatPrintPinsetChanges(obj *api.PinsetChangesSerial) {
+	if obj.Resync {
+		fmt.Printf("Changes no longer available: read the pinset again and follow changes from index %d in epoch %s\n", obj.LastIndex, obj.Epoch)
+		return
+	}
+	fmt.Printf("Epoch %s, last index %d\n", obj.Epoch, obj.LastIndex)
+	for _, ch := range obj.Changes {
+		switch ch.Type {
+		case api.PinsetChangeAdd:
+			fmt.Printf("%d + ", ch.Index)
+		default:
+			fmt.Printf("%d - ", ch.Index)
+		}
+		textFormatPrintPin(&ch.Pin)
+	}
+}
+
+func textFormatPrintFaults(obj *api.FaultsSerial) {
+	if obj.Until == "" {
+		fmt.Println("No faults injected")
+		return
+	}
+	delay := obj.RPCDelay
+	if delay == "" {
+		delay = "none"
+	}
+	fmt.Printf("IPFS errors: %t | RPC delay: %s | Drop metrics: %t | Until: %s\n",
+		obj.IPFSErrors, delay, obj.DropMetrics, obj.Until)
+}
+
+func textFormatPrintConsensusState(obj *api.ConsensusStateSerial) {
+	leader := obj.Leader
+	if leader == "" {
+		leader = "none"
+	}
+	fmt.Printf("Leader: %s | Term: %d | Applied index: %d | Quorum: %t\n",
+		leader, obj.Term, obj.AppliedIndex, obj.HasQuorum)
+	fmt.Printf("  > Leader changes: %d", obj.LeaderChanges)
+	if obj.LastLeaderChange != "" {
+		fmt.Printf(" (last: %s)", obj.LastLeaderChange)
+	}
+	fmt.Println()
+	fmt.Printf("  > Commit latency: %s | Apply latency: %s\n",
+		time.Duration(obj.CommitLatency), time.Duration(obj.ApplyLatency))
+	fmt.Printf("  > Commit index: %d | Log entries: %d | Snapshots: %d\n",
+		obj.CommitIndex, obj.LogEntries, obj.Snapshots)
+	var voters sort.StringSlice = obj.Voters
+	voters.Sort()
+	fmt.Println("  > Voters:")
+	for _, v := range voters {
+		fmt.Printf("    - %s\n", v)
+	}
+	if len(obj.NonVoters) > 0 {
+		var nonVoters sort.StringSlice = obj.NonVoters
+		nonVoters.Sort()
+		fmt.Println("  > Non-voters:")
+		for _, v := range nonVoters {
+			fmt.Printf("    - %s\n", v)
+		}
+	}
+}
+
+func textFormatPrintStateRepair(obj *api.StateRepair) {
+	if obj.Diverged {
+		fmt.Println("The local state diverged from the leader's and has been repaired")
+	} else {
+		fmt.Println("The local state matches the leader's")
+	}
+	fmt.Printf("  > Local: %s | %d pins | Applied index: %d\n",
+		obj.Local.Checksum, obj.Local.Pins, obj.Local.AppliedIndex)
+	fmt.Printf("  > Leader: %s | %d pins | Applied index: %d\n",
+		obj.Authoritative.Checksum, obj.Authoritative.Pins, obj.Authoritative.AppliedIndex)
+}
+
+func textFormatPrintPeerRemoval(obj *api.PeerRemoval) {
+	for _, w := range obj.Warnings {
+		fmt.Printf("Warning: %s\n", w)
+	}
+}
+
+func textFormatPrintSecretRotation(obj *api.SecretRotationSerial) {
+	fmt.Printf("The cluster secret was updated in %d peers:\n", len(obj.Updated))
+	sort.Strings(obj.Updated)
+	for _, p := range obj.Updated {
+		fmt.Printf("  > %s\n", p)
+	}
+	if len(obj.Failed) > 0 {
+		fmt.Printf("These peers failed to update it:\n")
+		failed := make([]string, 0, len(obj.Failed))
+		for p := range obj.Failed {
+			failed = append(failed, p)
+		}
+		sort.Strings(failed)
+		for _, p := range failed {
+			fmt.Printf("  > %s: %s\n", p, obj.Failed[p])
+		}
+	}
+	fmt.Println("Restart all cluster peers for the new secret to be used.")
+}
+
+func textFormatPrintSharedConfig(obj *api.SharedConfigSerial) {
+	orLocal := func(v string) string {
+		if v == "" || v == "0" {
+			return "(local)"
+		}
+		return v
+	}
+	fmt.Printf("Replication factor min: %s\n", orLocal(fmt.Sprint(obj.ReplicationFactorMin)))
+	fmt.Printf("Replication factor max: %s\n", orLocal(fmt.Sprint(obj.ReplicationFactorMax)))
+	fmt.Printf("Allocator: %s\n", orLocal(obj.Allocator))
+	fmt.Printf("State sync interval: %s\n", orLocal(obj.StateSyncInterval))
+	fmt.Printf("Paused: %t\n", obj.Paused)
+}
+
+func textFormatPrintRPCCallStats(obj *api.RPCCallStatsSerial) {
+	fmt.Printf("%s | %s | %d calls | Last: %s\n", obj.Peer, obj.Method, obj.Count, obj.Last)
+}
+
+func textFormatPrintResourceUsage(obj *api.ResourceUsage) {
+	fmt.Printf("Goroutines: %d\n", obj.Goroutines)
+	for _, u := range obj.Components {
+		limit := "none"
+		if u.Limit > 0 {
+			limit = strconv.Itoa(u.Limit)
+		}
+		fmt.Printf("  > %s: %d active | %d queued | Limit: %s | %d rejected\n",
+			u.Component, u.Active, u.Queued, limit, u.Rejected)
+	}
+}
+
+func textFormatPrintPeerHealth(obj *api.PeerHealthSerial) {
+	state := "ALIVE"
+	if !obj.Alive {
+		state = "DOWN"
+	}
+	heartbeat := obj.LastHeartbeat
+	if heartbeat == "" {
+		heartbeat = "none"
+	}
+	fmt.Printf("%s | %s | Last heartbeat: %s", obj.Peer, state, heartbeat)
+	names := make([]string, 0, len(obj.Metrics))
+	for name := range obj.Metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf(" | %s: %s", name, obj.Metrics[name])
+	}
+	fmt.Println()
+}
+
+func textFormatPrintMetric(obj *api.Metric) {
+	fmt.Printf("%s | %s: %s", obj.Peer.Pretty(), obj.Name, obj.Value)
+	if obj.Unallocatable {
+		fmt.Printf(" (unallocatable)")
+	}
+	if obj.Received > 0 {
+		age := time.Since(time.Unix(0, obj.Received)).Truncate(time.Second)
+		fmt.Printf(" | Received: %s ago", age)
+	}
+	fmt.Printf(" | Expires: %s\n", time.Unix(0, obj.Expire).UTC().Format(time.RFC3339))
+}
+
+func textFormatPrintStatusSummary(obj *api.StatusSummarySerial) {
+	if obj.Error != "" {
+		fmt.Printf("%s : ERROR | %s\n", obj.Peer, obj.Error)
+		return
+	}
+	statuses := make([]string, 0, len(obj.Counts))
+	for st := range obj.Counts {
+		statuses = append(statuses, st)
+	}
+	sort.Strings(statuses)
+	counts := make([]string, len(statuses), len(statuses))
+	for i, st := range statuses {
+		counts[i] = fmt.Sprintf("%s: %d", strings.ToUpper(st), obj.Counts[st])
+	}
+	if len(counts) == 0 {
+		fmt.Printf("%s : no items\n", obj.Peer)
+		return
+	}
+	fmt.Printf("%s : %s\n", obj.Peer, strings.Join(counts, " | "))
+}
+
+func textFormatPrintFailureDomainInfo(obj *api.FailureDomainInfoSerial) {
+	if obj.Concentrated {
+		fmt.Printf("%s : ! CONCENTRATED in a single domain\n", obj.Cid)
+	} else {
+		fmt.Printf("%s : pinned in %d domains\n", obj.Cid, len(obj.Domains))
+	}
+	domains := make(sort.StringSlice, 0, len(obj.Domains))
+	for d := range obj.Domains {
+		domains = append(domains, d)
+	}
+	domains.Sort()
+	for _, d := range domains {
+		name := d
+		if name == "" {
+			name = "(untagged)"
+		}
+		fmt.Printf("    > %s : %s\n", name, strings.Join(obj.Domains[d], ", "))
+	}
+}
+
+func textFormatPrintOrphanPins(obj *api.OrphanPinsSerial) {
+	var action string
+	switch api.OrphanAction(obj.Action) {
+	case api.OrphanActionAdopt:
+		action = " (adopted)"
+	case api.OrphanActionRemove:
+		action = " (removed)"
+	}
+
+	if obj.Error != "" && len(obj.Orphans) == 0 {
+		fmt.Printf("%s : ERROR | %s\n", obj.Peer, obj.Error)
+		return
+	}
+	fmt.Printf("%s : %d orphan pins%s\n", obj.Peer, len(obj.Orphans), action)
+	for _, c := range obj.Orphans {
+		fmt.Printf("  - %s\n", c)
+	}
+	if obj.Error != "" {
+		fmt.Printf("  > ERROR: %s\n", obj.Error)
+	}
 }
 
 func textFormatPrintError(obj *api.Error) {
@@ -2,10 +2,14 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net/http"
 	"os"
 	"strings"
 	"time"
@@ -15,6 +19,7 @@ import (
 
 	cid "github.com/ipfs/go-cid"
 	logging "github.com/ipfs/go-log"
+	crypto "github.com/libp2p/go-libp2p-crypto"
 	peer "github.com/libp2p/go-libp2p-peer"
 	ma "github.com/multiformats/go-multiaddr"
 	cli "github.com/urfave/cli"
@@ -32,6 +37,12 @@ var (
 	defaultUsername      = ""
 	defaultPassword      = ""
 	defaultWaitCheckFreq = time.Second
+	defaultMetricNames   = []string{"freespace", "numpin", "ping"}
+
+	// pin import retries and back-pressure handling
+	importRetries        = 5
+	importRetryDelay     = time.Second
+	maxBackPressureDelay = 5 * time.Second
 )
 
 var logger = logging.Logger("cluster-ctl")
@@ -51,7 +62,10 @@ Cluster server is assumed to be %s, but can be
 configured with the --host option. To use the secure libp2p-http
 API endpoint, use "--host" with the full cluster libp2p listener
 address (including the "/ipfs/<peerID>" part), and --secret (the
-32-byte cluster secret as it appears in the cluster configuration).
+32-byte cluster secret as it appears in the cluster configuration, which
+can also be set with the CLUSTER_SECRET environment variable). This endpoint
+is served on the cluster peer's own libp2p host, so no additional TCP port
+needs to be opened to manage a remote peer.
 
 For feedback, bug reports or any additional information, visit
 https://github.com/ipfs/ipfs-cluster.
@@ -90,9 +104,10 @@ func main() {
 			Usage: "Cluster's HTTP or LibP2P-HTTP API endpoint",
 		},
 		cli.StringFlag{
-			Name:  "secret",
-			Value: "",
-			Usage: "cluster secret (32 byte pnet-key) as needed. Only when using the LibP2P endpoint",
+			Name:   "secret",
+			Value:  "",
+			Usage:  "cluster secret (32 byte pnet-key) as needed. Only when using the LibP2P endpoint",
+			EnvVar: "CLUSTER_SECRET",
 		},
 		cli.BoolFlag{
 			Name:  "https, s",
@@ -102,6 +117,14 @@ func main() {
 			Name:  "no-check-certificate",
 			Usage: "do not verify server TLS certificate. only valid with --https flag",
 		},
+		cli.StringFlag{
+			Name:  "client-cert",
+			Usage: "path to a client TLS certificate for APIs which require one. only valid with --https flag",
+		},
+		cli.StringFlag{
+			Name:  "client-key",
+			Usage: "path to the private key for --client-cert",
+		},
 		cli.StringFlag{
 			Name:  "encoding, enc",
 			Value: "text",
@@ -122,9 +145,15 @@ func main() {
 requires authorization. implies --https, which you can disable with --force-http`,
 			EnvVar: "CLUSTER_CREDENTIALS",
 		},
+		cli.StringFlag{
+			Name: "api-key",
+			Usage: `API key for servers which authenticate clients with api_keys. takes
+precedence over --basic-auth. implies --https, which you can disable with --force-http`,
+			EnvVar: "CLUSTER_API_KEY",
+		},
 		cli.BoolFlag{
 			Name:  "force-http, f",
-			Usage: "force HTTP. only valid when using BasicAuth",
+			Usage: "force HTTP. only valid when using BasicAuth or an API key",
 		},
 	}
 
@@ -163,6 +192,8 @@ requires authorization. implies --https, which you can disable with --force-http
 
 		cfg.SSL = c.Bool("https")
 		cfg.NoVerifyCert = c.Bool("no-check-certificate")
+		cfg.ClientCertFile = c.String("client-cert")
+		cfg.ClientKeyFile = c.String("client-key")
 		user, pass := parseCredentials(c.String("basic-auth"))
 		cfg.Username = user
 		cfg.Password = pass
@@ -170,6 +201,11 @@ requires authorization. implies --https, which you can disable with --force-http
 			logger.Warning("SSL automatically enabled with basic auth credentials. Set \"force-http\" to disable")
 			cfg.SSL = true
 		}
+		cfg.APIKey = c.String("api-key")
+		if cfg.APIKey != "" && !cfg.SSL && !c.Bool("force-http") {
+			logger.Warning("SSL automatically enabled with an API key. Set \"force-http\" to disable")
+			cfg.SSL = true
+		}
 
 		enc := c.String("encoding")
 		if enc != "text" && enc != "json" {
@@ -205,10 +241,46 @@ This command displays information about the peer that the tool is contacting
 					Usage: "list the nodes participating in the IPFS Cluster",
 					Description: `
 This command provides a list of the ID information of all the peers in the Cluster.
+
+For clusters with many peers, the --offset and --limit options allow to list
+them in pages (peers are then sorted by ID). The --local option lists only the
+information known by the peer receiving the request (addresses and last
+heartbeat) without contacting every other peer.
+
+The ID information of peers is cached by the peer receiving the request, so
+that unresponsive peers do not slow down the listing. The --refresh option
+asks every peer for its current ID information instead.
 `,
-					Flags:     []cli.Flag{},
+					Flags: []cli.Flag{
+						cli.IntFlag{
+							Name:  "offset",
+							Usage: "skip this many peers",
+						},
+						cli.IntFlag{
+							Name:  "limit",
+							Usage: "list at most this many peers",
+						},
+						cli.BoolFlag{
+							Name:  "local",
+							Usage: "only list locally-known addresses and last heartbeats",
+						},
+						cli.BoolFlag{
+							Name:  "refresh",
+							Usage: "do not use cached peer information",
+						},
+					},
 					ArgsUsage: " ",
 					Action: func(c *cli.Context) error {
+						if c.Int("offset") > 0 || c.Int("limit") > 0 || c.Bool("local") || c.Bool("refresh") {
+							resp, cerr := globalClient.QueryPeers(api.PeersQuery{
+								Offset:  c.Int("offset"),
+								Limit:   c.Int("limit"),
+								Local:   c.Bool("local"),
+								Refresh: c.Bool("refresh"),
+							})
+							formatResponse(c, resp, cerr)
+							return nil
+						}
 						resp, cerr := globalClient.Peers()
 						formatResponse(c, resp, cerr)
 						return nil
@@ -222,18 +294,161 @@ This command removes a peer from the cluster. If the peer is online, it will
 automatically shut down. All other cluster peers should be online for the
 operation to succeed, otherwise some nodes may be left with an outdated list of
 cluster peers.
+
+The removal is refused when the remaining peers which are alive would not be
+enough to sustain a consensus quorum, as the cluster would be left unable to
+make any changes. Use --force to remove the peer anyway.
 `,
 					ArgsUsage: "<peer ID>",
-					Flags:     []cli.Flag{},
+					Flags: []cli.Flag{
+						cli.BoolFlag{
+							Name:  "force",
+							Usage: "remove the peer even if it breaks the quorum",
+						},
+					},
 					Action: func(c *cli.Context) error {
 						pid := c.Args().First()
 						p, err := peer.IDB58Decode(pid)
 						checkErr("parsing peer ID", err)
-						cerr := globalClient.PeerRm(p)
+						resp, cerr := globalClient.PeerRm(p, c.Bool("force"))
+						formatResponse(c, resp, cerr)
+						return nil
+					},
+				},
+				{
+					Name:  "rotate",
+					Usage: "replace the ID of a peer by a new one",
+					Description: `
+This command is used when a cluster peer has changed its private key (see
+"ipfs-cluster-service rotate-key"). All the pins allocated to the old peer ID
+are re-allocated to the new one, so that the content already pinned by the
+peer's IPFS daemon is not re-pinned elsewhere. Then the old peer ID is
+removed from the cluster. The rotated peer can then be started with the new
+key and join the cluster. The rest of the cluster must have quorum for this
+operation to succeed.
+`,
+					ArgsUsage: "<old peer ID> <new peer ID>",
+					Action: func(c *cli.Context) error {
+						oldID, err := peer.IDB58Decode(c.Args().Get(0))
+						checkErr("parsing old peer ID", err)
+						newID, err := peer.IDB58Decode(c.Args().Get(1))
+						checkErr("parsing new peer ID", err)
+						cerr := globalClient.PeerRotate(oldID, newID)
+						formatResponse(c, nil, cerr)
+						return nil
+					},
+				},
+				{
+					Name:  "leader",
+					Usage: "make a peer the consensus leader",
+					Description: `
+This command asks the current consensus leader to hand leadership over to the
+given peer. It can be used to move the leader off a machine before taking it
+down for maintenance. Other peers are briefly demoted during the transfer, so
+all peers should be online for the operation to succeed.
+`,
+					ArgsUsage: "<peer ID>",
+					Action: func(c *cli.Context) error {
+						p, err := peer.IDB58Decode(c.Args().First())
+						checkErr("parsing peer ID", err)
+						cerr := globalClient.TransferLeadership(p)
 						formatResponse(c, nil, cerr)
 						return nil
 					},
 				},
+				{
+					Name:  "addresses",
+					Usage: "list and fix the addresses known for other peers",
+					Description: `
+These commands manage the addresses that the peer receiving the request uses
+to reach the rest of cluster peers (its peerstore). They allow replacing stale
+addresses without restarting the peer or editing its peerstore file, which is
+updated too. The cluster peerset is not modified.
+`,
+					Subcommands: []cli.Command{
+						{
+							Name:      "ls",
+							Usage:     "list the known addresses of cluster peers",
+							ArgsUsage: " ",
+							Action: func(c *cli.Context) error {
+								resp, cerr := globalClient.PeerstoreAddresses()
+								formatResponse(c, api.MultiaddrsToSerial(resp), cerr)
+								return nil
+							},
+						},
+						{
+							Name:      "add",
+							Usage:     "add an address for a cluster peer",
+							ArgsUsage: "<multiaddress including /ipfs/<peer ID>>",
+							Action: func(c *cli.Context) error {
+								addr, err := ma.NewMultiaddr(c.Args().First())
+								checkErr("parsing multiaddress", err)
+								cerr := globalClient.PeerstoreAdd(addr)
+								formatResponse(c, nil, cerr)
+								return nil
+							},
+						},
+						{
+							Name:      "rm",
+							Usage:     "forget all the addresses of a cluster peer",
+							ArgsUsage: "<peer ID>",
+							Action: func(c *cli.Context) error {
+								p, err := peer.IDB58Decode(c.Args().First())
+								checkErr("parsing peer ID", err)
+								cerr := globalClient.PeerstoreRm(p)
+								formatResponse(c, nil, cerr)
+								return nil
+							},
+						},
+					},
+				},
+				{
+					Name:  "blacklist",
+					Usage: "list and manage the blacklisted peers",
+					Description: `
+These commands manage the peers blacklisted by the peer receiving the request.
+It refuses to add blacklisted peers, to answer their requests and to include
+them in the consensus peerset, so that a removed peer cannot rejoin through a
+stale bootstrap entry. The blacklist is saved to the peer_blacklist_file and
+is not shared with the rest of the cluster: it should be set on every peer.
+`,
+					Subcommands: []cli.Command{
+						{
+							Name:      "ls",
+							Usage:     "list the blacklisted peers",
+							ArgsUsage: " ",
+							Action: func(c *cli.Context) error {
+								resp, cerr := globalClient.PeerBlacklist()
+								formatResponse(c, resp, cerr)
+								return nil
+							},
+						},
+						{
+							Name:      "add",
+							Usage:     "blacklist a peer, removing it from the cluster",
+							ArgsUsage: "<peer ID>",
+							Action: func(c *cli.Context) error {
+								p, err := peer.IDB58Decode(c.Args().First())
+								checkErr("parsing peer ID", err)
+								cerr := globalClient.PeerBlacklistAdd(p)
+								formatResponse(c, nil, cerr)
+								return nil
+							},
+						},
+						{
+							Name:      "rm",
+							Usage:     "take a peer out of the blacklist",
+							ArgsUsage: "<peer ID>",
+							Action: func(c *cli.Context) error {
+								p, err := peer.IDB58Decode(c.Args().First())
+								checkErr("parsing peer ID", err)
+								cerr := globalClient.PeerBlacklistRm(p)
+								formatResponse(c, nil, cerr)
+								return nil
+							},
+						},
+					},
+				},
 			},
 		},
 		{
@@ -253,7 +468,17 @@ in the cluster and should be part of the list offered by "pin ls".
 
 An optional replication factor can be provided: -1 means "pin everywhere"
 and 0 means use cluster's default setting. Positive values indicate how many
-peers should pin this content.
+peers should pin this content. Content pinned everywhere is pinned by all
+current peers and by any peer joining the cluster later, regardless of the
+default replication factors. Setting -1 as either --rmin or --rmax is enough.
+
+The --group flag restricts the allocations to the members of one of the peer
+groups defined in the cluster configuration. In this case, -1 means pinning
+in all the members of the group.
+
+The --raw flag marks the CID as raw blocks or IPLD nodes which are not part of
+a UnixFS DAG. Their size is then obtained with "block stat" instead of
+"object stat", which only works with UnixFS content.
 `,
 					ArgsUsage: "<CID>",
 					Flags: []cli.Flag{
@@ -277,6 +502,16 @@ peers should pin this content.
 							Value: "",
 							Usage: "Sets a name for this pin",
 						},
+						metadataFlag("Sets a metadata entry for this pin (can be repeated)"),
+						cli.StringFlag{
+							Name:  "group, g",
+							Value: "",
+							Usage: "Restricts allocations to the members of this peer group",
+						},
+						cli.BoolFlag{
+							Name:  "raw",
+							Usage: "Marks the CID as raw blocks or IPLD nodes (not UnixFS)",
+						},
 						cli.BoolFlag{
 							Name:  "no-status, ns",
 							Usage: "Prevents fetching pin status after pinning (faster, quieter)",
@@ -290,6 +525,7 @@ peers should pin this content.
 							Value: 0,
 							Usage: "How long to --wait (in seconds), default is indefinitely",
 						},
+						signKeyFlag(),
 					},
 					Action: func(c *cli.Context) error {
 						cidStr := c.Args().First()
@@ -304,7 +540,38 @@ peers should pin this content.
 							rplMax = rpl
 						}
 
-						cerr := globalClient.Pin(ci, rplMin, rplMax, c.String("name"))
+						metadata := parseMetadataFlag(c)
+						group := c.String("group")
+						if group != "" && len(metadata) > 0 {
+							checkErr("", errors.New("--metadata cannot be used with --group"))
+						}
+
+						raw := c.Bool("raw")
+						if raw && (group != "" || len(metadata) > 0) {
+							checkErr("", errors.New("--raw cannot be used with --group or --metadata"))
+						}
+
+						var cerr error
+						if key := loadSignKey(c); key != nil {
+							if len(metadata) > 0 {
+								checkErr("", errors.New("--metadata cannot be used with --sign-key"))
+							}
+							if group != "" {
+								checkErr("", errors.New("--group cannot be used with --sign-key"))
+							}
+							if raw {
+								checkErr("", errors.New("--raw cannot be used with --sign-key"))
+							}
+							cerr = globalClient.PinSigned(ci, rplMin, rplMax, c.String("name"), key)
+						} else if group != "" {
+							cerr = globalClient.PinInGroup(ci, rplMin, rplMax, c.String("name"), group)
+						} else if len(metadata) > 0 {
+							cerr = globalClient.PinWithMetadata(ci, rplMin, rplMax, c.String("name"), metadata)
+						} else if raw {
+							cerr = globalClient.PinRaw(ci, rplMin, rplMax, c.String("name"))
+						} else {
+							cerr = globalClient.Pin(ci, rplMin, rplMax, c.String("name"))
+						}
 						if cerr != nil {
 							formatResponse(c, nil, cerr)
 							return nil
@@ -328,9 +595,23 @@ trigger unpinning operations in all the IPFS nodes holding the content.
 When the request has succeeded, the command returns the status of the CID
 in the cluster. The CID should disappear from the list offered by "pin ls",
 although unpinning operations in the cluster may take longer or fail.
+
+Instead of a CID, the --name and --metadata flags can be used to unpin all
+the pins matching them at once. Values ending in "*" match by prefix
+(i.e. --name "dataset-*"). In this case, the list of unpinned items is
+returned. Use --dry-run to only show which pins would be removed.
 `,
-					ArgsUsage: "<CID>",
+					ArgsUsage: "[CID]",
 					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "name, n",
+							Usage: "unpin all pins with this name (when no CID is given)",
+						},
+						metadataFlag("unpin all pins with this metadata entry (can be repeated)"),
+						cli.BoolFlag{
+							Name:  "dry-run",
+							Usage: "only list the pins that would be removed by --name or --metadata",
+						},
 						cli.BoolFlag{
 							Name:  "no-status, ns",
 							Usage: "Prevents fetching pin status after unpinning (faster, quieter)",
@@ -344,12 +625,31 @@ although unpinning operations in the cluster may take longer or fail.
 							Value: 0,
 							Usage: "How long to --wait (in seconds), default is indefinitely",
 						},
+						signKeyFlag(),
 					},
 					Action: func(c *cli.Context) error {
 						cidStr := c.Args().First()
+						metadata := parseMetadataFlag(c)
+						if cidStr == "" && (c.String("name") != "" || len(metadata) > 0) {
+							resp, cerr := globalClient.UnpinMatching(
+								api.PinQuery{
+									Name:     c.String("name"),
+									Metadata: metadata,
+								},
+								c.Bool("dry-run"),
+							)
+							formatResponse(c, resp, cerr)
+							return nil
+						}
+
 						ci, err := cid.Decode(cidStr)
 						checkErr("parsing cid", err)
-						cerr := globalClient.Unpin(ci)
+						var cerr error
+						if key := loadSignKey(c); key != nil {
+							cerr = globalClient.UnpinSigned(ci, key)
+						} else {
+							cerr = globalClient.Unpin(ci)
+						}
 						if cerr != nil {
 							formatResponse(c, nil, cerr)
 							return nil
@@ -363,6 +663,116 @@ although unpinning operations in the cluster may take longer or fail.
 						return nil
 					},
 				},
+				{
+					Name:  "prefetch",
+					Usage: "Fetch a CID in its allocated peers without pinning it",
+					Description: `
+This command asks the peers to which a CID would be allocated to fetch all
+its blocks, without pinning it and without adding it to the cluster state. It
+allows staging content close to where it will be pinned or served later. CIDs
+which are already pinned are fetched by their current allocations.
+
+The content is fetched in the background: the command returns the peers which
+started fetching it. Prefetched blocks may be garbage collected by IPFS until
+the CID is pinned.
+`,
+					ArgsUsage: "<CID>",
+					Flags: []cli.Flag{
+						cli.IntFlag{
+							Name:  "replication, r",
+							Value: 0,
+							Usage: "Sets a custom replication factor (overrides -rmax and -rmin)",
+						},
+						cli.IntFlag{
+							Name:  "replication-min, rmin",
+							Value: 0,
+							Usage: "Sets the minimum replication factor",
+						},
+						cli.IntFlag{
+							Name:  "replication-max, rmax",
+							Value: 0,
+							Usage: "Sets the maximum replication factor",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						ci, err := cid.Decode(c.Args().First())
+						checkErr("parsing cid", err)
+
+						rplMin := c.Int("replication-min")
+						rplMax := c.Int("replication-max")
+						if rpl := c.Int("replication"); rpl != 0 {
+							rplMin = rpl
+							rplMax = rpl
+						}
+
+						resp, cerr := globalClient.Prefetch(ci, rplMin, rplMax)
+						formatResponse(c, resp, cerr)
+						return nil
+					},
+				},
+				{
+					Name:  "changes",
+					Usage: "List changes to the pinset",
+					Description: `
+This command lists the pins added to and removed from the shared state by
+the peer after the given --since index, allowing external systems (search
+indexes, billing...) to follow the cluster pinset incrementally. Change
+indexes are assigned by the peer which is contacted and start again when it
+restarts: changes must always be followed from the same peer, giving the
+--epoch returned with them, which changes on every restart.
+
+When the changes are no longer available (or the index or epoch are unknown
+to the peer), the pinset should be read again with "pin ls" and changes
+followed from the given last index and epoch.
+
+--wait makes the peer hold the request until there are changes, for up to the
+given time. With --follow, changes are printed as they happen.
+`,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "epoch",
+							Usage: "epoch of the --since index",
+						},
+						cli.Uint64Flag{
+							Name:  "since",
+							Value: 0,
+							Usage: "only list changes after this index",
+						},
+						cli.IntFlag{
+							Name:  "limit",
+							Value: 0,
+							Usage: "list at most this many changes (0 for all)",
+						},
+						cli.DurationFlag{
+							Name:  "wait",
+							Value: 0,
+							Usage: "wait up to this time for new changes",
+						},
+						cli.BoolFlag{
+							Name:  "follow, f",
+							Usage: "keep printing changes as they happen",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						epoch := c.String("epoch")
+						since := c.Uint64("since")
+						wait := c.Duration("wait")
+						if c.Bool("follow") && wait == 0 {
+							wait = 30 * time.Second
+						}
+						for {
+							resp, cerr := globalClient.PinsetChanges(epoch, since, c.Int("limit"), wait)
+							formatResponse(c, resp, cerr)
+							if !c.Bool("follow") || cerr != nil || resp.Resync {
+								return nil
+							}
+							epoch = resp.Epoch
+							if len(resp.Changes) > 0 {
+								since = resp.Changes[len(resp.Changes)-1].Index
+							}
+						}
+					},
+				},
 				{
 					Name:  "ls",
 					Usage: "List tracked CIDs",
@@ -372,16 +782,44 @@ which peers they are currently allocated. This list does not include
 any monitoring information about the IPFS status of the CIDs, it
 merely represents the list of pins which are part of the shared state of
 the cluster. For IPFS-status information about the pins, use "status".
+
+The --name and --metadata flags allow to only list the pins with the
+given name or metadata values. Values ending in "*" match by prefix
+(i.e. --name "backup-2017-*"). Results can be paginated with --offset
+and --limit.
 `,
 					ArgsUsage: "[CID]",
-					Flags:     []cli.Flag{},
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "name, n",
+							Usage: "only list pins with this name",
+						},
+						metadataFlag("only list pins with this metadata entry (can be repeated)"),
+						cli.IntFlag{
+							Name:  "offset",
+							Usage: "skip this many pins when searching by name or metadata",
+						},
+						cli.IntFlag{
+							Name:  "limit",
+							Usage: "list at most this many pins when searching by name or metadata",
+						},
+					},
 					Action: func(c *cli.Context) error {
 						cidStr := c.Args().First()
+						metadata := parseMetadataFlag(c)
 						if cidStr != "" {
 							ci, err := cid.Decode(cidStr)
 							checkErr("parsing cid", err)
 							resp, cerr := globalClient.Allocation(ci)
 							formatResponse(c, resp, cerr)
+						} else if c.String("name") != "" || len(metadata) > 0 || c.Int("offset") > 0 || c.Int("limit") > 0 {
+							resp, cerr := globalClient.SearchPins(api.PinQuery{
+								Name:     c.String("name"),
+								Metadata: metadata,
+								Offset:   c.Int("offset"),
+								Limit:    c.Int("limit"),
+							})
+							formatResponse(c, resp, cerr)
 						} else {
 							resp, cerr := globalClient.Allocations()
 							formatResponse(c, resp, cerr)
@@ -389,6 +827,231 @@ the cluster. For IPFS-status information about the pins, use "status".
 						return nil
 					},
 				},
+				{
+					Name:  "diff",
+					Usage: "Compare an exported state with the current pinset",
+					Description: `
+This command compares a state file produced by "ipfs-cluster-service state
+export" with the current pinset of the cluster and lists the pins which have
+been added, removed or changed since the export, along with the options that
+changed. It can be used to verify the pinset after an upgrade or for audits.
+Two exported files can be compared offline with "ipfs-cluster-service state
+diff".
+`,
+					ArgsUsage: "<exported state file>",
+					Action: func(c *cli.Context) error {
+						f, err := os.Open(c.Args().First())
+						checkErr("reading state file", err)
+						defer f.Close()
+
+						var serials []api.PinSerial
+						err = json.NewDecoder(f).Decode(&serials)
+						checkErr("parsing state file", err)
+						before := make([]api.Pin, len(serials), len(serials))
+						for i, s := range serials {
+							before[i] = s.ToPin()
+						}
+
+						current, cerr := globalClient.Allocations()
+						if cerr != nil {
+							formatResponse(c, nil, cerr)
+							return nil
+						}
+						formatResponse(c, api.DiffPins(before, current), nil)
+						return nil
+					},
+				},
+				{
+					Name:  "export",
+					Usage: "Save the pinset to a file",
+					Description: `
+This command writes the pinset of the cluster (every CID with its name,
+replication factors, allocations and metadata) to the given file, or to the
+standard output when no file is given. The format is the same as the one used
+by "ipfs-cluster-service state export", so the result can be used with
+"pin diff", "pin import" or "ipfs-cluster-service state import".
+`,
+					ArgsUsage: "[file]",
+					Action: func(c *cli.Context) error {
+						pins, cerr := globalClient.Allocations()
+						if cerr != nil {
+							formatResponse(c, nil, cerr)
+							return nil
+						}
+						serials := make([]api.PinSerial, len(pins), len(pins))
+						for i, p := range pins {
+							serials[i] = p.ToSerial()
+						}
+
+						w := os.Stdout
+						if path := c.Args().First(); path != "" {
+							f, err := os.Create(path)
+							checkErr("creating export file", err)
+							defer f.Close()
+							w = f
+						}
+						enc := json.NewEncoder(w)
+						enc.SetIndent("", "    ")
+						checkErr("writing pinset", enc.Encode(serials))
+						if w != os.Stdout {
+							out("%d pins exported\n", len(serials))
+						}
+						return nil
+					},
+				},
+				{
+					Name:  "import",
+					Usage: "Pin the CIDs in a file produced by \"pin export\"",
+					Description: `
+This command pins every CID listed in a file produced by "pin export" (or by
+"ipfs-cluster-service state export") using the cluster API, keeping their
+names, replication factors, peer groups and metadata. It allows migrating
+pinsets between clusters without touching their consensus data.
+
+Allocations are decided by the destination cluster: those in the file are
+ignored, as well as the time at which the items were originally pinned. CIDs
+which are already pinned with the same options are left untouched. Failed
+pins are reported and the rest of them are still imported. When the cluster
+reports back-pressure, pins are sent more slowly.
+
+Signatures cannot be carried over, as they are only valid for a short time
+and their nonces may have been used already. Signed pins are skipped unless
+--sign-key is given, in which case every pin is signed again with that key.
+
+The API only creates recursive pins, so direct pins are skipped unless
+--async is used: jobs keep the type of the imported pins. With --async, the
+pins are sent at once and imported by a job in the contacted peer (see
+"jobs").
+`,
+					ArgsUsage: "<file>",
+					Flags: []cli.Flag{
+						cli.BoolFlag{
+							Name:  "async",
+							Usage: "import the pins in a background job",
+						},
+						signKeyFlag(),
+					},
+					Action: func(c *cli.Context) error {
+						f, err := os.Open(c.Args().First())
+						checkErr("reading import file", err)
+						defer f.Close()
+
+						var serials []api.PinSerial
+						err = json.NewDecoder(f).Decode(&serials)
+						checkErr("parsing import file", err)
+
+						pins, failed := importablePins(serials, loadSignKey(c))
+
+						if c.Bool("async") {
+							if len(pins) == 0 {
+								checkErr("", errors.New("there are no pins to import"))
+							}
+							req := api.JobRequest{Type: api.JobTypeImport}
+							for _, pin := range pins {
+								req.Pins = append(req.Pins, pin.ToSerial())
+							}
+							resp, cerr := globalClient.StartJob(req)
+							formatResponse(c, resp, cerr)
+							return nil
+						}
+
+						for _, pin := range pins {
+							if !pin.Recursive {
+								out("skipping %s: direct pins can only be imported with --async\n", pin.Cid)
+								failed++
+								continue
+							}
+							if cerr := importPin(pin); cerr != nil {
+								out("error pinning %s: %s\n", pin.Cid, cerr)
+								failed++
+							}
+						}
+						out("%d pins imported, %d failed\n", len(serials)-failed, failed)
+						if failed > 0 {
+							os.Exit(1)
+						}
+						return nil
+					},
+				},
+				{
+					Name:  "info",
+					Usage: "Show the shared state entry and the status of a CID",
+					Description: `
+This command shows, in a single request, what the cluster is supposed to do
+with a CID (its entry in the shared state: allocations, replication factors,
+metadata and when it was added) and what the cluster peers are actually doing
+with it (its status in every peer, as shown by "status"). The last status
+transitions of the CID in every peer are listed too, which helps spotting
+items which flap between pinned and error.
+`,
+					ArgsUsage: "<CID>",
+					Action: func(c *cli.Context) error {
+						cidStr := c.Args().First()
+						ci, err := cid.Decode(cidStr)
+						checkErr("parsing cid", err)
+						resp, cerr := globalClient.PinDetail(ci)
+						formatResponse(c, resp, cerr)
+						return nil
+					},
+				},
+				{
+					Name:  "dag",
+					Usage: "Show statistics about the DAG of a pinned CID",
+					Description: `
+This command walks the DAG under a pinned CID in the IPFS daemon of one of the
+peers it is allocated to and shows the number of unique blocks in it, their
+total size, the depth of the DAG and its largest block.
+
+Walking large DAGs may take a while.
+`,
+					ArgsUsage: "<CID>",
+					Action: func(c *cli.Context) error {
+						cidStr := c.Args().First()
+						ci, err := cid.Decode(cidStr)
+						checkErr("parsing cid", err)
+						resp, cerr := globalClient.DAGStats(ci)
+						formatResponse(c, resp, cerr)
+						return nil
+					},
+				},
+				{
+					Name:  "orphans",
+					Usage: "List pins on IPFS daemons which are not tracked by the cluster",
+					Description: `
+This command compares the pins of the IPFS daemon of every cluster peer with
+the shared state and lists those which are not part of it (orphan pins).
+
+With --adopt, the orphan pins are added to the cluster, preferentially
+allocating them to the peers where they were found. With --remove, they are
+unpinned from the IPFS daemons instead.
+`,
+					ArgsUsage: " ",
+					Flags: []cli.Flag{
+						localFlag(),
+						cli.BoolFlag{
+							Name:  "adopt",
+							Usage: "pin the orphan pins in the cluster",
+						},
+						cli.BoolFlag{
+							Name:  "remove",
+							Usage: "unpin the orphan pins from the IPFS daemons",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						action := api.OrphanActionNone
+						switch {
+						case c.Bool("adopt") && c.Bool("remove"):
+							checkErr("", errors.New("--adopt and --remove cannot be used together"))
+						case c.Bool("adopt"):
+							action = api.OrphanActionAdopt
+						case c.Bool("remove"):
+							action = api.OrphanActionRemove
+						}
+						resp, cerr := globalClient.OrphanPins(action, c.Bool("local"))
+						formatResponse(c, resp, cerr)
+						return nil
+					},
+				},
 			},
 		},
 		{
@@ -405,19 +1068,117 @@ with "sync".
 
 When the --local flag is passed, it will only fetch the status from the
 contacted cluster peer. By default, status will be fetched from all peers.
+
+When --partial-timeout is given, the command returns after the given
+duration with the replies received so far. Peers which have not answered
+are shown with the TIMED_OUT status.
+
+When --shard is given as "index/count" (i.e. "3/16"), only the items in
+that bucket out of "count" are shown. Items are spread in buckets by the
+FNV-1a hash of their CID. This allows to audit very large pinsets in
+chunks, i.e. running one command per bucket.
+
+When --summary is given, only the number of items in each status is
+shown for every peer. This is much cheaper than listing the status of
+every item on clusters with many pins.
+
+When --diff is given twice with two peer IDs, only the items whose status
+differs between those peers are shown. This is useful to verify that a peer
+has caught up with the rest after an outage.
+
+When --domains is given with a peer tag (i.e. "region", as set in the "tags"
+of the peers' configuration), the peers which have every item pinned are
+shown grouped by the value of that tag. Items pinned by several peers which
+all share the same value are flagged as concentrated: losing that failure
+domain would lose every replica.
 `,
 			ArgsUsage: "[CID]",
 			Flags: []cli.Flag{
 				localFlag(),
+				cli.BoolFlag{
+					Name:  "summary",
+					Usage: "only show how many items each peer has in each status",
+				},
+				cli.DurationFlag{
+					Name:  "partial-timeout, pt",
+					Value: 0,
+					Usage: "Return partial results after this long (i.e. 5s). Ignored with --local",
+				},
+				cli.StringSliceFlag{
+					Name:  "diff",
+					Usage: "only show items whose status differs between two peers (given twice)",
+				},
+				cli.StringFlag{
+					Name:  "domains",
+					Usage: "show how the peers pinning each item spread across the values of this peer tag",
+				},
+				cli.StringFlag{
+					Name:  "shard",
+					Usage: "only show the items in this bucket, given as index/count (i.e. 3/16)",
+				},
 			},
 			Action: func(c *cli.Context) error {
 				cidStr := c.Args().First()
+				if tag := c.String("domains"); tag != "" {
+					resp, cerr := globalClient.FailureDomains(tag)
+					formatResponse(c, resp, cerr)
+					return nil
+				}
+				if diffPeers := c.StringSlice("diff"); len(diffPeers) > 0 {
+					if len(diffPeers) != 2 {
+						checkErr("", errors.New("--diff needs exactly two peer IDs"))
+					}
+					p1, err := peer.IDB58Decode(diffPeers[0])
+					checkErr("parsing peer ID", err)
+					p2, err := peer.IDB58Decode(diffPeers[1])
+					checkErr("parsing peer ID", err)
+
+					var gpis []api.GlobalPinInfo
+					var cerr error
+					if cidStr != "" {
+						ci, err := cid.Decode(cidStr)
+						checkErr("parsing cid", err)
+						var gpi api.GlobalPinInfo
+						gpi, cerr = globalClient.Status(ci, false)
+						gpis = []api.GlobalPinInfo{gpi}
+					} else {
+						gpis, cerr = globalClient.StatusAll(false)
+					}
+					formatResponse(c, statusDiff(gpis, p1, p2), cerr)
+					return nil
+				}
+				if c.Bool("summary") {
+					resp, cerr := globalClient.StatusSummary(c.Bool("local"))
+					formatResponse(c, resp, cerr)
+					return nil
+				}
+				if shardStr := c.String("shard"); shardStr != "" {
+					shard, err := api.ParsePinShard(shardStr)
+					checkErr("parsing shard", err)
+					resp, cerr := globalClient.StatusShard(shard, c.Bool("local"))
+					formatResponse(c, resp, cerr)
+					return nil
+				}
+				partial := c.Duration("partial-timeout")
+				if c.Bool("local") {
+					partial = 0
+				}
 				if cidStr != "" {
 					ci, err := cid.Decode(cidStr)
 					checkErr("parsing cid", err)
+					if partial > 0 {
+						resp, cerr := globalClient.StatusPartial(ci, partial)
+						formatResponse(c, resp, cerr)
+						return nil
+					}
 					resp, cerr := globalClient.Status(ci, c.Bool("local"))
 					formatResponse(c, resp, cerr)
 				} else {
+					if partial > 0 {
+						resp, cerr := globalClient.StatusAllPartial(partial)
+						formatResponse(c, resp, cerr)
+						return nil
+					}
 					resp, cerr := globalClient.StatusAll(c.Bool("local"))
 					formatResponse(c, resp, cerr)
 				}
@@ -441,19 +1202,27 @@ CIDs in error state may be manually recovered with "recover".
 
 When the --local flag is passed, it will only trigger sync
 operations on the contacted peer. By default, all peers will sync.
+
+With --async, syncing every tracked CID runs as a job in the contacted peer
+and the command returns immediately. Use "jobs info" to follow it.
 `,
 			ArgsUsage: "[CID]",
 			Flags: []cli.Flag{
 				localFlag(),
+				asyncFlag(),
 			},
 			Action: func(c *cli.Context) error {
 				cidStr := c.Args().First()
-				if cidStr != "" {
+				switch {
+				case cidStr != "":
 					ci, err := cid.Decode(cidStr)
 					checkErr("parsing cid", err)
 					resp, cerr := globalClient.Sync(ci, c.Bool("local"))
 					formatResponse(c, resp, cerr)
-				} else {
+				case c.Bool("async") && !c.Bool("local"):
+					resp, cerr := globalClient.SyncAllAsync()
+					formatResponse(c, resp, cerr)
+				default:
 					resp, cerr := globalClient.SyncAll(c.Bool("local"))
 					formatResponse(c, resp, cerr)
 				}
@@ -473,20 +1242,57 @@ CIDs (without argument), it may take a considerably long time.
 
 When the --local flag is passed, it will only trigger recover
 operations on the contacted peer (as opposed to on every peer).
+
+When running on every tracked CID (--all or no argument), the contacted peer
+selects the items whose status in any peer matches --filter ("error" for any
+error status, or a status name like "pin_error") and recovers up to
+--concurrency of them at the same time. These flags have no effect with
+--local. With --async, this runs as a job in the contacted peer and the
+command returns immediately. Use "jobs info" to follow it.
 `,
 			ArgsUsage: "[CID]",
 			Flags: []cli.Flag{
 				localFlag(),
+				cli.BoolFlag{
+					Name:  "all",
+					Usage: "recover every tracked CID matching --filter",
+				},
+				cli.StringFlag{
+					Name:  "filter",
+					Value: "error",
+					Usage: "only recover items with this status in any peer",
+				},
+				cli.IntFlag{
+					Name:  "concurrency",
+					Value: 1,
+					Usage: "number of items recovered at the same time",
+				},
+				asyncFlag(),
 			},
 			Action: func(c *cli.Context) error {
 				cidStr := c.Args().First()
-				if cidStr != "" {
+				switch {
+				case cidStr != "" && c.Bool("all"):
+					checkErr("", errors.New("a CID and --all cannot be used together"))
+				case cidStr != "":
 					ci, err := cid.Decode(cidStr)
 					checkErr("parsing cid", err)
 					resp, cerr := globalClient.Recover(ci, c.Bool("local"))
 					formatResponse(c, resp, cerr)
-				} else {
-					resp, cerr := globalClient.RecoverAll(c.Bool("local"))
+				case c.Bool("local"):
+					resp, cerr := globalClient.RecoverAll(true)
+					formatResponse(c, resp, cerr)
+				case c.Bool("async"):
+					resp, cerr := globalClient.RecoverAllAsync(
+						c.String("filter"),
+						c.Int("concurrency"),
+					)
+					formatResponse(c, resp, cerr)
+				default:
+					resp, cerr := globalClient.RecoverAllMatching(
+						c.String("filter"),
+						c.Int("concurrency"),
+					)
 					formatResponse(c, resp, cerr)
 				}
 				return nil
@@ -552,6 +1358,381 @@ graph of the connections.  Output is a dot file encoding the cluster's connectio
 						return nil
 					},
 				},
+				{
+					Name:  "consensus",
+					Usage: "display the state of the consensus layer",
+					Description: `
+This command shows the consensus layer information as seen by the peer: the
+current leader, the raft term, the last applied index and which peers are
+voters. It allows to check whether the cluster has quorum.
+`,
+					Action: func(c *cli.Context) error {
+						resp, cerr := globalClient.ConsensusState()
+						formatResponse(c, resp, cerr)
+						return nil
+					},
+				},
+				{
+					Name:  "peers",
+					Usage: "show whether cluster peers are alive and their health metrics",
+					Description: `
+This command shows, for every cluster peer, whether the peer is alive
+according to the heartbeats received by the peer monitor, when the last
+heartbeat was produced and the latest values of the metrics which tell
+about its health. Peers which stop sending heartbeats are reported as
+DOWN and the leader re-allocates the content pinned in them.
+`,
+					Action: func(c *cli.Context) error {
+						resp, cerr := globalClient.PeerHealth()
+						formatResponse(c, resp, cerr)
+						return nil
+					},
+				},
+				{
+					Name:  "resources",
+					Usage: "show the goroutines and the work handled by each subsystem",
+					Description: `
+This command shows the number of goroutines running in the peer and, for
+its subsystems (broadcasts to other peers, pin tracker and IPFS proxy),
+the operations in progress, those waiting, the configured concurrency
+limit and the number of requests rejected because of it.
+`,
+					Action: func(c *cli.Context) error {
+						resp, cerr := globalClient.ResourceUsage()
+						formatResponse(c, resp, cerr)
+						return nil
+					},
+				},
+				{
+					Name:  "rpc",
+					Usage: "show the RPC requests received from other peers",
+					Description: `
+This command lists, for every other cluster peer and RPC method, how many
+requests the peer has received and when the last one arrived. It helps
+tracing peers which issue excessive or unexpected requests. Set
+"rpc_audit_log" in the cluster configuration to log every request.
+`,
+					Action: func(c *cli.Context) error {
+						resp, cerr := globalClient.RPCStats()
+						formatResponse(c, resp, cerr)
+						return nil
+					},
+				},
+				{
+					Name:      "metrics",
+					Usage:     "list the latest metrics received from every peer",
+					ArgsUsage: "[metric name]",
+					Description: `
+This command lists the latest valid metrics that the peer has received from
+every cluster peer, as used to allocate pins. When no metric name is given,
+the free space ("freespace"), pin count ("numpin") and heartbeat ("ping")
+metrics are shown. The age of each metric tells when the peer last heard
+from every other peer.
+`,
+					Action: func(c *cli.Context) error {
+						names := defaultMetricNames
+						if name := c.Args().First(); name != "" {
+							names = []string{name}
+						}
+						var resp []api.Metric
+						for _, name := range names {
+							metrics, cerr := globalClient.Metrics(name)
+							if cerr != nil {
+								formatResponse(c, nil, cerr)
+								return nil
+							}
+							resp = append(resp, metrics...)
+						}
+						formatResponse(c, resp, nil)
+						return nil
+					},
+				},
+				{
+					Name:  "repair",
+					Usage: "repair the shared state of the peer when it diverges",
+					Description: `
+This command compares the shared state (pinset) held by the peer with the
+one held by the consensus leader, using checksums. When they differ, the
+peer's state is replaced by the leader's and the pin tracker is
+reconciled with it. It is an alternative to wiping the peer's data folder.
+`,
+					Action: func(c *cli.Context) error {
+						resp, cerr := globalClient.RepairState()
+						formatResponse(c, resp, cerr)
+						return nil
+					},
+				},
+				{
+					Name:  "faults",
+					Usage: "show or inject simulated failures in the peer",
+					Description: `
+This command makes the peer simulate failures so that operators can rehearse
+failure handling and verify alerting in staging clusters: IPFS pin requests
+failing (--ipfs-errors), slow responses to other peers (--rpc-delay) and
+metrics not being published, so that other peers consider it down
+(--drop-metrics). Faults replace any previous ones and are cleared after
+--duration or with --clear. Without options, the current faults are shown.
+
+The peer must have "enable_fault_injection" set in its configuration.
+`,
+					Flags: []cli.Flag{
+						cli.BoolFlag{
+							Name:  "ipfs-errors",
+							Usage: "make IPFS pin, unpin and pin ls requests fail",
+						},
+						cli.DurationFlag{
+							Name:  "rpc-delay",
+							Usage: "delay the RPC requests received from other peers",
+						},
+						cli.BoolFlag{
+							Name:  "drop-metrics",
+							Usage: "stop publishing metrics and heartbeats",
+						},
+						cli.DurationFlag{
+							Name:  "duration",
+							Usage: "clear the faults after this time (default 10m)",
+						},
+						cli.BoolFlag{
+							Name:  "clear",
+							Usage: "clear the current faults",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						faults := api.Faults{
+							IPFSErrors:  c.Bool("ipfs-errors"),
+							RPCDelay:    c.Duration("rpc-delay"),
+							DropMetrics: c.Bool("drop-metrics"),
+							Duration:    c.Duration("duration"),
+						}
+						if !faults.Active() && !c.Bool("clear") {
+							resp, cerr := globalClient.Faults()
+							formatResponse(c, resp, cerr)
+							return nil
+						}
+						if c.Bool("clear") {
+							faults = api.Faults{}
+						}
+						cerr := globalClient.InjectFaults(faults)
+						formatResponse(c, nil, cerr)
+						return nil
+					},
+				},
+			},
+		},
+		{
+			Name:  "secret",
+			Usage: "Manage the cluster secret",
+			Subcommands: []cli.Command{
+				{
+					Name:  "rotate",
+					Usage: "replace the cluster secret in all peers",
+					Description: `
+This command distributes a new cluster secret (private network key) to all
+the cluster peers, which write it to their configuration. If any peer cannot
+receive it, the operation is aborted and no peer changes its secret. Peers
+which fail to switch to the new secret once all have received it are
+reported.
+
+Peers use the new secret for new connections as soon as they switch, without
+a restart. Existing connections are kept. A random secret is generated unless
+one is given with --secret.
+`,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "secret",
+							Usage: "hex-encoded 32-byte secret to use",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						resp, cerr := globalClient.RotateSecret(c.String("secret"))
+						formatResponse(c, resp, cerr)
+						return nil
+					},
+				},
+			},
+		},
+		{
+			Name:  "jobs",
+			Usage: "Follow long-running operations started with --async",
+			Description: `
+Long-running operations (recovering or syncing every tracked CID, importing
+pins) can run as jobs when using the --async flag. Rebalancing allocations
+only runs as a job. Jobs are kept in memory by the peer that runs them:
+these commands must contact that same peer.
+`,
+			Subcommands: []cli.Command{
+				{
+					Name:  "rebalance",
+					Usage: "Start a job which rebalances the allocations of every pin",
+					Description: `
+This command starts a job which re-allocates every pin (except those pinned
+everywhere), replacing current allocations with better ranked peers as
+described by the "rebalance_allocations" and "allocation_hysteresis" options,
+which must be enabled in the contacted peer. Use "jobs info" to follow it.
+`,
+					ArgsUsage: " ",
+					Action: func(c *cli.Context) error {
+						resp, cerr := globalClient.StartJob(api.JobRequest{
+							Type: api.JobTypeRebalance,
+						})
+						formatResponse(c, resp, cerr)
+						return nil
+					},
+				},
+				{
+					Name:      "ls",
+					Usage:     "List the jobs of the contacted peer",
+					ArgsUsage: " ",
+					Action: func(c *cli.Context) error {
+						resp, cerr := globalClient.Jobs()
+						formatResponse(c, resp, cerr)
+						return nil
+					},
+				},
+				{
+					Name:      "info",
+					Usage:     "Show the progress and results of a job",
+					ArgsUsage: "<job ID>",
+					Action: func(c *cli.Context) error {
+						id := c.Args().First()
+						if id == "" {
+							checkErr("", errors.New("a job ID is needed"))
+						}
+						resp, cerr := globalClient.Job(id)
+						formatResponse(c, resp, cerr)
+						return nil
+					},
+				},
+				{
+					Name:      "cancel",
+					Usage:     "Cancel a running job",
+					ArgsUsage: "<job ID>",
+					Action: func(c *cli.Context) error {
+						id := c.Args().First()
+						if id == "" {
+							checkErr("", errors.New("a job ID is needed"))
+						}
+						cerr := globalClient.CancelJob(id)
+						formatResponse(c, nil, cerr)
+						return nil
+					},
+				},
+			},
+		},
+		{
+			Name:  "pause",
+			Usage: "Stop all peers from pinning and unpinning on IPFS",
+			Description: `
+This command makes all cluster peers stop performing new pin and unpin
+operations on their IPFS daemons, i.e. while the daemons are being upgraded.
+Pins and unpins are still accepted and added to the shared state. Pending
+operations are performed after running "resume". Peers which are restarted
+while the cluster is paused stay paused.
+`,
+			ArgsUsage: " ",
+			Action: func(c *cli.Context) error {
+				cerr := globalClient.Pause()
+				formatResponse(c, nil, cerr)
+				return nil
+			},
+		},
+		{
+			Name:  "resume",
+			Usage: "Let all peers pin and unpin on IPFS again",
+			Description: `
+This command lets all cluster peers perform the pin and unpin operations
+which were queued after running "pause".
+`,
+			ArgsUsage: " ",
+			Action: func(c *cli.Context) error {
+				cerr := globalClient.Resume()
+				formatResponse(c, nil, cerr)
+				return nil
+			},
+		},
+		{
+			Name:  "config",
+			Usage: "Manage the configuration shared by all peers",
+			Subcommands: []cli.Command{
+				{
+					Name:  "show",
+					Usage: "show the cluster-wide configuration",
+					Description: `
+This command shows the settings which are stored in the shared state and
+used by all cluster peers. Settings which are not set are taken from the
+configuration file of each peer.
+`,
+					Action: func(c *cli.Context) error {
+						resp, cerr := globalClient.SharedConfig()
+						formatResponse(c, resp, cerr)
+						return nil
+					},
+				},
+				{
+					Name:  "set",
+					Usage: "modify the cluster-wide configuration",
+					Description: `
+This command modifies the settings stored in the shared state, which all
+cluster peers start using without editing their configuration files. Only
+the given settings are modified. Setting them to 0 (or "" for --allocator)
+makes peers use the value from their configuration file again.
+
+The replication factors apply to new pins which do not specify them. The
+allocator ("ascend" or "descend") decides how candidate peers are sorted
+according to the metrics of the informer used by the peers. The "rendezvous"
+allocator ignores metric values and sorts peers deterministically by hashing
+the CID along with the peer IDs.
+`,
+					Flags: []cli.Flag{
+						cli.IntFlag{
+							Name:  "replication-min, rmin",
+							Usage: "default replication factor minimum",
+						},
+						cli.IntFlag{
+							Name:  "replication-max, rmax",
+							Usage: "default replication factor maximum",
+						},
+						cli.StringFlag{
+							Name:  "allocator",
+							Usage: "allocator to use [ascend,descend,rendezvous]",
+						},
+						cli.DurationFlag{
+							Name:  "state-sync-interval",
+							Usage: "how often peers sync their state",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						var cfg api.SharedConfig
+						var fields []string
+						if c.IsSet("replication-min") {
+							cfg.ReplicationFactorMin = c.Int("replication-min")
+							fields = append(fields, api.SharedConfigReplicationFactorMin)
+						}
+						if c.IsSet("replication-max") {
+							cfg.ReplicationFactorMax = c.Int("replication-max")
+							fields = append(fields, api.SharedConfigReplicationFactorMax)
+						}
+						if c.IsSet("allocator") {
+							cfg.Allocator = c.String("allocator")
+							fields = append(fields, api.SharedConfigAllocator)
+						}
+						if c.IsSet("state-sync-interval") {
+							cfg.StateSyncInterval = c.Duration("state-sync-interval")
+							fields = append(fields, api.SharedConfigStateSyncInterval)
+						}
+						if len(fields) == 0 {
+							checkErr("setting the shared configuration", errors.New("no settings given"))
+						}
+						cerr := globalClient.SetSharedConfig(cfg, fields...)
+						if cerr != nil {
+							formatResponse(c, nil, cerr)
+							return nil
+						}
+						resp, cerr := globalClient.SharedConfig()
+						formatResponse(c, resp, cerr)
+						return nil
+					},
+				},
 			},
 		},
 		{
@@ -584,6 +1765,120 @@ func localFlag() cli.BoolFlag {
 	}
 }
 
+func asyncFlag() cli.BoolFlag {
+	return cli.BoolFlag{
+		Name:  "async",
+		Usage: "run as a job and return immediately (see \"jobs\")",
+	}
+}
+
+func signKeyFlag() cli.StringFlag {
+	return cli.StringFlag{
+		Name:  "sign-key",
+		Usage: "sign the request with the base64-encoded private key in the given file (for clusters with authorized publishers)",
+	}
+}
+
+func metadataFlag(usage string) cli.StringSliceFlag {
+	return cli.StringSliceFlag{
+		Name:  "metadata",
+		Usage: usage + ", in key=value form",
+	}
+}
+
+// parseMetadataFlag returns the key-value pairs given with --metadata.
+func parseMetadataFlag(c *cli.Context) map[string]string {
+	metadata := make(map[string]string)
+	for _, kv := range c.StringSlice("metadata") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			checkErr("parsing metadata", fmt.Errorf("%s is not in key=value form", kv))
+		}
+		metadata[parts[0]] = parts[1]
+	}
+	return metadata
+}
+
+// loadSignKey returns the private key given with --sign-key, or nil.
+func loadSignKey(c *cli.Context) crypto.PrivKey {
+	path := c.String("sign-key")
+	if path == "" {
+		return nil
+	}
+	b64, err := ioutil.ReadFile(path)
+	checkErr("reading signing key", err)
+	pkb, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b64)))
+	checkErr("decoding signing key", err)
+	key, err := crypto.UnmarshalPrivateKey(pkb)
+	checkErr("parsing signing key", err)
+	return key
+}
+
+// importablePins decodes the pins to import, ignoring their allocations.
+// Signed pins are signed again with the given key when there is one, and
+// skipped otherwise, as their signatures cannot be replayed. It returns
+// the pins and the number of skipped ones.
+func importablePins(serials []api.PinSerial, key crypto.PrivKey) ([]api.Pin, int) {
+	var pins []api.Pin
+	skipped := 0
+	for _, ps := range serials {
+		pin := ps.ToPin()
+		if pin.Cid == nil {
+			out("skipping bad CID: %s\n", ps.Cid)
+			skipped++
+			continue
+		}
+		pin.Allocations = nil
+		if key == nil {
+			if pin.Signer != nil {
+				out("skipping %s: signed pins need --sign-key to be signed again\n", pin.Cid)
+				skipped++
+				continue
+			}
+			pins = append(pins, pin)
+			continue
+		}
+
+		signed, err := pin.StripRequest().Sign(api.PinOpPin, key)
+		if err != nil {
+			out("skipping %s: error signing: %s\n", pin.Cid, err)
+			skipped++
+			continue
+		}
+		pins = append(pins, signed)
+	}
+	return pins, skipped
+}
+
+// importPin pins the given pin, retrying with a backoff when the cluster
+// answers with too many requests, and slowing down when it reports
+// back-pressure.
+func importPin(pin api.Pin) error {
+	delay := importRetryDelay
+	for i := 0; ; i++ {
+		pending, err := globalClient.PinWithOptions(pin)
+		if apiErr, ok := err.(*api.Error); ok && apiErr.Code == http.StatusTooManyRequests && i < importRetries {
+			time.Sleep(delay)
+			delay *= 2
+			continue
+		}
+		if err == nil && pending > 0 {
+			time.Sleep(backPressureDelay(pending))
+		}
+		return err
+	}
+}
+
+// backPressureDelay returns how long to wait before sending another pin
+// to a cluster with the given number of pending pin operations.
+func backPressureDelay(pending int) time.Duration {
+	d := time.Duration(pending) * time.Millisecond
+	if d > maxBackPressureDelay {
+		return maxBackPressureDelay
+	}
+	return d
+}
+
 func walkCommands(cmds []cli.Command, parentHelpName string) {
 	for _, c := range cmds {
 		h := c.HelpName
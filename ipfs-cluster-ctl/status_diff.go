@@ -0,0 +1,42 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/ipfs/ipfs-cluster/api"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+// statusDiff returns the items whose status differs between the two given
+// peers, including those which are only known to one of them. The PeerMap
+// of the returned items only contains the entries for those peers. Items
+// are sorted by CID.
+func statusDiff(gpis []api.GlobalPinInfo, p1, p2 peer.ID) []api.GlobalPinInfo {
+	diff := []api.GlobalPinInfo{}
+	for _, gpi := range gpis {
+		info1, ok1 := gpi.PeerMap[p1]
+		info2, ok2 := gpi.PeerMap[p2]
+		if ok1 && ok2 && info1.Status == info2.Status {
+			continue
+		}
+
+		peerMap := make(map[peer.ID]api.PinInfo)
+		if ok1 {
+			peerMap[p1] = info1
+		}
+		if ok2 {
+			peerMap[p2] = info2
+		}
+		diff = append(diff, api.GlobalPinInfo{
+			Cid:       gpi.Cid,
+			PeerMap:   peerMap,
+			Timestamp: gpi.Timestamp,
+		})
+	}
+
+	sort.Slice(diff, func(i, j int) bool {
+		return diff[i].Cid.String() < diff[j].Cid.String()
+	})
+	return diff
+}
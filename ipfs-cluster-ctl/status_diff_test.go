@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/ipfs/ipfs-cluster/api"
+
+	cid "github.com/ipfs/go-cid"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+func TestStatusDiff(t *testing.T) {
+	p1, _ := peer.IDB58Decode("QmXZrtE5jQwXNqCJMfHUTQkvhQ4ZAnqMnmzFMJfLewuabc")
+	p2, _ := peer.IDB58Decode("QmUZ13osndQ5uL4tPWHXe3iBgBgq9gfewcBMSCAuMBsDJ6")
+	p3, _ := peer.IDB58Decode("QmPGDFvBkgWhvzEK9qaTWrWurSwqXNmhnK3hgELPdZZNPa")
+	c1, _ := cid.Decode("QmP63DkAFEnDYNjDYBpyNDfttu1fvUw99x1brscPzpqmmq")
+	c2, _ := cid.Decode("QmP63DkAFEnDYNjDYBpyNDfttu1fvUw99x1brscPzpqmma")
+	c3, _ := cid.Decode("QmP63DkAFEnDYNjDYBpyNDfttu1fvUw99x1brscPzpqmmb")
+
+	gpis := []api.GlobalPinInfo{
+		{ // same status
+			Cid: c1,
+			PeerMap: map[peer.ID]api.PinInfo{
+				p1: {Status: api.TrackerStatusPinned},
+				p2: {Status: api.TrackerStatusPinned},
+				p3: {Status: api.TrackerStatusPinError},
+			},
+		},
+		{ // different status
+			Cid: c2,
+			PeerMap: map[peer.ID]api.PinInfo{
+				p1: {Status: api.TrackerStatusPinned},
+				p2: {Status: api.TrackerStatusPinning},
+				p3: {Status: api.TrackerStatusPinned},
+			},
+		},
+		{ // missing in p2
+			Cid: c3,
+			PeerMap: map[peer.ID]api.PinInfo{
+				p1: {Status: api.TrackerStatusPinned},
+			},
+		},
+	}
+
+	diff := statusDiff(gpis, p1, p2)
+	if len(diff) != 2 {
+		t.Fatalf("expected 2 differing items, got %d", len(diff))
+	}
+	for _, gpi := range diff {
+		if gpi.Cid.Equals(c1) {
+			t.Error("items with the same status should not be listed")
+		}
+		if _, ok := gpi.PeerMap[p3]; ok {
+			t.Error("only the compared peers should be listed")
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 	ipfscluster "github.com/ipfs/ipfs-cluster"
 	"github.com/ipfs/ipfs-cluster/api/rest"
 	"github.com/ipfs/ipfs-cluster/config"
+	"github.com/ipfs/ipfs-cluster/consensus/crdt"
 	"github.com/ipfs/ipfs-cluster/consensus/raft"
 	"github.com/ipfs/ipfs-cluster/informer/disk"
 	"github.com/ipfs/ipfs-cluster/informer/numpin"
@@ -22,6 +23,7 @@ type cfgs struct {
 	apiCfg       *rest.Config
 	ipfshttpCfg  *ipfshttp.Config
 	consensusCfg *raft.Config
+	crdtCfg      *crdt.Config
 	trackerCfg   *maptracker.Config
 	monCfg       *basic.Config
 	pubsubmonCfg *pubsubmon.Config
@@ -35,6 +37,7 @@ func makeConfigs() (*config.Manager, *cfgs) {
 	apiCfg := &rest.Config{}
 	ipfshttpCfg := &ipfshttp.Config{}
 	consensusCfg := &raft.Config{}
+	crdtCfg := &crdt.Config{}
 	trackerCfg := &maptracker.Config{}
 	monCfg := &basic.Config{}
 	pubsubmonCfg := &pubsubmon.Config{}
@@ -44,12 +47,13 @@ func makeConfigs() (*config.Manager, *cfgs) {
 	cfg.RegisterComponent(config.API, apiCfg)
 	cfg.RegisterComponent(config.IPFSConn, ipfshttpCfg)
 	cfg.RegisterComponent(config.Consensus, consensusCfg)
+	cfg.RegisterComponent(config.Consensus, crdtCfg)
 	cfg.RegisterComponent(config.PinTracker, trackerCfg)
 	cfg.RegisterComponent(config.Monitor, monCfg)
 	cfg.RegisterComponent(config.Monitor, pubsubmonCfg)
 	cfg.RegisterComponent(config.Informer, diskInfCfg)
 	cfg.RegisterComponent(config.Informer, numpinInfCfg)
-	return cfg, &cfgs{clusterCfg, apiCfg, ipfshttpCfg, consensusCfg, trackerCfg, monCfg, pubsubmonCfg, diskInfCfg, numpinInfCfg}
+	return cfg, &cfgs{clusterCfg, apiCfg, ipfshttpCfg, consensusCfg, crdtCfg, trackerCfg, monCfg, pubsubmonCfg, diskInfCfg, numpinInfCfg}
 }
 
 func saveConfig(cfg *config.Manager, force bool) {
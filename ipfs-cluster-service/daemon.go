@@ -3,18 +3,25 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 
+	floodsub "github.com/libp2p/go-floodsub"
 	host "github.com/libp2p/go-libp2p-host"
 	"github.com/urfave/cli"
 
 	ipfscluster "github.com/ipfs/ipfs-cluster"
 	"github.com/ipfs/ipfs-cluster/allocator/ascendalloc"
 	"github.com/ipfs/ipfs-cluster/allocator/descendalloc"
+	"github.com/ipfs/ipfs-cluster/allocator/rendezvousalloc"
+	"github.com/ipfs/ipfs-cluster/api"
 	"github.com/ipfs/ipfs-cluster/api/rest"
+	"github.com/ipfs/ipfs-cluster/consensus/crdt"
 	"github.com/ipfs/ipfs-cluster/consensus/raft"
 	"github.com/ipfs/ipfs-cluster/informer/disk"
 	"github.com/ipfs/ipfs-cluster/informer/numpin"
@@ -23,16 +30,26 @@ import (
 	"github.com/ipfs/ipfs-cluster/monitor/pubsubmon"
 	"github.com/ipfs/ipfs-cluster/pintracker/maptracker"
 	"github.com/ipfs/ipfs-cluster/pstoremgr"
+	"github.com/ipfs/ipfs-cluster/state"
+	"github.com/ipfs/ipfs-cluster/state/boltstate"
 	"github.com/ipfs/ipfs-cluster/state/mapstate"
 
 	ma "github.com/multiformats/go-multiaddr"
 )
 
+// parseBootstraps parses the values given to --bootstrap. Every value
+// may hold several comma-separated multiaddresses.
 func parseBootstraps(flagVal []string) (bootstraps []ma.Multiaddr) {
-	for _, a := range flagVal {
-		bAddr, err := ma.NewMultiaddr(a)
-		checkErr("error parsing bootstrap multiaddress (%s)", err, a)
-		bootstraps = append(bootstraps, bAddr)
+	for _, v := range flagVal {
+		for _, a := range strings.Split(v, ",") {
+			a = strings.TrimSpace(a)
+			if a == "" {
+				continue
+			}
+			bAddr, err := ma.NewMultiaddr(a)
+			checkErr("error parsing bootstrap multiaddress (%s)", err, a)
+			bootstraps = append(bootstraps, bAddr)
+		}
 	}
 	return
 }
@@ -66,10 +83,23 @@ func daemon(c *cli.Context) error {
 	err = cfgMgr.LoadJSONFromFile(configPath)
 	checkErr("loading configuration", err)
 
-	// Cleanup state if bootstrapping
+	// Cleanup state if bootstrapping. The CRDT pinset is merged with
+	// the one of the cluster instead.
 	raftStaging := false
-	if len(bootstraps) > 0 {
-		cleanupState(cfgs.consensusCfg)
+	var mergePins []api.Pin
+	if len(bootstraps) > 0 && c.String("consensus") == "raft" {
+		prevPins, err := previousPins()
+		if err != nil {
+			logger.Warningf("could not read the previous state: %s", err)
+		}
+		if len(prevPins) > 0 {
+			if c.Bool("merge-state") {
+				mergePins = prevPins
+			} else {
+				logger.Warningf("the previous state of this peer (%d pins) will be backed up and not be part of the joined cluster. Use --merge-state to add its pins", len(prevPins))
+			}
+		}
+		cleanupState(cfgs.clusterCfg, cfgs.consensusCfg)
 		raftStaging = true
 	}
 
@@ -77,10 +107,17 @@ func daemon(c *cli.Context) error {
 		cfgs.clusterCfg.LeaveOnShutdown = true
 	}
 
+	if c.Bool("clean-corrupted-state") {
+		cfgs.consensusCfg.CleanCorruptedState = true
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	cluster, err := createCluster(ctx, c, cfgs, raftStaging)
+	st, closeState := setupState(c.String("state"), consensusDataFolder(c.String("consensus"), cfgs))
+	defer closeState()
+
+	cluster, err := createCluster(ctx, c, cfgs, st, raftStaging)
 	checkErr("starting cluster", err)
 
 	// noop if no bootstraps
@@ -88,7 +125,7 @@ func daemon(c *cli.Context) error {
 	// and timeout. So this can happen in background and we
 	// avoid worrying about error handling here (since Cluster
 	// will realize).
-	go bootstrap(cluster, bootstraps)
+	go bootstrap(cluster, bootstraps, mergePins)
 
 	return handleSignals(cluster)
 }
@@ -97,6 +134,7 @@ func createCluster(
 	ctx context.Context,
 	c *cli.Context,
 	cfgs *cfgs,
+	st state.State,
 	raftStaging bool,
 ) (*ipfscluster.Cluster, error) {
 
@@ -112,32 +150,26 @@ func createCluster(
 	proxy, err := ipfshttp.NewConnector(cfgs.ipfshttpCfg)
 	checkErr("creating IPFS Connector component", err)
 
-	state := mapstate.NewMapState()
-
-	err = validateVersion(cfgs.clusterCfg, cfgs.consensusCfg)
-	checkErr("validating version", err)
-
-	raftcon, err := raft.NewConsensus(
-		host,
-		cfgs.consensusCfg,
-		state,
-		raftStaging,
-	)
-	checkErr("creating consensus component", err)
+	// Only one PubSub instance can run on a host, so it is shared
+	// by the components which need it.
+	var pubsub *floodsub.PubSub
+	if c.String("monitor") == "pubsub" || c.String("consensus") == "crdt" {
+		pubsub, err = floodsub.NewFloodSub(ctx, host)
+		checkErr("creating PubSub", err)
+	}
 
+	cons := setupConsensus(c.String("consensus"), host, pubsub, cfgs, st, raftStaging)
 	tracker := maptracker.NewMapPinTracker(cfgs.trackerCfg, host.ID())
-	mon := setupMonitor(c.String("monitor"), host, cfgs.monCfg, cfgs.pubsubmonCfg)
+	mon := setupMonitor(c.String("monitor"), host, pubsub, cfgs.monCfg, cfgs.pubsubmonCfg)
 	informer, alloc := setupAllocation(c.String("alloc"), cfgs.diskInfCfg, cfgs.numpinInfCfg)
 
-	ipfscluster.ReadyTimeout = cfgs.consensusCfg.WaitForLeaderTimeout + 5*time.Second
-
 	return ipfscluster.NewCluster(
 		host,
 		cfgs.clusterCfg,
-		raftcon,
+		cons,
 		api,
 		proxy,
-		state,
+		st,
 		tracker,
 		mon,
 		alloc,
@@ -145,15 +177,35 @@ func createCluster(
 	)
 }
 
-// bootstrap will bootstrap this peer to one of the bootstrap addresses
-// if there are any.
-func bootstrap(cluster *ipfscluster.Cluster, bootstraps []ma.Multiaddr) {
+// bootstrap will bootstrap this peer to the first of the bootstrap
+// addresses which it can join, if there are any. Once joined, the given
+// pins (from the previous state of this peer) are merged into the
+// cluster's shared state.
+func bootstrap(cluster *ipfscluster.Cluster, bootstraps []ma.Multiaddr, mergePins []api.Pin) {
 	for _, bstrap := range bootstraps {
 		logger.Infof("Bootstrapping to %s", bstrap)
-		err := cluster.Join(bstrap)
+		progress := make(chan api.PeerAddProgress)
+		go func() {
+			for p := range progress {
+				logger.Infof("bootstrap to %s: %s", bstrap, p.Stage)
+			}
+		}()
+		err := cluster.JoinWithProgress(bstrap, progress)
 		if err != nil {
 			logger.Errorf("bootstrap to %s failed: %s", bstrap, err)
+			continue
+		}
+		if len(mergePins) > 0 {
+			n, err := cluster.MergePins(mergePins)
+			if err != nil {
+				logger.Errorf("merging the previous state: %s", err)
+			}
+			logger.Infof("%d pins from the previous state added to the cluster", n)
 		}
+		return
+	}
+	if len(bootstraps) > 0 {
+		logger.Error("could not bootstrap to any of the given addresses")
 	}
 }
 
@@ -209,7 +261,7 @@ func setupAllocation(
 	numpinInfCfg *numpin.Config,
 ) (ipfscluster.Informer, ipfscluster.PinAllocator) {
 	switch name {
-	case "disk", "disk-freespace":
+	case "disk", "disk-freespace", "freespace":
 		informer, err := disk.NewInformer(diskInfCfg)
 		checkErr("creating informer", err)
 		return informer, descendalloc.NewAllocator()
@@ -221,8 +273,97 @@ func setupAllocation(
 		informer, err := numpin.NewInformer(numpinInfCfg)
 		checkErr("creating informer", err)
 		return informer, ascendalloc.NewAllocator()
+	case "rendezvous":
+		// metric values are ignored, but peers must still
+		// report valid metrics to be considered.
+		informer, err := numpin.NewInformer(numpinInfCfg)
+		checkErr("creating informer", err)
+		return informer, rendezvousalloc.NewAllocator()
+	default:
+		err := fmt.Errorf("unknown allocation strategy %q. Use one of disk-freespace, disk-reposize, numpin or rendezvous", name)
+		checkErr("setting up allocation", err)
+		return nil, nil
+	}
+}
+
+// setupConsensus returns the consensus component with the given name. It
+// also sets how long the cluster waits for it to be ready.
+func setupConsensus(
+	name string,
+	h host.Host,
+	pubsub *floodsub.PubSub,
+	cfgs *cfgs,
+	st state.State,
+	raftStaging bool,
+) ipfscluster.Consensus {
+	switch name {
+	case "raft":
+		err := validateVersion(cfgs.clusterCfg, cfgs.consensusCfg)
+		checkErr("validating version", err)
+
+		raftcon, err := raft.NewConsensus(
+			h,
+			cfgs.consensusCfg,
+			st,
+			raftStaging,
+		)
+		checkErr("creating consensus component", err)
+		warnConsensusStartTimeout(cfgs.clusterCfg, "raft.wait_for_leader_timeout", cfgs.consensusCfg.WaitForLeaderTimeout)
+		return raftcon
+	case "crdt":
+		crdtcon, err := crdt.NewConsensus(h, pubsub, cfgs.crdtCfg, st)
+		checkErr("creating consensus component", err)
+		warnConsensusStartTimeout(cfgs.clusterCfg, "crdt.sync_timeout", cfgs.crdtCfg.SyncTimeout)
+		return crdtcon
+	default:
+		err := fmt.Errorf("unknown consensus type %q. Use one of raft or crdt", name)
+		checkErr("", err)
+		return nil
+	}
+}
+
+// warnConsensusStartTimeout warns when the cluster gives up on the
+// consensus component before the component itself gives up.
+func warnConsensusStartTimeout(cfg *ipfscluster.Config, key string, t time.Duration) {
+	if cfg.ConsensusStartTimeout <= t {
+		logger.Warningf(
+			"cluster.consensus_start_timeout (%s) is not larger than %s (%s). The peer may fail to start while the consensus is still catching up",
+			cfg.ConsensusStartTimeout,
+			key,
+			t,
+		)
+	}
+}
+
+// consensusDataFolder returns the data folder of the consensus
+// component with the given name.
+func consensusDataFolder(name string, cfgs *cfgs) string {
+	if name == "crdt" {
+		return cfgs.crdtCfg.GetDataFolder()
+	}
+	return cfgs.consensusCfg.GetDataFolder()
+}
+
+// setupState returns the shared state implementation with the given name
+// and a function to close it once the peer has shut down. The "bolt"
+// state is stored in the consensus data folder, so it is backed up and
+// cleaned along with the rest of the consensus data.
+func setupState(name string, dataFolder string) (state.State, func()) {
+	switch name {
+	case "map":
+		return mapstate.NewMapState(), func() {}
+	case "bolt":
+		path := filepath.Join(dataFolder, boltstate.DefaultFileName)
+		st, err := boltstate.New(path)
+		checkErr("opening the state database", err)
+		return st, func() {
+			err := st.Close()
+			if err != nil {
+				logger.Error(err)
+			}
+		}
 	default:
-		err := errors.New("unknown allocation strategy")
+		err := fmt.Errorf("unknown state type %q. Use one of map or bolt", name)
 		checkErr("", err)
 		return nil, nil
 	}
@@ -231,6 +372,7 @@ func setupAllocation(
 func setupMonitor(
 	name string,
 	h host.Host,
+	pubsub *floodsub.PubSub,
 	basicCfg *basic.Config,
 	pubsubCfg *pubsubmon.Config,
 ) ipfscluster.PeerMonitor {
@@ -240,7 +382,7 @@ func setupMonitor(
 		checkErr("creating monitor", err)
 		return mon
 	case "pubsub":
-		mon, err := pubsubmon.New(h, pubsubCfg)
+		mon, err := pubsubmon.NewWithPubSub(h, pubsub, pubsubCfg)
 		checkErr("creating monitor", err)
 		return mon
 	default:
@@ -2,6 +2,8 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -24,6 +26,8 @@ const programName = `ipfs-cluster-service`
 const (
 	defaultAllocation = "disk-freespace"
 	defaultMonitor    = "pubsub"
+	defaultState      = "map"
+	defaultConsensus  = "raft"
 	defaultLogLevel   = "info"
 )
 
@@ -85,6 +89,10 @@ $ ipfs-cluster-service daemon
 Launch a peer and join existing cluster:
 
 $ ipfs-cluster-service daemon --bootstrap /ip4/192.168.1.2/tcp/9096/ipfs/QmPSoSaPXpyunaBwHs1rZBKYSqRV4bLRk32VGYLuvdrypL
+
+Several bootstrap addresses can be given (separated by commas or
+repeating the flag) or set in the CLUSTER_BOOTSTRAP environment
+variable. They are tried in order until one of them succeeds.
 `,
 	programName,
 	programName,
@@ -230,25 +238,46 @@ configuration.
 					Usage: "run necessary state migrations before starting cluster service",
 				},
 				cli.StringSliceFlag{
-					Name:  "bootstrap, j",
-					Usage: "join a cluster providing an existing peers multiaddress(es)",
+					Name:   "bootstrap, j",
+					EnvVar: "CLUSTER_BOOTSTRAP",
+					Usage:  "join a cluster providing existing peers multiaddresses (repeat the flag or separate them with commas). They are tried in order until one succeeds",
+				},
+				cli.BoolFlag{
+					Name:  "merge-state",
+					Usage: "when bootstrapping, add the pins in this peer's previous state to the joined cluster",
 				},
 				cli.BoolFlag{
 					Name:   "leave, x",
 					Usage:  "remove peer from cluster on exit. Overrides \"leave_on_shutdown\"",
 					Hidden: true,
 				},
+				cli.BoolFlag{
+					Name:  "clean-corrupted-state",
+					Usage: "back up and clean the consensus data folder if it is corrupted and cannot be recovered from a snapshot",
+				},
 				cli.StringFlag{
 					Name:  "alloc, a",
 					Value: defaultAllocation,
-					Usage: "allocation strategy to use [disk-freespace,disk-reposize,numpin].",
+					Usage: "allocation strategy to use [disk-freespace,disk-reposize,numpin,rendezvous].",
 				},
 				cli.StringFlag{
 					Name:   "monitor",
 					Value:  defaultMonitor,
-					Hidden: true,
+					EnvVar: "CLUSTER_MONITOR",
 					Usage:  "peer monitor to use [basic,pubsub].",
 				},
+				cli.StringFlag{
+					Name:   "consensus",
+					Value:  defaultConsensus,
+					EnvVar: "CLUSTER_CONSENSUS",
+					Usage:  "consensus component to use [raft,crdt]. \"crdt\" needs no leader and lets peers join and leave freely",
+				},
+				cli.StringFlag{
+					Name:   "state",
+					Value:  defaultState,
+					EnvVar: "CLUSTER_STATE",
+					Usage:  "shared state implementation to use [map,bolt]. \"bolt\" keeps the pinset on disk instead of in memory",
+				},
 			},
 			Action: daemon,
 		},
@@ -359,6 +388,49 @@ import.  If no argument is provided cluster will read json from stdin
 						return nil
 					},
 				},
+				{
+					Name:  "diff",
+					Usage: "compare an exported state with another one or with the current state",
+					Description: `
+This command compares two state files produced by "state export" and prints,
+as json, the pins which were added, removed or changed (along with the options
+which changed) from the first to the second. When only one file is given, it
+is compared with the current state of this peer. This allows, for example,
+to verify that the state is the same before and after an upgrade.
+`,
+					ArgsUsage: "<before.json> [<after.json>]",
+					Action: func(c *cli.Context) error {
+						if !c.Args().Present() {
+							checkErr("reading arguments", errors.New("an exported state file is needed"))
+						}
+
+						before, err := os.Open(c.Args().Get(0))
+						checkErr("reading state file", err)
+						defer before.Close()
+
+						var after io.ReadCloser
+						if afterFile := c.Args().Get(1); afterFile != "" {
+							after, err = os.Open(afterFile)
+							checkErr("reading state file", err)
+							defer after.Close()
+						} else {
+							err = locker.lock()
+							checkErr("acquiring execution lock", err)
+							defer locker.tryUnlock()
+						}
+
+						diff, err := stateDiff(before, after)
+						checkErr("comparing states", err)
+
+						enc := json.NewEncoder(os.Stdout)
+						enc.SetIndent("", "    ")
+						err = enc.Encode(diff.ToSerial())
+						checkErr("printing differences", err)
+						logger.Infof("%d pins added, %d removed and %d changed",
+							len(diff.Added), len(diff.Removed), len(diff.Changed))
+						return nil
+					},
+				},
 				{
 					Name:  "cleanup",
 					Usage: "cleanup persistent consensus state so cluster can start afresh",
@@ -384,12 +456,105 @@ the mth data folder (m currently defaults to 5)
 						err = cfgMgr.LoadJSONFromFile(configPath)
 						checkErr("reading configuration", err)
 
-						err = cleanupState(cfgs.consensusCfg)
+						err = cleanupState(cfgs.clusterCfg, cfgs.consensusCfg)
 						checkErr("Cleaning up consensus data", err)
 						logger.Warningf("the %s folder has been rotated.  Next start will use an empty state", cfgs.consensusCfg.GetDataFolder())
 						return nil
 					},
 				},
+				{
+					Name:  "compact",
+					Usage: "validate and compact the consensus data folder to reclaim disk space",
+					Description: `
+This command checks that the consensus data folder of a stopped peer is
+readable and rewrites the Raft log, keeping only the entries which are not
+included in the latest valid snapshot (plus the configured "trailing_logs").
+Unlike "cleanup", the state is preserved.
+
+With --prune, older snapshots, corrupted or unfinished snapshots and the
+backups of the data folder (<data-folder-name>.old.<n>) are removed too.
+`,
+					Flags: []cli.Flag{
+						cli.BoolFlag{
+							Name:  "prune",
+							Usage: "remove old snapshots and data folder backups",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						err := locker.lock()
+						checkErr("acquiring execution lock", err)
+						defer locker.tryUnlock()
+
+						if c.Bool("prune") && !c.GlobalBool("force") {
+							if !yesNoPrompt("Old snapshots and data folder backups will be removed.  Continue? [y/n]:") {
+								return nil
+							}
+						}
+
+						cfgMgr, cfgs := makeConfigs()
+						err = cfgMgr.LoadJSONFromFile(configPath)
+						checkErr("reading configuration", err)
+
+						err = compactState(cfgs.consensusCfg, c.Bool("prune"))
+						checkErr("compacting consensus data", err)
+						logger.Infof("the %s folder has been compacted", cfgs.consensusCfg.GetDataFolder())
+						return nil
+					},
+				},
+			},
+		},
+		{
+			Name:  "rotate-key",
+			Usage: "replace the peer's private key and ID by new ones",
+			Description: fmt.Sprintf(`
+This command generates a new private key (and peer ID) for this peer and saves
+it in the configuration, i.e. after the current key has been compromised.
+The peer must be stopped.
+
+Since the consensus data is bound to the old peer ID, it is cleaned up (a
+backup is kept, as with "state cleanup"). After running this command:
+
+  1. Run "ipfs-cluster-ctl peers rotate <old ID> <new ID>" against any
+     other running peer. This re-allocates the pins of the old ID to the new
+     one and removes the old ID from the cluster.
+  2. Start this peer with "%s daemon --bootstrap <peer multiaddress>"
+     to join the cluster with the new ID.
+
+The content pinned by this peer's IPFS daemon is kept and no re-pinning
+happens elsewhere in the cluster.
+`, programName),
+			ArgsUsage: " ",
+			Action: func(c *cli.Context) error {
+				err := locker.lock()
+				checkErr("acquiring execution lock", err)
+				defer locker.tryUnlock()
+
+				if !c.GlobalBool("force") {
+					if !yesNoPrompt("The peer's private key will be replaced and its consensus state removed from the load path. Continue? [y/n]:") {
+						return nil
+					}
+				}
+
+				cfgMgr, cfgs := makeConfigs()
+				err = cfgMgr.LoadJSONFromFile(configPath)
+				checkErr("reading configuration", err)
+
+				oldID := cfgs.clusterCfg.ID
+				err = cfgs.clusterCfg.NewIdentity()
+				checkErr("generating new identity", err)
+				newID := cfgs.clusterCfg.ID
+
+				err = cleanupState(cfgs.clusterCfg, cfgs.consensusCfg)
+				checkErr("cleaning up consensus data", err)
+
+				err = cfgMgr.SaveJSON(configPath)
+				checkErr("saving configuration", err)
+
+				out("Old peer ID: %s\n", oldID.Pretty())
+				out("New peer ID: %s\n", newID.Pretty())
+				out("Now run: ipfs-cluster-ctl peers rotate %s %s\n", oldID.Pretty(), newID.Pretty())
+				out("and start this peer with --bootstrap.\n")
+				return nil
 			},
 		},
 		{
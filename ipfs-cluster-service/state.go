@@ -1,16 +1,16 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
 	"errors"
 	"io"
 	"io/ioutil"
+	"os"
 
 	ipfscluster "github.com/ipfs/ipfs-cluster"
 	"github.com/ipfs/ipfs-cluster/api"
 	"github.com/ipfs/ipfs-cluster/consensus/raft"
 	"github.com/ipfs/ipfs-cluster/pstoremgr"
+	"github.com/ipfs/ipfs-cluster/state"
 	"github.com/ipfs/ipfs-cluster/state/mapstate"
 )
 
@@ -45,7 +45,7 @@ func export(w io.Writer) error {
 		return err
 	}
 
-	return exportState(stateToExport, w)
+	return state.Export(stateToExport, w)
 }
 
 // restoreStateFromDisk returns a mapstate containing the latest
@@ -67,28 +67,35 @@ func restoreStateFromDisk() (*mapstate.MapState, bool, error) {
 		return nil, false, err
 	}
 
-	stateFromSnap := mapstate.NewMapState()
-	// duplicate reader to both check version and migrate
-	var buf bytes.Buffer
-	r2 := io.TeeReader(r, &buf)
-	raw, err := ioutil.ReadAll(r2)
+	raw, err := ioutil.ReadAll(r)
 	if err != nil {
 		return nil, false, err
 	}
-	err = stateFromSnap.Unmarshal(raw)
+	version, err := mapstate.SnapshotVersion(raw)
 	if err != nil {
 		return nil, false, err
 	}
-	if stateFromSnap.GetVersion() == mapstate.Version {
-		return stateFromSnap, true, nil
-	}
 
-	err = stateFromSnap.Migrate(&buf)
+	// Older versions are migrated when unmarshaling.
+	stateFromSnap := mapstate.NewMapState()
+	err = stateFromSnap.Unmarshal(raw)
 	if err != nil {
 		return nil, false, err
 	}
+	return stateFromSnap, version == mapstate.Version, nil
+}
 
-	return stateFromSnap, false, nil
+// previousPins returns the pins in the latest snapshot of the state,
+// if any.
+func previousPins() ([]api.Pin, error) {
+	st, _, err := restoreStateFromDisk()
+	if err == errNoSnapshot {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return st.List(), nil
 }
 
 func stateImport(r io.Reader) error {
@@ -99,28 +106,42 @@ func stateImport(r io.Reader) error {
 		return err
 	}
 
-	pinSerials := make([]api.PinSerial, 0)
-	dec := json.NewDecoder(r)
-	err = dec.Decode(&pinSerials)
+	stateToImport := mapstate.NewMapState()
+	err = state.Import(stateToImport, r)
 	if err != nil {
 		return err
 	}
 
-	stateToImport := mapstate.NewMapState()
-	for _, pS := range pinSerials {
-		err = stateToImport.Add(pS.ToPin())
-		if err != nil {
-			return err
-		}
-	}
-
 	pm := pstoremgr.New(nil, cfgs.clusterCfg.GetPeerstorePath())
 	raftPeers := append(ipfscluster.PeersFromMultiaddrs(pm.LoadPeerstore()), cfgs.clusterCfg.ID)
 	return raft.SnapshotSave(cfgs.consensusCfg, stateToImport, raftPeers)
 }
 
+// stateDiff compares an exported state with another one or, when after
+// is nil, with the latest state snapshot of this peer.
+func stateDiff(before, after io.Reader) (api.PinsDiff, error) {
+	beforePins, err := state.ReadExported(before)
+	if err != nil {
+		return api.PinsDiff{}, err
+	}
+
+	var afterPins []api.Pin
+	if after != nil {
+		afterPins, err = state.ReadExported(after)
+	} else {
+		afterPins, err = previousPins()
+	}
+	if err != nil {
+		return api.PinsDiff{}, err
+	}
+	return api.DiffPins(beforePins, afterPins), nil
+}
+
+// validateVersion checks that the format version of the latest snapshot
+// can be loaded by this peer. Older versions are upgraded automatically
+// when the snapshot is loaded, but snapshots from newer versions of
+// ipfs-cluster cannot be read.
 func validateVersion(cfg *ipfscluster.Config, cCfg *raft.Config) error {
-	state := mapstate.NewMapState()
 	r, snapExists, err := raft.LastStateRaw(cCfg)
 	if !snapExists && err != nil {
 		logger.Error("error before reading latest snapshot.")
@@ -131,40 +152,39 @@ func validateVersion(cfg *ipfscluster.Config, cCfg *raft.Config) error {
 		if err2 != nil {
 			return err2
 		}
-		err2 = state.Unmarshal(raw)
+		version, err2 := mapstate.SnapshotVersion(raw)
 		if err2 != nil {
-			logger.Error("error unmarshalling snapshot. Snapshot potentially corrupt.")
+			logger.Error("error reading the snapshot version. Snapshot potentially corrupt.")
 			return err2
 		}
-		if state.GetVersion() != mapstate.Version {
+		switch {
+		case version > mapstate.Version:
 			logger.Error("!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!")
-			logger.Error("Out of date ipfs-cluster state is saved.")
-			logger.Error("To migrate to the new version, run ipfs-cluster-service state upgrade.")
+			logger.Error("The saved ipfs-cluster state is newer than the one supported by this version.")
 			logger.Error("To launch a node without this state, rename the consensus data directory.")
-			logger.Error("Hint, the default is .ipfs-cluster/ipfs-cluster-data.")
+			logger.Error("Hint, the default is .ipfs-cluster/raft.")
 			logger.Error("!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!")
-			err = errors.New("outdated state version stored")
+			err = errors.New("unsupported state version stored")
+		case version < mapstate.Version:
+			logger.Warningf("the saved state (version %d) will be upgraded to version %d when loaded", version, mapstate.Version)
+			logger.Warning("run ipfs-cluster-service state upgrade to persist the upgraded state")
 		}
 	} // !snapExists && err == nil // no existing state, no check needed
 	return err
 }
 
-// ExportState saves a json representation of a state
-func exportState(state *mapstate.MapState, w io.Writer) error {
-	// Serialize pins
-	pins := state.List()
-	pinSerials := make([]api.PinSerial, len(pins), len(pins))
-	for i, pin := range pins {
-		pinSerials[i] = pin.ToSerial()
+// CleanupState cleans the state. The tracker checkpoint is removed too,
+// as it refers to the old state.
+func cleanupState(cfg *ipfscluster.Config, cCfg *raft.Config) error {
+	if path := cfg.GetTrackerCheckpointPath(); path != "" {
+		err := os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
 	}
-
-	// Write json to output file
-	enc := json.NewEncoder(w)
-	enc.SetIndent("", "    ")
-	return enc.Encode(pinSerials)
+	return raft.CleanupRaft(cCfg.GetDataFolder(), cCfg.BackupsRotate)
 }
 
-// CleanupState cleans the state
-func cleanupState(cCfg *raft.Config) error {
-	return raft.CleanupRaft(cCfg.GetDataFolder(), cCfg.BackupsRotate)
+func compactState(cCfg *raft.Config, prune bool) error {
+	return raft.Compact(cCfg, prune)
 }
@@ -10,6 +10,7 @@ package ipfscluster
 
 import (
 	"context"
+	"time"
 
 	"github.com/ipfs/ipfs-cluster/api"
 	"github.com/ipfs/ipfs-cluster/state"
@@ -46,6 +47,8 @@ type Consensus interface {
 	LogPin(c api.Pin) error
 	// Logs an unpin operation
 	LogUnpin(c api.Pin) error
+	// Logs a change of the cluster-wide configuration
+	LogSharedConfig(update api.SharedConfigUpdate) error
 	AddPeer(p peer.ID) error
 	RmPeer(p peer.ID) error
 	State() (state.State, error)
@@ -59,6 +62,9 @@ type Consensus interface {
 	Clean() error
 	// Peers returns the peerset participating in the Consensus
 	Peers() ([]peer.ID, error)
+	// Status returns information about the consensus layer
+	// (leader, term, applied index, voters) as seen by this peer
+	Status() (api.ConsensusState, error)
 }
 
 // API is a component which offers an API for Cluster. This is
@@ -74,6 +80,9 @@ type IPFSConnector interface {
 	ID() (api.IPFSID, error)
 	Pin(context.Context, *cid.Cid, bool) error
 	Unpin(context.Context, *cid.Cid) error
+	// Prefetch fetches all the blocks of the DAG under the given Cid
+	// without pinning it.
+	Prefetch(context.Context, *cid.Cid) error
 	PinLsCid(context.Context, *cid.Cid) (api.IPFSPinStatus, error)
 	PinLs(ctx context.Context, typeFilter string) (map[string]api.IPFSPinStatus, error)
 	// ConnectSwarms make sure this peer's IPFS daemon is connected to
@@ -90,6 +99,88 @@ type IPFSConnector interface {
 	// RepoSize returns the current repository size as expressed
 	// by "repo stat".
 	RepoSize() (uint64, error)
+	// CumulativeSize returns the size of the whole DAG under
+	// the given Cid, as expressed by "object stat".
+	CumulativeSize(*cid.Cid) (uint64, error)
+	// BlockSize returns the size of a single block as expressed
+	// by "block stat". Unlike CumulativeSize, it works with any
+	// IPLD node, not only with UnixFS ones.
+	BlockSize(*cid.Cid) (uint64, error)
+	// DAGStats walks the DAG under the given Cid and returns
+	// statistics about its blocks.
+	DAGStats(*cid.Cid) (api.DAGStats, error)
+}
+
+// Drainer is implemented by components which can stop accepting new work
+// and finish ongoing operations before being shut down. Drain should
+// return when there is nothing left to do or when the context is cancelled.
+type Drainer interface {
+	Drain(ctx context.Context) error
+}
+
+// Pauser is implemented by components which can temporarily stop
+// performing their work (i.e. a PinTracker issuing IPFS pins) until
+// they are resumed.
+type Pauser interface {
+	Pause()
+	Resume()
+}
+
+// StatusCounter is implemented by PinTrackers which can count the items
+// with a given status without listing them all (see StatusAll).
+type StatusCounter interface {
+	StatusCount(api.TrackerStatus) int
+}
+
+// UsageReporter is implemented by components which can report the work
+// they are handling and their concurrency limits, for debugging.
+type UsageReporter interface {
+	Usage() api.ComponentUsage
+}
+
+// LeadershipTransferer is implemented by consensus components which can
+// hand the leadership of the cluster over to a given peer.
+type LeadershipTransferer interface {
+	TransferLeadership(peer.ID) error
+}
+
+// PinsetChangeLogger is implemented by consensus components which keep a
+// log of the changes they apply to the shared state, so that they can be
+// followed incrementally. Indexes are specific to every peer and to the
+// epoch of its log, which changes when the peer restarts.
+type PinsetChangeLogger interface {
+	PinsetChanges(epoch string, since uint64, limit int, wait time.Duration) (api.PinsetChanges, error)
+}
+
+// StateRepairer is implemented by consensus components which can make
+// their local copy of the shared state identical to a given one without
+// racing with the operations they apply.
+type StateRepairer interface {
+	RepairState(authoritative state.State) error
+}
+
+// PeerFilterer is implemented by consensus components which can be told
+// to ignore some peers. The given function returns false for the peers
+// which must not be part of the consensus peerset.
+type PeerFilterer interface {
+	SetPeerFilter(func(peer.ID) bool)
+}
+
+// CommitVerifier is implemented by consensus components which apply the
+// updates made by other peers without going through the Consensus RPC
+// methods, so that they can apply the same checks: updates are accepted
+// only from the peers for which trusted returns true, and their pins and
+// unpins only when verify returns no error.
+type CommitVerifier interface {
+	SetCommitVerifier(trusted func(peer.ID) bool, verify func(op string, pin api.Pin) error)
+}
+
+// LogPrefixer is implemented by components which log through a
+// logutil.Logger. NewCluster sets the prefix of their messages to the ID
+// of the peer (see logutil.PeerPrefix), so that the messages of several
+// peers in the same process can be told apart.
+type LogPrefixer interface {
+	SetLogPrefix(prefix string)
 }
 
 // Peered represents a component which needs to be aware of the peers
@@ -111,6 +202,11 @@ type PinTracker interface {
 	// Untrack tells the tracker that a Cid is to be forgotten. The tracker
 	// may perform an IPFS unpin operation.
 	Untrack(*cid.Cid) error
+	// Restore tells the tracker that a Cid is already pinned
+	// (TrackerStatusPinned) or tracked as remote (TrackerStatusRemote),
+	// as recorded in a checkpoint, so that it is tracked without
+	// performing any IPFS operation.
+	Restore(api.Pin, api.TrackerStatus) error
 	// StatusAll returns the list of pins with their local status.
 	StatusAll() []api.PinInfo
 	// Status returns the local status of a given Cid.
@@ -159,6 +255,12 @@ type PinAllocator interface {
 // The PeerMonitor component also provides an Alert channel which is signaled
 // when a metric is no longer received and the monitor identifies it
 // as a problem.
+//
+// Cluster does not make other assumptions about how metrics travel or how
+// failures are detected, so alternative implementations (i.e. relying on
+// an external health checker) can replace the built-in ones. The
+// monitor/metrics package provides a metrics Store and a Checker which
+// implementations may reuse.
 type PeerMonitor interface {
 	Component
 	// LogMetric stores a metric. It can be used to manually inject
@@ -1,6 +1,7 @@
 package ipfscluster
 
 import (
+	"bytes"
 	"context"
 	"flag"
 	"fmt"
@@ -13,8 +14,10 @@ import (
 	"time"
 
 	"github.com/ipfs/ipfs-cluster/allocator/descendalloc"
+	"github.com/ipfs/ipfs-cluster/allocator/rendezvousalloc"
 	"github.com/ipfs/ipfs-cluster/api"
 	"github.com/ipfs/ipfs-cluster/api/rest"
+	"github.com/ipfs/ipfs-cluster/consensus/crdt"
 	"github.com/ipfs/ipfs-cluster/consensus/raft"
 	"github.com/ipfs/ipfs-cluster/informer/disk"
 	"github.com/ipfs/ipfs-cluster/ipfsconn/ipfshttp"
@@ -26,6 +29,7 @@ import (
 	"github.com/ipfs/ipfs-cluster/test"
 
 	cid "github.com/ipfs/go-cid"
+	floodsub "github.com/libp2p/go-floodsub"
 	crypto "github.com/libp2p/go-libp2p-crypto"
 	host "github.com/libp2p/go-libp2p-host"
 	peer "github.com/libp2p/go-libp2p-peer"
@@ -44,6 +48,8 @@ var (
 
 	pmonitor = "pubsub"
 
+	pconsensus = "raft"
+
 	// When testing with fixed ports...
 	// clusterPort   = 10000
 	// apiPort       = 10100
@@ -55,6 +61,7 @@ func init() {
 	flag.IntVar(&nClusters, "nclusters", nClusters, "number of clusters to use")
 	flag.IntVar(&nPins, "npins", nPins, "number of pins to pin/unpin/check")
 	flag.StringVar(&pmonitor, "monitor", pmonitor, "monitor implementation")
+	flag.StringVar(&pconsensus, "consensus", pconsensus, "consensus implementation")
 	flag.Parse()
 
 	rand.Seed(time.Now().UnixNano())
@@ -83,7 +90,7 @@ func randomBytes() []byte {
 	return bs
 }
 
-func createComponents(t *testing.T, i int, clusterSecret []byte, staging bool) (host.Host, *Config, *raft.Consensus, API, IPFSConnector, state.State, PinTracker, PeerMonitor, PinAllocator, Informer, *test.IpfsMock) {
+func createComponents(t *testing.T, i int, clusterSecret []byte, staging bool) (host.Host, *Config, Consensus, API, IPFSConnector, state.State, PinTracker, PeerMonitor, PinAllocator, Informer, *test.IpfsMock) {
 	mock := test.NewIpfsMock()
 	//
 	//clusterAddr, _ := ma.NewMultiaddr(fmt.Sprintf("/ip4/127.0.0.1/tcp/%d", clusterPort+i))
@@ -103,6 +110,7 @@ func createComponents(t *testing.T, i int, clusterSecret []byte, staging bool) (
 	peername := fmt.Sprintf("peer_%d", i)
 
 	clusterCfg, apiCfg, ipfshttpCfg, consensusCfg, trackerCfg, bmonCfg, psmonCfg, diskInfCfg := testingConfigs()
+	crdtCfg := testingCRDTConfig()
 
 	clusterCfg.ID = pid
 	clusterCfg.Peername = peername
@@ -112,7 +120,10 @@ func createComponents(t *testing.T, i int, clusterSecret []byte, staging bool) (
 	clusterCfg.LeaveOnShutdown = false
 	clusterCfg.SetBaseDir("./e2eTestRaft/" + pid.Pretty())
 
-	ReadyTimeout = consensusCfg.WaitForLeaderTimeout + 1*time.Second
+	clusterCfg.ConsensusStartTimeout = consensusCfg.WaitForLeaderTimeout + 1*time.Second
+	if pconsensus == "crdt" {
+		clusterCfg.ConsensusStartTimeout = crdtCfg.SyncTimeout + 1*time.Second
+	}
 
 	host, err := NewClusterHost(context.Background(), clusterCfg)
 	checkErr(t, err)
@@ -121,6 +132,7 @@ func createComponents(t *testing.T, i int, clusterSecret []byte, staging bool) (
 	ipfshttpCfg.ProxyAddr = proxyAddr
 	ipfshttpCfg.NodeAddr = nodeAddr
 	consensusCfg.DataFolder = "./e2eTestRaft/" + pid.Pretty()
+	crdtCfg.DataFolder = "./e2eTestRaft/" + pid.Pretty()
 
 	api, err := rest.NewAPI(apiCfg)
 	checkErr(t, err)
@@ -129,25 +141,37 @@ func createComponents(t *testing.T, i int, clusterSecret []byte, staging bool) (
 	state := mapstate.NewMapState()
 	tracker := maptracker.NewMapPinTracker(trackerCfg, clusterCfg.ID)
 
-	mon := makeMonitor(t, host, bmonCfg, psmonCfg)
+	// The pubsub monitor and the crdt consensus share the PubSub
+	// instance, as in the daemon.
+	var pubsub *floodsub.PubSub
+	if pmonitor == "pubsub" || pconsensus == "crdt" {
+		pubsub, err = floodsub.NewFloodSub(context.Background(), host)
+		checkErr(t, err)
+	}
+	mon := makeMonitor(t, host, pubsub, bmonCfg, psmonCfg)
 
 	alloc := descendalloc.NewAllocator()
 	inf, err := disk.NewInformer(diskInfCfg)
 	checkErr(t, err)
-	raftCon, err := raft.NewConsensus(host, consensusCfg, state, staging)
-	checkErr(t, err)
+	cons := makeConsensus(t, host, pubsub, consensusCfg, crdtCfg, state, staging)
 
-	return host, clusterCfg, raftCon, api, ipfs, state, tracker, mon, alloc, inf, mock
+	return host, clusterCfg, cons, api, ipfs, state, tracker, mon, alloc, inf, mock
 }
 
-func makeMonitor(t *testing.T, h host.Host, bmonCfg *basic.Config, psmonCfg *pubsubmon.Config) PeerMonitor {
+// makeMonitor creates the monitor selected with -monitor. A nil pubsub
+// makes the pubsub monitor create its own.
+func makeMonitor(t *testing.T, h host.Host, pubsub *floodsub.PubSub, bmonCfg *basic.Config, psmonCfg *pubsubmon.Config) PeerMonitor {
 	var mon PeerMonitor
 	var err error
 	switch pmonitor {
 	case "basic":
 		mon, err = basic.NewMonitor(bmonCfg)
 	case "pubsub":
-		mon, err = pubsubmon.New(h, psmonCfg)
+		if pubsub != nil {
+			mon, err = pubsubmon.NewWithPubSub(h, pubsub, psmonCfg)
+		} else {
+			mon, err = pubsubmon.New(h, psmonCfg)
+		}
 	default:
 		panic("bad monitor")
 	}
@@ -155,15 +179,31 @@ func makeMonitor(t *testing.T, h host.Host, bmonCfg *basic.Config, psmonCfg *pub
 	return mon
 }
 
-func createCluster(t *testing.T, host host.Host, clusterCfg *Config, raftCons *raft.Consensus, api API, ipfs IPFSConnector, state state.State, tracker PinTracker, mon PeerMonitor, alloc PinAllocator, inf Informer) *Cluster {
-	cl, err := NewCluster(host, clusterCfg, raftCons, api, ipfs, state, tracker, mon, alloc, inf)
+// makeConsensus creates the consensus selected with -consensus.
+func makeConsensus(t *testing.T, h host.Host, pubsub *floodsub.PubSub, raftCfg *raft.Config, crdtCfg *crdt.Config, st state.State, staging bool) Consensus {
+	var cons Consensus
+	var err error
+	switch pconsensus {
+	case "raft":
+		cons, err = raft.NewConsensus(h, raftCfg, st, staging)
+	case "crdt":
+		cons, err = crdt.NewConsensus(h, pubsub, crdtCfg, st)
+	default:
+		panic("bad consensus")
+	}
+	checkErr(t, err)
+	return cons
+}
+
+func createCluster(t *testing.T, host host.Host, clusterCfg *Config, cons Consensus, api API, ipfs IPFSConnector, state state.State, tracker PinTracker, mon PeerMonitor, alloc PinAllocator, inf Informer) *Cluster {
+	cl, err := NewCluster(host, clusterCfg, cons, api, ipfs, state, tracker, mon, alloc, inf)
 	checkErr(t, err)
 	return cl
 }
 
 func createOnePeerCluster(t *testing.T, nth int, clusterSecret []byte) (*Cluster, *test.IpfsMock) {
-	host, clusterCfg, consensusCfg, api, ipfs, state, tracker, mon, alloc, inf, mock := createComponents(t, nth, clusterSecret, false)
-	cl := createCluster(t, host, clusterCfg, consensusCfg, api, ipfs, state, tracker, mon, alloc, inf)
+	host, clusterCfg, cons, api, ipfs, state, tracker, mon, alloc, inf, mock := createComponents(t, nth, clusterSecret, false)
+	cl := createCluster(t, host, clusterCfg, cons, api, ipfs, state, tracker, mon, alloc, inf)
 	<-cl.Ready()
 	return cl, mock
 }
@@ -171,7 +211,7 @@ func createOnePeerCluster(t *testing.T, nth int, clusterSecret []byte) (*Cluster
 func createClusters(t *testing.T) ([]*Cluster, []*test.IpfsMock) {
 	os.RemoveAll("./e2eTestRaft")
 	cfgs := make([]*Config, nClusters, nClusters)
-	raftCons := make([]*raft.Consensus, nClusters, nClusters)
+	consensuses := make([]Consensus, nClusters, nClusters)
 	apis := make([]API, nClusters, nClusters)
 	ipfss := make([]IPFSConnector, nClusters, nClusters)
 	states := make([]state.State, nClusters, nClusters)
@@ -189,10 +229,10 @@ func createClusters(t *testing.T) ([]*Cluster, []*test.IpfsMock) {
 
 	for i := 0; i < nClusters; i++ {
 		// staging = true for all except first (i==0)
-		host, clusterCfg, raftCon, api, ipfs, state, tracker, mon, alloc, inf, mock := createComponents(t, i, testingClusterSecret, i != 0)
+		host, clusterCfg, cons, api, ipfs, state, tracker, mon, alloc, inf, mock := createComponents(t, i, testingClusterSecret, i != 0)
 		hosts[i] = host
 		cfgs[i] = clusterCfg
-		raftCons[i] = raftCon
+		consensuses[i] = cons
 		apis[i] = api
 		ipfss[i] = ipfs
 		states[i] = state
@@ -218,13 +258,13 @@ func createClusters(t *testing.T) ([]*Cluster, []*test.IpfsMock) {
 	}
 
 	// Start first node
-	clusters[0] = createCluster(t, hosts[0], cfgs[0], raftCons[0], apis[0], ipfss[0], states[0], trackers[0], mons[0], allocs[0], infs[0])
+	clusters[0] = createCluster(t, hosts[0], cfgs[0], consensuses[0], apis[0], ipfss[0], states[0], trackers[0], mons[0], allocs[0], infs[0])
 	<-clusters[0].Ready()
 	bootstrapAddr, _ := ma.NewMultiaddr(fmt.Sprintf("%s/ipfs/%s", clusters[0].host.Addrs()[0], clusters[0].id.Pretty()))
 
 	// Start the rest and join
 	for i := 1; i < nClusters; i++ {
-		clusters[i] = createCluster(t, hosts[i], cfgs[i], raftCons[i], apis[i], ipfss[i], states[i], trackers[i], mons[i], allocs[i], infs[i])
+		clusters[i] = createCluster(t, hosts[i], cfgs[i], consensuses[i], apis[i], ipfss[i], states[i], trackers[i], mons[i], allocs[i], infs[i])
 		err := clusters[i].Join(bootstrapAddr)
 		if err != nil {
 			logger.Error(err)
@@ -343,6 +383,30 @@ func TestClustersVersion(t *testing.T) {
 	runF(t, clusters, f)
 }
 
+func TestClustersMetrics(t *testing.T) {
+	clusters, mock := createClusters(t)
+	defer shutdownClusters(t, clusters, mock)
+	waitForLeaderAndMetrics(t, clusters)
+
+	f := func(t *testing.T, c *Cluster) {
+		for _, name := range []string{"ping", c.informer.Name()} {
+			metrics := c.Metrics(name)
+			if len(metrics) != nClusters {
+				t.Errorf("expected %d %s metrics, got %d", nClusters, name, len(metrics))
+			}
+			for _, m := range metrics {
+				if m.Discard() {
+					t.Error("only valid metrics should be returned")
+				}
+			}
+		}
+		if len(c.Metrics("unknown")) != 0 {
+			t.Error("expected no metrics")
+		}
+	}
+	runF(t, clusters, f)
+}
+
 func TestClustersPeers(t *testing.T) {
 	clusters, mock := createClusters(t)
 	defer shutdownClusters(t, clusters, mock)
@@ -380,6 +444,94 @@ func TestClustersPeers(t *testing.T) {
 	}
 }
 
+func TestClustersQueryPeers(t *testing.T) {
+	clusters, mock := createClusters(t)
+	defer shutdownClusters(t, clusters, mock)
+
+	delay()
+
+	j := rand.Intn(nClusters) // choose a random cluster peer
+	all := clusters[j].QueryPeers(api.PeersQuery{})
+	if len(all) != nClusters {
+		t.Fatal("expected as many peers as clusters")
+	}
+	for i := 1; i < len(all); i++ {
+		if all[i-1].ID >= all[i].ID {
+			t.Fatal("peers should be sorted by ID")
+		}
+	}
+
+	page := clusters[j].QueryPeers(api.PeersQuery{Offset: 1, Limit: 2})
+	if len(page) != 2 || page[0].ID != all[1].ID || page[1].ID != all[2].ID {
+		t.Fatal("unexpected page of peers")
+	}
+	if page[0].Version == "" {
+		t.Error("expected the full ID of every peer")
+	}
+
+	local := clusters[j].QueryPeers(api.PeersQuery{Local: true})
+	if len(local) != nClusters {
+		t.Fatal("expected as many peers as clusters")
+	}
+	for _, id := range local {
+		if id.Version != "" {
+			t.Error("local peer information should not include the version")
+		}
+		if len(id.Addresses) == 0 {
+			t.Errorf("expected known addresses for %s", id.ID)
+		}
+		if id.LastHeartbeat.IsZero() {
+			t.Errorf("expected a last heartbeat for %s", id.ID)
+		}
+		if _, ok := id.Metrics[pendingMetricName]; !ok {
+			t.Errorf("expected the pending pins of %s", id.ID)
+		}
+		if _, ok := id.Metrics[pinErrorsMetricName]; !ok {
+			t.Errorf("expected the pin errors of %s", id.ID)
+		}
+	}
+}
+
+func TestClustersPeerHealth(t *testing.T) {
+	clusters, mock := createClusters(t)
+	defer shutdownClusters(t, clusters, mock)
+
+	waitForLeaderAndMetrics(t, clusters)
+
+	health, err := clusters[0].PeerHealth()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(health) != nClusters {
+		t.Fatal("expected the health of every peer")
+	}
+	for _, ph := range health {
+		if !ph.Alive || ph.LastHeartbeat.IsZero() {
+			t.Errorf("%s should be alive", ph.Peer)
+		}
+		if _, ok := ph.Metrics[pendingMetricName]; !ok {
+			t.Errorf("expected the pending pins of %s", ph.Peer)
+		}
+	}
+
+	down := clusters[nClusters-1]
+	down.Shutdown()
+	ttlDelay()
+
+	health, err = clusters[0].PeerHealth()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ph := range health {
+		if ph.Peer == down.id && ph.Alive {
+			t.Error("the peer which was shut down should not be alive")
+		}
+		if ph.Peer != down.id && !ph.Alive {
+			t.Errorf("%s should be alive", ph.Peer)
+		}
+	}
+}
+
 func TestClustersPin(t *testing.T) {
 	clusters, mock := createClusters(t)
 	defer shutdownClusters(t, clusters, mock)
@@ -482,8 +634,353 @@ func TestClustersStatusAll(t *testing.T) {
 		if pinfo.Status != api.TrackerStatusPinned {
 			t.Error("the status should show the hash as pinned")
 		}
+
+		// Pinned everywhere: all peers should hold it
+		if len(status.Allocations) != nClusters || len(status.PinnedBy) != nClusters || !status.Match {
+			t.Error("the status should show the allocations matching")
+		}
+	}
+	runF(t, clusters, f)
+}
+
+func TestClustersSharedConfig(t *testing.T) {
+	clusters, mock := createClusters(t)
+	defer shutdownClusters(t, clusters, mock)
+
+	ttlDelay()
+
+	err := clusters[0].SetSharedConfig(api.SharedConfigUpdate{
+		Config: api.SharedConfig{Allocator: "random"},
+	})
+	if err == nil {
+		t.Error("expected an error with an unknown allocator")
+	}
+	err = clusters[0].SetSharedConfig(api.SharedConfigUpdate{
+		Config: api.SharedConfig{ReplicationFactorMin: 2},
+	})
+	if err == nil {
+		t.Error("expected an error with a bad replication factor")
+	}
+	err = clusters[0].SetSharedConfig(api.SharedConfigUpdate{
+		Fields: []string{"replication"},
+	})
+	if err == nil {
+		t.Error("expected an error with an unknown setting")
+	}
+
+	shared := api.SharedConfig{
+		ReplicationFactorMin: 1,
+		ReplicationFactorMax: 1,
+		Allocator:            SharedAllocatorDescend,
+		StateSyncInterval:    time.Minute,
+	}
+	err = clusters[nClusters-1].SetSharedConfig(api.SharedConfigUpdate{Config: shared})
+	if err != nil {
+		t.Fatal(err)
+	}
+	delay()
+
+	// Updates only modify the given settings
+	err = clusters[0].Pause()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = clusters[0].SetSharedConfig(api.SharedConfigUpdate{
+		Config: api.SharedConfig{StateSyncInterval: 2 * time.Minute},
+		Fields: []string{api.SharedConfigStateSyncInterval},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = clusters[0].Resume()
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared.StateSyncInterval = 2 * time.Minute
+	delay()
+
+	h, _ := cid.Decode(test.TestCid1)
+	f := func(t *testing.T, c *Cluster) {
+		cfg, err := c.SharedConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg != shared {
+			t.Errorf("%s: unexpected shared config: %+v", c.id, cfg)
+		}
+		if c.stateSyncInterval() != 2*time.Minute {
+			t.Error("the shared state sync interval should be used")
+		}
+		if _, ok := c.pinAllocator().(descendalloc.DescendAllocator); !ok {
+			t.Error("the shared allocator should be used")
+		}
+	}
+	runF(t, clusters, f)
+
+	err = clusters[0].Pin(api.PinCid(h))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinDelay()
+	pin, err := clusters[0].PinGet(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pin.ReplicationFactorMin != 1 || len(pin.Allocations) != 1 {
+		t.Error("the shared replication factor should have been used")
+	}
+}
+
+func TestClustersRendezvousAllocator(t *testing.T) {
+	clusters, mock := createClusters(t)
+	defer shutdownClusters(t, clusters, mock)
+	waitForLeaderAndMetrics(t, clusters)
+
+	shared := api.SharedConfig{
+		ReplicationFactorMin: 2,
+		ReplicationFactorMax: 2,
+		Allocator:            SharedAllocatorRendezvous,
+	}
+	err := clusters[0].SetSharedConfig(api.SharedConfigUpdate{Config: shared})
+	if err != nil {
+		t.Fatal(err)
+	}
+	delay()
+
+	peers, err := clusters[0].consensus.Peers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, hStr := range []string{test.TestCid1, test.TestCid2} {
+		h, _ := cid.Decode(hStr)
+		err = clusters[nClusters-1].Pin(api.PinCid(h))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pinDelay()
+
+		expected := rendezvousalloc.SortByScore(h, peers)[0:2]
+		pin, err := clusters[0].PinGet(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pin.Allocations) != 2 {
+			t.Fatal("expected 2 allocations")
+		}
+		for _, p := range expected {
+			if !containsPeer(pin.Allocations, p) {
+				t.Errorf("%s: expected %s to be allocated", h, p)
+			}
+		}
+	}
+}
+
+func TestClustersPinInGroup(t *testing.T) {
+	clusters, mock := createClusters(t)
+	defer shutdownClusters(t, clusters, mock)
+	waitForLeaderAndMetrics(t, clusters)
+
+	group := []peer.ID{clusters[0].id, clusters[1].id}
+	for _, c := range clusters {
+		c.config.PeerGroups = map[string][]peer.ID{"ssd-tier": group}
+	}
+
+	h1, _ := cid.Decode(test.TestCid1)
+	pin := api.PinCid(h1)
+	pin.Group = "unknown"
+	if err := clusters[0].Pin(pin); err == nil {
+		t.Error("expected an error pinning in an unknown group")
+	}
+
+	pin.Group = "ssd-tier"
+	pin.ReplicationFactorMin = 1
+	pin.ReplicationFactorMax = 1
+	err := clusters[nClusters-1].Pin(pin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h2, _ := cid.Decode(test.TestCid2)
+	pin2 := api.PinCid(h2)
+	pin2.Group = "ssd-tier"
+	pin2.ReplicationFactorMin = -1
+	pin2.ReplicationFactorMax = -1
+	err = clusters[nClusters-1].Pin(pin2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinDelay()
+
+	stored, err := clusters[0].PinGet(h1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.Allocations) != 1 || !containsPeer(group, stored.Allocations[0]) {
+		t.Error("the pin should be allocated to a member of the group:", stored.Allocations)
+	}
+
+	f := func(t *testing.T, c *Cluster) {
+		info := c.tracker.Status(h2)
+		inGroup := containsPeer(group, c.id)
+		if inGroup && info.Status != api.TrackerStatusPinned {
+			t.Errorf("%s: expected pinned in group member", c.id)
+		}
+		if !inGroup && info.Status != api.TrackerStatusRemote {
+			t.Errorf("%s: expected remote outside the group", c.id)
+		}
+	}
+	runF(t, clusters, f)
+}
+
+func TestClustersStatusSummary(t *testing.T) {
+	clusters, mock := createClusters(t)
+	defer shutdownClusters(t, clusters, mock)
+	h, _ := cid.Decode(test.TestCid1)
+	clusters[0].Pin(api.PinCid(h))
+	pinDelay()
+
+	f := func(t *testing.T, c *Cluster) {
+		sums, err := c.StatusSummary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sums) != nClusters {
+			t.Fatal("expected a summary for every peer")
+		}
+		for _, sum := range sums {
+			if sum.Error != "" {
+				t.Error(sum.Error)
+			}
+			if sum.Counts[api.TrackerStatusPinned] != 1 {
+				t.Errorf("%s should have 1 pinned item", sum.Peer)
+			}
+		}
+	}
+	runF(t, clusters, f)
+}
+
+func TestClustersPauseResume(t *testing.T) {
+	clusters, mock := createClusters(t)
+	defer shutdownClusters(t, clusters, mock)
+
+	err := clusters[0].Pause()
+	if err != nil {
+		t.Fatal(err)
+	}
+	delay()
+
+	h, _ := cid.Decode(test.TestCid1)
+	err = clusters[1].Pin(api.PinCid(h))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinDelay()
+
+	f := func(t *testing.T, c *Cluster) {
+		cfg, err := c.SharedConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !cfg.Paused {
+			t.Error("the cluster should be paused")
+		}
+		if !c.tracker.(*maptracker.MapPinTracker).Paused() {
+			t.Error("the tracker should be paused")
+		}
+		if st := c.tracker.Status(h); st.Status != api.TrackerStatusPinQueued {
+			t.Errorf("%s: pin should be queued and is %s", c.id, st.Status)
+		}
 	}
 	runF(t, clusters, f)
+
+	err = clusters[2].Resume()
+	if err != nil {
+		t.Fatal(err)
+	}
+	delay()
+	pinDelay()
+
+	f2 := func(t *testing.T, c *Cluster) {
+		if c.tracker.(*maptracker.MapPinTracker).Paused() {
+			t.Error("the tracker should have been resumed")
+		}
+		if st := c.tracker.Status(h); st.Status != api.TrackerStatusPinned {
+			t.Errorf("%s: pin should be pinned and is %s", c.id, st.Status)
+		}
+	}
+	runF(t, clusters, f2)
+}
+
+func TestClustersOrphanPins(t *testing.T) {
+	clusters, mock := createClusters(t)
+	defer shutdownClusters(t, clusters, mock)
+	h1, _ := cid.Decode(test.TestCid1)
+	h2, _ := cid.Decode(test.TestCid2)
+	h3, _ := cid.Decode(test.TestCid3)
+	clusters[0].Pin(api.PinCid(h1))
+	pinDelay()
+
+	// Pin directly on the IPFS daemons, behind the cluster's back.
+	ctx := context.Background()
+	checkErr(t, clusters[1].ipfs.Pin(ctx, h2, true))
+	checkErr(t, clusters[2].ipfs.Pin(ctx, h3, true))
+
+	res, err := clusters[0].OrphanPins(api.OrphanActionNone)
+	checkErr(t, err)
+	if len(res) != nClusters {
+		t.Fatal("expected results for every peer")
+	}
+	for _, r := range res {
+		if r.Error != "" {
+			t.Error(r.Error)
+		}
+		switch r.Peer {
+		case clusters[1].id:
+			if len(r.Orphans) != 1 || !r.Orphans[0].Equals(h2) {
+				t.Errorf("expected %s to be an orphan in %s", h2, r.Peer)
+			}
+		case clusters[2].id:
+			if len(r.Orphans) != 1 || !r.Orphans[0].Equals(h3) {
+				t.Errorf("expected %s to be an orphan in %s", h3, r.Peer)
+			}
+		default:
+			if len(r.Orphans) != 0 {
+				t.Errorf("expected no orphans in %s", r.Peer)
+			}
+		}
+	}
+
+	local, err := clusters[1].OrphanPinsLocal(api.OrphanActionAdopt)
+	checkErr(t, err)
+	if len(local.Orphans) != 1 || local.Error != "" {
+		t.Fatal("expected one adopted pin")
+	}
+	pinDelay()
+	_, err = clusters[0].PinGet(h2)
+	if err != nil {
+		t.Error("the adopted pin should be part of the state")
+	}
+
+	_, err = clusters[2].OrphanPinsLocal(api.OrphanActionRemove)
+	checkErr(t, err)
+	st, err := clusters[2].ipfs.PinLsCid(ctx, h3)
+	if err == nil && st.IsPinned() {
+		t.Error("the orphan pin should have been removed")
+	}
+
+	res, err = clusters[0].OrphanPins(api.OrphanActionNone)
+	checkErr(t, err)
+	for _, r := range res {
+		if len(r.Orphans) != 0 {
+			t.Errorf("expected no orphans left in %s", r.Peer)
+		}
+	}
+
+	_, err = clusters[0].OrphanPinsLocal(api.OrphanAction("bad"))
+	if err == nil {
+		t.Error("expected an error for an unknown action")
+	}
 }
 
 func TestClustersStatusAllWithErrors(t *testing.T) {
@@ -812,6 +1309,43 @@ func TestClustersRecover(t *testing.T) {
 	}
 }
 
+func TestClustersRecoverAll(t *testing.T) {
+	clusters, mock := createClusters(t)
+	defer shutdownClusters(t, clusters, mock)
+	h, _ := cid.Decode(test.ErrorCid) // This cid always fails
+	h2, _ := cid.Decode(test.TestCid2)
+
+	ttlDelay()
+
+	clusters[0].Pin(api.PinCid(h))
+	clusters[0].Pin(api.PinCid(h2))
+
+	pinDelay()
+	pinDelay()
+
+	j := rand.Intn(nClusters)
+	ginfos, err := clusters[j].RecoverAll("error", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ginfos) != 1 || ginfos[0].Cid.String() != test.ErrorCid {
+		t.Fatal("only the item in error should have been recovered")
+	}
+
+	ginfos, err = clusters[j].RecoverAll("pinned", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ginfos) != 1 || ginfos[0].Cid.String() != test.TestCid2 {
+		t.Error("expected the pinned item to be selected")
+	}
+
+	_, err = clusters[j].RecoverAll("bad", 0)
+	if err == nil {
+		t.Error("expected an error with an invalid filter")
+	}
+}
+
 func TestClustersShutdown(t *testing.T) {
 	clusters, mock := createClusters(t)
 	defer shutdownClusters(t, clusters, mock)
@@ -1636,3 +2170,213 @@ func TestClustersDisabledRepinning(t *testing.T) {
 		t.Errorf("expected %d replicas for pin, got %d", nClusters-2, numPinned)
 	}
 }
+
+func TestClustersRPCStats(t *testing.T) {
+	clusters, mock := createClusters(t)
+	defer shutdownClusters(t, clusters, mock)
+
+	h, _ := cid.Decode(test.TestCid1)
+	err := clusters[1].Pin(api.PinCid(h))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinDelay()
+
+	stats := clusters[0].RPCStats()
+	if len(stats) == 0 {
+		t.Fatal("expected some RPC requests from other peers")
+	}
+	for _, st := range stats {
+		if st.Peer == clusters[0].id {
+			t.Error("local requests should not be recorded")
+		}
+		if !strings.HasPrefix(st.Method, "Cluster.") {
+			t.Errorf("unexpected method %s", st.Method)
+		}
+		if st.Count == 0 || st.Last.IsZero() {
+			t.Error("bad call stats")
+		}
+	}
+}
+
+func TestClustersTrustedPeers(t *testing.T) {
+	clusters, mock := createClusters(t)
+	defer shutdownClusters(t, clusters, mock)
+
+	leaderID, err := clusters[0].consensus.Leader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var leader, follower *Cluster
+	for _, c := range clusters {
+		if c.id == leaderID {
+			leader = c
+		} else {
+			follower = c
+		}
+	}
+
+	leader.config.TrustedPeers = []peer.ID{leader.id}
+
+	h, _ := cid.Decode(test.TestCid1)
+	err = follower.Pin(api.PinCid(h))
+	if err == nil {
+		t.Error("the leader should not accept pins from untrusted peers")
+	}
+
+	err = leader.Pin(api.PinCid(h))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leader.config.TrustedPeers = []peer.ID{leader.id, follower.id}
+	err = follower.Unpin(h)
+	if err != nil {
+		t.Error("trusted peers should be able to unpin:", err)
+	}
+}
+
+func TestClustersRotateSecret(t *testing.T) {
+	clusters, mock := createClusters(t)
+	defer shutdownClusters(t, clusters, mock)
+
+	secret, _ := DecodeClusterSecret("9d6f1c1e3f0d3a72a2a5e05b7e1c1d0fd6c07d6b4d2d2f6b09b8f1e2a3c4d5e6")
+	res, err := clusters[0].RotateSecret(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Updated) != nClusters || len(res.Failed) != 0 {
+		t.Errorf("expected all peers to be updated: %+v", res)
+	}
+	expected := &swappableProtector{}
+	expected.setSecret(secret)
+	for _, c := range clusters {
+		if !bytes.Equal(c.config.Secret, secret) {
+			t.Errorf("%s did not update its secret", c.id)
+		}
+		if c.config.protector.Fingerprint() != expected.Fingerprint() {
+			t.Errorf("%s is not protecting connections with the new secret", c.id)
+		}
+	}
+
+	// The cluster keeps working without a restart
+	h, _ := cid.Decode(test.TestCid1)
+	err = clusters[0].Pin(api.PinCid(h))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinDelay()
+	for _, c := range clusters {
+		if _, err := c.PinGet(h); err != nil {
+			t.Errorf("%s did not get the pin after the rotation", c.id)
+		}
+	}
+
+	// Secrets must be prepared before they are committed
+	err = clusters[0].commitSecretRotation(EncodeProtectorKey(testingClusterSecret))
+	if err != errNoPendingSecret {
+		t.Error("expected an error committing an unprepared secret")
+	}
+}
+
+func TestClustersRepairState(t *testing.T) {
+	clusters, mock := createClusters(t)
+	defer shutdownClusters(t, clusters, mock)
+
+	h, _ := cid.Decode(test.TestCid1)
+	err := clusters[0].Pin(api.PinCid(h))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinDelay()
+
+	leader, err := clusters[0].consensus.Leader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var follower *Cluster
+	for _, c := range clusters {
+		if c.id != leader {
+			follower = c
+			break
+		}
+	}
+
+	// Make the follower's state diverge
+	st, err := follower.consensus.State()
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.Rm(h)
+
+	res, err := follower.RepairState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Diverged {
+		t.Error("expected a diverged state")
+	}
+	if res.Local.Checksum == res.Authoritative.Checksum {
+		t.Error("checksums should differ")
+	}
+	if !st.Has(h) {
+		t.Error("the state should have been repaired")
+	}
+	if follower.tracker.Status(h).Status != api.TrackerStatusPinned {
+		t.Error("the tracker should have been reconciled")
+	}
+
+	res, err = follower.RepairState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Diverged {
+		t.Error("the state should not diverge anymore")
+	}
+}
+
+func TestClustersCanaryPinning(t *testing.T) {
+	if nClusters < 3 {
+		t.Skip("need at least 3 nodes for this test")
+	}
+
+	clusters, mock := createClusters(t)
+	defer shutdownClusters(t, clusters, mock)
+	for _, c := range clusters {
+		c.config.ReplicationFactorMin = nClusters - 1
+		c.config.ReplicationFactorMax = nClusters - 1
+		c.config.CanaryPinning = true
+	}
+	ttlDelay()
+
+	h, _ := cid.Decode(test.TestCid1)
+	err := clusters[0].Pin(api.PinCid(h))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinDelay()
+
+	// Wait for the canary to pin and the pin to be extended
+	time.Sleep(2 * canaryCheckInterval)
+	delay()
+
+	pin, err := clusters[0].PinGet(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pin.Allocations) != nClusters-1 {
+		t.Fatalf("expected the pin to be extended to %d peers: %s", nClusters-1, pin.Allocations)
+	}
+
+	f := func(t *testing.T, c *Cluster) {
+		st := c.tracker.Status(h).Status
+		allocated := containsPeer(pin.Allocations, c.id)
+		if allocated && st != api.TrackerStatusPinned {
+			t.Errorf("%s: %s should be pinned and is %s", c.id, h, st)
+		}
+		if !allocated && st != api.TrackerStatusRemote {
+			t.Errorf("%s: %s should be remote and is %s", c.id, h, st)
+		}
+	}
+	runF(t, clusters, f)
+}
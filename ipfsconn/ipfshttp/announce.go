@@ -0,0 +1,105 @@
+package ipfshttp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"github.com/ipfs/ipfs-cluster/api"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// PinAnnouncement is the message published to Config.PinAnnounceTopic
+// when content becomes pinned by the IPFS daemon of a cluster peer.
+type PinAnnouncement struct {
+	Cid         string `json:"cid"`
+	ClusterPeer string `json:"cluster_peer"`
+}
+
+// clusterPeerID returns the ID of the cluster peer using this connector.
+// It is fetched via RPC on first use and cached afterwards.
+func (ipfs *Connector) clusterPeerID() (string, error) {
+	ipfs.announceMux.Lock()
+	defer ipfs.announceMux.Unlock()
+	if ipfs.clusterID != "" {
+		return ipfs.clusterID, nil
+	}
+
+	var id api.IDSerial
+	err := ipfs.rpcClient.Call("", "Cluster", "ID", struct{}{}, &id)
+	if err != nil {
+		return "", err
+	}
+	ipfs.clusterID = id.ID
+	return ipfs.clusterID, nil
+}
+
+// announceQueueSize is the number of announcements which can wait to be
+// published. Announcements are dropped when the queue is full.
+var announceQueueSize = 1024
+
+// announcePin queues the publication of a PinAnnouncement for the given
+// cid when Config.PinAnnounceTopic is set, so that pins do not wait for
+// it. Announcements are best effort: errors are logged but never fail the
+// pin. Note the IPFS daemon needs pubsub enabled
+// (--enable-pubsub-experiment).
+func (ipfs *Connector) announcePin(c *cid.Cid) {
+	if ipfs.config.PinAnnounceTopic == "" {
+		return
+	}
+	select {
+	case ipfs.announceCh <- c:
+	default:
+		ipfs.logger.Warningf("too many pin announcements pending. Not announcing %s", c)
+	}
+}
+
+// announceWorker publishes the queued announcements until shutdown.
+func (ipfs *Connector) announceWorker() {
+	defer ipfs.wg.Done()
+	for {
+		select {
+		case <-ipfs.ctx.Done():
+			return
+		case c := <-ipfs.announceCh:
+			ipfs.publishAnnouncement(c)
+		}
+	}
+}
+
+// publishAnnouncement publishes a PinAnnouncement for the given cid to
+// Config.PinAnnounceTopic.
+func (ipfs *Connector) publishAnnouncement(c *cid.Cid) {
+	topic := ipfs.config.PinAnnounceTopic
+	if topic == "" {
+		return
+	}
+
+	clusterID, err := ipfs.clusterPeerID()
+	if err != nil {
+		ipfs.logger.Errorf("announcing pin %s: %s", c, err)
+		return
+	}
+	msg, err := json.Marshal(PinAnnouncement{
+		Cid:         c.String(),
+		ClusterPeer: clusterID,
+	})
+	if err != nil {
+		ipfs.logger.Error(err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ipfs.ctx, ipfs.config.IPFSRequestTimeout)
+	defer cancel()
+	path := fmt.Sprintf("pubsub/pub?arg=%s&arg=%s",
+		url.QueryEscape(topic),
+		url.QueryEscape(string(msg)))
+	err = ipfs.postDiscardBodyCtx(ctx, path)
+	if err != nil {
+		ipfs.logger.Errorf("announcing pin %s on %s: %s", c, topic, err)
+		return
+	}
+	ipfs.logger.Debugf("announced pin %s on %s", c, topic)
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/ipfs/ipfs-cluster/config"
@@ -28,6 +29,16 @@ const (
 	DefaultUnpinTimeout           = 3 * time.Hour
 )
 
+// DefaultProxyBlockedPaths lists the IPFS API endpoints which are not
+// forwarded by the proxy by default, as they allow to administer the
+// IPFS daemon.
+var DefaultProxyBlockedPaths = []string{
+	"repo/gc",
+	"config",
+	"shutdown",
+	"key",
+}
+
 // Config is used to initialize a Connector and allows to customize
 // its behaviour. It implements the config.ComponentConfig interface.
 type Config struct {
@@ -70,6 +81,30 @@ type Config struct {
 
 	// Unpin Operation timeout
 	UnpinTimeout time.Duration
+
+	// PinBandwidthLimit is the average number of bytes per second
+	// that pin operations may fetch. When set, pins are fetched one
+	// at a time and spaced according to the size of the content
+	// pinned before them. 0 means no limit.
+	PinBandwidthLimit uint64
+
+	// ProxyMaxConcurrentRequests caps the number of requests that the
+	// proxy handles at the same time. Requests over the cap are
+	// rejected with 429 (Too Many Requests). 0 means no limit.
+	ProxyMaxConcurrentRequests int
+
+	// ProxyBlockedPaths lists IPFS API endpoints (relative to /api/v0,
+	// i.e. "repo/gc") which the proxy rejects with 403 instead of
+	// forwarding them to the IPFS daemon. Sub-paths of these endpoints
+	// (i.e. "config/show" for "config") are rejected too.
+	ProxyBlockedPaths []string
+
+	// PinAnnounceTopic is an IPFS pubsub topic on which a small
+	// announcement (CID and cluster peer ID) is published every time
+	// the IPFS daemon pins new content, so that gateways or indexers
+	// can pick it up. Empty (the default) disables announcements.
+	// Requires pubsub to be enabled in the IPFS daemon.
+	PinAnnounceTopic string
 }
 
 type jsonConfig struct {
@@ -84,6 +119,12 @@ type jsonConfig struct {
 	IPFSRequestTimeout      string `json:"ipfs_request_timeout"`
 	PinTimeout              string `json:"pin_timeout"`
 	UnpinTimeout            string `json:"unpin_timeout"`
+	PinBandwidthLimit       uint64 `json:"pin_bandwidth_limit"`
+
+	ProxyMaxConcurrentRequests int `json:"proxy_max_concurrent_requests"`
+
+	ProxyBlockedPaths []string `json:"proxy_blocked_paths"`
+	PinAnnounceTopic  string   `json:"pin_announce_topic,omitempty"`
 }
 
 // ConfigKey provides a human-friendly identifier for this type of Config.
@@ -106,6 +147,8 @@ func (cfg *Config) Default() error {
 	cfg.IPFSRequestTimeout = DefaultIPFSRequestTimeout
 	cfg.PinTimeout = DefaultPinTimeout
 	cfg.UnpinTimeout = DefaultUnpinTimeout
+	cfg.ProxyBlockedPaths = make([]string, len(DefaultProxyBlockedPaths))
+	copy(cfg.ProxyBlockedPaths, DefaultProxyBlockedPaths)
 
 	return nil
 }
@@ -158,6 +201,16 @@ func (cfg *Config) Validate() error {
 	if cfg.UnpinTimeout < 0 {
 		err = errors.New("ipfshttp.unpin_timeout invalid")
 	}
+
+	if cfg.ProxyMaxConcurrentRequests < 0 {
+		err = errors.New("ipfshttp.proxy_max_concurrent_requests invalid")
+	}
+
+	for _, p := range cfg.ProxyBlockedPaths {
+		if strings.Trim(p, "/") == "" {
+			err = errors.New("ipfshttp.proxy_blocked_paths has an empty path")
+		}
+	}
 	return err
 
 }
@@ -201,6 +254,14 @@ func (cfg *Config) LoadJSON(raw []byte) error {
 	}
 
 	config.SetIfNotDefault(jcfg.PinMethod, &cfg.PinMethod)
+	cfg.PinBandwidthLimit = jcfg.PinBandwidthLimit
+	cfg.ProxyMaxConcurrentRequests = jcfg.ProxyMaxConcurrentRequests
+
+	// An empty list explicitly disables blocking.
+	if jcfg.ProxyBlockedPaths != nil {
+		cfg.ProxyBlockedPaths = jcfg.ProxyBlockedPaths
+	}
+	cfg.PinAnnounceTopic = jcfg.PinAnnounceTopic
 
 	return cfg.Validate()
 }
@@ -228,6 +289,13 @@ func (cfg *Config) ToJSON() (raw []byte, err error) {
 	jcfg.IPFSRequestTimeout = cfg.IPFSRequestTimeout.String()
 	jcfg.PinTimeout = cfg.PinTimeout.String()
 	jcfg.UnpinTimeout = cfg.UnpinTimeout.String()
+	jcfg.PinBandwidthLimit = cfg.PinBandwidthLimit
+	jcfg.ProxyMaxConcurrentRequests = cfg.ProxyMaxConcurrentRequests
+	jcfg.ProxyBlockedPaths = cfg.ProxyBlockedPaths
+	if jcfg.ProxyBlockedPaths == nil {
+		jcfg.ProxyBlockedPaths = []string{}
+	}
+	jcfg.PinAnnounceTopic = cfg.PinAnnounceTopic
 
 	raw, err = config.DefaultJSONMarshal(jcfg)
 	return
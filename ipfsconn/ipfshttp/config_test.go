@@ -17,7 +17,10 @@ var cfgJSON = []byte(`
       "pin_method": "pin",
       "ipfs_request_timeout": "5m0s",
       "pin_timeout": "24h",
-      "unpin_timeout": "3h"
+      "unpin_timeout": "3h",
+      "pin_bandwidth_limit": 1048576,
+      "proxy_max_concurrent_requests": 50,
+      "pin_announce_topic": "cluster-pins"
 }
 `)
 
@@ -54,6 +57,18 @@ func TestLoadJSON(t *testing.T) {
 	if err == nil {
 		t.Error("expected error in proxy_read_timeout")
 	}
+
+	j = &jsonConfig{}
+	json.Unmarshal(cfgJSON, j)
+	j.ProxyBlockedPaths = []string{}
+	tst, _ = json.Marshal(j)
+	err = cfg.LoadJSON(tst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.ProxyBlockedPaths) != 0 {
+		t.Error("an empty proxy_blocked_paths should disable blocking")
+	}
 }
 
 func TestToJSON(t *testing.T) {
@@ -68,6 +83,15 @@ func TestToJSON(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	if cfg.PinBandwidthLimit != 1048576 {
+		t.Error("pin_bandwidth_limit not preserved")
+	}
+	if cfg.ProxyMaxConcurrentRequests != 50 {
+		t.Error("proxy_max_concurrent_requests not preserved")
+	}
+	if cfg.PinAnnounceTopic != "cluster-pins" {
+		t.Error("pin_announce_topic not preserved")
+	}
 }
 
 func TestDefault(t *testing.T) {
@@ -111,4 +135,10 @@ func TestDefault(t *testing.T) {
 	if cfg.Validate() == nil {
 		t.Fatal("expected error validating")
 	}
+
+	cfg.Default()
+	cfg.ProxyBlockedPaths = []string{"repo/gc", "/"}
+	if cfg.Validate() == nil {
+		t.Fatal("expected error validating")
+	}
 }
@@ -0,0 +1,138 @@
+package ipfshttp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ipfs/ipfs-cluster/api"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+type ipfsRefsResp struct {
+	Ref string
+	Err string
+}
+
+type ipfsBlockStatResp struct {
+	Key  string
+	Size uint64
+}
+
+// DAGStats walks the DAG under the given Cid and returns the number of
+// unique blocks in it, their total size, the depth of the DAG (the
+// number of blocks in its longest path) and its largest block. Links are
+// obtained with "refs -r", whose response is consumed as it is streamed,
+// and the size of every block with "block stat", so the DAG should be
+// available locally (i.e. pinned) to avoid fetching it from the network.
+func (ipfs *Connector) DAGStats(c *cid.Cid) (api.DAGStats, error) {
+	stats := api.DAGStats{Cid: c}
+
+	ctx, cancel := context.WithTimeout(ipfs.ctx, ipfs.config.PinTimeout)
+	defer cancel()
+
+	links, err := ipfs.dagLinks(ctx, c)
+	if err != nil {
+		ipfs.logger.Error(err)
+		return stats, err
+	}
+
+	// depths holds the length of the longest path starting at every
+	// visited block, so shared sub-DAGs are only walked once.
+	depths := make(map[string]int)
+	var walk func(k string) (int, error)
+	walk = func(k string) (int, error) {
+		if d, ok := depths[k]; ok {
+			return d, nil
+		}
+		size, err := ipfs.blockSize(ctx, k)
+		if err != nil {
+			return 0, err
+		}
+		stats.Blocks++
+		stats.TotalSize += size
+		if stats.LargestBlock == nil || size > stats.LargestBlockSize {
+			stats.LargestBlock, _ = cid.Decode(k)
+			stats.LargestBlockSize = size
+		}
+
+		depth := 0
+		for _, child := range links[k] {
+			d, err := walk(child)
+			if err != nil {
+				return 0, err
+			}
+			if d > depth {
+				depth = d
+			}
+		}
+		depths[k] = depth + 1
+		return depth + 1, nil
+	}
+
+	stats.Depth, err = walk(c.String())
+	if err != nil {
+		ipfs.logger.Error(err)
+		return stats, err
+	}
+	return stats, nil
+}
+
+// dagLinks returns the links of every block in the DAG under the given
+// Cid, indexed by the Cid of the parent block. The refs are decoded
+// while they are received rather than buffering the whole response.
+func (ipfs *Connector) dagLinks(ctx context.Context, c *cid.Cid) (map[string][]string, error) {
+	path := fmt.Sprintf("refs?arg=%s&recursive=true&format=%s",
+		c, url.QueryEscape("<src> <dst>"))
+	res, err := ipfs.doPostCtx(ctx, ipfs.client, ipfs.apiURL(), path)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(res.Body)
+		return nil, checkResponse(path, res.StatusCode, body)
+	}
+
+	links := make(map[string][]string)
+	dec := json.NewDecoder(res.Body)
+	for {
+		var ref ipfsRefsResp
+		err := dec.Decode(&ref)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if ref.Err != "" {
+			return nil, fmt.Errorf("listing refs of %s: %s", c, ref.Err)
+		}
+		parts := strings.Fields(ref.Ref)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("unexpected ref format: %s", ref.Ref)
+		}
+		links[parts[0]] = append(links[parts[0]], parts[1])
+	}
+	return links, nil
+}
+
+// blockSize returns the size of a block as provided by "block stat".
+func (ipfs *Connector) blockSize(ctx context.Context, k string) (uint64, error) {
+	res, err := ipfs.postCtx(ctx, "block/stat?arg="+k)
+	if err != nil {
+		return 0, err
+	}
+	var stat ipfsBlockStatResp
+	err = json.Unmarshal(res, &stat)
+	if err != nil {
+		return 0, err
+	}
+	return stat.Size, nil
+}
@@ -13,12 +13,15 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/logutil"
 
 	rpc "github.com/hsanjuan/go-libp2p-gorpc"
 	cid "github.com/ipfs/go-cid"
@@ -48,6 +51,7 @@ type Connector struct {
 	cancel func()
 
 	config   *Config
+	logger   *logutil.Logger
 	nodeAddr string
 
 	handlers map[string]func(http.ResponseWriter, *http.Request)
@@ -59,6 +63,18 @@ type Connector struct {
 	server   *http.Server // proxy server
 	client   *http.Client // client to ipfs daemon
 
+	pacer *pinPacer
+
+	// concurrent proxy requests, see limitHandler
+	proxySlots    chan struct{}
+	proxyMux      sync.Mutex
+	proxyActive   int
+	proxyRejected uint64
+
+	announceMux sync.Mutex
+	clusterID   string // cached, see clusterPeerID
+	announceCh  chan *cid.Cid
+
 	shutdownLock sync.Mutex
 	shutdown     bool
 	wg           sync.WaitGroup
@@ -81,8 +97,9 @@ type ipfsPinOpResp struct {
 }
 
 type ipfsIDResp struct {
-	ID        string
-	Addresses []string
+	ID           string
+	Addresses    []string
+	AgentVersion string
 }
 
 type ipfsRepoStatResp struct {
@@ -91,6 +108,11 @@ type ipfsRepoStatResp struct {
 	NumObjects uint64
 }
 
+type ipfsObjectStatResp struct {
+	Hash           string
+	CumulativeSize uint64
+}
+
 type ipfsAddResp struct {
 	Name  string
 	Hash  string
@@ -150,17 +172,18 @@ func NewConnector(cfg *Config) (*Connector, error) {
 		WriteTimeout:      cfg.ProxyWriteTimeout,
 		ReadHeaderTimeout: cfg.ProxyReadHeaderTimeout,
 		IdleTimeout:       cfg.ProxyIdleTimeout,
-		Handler:           smux,
 	}
 	s.SetKeepAlivesEnabled(true) // A reminder that this can be changed
 
 	c := &http.Client{} // timeouts are handled by context timeouts
 
 	ctx, cancel := context.WithCancel(context.Background())
+	log := logutil.New(logger)
 
 	ipfs := &Connector{
 		ctx:      ctx,
 		config:   cfg,
+		logger:   log,
 		cancel:   cancel,
 		nodeAddr: nodeAddr,
 		handlers: make(map[string]func(http.ResponseWriter, *http.Request)),
@@ -168,6 +191,12 @@ func NewConnector(cfg *Config) (*Connector, error) {
 		listener: l,
 		server:   s,
 		client:   c,
+		pacer:    newPinPacer(cfg.PinBandwidthLimit, log),
+
+		announceCh: make(chan *cid.Cid, announceQueueSize),
+	}
+	if cfg.ProxyMaxConcurrentRequests > 0 {
+		ipfs.proxySlots = make(chan struct{}, cfg.ProxyMaxConcurrentRequests)
 	}
 
 	smux.HandleFunc("/", ipfs.defaultHandler)
@@ -179,11 +208,17 @@ func NewConnector(cfg *Config) (*Connector, error) {
 	smux.HandleFunc("/api/v0/pin/ls/", ipfs.pinLsHandler)
 	smux.HandleFunc("/api/v0/add", ipfs.addHandler)
 	smux.HandleFunc("/api/v0/add/", ipfs.addHandler)
+	s.Handler = ipfs.limitHandler(ipfs.blockedPathsHandler(smux))
 
 	go ipfs.run()
 	return ipfs, nil
 }
 
+// SetLogPrefix sets the prefix of the messages logged by this component.
+func (ipfs *Connector) SetLogPrefix(prefix string) {
+	ipfs.logger.SetPrefix(prefix)
+}
+
 // launches proxy and connects all ipfs daemons when
 // we receive the rpcReady signal.
 func (ipfs *Connector) run() {
@@ -198,14 +233,14 @@ func (ipfs *Connector) run() {
 	ipfs.wg.Add(1)
 	go func() {
 		defer ipfs.wg.Done()
-		logger.Infof(
+		ipfs.logger.Infof(
 			"IPFS Proxy: %s -> %s",
 			ipfs.config.ProxyAddr,
 			ipfs.config.NodeAddr,
 		)
 		err := ipfs.server.Serve(ipfs.listener) // hangs here
 		if err != nil && !strings.Contains(err.Error(), "closed network connection") {
-			logger.Error(err)
+			ipfs.logger.Error(err)
 		}
 	}()
 
@@ -228,6 +263,10 @@ func (ipfs *Connector) run() {
 			return
 		}
 	}()
+
+	// This publishes pin announcements
+	ipfs.wg.Add(1)
+	go ipfs.announceWorker()
 }
 
 func (ipfs *Connector) proxyRequest(r *http.Request) (*http.Response, error) {
@@ -237,7 +276,7 @@ func (ipfs *Connector) proxyRequest(r *http.Request) (*http.Response, error) {
 
 	proxyReq, err := http.NewRequest(r.Method, newURL.String(), r.Body)
 	if err != nil {
-		logger.Error("error creating proxy request: ", err)
+		ipfs.logger.Error("error creating proxy request: ", err)
 		return nil, err
 	}
 
@@ -249,7 +288,7 @@ func (ipfs *Connector) proxyRequest(r *http.Request) (*http.Response, error) {
 
 	res, err := http.DefaultTransport.RoundTrip(proxyReq)
 	if err != nil {
-		logger.Error("error forwarding request: ", err)
+		ipfs.logger.Error("error forwarding request: ", err)
 		return nil, err
 	}
 	return res, nil
@@ -282,6 +321,82 @@ func (ipfs *Connector) defaultHandler(w http.ResponseWriter, r *http.Request) {
 	res.Body.Close()
 }
 
+// blockedPathsHandler rejects requests to any of the ProxyBlockedPaths
+// with 403 and passes the rest to the given handler.
+func (ipfs *Connector) blockedPathsHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ipfs.isBlockedPath(r.URL.Path) {
+			ipfs.logger.Warningf("blocked proxy request to %s from %s", r.URL.Path, r.RemoteAddr)
+			res := ipfsError{"Error: " + r.URL.Path + " is not allowed through the IPFS Cluster proxy"}
+			resBytes, _ := json.Marshal(res)
+			w.Header().Add("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			w.Write(resBytes)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// limitHandler rejects requests with 429 when the proxy is already
+// handling ProxyMaxConcurrentRequests, and passes the rest to the given
+// handler.
+func (ipfs *Connector) limitHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ipfs.proxySlots != nil {
+			select {
+			case ipfs.proxySlots <- struct{}{}:
+				defer func() { <-ipfs.proxySlots }()
+			default:
+				ipfs.proxyMux.Lock()
+				ipfs.proxyRejected++
+				ipfs.proxyMux.Unlock()
+				ipfs.logger.Warningf("too many concurrent proxy requests: rejecting %s from %s", r.URL.Path, r.RemoteAddr)
+				res := ipfsError{"Error: too many concurrent requests to the IPFS Cluster proxy"}
+				resBytes, _ := json.Marshal(res)
+				w.Header().Add("Content-Type", "application/json")
+				w.WriteHeader(http.StatusTooManyRequests)
+				w.Write(resBytes)
+				return
+			}
+		}
+
+		ipfs.proxyMux.Lock()
+		ipfs.proxyActive++
+		ipfs.proxyMux.Unlock()
+		defer func() {
+			ipfs.proxyMux.Lock()
+			ipfs.proxyActive--
+			ipfs.proxyMux.Unlock()
+		}()
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Usage reports the requests being handled by the proxy, its
+// concurrency limit and how many requests were rejected because of it.
+func (ipfs *Connector) Usage() api.ComponentUsage {
+	ipfs.proxyMux.Lock()
+	defer ipfs.proxyMux.Unlock()
+	return api.ComponentUsage{
+		Component: "proxy",
+		Active:    ipfs.proxyActive,
+		Limit:     cap(ipfs.proxySlots),
+		Rejected:  ipfs.proxyRejected,
+	}
+}
+
+func (ipfs *Connector) isBlockedPath(urlPath string) bool {
+	endpoint := strings.TrimPrefix(path.Clean(urlPath), "/api/v0/")
+	for _, b := range ipfs.config.ProxyBlockedPaths {
+		b = strings.Trim(b, "/")
+		if endpoint == b || strings.HasPrefix(endpoint, b+"/") {
+			return true
+		}
+	}
+	return false
+}
+
 func ipfsErrorResponder(w http.ResponseWriter, errMsg string) {
 	res := ipfsError{errMsg}
 	resBytes, _ := json.Marshal(res)
@@ -291,34 +406,60 @@ func ipfsErrorResponder(w http.ResponseWriter, errMsg string) {
 	return
 }
 
+// pinOpHandler performs the given operation (Pin or Unpin) on every
+// argument, in order, honoring the "recursive" flag (true by default, as
+// in ipfs). When one of them fails, the error tells which ones had
+// succeeded already.
 func (ipfs *Connector) pinOpHandler(op string, w http.ResponseWriter, r *http.Request) {
-	arg, ok := extractArgument(r.URL)
+	args, ok := extractArguments(r.URL)
 	if !ok {
 		ipfsErrorResponder(w, "Error: bad argument")
 		return
 	}
-	_, err := cid.Decode(arg)
-	if err != nil {
-		ipfsErrorResponder(w, "Error parsing CID: "+err.Error())
-		return
+	// Check all the arguments before doing anything, as ipfs does.
+	for _, arg := range args {
+		_, err := cid.Decode(arg)
+		if err != nil {
+			ipfsErrorResponder(w, "Error parsing CID: "+err.Error())
+			return
+		}
 	}
 
-	err = ipfs.rpcClient.Call(
-		"",
-		"Cluster",
-		op,
-		api.PinSerial{
-			Cid: arg,
-		},
-		&struct{}{},
-	)
-	if err != nil {
-		ipfsErrorResponder(w, err.Error())
-		return
+	recursive := true
+	if v := r.URL.Query().Get("recursive"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			ipfsErrorResponder(w, "Error: bad value for recursive: "+v)
+			return
+		}
+		recursive = b
+	}
+
+	for i, arg := range args {
+		err := ipfs.rpcClient.Call(
+			"",
+			"Cluster",
+			op,
+			api.PinSerial{
+				Cid:       arg,
+				Recursive: recursive,
+			},
+			&struct{}{},
+		)
+		if err != nil {
+			if i > 0 {
+				ipfsErrorResponder(w, fmt.Sprintf(
+					"Error: %s failed for %s after succeeding for %s: %s",
+					strings.ToLower(op), arg, strings.Join(args[:i], ", "), err))
+				return
+			}
+			ipfsErrorResponder(w, err.Error())
+			return
+		}
 	}
 
 	res := ipfsPinOpResp{
-		Pins: []string{arg},
+		Pins: args,
 	}
 	resBytes, _ := json.Marshal(res)
 	w.Header().Add("Content-Type", "application/json")
@@ -339,27 +480,29 @@ func (ipfs *Connector) pinLsHandler(w http.ResponseWriter, r *http.Request) {
 	pinLs := ipfsPinLsResp{}
 	pinLs.Keys = make(map[string]ipfsPinType)
 
-	arg, ok := extractArgument(r.URL)
+	args, ok := extractArguments(r.URL)
 	if ok {
-		c, err := cid.Decode(arg)
-		if err != nil {
-			ipfsErrorResponder(w, err.Error())
-			return
-		}
-		var pin api.PinSerial
-		err = ipfs.rpcClient.Call(
-			"",
-			"Cluster",
-			"PinGet",
-			api.PinCid(c).ToSerial(),
-			&pin,
-		)
-		if err != nil {
-			ipfsErrorResponder(w, fmt.Sprintf("Error: path '%s' is not pinned", arg))
-			return
-		}
-		pinLs.Keys[pin.Cid] = ipfsPinType{
-			Type: "recursive",
+		for _, arg := range args {
+			c, err := cid.Decode(arg)
+			if err != nil {
+				ipfsErrorResponder(w, err.Error())
+				return
+			}
+			var pin api.PinSerial
+			err = ipfs.rpcClient.Call(
+				"",
+				"Cluster",
+				"PinGet",
+				api.PinCid(c).ToSerial(),
+				&pin,
+			)
+			if err != nil {
+				ipfsErrorResponder(w, fmt.Sprintf("Error: path '%s' is not pinned", arg))
+				return
+			}
+			pinLs.Keys[pin.Cid] = ipfsPinType{
+				Type: "recursive",
+			}
 		}
 	} else {
 		var pins []api.PinSerial
@@ -408,13 +551,13 @@ func (ipfs *Connector) addHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Shortcut some cases where there is nothing else to do
 	if scode := res.StatusCode; scode != http.StatusOK {
-		logger.Warningf("proxy /add request returned %d", scode)
+		ipfs.logger.Warningf("proxy /add request returned %d", scode)
 		ipfs.proxyResponse(w, res, res.Body)
 		return
 	}
 
 	if doNotPin {
-		logger.Debug("proxy /add requests has pin==false")
+		ipfs.logger.Debug("proxy /add requests has pin==false")
 		ipfs.proxyResponse(w, res, res.Body)
 		return
 	}
@@ -446,7 +589,7 @@ func (ipfs *Connector) addHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if len(ipfsAddResps) == 0 {
-		logger.Warning("proxy /add request response was OK but empty")
+		ipfs.logger.Warning("proxy /add request response was OK but empty")
 		ipfs.proxyResponse(w, res, bodyCopy)
 		return
 	}
@@ -463,7 +606,7 @@ func (ipfs *Connector) addHandler(w http.ResponseWriter, r *http.Request) {
 	// more things than it should.
 	pinHashes := decideRecursivePins(ipfsAddResps, r.URL.Query())
 
-	logger.Debugf("proxy /add request and will pin %s", pinHashes)
+	ipfs.logger.Debugf("proxy /add request and will pin %s", pinHashes)
 	for _, pin := range pinHashes {
 		err := ipfs.rpcClient.Call(
 			"",
@@ -480,7 +623,7 @@ func (ipfs *Connector) addHandler(w http.ResponseWriter, r *http.Request) {
 			msg := "add operation was successful but "
 			msg += "an error occurred performing the cluster "
 			msg += "pin operation: " + err.Error()
-			logger.Error(msg)
+			ipfs.logger.Error(msg)
 			http.Error(w, msg, 500)
 			return
 		}
@@ -564,11 +707,11 @@ func (ipfs *Connector) Shutdown() error {
 	defer ipfs.shutdownLock.Unlock()
 
 	if ipfs.shutdown {
-		logger.Debug("already shutdown")
+		ipfs.logger.Debug("already shutdown")
 		return nil
 	}
 
-	logger.Info("stopping IPFS Proxy")
+	ipfs.logger.Info("stopping IPFS Proxy")
 
 	ipfs.cancel()
 	close(ipfs.rpcReady)
@@ -594,6 +737,7 @@ func (ipfs *Connector) ID() (api.IPFSID, error) {
 		id.Error = err.Error()
 		return id, err
 	}
+	id.Reachable = true
 
 	var res ipfsIDResp
 	err = json.Unmarshal(body, &res)
@@ -608,6 +752,7 @@ func (ipfs *Connector) ID() (api.IPFSID, error) {
 		return id, err
 	}
 	id.ID = pID
+	id.AgentVersion = res.AgentVersion
 
 	mAddrs := make([]ma.Multiaddr, len(res.Addresses), len(res.Addresses))
 	for i, strAddr := range res.Addresses {
@@ -623,7 +768,8 @@ func (ipfs *Connector) ID() (api.IPFSID, error) {
 }
 
 // Pin performs a pin request against the configured IPFS
-// daemon.
+// daemon. If the item is already pinned with a different type
+// (direct or recursive), the pin is replaced.
 func (ipfs *Connector) Pin(ctx context.Context, hash *cid.Cid, recursive bool) error {
 	ctx, cancel := context.WithTimeout(ctx, ipfs.config.PinTimeout)
 	defer cancel()
@@ -631,25 +777,78 @@ func (ipfs *Connector) Pin(ctx context.Context, hash *cid.Cid, recursive bool) e
 	if err != nil {
 		return err
 	}
+
+	// IPFS turns direct pins into recursive ones when pinning
+	// recursively, but a recursive pin must be removed before
+	// pinning directly.
+	if pinStatus == api.IPFSPinStatusRecursive && !recursive {
+		ipfs.logger.Infof("replacing recursive pin with a direct one: %s", hash)
+		_, err = ipfs.postCtx(ctx, fmt.Sprintf("pin/rm?arg=%s", hash))
+		if err != nil {
+			return err
+		}
+		pinStatus = api.IPFSPinStatusUnpinned
+	}
+	if pinStatus == api.IPFSPinStatusDirect && recursive {
+		pinStatus = api.IPFSPinStatusUnpinned
+	}
+
 	if !pinStatus.IsPinned() {
-		switch ipfs.config.PinMethod {
-		case "refs":
-			path := fmt.Sprintf("refs?arg=%s&recursive=%t", hash, recursive)
-			err := ipfs.postDiscardBodyCtx(ctx, path)
-			if err != nil {
-				return err
-			}
-			logger.Debugf("Refs for %s sucessfully fetched", hash)
+		err = ipfs.pacer.wait(ctx)
+		if err != nil {
+			return err
+		}
+		start := time.Now()
+		err = ipfs.pinAdd(ctx, hash, recursive)
+		ipfs.accountPin(hash, start, err == nil)
+		if err != nil {
+			return err
 		}
+		ipfs.logger.Info("IPFS Pin request succeeded: ", hash)
+		ipfs.announcePin(hash)
+		return nil
+	}
+	ipfs.logger.Debug("IPFS object is already pinned: ", hash)
+	return nil
+}
 
-		path := fmt.Sprintf("pin/add?arg=%s&recursive=%t", hash, recursive)
-		_, err = ipfs.postCtx(ctx, path)
-		if err == nil {
-			logger.Info("IPFS Pin request succeeded: ", hash)
+// pinAdd fetches and pins the given Cid with the configured PinMethod.
+func (ipfs *Connector) pinAdd(ctx context.Context, hash *cid.Cid, recursive bool) error {
+	switch ipfs.config.PinMethod {
+	case "refs":
+		path := fmt.Sprintf("refs?arg=%s&recursive=%t", hash, recursive)
+		err := ipfs.postDiscardBodyCtx(ctx, path)
+		if err != nil {
+			return err
 		}
+		ipfs.logger.Debugf("Refs for %s sucessfully fetched", hash)
+	}
+
+	path := fmt.Sprintf("pin/add?arg=%s&recursive=%t", hash, recursive)
+	_, err := ipfs.postCtx(ctx, path)
+	return err
+}
+
+// Prefetch fetches all the blocks of the DAG under the given Cid, as
+// "ipfs refs -r" does, but without pinning it. The blocks may be
+// garbage collected by the IPFS daemon until the Cid is pinned.
+func (ipfs *Connector) Prefetch(ctx context.Context, hash *cid.Cid) error {
+	ctx, cancel := context.WithTimeout(ctx, ipfs.config.PinTimeout)
+	defer cancel()
+
+	err := ipfs.pacer.wait(ctx)
+	if err != nil {
 		return err
 	}
-	logger.Debug("IPFS object is already pinned: ", hash)
+
+	start := time.Now()
+	path := fmt.Sprintf("refs?arg=%s&recursive=true", hash)
+	err = ipfs.postDiscardBodyCtx(ctx, path)
+	ipfs.accountPin(hash, start, err == nil)
+	if err != nil {
+		return err
+	}
+	ipfs.logger.Infof("IPFS prefetch of %s succeeded", hash)
 	return nil
 }
 
@@ -666,12 +865,12 @@ func (ipfs *Connector) Unpin(ctx context.Context, hash *cid.Cid) error {
 		path := fmt.Sprintf("pin/rm?arg=%s", hash)
 		_, err := ipfs.postCtx(ctx, path)
 		if err == nil {
-			logger.Info("IPFS Unpin request succeeded:", hash)
+			ipfs.logger.Info("IPFS Unpin request succeeded:", hash)
 		}
 		return err
 	}
 
-	logger.Debug("IPFS object is already unpinned: ", hash)
+	ipfs.logger.Debug("IPFS object is already unpinned: ", hash)
 	return nil
 }
 
@@ -690,8 +889,8 @@ func (ipfs *Connector) PinLs(ctx context.Context, typeFilter string) (map[string
 	var res ipfsPinLsResp
 	err = json.Unmarshal(body, &res)
 	if err != nil {
-		logger.Error("parsing pin/ls response")
-		logger.Error(string(body))
+		ipfs.logger.Error("parsing pin/ls response")
+		ipfs.logger.Error(string(body))
 		return nil, err
 	}
 
@@ -702,12 +901,24 @@ func (ipfs *Connector) PinLs(ctx context.Context, typeFilter string) (map[string
 	return statusMap, nil
 }
 
-// PinLsCid performs a "pin ls --type=recursive <hash> "request and returns
-// an api.IPFSPinStatus for that hash.
+// PinLsCid performs "pin ls --type=recursive <hash>" and, if not found,
+// "pin ls --type=direct <hash>" requests and returns an api.IPFSPinStatus
+// for that hash.
 func (ipfs *Connector) PinLsCid(ctx context.Context, hash *cid.Cid) (api.IPFSPinStatus, error) {
 	ctx, cancel := context.WithTimeout(ctx, ipfs.config.IPFSRequestTimeout)
 	defer cancel()
-	lsPath := fmt.Sprintf("pin/ls?arg=%s&type=recursive", hash)
+
+	// Indirect pins are not checked, as that requires traversing
+	// all recursive pins.
+	ips, err := ipfs.pinLsCidType(ctx, hash, "recursive")
+	if err != nil || ips.IsPinned() {
+		return ips, err
+	}
+	return ipfs.pinLsCidType(ctx, hash, "direct")
+}
+
+func (ipfs *Connector) pinLsCidType(ctx context.Context, hash *cid.Cid, typeFilter string) (api.IPFSPinStatus, error) {
+	lsPath := fmt.Sprintf("pin/ls?arg=%s&type=%s", hash, typeFilter)
 	body, err := ipfs.postCtx(ctx, lsPath)
 
 	// Network error, daemon down
@@ -723,8 +934,8 @@ func (ipfs *Connector) PinLsCid(ctx context.Context, hash *cid.Cid) (api.IPFSPin
 	var res ipfsPinLsResp
 	err = json.Unmarshal(body, &res)
 	if err != nil {
-		logger.Error("parsing pin/ls?arg=cid response:")
-		logger.Error(string(body))
+		ipfs.logger.Error("parsing pin/ls?arg=cid response:")
+		ipfs.logger.Error(string(body))
 		return api.IPFSPinStatusError, err
 	}
 	pinObj, ok := res.Keys[hash.String()]
@@ -736,18 +947,18 @@ func (ipfs *Connector) PinLsCid(ctx context.Context, hash *cid.Cid) (api.IPFSPin
 }
 
 func (ipfs *Connector) doPostCtx(ctx context.Context, client *http.Client, apiURL, path string) (*http.Response, error) {
-	logger.Debugf("posting %s", path)
+	ipfs.logger.Debugf("posting %s", path)
 	urlstr := fmt.Sprintf("%s/%s", apiURL, path)
 
 	req, err := http.NewRequest("POST", urlstr, nil)
 	if err != nil {
-		logger.Error("error creating POST request:", err)
+		ipfs.logger.Error("error creating POST request:", err)
 	}
 
 	req = req.WithContext(ctx)
 	res, err := ipfs.client.Do(req)
 	if err != nil {
-		logger.Error("error posting to IPFS:", err)
+		ipfs.logger.Error("error posting to IPFS:", err)
 	}
 
 	return res, err
@@ -780,7 +991,7 @@ func (ipfs *Connector) postCtx(ctx context.Context, path string) ([]byte, error)
 	defer res.Body.Close()
 	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
-		logger.Errorf("error reading response body: %s", err)
+		ipfs.logger.Errorf("error reading response body: %s", err)
 		return nil, err
 	}
 	return body, checkResponse(path, res.StatusCode, body)
@@ -821,10 +1032,10 @@ func (ipfs *Connector) ConnectSwarms() error {
 		&idsSerial,
 	)
 	if err != nil {
-		logger.Error(err)
+		ipfs.logger.Error(err)
 		return err
 	}
-	logger.Debugf("%+v", idsSerial)
+	ipfs.logger.Debugf("%+v", idsSerial)
 
 	for _, idSerial := range idsSerial {
 		ipfsID := idSerial.IPFS
@@ -834,10 +1045,10 @@ func (ipfs *Connector) ConnectSwarms() error {
 			// when passing in a bunch of addresses
 			_, err := ipfs.postCtx(ctx, fmt.Sprintf("swarm/connect?arg=%s", addr))
 			if err != nil {
-				logger.Debug(err)
+				ipfs.logger.Debug(err)
 				continue
 			}
-			logger.Debugf("ipfs successfully connected to %s", addr)
+			ipfs.logger.Debugf("ipfs successfully connected to %s", addr)
 		}
 	}
 	return nil
@@ -851,14 +1062,14 @@ func (ipfs *Connector) ConfigKey(keypath string) (interface{}, error) {
 	defer cancel()
 	res, err := ipfs.postCtx(ctx, "config/show")
 	if err != nil {
-		logger.Error(err)
+		ipfs.logger.Error(err)
 		return nil, err
 	}
 
 	var cfg map[string]interface{}
 	err = json.Unmarshal(res, &cfg)
 	if err != nil {
-		logger.Error(err)
+		ipfs.logger.Error(err)
 		return nil, err
 	}
 
@@ -897,14 +1108,14 @@ func (ipfs *Connector) FreeSpace() (uint64, error) {
 	defer cancel()
 	res, err := ipfs.postCtx(ctx, "repo/stat")
 	if err != nil {
-		logger.Error(err)
+		ipfs.logger.Error(err)
 		return 0, err
 	}
 
 	var stats ipfsRepoStatResp
 	err = json.Unmarshal(res, &stats)
 	if err != nil {
-		logger.Error(err)
+		ipfs.logger.Error(err)
 		return 0, err
 	}
 	return stats.StorageMax - stats.RepoSize, nil
@@ -917,19 +1128,52 @@ func (ipfs *Connector) RepoSize() (uint64, error) {
 	defer cancel()
 	res, err := ipfs.postCtx(ctx, "repo/stat")
 	if err != nil {
-		logger.Error(err)
+		ipfs.logger.Error(err)
 		return 0, err
 	}
 
 	var stats ipfsRepoStatResp
 	err = json.Unmarshal(res, &stats)
 	if err != nil {
-		logger.Error(err)
+		ipfs.logger.Error(err)
 		return 0, err
 	}
 	return stats.RepoSize, nil
 }
 
+// CumulativeSize returns the size of the DAG under the given Cid as
+// provided by "object stat". The value is in bytes.
+func (ipfs *Connector) CumulativeSize(c *cid.Cid) (uint64, error) {
+	ctx, cancel := context.WithTimeout(ipfs.ctx, ipfs.config.IPFSRequestTimeout)
+	defer cancel()
+	res, err := ipfs.postCtx(ctx, "object/stat?arg="+c.String())
+	if err != nil {
+		ipfs.logger.Error(err)
+		return 0, err
+	}
+
+	var stats ipfsObjectStatResp
+	err = json.Unmarshal(res, &stats)
+	if err != nil {
+		ipfs.logger.Error(err)
+		return 0, err
+	}
+	return stats.CumulativeSize, nil
+}
+
+// BlockSize returns the size of the block with the given Cid as
+// provided by "block stat". The value is in bytes.
+func (ipfs *Connector) BlockSize(c *cid.Cid) (uint64, error) {
+	ctx, cancel := context.WithTimeout(ipfs.ctx, ipfs.config.IPFSRequestTimeout)
+	defer cancel()
+	size, err := ipfs.blockSize(ctx, c.String())
+	if err != nil {
+		ipfs.logger.Error(err)
+		return 0, err
+	}
+	return size, nil
+}
+
 // SwarmPeers returns the peers currently connected to this ipfs daemon
 func (ipfs *Connector) SwarmPeers() (api.SwarmPeers, error) {
 	ctx, cancel := context.WithTimeout(ipfs.ctx, ipfs.config.IPFSRequestTimeout)
@@ -937,13 +1181,13 @@ func (ipfs *Connector) SwarmPeers() (api.SwarmPeers, error) {
 	swarm := api.SwarmPeers{}
 	res, err := ipfs.postCtx(ctx, "swarm/peers")
 	if err != nil {
-		logger.Error(err)
+		ipfs.logger.Error(err)
 		return swarm, err
 	}
 	var peersRaw ipfsSwarmPeersResp
 	err = json.Unmarshal(res, &peersRaw)
 	if err != nil {
-		logger.Error(err)
+		ipfs.logger.Error(err)
 		return swarm, err
 	}
 
@@ -951,7 +1195,7 @@ func (ipfs *Connector) SwarmPeers() (api.SwarmPeers, error) {
 	for i, p := range peersRaw.Peers {
 		pID, err := peer.IDB58Decode(p.Peer)
 		if err != nil {
-			logger.Error(err)
+			ipfs.logger.Error(err)
 			return swarm, err
 		}
 		swarm[i] = pID
@@ -959,6 +1203,28 @@ func (ipfs *Connector) SwarmPeers() (api.SwarmPeers, error) {
 	return swarm, nil
 }
 
+// extractArguments works like extractArgument but returns all the
+// arguments given with "arg" query parameters, as sent by ipfs when a
+// command is given several paths (i.e. "ipfs pin add <cid1> <cid2>").
+// "/ipfs/<cid>" paths are accepted and turned into plain cids.
+func extractArguments(u *url.URL) ([]string, bool) {
+	var args []string
+	for _, arg := range u.Query()["arg"] {
+		if arg != "" {
+			args = append(args, strings.TrimPrefix(arg, "/ipfs/"))
+		}
+	}
+	if len(args) > 0 {
+		return args, true
+	}
+
+	arg, ok := extractArgument(u)
+	if !ok {
+		return nil, false
+	}
+	return []string{arg}, true
+}
+
 // extractArgument extracts the cid argument from a url.URL, either via
 // the query string parameters or from the url path itself.
 func extractArgument(u *url.URL) (string, bool) {
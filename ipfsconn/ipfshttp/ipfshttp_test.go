@@ -8,7 +8,9 @@ import (
 	"io/ioutil"
 	"mime/multipart"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
 	"time"
 
@@ -63,6 +65,12 @@ func TestIPFSID(t *testing.T) {
 	if len(id.Addresses) != 1 {
 		t.Error("expected 1 address")
 	}
+	if id.AgentVersion != test.IpfsMockAgentVersion {
+		t.Error("expected the agent version of the daemon")
+	}
+	if !id.Reachable {
+		t.Error("expected the daemon to be reachable")
+	}
 	if id.Error != "" {
 		t.Error("expected no error")
 	}
@@ -71,6 +79,9 @@ func TestIPFSID(t *testing.T) {
 	if err == nil {
 		t.Error("expected an error")
 	}
+	if id.Reachable {
+		t.Error("the daemon should not be reachable")
+	}
 	if id.Error != err.Error() {
 		t.Error("error messages should match")
 	}
@@ -109,6 +120,49 @@ func TestIPFSPin(t *testing.T) {
 	t.Run("method=refs", func(t *testing.T) { testPin(t, "refs") })
 }
 
+func TestIPFSPinAnnounce(t *testing.T) {
+	ctx := context.Background()
+	ipfs, mock := testIPFSConnector(t)
+	defer mock.Close()
+	defer ipfs.Shutdown()
+
+	ipfs.config.PinAnnounceTopic = "cluster-pins"
+
+	c, _ := cid.Decode(test.TestCid1)
+	err := ipfs.Pin(ctx, c, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Already pinned: not announced again
+	err = ipfs.Pin(ctx, c, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Announcements are published in the background
+	var msgs []string
+	for i := 0; i < 20; i++ {
+		msgs = mock.PubsubMessages("cluster-pins")
+		if len(msgs) > 0 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	msgs = mock.PubsubMessages("cluster-pins")
+	if len(msgs) != 1 {
+		t.Fatalf("expected 1 announcement, got %d", len(msgs))
+	}
+	var ann PinAnnouncement
+	err = json.Unmarshal([]byte(msgs[0]), &ann)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ann.Cid != test.TestCid1 || ann.ClusterPeer != test.TestPeerID1.Pretty() {
+		t.Errorf("unexpected announcement: %+v", ann)
+	}
+}
+
 func TestIPFSUnpin(t *testing.T) {
 	ctx := context.Background()
 	ipfs, mock := testIPFSConnector(t)
@@ -126,6 +180,37 @@ func TestIPFSUnpin(t *testing.T) {
 	}
 }
 
+func TestIPFSPrefetch(t *testing.T) {
+	ctx := context.Background()
+	ipfs, mock := testIPFSConnector(t)
+	defer mock.Close()
+	defer ipfs.Shutdown()
+	ipfs.pacer = newPinPacer(1000, nil)
+	c, _ := cid.Decode(test.TestCid1)
+	start := time.Now()
+	err := ipfs.Prefetch(ctx, c)
+	if err != nil {
+		t.Fatal("expected success prefetching cid:", err)
+	}
+	// The mock reports a cumulative size of 1000 bytes.
+	if ipfs.pacer.next.Before(start.Add(time.Second)) {
+		t.Error("prefetched content should count towards the pin bandwidth limit")
+	}
+	ips, err := ipfs.PinLsCid(ctx, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ips.IsPinned() {
+		t.Error("a prefetched cid should not be pinned")
+	}
+
+	c2, _ := cid.Decode(test.ErrorCid)
+	err = ipfs.Prefetch(ctx, c2)
+	if err == nil {
+		t.Error("expected error prefetching cid")
+	}
+}
+
 func TestIPFSPinLsCid(t *testing.T) {
 	ctx := context.Background()
 	ipfs, mock := testIPFSConnector(t)
@@ -301,6 +386,70 @@ func TestIPFSProxyPin(t *testing.T) {
 	}
 }
 
+func TestIPFSProxyPinMultiple(t *testing.T) {
+	ipfs, mock := testIPFSConnector(t)
+	defer mock.Close()
+	defer ipfs.Shutdown()
+
+	u := fmt.Sprintf("%s/pin/add?arg=%s&arg=/ipfs/%s", proxyURL(ipfs), test.TestCid1, test.TestCid2)
+	res, err := http.Post(u, "", nil)
+	if err != nil {
+		t.Fatal("should have succeeded: ", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("statusCode: got = %v, want %v", res.StatusCode, http.StatusOK)
+	}
+
+	var resp ipfsPinOpResp
+	resBytes, _ := ioutil.ReadAll(res.Body)
+	err = json.Unmarshal(resBytes, &resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Pins) != 2 || resp.Pins[0] != test.TestCid1 || resp.Pins[1] != test.TestCid2 {
+		t.Errorf("expected both cids to be pinned: %v", resp.Pins)
+	}
+
+	// Nothing is pinned when an argument is wrong
+	u = fmt.Sprintf("%s/pin/add?arg=%s&arg=abc", proxyURL(ipfs), test.TestCid1)
+	res2, err := http.Post(u, "", nil)
+	if err != nil {
+		t.Fatal("should have succeeded: ", err)
+	}
+	defer res2.Body.Close()
+	if res2.StatusCode != http.StatusInternalServerError {
+		t.Errorf("statusCode: got = %v, want %v", res2.StatusCode, http.StatusInternalServerError)
+	}
+
+	// The error tells which items were pinned before the failure
+	u = fmt.Sprintf("%s/pin/add?arg=%s&arg=%s&recursive=false", proxyURL(ipfs), test.TestCid1, test.ErrorCid)
+	res3, err := http.Post(u, "", nil)
+	if err != nil {
+		t.Fatal("should have succeeded: ", err)
+	}
+	defer res3.Body.Close()
+	if res3.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("statusCode: got = %v, want %v", res3.StatusCode, http.StatusInternalServerError)
+	}
+	var respErr ipfsError
+	resBytes, _ = ioutil.ReadAll(res3.Body)
+	json.Unmarshal(resBytes, &respErr)
+	if !strings.Contains(respErr.Message, "succeeding for "+test.TestCid1) {
+		t.Errorf("the error should list the pinned items: %s", respErr.Message)
+	}
+
+	u = fmt.Sprintf("%s/pin/add?arg=%s&recursive=maybe", proxyURL(ipfs), test.TestCid1)
+	res4, err := http.Post(u, "", nil)
+	if err != nil {
+		t.Fatal("should have succeeded: ", err)
+	}
+	defer res4.Body.Close()
+	if res4.StatusCode != http.StatusInternalServerError {
+		t.Errorf("statusCode: got = %v, want %v", res4.StatusCode, http.StatusInternalServerError)
+	}
+}
+
 func TestIPFSProxyUnpin(t *testing.T) {
 	ipfs, mock := testIPFSConnector(t)
 	defer mock.Close()
@@ -663,6 +812,83 @@ func TestProxyError(t *testing.T) {
 	}
 }
 
+func TestProxyBlockedPaths(t *testing.T) {
+	ipfs, mock := testIPFSConnector(t)
+	defer mock.Close()
+	defer ipfs.Shutdown()
+
+	blocked := []string{
+		"/repo/gc",
+		"/config/show",
+		"/config?arg=Datastore",
+		"/shutdown",
+		"/key/gen?arg=abc",
+		"//key/list",
+	}
+	for _, b := range blocked {
+		res, err := http.Post(proxyURL(ipfs)+b, "", nil)
+		if err != nil {
+			t.Fatal("should have succeeded: ", err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusForbidden {
+			t.Errorf("%s: expected 403 but got %d", b, res.StatusCode)
+		}
+	}
+
+	res, err := http.Post(proxyURL(ipfs)+"/version", "", nil)
+	if err != nil {
+		t.Fatal("should have succeeded: ", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Error("/version should not be blocked")
+	}
+
+	if ipfs.isBlockedPath("/api/v0/keys") {
+		t.Error("only the key endpoint and its sub-paths should be blocked")
+	}
+}
+
+func TestProxyConcurrencyLimit(t *testing.T) {
+	ipfs, mock := testIPFSConnector(t)
+	defer mock.Close()
+	defer ipfs.Shutdown()
+
+	ipfs.proxySlots = make(chan struct{}, 1)
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	h := ipfs.limitHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-unblock
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v0/version", nil))
+	}()
+	<-started
+
+	usage := ipfs.Usage()
+	if usage.Active != 1 || usage.Limit != 1 {
+		t.Errorf("expected 1 active request with a limit of 1: %+v", usage)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/api/v0/version", nil))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("expected 429 but got %d", rec.Code)
+	}
+
+	close(unblock)
+	<-done
+	usage = ipfs.Usage()
+	if usage.Active != 0 || usage.Rejected != 1 {
+		t.Errorf("expected no active requests and 1 rejected: %+v", usage)
+	}
+}
+
 func TestIPFSShutdown(t *testing.T) {
 	ipfs, mock := testIPFSConnector(t)
 	defer mock.Close()
@@ -736,6 +962,78 @@ func TestRepoSize(t *testing.T) {
 	}
 }
 
+func TestCumulativeSize(t *testing.T) {
+	ipfs, mock := testIPFSConnector(t)
+	defer mock.Close()
+	defer ipfs.Shutdown()
+
+	c, _ := cid.Decode(test.TestCid1)
+	s, err := ipfs.CumulativeSize(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// See the ipfs mock implementation
+	if s != 1000 {
+		t.Error("expected 1000 bytes of size")
+	}
+}
+
+func TestBlockSize(t *testing.T) {
+	ipfs, mock := testIPFSConnector(t)
+	defer mock.Close()
+	defer ipfs.Shutdown()
+
+	c, _ := cid.Decode(test.TestCid3)
+	s, err := ipfs.BlockSize(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// See the ipfs mock implementation
+	if s != 300 {
+		t.Error("expected 300 bytes of size")
+	}
+}
+
+func TestDAGStats(t *testing.T) {
+	ipfs, mock := testIPFSConnector(t)
+	defer mock.Close()
+	defer ipfs.Shutdown()
+
+	// See the ipfs mock implementation: Cid1 links to Cid2 and Cid3,
+	// and Cid2 links to Cid3 too.
+	c, _ := cid.Decode(test.TestCid1)
+	stats, err := ipfs.DAGStats(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stats.Cid.Equals(c) {
+		t.Error("wrong cid")
+	}
+	if stats.Blocks != 3 {
+		t.Error("expected 3 unique blocks:", stats.Blocks)
+	}
+	if stats.TotalSize != 500 {
+		t.Error("expected 500 bytes of size:", stats.TotalSize)
+	}
+	// Cid1 -> Cid2 -> Cid3 is the longest path
+	if stats.Depth != 3 {
+		t.Error("expected a depth of 3:", stats.Depth)
+	}
+	if stats.LargestBlock.String() != test.TestCid3 || stats.LargestBlockSize != 300 {
+		t.Error("wrong largest block")
+	}
+
+	// A DAG made of a single block
+	c2, _ := cid.Decode(test.TestCid2)
+	stats, err = ipfs.DAGStats(c2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Blocks != 1 || stats.Depth != 1 || stats.TotalSize != 100 {
+		t.Error("unexpected stats for a single block")
+	}
+}
+
 func TestConfigKey(t *testing.T) {
 	ipfs, mock := testIPFSConnector(t)
 	defer mock.Close()
@@ -836,6 +1134,26 @@ func Test_extractArgument(t *testing.T) {
 	}
 }
 
+func Test_extractArguments(t *testing.T) {
+	u := mustParseURL(fmt.Sprintf("/api/v0/pin/add?arg=%s&arg=/ipfs/%s", test.TestCid1, test.TestCid2))
+	args, ok := extractArguments(u)
+	if !ok || len(args) != 2 || args[0] != test.TestCid1 || args[1] != test.TestCid2 {
+		t.Errorf("unexpected arguments: %v", args)
+	}
+
+	u = mustParseURL(fmt.Sprintf("/api/v0/pin/add/%s", test.TestCid1))
+	args, ok = extractArguments(u)
+	if !ok || len(args) != 1 || args[0] != test.TestCid1 {
+		t.Errorf("unexpected arguments: %v", args)
+	}
+
+	u = mustParseURL("/api/v0/pin/ls")
+	_, ok = extractArguments(u)
+	if ok {
+		t.Error("expected no arguments")
+	}
+}
+
 func mustParseURL(rawurl string) *url.URL {
 	u, err := url.Parse(rawurl)
 	if err != nil {
@@ -0,0 +1,100 @@
+package ipfshttp
+
+import (
+	"context"
+	"time"
+
+	"github.com/ipfs/ipfs-cluster/logutil"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// pinPacer spaces pin operations so that, on average, the content they
+// fetch does not exceed a number of bytes per second. IPFS does not allow
+// throttling transfers, so the size of every pin is only known once it
+// has been fetched: the time that it should have taken at the given rate
+// delays the pins which come after it. For that to hold, paced operations
+// run one at a time.
+type pinPacer struct {
+	limit  uint64 // bytes per second. 0 means no limit.
+	logger *logutil.Logger
+
+	slot chan struct{} // held by the paced operation in progress
+	next time.Time     // pins cannot start before this time. Guarded by slot.
+}
+
+func newPinPacer(limit uint64, logger *logutil.Logger) *pinPacer {
+	return &pinPacer{
+		limit:  limit,
+		logger: logger,
+		slot:   make(chan struct{}, 1),
+	}
+}
+
+// wait blocks until a new pin operation can start or the context is
+// cancelled. When it returns nil, done must be called once the
+// operation finishes.
+func (p *pinPacer) wait(ctx context.Context) error {
+	if p.limit == 0 {
+		return nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case p.slot <- struct{}{}:
+	}
+
+	d := time.Until(p.next)
+	if d <= 0 {
+		return nil
+	}
+
+	p.logger.Debugf("pin bandwidth limit reached. Waiting %s", d)
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		<-p.slot
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// done registers a pin operation which started at the given time and
+// fetched the given amount of bytes, and lets the next one start.
+func (p *pinPacer) done(start time.Time, size uint64) {
+	if p.limit == 0 {
+		return
+	}
+	d := time.Duration(float64(size) / float64(p.limit) * float64(time.Second))
+	if p.next.Before(start) {
+		p.next = start
+	}
+	p.next = p.next.Add(d)
+	<-p.slot
+}
+
+// accountPin finishes a paced pin or prefetch operation, registering the
+// size of the given Cid with the pin pacer when it was fetched. "object stat"
+// only understands UnixFS (dag-pb) nodes, so the size of any other block
+// is obtained with "block stat".
+func (ipfs *Connector) accountPin(c *cid.Cid, start time.Time, fetched bool) {
+	if ipfs.pacer.limit == 0 {
+		return
+	}
+	if !fetched {
+		ipfs.pacer.done(start, 0)
+		return
+	}
+	sizeFn := ipfs.CumulativeSize
+	if c.Type() != cid.DagProtobuf {
+		sizeFn = ipfs.BlockSize
+	}
+	size, err := sizeFn(c)
+	if err != nil {
+		ipfs.logger.Warningf("cannot obtain the size of %s to limit pinning bandwidth: %s", c, err)
+	}
+	ipfs.pacer.done(start, size)
+}
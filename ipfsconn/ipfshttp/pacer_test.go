@@ -0,0 +1,73 @@
+package ipfshttp
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPinPacer(t *testing.T) {
+	ctx := context.Background()
+
+	p := newPinPacer(0, nil)
+	p.done(time.Now(), 1024*1024)
+	if time.Until(p.next) > 0 {
+		t.Error("no limit should not delay pins")
+	}
+
+	p = newPinPacer(1000, nil)
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		err := p.wait(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.done(now, 500)
+	}
+	if !p.next.Equal(now.Add(time.Second)) {
+		t.Errorf("expected next pin at %s, got %s", now.Add(time.Second), p.next)
+	}
+
+	start := time.Now()
+	err := p.wait(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) < 500*time.Millisecond {
+		t.Error("wait should have blocked")
+	}
+	p.done(time.Now(), 10000)
+
+	cctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	err = p.wait(cctx)
+	if err == nil {
+		t.Error("expected an error when the context is cancelled")
+	}
+}
+
+func TestPinPacerOneAtATime(t *testing.T) {
+	ctx := context.Background()
+	p := newPinPacer(1000, nil)
+
+	err := p.wait(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A second operation cannot start while the first is running,
+	// even if no bytes have been accounted yet.
+	cctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	err = p.wait(cctx)
+	if err == nil {
+		t.Fatal("wait should have blocked while another pin is in progress")
+	}
+
+	p.done(time.Now(), 0)
+	err = p.wait(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.done(time.Now(), 0)
+}
@@ -0,0 +1,292 @@
+package ipfscluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ipfs/ipfs-cluster/api"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+// How many finished jobs are remembered. Older ones are forgotten when
+// new jobs start.
+var maxFinishedJobs = 100
+
+var errJobNotFound = errors.New("job not found")
+
+// jobManager keeps the jobs started by this peer: long-running
+// operations which run in the background while clients poll their
+// progress. Jobs only live in memory and are cancelled on shutdown.
+type jobManager struct {
+	mux  sync.Mutex
+	jobs map[string]*job
+}
+
+type job struct {
+	api.Job
+	cancel func()
+}
+
+func newJobManager() *jobManager {
+	return &jobManager{
+		jobs: make(map[string]*job),
+	}
+}
+
+// start registers a new running job and returns it along with the
+// context it should run with.
+func (jm *jobManager) start(ctx context.Context, t api.JobType) (*job, context.Context) {
+	jm.mux.Lock()
+	defer jm.mux.Unlock()
+	jm.forgetOld()
+
+	ctx, cancel := context.WithCancel(ctx)
+	j := &job{
+		Job: api.Job{
+			ID:      api.NewRequestID(),
+			Type:    t,
+			Status:  api.JobStatusRunning,
+			Started: time.Now(),
+		},
+		cancel: cancel,
+	}
+	jm.jobs[j.ID] = j
+	return j, ctx
+}
+
+// progress updates the progress of a job.
+func (jm *jobManager) progress(j *job, done, total int) {
+	jm.mux.Lock()
+	defer jm.mux.Unlock()
+	j.Done = done
+	j.Total = total
+}
+
+// finish marks a job as finished with the given results.
+func (jm *jobManager) finish(j *job, result []api.GlobalPinInfo, err error) {
+	jm.mux.Lock()
+	defer jm.mux.Unlock()
+	j.cancel()
+	j.Finished = time.Now()
+	j.Result = result
+	switch {
+	case err == context.Canceled:
+		j.Status = api.JobStatusCancelled
+	case err != nil:
+		j.Status = api.JobStatusFailed
+		j.Error = err.Error()
+	default:
+		j.Status = api.JobStatusDone
+	}
+}
+
+func (jm *jobManager) get(id string) (api.Job, error) {
+	jm.mux.Lock()
+	defer jm.mux.Unlock()
+	j, ok := jm.jobs[id]
+	if !ok {
+		return api.Job{}, errJobNotFound
+	}
+	return j.Job, nil
+}
+
+// list returns all jobs, newest first.
+func (jm *jobManager) list() []api.Job {
+	jm.mux.Lock()
+	defer jm.mux.Unlock()
+	jobs := make([]api.Job, 0, len(jm.jobs))
+	for _, j := range jm.jobs {
+		jobs = append(jobs, j.Job)
+	}
+	sort.Slice(jobs, func(i, k int) bool {
+		return jobs[i].Started.After(jobs[k].Started)
+	})
+	return jobs
+}
+
+// cancelJob cancels a running job. Its status changes once the
+// operation stops.
+func (jm *jobManager) cancelJob(id string) error {
+	jm.mux.Lock()
+	defer jm.mux.Unlock()
+	j, ok := jm.jobs[id]
+	if !ok {
+		return errJobNotFound
+	}
+	if j.Status != api.JobStatusRunning {
+		return fmt.Errorf("job %s is not running", id)
+	}
+	j.cancel()
+	return nil
+}
+
+// forgetOld removes the oldest finished jobs when there are more than
+// maxFinishedJobs. It must be called with the lock held.
+func (jm *jobManager) forgetOld() {
+	var finished []*job
+	for _, j := range jm.jobs {
+		if j.Status != api.JobStatusRunning {
+			finished = append(finished, j)
+		}
+	}
+	if len(finished) < maxFinishedJobs {
+		return
+	}
+	sort.Slice(finished, func(i, k int) bool {
+		return finished[i].Finished.Before(finished[k].Finished)
+	})
+	for _, j := range finished[:len(finished)-maxFinishedJobs+1] {
+		delete(jm.jobs, j.ID)
+	}
+}
+
+// StartJob starts the given long-running operation in the background and
+// returns the job tracking it. Use Job() to follow its progress and
+// obtain its results. Jobs are kept by this peer only.
+func (c *Cluster) StartJob(req api.JobRequest) (api.Job, error) {
+	var run func(ctx context.Context, progress func(done, total int)) ([]api.GlobalPinInfo, error)
+	switch req.Type {
+	case api.JobTypeRecoverAll:
+		// Fail early with bad filters
+		if _, err := recoverFilter(req.Filter); err != nil {
+			return api.Job{}, err
+		}
+		run = func(ctx context.Context, progress func(done, total int)) ([]api.GlobalPinInfo, error) {
+			return c.recoverAll(ctx, req.Filter, req.Concurrency, progress)
+		}
+	case api.JobTypeSyncAll:
+		run = func(ctx context.Context, progress func(done, total int)) ([]api.GlobalPinInfo, error) {
+			progress(0, 1)
+			gpis, err := c.globalPinInfoSlice(ctx, "SyncAllLocal", struct{}{}, 0)
+			if err == nil {
+				progress(1, 1)
+			}
+			return gpis, err
+		}
+	case api.JobTypeRebalance:
+		if !c.config.RebalanceAllocations {
+			return api.Job{}, errors.New("rebalancing needs rebalance_allocations to be enabled")
+		}
+		run = func(ctx context.Context, progress func(done, total int)) ([]api.GlobalPinInfo, error) {
+			return nil, c.rebalanceAll(ctx, progress)
+		}
+	case api.JobTypeImport:
+		if len(req.Pins) == 0 {
+			return api.Job{}, errors.New("there are no pins to import")
+		}
+		pins := make([]api.Pin, len(req.Pins), len(req.Pins))
+		for i, ps := range req.Pins {
+			pins[i] = ps.ToPin()
+			if pins[i].Cid == nil {
+				return api.Job{}, fmt.Errorf("bad CID in imported pins: %s", ps.Cid)
+			}
+			// Allocations are decided by this cluster
+			pins[i].Allocations = nil
+		}
+		run = func(ctx context.Context, progress func(done, total int)) ([]api.GlobalPinInfo, error) {
+			return nil, c.importPins(ctx, pins, progress)
+		}
+	default:
+		return api.Job{}, fmt.Errorf("unknown job type: %s", req.Type)
+	}
+
+	j, ctx := c.jobs.start(c.ctx, req.Type)
+	c.logger.Infof("starting %s job %s", req.Type, j.ID)
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		// Operations return the context error only when they were
+		// interrupted, so that a job cancelled just as it completed
+		// is still reported as done.
+		res, err := run(ctx, func(done, total int) {
+			c.jobs.progress(j, done, total)
+		})
+		c.jobs.finish(j, res, err)
+		c.logger.Infof("%s job %s finished", req.Type, j.ID)
+	}()
+	return c.jobs.get(j.ID)
+}
+
+// Job returns the job with the given ID.
+func (c *Cluster) Job(id string) (api.Job, error) {
+	return c.jobs.get(id)
+}
+
+// Jobs returns the jobs started by this peer, newest first. Only the
+// latest finished jobs are remembered.
+func (c *Cluster) Jobs() []api.Job {
+	return c.jobs.list()
+}
+
+// CancelJob cancels a running job.
+func (c *Cluster) CancelJob(id string) error {
+	return c.jobs.cancelJob(id)
+}
+
+// rebalanceAll re-allocates every pin which is not pinned everywhere, so
+// that their allocations are rebalanced as described in
+// Config.RebalanceAllocations. Pins which fail to be re-allocated are
+// logged and skipped.
+func (c *Cluster) rebalanceAll(ctx context.Context, progress func(done, total int)) error {
+	st, err := c.consensus.State()
+	if err != nil {
+		return err
+	}
+	var pins []api.Pin
+	for _, pin := range st.List() {
+		if pin.IsPinEverywhere() || pin.ReplicationFactorMin == api.ReplicationFactorEverywhere {
+			continue
+		}
+		pins = append(pins, pin)
+	}
+	c.logger.Infof("rebalancing the allocations of %d pins", len(pins))
+	progress(0, len(pins))
+
+	failed := 0
+	for i, pin := range pins {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		changed, err := c.pin(pin.StripRequest(), []peer.ID{}, nil)
+		switch {
+		case err != nil:
+			c.logger.Errorf("rebalancing %s: %s", pin.Cid, err)
+			failed++
+		case changed:
+			c.logger.Debugf("%s was re-allocated", pin.Cid)
+		}
+		progress(i+1, len(pins))
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d pins could not be rebalanced", failed, len(pins))
+	}
+	return nil
+}
+
+// importPins pins the given pins. Failures are logged and the rest of
+// the pins are still pinned.
+func (c *Cluster) importPins(ctx context.Context, pins []api.Pin, progress func(done, total int)) error {
+	c.logger.Infof("importing %d pins", len(pins))
+	progress(0, len(pins))
+
+	failed := 0
+	for i, pin := range pins {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := c.Pin(pin); err != nil {
+			c.logger.Errorf("importing %s: %s", pin.Cid, err)
+			failed++
+		}
+		progress(i+1, len(pins))
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d pins could not be imported", failed, len(pins))
+	}
+	return nil
+}
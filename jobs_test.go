@@ -0,0 +1,175 @@
+package ipfscluster
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/test"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+func TestJobManager(t *testing.T) {
+	jm := newJobManager()
+	j, ctx := jm.start(context.Background(), api.JobTypeRecoverAll)
+
+	jm.progress(j, 1, 2)
+	job, err := jm.get(j.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != api.JobStatusRunning || job.Done != 1 || job.Total != 2 {
+		t.Error("unexpected job progress")
+	}
+
+	err = jm.cancelJob(j.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctx.Err() == nil {
+		t.Fatal("the job context should have been cancelled")
+	}
+	jm.finish(j, nil, ctx.Err())
+	job, _ = jm.get(j.ID)
+	if job.Status != api.JobStatusCancelled || job.Finished.IsZero() {
+		t.Error("the job should be cancelled")
+	}
+	if jm.cancelJob(j.ID) == nil {
+		t.Error("finished jobs cannot be cancelled")
+	}
+
+	j2, _ := jm.start(context.Background(), api.JobTypeSyncAll)
+	jm.finish(j2, nil, errors.New("boom"))
+	job, _ = jm.get(j2.ID)
+	if job.Status != api.JobStatusFailed || job.Error != "boom" {
+		t.Error("the job should have failed")
+	}
+
+	if len(jm.list()) != 2 {
+		t.Error("expected 2 jobs")
+	}
+	if _, err := jm.get("abc"); err != errJobNotFound {
+		t.Error("expected errJobNotFound")
+	}
+}
+
+func TestJobManagerForgetsOldJobs(t *testing.T) {
+	defer func(n int) { maxFinishedJobs = n }(maxFinishedJobs)
+	maxFinishedJobs = 2
+
+	jm := newJobManager()
+	var ids []string
+	for i := 0; i < 4; i++ {
+		j, _ := jm.start(context.Background(), api.JobTypeSyncAll)
+		jm.finish(j, nil, nil)
+		ids = append(ids, j.ID)
+		time.Sleep(time.Millisecond)
+	}
+	if len(jm.list()) != 2 {
+		t.Fatal("only the latest finished jobs should be kept")
+	}
+	if _, err := jm.get(ids[3]); err != nil {
+		t.Error("the latest job should be kept")
+	}
+}
+
+func TestClusterStartJob(t *testing.T) {
+	cl, _, _, _, _ := testingCluster(t)
+	defer cleanRaft()
+	defer cl.Shutdown()
+
+	c, _ := cid.Decode(test.TestCid1)
+	err := cl.Pin(api.PinCid(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinDelay()
+
+	_, err = cl.StartJob(api.JobRequest{Type: "abc"})
+	if err == nil {
+		t.Error("expected an error with an unknown job type")
+	}
+	_, err = cl.StartJob(api.JobRequest{
+		Type:              api.JobTypeRecoverAll,
+		RecoverAllRequest: api.RecoverAllRequest{Filter: "bad"},
+	})
+	if err == nil {
+		t.Error("expected an error with an invalid filter")
+	}
+
+	job, err := cl.StartJob(api.JobRequest{
+		Type:              api.JobTypeRecoverAll,
+		RecoverAllRequest: api.RecoverAllRequest{Filter: "pinned"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 50; i++ {
+		job, err = cl.Job(job.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Status != api.JobStatusRunning {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if job.Status != api.JobStatusDone {
+		t.Fatalf("the job should have finished: %+v", job)
+	}
+	if job.Done != 1 || job.Total != 1 || len(job.Result) != 1 {
+		t.Error("expected the pinned item to be recovered")
+	}
+	if len(cl.Jobs()) != 1 {
+		t.Error("expected 1 job")
+	}
+}
+
+func TestClusterImportJob(t *testing.T) {
+	cl, _, _, _, _ := testingCluster(t)
+	defer cleanRaft()
+	defer cl.Shutdown()
+
+	_, err := cl.StartJob(api.JobRequest{Type: api.JobTypeRebalance})
+	if err == nil {
+		t.Error("rebalancing should need RebalanceAllocations")
+	}
+	_, err = cl.StartJob(api.JobRequest{Type: api.JobTypeImport})
+	if err == nil {
+		t.Error("expected an error importing no pins")
+	}
+
+	c1, _ := cid.Decode(test.TestCid1)
+	c2, _ := cid.Decode(test.TestCid2)
+	job, err := cl.StartJob(api.JobRequest{
+		Type: api.JobTypeImport,
+		Pins: []api.PinSerial{
+			api.PinCid(c1).ToSerial(),
+			api.PinCid(c2).ToSerial(),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50; i++ {
+		job, err = cl.Job(job.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Status != api.JobStatusRunning {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if job.Status != api.JobStatusDone || job.Done != 2 || job.Total != 2 {
+		t.Fatalf("the job should have imported 2 pins: %+v", job)
+	}
+	pinDelay()
+	if len(cl.Pins()) != 2 {
+		t.Error("expected 2 pins")
+	}
+}
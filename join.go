@@ -0,0 +1,146 @@
+package ipfscluster
+
+import (
+	"errors"
+	"fmt"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+	ma "github.com/multiformats/go-multiaddr"
+
+	"github.com/ipfs/ipfs-cluster/api"
+)
+
+// validateJoin checks that this peer can join the cluster that the given
+// peer belongs to. Joining is only supported from single-peer clusters
+// with an empty shared state: the state of the joined cluster replaces
+// this peer's one, and a different history in the consensus log would
+// corrupt both. Peers with existing pins should be restarted with a clean
+// state (see MergePins to keep those pins).
+func (c *Cluster) validateJoin(pid peer.ID) error {
+	peers, err := c.consensus.Peers()
+	if err != nil {
+		return err
+	}
+	if containsPeer(peers, pid) {
+		// Already joined. Nothing will change.
+		return nil
+	}
+
+	var others int
+	for _, p := range peers {
+		if p != c.id {
+			others++
+		}
+	}
+	if others > 0 {
+		return fmt.Errorf(
+			"cannot join %s: this peer is already part of a cluster with %d other peers. Remove it from that cluster first",
+			pid.Pretty(), others)
+	}
+
+	cState, err := c.consensus.State()
+	if err != nil {
+		return err
+	}
+	if n := cState.Len(); n > 0 {
+		return fmt.Errorf(
+			"cannot join %s: this peer's shared state is not empty (%d pins) and would conflict with the joined cluster's. Restart this peer with a clean state to join",
+			pid.Pretty(), n)
+	}
+	return nil
+}
+
+// Peerset returns the multiaddresses of all the current cluster peers,
+// including this one, as known by this peer. Peers joining the cluster use
+// it to find other members to join through.
+func (c *Cluster) Peerset() ([]ma.Multiaddr, error) {
+	peers, err := c.consensus.Peers()
+	if err != nil {
+		return nil, err
+	}
+	return append(c.hostAddresses(), c.peerManager.PeersAddresses(peers)...), nil
+}
+
+// fetchPeerset asks the given peer for the current peerset of its cluster.
+// When it does not answer, the rest of the peers in our peerstore (i.e.
+// from the peerstore file or other bootstrap addresses) are asked instead.
+// Peersets which do not include the given peer belong to a different
+// cluster and are ignored.
+func (c *Cluster) fetchPeerset(target peer.ID) []ma.Multiaddr {
+	asked := []peer.ID{target}
+	for _, p := range c.host.Peerstore().Peers() {
+		if p != target && p != c.id {
+			asked = append(asked, p)
+		}
+	}
+
+	for _, p := range asked {
+		var addrs api.MultiaddrsSerial
+		err := c.rpcClient.Call(p,
+			"Cluster",
+			"Peerset",
+			struct{}{},
+			&addrs)
+		if err != nil {
+			c.logger.Debugf("fetching the peerset from %s: %s", p.Pretty(), err)
+			continue
+		}
+		peerset := addrs.ToMultiaddrs()
+		if !containsPeer(PeersFromMultiaddrs(peerset), target) {
+			c.logger.Debugf("the peerset of %s does not include %s. Ignoring it", p.Pretty(), target.Pretty())
+			continue
+		}
+		return peerset
+	}
+	return nil
+}
+
+// joinCandidates returns the peers, other than the given one, through
+// which this peer can join the cluster of the given peer: the rest of
+// the members of its peerset. Their addresses are added to the
+// peerstore.
+func (c *Cluster) joinCandidates(target peer.ID) []peer.ID {
+	var candidates []peer.ID
+	for _, addr := range c.fetchPeerset(target) {
+		pid, _, err := api.Libp2pMultiaddrSplit(addr)
+		if err != nil || pid == c.id || pid == target {
+			continue
+		}
+		c.peerManager.ImportPeer(addr, false)
+		if !containsPeer(candidates, pid) {
+			candidates = append(candidates, pid)
+		}
+	}
+	return candidates
+}
+
+// MergePins pins in the cluster those of the given pins which are not
+// part of the shared state yet. It is meant to be used after joining
+// a cluster from a peer which had its own pinset (for example, the state
+// backed up before bootstrapping). Merged pins are allocated to this peer
+// preferentially, since it is likely to have the content already. Names,
+// replication factors and metadata are kept, while allocations are
+// calculated again. It returns the number of pins which were added.
+// Pins cannot be merged when Config.AuthorizedPublishers is set, since
+// they are not signed.
+func (c *Cluster) MergePins(pins []api.Pin) (int, error) {
+	if len(c.config.AuthorizedPublishers) > 0 {
+		return 0, errors.New("pins cannot be merged: pins must be signed by an authorized publisher")
+	}
+
+	var merged int
+	for _, pin := range pins {
+		if _, exists := c.getCurrentPin(pin.Cid); exists {
+			continue
+		}
+		pin.Allocations = nil
+		_, err := c.pin(pin, []peer.ID{}, []peer.ID{c.id})
+		if err != nil {
+			c.logger.Errorf("merging pin %s: %s", pin.Cid, err)
+			return merged, err
+		}
+		merged++
+	}
+	c.logger.Infof("merged %d pins into the shared state", merged)
+	return merged, nil
+}
@@ -0,0 +1,79 @@
+package ipfscluster
+
+import (
+	"context"
+	"runtime"
+	"sync/atomic"
+
+	"github.com/ipfs/ipfs-cluster/api"
+)
+
+// workLimiter caps the number of operations of a subsystem which run at
+// the same time, and counts those running and waiting.
+type workLimiter struct {
+	name  string
+	slots chan struct{} // nil when there is no limit
+
+	active int32
+	queued int32
+}
+
+// newWorkLimiter returns a workLimiter allowing limit concurrent
+// operations. 0 means no limit.
+func newWorkLimiter(name string, limit int) *workLimiter {
+	l := &workLimiter{name: name}
+	if limit > 0 {
+		l.slots = make(chan struct{}, limit)
+	}
+	return l
+}
+
+// acquire blocks until an operation can start or the context is
+// cancelled. release must be called when a successful acquire's
+// operation finishes.
+func (l *workLimiter) acquire(ctx context.Context) error {
+	if l.slots != nil {
+		atomic.AddInt32(&l.queued, 1)
+		defer atomic.AddInt32(&l.queued, -1)
+		select {
+		case l.slots <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	atomic.AddInt32(&l.active, 1)
+	return nil
+}
+
+func (l *workLimiter) release() {
+	atomic.AddInt32(&l.active, -1)
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+func (l *workLimiter) usage() api.ComponentUsage {
+	return api.ComponentUsage{
+		Component: l.name,
+		Active:    int(atomic.LoadInt32(&l.active)),
+		Queued:    int(atomic.LoadInt32(&l.queued)),
+		Limit:     cap(l.slots),
+	}
+}
+
+// ResourceUsage returns the number of goroutines of this peer and the
+// work handled by its subsystems: broadcasts to other peers and the
+// components which implement UsageReporter (i.e. the pin tracker and
+// the IPFS proxy).
+func (c *Cluster) ResourceUsage() api.ResourceUsage {
+	usage := api.ResourceUsage{
+		Goroutines: runtime.NumGoroutine(),
+		Components: []api.ComponentUsage{c.broadcasts.usage()},
+	}
+	for _, comp := range []Component{c.tracker, c.ipfs} {
+		if r, ok := comp.(UsageReporter); ok {
+			usage.Components = append(usage.Components, r.Usage())
+		}
+	}
+	return usage
+}
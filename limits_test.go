@@ -0,0 +1,71 @@
+package ipfscluster
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWorkLimiter(t *testing.T) {
+	l := newWorkLimiter("test", 1)
+	err := l.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acquired := make(chan error)
+	go func() {
+		acquired <- l.acquire(context.Background())
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	usage := l.usage()
+	if usage.Active != 1 || usage.Queued != 1 || usage.Limit != 1 {
+		t.Errorf("expected 1 active and 1 queued operation: %+v", usage)
+	}
+
+	l.release()
+	if err := <-acquired; err != nil {
+		t.Fatal(err)
+	}
+	l.release()
+	if usage := l.usage(); usage.Active != 0 || usage.Queued != 0 {
+		t.Errorf("expected no operations: %+v", usage)
+	}
+
+	l.acquire(context.Background())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if l.acquire(ctx) == nil {
+		t.Error("expected an error with a cancelled context")
+	}
+
+	unlimited := newWorkLimiter("test", 0)
+	for i := 0; i < 10; i++ {
+		unlimited.acquire(context.Background())
+	}
+	if usage := unlimited.usage(); usage.Active != 10 || usage.Limit != 0 {
+		t.Errorf("expected 10 active operations and no limit: %+v", usage)
+	}
+}
+
+func TestClusterResourceUsage(t *testing.T) {
+	cl, _, _, _, _ := testingCluster(t)
+	defer cleanRaft()
+	defer cl.Shutdown()
+
+	usage := cl.ResourceUsage()
+	if usage.Goroutines == 0 {
+		t.Error("expected some goroutines")
+	}
+
+	names := make(map[string]bool)
+	for _, u := range usage.Components {
+		names[u.Component] = true
+	}
+	for _, name := range []string{"broadcasts", "pintracker"} {
+		if !names[name] {
+			t.Errorf("expected the usage of %s", name)
+		}
+	}
+}
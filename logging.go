@@ -1,9 +1,86 @@
 package ipfscluster
 
-import logging "github.com/ipfs/go-log"
+import (
+	"errors"
+	"fmt"
 
+	"github.com/ipfs/ipfs-cluster/logutil"
+
+	logging "github.com/ipfs/go-log"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+// logger is the "cluster" facility logger. Cluster peers, and the
+// components owned by them in this package, log through their own
+// instanceLogger instead. logger is only used directly by code which does
+// not belong to a peer, like configuration parsing.
+//
+// The same applies to sub-packages: their components (consensus, pin
+// tracker, IPFS connector, APIs, monitors, informers...) log through a
+// logutil.Logger prefixed with the ID of the peer (see LogPrefixer),
+// while their package-level facility loggers are only used by code which
+// does not belong to a component. Log levels are still set per facility
+// (see LoggingFacilities), for all the peers in the process.
 var logger = logging.Logger("cluster")
 
+// instanceLogger logs to the "cluster" facility, prefixing every message
+// with the (shortened) ID of the Cluster peer which produced it, so that
+// several peers running in the same process can be told apart. A nil
+// instanceLogger logs without prefix.
+type instanceLogger struct {
+	prefix string
+}
+
+func newInstanceLogger(pid peer.ID) *instanceLogger {
+	return &instanceLogger{prefix: logutil.PeerPrefix(pid)}
+}
+
+func (l *instanceLogger) pfx() string {
+	if l == nil {
+		return ""
+	}
+	return l.prefix
+}
+
+func (l *instanceLogger) Debug(args ...interface{}) {
+	logger.Debug(l.pfx() + fmt.Sprint(args...))
+}
+
+func (l *instanceLogger) Debugf(format string, args ...interface{}) {
+	logger.Debugf(l.pfx()+format, args...)
+}
+
+func (l *instanceLogger) Info(args ...interface{}) {
+	logger.Info(l.pfx() + fmt.Sprint(args...))
+}
+
+func (l *instanceLogger) Infof(format string, args ...interface{}) {
+	logger.Infof(l.pfx()+format, args...)
+}
+
+func (l *instanceLogger) Warning(args ...interface{}) {
+	logger.Warning(l.pfx() + fmt.Sprint(args...))
+}
+
+func (l *instanceLogger) Warningf(format string, args ...interface{}) {
+	logger.Warningf(l.pfx()+format, args...)
+}
+
+func (l *instanceLogger) Error(args ...interface{}) {
+	logger.Error(l.pfx() + fmt.Sprint(args...))
+}
+
+func (l *instanceLogger) Errorf(format string, args ...interface{}) {
+	logger.Errorf(l.pfx()+format, args...)
+}
+
+// logError logs an error message and returns it as an error.
+func (l *instanceLogger) logError(fmtstr string, args ...interface{}) error {
+	msg := fmt.Sprintf(fmtstr, args...)
+	l.Error(msg)
+	return errors.New(msg)
+}
+
 // LoggingFacilities provides a list of logging identifiers
 // used by cluster and their default logging level.
 var LoggingFacilities = map[string]string{
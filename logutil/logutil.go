@@ -0,0 +1,106 @@
+// Package logutil provides the loggers used by the components of a
+// Cluster peer. Several peers may run in the same process (tests,
+// simulators, multi-tenant hosts), so every component logs through its
+// own Logger, which prefixes messages with the ID of the peer it belongs
+// to, instead of through the facility logger of its package.
+package logutil
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	logging "github.com/ipfs/go-log"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+// Logger logs to a facility logger (i.e. "consensus"), prefixing every
+// message with the prefix set with SetPrefix. It is safe to use and to
+// set the prefix concurrently. A nil Logger discards messages.
+type Logger struct {
+	facility logging.StandardLogger
+	prefix   atomic.Value // string
+}
+
+// New returns a Logger which logs to the given facility logger without
+// prefix.
+func New(facility logging.StandardLogger) *Logger {
+	l := &Logger{facility: facility}
+	l.prefix.Store("")
+	return l
+}
+
+// PeerPrefix returns the prefix used for the messages of the given peer:
+// the end of its ID, which is enough to tell peers apart.
+func PeerPrefix(pid peer.ID) string {
+	id := peer.IDB58Encode(pid)
+	if len(id) > 6 {
+		id = id[len(id)-6:]
+	}
+	return "<" + id + "> "
+}
+
+// SetPrefix sets the prefix of the following messages.
+func (l *Logger) SetPrefix(prefix string) {
+	l.prefix.Store(prefix)
+}
+
+// Prefix returns the current prefix.
+func (l *Logger) Prefix() string {
+	return l.prefix.Load().(string)
+}
+
+// Debug logs at debug level.
+func (l *Logger) Debug(args ...interface{}) {
+	if l != nil {
+		l.facility.Debug(l.Prefix() + fmt.Sprint(args...))
+	}
+}
+
+// Debugf logs at debug level.
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	if l != nil {
+		l.facility.Debugf(l.Prefix()+format, args...)
+	}
+}
+
+// Info logs at info level.
+func (l *Logger) Info(args ...interface{}) {
+	if l != nil {
+		l.facility.Info(l.Prefix() + fmt.Sprint(args...))
+	}
+}
+
+// Infof logs at info level.
+func (l *Logger) Infof(format string, args ...interface{}) {
+	if l != nil {
+		l.facility.Infof(l.Prefix()+format, args...)
+	}
+}
+
+// Warning logs at warning level.
+func (l *Logger) Warning(args ...interface{}) {
+	if l != nil {
+		l.facility.Warning(l.Prefix() + fmt.Sprint(args...))
+	}
+}
+
+// Warningf logs at warning level.
+func (l *Logger) Warningf(format string, args ...interface{}) {
+	if l != nil {
+		l.facility.Warningf(l.Prefix()+format, args...)
+	}
+}
+
+// Error logs at error level.
+func (l *Logger) Error(args ...interface{}) {
+	if l != nil {
+		l.facility.Error(l.Prefix() + fmt.Sprint(args...))
+	}
+}
+
+// Errorf logs at error level.
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	if l != nil {
+		l.facility.Errorf(l.Prefix()+format, args...)
+	}
+}
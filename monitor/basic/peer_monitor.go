@@ -11,6 +11,7 @@ import (
 	"sync"
 
 	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/logutil"
 	"github.com/ipfs/ipfs-cluster/monitor/metrics"
 	"github.com/ipfs/ipfs-cluster/rpcutil"
 
@@ -33,6 +34,7 @@ type Monitor struct {
 	checker *metrics.Checker
 
 	config *Config
+	logger *logutil.Logger
 
 	shutdownLock sync.Mutex
 	shutdown     bool
@@ -59,6 +61,7 @@ func NewMonitor(cfg *Config) (*Monitor, error) {
 		metrics: mtrs,
 		checker: checker,
 		config:  cfg,
+		logger:  logutil.New(logger),
 	}
 
 	go mon.run()
@@ -73,6 +76,11 @@ func (mon *Monitor) run() {
 	}
 }
 
+// SetLogPrefix sets the prefix of the messages logged by this component.
+func (mon *Monitor) SetLogPrefix(prefix string) {
+	mon.logger.SetPrefix(prefix)
+}
+
 // SetClient saves the given rpc.Client  for later use
 func (mon *Monitor) SetClient(c *rpc.Client) {
 	mon.rpcClient = c
@@ -86,11 +94,11 @@ func (mon *Monitor) Shutdown() error {
 	defer mon.shutdownLock.Unlock()
 
 	if mon.shutdown {
-		logger.Warning("Monitor already shut down")
+		mon.logger.Warning("Monitor already shut down")
 		return nil
 	}
 
-	logger.Info("stopping Monitor")
+	mon.logger.Info("stopping Monitor")
 	close(mon.rpcReady)
 	mon.cancel()
 	mon.wg.Wait()
@@ -101,14 +109,14 @@ func (mon *Monitor) Shutdown() error {
 // LogMetric stores a metric so it can later be retrieved.
 func (mon *Monitor) LogMetric(m api.Metric) error {
 	mon.metrics.Add(m)
-	logger.Debugf("basic monitor logged '%s' metric from '%s'. Expires on %d", m.Name, m.Peer, m.Expire)
+	mon.logger.Debugf("basic monitor logged '%s' metric from '%s'. Expires on %d", m.Name, m.Peer, m.Expire)
 	return nil
 }
 
 // PublishMetric broadcasts a metric to all current cluster peers.
 func (mon *Monitor) PublishMetric(m api.Metric) error {
 	if m.Discard() {
-		logger.Warningf("discarding invalid metric: %+v", m)
+		mon.logger.Warningf("discarding invalid metric: %+v", m)
 		return nil
 	}
 
@@ -120,7 +128,7 @@ func (mon *Monitor) PublishMetric(m api.Metric) error {
 	ctxs, cancels := rpcutil.CtxsWithTimeout(mon.ctx, len(peers), m.GetTTL()/2)
 	defer rpcutil.MultiCancel(cancels)
 
-	logger.Debugf(
+	mon.logger.Debugf(
 		"broadcasting metric %s to %s. Expires: %d",
 		m.Name,
 		peers,
@@ -147,7 +155,7 @@ func (mon *Monitor) PublishMetric(m api.Metric) error {
 				peers[i].Pretty(),
 				e,
 			)
-			logger.Errorf(errStr)
+			mon.logger.Errorf(errStr)
 			errStrs = append(errStrs, errStr)
 		}
 	}
@@ -156,7 +164,7 @@ func (mon *Monitor) PublishMetric(m api.Metric) error {
 		return errors.New(strings.Join(errStrs, "\n"))
 	}
 
-	logger.Debugf(
+	mon.logger.Debugf(
 		"broadcasted metric %s to [%s]. Expires: %d",
 		m.Name,
 		peers,
@@ -176,7 +184,7 @@ func (mon *Monitor) getPeers() ([]peer.ID, error) {
 		&peers,
 	)
 	if err != nil {
-		logger.Error(err)
+		mon.logger.Error(err)
 	}
 	return peers, err
 }
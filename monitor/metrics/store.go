@@ -2,6 +2,7 @@ package metrics
 
 import (
 	"sync"
+	"time"
 
 	"github.com/ipfs/ipfs-cluster/api"
 
@@ -24,8 +25,11 @@ func NewStore() *Store {
 	}
 }
 
-// Add inserts a new metric in Metrics.
+// Add inserts a new metric in Metrics. The time of reception is
+// recorded in the metric.
 func (mtrs *Store) Add(m api.Metric) {
+	m.Received = time.Now().UnixNano()
+
 	mtrs.mux.Lock()
 	defer mtrs.mux.Unlock()
 
@@ -22,7 +22,10 @@ func TestStoreLatest(t *testing.T) {
 
 	latest := store.Latest("test")
 	if len(latest) != 1 {
-		t.Error("expected 1 metric")
+		t.Fatal("expected 1 metric")
+	}
+	if latest[0].Received == 0 {
+		t.Error("the reception time should have been set")
 	}
 
 	time.Sleep(220 * time.Millisecond)
@@ -8,6 +8,7 @@ import (
 	"sync"
 
 	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/logutil"
 	"github.com/ipfs/ipfs-cluster/monitor/metrics"
 
 	rpc "github.com/hsanjuan/go-libp2p-gorpc"
@@ -41,6 +42,7 @@ type Monitor struct {
 	checker *metrics.Checker
 
 	config *Config
+	logger *logutil.Logger
 
 	shutdownLock sync.Mutex
 	shutdown     bool
@@ -56,15 +58,38 @@ func New(h host.Host, cfg *Config) (*Monitor, error) {
 
 	ctx, cancel := context.WithCancel(context.Background())
 
-	mtrs := metrics.NewStore()
-	checker := metrics.NewChecker(mtrs)
-
 	pubsub, err := floodsub.NewFloodSub(ctx, h)
 	if err != nil {
 		cancel()
 		return nil, err
 	}
 
+	return newMonitor(ctx, cancel, h, pubsub, cfg)
+}
+
+// NewWithPubSub creates a new PubSub monitor which uses the given PubSub
+// instance, so that it can be shared with other components. Only one
+// PubSub instance can run on a libp2p host.
+func NewWithPubSub(h host.Host, pubsub *floodsub.PubSub, cfg *Config) (*Monitor, error) {
+	err := cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return newMonitor(ctx, cancel, h, pubsub, cfg)
+}
+
+func newMonitor(
+	ctx context.Context,
+	cancel func(),
+	h host.Host,
+	pubsub *floodsub.PubSub,
+	cfg *Config,
+) (*Monitor, error) {
+	mtrs := metrics.NewStore()
+	checker := metrics.NewChecker(mtrs)
+
 	subscription, err := pubsub.Subscribe(PubsubTopic)
 	if err != nil {
 		cancel()
@@ -83,7 +108,9 @@ func New(h host.Host, cfg *Config) (*Monitor, error) {
 		metrics: mtrs,
 		checker: checker,
 		config:  cfg,
+		logger:  logutil.New(logger),
 	}
+	mon.logger.SetPrefix(logutil.PeerPrefix(h.ID()))
 
 	go mon.run()
 	return mon, nil
@@ -116,10 +143,10 @@ func (mon *Monitor) logFromPubsub() {
 			metric := api.Metric{}
 			err = dec.Decode(&metric)
 			if err != nil {
-				logger.Error(err)
+				mon.logger.Error(err)
 				continue
 			}
-			logger.Debugf(
+			mon.logger.Debugf(
 				"received pubsub metric '%s' from '%s'",
 				metric.Name,
 				metric.Peer,
@@ -127,13 +154,18 @@ func (mon *Monitor) logFromPubsub() {
 
 			err = mon.LogMetric(metric)
 			if err != nil {
-				logger.Error(err)
+				mon.logger.Error(err)
 				continue
 			}
 		}
 	}
 }
 
+// SetLogPrefix sets the prefix of the messages logged by this component.
+func (mon *Monitor) SetLogPrefix(prefix string) {
+	mon.logger.SetPrefix(prefix)
+}
+
 // SetClient saves the given rpc.Client  for later use
 func (mon *Monitor) SetClient(c *rpc.Client) {
 	mon.rpcClient = c
@@ -147,13 +179,14 @@ func (mon *Monitor) Shutdown() error {
 	defer mon.shutdownLock.Unlock()
 
 	if mon.shutdown {
-		logger.Warning("Monitor already shut down")
+		mon.logger.Warning("Monitor already shut down")
 		return nil
 	}
 
-	logger.Info("stopping Monitor")
+	mon.logger.Info("stopping Monitor")
 	close(mon.rpcReady)
 
+	mon.subscription.Cancel()
 	mon.cancel()
 
 	mon.wg.Wait()
@@ -164,14 +197,14 @@ func (mon *Monitor) Shutdown() error {
 // LogMetric stores a metric so it can later be retrieved.
 func (mon *Monitor) LogMetric(m api.Metric) error {
 	mon.metrics.Add(m)
-	logger.Debugf("pubsub mon logged '%s' metric from '%s'. Expires on %d", m.Name, m.Peer, m.Expire)
+	mon.logger.Debugf("pubsub mon logged '%s' metric from '%s'. Expires on %d", m.Name, m.Peer, m.Expire)
 	return nil
 }
 
 // PublishMetric broadcasts a metric to all current cluster peers.
 func (mon *Monitor) PublishMetric(m api.Metric) error {
 	if m.Discard() {
-		logger.Warningf("discarding invalid metric: %+v", m)
+		mon.logger.Warningf("discarding invalid metric: %+v", m)
 		return nil
 	}
 
@@ -180,11 +213,11 @@ func (mon *Monitor) PublishMetric(m api.Metric) error {
 	enc := msgpack.Multicodec(msgpackHandle).Encoder(&b)
 	err := enc.Encode(m)
 	if err != nil {
-		logger.Error(err)
+		mon.logger.Error(err)
 		return err
 	}
 
-	logger.Debugf(
+	mon.logger.Debugf(
 		"publishing metric %s to pubsub. Expires: %d",
 		m.Name,
 		m.Expire,
@@ -192,7 +225,7 @@ func (mon *Monitor) PublishMetric(m api.Metric) error {
 
 	err = mon.pubsub.Publish(PubsubTopic, b.Bytes())
 	if err != nil {
-		logger.Error(err)
+		mon.logger.Error(err)
 		return err
 	}
 
@@ -210,7 +243,7 @@ func (mon *Monitor) getPeers() ([]peer.ID, error) {
 		&peers,
 	)
 	if err != nil {
-		logger.Error(err)
+		mon.logger.Error(err)
 	}
 	return peers, err
 }
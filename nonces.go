@@ -0,0 +1,40 @@
+package ipfscluster
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// nonceCache remembers the nonces of the signed requests seen by this
+// peer until they expire, so that the requests cannot be replayed.
+type nonceCache struct {
+	mux  sync.Mutex
+	seen map[string]time.Time
+}
+
+func newNonceCache() *nonceCache {
+	return &nonceCache{
+		seen: make(map[string]time.Time),
+	}
+}
+
+// use records a nonce which is valid until the given time. It fails if
+// the nonce has been used before.
+func (nc *nonceCache) use(nonce string, expires time.Time) error {
+	nc.mux.Lock()
+	defer nc.mux.Unlock()
+
+	now := time.Now()
+	for n, exp := range nc.seen {
+		if !exp.After(now) {
+			delete(nc.seen, n)
+		}
+	}
+
+	if _, ok := nc.seen[nonce]; ok {
+		return fmt.Errorf("the signed request with nonce %s has already been used", nonce)
+	}
+	nc.seen[nonce] = expires
+	return nil
+}
@@ -0,0 +1,133 @@
+package ipfscluster
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	cid "github.com/ipfs/go-cid"
+	peer "github.com/libp2p/go-libp2p-peer"
+
+	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/rpcutil"
+)
+
+// OrphanPinsLocal compares the pins of this peer's IPFS daemon with the
+// shared state and returns those which are not part of it (orphans). The
+// given action is then applied to them: adopting them pins them in the
+// cluster (allocating them to this peer preferentially), while removing
+// them unpins them from the IPFS daemon. Pins which are being removed
+// from the cluster may be reported until the IPFS daemon unpins them.
+func (c *Cluster) OrphanPinsLocal(action api.OrphanAction) (api.OrphanPins, error) {
+	res := api.OrphanPins{
+		Peer:    c.id,
+		Orphans: []*cid.Cid{},
+		Action:  action,
+	}
+
+	switch action {
+	case api.OrphanActionNone, api.OrphanActionAdopt, api.OrphanActionRemove:
+	default:
+		return res, fmt.Errorf("unknown orphan action %q", action)
+	}
+	if action == api.OrphanActionAdopt && len(c.config.AuthorizedPublishers) > 0 {
+		return res, errors.New("orphan pins cannot be adopted: pins must be signed by an authorized publisher")
+	}
+
+	cState, err := c.consensus.State()
+	if err != nil {
+		return res, err
+	}
+
+	ipfsPins, err := c.ipfs.PinLs(c.ctx, "all")
+	if err != nil {
+		return res, err
+	}
+
+	recursive := make(map[string]bool)
+	for k, st := range ipfsPins {
+		if !st.IsPinned() {
+			continue
+		}
+		h, err := cid.Decode(k)
+		if err != nil {
+			c.logger.Warningf("IPFS daemon returned a bad cid %s: %s", k, err)
+			continue
+		}
+		if cState.Has(h) {
+			continue
+		}
+		res.Orphans = append(res.Orphans, h)
+		recursive[h.String()] = st == api.IPFSPinStatusRecursive
+	}
+	sort.Slice(res.Orphans, func(i, j int) bool {
+		return res.Orphans[i].String() < res.Orphans[j].String()
+	})
+
+	var failed int
+	for _, h := range res.Orphans {
+		switch action {
+		case api.OrphanActionAdopt:
+			pin := api.PinCid(h)
+			pin.Recursive = recursive[h.String()]
+			_, err = c.pin(pin, []peer.ID{}, []peer.ID{c.id})
+		case api.OrphanActionRemove:
+			err = c.ipfs.Unpin(c.ctx, h)
+		default:
+			continue
+		}
+		if err != nil {
+			c.logger.Errorf("error applying %q to orphan pin %s: %s", action, h, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		res.Error = fmt.Sprintf("%d orphan pins could not be processed", failed)
+	}
+	return res, nil
+}
+
+// OrphanPins runs OrphanPinsLocal() on every cluster peer and returns the
+// results sorted by peer ID. Peers which cannot be contacted have their
+// Error set.
+func (c *Cluster) OrphanPins(action api.OrphanAction) ([]api.OrphanPins, error) {
+	members, err := c.consensus.Peers()
+	if err != nil {
+		c.logger.Error(err)
+		return nil, err
+	}
+
+	replies := make([]api.OrphanPinsSerial, len(members), len(members))
+
+	ctxs, cancels := c.multiCallCtxs(c.ctx, len(members), 0)
+	defer rpcutil.MultiCancel(cancels)
+
+	errs := c.rpcClient.MultiCall(
+		ctxs,
+		members,
+		"Cluster",
+		"OrphanPinsLocal",
+		action,
+		rpcutil.CopyOrphanPinsSerialToIfaces(replies),
+	)
+
+	results := make([]api.OrphanPins, len(members), len(members))
+	for i, r := range replies {
+		if e := errs[i]; e != nil {
+			c.logger.Errorf("%s: error in broadcast response from %s: %s ", c.id, members[i], e)
+			results[i] = api.OrphanPins{
+				Peer:    members[i],
+				Orphans: []*cid.Cid{},
+				Action:  action,
+				Error:   e.Error(),
+			}
+			continue
+		}
+		results[i] = r.ToOrphanPins()
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Peer < results[j].Peer
+	})
+	return results, nil
+}
@@ -0,0 +1,210 @@
+package ipfscluster
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/ipfs/ipfs-cluster/fsutil"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+// peerBlacklist holds the peers which this peer refuses to add, to serve
+// RPC requests for and to accept in the consensus peerset. It is saved
+// to Config.PeerBlacklistFile on every change.
+type peerBlacklist struct {
+	path string
+
+	mux   sync.RWMutex
+	peers map[peer.ID]struct{}
+}
+
+func newPeerBlacklist(path string) *peerBlacklist {
+	return &peerBlacklist{
+		path:  path,
+		peers: make(map[peer.ID]struct{}),
+	}
+}
+
+func (bl *peerBlacklist) has(p peer.ID) bool {
+	bl.mux.RLock()
+	defer bl.mux.RUnlock()
+	_, ok := bl.peers[p]
+	return ok
+}
+
+// list returns the blacklisted peers sorted by their string form.
+func (bl *peerBlacklist) list() []peer.ID {
+	bl.mux.RLock()
+	defer bl.mux.RUnlock()
+	peers := make([]peer.ID, 0, len(bl.peers))
+	for p := range bl.peers {
+		peers = append(peers, p)
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].Pretty() < peers[j].Pretty()
+	})
+	return peers
+}
+
+// add blacklists a peer and saves the blacklist. It returns false when
+// the peer was already blacklisted.
+func (bl *peerBlacklist) add(p peer.ID) (bool, error) {
+	bl.mux.Lock()
+	defer bl.mux.Unlock()
+	if _, ok := bl.peers[p]; ok {
+		return false, nil
+	}
+	bl.peers[p] = struct{}{}
+	err := bl.save()
+	if err != nil {
+		delete(bl.peers, p)
+		return false, err
+	}
+	return true, nil
+}
+
+// remove takes a peer out of the blacklist and saves it. It returns
+// false when the peer was not blacklisted.
+func (bl *peerBlacklist) remove(p peer.ID) (bool, error) {
+	bl.mux.Lock()
+	defer bl.mux.Unlock()
+	if _, ok := bl.peers[p]; !ok {
+		return false, nil
+	}
+	delete(bl.peers, p)
+	err := bl.save()
+	if err != nil {
+		bl.peers[p] = struct{}{}
+		return false, err
+	}
+	return true, nil
+}
+
+// load reads the blacklist file, if any, replacing the current
+// blacklist.
+func (bl *peerBlacklist) load() error {
+	if bl.path == "" {
+		return nil
+	}
+
+	raw, err := ioutil.ReadFile(bl.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var strs []string
+	err = json.Unmarshal(raw, &strs)
+	if err != nil {
+		return fmt.Errorf("error parsing %s: %s", bl.path, err)
+	}
+
+	peers := make(map[peer.ID]struct{})
+	for _, s := range strs {
+		p, err := peer.IDB58Decode(s)
+		if err != nil {
+			return fmt.Errorf("error parsing %s: %s: %s", bl.path, s, err)
+		}
+		peers[p] = struct{}{}
+	}
+
+	bl.mux.Lock()
+	bl.peers = peers
+	bl.mux.Unlock()
+	return nil
+}
+
+// save writes the blacklist file. It must be called with the lock held.
+func (bl *peerBlacklist) save() error {
+	if bl.path == "" {
+		return nil
+	}
+
+	strs := make([]string, 0, len(bl.peers))
+	for p := range bl.peers {
+		strs = append(strs, peer.IDB58Encode(p))
+	}
+	sort.Strings(strs)
+	raw, err := json.MarshalIndent(strs, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return fsutil.WriteFileAtomic(bl.path, raw, 0600)
+}
+
+// errPeerBlacklisted returns the error given when an operation involves
+// a blacklisted peer.
+func errPeerBlacklisted(p peer.ID) error {
+	return fmt.Errorf("peer %s is blacklisted", p.Pretty())
+}
+
+// PeerBlacklist returns the peers blacklisted by this peer.
+func (c *Cluster) PeerBlacklist() []peer.ID {
+	return c.peerBlacklist.list()
+}
+
+// PeerBlacklistAdd blacklists a peer: this peer will refuse to add it to
+// the cluster, to answer its RPC requests and to include it in the
+// consensus peerset, so that it cannot rejoin through a stale bootstrap
+// entry. The peer is removed from the cluster when it is part of it.
+// The blacklist is saved to Config.PeerBlacklistFile and only affects
+// the peer on which it is set: with Raft, a blacklisted peer which rejoins
+// through another peer is only removed again while this peer is the
+// leader, and an error is logged meanwhile.
+func (c *Cluster) PeerBlacklistAdd(pid peer.ID) error {
+	if pid == c.id {
+		return errors.New("cannot blacklist this peer")
+	}
+
+	added, err := c.peerBlacklist.add(pid)
+	if err != nil {
+		c.logger.Errorf("saving the peer blacklist: %s", err)
+		return err
+	}
+	if !added {
+		return nil
+	}
+	c.logger.Warningf("peer %s blacklisted", pid.Pretty())
+
+	peers, err := c.consensus.Peers()
+	if err != nil {
+		c.logger.Error(err)
+		return err
+	}
+	if !containsPeer(peers, pid) {
+		return nil
+	}
+	c.logger.Infof("removing blacklisted peer %s from the cluster", pid.Pretty())
+	_, err = c.PeerRemoveForce(pid)
+	return err
+}
+
+// PeerBlacklistRemove takes a peer out of the blacklist. It can then be
+// added to the cluster again.
+func (c *Cluster) PeerBlacklistRemove(pid peer.ID) error {
+	removed, err := c.peerBlacklist.remove(pid)
+	if err != nil {
+		c.logger.Errorf("saving the peer blacklist: %s", err)
+		return err
+	}
+	if !removed {
+		return fmt.Errorf("peer %s is not blacklisted", pid.Pretty())
+	}
+	c.logger.Infof("peer %s removed from the blacklist", pid.Pretty())
+	return nil
+}
+
+// acceptPeer returns false for blacklisted peers. It is given to the
+// consensus components implementing PeerFilterer.
+func (c *Cluster) acceptPeer(p peer.ID) bool {
+	return !c.peerBlacklist.has(p)
+}
@@ -0,0 +1,66 @@
+package ipfscluster
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipfs/ipfs-cluster/test"
+)
+
+func TestPeerBlacklistPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peer-blacklist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, DefaultPeerBlacklistFile)
+
+	bl := newPeerBlacklist(path)
+	err = bl.load()
+	if err != nil {
+		t.Fatal("a missing blacklist file should not be an error:", err)
+	}
+
+	added, err := bl.add(test.TestPeerID2)
+	if err != nil || !added {
+		t.Fatal("expected the peer to be added:", err)
+	}
+	added, _ = bl.add(test.TestPeerID2)
+	if added {
+		t.Error("the peer was already blacklisted")
+	}
+	bl.add(test.TestPeerID3)
+
+	bl2 := newPeerBlacklist(path)
+	err = bl2.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bl2.has(test.TestPeerID2) || !bl2.has(test.TestPeerID3) || bl2.has(test.TestPeerID1) {
+		t.Error("the blacklist should have been saved")
+	}
+	if len(bl2.list()) != 2 {
+		t.Error("expected 2 blacklisted peers")
+	}
+
+	removed, err := bl2.remove(test.TestPeerID2)
+	if err != nil || !removed {
+		t.Fatal("expected the peer to be removed:", err)
+	}
+	bl3 := newPeerBlacklist(path)
+	bl3.load()
+	if bl3.has(test.TestPeerID2) {
+		t.Error("the removal should have been saved")
+	}
+
+	ioutil.WriteFile(path, []byte(`["abc"]`), 0600)
+	err = bl3.load()
+	if err == nil {
+		t.Error("expected an error with a bad peer ID")
+	}
+	if !bl3.has(test.TestPeerID3) {
+		t.Error("the blacklist should be kept when it cannot be loaded")
+	}
+}
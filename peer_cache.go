@@ -0,0 +1,120 @@
+package ipfscluster
+
+import (
+	"sync"
+	"time"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+
+	"github.com/ipfs/ipfs-cluster/api"
+)
+
+// peerIDCache keeps the last successful ID response of every peer, so
+// that listing the cluster peers does not need to wait for the ones
+// which are not responding.
+type peerIDCache struct {
+	mux        sync.Mutex
+	ids        map[peer.ID]api.ID
+	refreshing bool
+}
+
+func newPeerIDCache() *peerIDCache {
+	return &peerIDCache{
+		ids: make(map[peer.ID]api.ID),
+	}
+}
+
+func (pc *peerIDCache) get(p peer.ID) (api.ID, bool) {
+	pc.mux.Lock()
+	defer pc.mux.Unlock()
+	id, ok := pc.ids[p]
+	return id, ok
+}
+
+func (pc *peerIDCache) set(id api.ID) {
+	pc.mux.Lock()
+	defer pc.mux.Unlock()
+	pc.ids[id.ID] = id
+}
+
+// retain removes the entries of peers which are not in the given list.
+func (pc *peerIDCache) retain(members []peer.ID) {
+	keep := make(map[peer.ID]struct{}, len(members))
+	for _, p := range members {
+		keep[p] = struct{}{}
+	}
+
+	pc.mux.Lock()
+	defer pc.mux.Unlock()
+	for p := range pc.ids {
+		if _, ok := keep[p]; !ok {
+			delete(pc.ids, p)
+		}
+	}
+}
+
+// startRefresh returns false when a refresh is already in progress.
+// Otherwise, endRefresh must be called when the refresh finishes.
+func (pc *peerIDCache) startRefresh() bool {
+	pc.mux.Lock()
+	defer pc.mux.Unlock()
+	if pc.refreshing {
+		return false
+	}
+	pc.refreshing = true
+	return true
+}
+
+func (pc *peerIDCache) endRefresh() {
+	pc.mux.Lock()
+	defer pc.mux.Unlock()
+	pc.refreshing = false
+}
+
+// fetchPeersIDs asks the given peers for their ID and caches the
+// successful responses.
+func (c *Cluster) fetchPeersIDs(members []peer.ID) []api.ID {
+	peers := c.peersIDs(members)
+	now := time.Now()
+	for i := range peers {
+		if peers[i].Error != "" {
+			continue
+		}
+		peers[i].FetchedAt = now
+		c.peerIDCache.set(peers[i])
+	}
+	return peers
+}
+
+// cachedPeersIDs returns the cached IDs of the given peers. Only the
+// peers without a cached ID are contacted before returning. The cached
+// IDs are refreshed in the background.
+func (c *Cluster) cachedPeersIDs(members []peer.ID) []api.ID {
+	peers := make([]api.ID, len(members), len(members))
+	var cached, missing []peer.ID
+	var missingIdx []int
+	for i, p := range members {
+		id, ok := c.peerIDCache.get(p)
+		if ok {
+			peers[i] = id
+			cached = append(cached, p)
+			continue
+		}
+		missing = append(missing, p)
+		missingIdx = append(missingIdx, i)
+	}
+
+	if len(missing) > 0 {
+		for j, id := range c.fetchPeersIDs(missing) {
+			peers[missingIdx[j]] = id
+		}
+	}
+
+	if len(cached) > 0 && c.peerIDCache.startRefresh() {
+		go func() {
+			defer c.peerIDCache.endRefresh()
+			c.fetchPeersIDs(cached)
+		}()
+	}
+	return peers
+}
@@ -0,0 +1,33 @@
+package ipfscluster
+
+import (
+	"fmt"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+// peerGroup returns the members of the given peer group, as defined in the
+// configuration.
+func (c *Cluster) peerGroup(name string) ([]peer.ID, error) {
+	members, ok := c.config.PeerGroups[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown peer group %q", name)
+	}
+	return members, nil
+}
+
+// peersOutsideGroup returns the cluster peers which are not members of the
+// given group. They are blacklisted when allocating pins to the group.
+func (c *Cluster) peersOutsideGroup(members []peer.ID) ([]peer.ID, error) {
+	peers, err := c.consensus.Peers()
+	if err != nil {
+		return nil, err
+	}
+	outside := []peer.ID{}
+	for _, p := range peers {
+		if !containsPeer(members, p) {
+			outside = append(outside, p)
+		}
+	}
+	return outside, nil
+}
@@ -107,6 +107,132 @@ func TestClustersPeerAdd(t *testing.T) {
 	runF(t, clusters, f)
 }
 
+func TestClustersPeerAddWithProgress(t *testing.T) {
+	clusters, mocks := peerManagerClusters(t)
+	defer shutdownClusters(t, clusters, mocks)
+
+	if len(clusters) < 2 {
+		t.Skip("need at least 2 nodes for this test")
+	}
+
+	progress := make(chan api.PeerAddProgress)
+	done := make(chan []api.PeerAddStage)
+	go func() {
+		var stages []api.PeerAddStage
+		for p := range progress {
+			if p.Peer != clusters[1].ID().ID {
+				t.Error("progress reported for the wrong peer")
+			}
+			stages = append(stages, p.Stage)
+		}
+		done <- stages
+	}()
+
+	_, err := clusters[0].PeerAddWithProgress(clusterAddr(clusters[1]), progress)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stages := <-done
+	expected := []api.PeerAddStage{
+		api.PeerAddStageReachable,
+		api.PeerAddStagePeersetPushed,
+		api.PeerAddStageConsensus,
+		api.PeerAddStagePeersetSynced,
+	}
+	if len(stages) != len(expected) {
+		t.Fatalf("expected %d stages but got %v", len(expected), stages)
+	}
+	for i := range expected {
+		if stages[i] != expected[i] {
+			t.Errorf("stage %d: expected %s but got %s", i, expected[i], stages[i])
+		}
+	}
+}
+
+func TestClustersPeerJoinWithProgress(t *testing.T) {
+	clusters, mocks := peerManagerClusters(t)
+	defer shutdownClusters(t, clusters, mocks)
+
+	if len(clusters) < 2 {
+		t.Skip("need at least 2 nodes for this test")
+	}
+
+	progress := make(chan api.PeerAddProgress)
+	done := make(chan []api.PeerAddStage)
+	go func() {
+		var stages []api.PeerAddStage
+		for p := range progress {
+			stages = append(stages, p.Stage)
+		}
+		done <- stages
+	}()
+
+	err := clusters[1].JoinWithProgress(clusterAddr(clusters[0]), progress)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stages := <-done
+	expected := []api.PeerAddStage{
+		api.PeerAddStageReachable,
+		api.PeerAddStageConsensus,
+		api.PeerAddStageStateSynced,
+	}
+	if len(stages) != len(expected) {
+		t.Fatalf("expected %d stages but got %v", len(expected), stages)
+	}
+	for i := range expected {
+		if stages[i] != expected[i] {
+			t.Errorf("stage %d: expected %s but got %s", i, expected[i], stages[i])
+		}
+	}
+}
+
+func TestClustersPeerAddPushesState(t *testing.T) {
+	clusters, mocks := peerManagerClusters(t)
+	defer shutdownClusters(t, clusters, mocks)
+
+	if len(clusters) < 2 {
+		t.Skip("need at least 2 nodes for this test")
+	}
+
+	h, _ := cid.Decode(test.TestCid1)
+	err := clusters[0].Pin(api.PinCid(h))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinDelay()
+
+	// A peer with its own state ignores preloaded pins
+	h2, _ := cid.Decode(test.TestCid2)
+	err = clusters[0].PreloadState([]api.Pin{api.PinCid(h2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := clusters[0].tracker.Status(h2).Status; st != api.TrackerStatusUnpinned {
+		t.Errorf("%s should not be tracked and is %s", h2, st)
+	}
+
+	err = clusters[1].PreloadState(clusters[0].Pins())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := clusters[1].tracker.Status(h).Status; st == api.TrackerStatusUnpinned {
+		t.Errorf("%s should be tracked after preloading", h)
+	}
+
+	_, err = clusters[0].PeerAdd(clusterAddr(clusters[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinDelay()
+
+	if st := clusters[1].tracker.Status(h).Status; st != api.TrackerStatusPinned {
+		t.Errorf("%s should be pinned and is %s", h, st)
+	}
+}
+
 func TestClustersPeerAddBadPeer(t *testing.T) {
 	clusters, mocks := peerManagerClusters(t)
 	defer shutdownClusters(t, clusters, mocks)
@@ -135,6 +261,123 @@ func TestClustersPeerAddBadPeer(t *testing.T) {
 	}
 }
 
+func TestClustersPeerstore(t *testing.T) {
+	clusters, mocks := peerManagerClusters(t)
+	defer shutdownClusters(t, clusters, mocks)
+
+	if len(clusters) < 3 {
+		t.Skip("need at least 3 nodes for this test")
+	}
+
+	_, err := clusters[0].PeerAdd(clusterAddr(clusters[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addrs, err := clusters[0].PeerstoreAddresses()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) == 0 {
+		t.Fatal("expected the addresses of the added peer")
+	}
+
+	err = clusters[0].PeerstoreRemove(clusters[1].id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrs, _ = clusters[0].PeerstoreAddresses()
+	if len(addrs) != 0 {
+		t.Error("the addresses of the peer should have been removed")
+	}
+	if len(clusters[0].Peers()) != 2 {
+		t.Error("the peer should still be part of the cluster")
+	}
+
+	err = clusters[0].PeerstoreAdd(clusterAddr(clusters[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrs, _ = clusters[0].PeerstoreAddresses()
+	if len(addrs) != 1 {
+		t.Error("expected the address to be added back")
+	}
+	if len(clusters[0].peerManager.LoadPeerstore()) != 1 {
+		t.Error("the peerstore file should have been saved")
+	}
+
+	err = clusters[0].PeerstoreAdd(clusterAddr(clusters[2]))
+	if err == nil {
+		t.Error("expected an error with a peer outside the cluster")
+	}
+	err = clusters[0].PeerstoreRemove(clusters[0].id)
+	if err == nil {
+		t.Error("expected an error removing our own addresses")
+	}
+}
+
+func TestClustersPeerBlacklist(t *testing.T) {
+	clusters, mocks := peerManagerClusters(t)
+	defer shutdownClusters(t, clusters, mocks)
+
+	if len(clusters) < 3 {
+		t.Skip("need at least 3 nodes for this test")
+	}
+
+	err := clusters[0].PeerBlacklistAdd(clusters[0].id)
+	if err == nil {
+		t.Error("expected an error blacklisting ourselves")
+	}
+
+	err = clusters[0].PeerBlacklistAdd(clusters[2].id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bl := clusters[0].PeerBlacklist()
+	if len(bl) != 1 || bl[0] != clusters[2].id {
+		t.Fatal("expected the peer to be blacklisted")
+	}
+
+	_, err = clusters[0].PeerAdd(clusterAddr(clusters[2]))
+	if err == nil {
+		t.Error("expected an error adding a blacklisted peer")
+	}
+	err = clusters[2].Join(clusterAddr(clusters[0]))
+	if err == nil {
+		t.Error("expected an error joining through a peer which blacklists us")
+	}
+	if len(clusters[0].Peers()) != 1 {
+		t.Error("the blacklisted peer should not be part of the cluster")
+	}
+
+	// A blacklisted member is removed from the cluster.
+	_, err = clusters[0].PeerAdd(clusterAddr(clusters[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = clusters[0].PeerBlacklistAdd(clusters[1].id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delay()
+	if len(clusters[0].Peers()) != 1 {
+		t.Error("the blacklisted peer should have been removed")
+	}
+
+	err = clusters[0].PeerBlacklistRemove(clusters[2].id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = clusters[0].PeerBlacklistRemove(clusters[2].id)
+	if err == nil {
+		t.Error("expected an error removing a peer which is not blacklisted")
+	}
+	_, err = clusters[0].PeerAdd(clusterAddr(clusters[2]))
+	if err != nil {
+		t.Error(err)
+	}
+}
+
 func TestClustersPeerAddInUnhealthyCluster(t *testing.T) {
 	clusters, mocks := peerManagerClusters(t)
 	defer shutdownClusters(t, clusters, mocks)
@@ -175,8 +418,9 @@ func TestClustersPeerRemove(t *testing.T) {
 		t.Skip("test needs at least 2 clusters")
 	}
 
+	waitForLeaderAndMetrics(t, clusters)
 	p := clusters[1].ID().ID
-	err := clusters[0].PeerRemove(p)
+	_, err := clusters[0].PeerRemove(p)
 	if err != nil {
 		t.Error(err)
 	}
@@ -200,6 +444,41 @@ func TestClustersPeerRemove(t *testing.T) {
 	runF(t, clusters, f)
 }
 
+func TestClustersPeerRemoveQuorum(t *testing.T) {
+	clusters, mocks := createClusters(t)
+	defer shutdownClusters(t, clusters, mocks)
+
+	if len(clusters) < 3 {
+		t.Skip("test needs at least 3 clusters")
+	}
+
+	// Take down as many peers as possible while keeping quorum
+	down := (nClusters - 1) / 2
+	for i := nClusters - down; i < nClusters; i++ {
+		clusters[i].Shutdown()
+	}
+	waitForLeaderAndMetrics(t, clusters)
+
+	// Removing a live peer would leave too few live peers
+	_, err := clusters[0].PeerRemove(clusters[1].id)
+	if err == nil {
+		t.Fatal("expected an error removing a live peer")
+	}
+	if len(clusters[0].Peers()) != nClusters {
+		t.Error("the peer should not have been removed")
+	}
+
+	// Removing a peer which is down is fine
+	_, err = clusters[0].PeerRemove(clusters[nClusters-1].id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delay()
+	if len(clusters[0].Peers()) != nClusters-1 {
+		t.Error("the peer should have been removed")
+	}
+}
+
 func TestClustersPeerRemoveSelf(t *testing.T) {
 	// this test hangs sometimes if there are problems
 	clusters, mocks := createClusters(t)
@@ -212,7 +491,7 @@ func TestClustersPeerRemoveSelf(t *testing.T) {
 		if len(peers) != (len(clusters) - i) {
 			t.Fatal("Previous peers not removed correctly")
 		}
-		err := clusters[i].PeerRemove(clusters[i].ID().ID)
+		_, err := clusters[i].PeerRemove(clusters[i].ID().ID)
 		// Last peer member won't be able to remove itself
 		// In this case, we shut it down.
 		if err != nil {
@@ -262,7 +541,7 @@ func TestClustersPeerRemoveLeader(t *testing.T) {
 		if len(peers) != (len(clusters) - i) {
 			t.Fatal("Previous peers not removed correctly")
 		}
-		err := leader.PeerRemove(leader.id)
+		_, err := leader.PeerRemove(leader.id)
 		// Last peer member won't be able to remove itself
 		// In this case, we shut it down.
 		if err != nil {
@@ -283,6 +562,122 @@ func TestClustersPeerRemoveLeader(t *testing.T) {
 	}
 }
 
+func TestClustersTransferLeadership(t *testing.T) {
+	clusters, mocks := createClusters(t)
+	defer shutdownClusters(t, clusters, mocks)
+
+	if len(clusters) < 2 {
+		t.Skip("need at least 2 nodes for this test")
+	}
+
+	waitForLeaderAndMetrics(t, clusters)
+	leaderID, err := clusters[0].consensus.Leader()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var target *Cluster
+	for _, c := range clusters {
+		if c.id != leaderID {
+			target = c
+			break
+		}
+	}
+
+	// Ask a non-leader, so that the request is redirected
+	err = target.TransferLeadership(target.id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitForLeaderAndMetrics(t, clusters)
+	delay()
+
+	for _, c := range clusters {
+		l, err := c.consensus.Leader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if l != target.id {
+			t.Errorf("%s: expected %s as leader, got %s", c.id, target.id, l)
+		}
+
+		cs, err := c.consensus.Status()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(cs.Voters) != len(clusters) || len(cs.NonVoters) != 0 {
+			t.Errorf("%s: expected all peers to be voters again: %+v", c.id, cs)
+		}
+	}
+
+	err = target.TransferLeadership(target.id)
+	if err == nil {
+		t.Error("expected an error transferring leadership to the leader")
+	}
+
+	// Transfers to unreachable peers fail without demoting anyone
+	err = target.TransferLeadership(test.TestPeerID6)
+	if err == nil {
+		t.Error("expected an error transferring leadership to a disconnected peer")
+	}
+	cs, err := target.consensus.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cs.Voters) != len(clusters) || len(cs.NonVoters) != 0 {
+		t.Errorf("voters should be untouched after a failed transfer: %+v", cs)
+	}
+}
+
+func TestClustersLeaderHandOverOnShutdown(t *testing.T) {
+	clusters, mocks := createClusters(t)
+	defer shutdownClusters(t, clusters, mocks)
+
+	if len(clusters) < 3 {
+		t.Skip("need at least 3 nodes for this test")
+	}
+
+	waitForLeaderAndMetrics(t, clusters)
+	leaderID, err := clusters[0].consensus.Leader()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var leader *Cluster
+	var rest []*Cluster
+	for _, c := range clusters {
+		if c.id == leaderID {
+			leader = c
+		} else {
+			rest = append(rest, c)
+		}
+	}
+
+	err = leader.Shutdown()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The leadership was handed over before shutting down, so the
+	// remaining peers know the new leader straight away.
+	newLeader, err := rest[0].consensus.Leader()
+	if err != nil {
+		t.Fatal("expected a leader right after shutting down the old one:", err)
+	}
+	if newLeader == leaderID {
+		t.Error("the leadership should have been handed over")
+	}
+
+	cs, err := rest[0].consensus.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cs.Voters) != len(clusters) {
+		t.Error("every peer should be a voter again:", cs.Voters)
+	}
+}
+
 func TestClustersPeerRemoveReallocsPins(t *testing.T) {
 	clusters, mocks := createClusters(t)
 	defer shutdownClusters(t, clusters, mocks)
@@ -362,7 +757,7 @@ func TestClustersPeerRemoveReallocsPins(t *testing.T) {
 	}
 
 	// Now the leader removes itself
-	err = leader.PeerRemove(leaderID)
+	_, err = leader.PeerRemove(leaderID)
 	if err != nil {
 		t.Fatal("error removing peer:", err)
 	}
@@ -414,6 +809,162 @@ func TestClustersPeerJoin(t *testing.T) {
 	runF(t, clusters, f)
 }
 
+func TestClustersPeerJoinThroughPeerset(t *testing.T) {
+	clusters, mocks := peerManagerClusters(t)
+	defer shutdownClusters(t, clusters, mocks)
+
+	if len(clusters) < 5 {
+		t.Skip("test needs at least 5 clusters")
+	}
+
+	for i := 1; i < 4; i++ {
+		err := clusters[i].Join(clusterAddr(clusters[0]))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	addrs, err := clusters[1].Peerset()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) < 4 {
+		t.Fatalf("expected the addresses of 4 peers, got %d", len(addrs))
+	}
+
+	// The last peer only knows about clusters[1] apart from the
+	// address it is bootstrapping to, which is down.
+	clusters[4].peerManager.ImportPeer(clusterAddr(clusters[1]), false)
+	bootstrap := clusterAddr(clusters[0])
+	err = clusters[0].Shutdown()
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForLeader(t, clusters[1:4])
+
+	err = clusters[4].Join(bootstrap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clusters[4].Peers()) != 5 {
+		t.Error("the peer should have joined the cluster")
+	}
+}
+
+func TestClustersPeerJoinWithState(t *testing.T) {
+	clusters, mocks := peerManagerClusters(t)
+	defer shutdownClusters(t, clusters, mocks)
+
+	if len(clusters) < 3 {
+		t.Skip("test needs at least 3 clusters")
+	}
+
+	h1, _ := cid.Decode(test.TestCid1)
+	h2, _ := cid.Decode(test.TestCid2)
+	err := clusters[0].Pin(api.PinCid(h1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = clusters[1].Pin(api.PinCid(h2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinDelay()
+
+	err = clusters[1].Join(clusterAddr(clusters[0]))
+	if err == nil {
+		t.Fatal("expected an error joining with a non-empty state")
+	}
+
+	err = clusters[2].Join(clusterAddr(clusters[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Joining again is fine
+	err = clusters[2].Join(clusterAddr(clusters[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := clusters[2].MergePins(clusters[1].Pins())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("expected 1 merged pin, got %d", n)
+	}
+	n, err = clusters[2].MergePins(clusters[0].Pins())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("expected no merged pins, got %d", n)
+	}
+	pinDelay()
+
+	if len(clusters[0].Pins()) != 2 {
+		t.Error("the merged pin should be part of the shared state")
+	}
+}
+
+func TestClustersPinEverywhereOnJoin(t *testing.T) {
+	clusters, mocks := peerManagerClusters(t)
+	defer shutdownClusters(t, clusters, mocks)
+
+	if len(clusters) < 2 {
+		t.Skip("test needs at least 2 clusters")
+	}
+
+	// Pins are replicated once by default
+	clusters[0].config.ReplicationFactorMin = 1
+	clusters[0].config.ReplicationFactorMax = 1
+	delay() // let metrics be published
+
+	factored, _ := cid.Decode(test.TestCid1)
+	everywhere, _ := cid.Decode(test.TestCid2)
+	err := clusters[0].Pin(api.PinCid(factored))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pin := api.PinCid(everywhere)
+	pin.ReplicationFactorMin = api.ReplicationFactorEverywhere
+	err = clusters[0].Pin(pin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinDelay()
+
+	stored, err := clusters[0].PinGet(everywhere)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stored.IsPinEverywhere() || len(stored.Allocations) != 0 {
+		t.Error("the pin should be allocated everywhere")
+	}
+
+	for i := 1; i < len(clusters); i++ {
+		err := clusters[i].Join(clusterAddr(clusters[0]))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	pinDelay()
+
+	f := func(t *testing.T, c *Cluster) {
+		if st := c.tracker.Status(everywhere).Status; st != api.TrackerStatusPinned {
+			t.Errorf("%s: %s should be pinned and is %s", c.id, everywhere, st)
+		}
+		expected := api.TrackerStatusRemote
+		if c.id == clusters[0].id {
+			expected = api.TrackerStatusPinned
+		}
+		if st := c.tracker.Status(factored).Status; st != expected {
+			t.Errorf("%s: %s should be %s and is %s", c.id, factored, expected, st)
+		}
+	}
+	runF(t, clusters, f)
+}
+
 func TestClustersPeerJoinAllAtOnce(t *testing.T) {
 	clusters, mocks := peerManagerClusters(t)
 	defer shutdownClusters(t, clusters, mocks)
@@ -536,6 +1087,14 @@ func TestClustersPeerRejoin(t *testing.T) {
 
 	delay()
 
+	peers, err := clusters[1].consensus.Peers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if containsPeer(peers, clusters[0].id) {
+		t.Error("the peer should have left the cluster on shutdown")
+	}
+
 	// Forget peer so we can re-add one in same address/port
 	f := func(t *testing.T, c *Cluster) {
 		c.peerManager.RmPeer(clusters[0].id)
@@ -0,0 +1,89 @@
+package ipfscluster
+
+import (
+	"errors"
+	"fmt"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+var errRemoveLastPeer = errors.New("cannot remove the last peer of the cluster")
+
+// validatePeerRemove returns an error when removing the given peer would
+// leave the consensus peerset without a quorum of live peers: i.e.
+// removing a healthy peer from a 3-peer cluster where another peer is
+// down. Peers are considered alive while their ping metrics are valid.
+func (c *Cluster) validatePeerRemove(pid peer.ID) error {
+	peers, err := c.consensus.Peers()
+	if err != nil {
+		return err
+	}
+	if !containsPeer(peers, pid) {
+		return nil
+	}
+
+	remaining := make([]peer.ID, 0, len(peers))
+	for _, p := range peers {
+		if p != pid {
+			remaining = append(remaining, p)
+		}
+	}
+	if len(remaining) == 0 {
+		return errRemoveLastPeer
+	}
+
+	alive := []peer.ID{c.id}
+	for _, m := range c.Metrics("ping") {
+		if m.Peer != c.id {
+			alive = append(alive, m.Peer)
+		}
+	}
+
+	var live int
+	for _, p := range remaining {
+		if containsPeer(alive, p) {
+			live++
+		}
+	}
+	quorum := len(remaining)/2 + 1
+	if live < quorum {
+		return fmt.Errorf(
+			"removing %s would leave %d live peers out of %d, below the %d needed for quorum. Force the removal to proceed anyway",
+			pid.Pretty(), live, len(remaining), quorum)
+	}
+	return nil
+}
+
+// warnUnderReplicated returns, and logs, a warning when the pins
+// allocated to the given peer cannot be re-allocated to enough peers once
+// it is removed. It returns an empty string otherwise.
+func (c *Cluster) warnUnderReplicated(pid peer.ID) string {
+	cState, err := c.consensus.State()
+	if err != nil {
+		return ""
+	}
+	peers, err := c.consensus.Peers()
+	if err != nil {
+		return ""
+	}
+	remaining := len(peers)
+	if containsPeer(peers, pid) {
+		remaining--
+	}
+
+	var n int
+	for _, pin := range cState.List() {
+		if !containsPeer(pin.Allocations, pid) {
+			continue
+		}
+		if c.config.DisableRepinning || remaining < pin.ReplicationFactorMin {
+			n++
+		}
+	}
+	if n == 0 {
+		return ""
+	}
+	msg := fmt.Sprintf("removing %s will leave %d pins under-replicated", pid.Pretty(), n)
+	c.logger.Warning(msg)
+	return msg
+}
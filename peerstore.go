@@ -0,0 +1,77 @@
+package ipfscluster
+
+import (
+	"errors"
+	"fmt"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+	ma "github.com/multiformats/go-multiaddr"
+
+	"github.com/ipfs/ipfs-cluster/api"
+)
+
+// PeerstoreAddresses returns the addresses that this peer knows for the
+// rest of cluster peers, as kept in its libp2p peerstore.
+func (c *Cluster) PeerstoreAddresses() ([]ma.Multiaddr, error) {
+	peers, err := c.consensus.Peers()
+	if err != nil {
+		return nil, err
+	}
+	return c.peerManager.PeersAddresses(peers), nil
+}
+
+// PeerstoreAdd adds an address (including the /ipfs/<peerID> part) for a
+// cluster peer to the peerstore of this peer and saves the peerstore
+// file. It only affects how this peer reaches the other one: the peerset
+// is not modified.
+func (c *Cluster) PeerstoreAdd(addr ma.Multiaddr) error {
+	pid, _, err := api.Libp2pMultiaddrSplit(addr)
+	if err != nil {
+		return err
+	}
+	peers, err := c.peerstorePeers(pid)
+	if err != nil {
+		return err
+	}
+
+	err = c.peerManager.ImportPeer(addr, false)
+	if err != nil {
+		return err
+	}
+	c.peerManager.SavePeerstoreForPeers(peers)
+	return nil
+}
+
+// PeerstoreRemove forgets all the addresses of a cluster peer from the
+// peerstore of this peer and saves the peerstore file. It is used to get
+// rid of stale addresses, which can be replaced with PeerstoreAdd. The
+// peer is not removed from the cluster (see PeerRemove).
+func (c *Cluster) PeerstoreRemove(pid peer.ID) error {
+	if pid == c.id {
+		return errors.New("cannot remove our own addresses from the peerstore")
+	}
+	peers, err := c.peerstorePeers(pid)
+	if err != nil {
+		return err
+	}
+
+	err = c.peerManager.RmPeer(pid)
+	if err != nil {
+		return err
+	}
+	c.peerManager.SavePeerstoreForPeers(peers)
+	return nil
+}
+
+// peerstorePeers returns the current cluster peers, or an error if the
+// given peer is not among them.
+func (c *Cluster) peerstorePeers(pid peer.ID) ([]peer.ID, error) {
+	peers, err := c.consensus.Peers()
+	if err != nil {
+		return nil, err
+	}
+	if !containsPeer(peers, pid) {
+		return nil, fmt.Errorf("%s is not a cluster peer", pid.Pretty())
+	}
+	return peers, nil
+}
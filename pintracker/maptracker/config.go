@@ -3,6 +3,8 @@ package maptracker
 import (
 	"encoding/json"
 	"errors"
+	"path/filepath"
+	"time"
 
 	"github.com/ipfs/ipfs-cluster/config"
 )
@@ -11,8 +13,14 @@ const configKey = "maptracker"
 
 // Default values for this Config.
 const (
-	DefaultMaxPinQueueSize = 4096
-	DefaultConcurrentPins  = 10
+	DefaultMaxPinQueueSize  = 4096
+	DefaultConcurrentPins   = 10
+	DefaultMinFreeSpace     = 0
+	DefaultHookTimeout      = time.Minute
+	DefaultUnpinGracePeriod = 0
+	// DefaultUnpinQueueFile is the file, in the configuration folder,
+	// where the items waiting for the UnpinGracePeriod are saved.
+	DefaultUnpinQueueFile = "unpin_queue.json"
 )
 
 // Config allows to initialize a Monitor and customize some parameters.
@@ -25,11 +33,39 @@ type Config struct {
 	// daemon in parallel. If the pinning method is "refs", it might increase
 	// speed. Unpin requests are always processed one by one.
 	ConcurrentPins int
+	// MinFreeSpace is the amount of free space (in bytes) in the ipfs
+	// repository below which pin operations are paused until space
+	// is freed. Unpin operations are not affected. 0 disables the check.
+	MinFreeSpace uint64
+	// PinnedHook is a command run (with "sh -c") every time an item
+	// becomes pinned in this peer. ErrorHook is run when pinning or
+	// unpinning an item fails. The commands receive the CID, the
+	// tracker status, the peer ID, the pin name and the error in the
+	// IPFS_CLUSTER_CID, IPFS_CLUSTER_STATUS, IPFS_CLUSTER_PEER,
+	// IPFS_CLUSTER_NAME and IPFS_CLUSTER_ERROR environment variables.
+	// Empty commands are not run.
+	PinnedHook string
+	ErrorHook  string
+	// HookTimeout is the maximum time a hook command can run before
+	// being killed.
+	HookTimeout time.Duration
+	// UnpinGracePeriod delays unpinning items from the IPFS daemon after
+	// they are removed from the cluster. Meanwhile, their status is
+	// "unpin_pending" and pinning them again does not need to fetch
+	// them. Pending unpins are saved to DefaultUnpinQueueFile, in the
+	// configuration folder, so that they are resumed after a restart.
+	// 0 unpins right away.
+	UnpinGracePeriod time.Duration
 }
 
 type jsonConfig struct {
-	MaxPinQueueSize int `json:"max_pin_queue_size"`
-	ConcurrentPins  int `json:"concurrent_pins"`
+	MaxPinQueueSize  int    `json:"max_pin_queue_size"`
+	ConcurrentPins   int    `json:"concurrent_pins"`
+	MinFreeSpace     uint64 `json:"min_free_space"`
+	PinnedHook       string `json:"pinned_hook,omitempty"`
+	ErrorHook        string `json:"error_hook,omitempty"`
+	HookTimeout      string `json:"hook_timeout"`
+	UnpinGracePeriod string `json:"unpin_grace_period"`
 }
 
 // ConfigKey provides a human-friendly identifier for this type of Config.
@@ -41,6 +77,11 @@ func (cfg *Config) ConfigKey() string {
 func (cfg *Config) Default() error {
 	cfg.MaxPinQueueSize = DefaultMaxPinQueueSize
 	cfg.ConcurrentPins = DefaultConcurrentPins
+	cfg.MinFreeSpace = DefaultMinFreeSpace
+	cfg.PinnedHook = ""
+	cfg.ErrorHook = ""
+	cfg.HookTimeout = DefaultHookTimeout
+	cfg.UnpinGracePeriod = DefaultUnpinGracePeriod
 	return nil
 }
 
@@ -54,6 +95,14 @@ func (cfg *Config) Validate() error {
 	if cfg.ConcurrentPins <= 0 {
 		return errors.New("maptracker.concurrent_pins is too low")
 	}
+
+	if cfg.HookTimeout <= 0 {
+		return errors.New("maptracker.hook_timeout is invalid")
+	}
+
+	if cfg.UnpinGracePeriod < 0 {
+		return errors.New("maptracker.unpin_grace_period is invalid")
+	}
 	return nil
 }
 
@@ -71,6 +120,13 @@ func (cfg *Config) LoadJSON(raw []byte) error {
 
 	config.SetIfNotDefault(jcfg.MaxPinQueueSize, &cfg.MaxPinQueueSize)
 	config.SetIfNotDefault(jcfg.ConcurrentPins, &cfg.ConcurrentPins)
+	cfg.MinFreeSpace = jcfg.MinFreeSpace
+	cfg.PinnedHook = jcfg.PinnedHook
+	cfg.ErrorHook = jcfg.ErrorHook
+	hookTimeout, _ := time.ParseDuration(jcfg.HookTimeout)
+	config.SetIfNotDefault(hookTimeout, &cfg.HookTimeout)
+	unpinGracePeriod, _ := time.ParseDuration(jcfg.UnpinGracePeriod)
+	config.SetIfNotDefault(unpinGracePeriod, &cfg.UnpinGracePeriod)
 
 	return cfg.Validate()
 }
@@ -81,6 +137,21 @@ func (cfg *Config) ToJSON() ([]byte, error) {
 
 	jcfg.MaxPinQueueSize = cfg.MaxPinQueueSize
 	jcfg.ConcurrentPins = cfg.ConcurrentPins
+	jcfg.MinFreeSpace = cfg.MinFreeSpace
+	jcfg.PinnedHook = cfg.PinnedHook
+	jcfg.ErrorHook = cfg.ErrorHook
+	jcfg.HookTimeout = cfg.HookTimeout.String()
+	jcfg.UnpinGracePeriod = cfg.UnpinGracePeriod.String()
 
 	return config.DefaultJSONMarshal(jcfg)
 }
+
+// GetUnpinQueuePath returns the path of the file where the items waiting
+// for the UnpinGracePeriod are saved, or an empty string when the
+// configuration folder is not set.
+func (cfg *Config) GetUnpinQueuePath() string {
+	if cfg.BaseDir == "" {
+		return ""
+	}
+	return filepath.Join(cfg.BaseDir, DefaultUnpinQueueFile)
+}
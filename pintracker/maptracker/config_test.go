@@ -3,12 +3,17 @@ package maptracker
 import (
 	"encoding/json"
 	"testing"
+	"time"
 )
 
 var cfgJSON = []byte(`
 {
       "max_pin_queue_size": 4092,
-      "concurrent_pins": 2
+      "concurrent_pins": 2,
+      "min_free_space": 1000000,
+      "pinned_hook": "echo pinned",
+      "hook_timeout": "10s",
+      "unpin_grace_period": "1h"
 }
 `)
 
@@ -18,6 +23,18 @@ func TestLoadJSON(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	if cfg.MinFreeSpace != 1000000 {
+		t.Error("expected min_free_space to be loaded")
+	}
+	if cfg.PinnedHook != "echo pinned" || cfg.ErrorHook != "" {
+		t.Error("expected hooks to be loaded")
+	}
+	if cfg.HookTimeout != 10*time.Second {
+		t.Error("expected hook_timeout to be loaded")
+	}
+	if cfg.UnpinGracePeriod != time.Hour {
+		t.Error("expected unpin_grace_period to be loaded")
+	}
 
 	j := &jsonConfig{}
 
@@ -58,4 +75,10 @@ func TestDefault(t *testing.T) {
 	if cfg.Validate() == nil {
 		t.Fatal("expected error validating")
 	}
+
+	cfg.Default()
+	cfg.HookTimeout = 0
+	if cfg.Validate() == nil {
+		t.Fatal("expected error validating")
+	}
 }
@@ -0,0 +1,40 @@
+package maptracker
+
+import (
+	"context"
+	"os"
+	"os/exec"
+
+	"github.com/ipfs/ipfs-cluster/pintracker/optracker"
+)
+
+// runHook runs the given hook command in the background, passing the
+// details of the operation in the environment. Errors are only logged.
+func (mpt *MapPinTracker) runHook(command string, op *optracker.Operation) {
+	if command == "" {
+		return
+	}
+
+	env := append(
+		os.Environ(),
+		"IPFS_CLUSTER_CID="+op.Cid().String(),
+		"IPFS_CLUSTER_STATUS="+op.ToTrackerStatus().String(),
+		"IPFS_CLUSTER_PEER="+mpt.peerID.Pretty(),
+		"IPFS_CLUSTER_NAME="+op.Pin().Name,
+		"IPFS_CLUSTER_ERROR="+op.Error(),
+	)
+
+	go func() {
+		ctx, cancel := context.WithTimeout(mpt.ctx, mpt.config.HookTimeout)
+		defer cancel()
+
+		cmd := exec.CommandContext(ctx, "sh", "-c", command)
+		cmd.Env = env
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			mpt.logger.Errorf("error running hook for %s: %s: %s", op.Cid(), err, out)
+			return
+		}
+		mpt.logger.Debugf("hook for %s finished: %s", op.Cid(), out)
+	}()
+}
@@ -5,9 +5,13 @@ package maptracker
 import (
 	"context"
 	"errors"
+	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/logutil"
 	"github.com/ipfs/ipfs-cluster/pintracker/optracker"
 	"github.com/ipfs/ipfs-cluster/pintracker/util"
 
@@ -21,12 +25,23 @@ var logger = logging.Logger("pintracker")
 
 var (
 	errUnpinned = errors.New("the item is unexpectedly not pinned on IPFS")
+	// errPinTypeMismatch is set when the item is pinned in IPFS with a
+	// different type (direct/recursive) than requested.
+	errPinTypeMismatch = errors.New("the item is pinned on IPFS with the wrong pin type")
 )
 
+// how often Drain() checks for in-flight operations
+var drainCheckInterval = 100 * time.Millisecond
+
+// how long the result of checking the ipfs repository
+// free space is re-used
+var freeSpaceCheckInterval = 10 * time.Second
+
 // MapPinTracker is a PinTracker implementation which uses a Go map
 // to store the status of the tracked Cids. This component is thread-safe.
 type MapPinTracker struct {
 	config *Config
+	logger *logutil.Logger
 
 	optracker *optracker.OperationTracker
 
@@ -40,6 +55,22 @@ type MapPinTracker struct {
 	pinCh   chan *optracker.Operation
 	unpinCh chan *optracker.Operation
 
+	// items waiting for the UnpinGracePeriod to expire
+	unpins *unpinQueue
+
+	// number of workers performing an operation
+	active int32
+
+	freeSpaceMux       sync.Mutex
+	lastFreeSpaceCheck time.Time
+	lowFreeSpace       bool
+
+	// operations dequeued or queued while paused are held
+	// until the tracker is resumed.
+	pauseMux sync.Mutex
+	paused   bool
+	held     []heldOperation
+
 	shutdownLock sync.Mutex
 	shutdown     bool
 	wg           sync.WaitGroup
@@ -50,21 +81,39 @@ type MapPinTracker struct {
 func NewMapPinTracker(cfg *Config, pid peer.ID) *MapPinTracker {
 	ctx, cancel := context.WithCancel(context.Background())
 
+	log := logutil.New(logger)
+	log.SetPrefix(logutil.PeerPrefix(pid))
+
 	mpt := &MapPinTracker{
 		ctx:       ctx,
 		cancel:    cancel,
 		config:    cfg,
+		logger:    log,
 		optracker: optracker.NewOperationTracker(ctx, pid),
 		rpcReady:  make(chan struct{}, 1),
 		peerID:    pid,
 		pinCh:     make(chan *optracker.Operation, cfg.MaxPinQueueSize),
 		unpinCh:   make(chan *optracker.Operation, cfg.MaxPinQueueSize),
+		unpins:    newUnpinQueue(cfg.GetUnpinQueuePath()),
+	}
+
+	// Resume the unpins which were waiting before a restart.
+	err := mpt.unpins.load()
+	if err != nil {
+		mpt.logger.Errorf("error loading the unpin queue: %s", err)
+	}
+	for _, item := range mpt.unpins.list() {
+		c, _ := cid.Decode(item.Cid)
+		op := mpt.optracker.TrackNewOperation(api.PinCid(c), optracker.OperationUnpin, optracker.PhaseWaiting)
+		mpt.unpins.add(op, item.Deadline)
 	}
 
 	for i := 0; i < mpt.config.ConcurrentPins; i++ {
 		go mpt.opWorker(mpt.pin, mpt.pinCh)
 	}
 	go mpt.opWorker(mpt.unpin, mpt.unpinCh)
+	mpt.wg.Add(1)
+	go mpt.unpinScheduler()
 	return mpt
 }
 
@@ -79,8 +128,14 @@ func (mpt *MapPinTracker) opWorker(pinF func(*optracker.Operation) error, opChan
 				// This saves some time, but not 100% needed.
 				continue
 			}
+			if mpt.hold(op, opChan) {
+				// paused. Performed on Resume().
+				continue
+			}
 			op.SetPhase(optracker.PhaseInProgress)
+			atomic.AddInt32(&mpt.active, 1)
 			err := pinF(op) // call pin/unpin
+			atomic.AddInt32(&mpt.active, -1)
 			if err != nil {
 				if op.Cancelled() {
 					// there was an error because
@@ -89,14 +144,21 @@ func (mpt *MapPinTracker) opWorker(pinF func(*optracker.Operation) error, opChan
 				}
 				op.SetError(err)
 				op.Cancel()
+				mpt.runHook(mpt.config.ErrorHook, op)
 				continue
 			}
 			op.SetPhase(optracker.PhaseDone)
 			op.Cancel()
+			if op.Type() == optracker.OperationPin {
+				mpt.runHook(mpt.config.PinnedHook, op)
+			}
 
 			// We keep all pinned things in the tracker,
-			// only clean unpinned things.
+			// only clean unpinned things. Delayed unpins
+			// leave the unpin queue only now that they are
+			// done.
 			if op.Type() == optracker.OperationUnpin {
+				mpt.dequeueUnpin(op.Cid())
 				mpt.optracker.Clean(op)
 			}
 		case <-mpt.ctx.Done():
@@ -112,11 +174,11 @@ func (mpt *MapPinTracker) Shutdown() error {
 	defer mpt.shutdownLock.Unlock()
 
 	if mpt.shutdown {
-		logger.Debug("already shutdown")
+		mpt.logger.Debug("already shutdown")
 		return nil
 	}
 
-	logger.Info("stopping MapPinTracker")
+	mpt.logger.Info("stopping MapPinTracker")
 	mpt.cancel()
 	close(mpt.rpcReady)
 	mpt.wg.Wait()
@@ -124,9 +186,182 @@ func (mpt *MapPinTracker) Shutdown() error {
 	return nil
 }
 
-func (mpt *MapPinTracker) pin(op *optracker.Operation) error {
-	logger.Debugf("issuing pin call for %s", op.Cid())
+// Drain waits until there are no queued or ongoing pin and unpin
+// operations, or until the context is cancelled. Operations which did not
+// finish are logged: pins will be re-tracked from the shared state when
+// the peer starts again.
+func (mpt *MapPinTracker) Drain(ctx context.Context) error {
+	ticker := time.NewTicker(drainCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		inFlight := mpt.optracker.InFlight()
+		if len(inFlight) == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			for _, c := range inFlight {
+				mpt.logger.Warningf("operation on %s did not finish before shutdown", c)
+			}
+			return fmt.Errorf("%d operations did not finish before shutdown", len(inFlight))
+		case <-ticker.C:
+		}
+	}
+}
+
+// Usage reports the pin and unpin operations being performed, those
+// queued and the number of workers performing them (ConcurrentPins plus
+// one for unpins).
+func (mpt *MapPinTracker) Usage() api.ComponentUsage {
+	return api.ComponentUsage{
+		Component: "pintracker",
+		Active:    int(atomic.LoadInt32(&mpt.active)),
+		Queued:    len(mpt.pinCh) + len(mpt.unpinCh),
+		Limit:     mpt.config.ConcurrentPins + 1,
+	}
+}
+
+// heldOperation is an operation put aside while the tracker is paused,
+// along with the queue it belongs to.
+type heldOperation struct {
+	op *optracker.Operation
+	ch chan *optracker.Operation
+}
+
+// Pause stops the MapPinTracker from performing new pin and unpin
+// operations on the IPFS daemon. New and queued operations are held
+// aside (without limit and without occupying the workers) and queued
+// again once the tracker is resumed. Ongoing operations are not affected.
+func (mpt *MapPinTracker) Pause() {
+	mpt.pauseMux.Lock()
+	defer mpt.pauseMux.Unlock()
+	if mpt.paused {
+		return
+	}
+	mpt.logger.Info("pausing pin and unpin operations")
+	mpt.paused = true
+}
+
+// Resume lets the MapPinTracker perform pin and
+// unpin operations again after a Pause().
+func (mpt *MapPinTracker) Resume() {
+	mpt.pauseMux.Lock()
+	defer mpt.pauseMux.Unlock()
+	if !mpt.paused {
+		return
+	}
+	mpt.logger.Infof("resuming pin and unpin operations (%d held)", len(mpt.held))
+	mpt.paused = false
+	held := mpt.held
+	mpt.held = nil
+
+	mpt.wg.Add(1)
+	go mpt.release(held)
+}
+
+// Paused returns true when the tracker has been paused.
+func (mpt *MapPinTracker) Paused() bool {
+	mpt.pauseMux.Lock()
+	defer mpt.pauseMux.Unlock()
+	return mpt.paused
+}
+
+// hold puts the operation aside when the tracker is paused,
+// returning true. Otherwise it returns false.
+func (mpt *MapPinTracker) hold(op *optracker.Operation, ch chan *optracker.Operation) bool {
+	mpt.pauseMux.Lock()
+	defer mpt.pauseMux.Unlock()
+	if !mpt.paused {
+		return false
+	}
+	mpt.held = append(mpt.held, heldOperation{op: op, ch: ch})
+	return true
+}
+
+// release queues again the operations held while the tracker was
+// paused, waiting for room in the queues when they are full.
+func (mpt *MapPinTracker) release(held []heldOperation) {
+	defer mpt.wg.Done()
+	for _, h := range held {
+		if h.op.Cancelled() {
+			continue
+		}
+		select {
+		case h.ch <- h.op:
+		case <-mpt.ctx.Done():
+			return
+		}
+	}
+}
+
+// hasLowFreeSpace returns true when the ipfs repository free space is
+// below MinFreeSpace. The result is cached for freeSpaceCheckInterval.
+func (mpt *MapPinTracker) hasLowFreeSpace(ctx context.Context) bool {
+	mpt.freeSpaceMux.Lock()
+	defer mpt.freeSpaceMux.Unlock()
+
+	if time.Since(mpt.lastFreeSpaceCheck) < freeSpaceCheckInterval {
+		return mpt.lowFreeSpace
+	}
+
+	var free uint64
 	err := mpt.rpcClient.CallContext(
+		ctx,
+		"",
+		"Cluster",
+		"IPFSFreeSpace",
+		struct{}{},
+		&free,
+	)
+	if err != nil {
+		// Do not block pins when we cannot tell.
+		mpt.logger.Error(err)
+		return false
+	}
+
+	low := free < mpt.config.MinFreeSpace
+	if low && !mpt.lowFreeSpace {
+		mpt.logger.Errorf(
+			"ipfs repository free space (%d bytes) is below min_free_space (%d bytes). Pausing pin operations",
+			free,
+			mpt.config.MinFreeSpace,
+		)
+	}
+	if !low && mpt.lowFreeSpace {
+		mpt.logger.Info("ipfs repository free space recovered. Resuming pin operations")
+	}
+	mpt.lowFreeSpace = low
+	mpt.lastFreeSpaceCheck = time.Now()
+	return low
+}
+
+// waitForFreeSpace blocks while the ipfs repository free space is below
+// MinFreeSpace, or until the context is cancelled.
+func (mpt *MapPinTracker) waitForFreeSpace(ctx context.Context) error {
+	if mpt.config.MinFreeSpace == 0 {
+		return nil
+	}
+
+	for mpt.hasLowFreeSpace(ctx) {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(freeSpaceCheckInterval):
+		}
+	}
+	return nil
+}
+
+func (mpt *MapPinTracker) pin(op *optracker.Operation) error {
+	err := mpt.waitForFreeSpace(op.Context())
+	if err != nil {
+		return err
+	}
+
+	mpt.logger.Debugf("issuing pin call for %s (request %s)", op.Cid(), op.Pin().RequestID)
+	err = mpt.rpcClient.CallContext(
 		op.Context(),
 		"",
 		"Cluster",
@@ -137,11 +372,33 @@ func (mpt *MapPinTracker) pin(op *optracker.Operation) error {
 	if err != nil {
 		return err
 	}
+
+	// Record the pinned size. Not knowing it is not an error.
+	// The cumulative size is only known for UnixFS DAGs: raw
+	// pins record the size of their root block.
+	sizeMethod := "IPFSCumulativeSize"
+	if op.Pin().Raw {
+		sizeMethod = "IPFSBlockSize"
+	}
+	var size uint64
+	err = mpt.rpcClient.CallContext(
+		op.Context(),
+		"",
+		"Cluster",
+		sizeMethod,
+		op.Pin().ToSerial(),
+		&size,
+	)
+	if err != nil {
+		mpt.logger.Debugf("error obtaining the size of %s: %s", op.Cid(), err)
+		return nil
+	}
+	op.SetSize(size)
 	return nil
 }
 
 func (mpt *MapPinTracker) unpin(op *optracker.Operation) error {
-	logger.Debugf("issuing unpin call for %s", op.Cid())
+	mpt.logger.Debugf("issuing unpin call for %s", op.Cid())
 	err := mpt.rpcClient.CallContext(
 		op.Context(),
 		"",
@@ -163,14 +420,66 @@ func (mpt *MapPinTracker) enqueue(c api.Pin, typ optracker.OperationType, ch cha
 	if op == nil {
 		return nil // ongoing pin operation.
 	}
+	return mpt.queue(op, ch)
+}
 
+// delayUnpin tracks an unpin operation which waits in the unpin queue
+// until UnpinGracePeriod expires. Tracking the item again in the
+// meantime cancels it.
+func (mpt *MapPinTracker) delayUnpin(c api.Pin) error {
+	op := mpt.optracker.TrackNewOperation(c, optracker.OperationUnpin, optracker.PhaseWaiting)
+	if op == nil {
+		return nil // ongoing unpin operation.
+	}
+	err := mpt.unpins.add(op, time.Now().Add(mpt.config.UnpinGracePeriod))
+	if err != nil {
+		// still unpinned after the grace period, unless we restart
+		mpt.logger.Errorf("error saving the unpin queue: %s", err)
+	}
+	return nil
+}
+
+// dequeueUnpin removes an item from the unpin queue, if it is there.
+func (mpt *MapPinTracker) dequeueUnpin(c *cid.Cid) {
+	err := mpt.unpins.remove(c)
+	if err != nil {
+		mpt.logger.Errorf("error saving the unpin queue: %s", err)
+	}
+}
+
+// unpinScheduler queues the unpin operations of the items in the unpin
+// queue whose grace period has expired.
+func (mpt *MapPinTracker) unpinScheduler() {
+	defer mpt.wg.Done()
+	ticker := time.NewTicker(unpinQueueCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-mpt.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, op := range mpt.unpins.due(time.Now()) {
+			op.SetPhase(optracker.PhaseQueued)
+			mpt.queue(op, mpt.unpinCh)
+		}
+	}
+}
+
+// puts an operation on the given queue. It fails if the queue is full.
+// Operations are held aside while the tracker is paused.
+func (mpt *MapPinTracker) queue(op *optracker.Operation, ch chan *optracker.Operation) error {
+	if mpt.hold(op, ch) {
+		return nil
+	}
 	select {
 	case ch <- op:
 	default:
 		err := errors.New("queue is full")
 		op.SetError(err)
 		op.Cancel()
-		logger.Error(err.Error())
+		mpt.logger.Error(err.Error())
 		return err
 	}
 	return nil
@@ -179,12 +488,22 @@ func (mpt *MapPinTracker) enqueue(c api.Pin, typ optracker.OperationType, ch cha
 // Track tells the MapPinTracker to start managing a Cid,
 // possibly triggering Pin operations on the IPFS daemon.
 func (mpt *MapPinTracker) Track(c api.Pin) error {
-	logger.Debugf("tracking %s", c.Cid)
+	if c.RequestID != "" {
+		mpt.logger.Infof("tracking %s (request %s)", c.Cid, c.RequestID)
+	} else {
+		mpt.logger.Debugf("tracking %s", c.Cid)
+	}
+	// No longer waiting to be unpinned
+	mpt.dequeueUnpin(c.Cid)
 
 	// Trigger unpin whenever something remote is tracked
 	// Note, IPFSConn checks with pin/ls before triggering
 	// pin/rm.
 	if util.IsRemotePin(c, mpt.peerID) {
+		if mpt.Paused() {
+			// do not block: the unpin happens on Resume().
+			return mpt.enqueue(c, optracker.OperationRemote, mpt.unpinCh)
+		}
 		op := mpt.optracker.TrackNewOperation(c, optracker.OperationRemote, optracker.PhaseInProgress)
 		if op == nil {
 			return nil // ongoing unpin
@@ -202,10 +521,37 @@ func (mpt *MapPinTracker) Track(c api.Pin) error {
 	return mpt.enqueue(c, optracker.OperationPin, mpt.pinCh)
 }
 
+// Restore tells the MapPinTracker to manage a Cid which is known to be
+// pinned or tracked as remote already, without triggering any operation
+// on the IPFS daemon. Mismatches with the actual IPFS status are
+// detected by Sync() and SyncAll().
+func (mpt *MapPinTracker) Restore(c api.Pin, st api.TrackerStatus) error {
+	var typ optracker.OperationType
+	switch st {
+	case api.TrackerStatusPinned:
+		typ = optracker.OperationPin
+	case api.TrackerStatusRemote:
+		typ = optracker.OperationRemote
+	default:
+		return fmt.Errorf("cannot restore %s with status %s", c.Cid, st)
+	}
+
+	mpt.logger.Debugf("restoring %s as %s", c.Cid, st)
+	mpt.dequeueUnpin(c.Cid)
+	op := mpt.optracker.TrackNewOperation(c, typ, optracker.PhaseDone)
+	if op != nil {
+		op.Cancel()
+	}
+	return nil
+}
+
 // Untrack tells the MapPinTracker to stop managing a Cid.
 // If the Cid is pinned locally, it will be unpinned.
 func (mpt *MapPinTracker) Untrack(c *cid.Cid) error {
-	logger.Debugf("untracking %s", c)
+	mpt.logger.Debugf("untracking %s", c)
+	if mpt.config.UnpinGracePeriod > 0 {
+		return mpt.delayUnpin(api.PinCid(c))
+	}
 	return mpt.enqueue(api.PinCid(c), optracker.OperationUnpin, mpt.unpinCh)
 }
 
@@ -221,6 +567,11 @@ func (mpt *MapPinTracker) StatusAll() []api.PinInfo {
 	return mpt.optracker.GetAll()
 }
 
+// StatusCount returns the number of Cids tracked with the given status.
+func (mpt *MapPinTracker) StatusCount(st api.TrackerStatus) int {
+	return mpt.optracker.Count(st)
+}
+
 // Sync verifies that the status of a Cid matches that of
 // the IPFS daemon. If not, it will be transitioned
 // to PinError or UnpinError.
@@ -256,15 +607,8 @@ func (mpt *MapPinTracker) Sync(c *cid.Cid) (api.PinInfo, error) {
 // with Recover().
 // An error is returned if we are unable to contact the IPFS daemon.
 func (mpt *MapPinTracker) SyncAll() ([]api.PinInfo, error) {
-	var ipsMap map[string]api.IPFSPinStatus
 	var results []api.PinInfo
-	err := mpt.rpcClient.Call(
-		"",
-		"Cluster",
-		"IPFSPinLs",
-		"recursive",
-		&ipsMap,
-	)
+	ipsMap, err := mpt.ipfsPinLs()
 	if err != nil {
 		// set everything as error
 		pInfos := mpt.optracker.GetAll()
@@ -295,6 +639,46 @@ func (mpt *MapPinTracker) SyncAll() ([]api.PinInfo, error) {
 	return results, nil
 }
 
+// ipfsPinLs returns the recursive and direct pins in IPFS. Indirect
+// pins are not requested as they are not relevant for syncing.
+func (mpt *MapPinTracker) ipfsPinLs() (map[string]api.IPFSPinStatus, error) {
+	ipsMap := make(map[string]api.IPFSPinStatus)
+	for _, typeFilter := range []string{"recursive", "direct"} {
+		var m map[string]api.IPFSPinStatus
+		err := mpt.rpcClient.Call(
+			"",
+			"Cluster",
+			"IPFSPinLs",
+			typeFilter,
+			&m,
+		)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range m {
+			ipsMap[k] = v
+		}
+	}
+	return ipsMap, nil
+}
+
+// pinTypeMismatch returns true when the given pin is pinned in IPFS
+// but not with the requested type.
+func pinTypeMismatch(pin api.Pin, ips api.IPFSPinStatus) bool {
+	return (pin.Recursive && ips == api.IPFSPinStatusDirect) ||
+		(!pin.Recursive && ips == api.IPFSPinStatusRecursive)
+}
+
+// trackedPin returns the Pin for the operation tracked for the given
+// Cid, or a default one if there is none.
+func (mpt *MapPinTracker) trackedPin(c *cid.Cid) api.Pin {
+	pin, ok := mpt.optracker.GetPin(c)
+	if !ok {
+		return api.PinCid(c)
+	}
+	return pin
+}
+
 func (mpt *MapPinTracker) syncStatus(c *cid.Cid, ips api.IPFSPinStatus) api.PinInfo {
 	status, ok := mpt.optracker.Status(c)
 	if !ok {
@@ -306,10 +690,23 @@ func (mpt *MapPinTracker) syncStatus(c *cid.Cid, ips api.IPFSPinStatus) api.PinI
 		case api.TrackerStatusPinError:
 			// If an item that we wanted to pin is pinned, we mark it so
 			mpt.optracker.TrackNewOperation(
-				api.PinCid(c),
+				mpt.trackedPin(c),
 				optracker.OperationPin,
 				optracker.PhaseDone,
 			)
+		case api.TrackerStatusPinned:
+			// pinned, but maybe direct when we wanted recursive or
+			// viceversa: mark as error and pin again with the
+			// right type.
+			pin := mpt.trackedPin(c)
+			if pinTypeMismatch(pin, ips) {
+				mpt.logger.Warningf("%s is pinned as %s in IPFS. Repinning", c, ips)
+				mpt.optracker.SetError(c, errPinTypeMismatch)
+				mpt.optracker.SetIPFSStatus(c, ips)
+				pInfo := mpt.optracker.Get(c)
+				mpt.enqueue(pin, optracker.OperationPin, mpt.pinCh)
+				return pInfo
+			}
 		default:
 			// 1. Unpinning phases
 			// 2. Pinned in ipfs but we are not tracking
@@ -336,19 +733,20 @@ func (mpt *MapPinTracker) syncStatus(c *cid.Cid, ips api.IPFSPinStatus) api.PinI
 			// -> do nothing
 		}
 	}
+	mpt.optracker.SetIPFSStatus(c, ips)
 	return mpt.optracker.Get(c)
 }
 
 // Recover will re-queue a Cid in error state for the failed operation,
 // possibly retriggering an IPFS pinning operation.
 func (mpt *MapPinTracker) Recover(c *cid.Cid) (api.PinInfo, error) {
-	logger.Infof("Attempting to recover %s", c)
+	mpt.logger.Infof("Attempting to recover %s", c)
 	pInfo := mpt.optracker.Get(c)
 	var err error
 
 	switch pInfo.Status {
 	case api.TrackerStatusPinError:
-		err = mpt.enqueue(api.PinCid(c), optracker.OperationPin, mpt.pinCh)
+		err = mpt.enqueue(mpt.trackedPin(c), optracker.OperationPin, mpt.pinCh)
 	case api.TrackerStatusUnpinError:
 		err = mpt.enqueue(api.PinCid(c), optracker.OperationUnpin, mpt.unpinCh)
 	}
@@ -369,6 +767,13 @@ func (mpt *MapPinTracker) RecoverAll() ([]api.PinInfo, error) {
 	return results, nil
 }
 
+// SetLogPrefix sets the prefix of the messages logged by this component
+// and by its operation tracker.
+func (mpt *MapPinTracker) SetLogPrefix(prefix string) {
+	mpt.logger.SetPrefix(prefix)
+	mpt.optracker.SetLogPrefix(prefix)
+}
+
 // SetClient makes the MapPinTracker ready to perform RPC requests to
 // other components.
 func (mpt *MapPinTracker) SetClient(c *rpc.Client) {
@@ -3,7 +3,11 @@ package maptracker
 import (
 	"context"
 	"errors"
+	"io/ioutil"
 	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -94,6 +98,190 @@ func TestShutdown(t *testing.T) {
 	}
 }
 
+func TestDrain(t *testing.T) {
+	mpt := testSlowMapPinTracker(t)
+	defer mpt.Shutdown()
+
+	slowPin := api.Pin{
+		Cid:                  test.MustDecodeCid(test.TestSlowCid1),
+		Allocations:          []peer.ID{},
+		ReplicationFactorMin: -1,
+		ReplicationFactorMax: -1,
+	}
+
+	err := mpt.Track(slowPin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	err = mpt.Drain(ctx)
+	if err == nil {
+		t.Error("expected an error as the slow pin cannot finish in time")
+	}
+
+	ctx2, cancel2 := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel2()
+	err = mpt.Drain(ctx2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := mpt.Status(slowPin.Cid); st.Status != api.TrackerStatusPinned {
+		t.Errorf("cid should be pinned and is %s", st.Status)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	mpt := testSlowMapPinTracker(t)
+	defer mpt.Shutdown()
+
+	for _, c := range []string{test.TestSlowCid1, test.TestCid1} {
+		err := mpt.Track(api.Pin{
+			Cid:                  test.MustDecodeCid(c),
+			Allocations:          []peer.ID{},
+			ReplicationFactorMin: -1,
+			ReplicationFactorMax: -1,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	time.Sleep(500 * time.Millisecond)
+	usage := mpt.Usage()
+	if usage.Active != 1 || usage.Queued != 1 {
+		t.Errorf("expected 1 active and 1 queued operation: %+v", usage)
+	}
+	if usage.Limit != 2 {
+		t.Error("expected a limit of one pin and one unpin worker")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := mpt.Drain(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage := mpt.Usage(); usage.Active != 0 || usage.Queued != 0 {
+		t.Errorf("expected no pending operations: %+v", usage)
+	}
+}
+
+func TestTrackLowFreeSpace(t *testing.T) {
+	cfg := &Config{}
+	cfg.Default()
+	cfg.ConcurrentPins = 1
+	// The mock client reports 98000 bytes free
+	cfg.MinFreeSpace = 100000
+	mpt := NewMapPinTracker(cfg, test.TestPeerID1)
+	mpt.SetClient(test.NewMockRPCClient(t))
+	defer mpt.Shutdown()
+
+	h, _ := cid.Decode(test.TestCid1)
+	c := api.Pin{
+		Cid:                  h,
+		Allocations:          []peer.ID{},
+		ReplicationFactorMin: -1,
+		ReplicationFactorMax: -1,
+	}
+	err := mpt.Track(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(500 * time.Millisecond)
+	if st := mpt.Status(h); st.Status != api.TrackerStatusPinning {
+		t.Fatalf("pin should be paused and is %s", st.Status)
+	}
+
+	// Unpins are not affected and cancel the paused pin
+	err = mpt.Untrack(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	if st := mpt.Status(h); st.Status != api.TrackerStatusUnpinned {
+		t.Fatalf("cid should be unpinned and is %s", st.Status)
+	}
+}
+
+func TestPauseResume(t *testing.T) {
+	cfg := &Config{}
+	cfg.Default()
+	cfg.ConcurrentPins = 1
+	cfg.MaxPinQueueSize = 1
+	mpt := NewMapPinTracker(cfg, test.TestPeerID1)
+	mpt.SetClient(test.NewMockRPCClient(t))
+	defer mpt.Shutdown()
+
+	mpt.Pause()
+	mpt.Pause() // no-op
+	if !mpt.Paused() {
+		t.Fatal("tracker should be paused")
+	}
+
+	h1, _ := cid.Decode(test.TestCid1)
+	h2, _ := cid.Decode(test.TestCid2)
+	err := mpt.Track(api.Pin{
+		Cid:                  h1,
+		Allocations:          []peer.ID{},
+		ReplicationFactorMin: -1,
+		ReplicationFactorMax: -1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Remote pins are queued rather than unpinned right away
+	err = mpt.Track(api.Pin{
+		Cid:                  h2,
+		Allocations:          []peer.ID{test.TestPeerID2},
+		ReplicationFactorMin: 1,
+		ReplicationFactorMax: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(500 * time.Millisecond)
+	if st := mpt.Status(h1); st.Status != api.TrackerStatusPinQueued {
+		t.Fatalf("pin should be queued and is %s", st.Status)
+	}
+	if u := mpt.Usage(); u.Active != 0 {
+		t.Error("no worker should be busy while paused")
+	}
+
+	// More operations than the queue size are held while paused
+	for _, c := range []string{test.TestCid3, test.TestSlowCid1} {
+		err = mpt.Track(api.Pin{
+			Cid:                  test.MustDecodeCid(c),
+			Allocations:          []peer.ID{},
+			ReplicationFactorMin: -1,
+			ReplicationFactorMax: -1,
+		})
+		if err != nil {
+			t.Fatal("operations should not fail while paused:", err)
+		}
+	}
+
+	mpt.Resume()
+	mpt.Resume() // no-op
+	if mpt.Paused() {
+		t.Fatal("tracker should not be paused")
+	}
+
+	time.Sleep(500 * time.Millisecond)
+	if st := mpt.Status(h1); st.Status != api.TrackerStatusPinned {
+		t.Fatalf("cid should be pinned and is %s", st.Status)
+	}
+	if st := mpt.Status(h2); st.Status != api.TrackerStatusRemote {
+		t.Fatalf("cid should be remote and is %s", st.Status)
+	}
+	if st := mpt.Status(test.MustDecodeCid(test.TestCid3)); st.Status != api.TrackerStatusPinned {
+		t.Fatalf("held pins should be performed after resuming and is %s", st.Status)
+	}
+}
+
 func TestTrack(t *testing.T) {
 	mpt := testMapPinTracker(t)
 	defer mpt.Shutdown()
@@ -140,6 +328,36 @@ func TestTrack(t *testing.T) {
 	}
 }
 
+func TestRestore(t *testing.T) {
+	mpt := testSlowMapPinTracker(t)
+	defer mpt.Shutdown()
+
+	h1, _ := cid.Decode(test.TestCid1)
+	h2, _ := cid.Decode(test.TestCid2)
+
+	err := mpt.Restore(api.PinCid(h1), api.TrackerStatusPinned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = mpt.Restore(api.PinCid(h2), api.TrackerStatusRemote)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// No need to wait: nothing is pinned
+	if st := mpt.Status(h1).Status; st != api.TrackerStatusPinned {
+		t.Errorf("cid should be pinned and is %s", st)
+	}
+	if st := mpt.Status(h2).Status; st != api.TrackerStatusRemote {
+		t.Errorf("cid should be remote and is %s", st)
+	}
+
+	err = mpt.Restore(api.PinCid(h1), api.TrackerStatusPinError)
+	if err == nil {
+		t.Error("expected an error restoring an item in error")
+	}
+}
+
 func TestUntrack(t *testing.T) {
 	mpt := testMapPinTracker(t)
 	defer mpt.Shutdown()
@@ -200,6 +418,121 @@ func TestUntrack(t *testing.T) {
 	}
 }
 
+func TestUntrackGracePeriod(t *testing.T) {
+	unpinQueueCheckInterval = 100 * time.Millisecond
+	defer func() { unpinQueueCheckInterval = time.Second }()
+	mpt := testMapPinTracker(t)
+	defer mpt.Shutdown()
+	mpt.config.UnpinGracePeriod = time.Second
+
+	h1, _ := cid.Decode(test.TestCid1)
+	h2, _ := cid.Decode(test.TestCid2)
+	for _, h := range []*cid.Cid{h1, h2} {
+		c := api.Pin{
+			Cid:                  h,
+			Allocations:          []peer.ID{},
+			ReplicationFactorMin: -1,
+			ReplicationFactorMax: -1,
+		}
+		err := mpt.Track(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(time.Second / 2)
+
+	mpt.Untrack(h1)
+	mpt.Untrack(h2)
+	for _, h := range []*cid.Cid{h1, h2} {
+		if st := mpt.Status(h).Status; st != api.TrackerStatusUnpinPending {
+			t.Fatalf("%s should be pending unpin and is %s", h, st)
+		}
+	}
+
+	// Pinning again during the grace period cancels the unpin
+	err := mpt.Track(api.Pin{
+		Cid:                  h2,
+		Allocations:          []peer.ID{},
+		ReplicationFactorMin: -1,
+		ReplicationFactorMax: -1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(2 * time.Second)
+	if st := mpt.Status(h1).Status; st != api.TrackerStatusUnpinned {
+		t.Errorf("%s should be unpinned and is %s", h1, st)
+	}
+	if st := mpt.Status(h2).Status; st != api.TrackerStatusPinned {
+		t.Errorf("%s should be pinned and is %s", h2, st)
+	}
+}
+
+func TestUnpinQueuePersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unpinqueue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	newTracker := func() *MapPinTracker {
+		cfg := &Config{}
+		cfg.Default()
+		cfg.SetBaseDir(dir)
+		cfg.UnpinGracePeriod = time.Hour
+		mpt := NewMapPinTracker(cfg, test.TestPeerID1)
+		mpt.SetClient(test.NewMockRPCClient(t))
+		return mpt
+	}
+
+	h1, _ := cid.Decode(test.TestCid1)
+	h2, _ := cid.Decode(test.TestCid2)
+	mpt := newTracker()
+	for _, h := range []*cid.Cid{h1, h2} {
+		err := mpt.Track(api.Pin{
+			Cid:                  h,
+			Allocations:          []peer.ID{},
+			ReplicationFactorMin: -1,
+			ReplicationFactorMax: -1,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(time.Second / 2)
+	mpt.Untrack(h1)
+	mpt.Untrack(h2)
+	mpt.Shutdown()
+
+	// Pending unpins are resumed after a restart
+	mpt = newTracker()
+	for _, h := range []*cid.Cid{h1, h2} {
+		if st := mpt.Status(h).Status; st != api.TrackerStatusUnpinPending {
+			t.Errorf("%s should be pending unpin after a restart and is %s", h, st)
+		}
+	}
+
+	// and leave the queue when tracked again
+	err = mpt.Track(api.Pin{
+		Cid:                  h2,
+		Allocations:          []peer.ID{},
+		ReplicationFactorMin: -1,
+		ReplicationFactorMax: -1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mpt.Shutdown()
+
+	mpt = newTracker()
+	defer mpt.Shutdown()
+	items := mpt.unpins.list()
+	if len(items) != 1 || items[0].Cid != test.TestCid1 {
+		t.Errorf("only %s should be waiting to be unpinned: %+v", h1, items)
+	}
+}
+
 func TestStatusAll(t *testing.T) {
 	mpt := testMapPinTracker(t)
 	defer mpt.Shutdown()
@@ -253,6 +586,7 @@ func TestSyncAndRecover(t *testing.T) {
 		Allocations:          []peer.ID{},
 		ReplicationFactorMin: -1,
 		ReplicationFactorMax: -1,
+		Recursive:            true,
 	}
 	mpt.Track(c)
 	c = api.Pin{
@@ -260,6 +594,7 @@ func TestSyncAndRecover(t *testing.T) {
 		Allocations:          []peer.ID{},
 		ReplicationFactorMin: -1,
 		ReplicationFactorMax: -1,
+		Recursive:            true,
 	}
 	mpt.Track(c)
 
@@ -303,6 +638,89 @@ func TestSyncAndRecover(t *testing.T) {
 	}
 }
 
+func TestSyncPinTypeMismatch(t *testing.T) {
+	mpt := testMapPinTracker(t)
+	defer mpt.Shutdown()
+
+	h1, _ := cid.Decode(test.TestCid1)
+
+	// A direct pin. IPFSPinLsCid RPC returns recursive for Cid1.
+	c := api.Pin{
+		Cid:                  h1,
+		Allocations:          []peer.ID{},
+		ReplicationFactorMin: -1,
+		ReplicationFactorMax: -1,
+		Recursive:            false,
+	}
+	mpt.Track(c)
+	time.Sleep(100 * time.Millisecond)
+
+	info, err := mpt.Sync(h1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Status != api.TrackerStatusPinError {
+		t.Errorf("expected pin_error but got %s", info.Status)
+	}
+	if info.Error != errPinTypeMismatch.Error() {
+		t.Error("expected a pin type mismatch error")
+	}
+	if info.IPFSPinStatus != api.IPFSPinStatusRecursive {
+		t.Error("expected the ipfs pin status to be recorded")
+	}
+
+	// The item should have been re-pinned
+	time.Sleep(100 * time.Millisecond)
+	info = mpt.Status(h1)
+	if info.Status != api.TrackerStatusPinned {
+		t.Errorf("expected pinned but got %s", info.Status)
+	}
+
+	c.Recursive = true
+	mpt.Track(c)
+	time.Sleep(100 * time.Millisecond)
+	info, err = mpt.Sync(h1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Status != api.TrackerStatusPinned {
+		t.Errorf("expected pinned but got %s", info.Status)
+	}
+	if info.IPFSPinStatus != api.IPFSPinStatusRecursive {
+		t.Error("expected a recursive ipfs pin status")
+	}
+	// See the rpc mock implementation
+	if info.Size != 1000 {
+		t.Error("expected the pinned size to be recorded")
+	}
+}
+
+func TestTrackRawPinSize(t *testing.T) {
+	mpt := testMapPinTracker(t)
+	defer mpt.Shutdown()
+
+	h1, _ := cid.Decode(test.TestCid1)
+	c := api.PinCid(h1)
+	c.ReplicationFactorMin = -1
+	c.ReplicationFactorMax = -1
+	c.Raw = true
+
+	mpt.Track(c)
+	time.Sleep(100 * time.Millisecond)
+	info, err := mpt.Sync(h1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Status != api.TrackerStatusPinned {
+		t.Errorf("expected pinned but got %s", info.Status)
+	}
+	// See the rpc mock implementation: the size of raw pins
+	// comes from IPFSBlockSize.
+	if info.Size != 100 {
+		t.Error("expected the size of the root block to be recorded:", info.Size)
+	}
+}
+
 func TestRecoverAll(t *testing.T) {
 	mpt := testMapPinTracker(t)
 	defer mpt.Shutdown()
@@ -356,6 +774,7 @@ func TestSyncAll(t *testing.T) {
 		Allocations:          []peer.ID{},
 		ReplicationFactorMin: -1,
 		ReplicationFactorMax: -1,
+		Recursive:            true,
 	}
 
 	mpt.Track(c)
@@ -364,6 +783,7 @@ func TestSyncAll(t *testing.T) {
 		Allocations:          []peer.ID{},
 		ReplicationFactorMin: -1,
 		ReplicationFactorMax: -1,
+		Recursive:            true,
 	}
 	mpt.Track(c)
 
@@ -668,3 +1088,52 @@ func TestTrackUntrackConcurrent(t *testing.T) {
 		t.Fatal("should be pinned or unpinned")
 	}
 }
+
+func TestHooks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "maptracker-hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pinnedFile := filepath.Join(dir, "pinned")
+	errorFile := filepath.Join(dir, "error")
+
+	mpt := testSlowMapPinTracker(t)
+	defer mpt.Shutdown()
+	mpt.config.PinnedHook = "echo $IPFS_CLUSTER_CID $IPFS_CLUSTER_STATUS $IPFS_CLUSTER_PEER > " + pinnedFile
+	mpt.config.ErrorHook = "echo $IPFS_CLUSTER_CID $IPFS_CLUSTER_STATUS > " + errorFile
+
+	h1, _ := cid.Decode(test.TestCid1)
+	h2, _ := cid.Decode(pinCancelCid)
+	for _, h := range []*cid.Cid{h1, h2} {
+		err := mpt.Track(api.Pin{
+			Cid:                  h,
+			Allocations:          []peer.ID{},
+			ReplicationFactorMin: -1,
+			ReplicationFactorMax: -1,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	time.Sleep(time.Second)
+
+	out, err := ioutil.ReadFile(pinnedFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.Join([]string{h1.String(), api.TrackerStatusPinned.String(), test.TestPeerID1.Pretty()}, " ")
+	if strings.TrimSpace(string(out)) != expected {
+		t.Errorf("unexpected pinned hook output: %s", out)
+	}
+
+	out, err = ioutil.ReadFile(errorFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = strings.Join([]string{h2.String(), api.TrackerStatusPinError.String()}, " ")
+	if strings.TrimSpace(string(out)) != expected {
+		t.Errorf("unexpected error hook output: %s", out)
+	}
+}
@@ -0,0 +1,165 @@
+package maptracker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ipfs/ipfs-cluster/fsutil"
+	"github.com/ipfs/ipfs-cluster/pintracker/optracker"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// how often the unpin queue is checked for items whose
+// UnpinGracePeriod has expired
+var unpinQueueCheckInterval = time.Second
+
+// unpinQueue holds the items waiting for the UnpinGracePeriod to expire
+// before being unpinned. It is saved to Config.GetUnpinQueuePath() on
+// every change, so that pending unpins survive restarts. Items are only
+// removed once they have been unpinned, or when they are tracked again.
+type unpinQueue struct {
+	path string
+
+	mux   sync.Mutex
+	items map[string]*pendingUnpin
+}
+
+// pendingUnpin is an item in the unpinQueue. op is the unpin operation
+// tracked for it, if any.
+type pendingUnpin struct {
+	Cid      string    `json:"cid"`
+	Deadline time.Time `json:"deadline"`
+
+	op *optracker.Operation
+}
+
+func newUnpinQueue(path string) *unpinQueue {
+	return &unpinQueue{
+		path:  path,
+		items: make(map[string]*pendingUnpin),
+	}
+}
+
+// add queues the item handled by the given operation until the deadline
+// and saves the queue. Items already queued keep their deadline.
+func (q *unpinQueue) add(op *optracker.Operation, deadline time.Time) error {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	k := op.Cid().String()
+	if item, ok := q.items[k]; ok {
+		item.op = op
+		return nil
+	}
+	q.items[k] = &pendingUnpin{
+		Cid:      k,
+		Deadline: deadline,
+		op:       op,
+	}
+	return q.save()
+}
+
+// remove takes an item out of the queue and saves it. It does nothing
+// when the item is not queued.
+func (q *unpinQueue) remove(c *cid.Cid) error {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	k := c.String()
+	if _, ok := q.items[k]; !ok {
+		return nil
+	}
+	delete(q.items, k)
+	return q.save()
+}
+
+// due returns the operations of the items whose deadline has passed and
+// which are still waiting, that is, which have not been queued for
+// unpinning yet.
+func (q *unpinQueue) due(now time.Time) []*optracker.Operation {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	var ops []*optracker.Operation
+	for _, item := range q.items {
+		if item.op == nil || item.Deadline.After(now) {
+			continue
+		}
+		if item.op.Phase() == optracker.PhaseWaiting && !item.op.Cancelled() {
+			ops = append(ops, item.op)
+		}
+	}
+	return ops
+}
+
+// list returns the queued items sorted by deadline.
+func (q *unpinQueue) list() []pendingUnpin {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	items := make([]pendingUnpin, 0, len(q.items))
+	for _, item := range q.items {
+		items = append(items, *item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Deadline.Before(items[j].Deadline)
+	})
+	return items
+}
+
+// load reads the queue file, if any, replacing the current queue.
+func (q *unpinQueue) load() error {
+	if q.path == "" {
+		return nil
+	}
+
+	raw, err := ioutil.ReadFile(q.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var list []*pendingUnpin
+	err = json.Unmarshal(raw, &list)
+	if err != nil {
+		return fmt.Errorf("error parsing %s: %s", q.path, err)
+	}
+
+	items := make(map[string]*pendingUnpin)
+	for _, item := range list {
+		if _, err := cid.Decode(item.Cid); err != nil {
+			return fmt.Errorf("error parsing %s: %s: %s", q.path, item.Cid, err)
+		}
+		items[item.Cid] = item
+	}
+
+	q.mux.Lock()
+	q.items = items
+	q.mux.Unlock()
+	return nil
+}
+
+// save writes the queue file. It must be called with the lock held.
+func (q *unpinQueue) save() error {
+	if q.path == "" {
+		return nil
+	}
+
+	list := make([]*pendingUnpin, 0, len(q.items))
+	for _, item := range q.items {
+		list = append(list, item)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Cid < list[j].Cid
+	})
+	raw, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return fsutil.WriteFileAtomic(q.path, raw, 0600)
+}
@@ -41,6 +41,9 @@ const (
 	PhaseInProgress
 	// PhaseDone represents the operation once finished.
 	PhaseDone
+	// PhaseWaiting represents an operation which waits for some
+	// time before being queued.
+	PhaseWaiting
 )
 
 // Operation represents an ongoing operation involving a
@@ -54,11 +57,16 @@ type Operation struct {
 	opType OperationType
 	pin    api.Pin
 
+	// onChange, when set, is called after the phase changes.
+	onChange func(*Operation)
+
 	// RW fields
-	mu    sync.RWMutex
-	phase Phase
-	error string
-	ts    time.Time
+	mu         sync.RWMutex
+	phase      Phase
+	error      string
+	ts         time.Time
+	ipfsStatus api.IPFSPinStatus
+	size       uint64
 }
 
 // NewOperation creates a new Operation.
@@ -103,9 +111,10 @@ func (op *Operation) Phase() Phase {
 // SetPhase changes the Phase and updates the timestamp.
 func (op *Operation) SetPhase(ph Phase) {
 	op.mu.Lock()
-	defer op.mu.Unlock()
 	op.phase = ph
 	op.ts = time.Now()
+	op.mu.Unlock()
+	op.changed()
 }
 
 // Error returns any error message attached to the operation.
@@ -119,10 +128,50 @@ func (op *Operation) Error() string {
 // an error message. It updates the timestamp.
 func (op *Operation) SetError(err error) {
 	op.mu.Lock()
-	defer op.mu.Unlock()
 	op.phase = PhaseError
 	op.error = err.Error()
 	op.ts = time.Now()
+	op.mu.Unlock()
+	op.changed()
+}
+
+// changed notifies a phase change, if anyone is interested.
+func (op *Operation) changed() {
+	if op.onChange != nil {
+		op.onChange(op)
+	}
+}
+
+// IPFSStatus returns the type of pin last seen in IPFS for the
+// Cid of this operation, or api.IPFSPinStatusBug when unknown.
+func (op *Operation) IPFSStatus() api.IPFSPinStatus {
+	op.mu.RLock()
+	defer op.mu.RUnlock()
+	return op.ipfsStatus
+}
+
+// SetIPFSStatus records the type of pin seen in IPFS for the Cid
+// of this operation. It does not update the timestamp.
+func (op *Operation) SetIPFSStatus(ips api.IPFSPinStatus) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	op.ipfsStatus = ips
+}
+
+// Size returns the cumulative size of the DAG for the Cid of this
+// operation, or 0 when unknown.
+func (op *Operation) Size() uint64 {
+	op.mu.RLock()
+	defer op.mu.RUnlock()
+	return op.size
+}
+
+// SetSize records the cumulative size of the DAG for the Cid of this
+// operation. It does not update the timestamp.
+func (op *Operation) SetSize(size uint64) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	op.size = size
 }
 
 // Type returns the operation Type.
@@ -178,6 +227,8 @@ func (op *Operation) ToTrackerStatus() api.TrackerStatus {
 		switch ph {
 		case PhaseError:
 			return api.TrackerStatusUnpinError
+		case PhaseWaiting:
+			return api.TrackerStatusUnpinPending
 		case PhaseQueued:
 			return api.TrackerStatusUnpinQueued
 		case PhaseInProgress:
@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/logutil"
 
 	cid "github.com/ipfs/go-cid"
 	logging "github.com/ipfs/go-log"
@@ -19,24 +20,43 @@ import (
 
 var logger = logging.Logger("optracker")
 
+// HistoryLength is the number of status transitions remembered for
+// every Cid (see History).
+const HistoryLength = 10
+
 // OperationTracker tracks and manages all inflight Operations.
 type OperationTracker struct {
-	ctx context.Context // parent context for all ops
-	pid peer.ID
+	ctx    context.Context // parent context for all ops
+	pid    peer.ID
+	logger *logutil.Logger
 
 	mu         sync.RWMutex
 	operations map[string]*Operation
+
+	historyMu sync.Mutex
+	history   map[string][]api.StatusChange
+	counts    map[api.TrackerStatus]int // last status of every Cid in history
 }
 
 // NewOperationTracker creates a new OperationTracker.
 func NewOperationTracker(ctx context.Context, pid peer.ID) *OperationTracker {
+	log := logutil.New(logger)
+	log.SetPrefix(logutil.PeerPrefix(pid))
 	return &OperationTracker{
 		ctx:        ctx,
 		pid:        pid,
+		logger:     log,
 		operations: make(map[string]*Operation),
+		history:    make(map[string][]api.StatusChange),
+		counts:     make(map[api.TrackerStatus]int),
 	}
 }
 
+// SetLogPrefix sets the prefix of the messages logged by this component.
+func (opt *OperationTracker) SetLogPrefix(prefix string) {
+	opt.logger.SetPrefix(prefix)
+}
+
 // TrackNewOperation will create, track and return a new operation unless
 // one already exists to do the same thing, in which case nil is returned.
 //
@@ -57,13 +77,66 @@ func (opt *OperationTracker) TrackNewOperation(pin api.Pin, typ OperationType, p
 	}
 
 	op2 := NewOperation(opt.ctx, pin, typ, ph)
-	logger.Debugf("'%s' on cid '%s' has been created with phase '%s'", typ, cidStr, ph)
+	op2.onChange = opt.recordStatus
+	opt.logger.Debugf("'%s' on cid '%s' has been created with phase '%s'", typ, cidStr, ph)
 	opt.operations[cidStr] = op2
+	opt.recordStatus(op2)
 	return op2
 }
 
+// recordStatus appends the current status of an operation to the
+// history of its Cid, unless it is the same as the last one recorded.
+// Only the last HistoryLength transitions are kept.
+func (opt *OperationTracker) recordStatus(op *Operation) {
+	change := api.StatusChange{
+		Status: op.ToTrackerStatus(),
+		TS:     op.Timestamp(),
+		Error:  op.Error(),
+	}
+	cidStr := op.Cid().String()
+
+	opt.historyMu.Lock()
+	defer opt.historyMu.Unlock()
+	h := opt.history[cidStr]
+	n := len(h)
+	if n > 0 && h[n-1].Status == change.Status && h[n-1].Error == change.Error {
+		return
+	}
+	if n > 0 {
+		opt.counts[h[n-1].Status]--
+	}
+	opt.counts[change.Status]++
+	h = append(h, change)
+	if len(h) > HistoryLength {
+		h = h[len(h)-HistoryLength:]
+	}
+	opt.history[cidStr] = h
+}
+
+// History returns the last status transitions of the given Cid,
+// oldest first.
+func (opt *OperationTracker) History(c *cid.Cid) []api.StatusChange {
+	opt.historyMu.Lock()
+	defer opt.historyMu.Unlock()
+	h := opt.history[c.String()]
+	if len(h) == 0 {
+		return nil
+	}
+	res := make([]api.StatusChange, len(h))
+	copy(res, h)
+	return res
+}
+
+// Count returns the number of Cids whose operation is currently in
+// the given status, without listing them.
+func (opt *OperationTracker) Count(st api.TrackerStatus) int {
+	opt.historyMu.Lock()
+	defer opt.historyMu.Unlock()
+	return opt.counts[st]
+}
+
 // Clean deletes an operation from the tracker if it is the one we are tracking
-// (compares pointers)
+// (compares pointers). The status history of the Cid is forgotten too.
 func (opt *OperationTracker) Clean(op *Operation) {
 	cidStr := op.Cid().String()
 
@@ -72,6 +145,12 @@ func (opt *OperationTracker) Clean(op *Operation) {
 	op2, ok := opt.operations[cidStr]
 	if ok && op == op2 { // same pointer
 		delete(opt.operations, cidStr)
+		opt.historyMu.Lock()
+		if h := opt.history[cidStr]; len(h) > 0 {
+			opt.counts[h[len(h)-1].Status]--
+		}
+		delete(opt.history, cidStr)
+		opt.historyMu.Unlock()
 	}
 }
 
@@ -101,9 +180,32 @@ func (opt *OperationTracker) SetError(c *cid.Cid, err error) {
 	}
 
 	if ph := op.Phase(); ph == PhaseDone || ph == PhaseError {
-		op.SetPhase(PhaseError)
-		op.SetError(err)
+		op.SetError(err) // sets PhaseError
+	}
+}
+
+// SetIPFSStatus records the type of pin seen in IPFS in the operation
+// for the given Cid, if any.
+func (opt *OperationTracker) SetIPFSStatus(c *cid.Cid, ips api.IPFSPinStatus) {
+	opt.mu.RLock()
+	defer opt.mu.RUnlock()
+	op, ok := opt.operations[c.String()]
+	if !ok {
+		return
+	}
+	op.SetIPFSStatus(ips)
+}
+
+// GetPin returns the Pin associated to the operation for the given
+// Cid. It returns false if we are not tracking any operation for it.
+func (opt *OperationTracker) GetPin(c *cid.Cid) (api.Pin, bool) {
+	opt.mu.RLock()
+	defer opt.mu.RUnlock()
+	op, ok := opt.operations[c.String()]
+	if !ok {
+		return api.Pin{}, false
 	}
+	return op.Pin(), true
 }
 
 func (opt *OperationTracker) unsafePinInfo(op *Operation) api.PinInfo {
@@ -118,15 +220,17 @@ func (opt *OperationTracker) unsafePinInfo(op *Operation) api.PinInfo {
 	}
 
 	return api.PinInfo{
-		Cid:    op.Cid(),
-		Peer:   opt.pid,
-		Status: op.ToTrackerStatus(),
-		TS:     op.Timestamp(),
-		Error:  op.Error(),
+		Cid:           op.Cid(),
+		Peer:          opt.pid,
+		Status:        op.ToTrackerStatus(),
+		TS:            op.Timestamp(),
+		Error:         op.Error(),
+		IPFSPinStatus: op.IPFSStatus(),
+		Size:          op.Size(),
 	}
 }
 
-// Get returns a PinInfo object for Cid.
+// Get returns a PinInfo object for Cid, including its status history.
 func (opt *OperationTracker) Get(c *cid.Cid) api.PinInfo {
 	opt.mu.RLock()
 	defer opt.mu.RUnlock()
@@ -135,10 +239,12 @@ func (opt *OperationTracker) Get(c *cid.Cid) api.PinInfo {
 	if pInfo.Cid == nil {
 		pInfo.Cid = c
 	}
+	pInfo.History = opt.History(c)
 	return pInfo
 }
 
-// GetAll returns PinInfo objets for all known operations
+// GetAll returns PinInfo objets for all known operations. They do not
+// include the status history.
 func (opt *OperationTracker) GetAll() []api.PinInfo {
 	var pinfos []api.PinInfo
 	opt.mu.RLock()
@@ -149,6 +255,21 @@ func (opt *OperationTracker) GetAll() []api.PinInfo {
 	return pinfos
 }
 
+// InFlight returns the Cids for which there is an operation queued or
+// in progress.
+func (opt *OperationTracker) InFlight() []*cid.Cid {
+	var cids []*cid.Cid
+	opt.mu.RLock()
+	defer opt.mu.RUnlock()
+	for _, op := range opt.operations {
+		switch op.Phase() {
+		case PhaseQueued, PhaseInProgress:
+			cids = append(cids, op.Cid())
+		}
+	}
+	return cids
+}
+
 // GetOpContext gets the context of an operation, if any.
 func (opt *OperationTracker) GetOpContext(c *cid.Cid) context.Context {
 	opt.mu.RLock()
@@ -191,6 +191,93 @@ func TestOperationTracker_GetAll(t *testing.T) {
 	}
 }
 
+func TestOperationTracker_History(t *testing.T) {
+	opt := testOperationTracker(t)
+	h := test.MustDecodeCid(test.TestCid1)
+	op := opt.TrackNewOperation(api.PinCid(h), OperationPin, PhaseQueued)
+	op.SetPhase(PhaseInProgress)
+	op.SetPhase(PhaseDone)
+	opt.SetError(h, errors.New("fake error"))
+	opt.SetError(h, errors.New("fake error"))
+	opt.TrackNewOperation(api.PinCid(h), OperationPin, PhaseDone)
+
+	expected := []api.TrackerStatus{
+		api.TrackerStatusPinQueued,
+		api.TrackerStatusPinning,
+		api.TrackerStatusPinned,
+		api.TrackerStatusPinError,
+		api.TrackerStatusPinned,
+	}
+	history := opt.Get(h).History
+	if len(history) != len(expected) {
+		t.Fatalf("expected %d transitions: %+v", len(expected), history)
+	}
+	for i, st := range expected {
+		if history[i].Status != st {
+			t.Errorf("transition %d: expected %s but got %s", i, st, history[i].Status)
+		}
+	}
+	if history[3].Error != "fake error" {
+		t.Error("the error should be part of the history")
+	}
+	if len(opt.GetAll()[0].History) != 0 {
+		t.Error("GetAll should not include the history")
+	}
+
+	for i := 0; i < HistoryLength; i++ {
+		opt.SetError(h, errors.New("fake error"))
+		opt.TrackNewOperation(api.PinCid(h), OperationPin, PhaseDone)
+	}
+	if len(opt.History(h)) != HistoryLength {
+		t.Error("the history should be bounded")
+	}
+
+	op = opt.TrackNewOperation(api.PinCid(h), OperationUnpin, PhaseDone)
+	opt.Clean(op)
+	if len(opt.History(h)) != 0 {
+		t.Error("the history should be forgotten when cleaning")
+	}
+}
+
+func TestOperationTracker_InFlight(t *testing.T) {
+	opt := testOperationTracker(t)
+	h1 := test.MustDecodeCid(test.TestCid1)
+	h2 := test.MustDecodeCid(test.TestCid2)
+	h3 := test.MustDecodeCid(test.TestCid3)
+	opt.TrackNewOperation(api.PinCid(h1), OperationPin, PhaseInProgress)
+	opt.TrackNewOperation(api.PinCid(h2), OperationUnpin, PhaseQueued)
+	opt.TrackNewOperation(api.PinCid(h3), OperationPin, PhaseDone)
+	if len(opt.InFlight()) != 2 {
+		t.Fatal("expected 2 operations in flight")
+	}
+}
+
+func TestOperationTracker_Count(t *testing.T) {
+	opt := testOperationTracker(t)
+	h1 := test.MustDecodeCid(test.TestCid1)
+	h2 := test.MustDecodeCid(test.TestCid2)
+	op1 := opt.TrackNewOperation(api.PinCid(h1), OperationPin, PhaseQueued)
+	opt.TrackNewOperation(api.PinCid(h2), OperationPin, PhaseQueued)
+	if n := opt.Count(api.TrackerStatusPinQueued); n != 2 {
+		t.Fatalf("expected 2 queued pins, got %d", n)
+	}
+
+	op1.SetPhase(PhaseInProgress)
+	if opt.Count(api.TrackerStatusPinQueued) != 1 || opt.Count(api.TrackerStatusPinning) != 1 {
+		t.Error("the counts should follow the status changes")
+	}
+
+	op1.SetError(errors.New("fake error"))
+	if opt.Count(api.TrackerStatusPinning) != 0 || opt.Count(api.TrackerStatusPinError) != 1 {
+		t.Error("expected the pin in error to be counted")
+	}
+
+	opt.Clean(op1)
+	if opt.Count(api.TrackerStatusPinError) != 0 {
+		t.Error("cleaned operations should not be counted")
+	}
+}
+
 func TestOperationTracker_GetOpContext(t *testing.T) {
 	opt := testOperationTracker(t)
 	h := test.MustDecodeCid(test.TestCid1)
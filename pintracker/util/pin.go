@@ -9,7 +9,7 @@ import (
 // IsRemotePin determines whether a Pin's ReplicationFactor has
 // been met, so as to either pin or unpin it from the peer.
 func IsRemotePin(c api.Pin, pid peer.ID) bool {
-	if c.ReplicationFactorMax < 0 {
+	if c.IsPinEverywhere() {
 		return false
 	}
 
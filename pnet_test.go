@@ -31,6 +31,34 @@ func TestClusterSecretFormat(t *testing.T) {
 	}
 }
 
+func TestSwappableProtector(t *testing.T) {
+	secret1, _ := DecodeClusterSecret("0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef")
+	secret2, _ := DecodeClusterSecret("fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210")
+
+	sp := &swappableProtector{}
+	err := sp.setSecret(secret1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp1 := sp.Fingerprint()
+
+	err = sp.setSecret(secret2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sp.Fingerprint() == fp1 {
+		t.Error("the fingerprint should change with the secret")
+	}
+
+	err = sp.setSecret(secret1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sp.Fingerprint() != fp1 {
+		t.Error("the same secret should give the same fingerprint")
+	}
+}
+
 func TestSimplePNet(t *testing.T) {
 	clusters, mocks := peerManagerClusters(t)
 	defer cleanRaft()
@@ -0,0 +1,141 @@
+package ipfscluster
+
+import (
+	"errors"
+	"sync"
+
+	cid "github.com/ipfs/go-cid"
+	peer "github.com/libp2p/go-libp2p-peer"
+
+	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/rpcutil"
+)
+
+// prefetches keeps the Cids which this peer is currently prefetching, so
+// that repeated requests do not fetch the same DAG several times.
+type prefetches struct {
+	mux  sync.Mutex
+	cids map[string]struct{}
+}
+
+func newPrefetches() *prefetches {
+	return &prefetches{
+		cids: make(map[string]struct{}),
+	}
+}
+
+// start returns false when the Cid is already being prefetched.
+func (pf *prefetches) start(h *cid.Cid) bool {
+	pf.mux.Lock()
+	defer pf.mux.Unlock()
+	if _, ok := pf.cids[h.String()]; ok {
+		return false
+	}
+	pf.cids[h.String()] = struct{}{}
+	return true
+}
+
+func (pf *prefetches) done(h *cid.Cid) {
+	pf.mux.Lock()
+	defer pf.mux.Unlock()
+	delete(pf.cids, h.String())
+}
+
+// Prefetch asks the peers which would be allocated to the given pin to
+// fetch its DAG without pinning it, i.e. to stage content close to where
+// it will be pinned or served later. Nothing is committed to the shared
+// state. Allocations are obtained as Pin does, using the replication
+// factors of the pin (or the defaults). Cids already in the shared state
+// keep their current allocations. The peers fetch the content in the
+// background: Prefetch returns the peers which started fetching.
+func (c *Cluster) Prefetch(pin api.Pin) ([]peer.ID, error) {
+	h := api.CanonicalCid(pin.Cid)
+	if c.blocklist.has(h) {
+		return nil, errBlocklisted(h)
+	}
+
+	allocs, err := c.prefetchAllocations(h, pin.ReplicationFactorMin, pin.ReplicationFactorMax)
+	if err != nil {
+		c.logger.Error(err)
+		return nil, err
+	}
+	if len(allocs) == 0 {
+		return nil, errors.New("no peers to prefetch the content")
+	}
+
+	c.logger.Infof("prefetching %s in %d peers", h, len(allocs))
+	ctxs, cancels := rpcutil.CtxsWithCancel(c.ctx, len(allocs))
+	defer rpcutil.MultiCancel(cancels)
+
+	errs := c.rpcClient.MultiCall(
+		ctxs,
+		allocs,
+		"Cluster",
+		"PrefetchLocal",
+		api.PinCid(h).ToSerial(),
+		rpcutil.RPCDiscardReplies(len(allocs)),
+	)
+
+	var started []peer.ID
+	for i, e := range errs {
+		if e != nil {
+			c.logger.Errorf("prefetching %s in %s: %s", h, allocs[i].Pretty(), e)
+			err = e
+			continue
+		}
+		started = append(started, allocs[i])
+	}
+	if len(started) == 0 {
+		return nil, err
+	}
+	return started, nil
+}
+
+// prefetchAllocations returns the peers which would be allocated to a
+// Cid with the given replication factors.
+func (c *Cluster) prefetchAllocations(h *cid.Cid, rplMin, rplMax int) ([]peer.ID, error) {
+	defaultMin, defaultMax := c.defaultReplicationFactors()
+	if rplMin == 0 {
+		rplMin = defaultMin
+	}
+	if rplMax == 0 {
+		rplMax = defaultMax
+	}
+
+	everywhere := api.ReplicationFactorEverywhere
+	curr, exists := c.getCurrentPin(h)
+	if rplMin == everywhere || rplMax == everywhere || (exists && curr.IsPinEverywhere()) {
+		return c.consensus.Peers()
+	}
+
+	if err := isReplicationFactorValid(rplMin, rplMax); err != nil {
+		return nil, err
+	}
+	return c.allocate(h, rplMin, rplMax, []peer.ID{}, []peer.ID{})
+}
+
+// PrefetchLocal makes the IPFS daemon of this peer fetch the DAG under
+// the given Cid without pinning it. It returns right away while the
+// content is fetched in the background.
+func (c *Cluster) PrefetchLocal(h *cid.Cid) error {
+	if c.blocklist.has(h) {
+		return errBlocklisted(h)
+	}
+	if !c.prefetches.start(h) {
+		c.logger.Debugf("%s is already being prefetched", h)
+		return nil
+	}
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		defer c.prefetches.done(h)
+		err := c.ipfs.Prefetch(c.ctx, h)
+		if err != nil {
+			c.logger.Errorf("prefetching %s: %s", h, err)
+			return
+		}
+		c.logger.Infof("%s prefetched", h)
+	}()
+	return nil
+}
@@ -14,6 +14,7 @@ import (
 	"time"
 
 	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/logutil"
 
 	logging "github.com/ipfs/go-log"
 	host "github.com/libp2p/go-libp2p-host"
@@ -38,19 +39,30 @@ type Manager struct {
 	host          host.Host
 	peerstoreLock sync.Mutex
 	peerstorePath string
+	logger        *logutil.Logger
 }
 
 // New creates a Manager with the given libp2p Host and peerstorePath.
 // The path indicates the place to persist and read peer addresses from.
 // If empty, these operations (LoadPeerstore, SavePeerstore) will no-op.
 func New(h host.Host, peerstorePath string) *Manager {
+	log := logutil.New(logger)
+	if h != nil {
+		log.SetPrefix(logutil.PeerPrefix(h.ID()))
+	}
 	return &Manager{
 		ctx:           context.Background(),
 		host:          h,
 		peerstorePath: peerstorePath,
+		logger:        log,
 	}
 }
 
+// SetLogPrefix sets the prefix of the messages logged by this Manager.
+func (pm *Manager) SetLogPrefix(prefix string) {
+	pm.logger.SetPrefix(prefix)
+}
+
 // ImportPeer adds a new peer address to the host's peerstore, optionally
 // dialing to it. It will resolve any DNS multiaddresses before adding them.
 // The address is expected to include the /ipfs/<peerID> protocol part.
@@ -59,7 +71,7 @@ func (pm *Manager) ImportPeer(addr ma.Multiaddr, connect bool) error {
 		return nil
 	}
 
-	logger.Debugf("adding peer address %s", addr)
+	pm.logger.Debugf("adding peer address %s", addr)
 	pid, decapAddr, err := api.Libp2pMultiaddrSplit(addr)
 	if err != nil {
 		return err
@@ -73,7 +85,7 @@ func (pm *Manager) ImportPeer(addr ma.Multiaddr, connect bool) error {
 		defer cancel()
 		resolvedAddrs, err := madns.Resolve(ctx, addr)
 		if err != nil {
-			logger.Error(err)
+			pm.logger.Error(err)
 			return err
 		}
 		pm.ImportPeers(resolvedAddrs, connect)
@@ -92,7 +104,7 @@ func (pm *Manager) RmPeer(pid peer.ID) error {
 		return nil
 	}
 
-	logger.Debugf("forgetting peer %s", pid.Pretty())
+	pm.logger.Debugf("forgetting peer %s", pid.Pretty())
 	pm.host.Peerstore().ClearAddrs(pid)
 	return nil
 }
@@ -184,7 +196,7 @@ func (pm *Manager) LoadPeerstore() (addrs []ma.Multiaddr) {
 		}
 		addr, err := ma.NewMultiaddr(addrStr)
 		if err != nil {
-			logger.Errorf(
+			pm.logger.Errorf(
 				"error parsing multiaddress from %s: %s",
 				pm.peerstorePath,
 				err,
@@ -193,7 +205,7 @@ func (pm *Manager) LoadPeerstore() (addrs []ma.Multiaddr) {
 		addrs = append(addrs, addr)
 	}
 	if err := scanner.Err(); err != nil {
-		logger.Errorf("reading %s: %s", pm.peerstorePath, err)
+		pm.logger.Errorf("reading %s: %s", pm.peerstorePath, err)
 	}
 	return addrs
 }
@@ -210,7 +222,7 @@ func (pm *Manager) SavePeerstore(addrs []ma.Multiaddr) {
 
 	f, err := os.Create(pm.peerstorePath)
 	if err != nil {
-		logger.Errorf(
+		pm.logger.Errorf(
 			"could not save peer addresses to %s: %s",
 			pm.peerstorePath,
 			err,
@@ -3,6 +3,7 @@ package ipfscluster
 import (
 	"context"
 	"errors"
+	"time"
 
 	peer "github.com/libp2p/go-libp2p-peer"
 
@@ -33,13 +34,37 @@ func (rpcapi *RPCAPI) ID(ctx context.Context, in struct{}, out *api.IDSerial) er
 
 // Pin runs Cluster.Pin().
 func (rpcapi *RPCAPI) Pin(ctx context.Context, in api.PinSerial, out *struct{}) error {
-	return rpcapi.c.Pin(in.ToPin())
+	pin := in.ToPin()
+	if err := rpcapi.c.verifyPublisher(api.PinOpPin, pin); err != nil {
+		return err
+	}
+	return rpcapi.c.Pin(pin)
 }
 
 // Unpin runs Cluster.Unpin().
 func (rpcapi *RPCAPI) Unpin(ctx context.Context, in api.PinSerial, out *struct{}) error {
-	c := in.ToPin().Cid
-	return rpcapi.c.Unpin(c)
+	pin := in.ToPin()
+	if err := rpcapi.c.verifyPublisher(api.PinOpUnpin, pin); err != nil {
+		return err
+	}
+	if pin.RequestID == "" {
+		pin.RequestID = api.NewRequestID()
+	}
+	return rpcapi.c.unpin(pin)
+}
+
+// UnpinMatching runs Cluster.UnpinMatching().
+func (rpcapi *RPCAPI) UnpinMatching(ctx context.Context, in api.BulkUnpin, out *[]api.PinSerial) error {
+	if !in.DryRun && len(rpcapi.c.config.AuthorizedPublishers) > 0 {
+		return errors.New("bulk unpin request rejected: unpin requests must be signed by an authorized publisher")
+	}
+	pins, err := rpcapi.c.UnpinMatching(in.Query, in.DryRun)
+	pinsSerial := make([]api.PinSerial, len(pins), len(pins))
+	for i, p := range pins {
+		pinsSerial[i] = p.ToSerial()
+	}
+	*out = pinsSerial
+	return err
 }
 
 // Pins runs Cluster.Pins().
@@ -53,6 +78,17 @@ func (rpcapi *RPCAPI) Pins(ctx context.Context, in struct{}, out *[]api.PinSeria
 	return nil
 }
 
+// SearchPins runs Cluster.SearchPins().
+func (rpcapi *RPCAPI) SearchPins(ctx context.Context, in api.PinQuery, out *[]api.PinSerial) error {
+	pins := rpcapi.c.SearchPins(in)
+	pinsSerial := make([]api.PinSerial, 0, len(pins))
+	for _, p := range pins {
+		pinsSerial = append(pinsSerial, p.ToSerial())
+	}
+	*out = pinsSerial
+	return nil
+}
+
 // PinGet runs Cluster.PinGet().
 func (rpcapi *RPCAPI) PinGet(ctx context.Context, in api.PinSerial, out *api.PinSerial) error {
 	cidarg := in.ToPin()
@@ -82,6 +118,17 @@ func (rpcapi *RPCAPI) Peers(ctx context.Context, in struct{}, out *[]api.IDSeria
 	return nil
 }
 
+// QueryPeers runs Cluster.QueryPeers().
+func (rpcapi *RPCAPI) QueryPeers(ctx context.Context, in api.PeersQuery, out *[]api.IDSerial) error {
+	peers := rpcapi.c.QueryPeers(in)
+	sPeers := make([]api.IDSerial, 0, len(peers))
+	for _, p := range peers {
+		sPeers = append(sPeers, p.ToSerial())
+	}
+	*out = sPeers
+	return nil
+}
+
 // PeerAdd runs Cluster.PeerAdd().
 func (rpcapi *RPCAPI) PeerAdd(ctx context.Context, in api.MultiaddrSerial, out *api.IDSerial) error {
 	addr := in.ToMultiaddr()
@@ -97,9 +144,238 @@ func (rpcapi *RPCAPI) ConnectGraph(ctx context.Context, in struct{}, out *api.Co
 	return err
 }
 
+// BackPressure runs Cluster.BackPressure().
+func (rpcapi *RPCAPI) BackPressure(ctx context.Context, in struct{}, out *int) error {
+	*out = rpcapi.c.BackPressure()
+	return nil
+}
+
+// Metrics runs Cluster.Metrics().
+func (rpcapi *RPCAPI) Metrics(ctx context.Context, in string, out *[]api.Metric) error {
+	*out = rpcapi.c.Metrics(in)
+	return nil
+}
+
+// PeerHealth runs Cluster.PeerHealth().
+func (rpcapi *RPCAPI) PeerHealth(ctx context.Context, in struct{}, out *[]api.PeerHealthSerial) error {
+	health, err := rpcapi.c.PeerHealth()
+	if err != nil {
+		return err
+	}
+	serials := make([]api.PeerHealthSerial, len(health), len(health))
+	for i, ph := range health {
+		serials[i] = ph.ToSerial()
+	}
+	*out = serials
+	return nil
+}
+
+// ResourceUsage runs Cluster.ResourceUsage().
+func (rpcapi *RPCAPI) ResourceUsage(ctx context.Context, in struct{}, out *api.ResourceUsage) error {
+	*out = rpcapi.c.ResourceUsage()
+	return nil
+}
+
+// ConsensusState runs Cluster.ConsensusState().
+func (rpcapi *RPCAPI) ConsensusState(ctx context.Context, in struct{}, out *api.ConsensusStateSerial) error {
+	cs, err := rpcapi.c.ConsensusState()
+	*out = cs.ToSerial()
+	return err
+}
+
+// PeerRotate runs Cluster.PeerRotate().
+func (rpcapi *RPCAPI) PeerRotate(ctx context.Context, in api.PeerRotationSerial, out *struct{}) error {
+	pr := in.ToPeerRotation()
+	return rpcapi.c.PeerRotate(pr.Old, pr.New)
+}
+
+// TransferLeadership runs Cluster.TransferLeadership().
+func (rpcapi *RPCAPI) TransferLeadership(ctx context.Context, in peer.ID, out *struct{}) error {
+	return rpcapi.c.TransferLeadership(in)
+}
+
+// StateChecksum runs Cluster.StateChecksum().
+func (rpcapi *RPCAPI) StateChecksum(ctx context.Context, in struct{}, out *api.StateChecksum) error {
+	sum, err := rpcapi.c.StateChecksum()
+	*out = sum
+	return err
+}
+
+// StateSnapshot runs Cluster.StateSnapshot().
+func (rpcapi *RPCAPI) StateSnapshot(ctx context.Context, in struct{}, out *[]byte) error {
+	snap, err := rpcapi.c.StateSnapshot()
+	*out = snap
+	return err
+}
+
+// PreloadState runs Cluster.PreloadState().
+func (rpcapi *RPCAPI) PreloadState(ctx context.Context, in []api.PinSerial, out *struct{}) error {
+	pins := make([]api.Pin, len(in), len(in))
+	for i, p := range in {
+		pins[i] = p.ToPin()
+	}
+	return rpcapi.c.PreloadState(pins)
+}
+
+// RepairState runs Cluster.RepairState().
+func (rpcapi *RPCAPI) RepairState(ctx context.Context, in struct{}, out *api.StateRepair) error {
+	res, err := rpcapi.c.RepairState()
+	*out = res
+	return err
+}
+
+// SharedConfig runs Cluster.SharedConfig().
+func (rpcapi *RPCAPI) SharedConfig(ctx context.Context, in struct{}, out *api.SharedConfigSerial) error {
+	cfg, err := rpcapi.c.SharedConfig()
+	*out = cfg.ToSerial()
+	return err
+}
+
+// SetSharedConfig runs Cluster.SetSharedConfig().
+func (rpcapi *RPCAPI) SetSharedConfig(ctx context.Context, in api.SharedConfigUpdateSerial, out *struct{}) error {
+	return rpcapi.c.SetSharedConfig(in.ToSharedConfigUpdate())
+}
+
+// InjectFaults runs Cluster.InjectFaults().
+func (rpcapi *RPCAPI) InjectFaults(ctx context.Context, in api.FaultsSerial, out *struct{}) error {
+	return rpcapi.c.InjectFaults(in.ToFaults())
+}
+
+// Faults runs Cluster.Faults().
+func (rpcapi *RPCAPI) Faults(ctx context.Context, in struct{}, out *api.FaultsSerial) error {
+	*out = rpcapi.c.Faults().ToSerial()
+	return nil
+}
+
+// Pause runs Cluster.Pause().
+func (rpcapi *RPCAPI) Pause(ctx context.Context, in struct{}, out *struct{}) error {
+	return rpcapi.c.Pause()
+}
+
+// Resume runs Cluster.Resume().
+func (rpcapi *RPCAPI) Resume(ctx context.Context, in struct{}, out *struct{}) error {
+	return rpcapi.c.Resume()
+}
+
+// ApplySharedConfig applies the local changes needed after the
+// shared configuration has been modified.
+func (rpcapi *RPCAPI) ApplySharedConfig(ctx context.Context, in api.SharedConfigSerial, out *struct{}) error {
+	rpcapi.c.applySharedConfig(in.ToSharedConfig())
+	return nil
+}
+
+// RotateSecret runs Cluster.RotateSecret().
+func (rpcapi *RPCAPI) RotateSecret(ctx context.Context, in string, out *api.SecretRotationSerial) error {
+	var secret []byte
+	if in != "" {
+		s, err := DecodeClusterSecret(in)
+		if err != nil {
+			return err
+		}
+		secret = s
+	}
+	res, err := rpcapi.c.RotateSecret(secret)
+	*out = res.ToSerial()
+	return err
+}
+
+// PrepareSecretRotation runs Cluster.prepareSecretRotation().
+func (rpcapi *RPCAPI) PrepareSecretRotation(ctx context.Context, in string, out *struct{}) error {
+	return rpcapi.c.prepareSecretRotation(in)
+}
+
+// CommitSecretRotation runs Cluster.commitSecretRotation().
+func (rpcapi *RPCAPI) CommitSecretRotation(ctx context.Context, in string, out *struct{}) error {
+	return rpcapi.c.commitSecretRotation(in)
+}
+
+// AbortSecretRotation runs Cluster.abortSecretRotation().
+func (rpcapi *RPCAPI) AbortSecretRotation(ctx context.Context, in string, out *struct{}) error {
+	return rpcapi.c.abortSecretRotation(in)
+}
+
+// RPCStats runs Cluster.RPCStats().
+func (rpcapi *RPCAPI) RPCStats(ctx context.Context, in struct{}, out *[]api.RPCCallStatsSerial) error {
+	stats := rpcapi.c.RPCStats()
+	statsSerial := make([]api.RPCCallStatsSerial, len(stats), len(stats))
+	for i, st := range stats {
+		statsSerial[i] = st.ToSerial()
+	}
+	*out = statsSerial
+	return nil
+}
+
 // PeerRemove runs Cluster.PeerRm().
-func (rpcapi *RPCAPI) PeerRemove(ctx context.Context, in peer.ID, out *struct{}) error {
-	return rpcapi.c.PeerRemove(in)
+func (rpcapi *RPCAPI) PeerRemove(ctx context.Context, in peer.ID, out *api.PeerRemoval) error {
+	res, err := rpcapi.c.PeerRemove(in)
+	*out = res
+	return err
+}
+
+// PeerRemoveForce runs Cluster.PeerRemoveForce().
+func (rpcapi *RPCAPI) PeerRemoveForce(ctx context.Context, in peer.ID, out *api.PeerRemoval) error {
+	res, err := rpcapi.c.PeerRemoveForce(in)
+	*out = res
+	return err
+}
+
+// PeerstoreAddresses runs Cluster.PeerstoreAddresses().
+func (rpcapi *RPCAPI) PeerstoreAddresses(ctx context.Context, in struct{}, out *api.MultiaddrsSerial) error {
+	addrs, err := rpcapi.c.PeerstoreAddresses()
+	*out = api.MultiaddrsToSerial(addrs)
+	return err
+}
+
+// PeerstoreAdd runs Cluster.PeerstoreAdd().
+func (rpcapi *RPCAPI) PeerstoreAdd(ctx context.Context, in api.MultiaddrSerial, out *struct{}) error {
+	return rpcapi.c.PeerstoreAdd(in.ToMultiaddr())
+}
+
+// PeerstoreRemove runs Cluster.PeerstoreRemove().
+func (rpcapi *RPCAPI) PeerstoreRemove(ctx context.Context, in peer.ID, out *struct{}) error {
+	return rpcapi.c.PeerstoreRemove(in)
+}
+
+// Prefetch runs Cluster.Prefetch().
+func (rpcapi *RPCAPI) Prefetch(ctx context.Context, in api.PinSerial, out *[]peer.ID) error {
+	peers, err := rpcapi.c.Prefetch(in.ToPin())
+	*out = peers
+	return err
+}
+
+// PrefetchLocal runs Cluster.PrefetchLocal().
+func (rpcapi *RPCAPI) PrefetchLocal(ctx context.Context, in api.PinSerial, out *struct{}) error {
+	return rpcapi.c.PrefetchLocal(in.ToPin().Cid)
+}
+
+// PinsetChanges runs Cluster.PinsetChanges().
+func (rpcapi *RPCAPI) PinsetChanges(ctx context.Context, in api.PinsetChangesRequest, out *api.PinsetChangesSerial) error {
+	chs, err := rpcapi.c.PinsetChanges(in.Epoch, in.Since, in.Limit, in.Wait)
+	*out = chs.ToSerial()
+	return err
+}
+
+// PeerBlacklist runs Cluster.PeerBlacklist().
+func (rpcapi *RPCAPI) PeerBlacklist(ctx context.Context, in struct{}, out *[]peer.ID) error {
+	*out = rpcapi.c.PeerBlacklist()
+	return nil
+}
+
+// PeerBlacklistAdd runs Cluster.PeerBlacklistAdd().
+func (rpcapi *RPCAPI) PeerBlacklistAdd(ctx context.Context, in peer.ID, out *struct{}) error {
+	return rpcapi.c.PeerBlacklistAdd(in)
+}
+
+// PeerBlacklistRemove runs Cluster.PeerBlacklistRemove().
+func (rpcapi *RPCAPI) PeerBlacklistRemove(ctx context.Context, in peer.ID, out *struct{}) error {
+	return rpcapi.c.PeerBlacklistRemove(in)
+}
+
+// Peerset runs Cluster.Peerset().
+func (rpcapi *RPCAPI) Peerset(ctx context.Context, in struct{}, out *api.MultiaddrsSerial) error {
+	addrs, err := rpcapi.c.Peerset()
+	*out = api.MultiaddrsToSerial(addrs)
+	return err
 }
 
 // Join runs Cluster.Join().
@@ -116,6 +392,13 @@ func (rpcapi *RPCAPI) StatusAll(ctx context.Context, in struct{}, out *[]api.Glo
 	return err
 }
 
+// StatusAllPartial runs Cluster.StatusAllPartial().
+func (rpcapi *RPCAPI) StatusAllPartial(ctx context.Context, in time.Duration, out *[]api.GlobalPinInfoSerial) error {
+	pinfos, err := rpcapi.c.StatusAllPartial(in)
+	*out = GlobalPinInfoSliceToSerial(pinfos)
+	return err
+}
+
 // StatusAllLocal runs Cluster.StatusAllLocal().
 func (rpcapi *RPCAPI) StatusAllLocal(ctx context.Context, in struct{}, out *[]api.PinInfoSerial) error {
 	pinfos := rpcapi.c.StatusAllLocal()
@@ -123,6 +406,66 @@ func (rpcapi *RPCAPI) StatusAllLocal(ctx context.Context, in struct{}, out *[]ap
 	return nil
 }
 
+// StatusShard runs Cluster.StatusShard().
+func (rpcapi *RPCAPI) StatusShard(ctx context.Context, in api.PinShard, out *[]api.GlobalPinInfoSerial) error {
+	pinfos, err := rpcapi.c.StatusShard(in)
+	*out = GlobalPinInfoSliceToSerial(pinfos)
+	return err
+}
+
+// StatusShardLocal runs Cluster.StatusShardLocal().
+func (rpcapi *RPCAPI) StatusShardLocal(ctx context.Context, in api.PinShard, out *[]api.PinInfoSerial) error {
+	pinfos, err := rpcapi.c.StatusShardLocal(in)
+	*out = pinInfoSliceToSerial(pinfos)
+	return err
+}
+
+// StatusSummary runs Cluster.StatusSummary().
+func (rpcapi *RPCAPI) StatusSummary(ctx context.Context, in struct{}, out *[]api.StatusSummarySerial) error {
+	sums, err := rpcapi.c.StatusSummary()
+	sumsSerial := make([]api.StatusSummarySerial, len(sums), len(sums))
+	for i, s := range sums {
+		sumsSerial[i] = s.ToSerial()
+	}
+	*out = sumsSerial
+	return err
+}
+
+// StatusSummaryLocal runs Cluster.StatusSummaryLocal().
+func (rpcapi *RPCAPI) StatusSummaryLocal(ctx context.Context, in struct{}, out *api.StatusSummarySerial) error {
+	*out = rpcapi.c.StatusSummaryLocal().ToSerial()
+	return nil
+}
+
+// FailureDomains runs Cluster.FailureDomains().
+func (rpcapi *RPCAPI) FailureDomains(ctx context.Context, in string, out *[]api.FailureDomainInfoSerial) error {
+	infos, err := rpcapi.c.FailureDomains(in)
+	infosSerial := make([]api.FailureDomainInfoSerial, len(infos), len(infos))
+	for i, info := range infos {
+		infosSerial[i] = info.ToSerial()
+	}
+	*out = infosSerial
+	return err
+}
+
+// OrphanPins runs Cluster.OrphanPins().
+func (rpcapi *RPCAPI) OrphanPins(ctx context.Context, in api.OrphanAction, out *[]api.OrphanPinsSerial) error {
+	res, err := rpcapi.c.OrphanPins(in)
+	resSerial := make([]api.OrphanPinsSerial, len(res), len(res))
+	for i, r := range res {
+		resSerial[i] = r.ToSerial()
+	}
+	*out = resSerial
+	return err
+}
+
+// OrphanPinsLocal runs Cluster.OrphanPinsLocal().
+func (rpcapi *RPCAPI) OrphanPinsLocal(ctx context.Context, in api.OrphanAction, out *api.OrphanPinsSerial) error {
+	res, err := rpcapi.c.OrphanPinsLocal(in)
+	*out = res.ToSerial()
+	return err
+}
+
 // Status runs Cluster.Status().
 func (rpcapi *RPCAPI) Status(ctx context.Context, in api.PinSerial, out *api.GlobalPinInfoSerial) error {
 	c := in.ToPin().Cid
@@ -131,6 +474,30 @@ func (rpcapi *RPCAPI) Status(ctx context.Context, in api.PinSerial, out *api.Glo
 	return err
 }
 
+// PinDetail runs Cluster.PinDetail().
+func (rpcapi *RPCAPI) PinDetail(ctx context.Context, in api.PinSerial, out *api.PinDetailSerial) error {
+	c := in.ToPin().Cid
+	detail, err := rpcapi.c.PinDetail(c)
+	*out = detail.ToSerial()
+	return err
+}
+
+// DAGStats runs Cluster.DAGStats().
+func (rpcapi *RPCAPI) DAGStats(ctx context.Context, in api.PinSerial, out *api.DAGStatsSerial) error {
+	c := in.ToPin().Cid
+	stats, err := rpcapi.c.DAGStats(c)
+	*out = stats.ToSerial()
+	return err
+}
+
+// StatusPartial runs Cluster.StatusPartial().
+func (rpcapi *RPCAPI) StatusPartial(ctx context.Context, in api.StatusRequestSerial, out *api.GlobalPinInfoSerial) error {
+	c := in.Pin.ToPin().Cid
+	pinfo, err := rpcapi.c.StatusPartial(c, in.Timeout)
+	*out = pinfo.ToSerial()
+	return err
+}
+
 // StatusLocal runs Cluster.StatusLocal().
 func (rpcapi *RPCAPI) StatusLocal(ctx context.Context, in api.PinSerial, out *api.PinInfoSerial) error {
 	c := in.ToPin().Cid
@@ -169,6 +536,43 @@ func (rpcapi *RPCAPI) SyncLocal(ctx context.Context, in api.PinSerial, out *api.
 	return err
 }
 
+// RecoverAll runs Cluster.RecoverAll().
+func (rpcapi *RPCAPI) RecoverAll(ctx context.Context, in api.RecoverAllRequest, out *[]api.GlobalPinInfoSerial) error {
+	pinfos, err := rpcapi.c.RecoverAll(in.Filter, in.Concurrency)
+	*out = GlobalPinInfoSliceToSerial(pinfos)
+	return err
+}
+
+// StartJob runs Cluster.StartJob().
+func (rpcapi *RPCAPI) StartJob(ctx context.Context, in api.JobRequest, out *api.JobSerial) error {
+	job, err := rpcapi.c.StartJob(in)
+	*out = job.ToSerial()
+	return err
+}
+
+// Job runs Cluster.Job().
+func (rpcapi *RPCAPI) Job(ctx context.Context, in string, out *api.JobSerial) error {
+	job, err := rpcapi.c.Job(in)
+	*out = job.ToSerial()
+	return err
+}
+
+// Jobs runs Cluster.Jobs().
+func (rpcapi *RPCAPI) Jobs(ctx context.Context, in struct{}, out *[]api.JobSerial) error {
+	jobs := rpcapi.c.Jobs()
+	serials := make([]api.JobSerial, len(jobs), len(jobs))
+	for i, j := range jobs {
+		serials[i] = j.ToSerial()
+	}
+	*out = serials
+	return nil
+}
+
+// CancelJob runs Cluster.CancelJob().
+func (rpcapi *RPCAPI) CancelJob(ctx context.Context, in string, out *struct{}) error {
+	return rpcapi.c.CancelJob(in)
+}
+
 // RecoverAllLocal runs Cluster.RecoverAllLocal().
 func (rpcapi *RPCAPI) RecoverAllLocal(ctx context.Context, in struct{}, out *[]api.PinInfoSerial) error {
 	pinfos, err := rpcapi.c.RecoverAllLocal()
@@ -196,9 +600,10 @@ func (rpcapi *RPCAPI) RecoverLocal(ctx context.Context, in api.PinSerial, out *a
    Tracker component methods
 */
 
-// Track runs PinTracker.Track().
+// Track runs Cluster.track(), which refuses blocklisted items before
+// calling PinTracker.Track().
 func (rpcapi *RPCAPI) Track(ctx context.Context, in api.PinSerial, out *struct{}) error {
-	return rpcapi.c.tracker.Track(in.ToPin())
+	return rpcapi.c.track(in.ToPin())
 }
 
 // Untrack runs PinTracker.Untrack().
@@ -242,19 +647,33 @@ func (rpcapi *RPCAPI) TrackerRecover(ctx context.Context, in api.PinSerial, out
 
 // IPFSPin runs IPFSConnector.Pin().
 func (rpcapi *RPCAPI) IPFSPin(ctx context.Context, in api.PinSerial, out *struct{}) error {
+	if err := rpcapi.c.faults.ipfsError(); err != nil {
+		return err
+	}
 	c := in.ToPin().Cid
 	r := in.ToPin().Recursive
+	// Items tracked before being blocklisted must not be pinned
+	// when the tracker retries them.
+	if rpcapi.c.blocklist.has(c) {
+		return errBlocklisted(c)
+	}
 	return rpcapi.c.ipfs.Pin(ctx, c, r)
 }
 
 // IPFSUnpin runs IPFSConnector.Unpin().
 func (rpcapi *RPCAPI) IPFSUnpin(ctx context.Context, in api.PinSerial, out *struct{}) error {
+	if err := rpcapi.c.faults.ipfsError(); err != nil {
+		return err
+	}
 	c := in.ToPin().Cid
 	return rpcapi.c.ipfs.Unpin(ctx, c)
 }
 
 // IPFSPinLsCid runs IPFSConnector.PinLsCid().
 func (rpcapi *RPCAPI) IPFSPinLsCid(ctx context.Context, in api.PinSerial, out *api.IPFSPinStatus) error {
+	if err := rpcapi.c.faults.ipfsError(); err != nil {
+		return err
+	}
 	c := in.ToPin().Cid
 	b, err := rpcapi.c.ipfs.PinLsCid(ctx, c)
 	*out = b
@@ -263,6 +682,9 @@ func (rpcapi *RPCAPI) IPFSPinLsCid(ctx context.Context, in api.PinSerial, out *a
 
 // IPFSPinLs runs IPFSConnector.PinLs().
 func (rpcapi *RPCAPI) IPFSPinLs(ctx context.Context, in string, out *map[string]api.IPFSPinStatus) error {
+	if err := rpcapi.c.faults.ipfsError(); err != nil {
+		return err
+	}
 	m, err := rpcapi.c.ipfs.PinLs(ctx, in)
 	*out = m
 	return err
@@ -295,6 +717,27 @@ func (rpcapi *RPCAPI) IPFSRepoSize(ctx context.Context, in struct{}, out *uint64
 	return err
 }
 
+// IPFSCumulativeSize runs IPFSConnector.CumulativeSize().
+func (rpcapi *RPCAPI) IPFSCumulativeSize(ctx context.Context, in api.PinSerial, out *uint64) error {
+	res, err := rpcapi.c.ipfs.CumulativeSize(in.ToPin().Cid)
+	*out = res
+	return err
+}
+
+// IPFSBlockSize runs IPFSConnector.BlockSize().
+func (rpcapi *RPCAPI) IPFSBlockSize(ctx context.Context, in api.PinSerial, out *uint64) error {
+	res, err := rpcapi.c.ipfs.BlockSize(in.ToPin().Cid)
+	*out = res
+	return err
+}
+
+// IPFSDAGStats runs IPFSConnector.DAGStats().
+func (rpcapi *RPCAPI) IPFSDAGStats(ctx context.Context, in api.PinSerial, out *api.DAGStatsSerial) error {
+	res, err := rpcapi.c.ipfs.DAGStats(in.ToPin().Cid)
+	*out = res.ToSerial()
+	return err
+}
+
 // IPFSSwarmPeers runs IPFSConnector.SwarmPeers().
 func (rpcapi *RPCAPI) IPFSSwarmPeers(ctx context.Context, in struct{}, out *api.SwarmPeersSerial) error {
 	res, err := rpcapi.c.ipfs.SwarmPeers()
@@ -309,17 +752,31 @@ func (rpcapi *RPCAPI) IPFSSwarmPeers(ctx context.Context, in struct{}, out *api.
 // ConsensusLogPin runs Consensus.LogPin().
 func (rpcapi *RPCAPI) ConsensusLogPin(ctx context.Context, in api.PinSerial, out *struct{}) error {
 	c := in.ToPin()
+	if err := rpcapi.c.verifyCommit(api.PinOpPin, c); err != nil {
+		return err
+	}
 	return rpcapi.c.consensus.LogPin(c)
 }
 
 // ConsensusLogUnpin runs Consensus.LogUnpin().
 func (rpcapi *RPCAPI) ConsensusLogUnpin(ctx context.Context, in api.PinSerial, out *struct{}) error {
 	c := in.ToPin()
+	if err := rpcapi.c.verifyCommit(api.PinOpUnpin, c); err != nil {
+		return err
+	}
 	return rpcapi.c.consensus.LogUnpin(c)
 }
 
+// ConsensusLogSharedConfig runs Consensus.LogSharedConfig().
+func (rpcapi *RPCAPI) ConsensusLogSharedConfig(ctx context.Context, in api.SharedConfigUpdateSerial, out *struct{}) error {
+	return rpcapi.c.consensus.LogSharedConfig(in.ToSharedConfigUpdate())
+}
+
 // ConsensusAddPeer runs Consensus.AddPeer().
 func (rpcapi *RPCAPI) ConsensusAddPeer(ctx context.Context, in peer.ID, out *struct{}) error {
+	if !rpcapi.c.acceptPeer(in) {
+		return errPeerBlacklisted(in)
+	}
 	return rpcapi.c.consensus.AddPeer(in)
 }
 
@@ -328,6 +785,11 @@ func (rpcapi *RPCAPI) ConsensusRmPeer(ctx context.Context, in peer.ID, out *stru
 	return rpcapi.c.consensus.RmPeer(in)
 }
 
+// ConsensusTransferLeadership runs Consensus.TransferLeadership().
+func (rpcapi *RPCAPI) ConsensusTransferLeadership(ctx context.Context, in peer.ID, out *struct{}) error {
+	return rpcapi.c.TransferLeadership(in)
+}
+
 // ConsensusPeers runs Consensus.Peers().
 func (rpcapi *RPCAPI) ConsensusPeers(ctx context.Context, in struct{}, out *[]peer.ID) error {
 	peers, err := rpcapi.c.consensus.Peers()
@@ -342,7 +804,11 @@ func (rpcapi *RPCAPI) ConsensusPeers(ctx context.Context, in struct{}, out *[]pe
 // PeerManagerAddPeer runs peerManager.addPeer().
 func (rpcapi *RPCAPI) PeerManagerAddPeer(ctx context.Context, in api.MultiaddrSerial, out *struct{}) error {
 	addr := in.ToMultiaddr()
-	err := rpcapi.c.peerManager.ImportPeer(addr, false)
+	pid, _, err := api.Libp2pMultiaddrSplit(addr)
+	if err == nil && !rpcapi.c.acceptPeer(pid) {
+		return errPeerBlacklisted(pid)
+	}
+	err = rpcapi.c.peerManager.ImportPeer(addr, false)
 	return err
 }
 
@@ -0,0 +1,150 @@
+package ipfscluster
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	host "github.com/libp2p/go-libp2p-host"
+	inet "github.com/libp2p/go-libp2p-net"
+	peer "github.com/libp2p/go-libp2p-peer"
+	protocol "github.com/libp2p/go-libp2p-protocol"
+
+	"github.com/ipfs/ipfs-cluster/api"
+)
+
+// rpcServiceID mirrors the go-libp2p-gorpc ServiceID, which is the first
+// object sent by RPC clients on every request.
+type rpcServiceID struct {
+	Name   string
+	Method string
+}
+
+type rpcCallKey struct {
+	peer   peer.ID
+	method string
+}
+
+// rpcAudit keeps track of the RPC calls received from other peers.
+type rpcAudit struct {
+	logger   *instanceLogger
+	logCalls bool
+
+	mux   sync.Mutex
+	stats map[rpcCallKey]*api.RPCCallStats
+}
+
+func newRPCAudit(log *instanceLogger, logCalls bool) *rpcAudit {
+	return &rpcAudit{
+		logger:   log,
+		logCalls: logCalls,
+		stats:    make(map[rpcCallKey]*api.RPCCallStats),
+	}
+}
+
+func (a *rpcAudit) record(p peer.ID, method string) {
+	now := time.Now()
+	if a.logCalls {
+		a.logger.Infof("RPC call from %s: %s", p.Pretty(), method)
+	}
+
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	key := rpcCallKey{p, method}
+	st, ok := a.stats[key]
+	if !ok {
+		st = &api.RPCCallStats{
+			Peer:   p,
+			Method: method,
+		}
+		a.stats[key] = st
+	}
+	st.Count++
+	st.Last = now
+}
+
+// list returns the recorded statistics sorted by peer and method.
+func (a *rpcAudit) list() []api.RPCCallStats {
+	a.mux.Lock()
+	stats := make([]api.RPCCallStats, 0, len(a.stats))
+	for _, st := range a.stats {
+		stats = append(stats, *st)
+	}
+	a.mux.Unlock()
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Peer != stats[j].Peer {
+			return stats[i].Peer < stats[j].Peer
+		}
+		return stats[i].Method < stats[j].Method
+	})
+	return stats
+}
+
+// auditHost wraps the stream handlers set by the RPC server so that
+// every incoming request is recorded by the rpcAudit. Requests for
+// which authorize returns false are rejected. Requests are held for the
+// time returned by delay, if set (see Cluster.InjectFaults).
+type auditHost struct {
+	host.Host
+	audit     *rpcAudit
+	authorize func(p peer.ID, method string) bool
+	delay     func() time.Duration
+}
+
+func (h *auditHost) SetStreamHandler(pid protocol.ID, handler inet.StreamHandler) {
+	h.Host.SetStreamHandler(pid, func(s inet.Stream) {
+		as, method := peekRPCMethod(s)
+		remote := s.Conn().RemotePeer()
+		h.audit.record(remote, method)
+		if h.authorize != nil && !h.authorize(remote, method) {
+			h.audit.logger.Warningf("rejected %s request from %s", method, remote.Pretty())
+			s.Reset()
+			return
+		}
+		if h.delay != nil {
+			if d := h.delay(); d > 0 {
+				time.Sleep(d)
+			}
+		}
+		handler(as)
+	})
+}
+
+// auditStream is a stream which first returns the bytes that were
+// already read from the original stream.
+type auditStream struct {
+	inet.Stream
+	r io.Reader
+}
+
+func (s *auditStream) Read(p []byte) (int, error) {
+	return s.r.Read(p)
+}
+
+// peekRPCMethod decodes the service and method name of the RPC request
+// sent on the given stream. It returns a stream which can be read from
+// the start, as the original one.
+func peekRPCMethod(s inet.Stream) (inet.Stream, string) {
+	var buf bytes.Buffer
+	var svcID rpcServiceID
+	method := "unknown"
+	err := gob.NewDecoder(io.TeeReader(s, &buf)).Decode(&svcID)
+	if err == nil {
+		method = svcID.Name + "." + svcID.Method
+	}
+	return &auditStream{
+		Stream: s,
+		r:      io.MultiReader(&buf, s),
+	}, method
+}
+
+// RPCStats returns how many times, and when last, every other peer has
+// called each of the RPC methods offered by this peer. This allows to
+// trace peers issuing excessive or unexpected requests.
+func (c *Cluster) RPCStats() []api.RPCCallStats {
+	return c.rpcAudit.list()
+}
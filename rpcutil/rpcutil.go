@@ -110,6 +110,39 @@ func CopyPinInfoSerialSliceToIfaces(in [][]api.PinInfoSerial) []interface{} {
 	return ifaces
 }
 
+// CopyConsensusStateSerialToIfaces converts an api.ConsensusStateSerial
+// slice to an empty interface slice using pointers to each elements of the
+// original slice. Useful to handle gorpc.MultiCall() replies.
+func CopyConsensusStateSerialToIfaces(in []api.ConsensusStateSerial) []interface{} {
+	ifaces := make([]interface{}, len(in), len(in))
+	for i := range in {
+		ifaces[i] = &in[i]
+	}
+	return ifaces
+}
+
+// CopyStatusSummarySerialToIfaces converts an api.StatusSummarySerial
+// slice to an empty interface slice using pointers to each elements of the
+// original slice. Useful to handle gorpc.MultiCall() replies.
+func CopyStatusSummarySerialToIfaces(in []api.StatusSummarySerial) []interface{} {
+	ifaces := make([]interface{}, len(in), len(in))
+	for i := range in {
+		ifaces[i] = &in[i]
+	}
+	return ifaces
+}
+
+// CopyOrphanPinsSerialToIfaces converts an api.OrphanPinsSerial
+// slice to an empty interface slice using pointers to each elements of the
+// original slice. Useful to handle gorpc.MultiCall() replies.
+func CopyOrphanPinsSerialToIfaces(in []api.OrphanPinsSerial) []interface{} {
+	ifaces := make([]interface{}, len(in), len(in))
+	for i := range in {
+		ifaces[i] = &in[i]
+	}
+	return ifaces
+}
+
 // CopyEmptyStructToIfaces converts an empty struct slice to an empty interface
 // slice using pointers to each elements of the original slice.
 // Useful to handle gorpc.MultiCall() replies.
@@ -0,0 +1,167 @@
+package ipfscluster
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+	pnet "github.com/libp2p/go-libp2p-pnet"
+
+	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/rpcutil"
+)
+
+// How long we wait for every peer to answer during each
+// of the phases of a secret rotation.
+var secretRotationTimeout = 30 * time.Second
+
+var errNoPendingSecret = errors.New("no matching secret has been prepared for rotation")
+
+// RotateSecret replaces the cluster secret used for the private network
+// in every peer of the cluster. When secret is empty, a new random one is
+// generated.
+//
+// The rotation happens in two phases. First, the new secret is sent to
+// all peers (over the current private network), which keep it aside. If
+// any peer fails to receive it, the rotation is aborted everywhere and the
+// error is returned. Otherwise, every peer is told to switch to the new
+// secret: it starts protecting new connections with it right away and
+// writes it to its configuration. Peers failing at this point are
+// reported in the result. Connections established before the switch are
+// kept, so the cluster keeps working while every peer switches.
+//
+// Peers started without a secret do not run a private network and cannot
+// switch to one while running. They store the new secret and use it after
+// a restart.
+func (c *Cluster) RotateSecret(secret []byte) (api.SecretRotation, error) {
+	result := api.SecretRotation{
+		Failed: make(map[peer.ID]string),
+	}
+
+	if len(secret) == 0 {
+		s, err := pnet.GenerateV1Bytes()
+		if err != nil {
+			return result, err
+		}
+		secret = (*s)[:]
+	}
+	if len(secret) != 32 {
+		return result, fmt.Errorf("the new secret is %d bytes, cluster secret should be 32", len(secret))
+	}
+
+	members, err := c.consensus.Peers()
+	if err != nil {
+		return result, err
+	}
+
+	hexSecret := EncodeProtectorKey(secret)
+
+	errs := c.multiCallSecret(members, "PrepareSecretRotation", hexSecret)
+	for i, err := range errs {
+		if err != nil {
+			result.Failed[members[i]] = err.Error()
+		}
+	}
+	if len(result.Failed) > 0 {
+		c.logger.Error("secret rotation aborted: some peers could not receive the new secret")
+		c.multiCallSecret(members, "AbortSecretRotation", hexSecret)
+		return result, errors.New("secret rotation aborted: some peers could not receive the new secret")
+	}
+
+	errs = c.multiCallSecret(members, "CommitSecretRotation", hexSecret)
+	for i, err := range errs {
+		if err != nil {
+			c.logger.Errorf("%s failed to switch to the new secret: %s", members[i].Pretty(), err)
+			result.Failed[members[i]] = err.Error()
+			continue
+		}
+		result.Updated = append(result.Updated, members[i])
+	}
+	c.logger.Infof(
+		"cluster secret rotated in %d peers (%d failed)",
+		len(result.Updated),
+		len(result.Failed),
+	)
+	return result, nil
+}
+
+func (c *Cluster) multiCallSecret(members []peer.ID, method, hexSecret string) []error {
+	ctxs, cancels := rpcutil.CtxsWithTimeout(c.ctx, len(members), secretRotationTimeout)
+	defer rpcutil.MultiCancel(cancels)
+
+	return c.rpcClient.MultiCall(
+		ctxs,
+		members,
+		"Cluster",
+		method,
+		hexSecret,
+		rpcutil.RPCDiscardReplies(len(members)),
+	)
+}
+
+// prepareSecretRotation keeps the given secret aside, until it is
+// committed or the rotation is aborted.
+func (c *Cluster) prepareSecretRotation(hexSecret string) error {
+	secret, err := DecodeClusterSecret(hexSecret)
+	if err != nil {
+		return err
+	}
+	if len(secret) == 0 {
+		return errors.New("cannot rotate to an empty secret")
+	}
+
+	c.secretMux.Lock()
+	defer c.secretMux.Unlock()
+	c.pendingSecret = secret
+	return nil
+}
+
+// abortSecretRotation forgets the given secret if it was prepared.
+func (c *Cluster) abortSecretRotation(hexSecret string) error {
+	secret, err := DecodeClusterSecret(hexSecret)
+	if err != nil {
+		return err
+	}
+
+	c.secretMux.Lock()
+	defer c.secretMux.Unlock()
+	if bytes.Equal(c.pendingSecret, secret) {
+		c.pendingSecret = nil
+	}
+	return nil
+}
+
+// commitSecretRotation switches the private network to a previously
+// prepared secret and saves it in the configuration.
+func (c *Cluster) commitSecretRotation(hexSecret string) error {
+	secret, err := DecodeClusterSecret(hexSecret)
+	if err != nil {
+		return err
+	}
+
+	c.secretMux.Lock()
+	defer c.secretMux.Unlock()
+	if c.pendingSecret == nil || !bytes.Equal(c.pendingSecret, secret) {
+		return errNoPendingSecret
+	}
+
+	if c.config.protector == nil {
+		c.config.Secret = secret
+		c.config.NotifySave()
+		c.pendingSecret = nil
+		c.logger.Warning("this peer runs without a private network. The new secret will be used after restarting it")
+		return nil
+	}
+
+	err = c.config.protector.setSecret(secret)
+	if err != nil {
+		return err
+	}
+	c.config.Secret = secret
+	c.config.NotifySave()
+	c.pendingSecret = nil
+	c.logger.Info("the cluster secret has been rotated")
+	return nil
+}
@@ -0,0 +1,170 @@
+package ipfscluster
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ipfs/ipfs-cluster/allocator/ascendalloc"
+	"github.com/ipfs/ipfs-cluster/allocator/descendalloc"
+	"github.com/ipfs/ipfs-cluster/allocator/rendezvousalloc"
+	"github.com/ipfs/ipfs-cluster/api"
+)
+
+// Allocators which can be selected with SharedConfig.Allocator.
+const (
+	SharedAllocatorAscend     = "ascend"
+	SharedAllocatorDescend    = "descend"
+	SharedAllocatorRendezvous = "rendezvous"
+)
+
+// SharedConfig returns the cluster-wide configuration stored in the shared
+// state. Settings which are not set (zero values) are taken from the
+// local configuration of every peer.
+func (c *Cluster) SharedConfig() (api.SharedConfig, error) {
+	st, err := c.consensus.State()
+	if err != nil {
+		return api.SharedConfig{}, err
+	}
+	return st.SharedConfig(), nil
+}
+
+// SetSharedConfig modifies the settings named in the update in the
+// cluster-wide configuration stored in the shared state, keeping the
+// others (see api.SharedConfigUpdate). All peers start using the new
+// settings as soon as the operation is applied to their state: the
+// replication factors apply to new pins, the allocator to new allocations
+// and the state sync interval to the next StateSync() of every peer.
+func (c *Cluster) SetSharedConfig(update api.SharedConfigUpdate) error {
+	for _, f := range update.Fields {
+		if !isSharedConfigField(f) {
+			return fmt.Errorf("unknown shared configuration setting %q", f)
+		}
+	}
+	current, err := c.SharedConfig()
+	if err != nil {
+		return err
+	}
+	if err := validateSharedConfig(current.Merge(update)); err != nil {
+		return err
+	}
+	return c.consensus.LogSharedConfig(update)
+}
+
+func isSharedConfigField(f string) bool {
+	for _, name := range api.SharedConfigFields {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Pause makes all cluster peers stop performing new IPFS pin and unpin
+// operations, i.e. during IPFS daemon upgrades. Pins and unpins are still
+// added to the shared state, and the pending operations are performed
+// once the cluster is resumed. The paused status is stored in the shared
+// configuration, so peers which are restarted stay paused.
+func (c *Cluster) Pause() error {
+	return c.setPaused(true)
+}
+
+// Resume lets all cluster peers perform IPFS pin
+// and unpin operations again after Pause().
+func (c *Cluster) Resume() error {
+	return c.setPaused(false)
+}
+
+// setPaused only modifies the Paused setting. The update is merged
+// into the shared configuration when it is applied, so it cannot
+// overwrite concurrent changes of other settings, and it is skipped when
+// the cluster is already in the requested state.
+func (c *Cluster) setPaused(paused bool) error {
+	return c.SetSharedConfig(api.SharedConfigUpdate{
+		Config: api.SharedConfig{Paused: paused},
+		Fields: []string{api.SharedConfigPaused},
+	})
+}
+
+func validateSharedConfig(cfg api.SharedConfig) error {
+	if cfg.ReplicationFactorMin != 0 || cfg.ReplicationFactorMax != 0 {
+		if err := isReplicationFactorValid(cfg.ReplicationFactorMin, cfg.ReplicationFactorMax); err != nil {
+			return err
+		}
+	}
+
+	switch cfg.Allocator {
+	case "", SharedAllocatorAscend, SharedAllocatorDescend, SharedAllocatorRendezvous:
+	default:
+		return fmt.Errorf("unknown allocator %q", cfg.Allocator)
+	}
+
+	if cfg.StateSyncInterval < 0 {
+		return errors.New("the state sync interval cannot be negative")
+	}
+	return nil
+}
+
+// applySharedConfig performs the changes needed in this peer when the
+// shared configuration is modified. Other settings are read when used.
+func (c *Cluster) applySharedConfig(cfg api.SharedConfig) {
+	p, ok := c.tracker.(Pauser)
+	if !ok {
+		if cfg.Paused {
+			c.logger.Warning("the pin tracker does not support being paused")
+		}
+		return
+	}
+	if cfg.Paused {
+		p.Pause()
+	} else {
+		p.Resume()
+	}
+}
+
+// sharedConfig returns the shared configuration, or an
+// empty one (meaning no overrides) when it cannot be read.
+func (c *Cluster) sharedConfig() api.SharedConfig {
+	cfg, err := c.SharedConfig()
+	if err != nil {
+		c.logger.Debugf("cannot read the shared configuration: %s", err)
+		return api.SharedConfig{}
+	}
+	return cfg
+}
+
+// defaultReplicationFactors returns the replication factors
+// used for pins which do not specify them.
+func (c *Cluster) defaultReplicationFactors() (int, int) {
+	shared := c.sharedConfig()
+	if shared.ReplicationFactorMin != 0 {
+		return shared.ReplicationFactorMin, shared.ReplicationFactorMax
+	}
+	return c.config.ReplicationFactorMin, c.config.ReplicationFactorMax
+}
+
+// stateSyncInterval returns how often StateSync() should be triggered.
+func (c *Cluster) stateSyncInterval() time.Duration {
+	if interval := c.sharedConfig().StateSyncInterval; interval > 0 {
+		return interval
+	}
+	return c.config.StateSyncInterval
+}
+
+// newSharedAllocators returns the allocators which can be selected
+// in the shared configuration, indexed by name.
+func newSharedAllocators() map[string]PinAllocator {
+	return map[string]PinAllocator{
+		SharedAllocatorAscend:     ascendalloc.NewAllocator(),
+		SharedAllocatorDescend:    descendalloc.NewAllocator(),
+		SharedAllocatorRendezvous: rendezvousalloc.NewAllocator(),
+	}
+}
+
+// pinAllocator returns the PinAllocator to use for new allocations.
+func (c *Cluster) pinAllocator() PinAllocator {
+	if alloc, ok := c.allocators[c.sharedConfig().Allocator]; ok {
+		return alloc
+	}
+	return c.allocator
+}
@@ -0,0 +1,122 @@
+package ipfscluster
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+
+	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/rpcutil"
+)
+
+// How long we wait for peers to report their consensus state
+var splitBrainCheckTimeout = 10 * time.Second
+
+// SplitBrain cross-checks the consensus state seen by every peer in our
+// peerset. It returns a description of every inconsistency found: peers
+// which see different leaders or which do not include this peer in their
+// peerset. An empty result means no split brain was detected. Peers which
+// cannot be contacted are ignored.
+func (c *Cluster) SplitBrain() ([]string, error) {
+	members, err := c.consensus.Peers()
+	if err != nil {
+		return nil, err
+	}
+
+	statesSerial := make([]api.ConsensusStateSerial, len(members), len(members))
+
+	ctxs, cancels := rpcutil.CtxsWithTimeout(c.ctx, len(members), splitBrainCheckTimeout)
+	defer rpcutil.MultiCancel(cancels)
+
+	errs := c.rpcClient.MultiCall(
+		ctxs,
+		members,
+		"Cluster",
+		"ConsensusState",
+		struct{}{},
+		rpcutil.CopyConsensusStateSerialToIfaces(statesSerial),
+	)
+
+	states := make([]api.ConsensusState, len(members), len(members))
+	for i, s := range statesSerial {
+		if errs[i] != nil {
+			c.logger.Debugf("split brain check: %s: %s", members[i].Pretty(), errs[i])
+		}
+		states[i] = s.ToConsensusState()
+	}
+	return splitBrainProblems(c.id, members, states, errs), nil
+}
+
+// splitBrainProblems compares the consensus states reported by members
+// (ignoring those which errored) and describes any inconsistencies.
+func splitBrainProblems(self peer.ID, members []peer.ID, states []api.ConsensusState, errs []error) []string {
+	var problems []string
+	leaders := make(map[peer.ID][]string)
+
+	for i, cs := range states {
+		if errs[i] != nil {
+			continue
+		}
+		if cs.Leader != "" {
+			leaders[cs.Leader] = append(leaders[cs.Leader], members[i].Pretty())
+		}
+		if !containsPeer(cs.Voters, self) && !containsPeer(cs.NonVoters, self) {
+			problems = append(problems, fmt.Sprintf(
+				"%s does not have %s in its peerset",
+				members[i].Pretty(),
+				self.Pretty(),
+			))
+		}
+	}
+
+	if len(leaders) > 1 {
+		var views []string
+		for l, seenBy := range leaders {
+			sort.Strings(seenBy)
+			views = append(views, fmt.Sprintf(
+				"%s (seen by %s)",
+				l.Pretty(),
+				strings.Join(seenBy, ", "),
+			))
+		}
+		sort.Strings(views)
+		problems = append(problems, "peers see different leaders: "+strings.Join(views, "; "))
+	}
+	return problems
+}
+
+// watchSplitBrain runs SplitBrain() regularly and raises an alert when
+// inconsistencies are found in two consecutive checks (to skip
+// transient situations, like elections).
+func (c *Cluster) watchSplitBrain() {
+	ticker := time.NewTicker(c.config.SplitBrainCheckInterval)
+	defer ticker.Stop()
+	suspect := false
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			problems, err := c.SplitBrain()
+			if err != nil {
+				c.logger.Debug(err)
+				continue
+			}
+
+			if len(problems) > 0 && suspect {
+				c.logger.Error("***** ipfs-cluster split brain detected *****")
+				for _, p := range problems {
+					c.logger.Error(p)
+				}
+				c.logger.Error("Different parts of the cluster may be committing divergent pins.")
+				c.logger.Error("Check the connectivity among cluster peers and the consensus")
+				c.logger.Error("state of each of them (ipfs-cluster-ctl health consensus).")
+			}
+			suspect = len(problems) > 0
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package ipfscluster
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+
+	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/state"
+)
+
+// How often the startup check looks at the items being repaired
+var startupCheckPollInterval = 500 * time.Millisecond
+
+// How many times and how often the startup check tries to list the pins
+// in IPFS before giving up
+var (
+	startupCheckRetries    = 5
+	startupCheckRetryDelay = 2 * time.Second
+)
+
+// startupCheck compares every item allocated to this peer in the shared
+// state with its status in the tracker (as restored from the tracker
+// checkpoint by the first StateSync) and with the pins in the IPFS daemon.
+// Items which the tracker believes pinned but are missing from IPFS, or
+// pinned with the wrong type, are pinned again. Items in error which IPFS
+// has pinned are marked as pinned. Config.StartupCheckConcurrency items are
+// repaired at the same time and the check waits until the repairs finish.
+// It returns the number of items repaired and of those left with errors,
+// or an error when the pins in IPFS could not be listed after
+// startupCheckRetries attempts.
+func (c *Cluster) startupCheck(cState state.State) (repaired, failed int, err error) {
+	if cState.SharedConfig().Paused {
+		c.logger.Warning("startup check skipped: the cluster is paused")
+		return 0, 0, nil
+	}
+
+	ipfsPins, err := c.startupPinLs()
+	if err != nil {
+		// Do not report statuses which cannot be verified: SyncAll
+		// marks all items as errored.
+		c.tracker.SyncAll()
+		return 0, 0, fmt.Errorf("cannot list the pins in IPFS: %s", err)
+	}
+
+	var toRepair []api.Pin
+	for _, pin := range cState.List() {
+		if !containsPeer(pin.Allocations, c.id) && !pin.IsPinEverywhere() {
+			continue
+		}
+		st := c.tracker.Status(pin.Cid).Status
+		if needsRepair(pin, st, ipfsPins[pin.Cid.String()]) {
+			toRepair = append(toRepair, pin)
+		}
+	}
+	if len(toRepair) == 0 {
+		c.logger.Info("startup check: the pins in IPFS match the shared state")
+		return 0, 0, nil
+	}
+	c.logger.Warningf("startup check: repairing %d items", len(toRepair))
+
+	var mux sync.Mutex
+	sem := make(chan struct{}, c.config.StartupCheckConcurrency)
+	var wg sync.WaitGroup
+	for _, pin := range toRepair {
+		sem <- struct{}{}
+		if c.ctx.Err() != nil {
+			<-sem
+			break
+		}
+		wg.Add(1)
+		go func(pin api.Pin) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			st := c.repairItem(pin)
+			mux.Lock()
+			if st == api.TrackerStatusPinned {
+				repaired++
+			} else {
+				c.logger.Errorf("startup check: %s could not be repaired: %s", pin.Cid, st)
+				failed++
+			}
+			mux.Unlock()
+		}(pin)
+	}
+	wg.Wait()
+
+	c.logger.Infof("startup check: %d items repaired, %d failed", repaired, failed)
+	return repaired, failed, nil
+}
+
+// startupPinLs lists the pins in IPFS, retrying when it fails since the
+// IPFS daemon may still be starting.
+func (c *Cluster) startupPinLs() (map[string]api.IPFSPinStatus, error) {
+	var err error
+	for i := 0; i < startupCheckRetries; i++ {
+		if i > 0 {
+			c.logger.Warningf("startup check: cannot list the pins in IPFS (%s). Retrying in %s", err, startupCheckRetryDelay)
+			select {
+			case <-c.ctx.Done():
+				return nil, c.ctx.Err()
+			case <-time.After(startupCheckRetryDelay):
+			}
+		}
+		var ipfsPins map[string]api.IPFSPinStatus
+		ipfsPins, err = c.ipfsPinLs()
+		if err == nil {
+			return ipfsPins, nil
+		}
+	}
+	return nil, err
+}
+
+// needsRepair returns true when an item which should be pinned in this
+// peer has a tracker status which does not match the pin status in IPFS.
+func needsRepair(pin api.Pin, st api.TrackerStatus, ips api.IPFSPinStatus) bool {
+	switch st {
+	case api.TrackerStatusPinQueued, api.TrackerStatusPinning:
+		return false // being pinned already
+	case api.TrackerStatusPinned:
+		return !ips.IsPinned() ||
+			(pin.Recursive && ips == api.IPFSPinStatusDirect) ||
+			(!pin.Recursive && ips == api.IPFSPinStatusRecursive)
+	default:
+		return true
+	}
+}
+
+// repairItem syncs the tracker status of an item with IPFS and pins it
+// again when needed. It waits for the pin to finish and returns the
+// final tracker status.
+func (c *Cluster) repairItem(pin api.Pin) api.TrackerStatus {
+	pinfo, err := c.tracker.Sync(pin.Cid)
+	if err != nil {
+		return pinfo.Status
+	}
+
+	switch pinfo.Status {
+	case api.TrackerStatusPinned, api.TrackerStatusPinQueued, api.TrackerStatusPinning:
+	case api.TrackerStatusPinError:
+		c.logger.Debugf("startup check: pinning %s again", pin.Cid)
+		c.tracker.Recover(pin.Cid)
+	default:
+		c.logger.Debugf("startup check: tracking %s again", pin.Cid)
+		c.track(pin)
+	}
+	return c.waitPinned(pin.Cid)
+}
+
+// waitPinned waits until the given Cid is no longer queued or being
+// pinned by the tracker and returns its status.
+func (c *Cluster) waitPinned(h *cid.Cid) api.TrackerStatus {
+	ticker := time.NewTicker(startupCheckPollInterval)
+	defer ticker.Stop()
+	for {
+		st := c.tracker.Status(h).Status
+		if st != api.TrackerStatusPinQueued && st != api.TrackerStatusPinning {
+			return st
+		}
+		select {
+		case <-c.ctx.Done():
+			return st
+		case <-ticker.C:
+		}
+	}
+}
+
+// ipfsPinLs returns the recursive and direct pins in the IPFS daemon.
+func (c *Cluster) ipfsPinLs() (map[string]api.IPFSPinStatus, error) {
+	ipsMap := make(map[string]api.IPFSPinStatus)
+	for _, typeFilter := range []string{"recursive", "direct"} {
+		m, err := c.ipfs.PinLs(c.ctx, typeFilter)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range m {
+			ipsMap[k] = v
+		}
+	}
+	return ipsMap, nil
+}
@@ -0,0 +1,353 @@
+// Package boltstate implements the State interface for IPFS Cluster by
+// storing the consensus-shared state in a BoltDB file. Unlike mapstate,
+// the pinset is kept on disk, so that very large pinsets do not need to
+// fit in memory.
+package boltstate
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	cid "github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log"
+
+	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/state/mapstate"
+)
+
+// Version is the version of the serialized state. BoltState snapshots
+// use the mapstate format, so that peers using either implementation
+// can exchange them.
+const Version = mapstate.Version
+
+// DefaultFileName is the name of the database file, usually placed in
+// the consensus data folder.
+const DefaultFileName = "state.db"
+
+var logger = logging.Logger("boltstate")
+
+// errStopIteration ends a ForEach early.
+var errStopIteration = errors.New("iteration stopped")
+
+var (
+	pinsBucket = []byte("pins")
+	metaBucket = []byte("meta")
+	configKey  = []byte("config")
+)
+
+// BoltState stores the state of the system in a BoltDB file. It is
+// thread safe. It implements the State interface.
+type BoltState struct {
+	db *bolt.DB
+
+	versionMux sync.RWMutex
+	version    int
+}
+
+// New opens (or creates) the BoltDB file at the given path and returns
+// a BoltState using it. The pins already stored in it are kept.
+func New(path string) (*BoltState, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{pinsBucket, metaBucket} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltState{db: db, version: Version}, nil
+}
+
+// Close closes the database file.
+func (st *BoltState) Close() error {
+	return st.db.Close()
+}
+
+// key returns the database key for a Cid. Equivalent CIDv0 and CIDv1
+// forms share the same key (see api.CanonicalCid).
+func key(c *cid.Cid) []byte {
+	return []byte(api.CanonicalCid(c).String())
+}
+
+// Add stores a Pin. Concurrent calls to Add and Rm are grouped in a
+// single database transaction.
+func (st *BoltState) Add(c api.Pin) error {
+	c.Cid = api.CanonicalCid(c.Cid)
+	v, err := json.Marshal(c.ToSerial())
+	if err != nil {
+		return err
+	}
+	return st.db.Batch(func(tx *bolt.Tx) error {
+		return tx.Bucket(pinsBucket).Put(key(c.Cid), v)
+	})
+}
+
+// AddBatch stores the given Pins in a single database transaction.
+func (st *BoltState) AddBatch(pins []api.Pin) error {
+	return st.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(pinsBucket)
+		for _, pin := range pins {
+			pin.Cid = api.CanonicalCid(pin.Cid)
+			v, err := json.Marshal(pin.ToSerial())
+			if err != nil {
+				return err
+			}
+			if err := b.Put(key(pin.Cid), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Rm removes a Cid from the database. Concurrent calls to Add and Rm
+// are grouped in a single database transaction.
+func (st *BoltState) Rm(c *cid.Cid) error {
+	return st.db.Batch(func(tx *bolt.Tx) error {
+		return tx.Bucket(pinsBucket).Delete(key(c))
+	})
+}
+
+// Get returns Pin information for a CID.
+// The returned object has its Cid and Allocations
+// fields initialized, regardless of the
+// presence of the provided Cid in the state.
+// To check the presence, use BoltState.Has(*cid.Cid).
+func (st *BoltState) Get(c *cid.Cid) api.Pin {
+	var pin api.PinSerial
+	found := false
+	err := st.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(pinsBucket).Get(key(c))
+		if v == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(v, &pin)
+	})
+	if err != nil {
+		logger.Error(err)
+	}
+	if !found || err != nil { // make sure no panics
+		return api.PinCid(c)
+	}
+	return pin.ToPin()
+}
+
+// Has returns true if the Cid belongs to the State.
+func (st *BoltState) Has(c *cid.Cid) bool {
+	found := false
+	err := st.db.View(func(tx *bolt.Tx) error {
+		found = tx.Bucket(pinsBucket).Get(key(c)) != nil
+		return nil
+	})
+	if err != nil {
+		logger.Error(err)
+	}
+	return found
+}
+
+// SharedConfig returns the cluster-wide configuration.
+func (st *BoltState) SharedConfig() api.SharedConfig {
+	var cfg api.SharedConfigSerial
+	err := st.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(metaBucket).Get(configKey)
+		if v == nil {
+			return nil
+		}
+		return json.Unmarshal(v, &cfg)
+	})
+	if err != nil {
+		logger.Error(err)
+	}
+	return cfg.ToSharedConfig()
+}
+
+// SetSharedConfig replaces the cluster-wide configuration.
+func (st *BoltState) SetSharedConfig(cfg api.SharedConfig) error {
+	v, err := json.Marshal(cfg.ToSerial())
+	if err != nil {
+		return err
+	}
+	return st.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(metaBucket).Put(configKey, v)
+	})
+}
+
+// List provides the list of tracked Pins.
+func (st *BoltState) List() []api.Pin {
+	var pins []api.Pin
+	err := st.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(pinsBucket)
+		pins = make([]api.Pin, 0, b.Stats().KeyN)
+		return b.ForEach(func(k, v []byte) error {
+			var pin api.PinSerial
+			if err := json.Unmarshal(v, &pin); err != nil {
+				logger.Errorf("skipping %s: %s", k, err)
+				return nil
+			}
+			pins = append(pins, pin.ToPin())
+			return nil
+		})
+	})
+	if err != nil {
+		logger.Error(err)
+	}
+	return pins
+}
+
+// Len returns the number of stored Pins.
+func (st *BoltState) Len() int {
+	n := 0
+	err := st.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(pinsBucket).Stats().KeyN
+		return nil
+	})
+	if err != nil {
+		logger.Error(err)
+	}
+	return n
+}
+
+// ForEach calls f for every stored Pin, reading them one by one from
+// the database, until it returns false. It runs in a read transaction,
+// so f must not modify the state (waiting for a write to the database
+// would never finish).
+func (st *BoltState) ForEach(f func(api.Pin) bool) error {
+	err := st.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(pinsBucket).ForEach(func(k, v []byte) error {
+			var pin api.PinSerial
+			if err := json.Unmarshal(v, &pin); err != nil {
+				logger.Errorf("skipping %s: %s", k, err)
+				return nil
+			}
+			if !f(pin.ToPin()) {
+				return errStopIteration
+			}
+			return nil
+		})
+	})
+	if err == errStopIteration {
+		return nil
+	}
+	return err
+}
+
+// Migrate restores a snapshot from the state's serialized bytes and if
+// necessary migrates the format to the current version.
+func (st *BoltState) Migrate(r io.Reader) error {
+	ms := mapstate.NewMapState()
+	err := ms.Migrate(r)
+	if err != nil {
+		return err
+	}
+	return st.replace(ms)
+}
+
+// GetVersion returns the version of the last snapshot given to
+// Unmarshal, or the current version. It is not necessarily up to date.
+func (st *BoltState) GetVersion() int {
+	st.versionMux.RLock()
+	defer st.versionMux.RUnlock()
+	return st.version
+}
+
+// Marshal serializes the state in the mapstate format. Note that the
+// whole pinset is loaded in memory to do so.
+func (st *BoltState) Marshal() ([]byte, error) {
+	ms := mapstate.NewMapState()
+	err := st.db.View(func(tx *bolt.Tx) error {
+		err := tx.Bucket(pinsBucket).ForEach(func(k, v []byte) error {
+			var pin api.PinSerial
+			if err := json.Unmarshal(v, &pin); err != nil {
+				return err
+			}
+			ms.PinMap[string(k)] = pin
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if v := tx.Bucket(metaBucket).Get(configKey); v != nil {
+			return json.Unmarshal(v, &ms.Config)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ms.Marshal()
+}
+
+// Unmarshal replaces the contents of the database with the given
+// serialized state. As with mapstate, outdated versions are migrated
+// to the current one.
+func (st *BoltState) Unmarshal(bs []byte) error {
+	if len(bs) < 1 {
+		return errors.New("cannot unmarshal from empty bytes")
+	}
+	ms := mapstate.NewMapState()
+	err := ms.Unmarshal(bs)
+	if err != nil {
+		return err
+	}
+
+	st.versionMux.Lock()
+	st.version = ms.GetVersion()
+	st.versionMux.Unlock()
+	return st.replace(ms)
+}
+
+// replace discards all the contents of the database and stores those of
+// the given MapState in a single transaction.
+func (st *BoltState) replace(ms *mapstate.MapState) error {
+	cfg, err := json.Marshal(ms.Config)
+	if err != nil {
+		return err
+	}
+
+	err = st.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(pinsBucket); err != nil {
+			return err
+		}
+		b, err := tx.CreateBucket(pinsBucket)
+		if err != nil {
+			return err
+		}
+		for k, pin := range ms.PinMap {
+			v, err := json.Marshal(pin)
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(k), v); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(metaBucket).Put(configKey, cfg)
+	})
+	if err != nil {
+		return err
+	}
+
+	st.versionMux.Lock()
+	st.version = Version
+	st.versionMux.Unlock()
+	return nil
+}
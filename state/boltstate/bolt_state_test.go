@@ -0,0 +1,202 @@
+package boltstate
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+	peer "github.com/libp2p/go-libp2p-peer"
+
+	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/state/mapstate"
+)
+
+var testCid1, _ = cid.Decode("QmP63DkAFEnDYNjDYBpyNDfttu1fvUw99x1brscPzpqmmq")
+var testPeerID1, _ = peer.IDB58Decode("QmXZrtE5jQwXNqCJMfHUTQkvhQ4ZAnqMnmzFMJfLewuabc")
+
+var c = api.Pin{
+	Cid:                  testCid1,
+	Allocations:          []peer.ID{testPeerID1},
+	ReplicationFactorMax: -1,
+	ReplicationFactorMin: -1,
+}
+
+func testBoltState(t *testing.T) (*BoltState, func()) {
+	dir, err := ioutil.TempDir("", "boltstate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := New(filepath.Join(dir, DefaultFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return st, func() {
+		st.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestAddRm(t *testing.T) {
+	st, clean := testBoltState(t)
+	defer clean()
+
+	st.Add(c)
+	if !st.Has(c.Cid) {
+		t.Error("should have added it")
+	}
+	st.Rm(c.Cid)
+	if st.Has(c.Cid) {
+		t.Error("should have removed it")
+	}
+}
+
+func TestGetList(t *testing.T) {
+	st, clean := testBoltState(t)
+	defer clean()
+
+	st.Add(c)
+	get := st.Get(c.Cid)
+	if get.Cid.String() != c.Cid.String() ||
+		get.Allocations[0] != c.Allocations[0] ||
+		get.ReplicationFactorMax != c.ReplicationFactorMax ||
+		get.ReplicationFactorMin != c.ReplicationFactorMin {
+		t.Error("returned something different")
+	}
+
+	list := st.List()
+	if len(list) != 1 || !list[0].Cid.Equals(c.Cid) {
+		t.Error("returned something different")
+	}
+
+	v1 := cid.NewCidV1(cid.DagProtobuf, testCid1.Hash())
+	if !st.Has(v1) || len(st.List()) != 1 {
+		t.Error("both Cid forms should be the same item")
+	}
+
+	other, _ := cid.Decode("QmWPKsvv9VCXmnmX4YGNaYUmB4MbwKyyLsVDYxTQXkNdxt")
+	if get := st.Get(other); !get.Cid.Equals(other) {
+		t.Error("Get should return the Cid of missing items")
+	}
+}
+
+func TestLenForEachBatch(t *testing.T) {
+	st, clean := testBoltState(t)
+	defer clean()
+
+	other, _ := cid.Decode("QmWPKsvv9VCXmnmX4YGNaYUmB4MbwKyyLsVDYxTQXkNdxt")
+	err := st.AddBatch([]api.Pin{c, api.PinCid(other)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Len() != 2 {
+		t.Fatal("expected 2 pins")
+	}
+
+	n := 0
+	err = st.ForEach(func(pin api.Pin) bool {
+		n++
+		return true
+	})
+	if err != nil || n != 2 {
+		t.Error("ForEach should visit every pin")
+	}
+
+	n = 0
+	err = st.ForEach(func(pin api.Pin) bool {
+		n++
+		return false
+	})
+	if err != nil || n != 1 {
+		t.Error("ForEach should stop when asked to")
+	}
+}
+
+func TestSharedConfig(t *testing.T) {
+	st, clean := testBoltState(t)
+	defer clean()
+
+	if st.SharedConfig() != (api.SharedConfig{}) {
+		t.Error("shared config should be empty")
+	}
+	cfg := api.SharedConfig{
+		ReplicationFactorMin: 2,
+		ReplicationFactorMax: 3,
+		Allocator:            "ascend",
+	}
+	st.SetSharedConfig(cfg)
+	if st.SharedConfig() != cfg {
+		t.Error("returned something different")
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "boltstate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, DefaultFileName)
+
+	st, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.Add(c)
+	st.Close()
+
+	st, err = New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	if !st.Has(c.Cid) {
+		t.Error("the pin should have been kept on disk")
+	}
+}
+
+func TestMarshalUnmarshal(t *testing.T) {
+	st, clean := testBoltState(t)
+	defer clean()
+
+	st.Add(c)
+	st.SetSharedConfig(api.SharedConfig{ReplicationFactorMin: 2})
+	b, err := st.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Snapshots are compatible with mapstate
+	ms := mapstate.NewMapState()
+	err = ms.Unmarshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ms.Has(c.Cid) || ms.SharedConfig().ReplicationFactorMin != 2 {
+		t.Error("expected the same contents in the map state")
+	}
+
+	st2, clean2 := testBoltState(t)
+	defer clean2()
+	other, _ := cid.Decode("QmWPKsvv9VCXmnmX4YGNaYUmB4MbwKyyLsVDYxTQXkNdxt")
+	st2.Add(api.PinCid(other))
+
+	err = st2.Unmarshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st2.GetVersion() != Version {
+		t.Error("unexpected version")
+	}
+	if st2.Has(other) {
+		t.Error("the previous contents should have been replaced")
+	}
+	get := st2.Get(c.Cid)
+	if len(get.Allocations) != 1 || get.Allocations[0] != testPeerID1 {
+		t.Error("expected different peer id")
+	}
+	if st2.SharedConfig().ReplicationFactorMin != 2 {
+		t.Error("expected the shared config to be restored")
+	}
+}
@@ -0,0 +1,132 @@
+package state
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ipfs/ipfs-cluster/api"
+)
+
+// DefaultChangeLogSize is the number of pinset changes kept by a
+// ChangeLog created with NewChangeLog(0).
+const DefaultChangeLogSize = 10000
+
+// ChangeLog keeps, in memory, the last pinset changes applied to a State
+// so that they can be followed incrementally (i.e. by external indexers).
+// Every change gets the next index in the log. Since the log starts empty
+// every time, it has a random epoch which tells followers whether the
+// indexes they know belong to it. Consensus components record changes as
+// they apply them. It is safe for concurrent use.
+type ChangeLog struct {
+	epoch     string
+	mux       sync.Mutex
+	size      int
+	changes   []api.PinsetChange
+	lastIndex uint64
+	updated   chan struct{} // closed and replaced on every Record
+}
+
+// NewChangeLog returns a ChangeLog which keeps the given number of
+// changes, or DefaultChangeLogSize when it is not positive.
+func NewChangeLog(size int) *ChangeLog {
+	if size <= 0 {
+		size = DefaultChangeLogSize
+	}
+	return &ChangeLog{
+		epoch:   api.NewRequestID(),
+		size:    size,
+		updated: make(chan struct{}),
+	}
+}
+
+// Record adds a change to the log, dropping the oldest one when the log
+// is full.
+func (cl *ChangeLog) Record(t api.PinsetChangeType, pin api.Pin) {
+	cl.mux.Lock()
+	defer cl.mux.Unlock()
+
+	cl.lastIndex++
+	if len(cl.changes) >= cl.size {
+		// Shift instead of reslicing, so that the backing array does
+		// not grow forever.
+		copy(cl.changes, cl.changes[1:])
+		cl.changes = cl.changes[:len(cl.changes)-1]
+	}
+	cl.changes = append(cl.changes, api.PinsetChange{
+		Index: cl.lastIndex,
+		Type:  t,
+		Pin:   pin,
+		Time:  time.Now(),
+	})
+
+	close(cl.updated)
+	cl.updated = make(chan struct{})
+}
+
+// Epoch returns the epoch of this log.
+func (cl *ChangeLog) Epoch() string {
+	return cl.epoch
+}
+
+// Since returns up to limit changes with an index greater than the given
+// one (all of them when limit is not positive). Resync is set when
+// some of those changes have been dropped already, when the index is
+// ahead of the log or when the given epoch is set and is not the one of
+// the log.
+func (cl *ChangeLog) Since(epoch string, index uint64, limit int) api.PinsetChanges {
+	cl.mux.Lock()
+	defer cl.mux.Unlock()
+	chs, _ := cl.since(epoch, index, limit)
+	return chs
+}
+
+func (cl *ChangeLog) since(epoch string, index uint64, limit int) (api.PinsetChanges, <-chan struct{}) {
+	chs := api.PinsetChanges{
+		Epoch:     cl.epoch,
+		LastIndex: cl.lastIndex,
+	}
+	if (epoch != "" && epoch != cl.epoch) || index > cl.lastIndex {
+		chs.Resync = true
+		return chs, cl.updated
+	}
+	if len(cl.changes) > 0 && index+1 < cl.changes[0].Index {
+		chs.Resync = true
+		return chs, cl.updated
+	}
+
+	// Indexes are consecutive.
+	var pending []api.PinsetChange
+	if len(cl.changes) > 0 {
+		pending = cl.changes[index+1-cl.changes[0].Index:]
+	}
+	if limit > 0 && len(pending) > limit {
+		pending = pending[:limit]
+	}
+	chs.Changes = make([]api.PinsetChange, len(pending))
+	copy(chs.Changes, pending)
+	return chs, cl.updated
+}
+
+// Wait works like Since, but when there are no changes after the given
+// index, it waits for up to the given amount of time (or until the
+// context is cancelled) for new ones to be recorded.
+func (cl *ChangeLog) Wait(ctx context.Context, epoch string, index uint64, limit int, wait time.Duration) api.PinsetChanges {
+	cl.mux.Lock()
+	chs, updated := cl.since(epoch, index, limit)
+	cl.mux.Unlock()
+	if len(chs.Changes) > 0 || chs.Resync || wait <= 0 {
+		return chs
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return chs
+	case <-timer.C:
+		return chs
+	case <-updated:
+		return cl.Since(epoch, index, limit)
+	}
+}
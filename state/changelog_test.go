@@ -0,0 +1,94 @@
+package state
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	"github.com/ipfs/ipfs-cluster/api"
+)
+
+var testCid1, _ = cid.Decode("QmP63DkAFEnDYNjDYBpyNDfttu1fvUw99x1brscPzpqmmq")
+
+func TestChangeLog(t *testing.T) {
+	cl := NewChangeLog(3)
+	pin := api.PinCid(testCid1)
+
+	chs := cl.Since("", 0, 0)
+	if chs.Resync || chs.LastIndex != 0 || len(chs.Changes) != 0 {
+		t.Fatal("expected an empty log")
+	}
+
+	cl.Record(api.PinsetChangeAdd, pin)
+	cl.Record(api.PinsetChangeRemove, pin)
+	chs = cl.Since("", 0, 0)
+	if chs.Resync || chs.LastIndex != 2 || len(chs.Changes) != 2 {
+		t.Fatalf("unexpected changes: %+v", chs)
+	}
+	if chs.Changes[0].Index != 1 || chs.Changes[0].Type != api.PinsetChangeAdd ||
+		chs.Changes[1].Index != 2 || chs.Changes[1].Type != api.PinsetChangeRemove {
+		t.Errorf("unexpected changes: %+v", chs.Changes)
+	}
+	if !chs.Changes[0].Pin.Cid.Equals(testCid1) {
+		t.Error("bad pin in change")
+	}
+
+	chs = cl.Since("", 1, 0)
+	if len(chs.Changes) != 1 || chs.Changes[0].Index != 2 {
+		t.Errorf("expected the second change only: %+v", chs.Changes)
+	}
+
+	chs = cl.Since("", 2, 0)
+	if chs.Resync || len(chs.Changes) != 0 {
+		t.Error("expected no changes")
+	}
+
+	chs = cl.Since("", 5, 0)
+	if !chs.Resync {
+		t.Error("expected a resync for an index ahead of the log")
+	}
+
+	cl.Record(api.PinsetChangeAdd, pin)
+	cl.Record(api.PinsetChangeAdd, pin)
+	chs = cl.Since("", 0, 0)
+	if !chs.Resync || len(chs.Changes) != 0 || chs.LastIndex != 4 {
+		t.Errorf("expected a resync once the first change was dropped: %+v", chs)
+	}
+
+	chs = cl.Since("", 1, 2)
+	if chs.Resync || len(chs.Changes) != 2 || chs.Changes[0].Index != 2 || chs.Changes[1].Index != 3 {
+		t.Errorf("unexpected limited changes: %+v", chs)
+	}
+
+	chs = cl.Since(cl.Epoch(), 1, 2)
+	if chs.Resync || chs.Epoch != cl.Epoch() || len(chs.Changes) != 2 {
+		t.Errorf("unexpected changes for the log epoch: %+v", chs)
+	}
+
+	// a log created after a restart
+	chs = NewChangeLog(3).Since(cl.Epoch(), 0, 0)
+	if !chs.Resync {
+		t.Error("expected a resync for a different epoch")
+	}
+}
+
+func TestChangeLogWait(t *testing.T) {
+	cl := NewChangeLog(0)
+	pin := api.PinCid(testCid1)
+
+	start := time.Now()
+	chs := cl.Wait(context.Background(), "", 0, 0, 100*time.Millisecond)
+	if len(chs.Changes) != 0 || time.Since(start) < 100*time.Millisecond {
+		t.Error("expected to wait for the timeout")
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cl.Record(api.PinsetChangeAdd, pin)
+	}()
+	chs = cl.Wait(context.Background(), "", 0, 0, 10*time.Second)
+	if len(chs.Changes) != 1 || chs.LastIndex != 1 {
+		t.Errorf("expected the recorded change: %+v", chs)
+	}
+}
@@ -0,0 +1,96 @@
+package state
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/ipfs/ipfs-cluster/api"
+)
+
+// how many pins Import stores at once in States implementing Batcher
+var importBatchSize = 10000
+
+// Export writes the pinset of the given State to w as an indented json
+// list of pins. The result does not depend on the State implementation
+// or on its format version, so it can be used to back up the pinset or
+// to move it to a different peer. Pins are written as they are read
+// from the State, without listing them first.
+func Export(st State, w io.Writer) error {
+	n := 0
+	var werr error
+	err := st.ForEach(func(pin api.Pin) bool {
+		sep := ",\n    "
+		if n == 0 {
+			sep = "[\n    "
+		}
+		n++
+		var b []byte
+		b, werr = json.MarshalIndent(pin.ToSerial(), "    ", "    ")
+		if werr != nil {
+			return false
+		}
+		if _, werr = io.WriteString(w, sep); werr != nil {
+			return false
+		}
+		_, werr = w.Write(b)
+		return werr == nil
+	})
+	if err != nil {
+		return err
+	}
+	if werr != nil {
+		return werr
+	}
+	if n == 0 {
+		_, err = io.WriteString(w, "[]\n")
+	} else {
+		_, err = io.WriteString(w, "\n]\n")
+	}
+	return err
+}
+
+// ReadExported parses the pins in a pinset written by Export.
+func ReadExported(r io.Reader) ([]api.Pin, error) {
+	pinSerials := make([]api.PinSerial, 0)
+	dec := json.NewDecoder(r)
+	err := dec.Decode(&pinSerials)
+	if err != nil {
+		return nil, err
+	}
+
+	pins := make([]api.Pin, len(pinSerials), len(pinSerials))
+	for i, pS := range pinSerials {
+		pins[i] = pS.ToPin()
+	}
+	return pins, nil
+}
+
+// Import adds the pins in a pinset written by Export to the given
+// State, which is usually empty. States implementing Batcher store them
+// in batches.
+func Import(st State, r io.Reader) error {
+	pins, err := ReadExported(r)
+	if err != nil {
+		return err
+	}
+	if b, ok := st.(Batcher); ok {
+		for len(pins) > 0 {
+			n := importBatchSize
+			if n > len(pins) {
+				n = len(pins)
+			}
+			if err := b.AddBatch(pins[:n]); err != nil {
+				return err
+			}
+			pins = pins[n:]
+		}
+		return nil
+	}
+	for _, pin := range pins {
+		err = st.Add(pin)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package state
+
+import (
+	"bytes"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+	peer "github.com/libp2p/go-libp2p-peer"
+
+	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/state/mapstate"
+	"github.com/ipfs/ipfs-cluster/test"
+)
+
+func TestExportImport(t *testing.T) {
+	c1, _ := cid.Decode(test.TestCid1)
+	c2, _ := cid.Decode(test.TestCid2)
+
+	st := mapstate.NewMapState()
+	pin1 := api.PinCid(c1)
+	pin1.Name = "a"
+	pin1.Allocations = []peer.ID{test.TestPeerID1}
+	pin1.ReplicationFactorMin = 1
+	pin1.ReplicationFactorMax = 1
+	st.Add(pin1)
+	st.Add(api.PinCid(c2))
+
+	var buf bytes.Buffer
+	err := Export(st, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st2 := mapstate.NewMapState()
+	err = Import(st2, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(st2.List()) != 2 {
+		t.Fatal("expected 2 pins in the imported state")
+	}
+	if !st2.Get(c1).Equals(pin1) {
+		t.Error("the pin did not survive the export")
+	}
+	if !st2.Has(c2) {
+		t.Error("missing pin in the imported state")
+	}
+
+	var empty bytes.Buffer
+	err = Export(mapstate.NewMapState(), &empty)
+	if err != nil || empty.String() != "[]\n" {
+		t.Errorf("unexpected export of an empty state: %q", empty.String())
+	}
+
+	err = Import(st2, bytes.NewBufferString("not json"))
+	if err == nil {
+		t.Error("expected an error importing a bad pinset")
+	}
+}
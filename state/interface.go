@@ -19,10 +19,19 @@ type State interface {
 	Rm(*cid.Cid) error
 	// List lists all the pins in the state
 	List() []api.Pin
+	// Len returns the number of pins in the state without listing them
+	Len() int
+	// ForEach calls f for every pin in the state, without listing them
+	// first, until f returns false. f must not modify the state.
+	ForEach(f func(api.Pin) bool) error
 	// Has returns true if the state is holding information for a Cid
 	Has(*cid.Cid) bool
 	// Get returns the information attacthed to this pin
 	Get(*cid.Cid) api.Pin
+	// SharedConfig returns the cluster-wide configuration
+	SharedConfig() api.SharedConfig
+	// SetSharedConfig replaces the cluster-wide configuration
+	SetSharedConfig(api.SharedConfig) error
 	// Migrate restores the serialized format of an outdated state to the current version
 	Migrate(r io.Reader) error
 	// Return the version of this state
@@ -32,3 +41,10 @@ type State interface {
 	// Unmarshal deserializes the state from marshaled bytes
 	Unmarshal([]byte) error
 }
+
+// Batcher is implemented by States which can store many pins in a single
+// write, which is much faster than adding them one by one when the
+// State is kept on disk.
+type Batcher interface {
+	AddBatch([]api.Pin) error
+}
@@ -5,6 +5,7 @@ package mapstate
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"sync"
@@ -17,9 +18,9 @@ import (
 	"github.com/ipfs/ipfs-cluster/api"
 )
 
-// Version is the map state Version. States with old versions should
-// perform an upgrade before.
-const Version = 4
+// Version is the map state Version. States with old versions are
+// migrated to it when they are unmarshaled.
+const Version = 6
 
 var logger = logging.Logger("mapstate")
 
@@ -28,6 +29,7 @@ var logger = logging.Logger("mapstate")
 type MapState struct {
 	pinMux  sync.RWMutex
 	PinMap  map[string]api.PinSerial
+	Config  api.SharedConfigSerial
 	Version int
 }
 
@@ -39,11 +41,18 @@ func NewMapState() *MapState {
 	}
 }
 
+// key returns the map key for a Cid. Equivalent CIDv0 and CIDv1 forms
+// share the same key (see api.CanonicalCid).
+func key(c *cid.Cid) string {
+	return api.CanonicalCid(c).String()
+}
+
 // Add adds a Pin to the internal map.
 func (st *MapState) Add(c api.Pin) error {
 	st.pinMux.Lock()
 	defer st.pinMux.Unlock()
-	st.PinMap[c.Cid.String()] = c.ToSerial()
+	c.Cid = api.CanonicalCid(c.Cid)
+	st.PinMap[key(c.Cid)] = c.ToSerial()
 	return nil
 }
 
@@ -51,7 +60,7 @@ func (st *MapState) Add(c api.Pin) error {
 func (st *MapState) Rm(c *cid.Cid) error {
 	st.pinMux.Lock()
 	defer st.pinMux.Unlock()
-	delete(st.PinMap, c.String())
+	delete(st.PinMap, key(c))
 	return nil
 }
 
@@ -63,7 +72,7 @@ func (st *MapState) Rm(c *cid.Cid) error {
 func (st *MapState) Get(c *cid.Cid) api.Pin {
 	st.pinMux.RLock()
 	defer st.pinMux.RUnlock()
-	pins, ok := st.PinMap[c.String()]
+	pins, ok := st.PinMap[key(c)]
 	if !ok { // make sure no panics
 		return api.PinCid(c)
 	}
@@ -74,10 +83,25 @@ func (st *MapState) Get(c *cid.Cid) api.Pin {
 func (st *MapState) Has(c *cid.Cid) bool {
 	st.pinMux.RLock()
 	defer st.pinMux.RUnlock()
-	_, ok := st.PinMap[c.String()]
+	_, ok := st.PinMap[key(c)]
 	return ok
 }
 
+// SharedConfig returns the cluster-wide configuration.
+func (st *MapState) SharedConfig() api.SharedConfig {
+	st.pinMux.RLock()
+	defer st.pinMux.RUnlock()
+	return st.Config.ToSharedConfig()
+}
+
+// SetSharedConfig replaces the cluster-wide configuration.
+func (st *MapState) SetSharedConfig(cfg api.SharedConfig) error {
+	st.pinMux.Lock()
+	defer st.pinMux.Unlock()
+	st.Config = cfg.ToSerial()
+	return nil
+}
+
 // List provides the list of tracked Pins.
 func (st *MapState) List() []api.Pin {
 	st.pinMux.RLock()
@@ -92,27 +116,39 @@ func (st *MapState) List() []api.Pin {
 	return cids
 }
 
+// Len returns the number of tracked Pins.
+func (st *MapState) Len() int {
+	st.pinMux.RLock()
+	defer st.pinMux.RUnlock()
+	return len(st.PinMap)
+}
+
+// ForEach calls f for every tracked Pin until it returns false. The
+// state is locked meanwhile, so f must not modify it.
+func (st *MapState) ForEach(f func(api.Pin) bool) error {
+	st.pinMux.RLock()
+	defer st.pinMux.RUnlock()
+	for _, v := range st.PinMap {
+		if v.Cid == "" {
+			continue
+		}
+		if !f(v.ToPin()) {
+			break
+		}
+	}
+	return nil
+}
+
 // Migrate restores a snapshot from the state's internal bytes and if
-// necessary migrates the format to the current version.
+// necessary migrates the format to the current version. Unmarshal
+// performs the same migrations, so this is equivalent to reading r and
+// calling Unmarshal.
 func (st *MapState) Migrate(r io.Reader) error {
 	bs, err := ioutil.ReadAll(r)
 	if err != nil {
 		return err
 	}
-	err = st.Unmarshal(bs)
-	if err != nil {
-		return err
-	}
-	if st.Version == Version { // Unmarshal restored for us
-		return nil
-	}
-	bytesNoVersion := bs[1:] // Restore is aware of encoding format
-	err = st.migrateFrom(st.Version, bytesNoVersion)
-	if err != nil {
-		return err
-	}
-	st.Version = Version
-	return nil
+	return st.Unmarshal(bs)
 }
 
 // GetVersion returns the current version of this state object.
@@ -138,34 +174,83 @@ func (st *MapState) Marshal() ([]byte, error) {
 	return ret, nil
 }
 
-// Unmarshal decodes the state using msgpack.  It first decodes just
-// the version number.  If this is not the current version the bytes
-// are stored within the state's internal reader, which can be migrated
-// to the current version in a later call to restore.  Note: Out of date
-// version is not an error
-func (st *MapState) Unmarshal(bs []byte) error {
-	// Check version byte
-	// logger.Debugf("The incoming bytes to unmarshal: %x", bs)
+// SnapshotVersion returns the format version of a state serialized
+// with Marshal, without decoding it.
+func SnapshotVersion(bs []byte) (int, error) {
 	if len(bs) < 1 {
-		return errors.New("cannot unmarshal from empty bytes")
+		return 0, errors.New("cannot unmarshal from empty bytes")
+	}
+	return int(bs[0]), nil
+}
+
+// Unmarshal decodes the state using msgpack. It first decodes just
+// the version number. If this is an older version, the state is
+// migrated to the current one (see migrate.go), so that outdated
+// snapshots are upgraded on load. States with a newer version than
+// the supported one cannot be decoded and result in an error.
+func (st *MapState) Unmarshal(bs []byte) error {
+	v, err := SnapshotVersion(bs)
+	if err != nil {
+		return err
 	}
-	v := int(bs[0])
 	logger.Debugf("The interpreted version: %d", v)
-	if v != Version { // snapshot is out of date
-		st.Version = v
-		return nil
+	switch {
+	case v > Version:
+		return fmt.Errorf("state version %d is newer than the supported version (%d)", v, Version)
+	case v < Version:
+		return st.upgrade(v, bs[1:])
 	}
 
 	// snapshot is up to date
 	buf := bytes.NewBuffer(bs[1:])
 	newState := MapState{}
 	dec := msgpack.Multicodec(msgpack.DefaultMsgpackHandle()).Decoder(buf)
-	err := dec.Decode(&newState)
+	err = dec.Decode(&newState)
 	if err != nil {
 		logger.Error(err)
 	}
 
 	st.PinMap = newState.PinMap
+	st.Config = newState.Config
 	st.Version = newState.Version
 	return err
 }
+
+// upgrade replaces the contents of the state with those of a snapshot
+// of the given (older) version, migrated to the current one.
+func (st *MapState) upgrade(version int, snap []byte) error {
+	logger.Warningf("upgrading state from version %d to version %d", version, Version)
+	migrated := NewMapState()
+	err := migrated.migrateFrom(version, snap)
+	if err != nil {
+		return err
+	}
+	st.PinMap = migrated.PinMap
+	st.Config = migrated.Config
+	st.Version = Version
+	return nil
+}
+
+// canonicalPinMap re-indexes pins stored with a non-canonical Cid, which
+// versions older than 6 allowed, under their canonical key. If both forms of a
+// Cid are present, the entry already using the canonical key is kept.
+func canonicalPinMap(pins map[string]api.PinSerial) map[string]api.PinSerial {
+	for k, p := range pins {
+		c, err := cid.Decode(k)
+		if err != nil {
+			continue
+		}
+		ck := key(c)
+		if ck == k {
+			continue
+		}
+		delete(pins, k)
+		if _, ok := pins[ck]; ok {
+			logger.Warningf("dropping %s from the state: %s is already pinned", k, ck)
+			continue
+		}
+		p.Cid = ck
+		pins[ck] = p
+	}
+	return pins
+}
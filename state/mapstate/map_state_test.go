@@ -3,6 +3,7 @@ package mapstate
 import (
 	"bytes"
 	"testing"
+	"time"
 
 	msgpack "github.com/multiformats/go-multicodec/msgpack"
 
@@ -13,6 +14,7 @@ import (
 )
 
 var testCid1, _ = cid.Decode("QmP63DkAFEnDYNjDYBpyNDfttu1fvUw99x1brscPzpqmmq")
+var testCid2, _ = cid.Decode("QmP63DkAFEnDYNjDYBpyNDfttu1fvUw99x1brscPzpqmma")
 var testPeerID1, _ = peer.IDB58Decode("QmXZrtE5jQwXNqCJMfHUTQkvhQ4ZAnqMnmzFMJfLewuabc")
 
 var c = api.Pin{
@@ -73,9 +75,46 @@ func TestList(t *testing.T) {
 	}
 }
 
+func TestLenForEach(t *testing.T) {
+	ms := NewMapState()
+	ms.Add(c)
+	if ms.Len() != 1 {
+		t.Error("expected 1 pin")
+	}
+	n := 0
+	ms.ForEach(func(pin api.Pin) bool {
+		if !pin.Cid.Equals(c.Cid) {
+			t.Error("returned something different")
+		}
+		n++
+		return true
+	})
+	if n != 1 {
+		t.Error("ForEach should visit every pin")
+	}
+}
+
+func TestSharedConfig(t *testing.T) {
+	ms := NewMapState()
+	if ms.SharedConfig() != (api.SharedConfig{}) {
+		t.Error("shared config should be empty")
+	}
+	cfg := api.SharedConfig{
+		ReplicationFactorMin: 2,
+		ReplicationFactorMax: 3,
+		Allocator:            "ascend",
+		StateSyncInterval:    time.Minute,
+	}
+	ms.SetSharedConfig(cfg)
+	if ms.SharedConfig() != cfg {
+		t.Error("returned something different")
+	}
+}
+
 func TestMarshalUnmarshal(t *testing.T) {
 	ms := NewMapState()
 	ms.Add(c)
+	ms.SetSharedConfig(api.SharedConfig{ReplicationFactorMin: 2})
 	b, err := ms.Marshal()
 	if err != nil {
 		t.Fatal(err)
@@ -92,6 +131,9 @@ func TestMarshalUnmarshal(t *testing.T) {
 	if get.Allocations[0] != testPeerID1 {
 		t.Error("expected different peer id")
 	}
+	if ms2.SharedConfig().ReplicationFactorMin != 2 {
+		t.Error("expected the shared config to be restored")
+	}
 }
 
 func TestMigrateFromV1(t *testing.T) {
@@ -110,16 +152,16 @@ func TestMigrateFromV1(t *testing.T) {
 	vCodec[0] = byte(v1State.Version)
 	v1Bytes := append(vCodec, buf.Bytes()...)
 
-	// Unmarshal first to check this is v1
-	ms := NewMapState()
-	err = ms.Unmarshal(v1Bytes)
+	v, err := SnapshotVersion(v1Bytes)
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
-	if ms.Version != 1 {
-		t.Error("unmarshal picked up the wrong version")
+	if v != 1 {
+		t.Error("picked up the wrong version")
 	}
+
 	// Migrate state to current version
+	ms := NewMapState()
 	r := bytes.NewBuffer(v1Bytes)
 	err = ms.Migrate(r)
 	if err != nil {
@@ -130,4 +172,106 @@ func TestMigrateFromV1(t *testing.T) {
 		t.Error("expected something different")
 		t.Logf("%+v", get)
 	}
+
+	// Unmarshal upgrades the state too
+	ms2 := NewMapState()
+	ms2.Add(api.PinCid(testCid2))
+	err = ms2.Unmarshal(v1Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ms2.Version != Version {
+		t.Error("the state should have been upgraded")
+	}
+	if !ms2.Has(c.Cid) || ms2.Has(testCid2) || len(ms2.List()) != 1 {
+		t.Error("the state should only have the migrated pins")
+	}
+}
+
+func TestMigrateFromV4(t *testing.T) {
+	v4State := mapStateV4{
+		PinMap:  map[string]api.PinSerial{c.Cid.String(): c.ToSerial()},
+		Version: 4,
+	}
+	buf := new(bytes.Buffer)
+	enc := msgpack.Multicodec(msgpack.DefaultMsgpackHandle()).Encoder(buf)
+	err := enc.Encode(v4State)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v4Bytes := append([]byte{4}, buf.Bytes()...)
+
+	ms := NewMapState()
+	ms.SetSharedConfig(api.SharedConfig{ReplicationFactorMin: 2})
+	err = ms.Unmarshal(v4Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ms.Version != Version || !ms.Has(c.Cid) {
+		t.Error("the state should have been upgraded")
+	}
+	if ms.SharedConfig() != (api.SharedConfig{}) {
+		t.Error("v4 states have no shared configuration")
+	}
+}
+
+func TestUnmarshalNewerVersion(t *testing.T) {
+	ms := NewMapState()
+	b, err := ms.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[0] = byte(Version + 1)
+	err = NewMapState().Unmarshal(b)
+	if err == nil {
+		t.Error("expected an error unmarshaling a newer state version")
+	}
+}
+
+func TestCidVersions(t *testing.T) {
+	v1 := cid.NewCidV1(cid.DagProtobuf, testCid1.Hash())
+	ms := NewMapState()
+	ms.Add(api.Pin{Cid: v1, Allocations: []peer.ID{}})
+	ms.Add(c)
+	if len(ms.List()) != 1 {
+		t.Fatal("both Cid forms should be the same item")
+	}
+	if !ms.Has(v1) || !ms.Get(v1).Cid.Equals(testCid1) {
+		t.Error("the CIDv0 form should be stored")
+	}
+
+}
+
+func TestMigrateFromV5(t *testing.T) {
+	// v5 states could index pins by their CIDv1
+	v1 := cid.NewCidV1(cid.DagProtobuf, testCid1.Hash())
+	v5State := mapStateV5{
+		PinMap: map[string]api.PinSerial{
+			v1.String(): api.Pin{Cid: v1, Allocations: []peer.ID{}}.ToSerial(),
+		},
+		Config:  api.SharedConfig{ReplicationFactorMin: 2}.ToSerial(),
+		Version: 5,
+	}
+	buf := new(bytes.Buffer)
+	enc := msgpack.Multicodec(msgpack.DefaultMsgpackHandle()).Encoder(buf)
+	err := enc.Encode(v5State)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v5Bytes := append([]byte{5}, buf.Bytes()...)
+
+	ms := NewMapState()
+	err = ms.Unmarshal(v5Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ms.Version != Version {
+		t.Error("the state should have been upgraded")
+	}
+	if _, ok := ms.PinMap[testCid1.String()]; !ok || len(ms.PinMap) != 1 {
+		t.Error("expected the pin to be indexed by its CIDv0")
+	}
+	if ms.SharedConfig().ReplicationFactorMin != 2 {
+		t.Error("the shared configuration should have been kept")
+	}
 }
@@ -134,14 +134,61 @@ func (st *mapStateV4) unmarshal(bs []byte) error {
 	return dec.Decode(st)
 }
 
+// Migrate from v4 to v5, which adds the shared configuration.
+// v4 states had none.
 func (st *mapStateV4) next() migrateable {
+	var mst5 mapStateV5
+	mst5.PinMap = st.PinMap
+	return &mst5
+}
+
+/* V5 */
+
+type mapStateV5 struct {
+	PinMap  map[string]api.PinSerial
+	Config  api.SharedConfigSerial
+	Version int
+}
+
+func (st *mapStateV5) unmarshal(bs []byte) error {
+	buf := bytes.NewBuffer(bs)
+	dec := msgpack.Multicodec(msgpack.DefaultMsgpackHandle()).Decoder(buf)
+	return dec.Decode(st)
+}
+
+// Migrate from v5 to v6, which stores every pin under the canonical form
+// of its Cid (see api.CanonicalCid). v5 states could have pins stored
+// under their CIDv1 form.
+func (st *mapStateV5) next() migrateable {
+	var mst6 mapStateV6
+	mst6.PinMap = canonicalPinMap(st.PinMap)
+	mst6.Config = st.Config
+	return &mst6
+}
+
+/* V6 */
+
+type mapStateV6 struct {
+	PinMap  map[string]api.PinSerial
+	Config  api.SharedConfigSerial
+	Version int
+}
+
+func (st *mapStateV6) unmarshal(bs []byte) error {
+	buf := bytes.NewBuffer(bs)
+	dec := msgpack.Multicodec(msgpack.DefaultMsgpackHandle()).Decoder(buf)
+	return dec.Decode(st)
+}
+
+func (st *mapStateV6) next() migrateable {
 	return nil
 }
 
-func finalCopy(st *MapState, internal *mapStateV4) {
+func finalCopy(st *MapState, internal *mapStateV6) {
 	for k, v := range internal.PinMap {
 		st.PinMap[k] = v
 	}
+	st.Config = internal.Config
 }
 
 func (st *MapState) migrateFrom(version int, snap []byte) error {
@@ -156,6 +203,12 @@ func (st *MapState) migrateFrom(version int, snap []byte) error {
 	case 3:
 		var mst3 mapStateV3
 		m = &mst3
+	case 4:
+		var mst4 mapStateV4
+		m = &mst4
+	case 5:
+		var mst5 mapStateV5
+		m = &mst5
 	default:
 		return errors.New("version migration not supported")
 	}
@@ -168,11 +221,11 @@ func (st *MapState) migrateFrom(version int, snap []byte) error {
 	for {
 		next = m.next()
 		if next == nil {
-			mst4, ok := m.(*mapStateV4)
+			mst6, ok := m.(*mapStateV6)
 			if !ok {
 				return errors.New("migration ended prematurely")
 			}
-			finalCopy(st, mst4)
+			finalCopy(st, mst6)
 			return nil
 		}
 		m = next
@@ -0,0 +1,98 @@
+package ipfscluster
+
+import (
+	"encoding/json"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+
+	"github.com/ipfs/ipfs-cluster/api"
+)
+
+// pushState sends the pins in the shared state to a peer which has just
+// been added, so that it can start tracking them before it has caught up
+// with the consensus log. Nothing is sent while the cluster is paused.
+// The state is streamed in chunks of Config.StateSyncBatchSize pins, one
+// PreloadState call each, paced according to Config.StateTransferLimit.
+// It stops when the cluster shuts down.
+func (c *Cluster) pushState(pid peer.ID) error {
+	cState, err := c.consensus.State()
+	if err != nil {
+		return err
+	}
+	if cState.SharedConfig().Paused {
+		return nil
+	}
+
+	pins := cState.List()
+	batch := c.config.StateSyncBatchSize
+	for i := 0; i < len(pins); i += batch {
+		end := i + batch
+		if end > len(pins) {
+			end = len(pins)
+		}
+		serials := make([]api.PinSerial, 0, end-i)
+		for _, p := range pins[i:end] {
+			serials = append(serials, p.ToSerial())
+		}
+
+		if c.statePacer.limit > 0 {
+			raw, err := json.Marshal(serials)
+			if err != nil {
+				return err
+			}
+			err = c.statePacer.wait(c.ctx, len(raw))
+			if err != nil {
+				return err
+			}
+		}
+
+		err := c.rpcClient.CallContext(
+			c.ctx,
+			pid,
+			"Cluster",
+			"PreloadState",
+			serials,
+			&struct{}{})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PreloadState makes the PinTracker track the given pins, as sent by a
+// peer adding this one to its cluster, so that pinning can start right
+// away. It does nothing when the shared state of this peer is not empty,
+// since the consensus log is authoritative. Any differences with the
+// preloaded pins are fixed by StateSync() once the shared state has
+// caught up.
+func (c *Cluster) PreloadState(pins []api.Pin) error {
+	cState, err := c.consensus.State()
+	if err != nil {
+		return err
+	}
+	if cState.Len() > 0 {
+		c.logger.Debug("shared state is not empty. Not preloading pins")
+		return nil
+	}
+
+	tracked := make(map[string]struct{})
+	for _, pinfo := range c.tracker.StatusAll() {
+		tracked[pinfo.Cid.String()] = struct{}{}
+	}
+
+	var n int
+	for _, pin := range pins {
+		if _, ok := tracked[pin.Cid.String()]; ok {
+			continue
+		}
+		err := c.track(pin)
+		if err != nil {
+			c.logger.Errorf("preloading %s: %s", pin.Cid, err)
+			continue
+		}
+		n++
+	}
+	c.logger.Infof("preloaded %d pins from the peer adding us", n)
+	return nil
+}
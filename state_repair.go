@@ -0,0 +1,261 @@
+package ipfscluster
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/state"
+	"github.com/ipfs/ipfs-cluster/state/mapstate"
+)
+
+// Number of attempts to obtain comparable state checksums
+// (taken at the same applied index) from this peer and the leader.
+var (
+	stateRepairRetries       = 10
+	stateRepairRetryInterval = 500 * time.Millisecond
+)
+
+var errStateMoving = errors.New("the state kept changing while taking a checksum")
+
+// stateChecksum returns a hex-encoded sha256 digest of the given state.
+// It does not depend on the order in which pins are listed.
+func stateChecksum(st state.State) (string, int, error) {
+	pins := st.List()
+	serials := make([]api.PinSerial, 0, len(pins))
+	for _, p := range pins {
+		serials = append(serials, p.ToSerial())
+	}
+	sort.Slice(serials, func(i, j int) bool {
+		return serials[i].Cid < serials[j].Cid
+	})
+
+	h := sha256.New()
+	enc := json.NewEncoder(h)
+	for _, p := range serials {
+		if err := enc.Encode(p); err != nil {
+			return "", 0, err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), len(serials), nil
+}
+
+// StateChecksum returns a checksum of the shared state as currently seen by
+// this peer, along with the consensus index it corresponds to.
+func (c *Cluster) StateChecksum() (api.StateChecksum, error) {
+	st, err := c.consensus.State()
+	if err != nil {
+		return api.StateChecksum{}, err
+	}
+
+	// Make sure that no operations were applied while
+	// calculating the checksum.
+	for i := 0; i < stateRepairRetries; i++ {
+		before, err := c.consensus.Status()
+		if err != nil {
+			return api.StateChecksum{}, err
+		}
+		sum, n, err := stateChecksum(st)
+		if err != nil {
+			return api.StateChecksum{}, err
+		}
+		after, err := c.consensus.Status()
+		if err != nil {
+			return api.StateChecksum{}, err
+		}
+		if before.AppliedIndex == after.AppliedIndex {
+			return api.StateChecksum{
+				Checksum:     sum,
+				AppliedIndex: after.AppliedIndex,
+				Pins:         n,
+			}, nil
+		}
+	}
+	return api.StateChecksum{}, errStateMoving
+}
+
+// StateSnapshot returns the serialized shared state as seen by this peer.
+// Snapshots are served at the pace given by Config.StateTransferLimit, so
+// that many peers repairing at once do not starve consensus traffic.
+func (c *Cluster) StateSnapshot() ([]byte, error) {
+	st, err := c.consensus.State()
+	if err != nil {
+		return nil, err
+	}
+	snapshot, err := st.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	err = c.statePacer.wait(c.ctx, len(snapshot))
+	if err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// compareStateWithLeader obtains checksums for the local state and for the
+// leader's state taken at the same applied index.
+func (c *Cluster) compareStateWithLeader() (api.StateChecksum, api.StateChecksum, error) {
+	var local, remote api.StateChecksum
+
+	for i := 0; i < stateRepairRetries; i++ {
+		leader, err := c.consensus.Leader()
+		if err != nil {
+			return local, remote, err
+		}
+
+		err = c.consensus.WaitForSync()
+		if err != nil {
+			return local, remote, err
+		}
+
+		err = c.rpcClient.Call(leader,
+			"Cluster",
+			"StateChecksum",
+			struct{}{},
+			&remote)
+		if err != nil {
+			return local, remote, err
+		}
+
+		local, err = c.StateChecksum()
+		if err != nil {
+			return local, remote, err
+		}
+
+		if local.AppliedIndex == remote.AppliedIndex {
+			return local, remote, nil
+		}
+		c.logger.Debugf(
+			"state checksums taken at different indexes (%d vs %d). Retrying",
+			local.AppliedIndex,
+			remote.AppliedIndex,
+		)
+		time.Sleep(stateRepairRetryInterval)
+	}
+	return local, remote, errStateMoving
+}
+
+// RepairState compares the local shared state with the leader's using
+// checksums. When they diverge, a snapshot is obtained from the leader
+// and the consensus component brings the local copy of the state in line
+// with it. The PinTracker is then reconciled with the state (as in
+// StateSync()). This avoids having to wipe the data folder of a peer whose
+// state has become inconsistent.
+//
+// Calling RepairState on the leader does nothing, as its state is
+// considered authoritative. It fails when the consensus component is not
+// a StateRepairer.
+func (c *Cluster) RepairState() (api.StateRepair, error) {
+	repairer, ok := c.consensus.(StateRepairer)
+	if !ok {
+		return api.StateRepair{}, errors.New("the consensus component cannot repair the state")
+	}
+
+	leader, err := c.consensus.Leader()
+	if err != nil {
+		return api.StateRepair{}, err
+	}
+	if leader == c.id {
+		local, err := c.StateChecksum()
+		return api.StateRepair{
+			Local:         local,
+			Authoritative: local,
+		}, err
+	}
+
+	local, remote, err := c.compareStateWithLeader()
+	if err != nil {
+		c.logger.Error(err)
+		return api.StateRepair{}, err
+	}
+	if local.Checksum == remote.Checksum {
+		return api.StateRepair{
+			Local:         local,
+			Authoritative: remote,
+		}, nil
+	}
+
+	c.logger.Warningf(
+		"local state (%s, %d pins) diverges from the leader's (%s, %d pins) at index %d. Repairing",
+		local.Checksum,
+		local.Pins,
+		remote.Checksum,
+		remote.Pins,
+		remote.AppliedIndex,
+	)
+
+	var repaired api.StateChecksum
+	for i := 0; i < stateRepairRetries; i++ {
+		var snapshot []byte
+		err = c.rpcClient.Call(leader,
+			"Cluster",
+			"StateSnapshot",
+			struct{}{},
+			&snapshot)
+		if err != nil {
+			c.logger.Error(err)
+			return api.StateRepair{}, err
+		}
+
+		// Decode the snapshot on a copy. The live state is only
+		// modified by the consensus component.
+		authoritative := mapstate.NewMapState()
+		err = authoritative.Unmarshal(snapshot)
+		if err != nil {
+			c.logger.Error(err)
+			return api.StateRepair{}, err
+		}
+
+		err = repairer.RepairState(authoritative)
+		if err != nil {
+			c.logger.Error(err)
+			return api.StateRepair{}, err
+		}
+
+		// Verify that the repair was successful. Operations
+		// applied since the snapshot was taken make it fail,
+		// in which case we try again.
+		repaired, remote, err = c.compareStateWithLeader()
+		if err != nil {
+			c.logger.Error(err)
+			return api.StateRepair{}, err
+		}
+		if repaired.Checksum == remote.Checksum {
+			break
+		}
+		c.logger.Debugf(
+			"state still diverges from the leader's after repair (%s vs %s). Retrying",
+			repaired.Checksum,
+			remote.Checksum,
+		)
+		time.Sleep(stateRepairRetryInterval)
+	}
+	if repaired.Checksum != remote.Checksum {
+		err = fmt.Errorf(
+			"state still diverges from the leader's after repair (%s vs %s)",
+			repaired.Checksum,
+			remote.Checksum,
+		)
+		c.logger.Error(err)
+		return api.StateRepair{}, err
+	}
+
+	err = c.StateSync()
+	if err != nil {
+		c.logger.Error(err)
+		return api.StateRepair{}, err
+	}
+
+	c.logger.Infof("local state repaired: %d pins", repaired.Pins)
+	return api.StateRepair{
+		Diverged:      true,
+		Local:         local,
+		Authoritative: remote,
+	}, nil
+}
@@ -0,0 +1,66 @@
+package ipfscluster
+
+import (
+	"sort"
+
+	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/rpcutil"
+)
+
+// StatusSummaryLocal counts the items tracked by this peer in each
+// TrackerStatus.
+func (c *Cluster) StatusSummaryLocal() api.StatusSummary {
+	sum := api.StatusSummary{
+		Peer:   c.id,
+		Counts: make(map[api.TrackerStatus]int),
+	}
+	for _, pinfo := range c.tracker.StatusAll() {
+		sum.Counts[pinfo.Status]++
+	}
+	return sum
+}
+
+// StatusSummary returns the StatusSummaryLocal() of every cluster peer,
+// sorted by peer ID. Unlike StatusAll(), it does not list the status
+// of every item, which makes it cheap to obtain an overview of large
+// clusters. Peers which cannot be contacted have their Error set.
+func (c *Cluster) StatusSummary() ([]api.StatusSummary, error) {
+	members, err := c.consensus.Peers()
+	if err != nil {
+		c.logger.Error(err)
+		return nil, err
+	}
+
+	replies := make([]api.StatusSummarySerial, len(members), len(members))
+
+	ctxs, cancels := c.multiCallCtxs(c.ctx, len(members), 0)
+	defer rpcutil.MultiCancel(cancels)
+
+	errs := c.rpcClient.MultiCall(
+		ctxs,
+		members,
+		"Cluster",
+		"StatusSummaryLocal",
+		struct{}{},
+		rpcutil.CopyStatusSummarySerialToIfaces(replies),
+	)
+
+	sums := make([]api.StatusSummary, len(members), len(members))
+	for i, r := range replies {
+		if e := errs[i]; e != nil {
+			c.logger.Errorf("%s: error in broadcast response from %s: %s ", c.id, members[i], e)
+			sums[i] = api.StatusSummary{
+				Peer:   members[i],
+				Counts: make(map[api.TrackerStatus]int),
+				Error:  e.Error(),
+			}
+			continue
+		}
+		sums[i] = r.ToStatusSummary()
+	}
+
+	sort.Slice(sums, func(i, j int) bool {
+		return sums[i].Peer < sums[j].Peer
+	})
+	return sums, nil
+}
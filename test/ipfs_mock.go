@@ -8,6 +8,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/ipfs/ipfs-cluster/api"
 	"github.com/ipfs/ipfs-cluster/state/mapstate"
@@ -15,12 +16,18 @@ import (
 	cid "github.com/ipfs/go-cid"
 )
 
+// IpfsMockAgentVersion is the agent version reported by the IpfsMock.
+const IpfsMockAgentVersion = "go-ipfs/0.4.14/mock"
+
 // IpfsMock is an ipfs daemon mock which should sustain the functionality used by ipfscluster.
 type IpfsMock struct {
 	server *httptest.Server
 	Addr   string
 	Port   int
 	pinMap *mapstate.MapState
+
+	pubsubMux sync.Mutex
+	pubsub    map[string][]string
 }
 
 type mockPinResp struct {
@@ -41,8 +48,9 @@ type ipfsErr struct {
 }
 
 type mockIDResp struct {
-	ID        string
-	Addresses []string
+	ID           string
+	Addresses    []string
+	AgentVersion string
 }
 
 type mockRepoStatResp struct {
@@ -57,6 +65,11 @@ type mockConfigResp struct {
 	}
 }
 
+type mockObjectStatResp struct {
+	Hash           string
+	CumulativeSize uint64
+}
+
 type mockAddResp struct {
 	Name  string
 	Hash  string
@@ -68,6 +81,11 @@ type mockRefsResp struct {
 	Err string
 }
 
+type mockBlockStatResp struct {
+	Key  string
+	Size uint64
+}
+
 type mockSwarmPeersResp struct {
 	Peers []mockIpfsPeer
 }
@@ -81,6 +99,7 @@ func NewIpfsMock() *IpfsMock {
 	st := mapstate.NewMapState()
 	m := &IpfsMock{
 		pinMap: st,
+		pubsub: make(map[string][]string),
 	}
 	ts := httptest.NewServer(http.HandlerFunc(m.handler))
 	m.server = ts
@@ -106,6 +125,7 @@ func (m *IpfsMock) handler(w http.ResponseWriter, r *http.Request) {
 			Addresses: []string{
 				"/ip4/0.0.0.0/tcp/1234",
 			},
+			AgentVersion: IpfsMockAgentVersion,
 		}
 		j, _ := json.Marshal(resp)
 		w.Write(j)
@@ -235,6 +255,17 @@ func (m *IpfsMock) handler(w http.ResponseWriter, r *http.Request) {
 		}
 		j, _ := json.Marshal(resp)
 		w.Write(j)
+	case "object/stat":
+		arg, ok := extractCid(r.URL)
+		if !ok {
+			goto ERROR
+		}
+		resp := mockObjectStatResp{
+			Hash:           arg,
+			CumulativeSize: 1000,
+		}
+		j, _ := json.Marshal(resp)
+		w.Write(j)
 	case "config/show":
 		resp := mockConfigResp{
 			Datastore: struct {
@@ -246,17 +277,57 @@ func (m *IpfsMock) handler(w http.ResponseWriter, r *http.Request) {
 		j, _ := json.Marshal(resp)
 		w.Write(j)
 	case "refs":
+		arg, ok := extractCid(r.URL)
+		if !ok || arg == ErrorCid {
+			goto ERROR
+		}
+		if r.URL.Query().Get("format") == "" {
+			resp := mockRefsResp{
+				Ref: arg,
+			}
+			j, _ := json.Marshal(resp)
+			w.Write(j)
+			break
+		}
+		// "<src> <dst>" format: TestCid1 links to TestCid2 and
+		// TestCid3, and TestCid2 links to TestCid3 too.
+		if arg != TestCid1 {
+			break
+		}
+		links := [][2]string{
+			{TestCid1, TestCid2},
+			{TestCid1, TestCid3},
+			{TestCid2, TestCid3},
+		}
+		for _, l := range links {
+			j, _ := json.Marshal(mockRefsResp{Ref: l[0] + " " + l[1]})
+			w.Write(j)
+			w.Write([]byte("\n"))
+		}
+	case "block/stat":
 		arg, ok := extractCid(r.URL)
 		if !ok {
 			goto ERROR
 		}
-		resp := mockRefsResp{
-			Ref: arg,
+		resp := mockBlockStatResp{
+			Key:  arg,
+			Size: 100,
+		}
+		if arg == TestCid3 {
+			resp.Size = 300
 		}
 		j, _ := json.Marshal(resp)
 		w.Write(j)
 	case "version":
 		w.Write([]byte("{\"Version\":\"m.o.c.k\"}"))
+	case "pubsub/pub":
+		args := r.URL.Query()["arg"]
+		if len(args) != 2 {
+			goto ERROR
+		}
+		m.pubsubMux.Lock()
+		m.pubsub[args[0]] = append(m.pubsub[args[0]], args[1])
+		m.pubsubMux.Unlock()
 	default:
 		w.WriteHeader(http.StatusNotFound)
 	}
@@ -265,6 +336,13 @@ ERROR:
 	w.WriteHeader(http.StatusInternalServerError)
 }
 
+// PubsubMessages returns the messages published to the given topic.
+func (m *IpfsMock) PubsubMessages(topic string) []string {
+	m.pubsubMux.Lock()
+	defer m.pubsubMux.Unlock()
+	return append([]string{}, m.pubsub[topic]...)
+}
+
 // Close closes the mock server. It's important to call after each test or
 // the listeners are left hanging around.
 func (m *IpfsMock) Close() {
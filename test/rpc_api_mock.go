@@ -18,6 +18,9 @@ import (
 // fail.
 var ErrBadCid = errors.New("this is an expected error when using ErrorCid")
 
+// TestJobID is the ID of the only job known to the RPC mock.
+var TestJobID = "f00df00df00df00d"
+
 type mockService struct{}
 
 // NewMockRPCClient creates a mock ipfs-cluster RPC server and returns
@@ -45,6 +48,11 @@ func (mock *mockService) Pin(ctx context.Context, in api.PinSerial, out *struct{
 	return nil
 }
 
+func (mock *mockService) BackPressure(ctx context.Context, in struct{}, out *int) error {
+	*out = 1500
+	return nil
+}
+
 func (mock *mockService) Unpin(ctx context.Context, in api.PinSerial, out *struct{}) error {
 	if in.Cid == ErrorCid {
 		return ErrBadCid
@@ -67,6 +75,88 @@ func (mock *mockService) Pins(ctx context.Context, in struct{}, out *[]api.PinSe
 	return nil
 }
 
+// mockSearchablePins returns the pins used to answer SearchPins and
+// UnpinMatching requests.
+func mockSearchablePins() []api.Pin {
+	pins := []api.Pin{
+		{
+			Name:     "backup-2017-01",
+			Metadata: map[string]string{"tenant": "a"},
+		},
+		{
+			Name:     "backup-2017-02",
+			Metadata: map[string]string{"tenant": "b"},
+		},
+		{
+			Name: "other",
+		},
+	}
+	for i, c := range []string{TestCid1, TestCid2, TestCid3} {
+		pins[i].Cid, _ = cid.Decode(c)
+	}
+	return pins
+}
+
+func (mock *mockService) SearchPins(ctx context.Context, in api.PinQuery, out *[]api.PinSerial) error {
+	found := in.Search(mockSearchablePins())
+	*out = make([]api.PinSerial, len(found), len(found))
+	for i, p := range found {
+		(*out)[i] = p.ToSerial()
+	}
+	return nil
+}
+
+func (mock *mockService) UnpinMatching(ctx context.Context, in api.BulkUnpin, out *[]api.PinSerial) error {
+	if in.Query.IsEmpty() {
+		return errors.New("a name or metadata selector (other than \"*\") is needed to unpin in bulk")
+	}
+	return mock.SearchPins(ctx, in.Query, out)
+}
+
+func (mock *mockService) SharedConfig(ctx context.Context, in struct{}, out *api.SharedConfigSerial) error {
+	*out = api.SharedConfigSerial{
+		ReplicationFactorMin: 2,
+		ReplicationFactorMax: 3,
+		StateSyncInterval:    "1m0s",
+	}
+	return nil
+}
+
+func (mock *mockService) SetSharedConfig(ctx context.Context, in api.SharedConfigUpdateSerial, out *struct{}) error {
+	if in.Config.Allocator != "" && in.Config.Allocator != "ascend" && in.Config.Allocator != "descend" {
+		return errors.New("unknown allocator")
+	}
+	if len(in.Fields) == 0 {
+		return errors.New("no settings to update")
+	}
+	return nil
+}
+
+func (mock *mockService) InjectFaults(ctx context.Context, in api.FaultsSerial, out *struct{}) error {
+	return nil
+}
+
+func (mock *mockService) Faults(ctx context.Context, in struct{}, out *api.FaultsSerial) error {
+	*out = api.FaultsSerial{
+		IPFSErrors: true,
+		Duration:   "10m0s",
+		Until:      "2030-01-01T00:00:00Z",
+	}
+	return nil
+}
+
+func (mock *mockService) Pause(ctx context.Context, in struct{}, out *struct{}) error {
+	return nil
+}
+
+func (mock *mockService) Resume(ctx context.Context, in struct{}, out *struct{}) error {
+	return nil
+}
+
+func (mock *mockService) ApplySharedConfig(ctx context.Context, in api.SharedConfigSerial, out *struct{}) error {
+	return nil
+}
+
 func (mock *mockService) PinGet(ctx context.Context, in api.PinSerial, out *api.PinSerial) error {
 	if in.Cid == ErrorCid {
 		return errors.New("expected error when using ErrorCid")
@@ -88,6 +178,8 @@ func (mock *mockService) ID(ctx context.Context, in struct{}, out *api.IDSerial)
 			Addresses: api.MultiaddrsSerial{
 				api.MultiaddrSerial("/ip4/127.0.0.1/tcp/4001/ipfs/" + TestPeerID1.Pretty()),
 			},
+			AgentVersion: IpfsMockAgentVersion,
+			Reachable:    true,
 		},
 	}
 	return nil
@@ -108,6 +200,22 @@ func (mock *mockService) Peers(ctx context.Context, in struct{}, out *[]api.IDSe
 	return nil
 }
 
+func (mock *mockService) QueryPeers(ctx context.Context, in api.PeersQuery, out *[]api.IDSerial) error {
+	var ids []api.IDSerial
+	for _, p := range in.Paginate([]peer.ID{TestPeerID1, TestPeerID2, TestPeerID3}) {
+		id := api.ID{
+			ID:            p,
+			LastHeartbeat: time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC),
+		}
+		if !in.Local {
+			id.Peername = "peer"
+		}
+		ids = append(ids, id.ToSerial())
+	}
+	*out = ids
+	return nil
+}
+
 func (mock *mockService) PeerAdd(ctx context.Context, in api.MultiaddrSerial, out *api.IDSerial) error {
 	id := api.IDSerial{}
 	mock.ID(ctx, struct{}{}, &id)
@@ -115,7 +223,82 @@ func (mock *mockService) PeerAdd(ctx context.Context, in api.MultiaddrSerial, ou
 	return nil
 }
 
-func (mock *mockService) PeerRemove(ctx context.Context, in peer.ID, out *struct{}) error {
+func (mock *mockService) Peerset(ctx context.Context, in struct{}, out *api.MultiaddrsSerial) error {
+	*out = api.MultiaddrsSerial{
+		api.MultiaddrSerial("/ip4/127.0.0.1/tcp/9096/ipfs/" + TestPeerID1.Pretty()),
+	}
+	return nil
+}
+
+func (mock *mockService) PeerRemove(ctx context.Context, in peer.ID, out *api.PeerRemoval) error {
+	if in == TestPeerID3 {
+		return errors.New("removing the peer would break quorum")
+	}
+	return nil
+}
+
+func (mock *mockService) PeerRemoveForce(ctx context.Context, in peer.ID, out *api.PeerRemoval) error {
+	*out = api.PeerRemoval{
+		Warnings: []string{"removing " + in.Pretty() + " will leave 1 pins under-replicated"},
+	}
+	return nil
+}
+
+func (mock *mockService) PeerstoreAddresses(ctx context.Context, in struct{}, out *api.MultiaddrsSerial) error {
+	*out = api.MultiaddrsSerial{
+		api.MultiaddrSerial("/ip4/1.2.3.4/tcp/9096/ipfs/" + TestPeerID2.Pretty()),
+	}
+	return nil
+}
+
+func (mock *mockService) PeerstoreAdd(ctx context.Context, in api.MultiaddrSerial, out *struct{}) error {
+	pid, _, err := api.Libp2pMultiaddrSplit(in.ToMultiaddr())
+	if err != nil {
+		return err
+	}
+	if pid != TestPeerID2 {
+		return errors.New("not a cluster peer")
+	}
+	return nil
+}
+
+func (mock *mockService) PeerstoreRemove(ctx context.Context, in peer.ID, out *struct{}) error {
+	if in != TestPeerID2 {
+		return errors.New("not a cluster peer")
+	}
+	return nil
+}
+
+func (mock *mockService) PeerBlacklist(ctx context.Context, in struct{}, out *[]peer.ID) error {
+	*out = []peer.ID{TestPeerID3}
+	return nil
+}
+
+func (mock *mockService) PeerBlacklistAdd(ctx context.Context, in peer.ID, out *struct{}) error {
+	if in == TestPeerID1 {
+		return errors.New("cannot blacklist this peer")
+	}
+	return nil
+}
+
+func (mock *mockService) PeerBlacklistRemove(ctx context.Context, in peer.ID, out *struct{}) error {
+	if in != TestPeerID3 {
+		return errors.New("peer is not blacklisted")
+	}
+	return nil
+}
+
+func (mock *mockService) PeerRotate(ctx context.Context, in api.PeerRotationSerial, out *struct{}) error {
+	if in.Old != TestPeerID1.Pretty() {
+		return errors.New("not a cluster peer")
+	}
+	return nil
+}
+
+func (mock *mockService) TransferLeadership(ctx context.Context, in peer.ID, out *struct{}) error {
+	if in != TestPeerID1 && in != TestPeerID2 && in != TestPeerID3 {
+		return errors.New("not a cluster peer")
+	}
 	return nil
 }
 
@@ -141,6 +324,168 @@ func (mock *mockService) ConnectGraph(ctx context.Context, in struct{}, out *api
 	return nil
 }
 
+func (mock *mockService) Metrics(ctx context.Context, in string, out *[]api.Metric) error {
+	return mock.PeerMonitorLatestMetrics(ctx, in, out)
+}
+
+func (mock *mockService) PeerHealth(ctx context.Context, in struct{}, out *[]api.PeerHealthSerial) error {
+	*out = []api.PeerHealthSerial{
+		{
+			Peer:          TestPeerID1.Pretty(),
+			Alive:         true,
+			LastHeartbeat: time.Now().UTC().Format(time.RFC3339),
+			Metrics:       map[string]string{"freespace": "1000"},
+		},
+		{
+			Peer:  TestPeerID2.Pretty(),
+			Alive: false,
+		},
+	}
+	return nil
+}
+
+func (mock *mockService) ResourceUsage(ctx context.Context, in struct{}, out *api.ResourceUsage) error {
+	*out = api.ResourceUsage{
+		Goroutines: 120,
+		Components: []api.ComponentUsage{
+			{Component: "broadcasts", Active: 1},
+			{Component: "pintracker", Active: 2, Queued: 5, Limit: 11},
+			{Component: "proxy", Limit: 50, Rejected: 3},
+		},
+	}
+	return nil
+}
+
+func (mock *mockService) ConsensusState(ctx context.Context, in struct{}, out *api.ConsensusStateSerial) error {
+	*out = api.ConsensusStateSerial{
+		Leader:       TestPeerID1.Pretty(),
+		Term:         2,
+		AppliedIndex: 10,
+		Voters:       []string{TestPeerID1.Pretty(), TestPeerID2.Pretty(), TestPeerID3.Pretty()},
+		NonVoters:    []string{},
+		HasQuorum:    true,
+	}
+	return nil
+}
+
+func (mock *mockService) StatusSummary(ctx context.Context, in struct{}, out *[]api.StatusSummarySerial) error {
+	var sum api.StatusSummarySerial
+	mock.StatusSummaryLocal(ctx, in, &sum)
+	sum2 := api.StatusSummarySerial{
+		Peer:   TestPeerID2.Pretty(),
+		Counts: map[string]int{},
+		Error:  "an error",
+	}
+	*out = []api.StatusSummarySerial{sum, sum2}
+	return nil
+}
+
+func (mock *mockService) StatusSummaryLocal(ctx context.Context, in struct{}, out *api.StatusSummarySerial) error {
+	*out = api.StatusSummarySerial{
+		Peer: TestPeerID1.Pretty(),
+		Counts: map[string]int{
+			api.TrackerStatusPinned.String():   2,
+			api.TrackerStatusPinError.String(): 1,
+		},
+	}
+	return nil
+}
+
+func (mock *mockService) FailureDomains(ctx context.Context, in string, out *[]api.FailureDomainInfoSerial) error {
+	if in == "" {
+		return errors.New("a peer tag is needed")
+	}
+	*out = []api.FailureDomainInfoSerial{
+		{
+			Cid: TestCid1,
+			Domains: map[string][]string{
+				"eu-west": []string{TestPeerID1.Pretty(), TestPeerID2.Pretty()},
+			},
+			Concentrated: true,
+		},
+	}
+	return nil
+}
+
+func (mock *mockService) OrphanPins(ctx context.Context, in api.OrphanAction, out *[]api.OrphanPinsSerial) error {
+	var res api.OrphanPinsSerial
+	err := mock.OrphanPinsLocal(ctx, in, &res)
+	if err != nil {
+		return err
+	}
+	res2 := api.OrphanPinsSerial{
+		Peer:    TestPeerID2.Pretty(),
+		Orphans: []string{},
+		Action:  string(in),
+		Error:   "an error",
+	}
+	*out = []api.OrphanPinsSerial{res, res2}
+	return nil
+}
+
+func (mock *mockService) OrphanPinsLocal(ctx context.Context, in api.OrphanAction, out *api.OrphanPinsSerial) error {
+	switch in {
+	case api.OrphanActionNone, api.OrphanActionAdopt, api.OrphanActionRemove:
+	default:
+		return errors.New("unknown orphan action")
+	}
+	*out = api.OrphanPinsSerial{
+		Peer:    TestPeerID1.Pretty(),
+		Orphans: []string{TestCid3},
+		Action:  string(in),
+	}
+	return nil
+}
+
+func (mock *mockService) RPCStats(ctx context.Context, in struct{}, out *[]api.RPCCallStatsSerial) error {
+	now := time.Now()
+	*out = []api.RPCCallStatsSerial{
+		api.RPCCallStats{
+			Peer:   TestPeerID2,
+			Method: "Cluster.PeerMonitorLogMetric",
+			Count:  20,
+			Last:   now,
+		}.ToSerial(),
+		api.RPCCallStats{
+			Peer:   TestPeerID3,
+			Method: "Cluster.Pin",
+			Count:  1,
+			Last:   now,
+		}.ToSerial(),
+	}
+	return nil
+}
+
+func (mock *mockService) RotateSecret(ctx context.Context, in string, out *api.SecretRotationSerial) error {
+	if in != "" && len(in) != 64 {
+		return errors.New("bad secret")
+	}
+	*out = api.SecretRotationSerial{
+		Updated: []string{TestPeerID1.Pretty(), TestPeerID2.Pretty()},
+		Failed: map[string]string{
+			TestPeerID3.Pretty(): "timeout",
+		},
+	}
+	return nil
+}
+
+func (mock *mockService) RepairState(ctx context.Context, in struct{}, out *api.StateRepair) error {
+	*out = api.StateRepair{
+		Diverged: true,
+		Local: api.StateChecksum{
+			Checksum:     "abc",
+			AppliedIndex: 10,
+			Pins:         2,
+		},
+		Authoritative: api.StateChecksum{
+			Checksum:     "def",
+			AppliedIndex: 10,
+			Pins:         3,
+		},
+	}
+	return nil
+}
+
 func (mock *mockService) StatusAll(ctx context.Context, in struct{}, out *[]api.GlobalPinInfoSerial) error {
 	c1, _ := cid.Decode(TestCid1)
 	c2, _ := cid.Decode(TestCid2)
@@ -183,10 +528,38 @@ func (mock *mockService) StatusAll(ctx context.Context, in struct{}, out *[]api.
 	return nil
 }
 
+func (mock *mockService) StatusAllPartial(ctx context.Context, in time.Duration, out *[]api.GlobalPinInfoSerial) error {
+	return mock.StatusAll(ctx, struct{}{}, out)
+}
+
 func (mock *mockService) StatusAllLocal(ctx context.Context, in struct{}, out *[]api.PinInfoSerial) error {
 	return mock.TrackerStatusAll(ctx, in, out)
 }
 
+func (mock *mockService) StatusShard(ctx context.Context, in api.PinShard, out *[]api.GlobalPinInfoSerial) error {
+	var all []api.GlobalPinInfoSerial
+	mock.StatusAll(ctx, struct{}{}, &all)
+	*out = []api.GlobalPinInfoSerial{}
+	for _, gpi := range all {
+		if in.Contains(gpi.ToGlobalPinInfo().Cid) {
+			*out = append(*out, gpi)
+		}
+	}
+	return nil
+}
+
+func (mock *mockService) StatusShardLocal(ctx context.Context, in api.PinShard, out *[]api.PinInfoSerial) error {
+	var all []api.PinInfoSerial
+	mock.TrackerStatusAll(ctx, struct{}{}, &all)
+	*out = []api.PinInfoSerial{}
+	for _, pinfo := range all {
+		if in.Contains(pinfo.ToPinInfo().Cid) {
+			*out = append(*out, pinfo)
+		}
+	}
+	return nil
+}
+
 func (mock *mockService) Status(ctx context.Context, in api.PinSerial, out *api.GlobalPinInfoSerial) error {
 	if in.Cid == ErrorCid {
 		return ErrBadCid
@@ -206,6 +579,38 @@ func (mock *mockService) Status(ctx context.Context, in api.PinSerial, out *api.
 	return nil
 }
 
+func (mock *mockService) PinDetail(ctx context.Context, in api.PinSerial, out *api.PinDetailSerial) error {
+	var gpi api.GlobalPinInfoSerial
+	err := mock.Status(ctx, in, &gpi)
+	if err != nil {
+		return err
+	}
+	*out = api.PinDetailSerial{
+		GlobalPinInfoSerial: gpi,
+		Pin: &api.PinSerial{
+			Cid:                  gpi.Cid,
+			Allocations:          []string{TestPeerID1.Pretty()},
+			ReplicationFactorMin: 1,
+			ReplicationFactorMax: 1,
+			Recursive:            true,
+		},
+	}
+	return nil
+}
+
+func (mock *mockService) DAGStats(ctx context.Context, in api.PinSerial, out *api.DAGStatsSerial) error {
+	if in.Cid == ErrorCid {
+		return ErrBadCid
+	}
+	err := mock.IPFSDAGStats(ctx, in, out)
+	out.Peer = TestPeerID1.Pretty()
+	return err
+}
+
+func (mock *mockService) StatusPartial(ctx context.Context, in api.StatusRequestSerial, out *api.GlobalPinInfoSerial) error {
+	return mock.Status(ctx, in.Pin, out)
+}
+
 func (mock *mockService) StatusLocal(ctx context.Context, in api.PinSerial, out *api.PinInfoSerial) error {
 	return mock.TrackerStatus(ctx, in, out)
 }
@@ -226,10 +631,114 @@ func (mock *mockService) SyncLocal(ctx context.Context, in api.PinSerial, out *a
 	return mock.StatusLocal(ctx, in, out)
 }
 
+func (mock *mockService) RecoverAll(ctx context.Context, in api.RecoverAllRequest, out *[]api.GlobalPinInfoSerial) error {
+	switch in.Filter {
+	case "error", "pin_error", "unpin_error", "cluster_error":
+		return mock.StatusAll(ctx, struct{}{}, out)
+	default:
+		return errors.New("invalid recover filter: " + in.Filter)
+	}
+}
+
+func (mock *mockService) StartJob(ctx context.Context, in api.JobRequest, out *api.JobSerial) error {
+	switch in.Type {
+	case api.JobTypeRecoverAll, api.JobTypeSyncAll, api.JobTypeRebalance:
+	case api.JobTypeImport:
+		if len(in.Pins) == 0 {
+			return errors.New("there are no pins to import")
+		}
+	default:
+		return errors.New("unknown job type: " + string(in.Type))
+	}
+	*out = api.JobSerial{
+		ID:      TestJobID,
+		Type:    in.Type,
+		Status:  api.JobStatusRunning,
+		Started: time.Now().UTC().Format(time.RFC3339),
+	}
+	return nil
+}
+
+func (mock *mockService) Job(ctx context.Context, in string, out *api.JobSerial) error {
+	if in != TestJobID {
+		return errors.New("job not found")
+	}
+	var gpis []api.GlobalPinInfoSerial
+	mock.StatusAll(ctx, struct{}{}, &gpis)
+	now := time.Now().UTC().Format(time.RFC3339)
+	*out = api.JobSerial{
+		ID:       TestJobID,
+		Type:     api.JobTypeRecoverAll,
+		Status:   api.JobStatusDone,
+		Done:     len(gpis),
+		Total:    len(gpis),
+		Started:  now,
+		Finished: now,
+		Result:   gpis,
+	}
+	return nil
+}
+
+func (mock *mockService) Jobs(ctx context.Context, in struct{}, out *[]api.JobSerial) error {
+	var job api.JobSerial
+	mock.Job(ctx, TestJobID, &job)
+	*out = []api.JobSerial{job}
+	return nil
+}
+
+func (mock *mockService) CancelJob(ctx context.Context, in string, out *struct{}) error {
+	if in != TestJobID {
+		return errors.New("job not found")
+	}
+	return nil
+}
+
 func (mock *mockService) RecoverAllLocal(ctx context.Context, in struct{}, out *[]api.PinInfoSerial) error {
 	return mock.TrackerRecoverAll(ctx, in, out)
 }
 
+func (mock *mockService) Prefetch(ctx context.Context, in api.PinSerial, out *[]peer.ID) error {
+	if in.Cid == ErrorCid {
+		return ErrBadCid
+	}
+	*out = []peer.ID{TestPeerID1, TestPeerID2}
+	return nil
+}
+
+func (mock *mockService) PrefetchLocal(ctx context.Context, in api.PinSerial, out *struct{}) error {
+	return nil
+}
+
+func (mock *mockService) PinsetChanges(ctx context.Context, in api.PinsetChangesRequest, out *api.PinsetChangesSerial) error {
+	c1, _ := cid.Decode(TestCid1)
+	c2, _ := cid.Decode(TestCid2)
+	chs := api.PinsetChanges{
+		Epoch:     "mock",
+		LastIndex: 3,
+	}
+	if (in.Epoch != "" && in.Epoch != chs.Epoch) || in.Since > chs.LastIndex {
+		chs.Resync = true
+		*out = chs.ToSerial()
+		return nil
+	}
+	all := []api.PinsetChange{
+		{Index: 1, Type: api.PinsetChangeAdd, Pin: api.PinCid(c1), Time: time.Now()},
+		{Index: 2, Type: api.PinsetChangeAdd, Pin: api.PinCid(c2), Time: time.Now()},
+		{Index: 3, Type: api.PinsetChangeRemove, Pin: api.PinCid(c1), Time: time.Now()},
+	}
+	for _, ch := range all {
+		if ch.Index <= in.Since {
+			continue
+		}
+		if in.Limit > 0 && len(chs.Changes) >= in.Limit {
+			break
+		}
+		chs.Changes = append(chs.Changes, ch)
+	}
+	*out = chs.ToSerial()
+	return nil
+}
+
 func (mock *mockService) Recover(ctx context.Context, in api.PinSerial, out *api.GlobalPinInfoSerial) error {
 	return mock.Status(ctx, in, out)
 }
@@ -316,10 +825,11 @@ func (mock *mockService) PeerMonitorLogMetric(ctx context.Context, in api.Metric
 // PeerMonitorLatestMetrics runs PeerMonitor.LatestMetrics().
 func (mock *mockService) PeerMonitorLatestMetrics(ctx context.Context, in string, out *[]api.Metric) error {
 	m := api.Metric{
-		Name:  "test",
-		Peer:  TestPeerID1,
-		Value: "0",
-		Valid: true,
+		Name:     in,
+		Peer:     TestPeerID1,
+		Value:    "0",
+		Valid:    true,
+		Received: time.Now().UnixNano(),
 	}
 	m.SetTTL(2 * time.Second)
 	last := []api.Metric{m}
@@ -380,6 +890,28 @@ func (mock *mockService) IPFSRepoSize(ctx context.Context, in struct{}, out *uin
 	return nil
 }
 
+func (mock *mockService) IPFSCumulativeSize(ctx context.Context, in api.PinSerial, out *uint64) error {
+	*out = 1000
+	return nil
+}
+
+func (mock *mockService) IPFSBlockSize(ctx context.Context, in api.PinSerial, out *uint64) error {
+	*out = 100
+	return nil
+}
+
+func (mock *mockService) IPFSDAGStats(ctx context.Context, in api.PinSerial, out *api.DAGStatsSerial) error {
+	*out = api.DAGStatsSerial{
+		Cid:              in.Cid,
+		Blocks:           3,
+		TotalSize:        500,
+		Depth:            3,
+		LargestBlock:     TestCid3,
+		LargestBlockSize: 300,
+	}
+	return nil
+}
+
 func (mock *mockService) IPFSFreeSpace(ctx context.Context, in struct{}, out *uint64) error {
 	// RepoSize is 2KB, StorageMax is 100KB
 	*out = 98000
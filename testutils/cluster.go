@@ -0,0 +1,155 @@
+package testutils
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	ipfscluster "github.com/ipfs/ipfs-cluster"
+	"github.com/ipfs/ipfs-cluster/allocator/ascendalloc"
+	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/consensus/raft"
+	"github.com/ipfs/ipfs-cluster/informer/numpin"
+	"github.com/ipfs/ipfs-cluster/monitor/basic"
+
+	crypto "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// ReadyTimeout specifies how long NewClusters waits for each
+// peer to become ready.
+var ReadyTimeout = 30 * time.Second
+
+// NewClusters starts n cluster peers in the current process and joins
+// them into a single cluster. Every peer uses the in-memory API,
+// IPFSConnector, State and PinTracker from this package, along with
+// Raft consensus, which stores its data under baseDir. The caller is
+// responsible for shutting down the returned peers (see ShutdownClusters)
+// and for removing baseDir.
+func NewClusters(n int, baseDir string) ([]*ipfscluster.Cluster, error) {
+	if n < 1 {
+		return nil, errors.New("at least one cluster peer is needed")
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+
+	clusters := make([]*ipfscluster.Cluster, 0, n)
+	var bootstrap ma.Multiaddr
+	for i := 0; i < n; i++ {
+		cl, addr, err := newCluster(i, secret, baseDir, i != 0)
+		if err != nil {
+			ShutdownClusters(clusters)
+			return nil, err
+		}
+		clusters = append(clusters, cl)
+
+		if i == 0 {
+			bootstrap = addr
+		} else {
+			err = cl.Join(bootstrap)
+			if err != nil {
+				ShutdownClusters(clusters)
+				return nil, err
+			}
+		}
+
+		select {
+		case <-cl.Ready():
+		case <-time.After(ReadyTimeout):
+			ShutdownClusters(clusters)
+			return nil, fmt.Errorf("cluster peer %d did not become ready", i)
+		}
+	}
+	return clusters, nil
+}
+
+// ShutdownClusters shuts down all the given cluster peers. It returns
+// the last error found, if any.
+func ShutdownClusters(clusters []*ipfscluster.Cluster) error {
+	var err error
+	for _, cl := range clusters {
+		if e := cl.Shutdown(); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// newCluster creates the i-th cluster peer and returns it along with a
+// multiaddress that other peers can use to join it.
+func newCluster(i int, secret []byte, baseDir string, staging bool) (*ipfscluster.Cluster, ma.Multiaddr, error) {
+	clusterCfg := &ipfscluster.Config{}
+	if err := clusterCfg.Default(); err != nil {
+		return nil, nil, err
+	}
+	listenAddr, _ := ma.NewMultiaddr("/ip4/127.0.0.1/tcp/0")
+	clusterCfg.ListenAddr = listenAddr
+	clusterCfg.Secret = secret
+	clusterCfg.Peername = fmt.Sprintf("peer_%d", i)
+	clusterCfg.LeaveOnShutdown = false
+	peerDir := filepath.Join(baseDir, clusterCfg.ID.Pretty())
+	clusterCfg.SetBaseDir(peerDir)
+
+	consensusCfg := &raft.Config{}
+	consensusCfg.Default()
+	consensusCfg.SetBaseDir(peerDir)
+
+	monCfg := &basic.Config{}
+	monCfg.Default()
+
+	numpinCfg := &numpin.Config{}
+	numpinCfg.Default()
+
+	host, err := ipfscluster.NewClusterHost(context.Background(), clusterCfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, ipfsPub, err := crypto.GenerateKeyPair(crypto.RSA, 2048)
+	if err != nil {
+		return nil, nil, err
+	}
+	ipfsID, err := peer.IDFromPublicKey(ipfsPub)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	st := NewState()
+	consensus, err := raft.NewConsensus(host, consensusCfg, st, staging)
+	if err != nil {
+		return nil, nil, err
+	}
+	mon, err := basic.NewMonitor(monCfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	inf, err := numpin.NewInformer(numpinCfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cl, err := ipfscluster.NewCluster(
+		host,
+		clusterCfg,
+		consensus,
+		NewAPI(),
+		NewIPFSConnector(ipfsID),
+		st,
+		NewPinTracker(clusterCfg.ID),
+		mon,
+		ascendalloc.NewAllocator(),
+		inf,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	addr := api.MustLibp2pMultiaddrJoin(host.Addrs()[0], host.ID())
+	return cl, addr, nil
+}
@@ -0,0 +1,62 @@
+package testutils
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/test"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+const testBaseDir = "testutilsTestData"
+
+func TestNewClusters(t *testing.T) {
+	defer os.RemoveAll(testBaseDir)
+	clusters, err := NewClusters(2, testBaseDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ShutdownClusters(clusters)
+
+	for _, cl := range clusters {
+		if len(cl.Peers()) != 2 {
+			t.Error("expected 2 cluster peers")
+		}
+	}
+
+	c, _ := cid.Decode(test.TestCid1)
+	err = clusters[0].Pin(api.PinCid(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+
+	for _, cl := range clusters {
+		pinfo := cl.StatusLocal(c)
+		if pinfo.Status != api.TrackerStatusPinned {
+			t.Errorf("expected pinned but got %s", pinfo.Status)
+		}
+	}
+}
+
+func TestIPFSConnector(t *testing.T) {
+	ctx := context.Background()
+	ipfs := NewIPFSConnector(test.TestPeerID1)
+	c, _ := cid.Decode(test.TestCid1)
+
+	ipfs.Pin(ctx, c, true)
+	st, _ := ipfs.PinLsCid(ctx, c)
+	if st != api.IPFSPinStatusRecursive {
+		t.Error("expected recursive pin")
+	}
+
+	ipfs.Unpin(ctx, c)
+	st, _ = ipfs.PinLsCid(ctx, c)
+	if st.IsPinned() {
+		t.Error("expected cid to be unpinned")
+	}
+}
@@ -0,0 +1,374 @@
+// Package testutils provides in-memory implementations of several IPFS
+// Cluster components, along with helpers to run multi-peer clusters
+// in-process. It is meant for applications embedding ipfs-cluster which
+// want to write integration tests without running real ipfs daemons.
+package testutils
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/state"
+	"github.com/ipfs/ipfs-cluster/state/mapstate"
+
+	rpc "github.com/hsanjuan/go-libp2p-gorpc"
+	cid "github.com/ipfs/go-cid"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+// DefaultFreeSpace is the repository free space reported by IPFSConnector.
+var DefaultFreeSpace uint64 = 100 * 1024 * 1024 * 1024
+
+var errNotPinned = errors.New("not pinned")
+
+// API is an API component which does nothing. It can be used
+// when the cluster is driven directly through its Go methods.
+type API struct {
+	rpcClient *rpc.Client
+}
+
+// NewAPI returns a new API component.
+func NewAPI() *API {
+	return &API{}
+}
+
+// SetClient stores the RPC client.
+func (a *API) SetClient(c *rpc.Client) {
+	a.rpcClient = c
+}
+
+// Shutdown does nothing.
+func (a *API) Shutdown() error {
+	return nil
+}
+
+// IPFSConnector is an IPFSConnector component which keeps the pinset
+// in memory instead of talking to an ipfs daemon. It is thread-safe.
+type IPFSConnector struct {
+	id peer.ID
+
+	mux  sync.RWMutex
+	pins map[string]api.IPFSPinStatus
+}
+
+// NewIPFSConnector returns a new IPFSConnector which pretends to be
+// the ipfs daemon with the given peer ID.
+func NewIPFSConnector(id peer.ID) *IPFSConnector {
+	return &IPFSConnector{
+		id:   id,
+		pins: make(map[string]api.IPFSPinStatus),
+	}
+}
+
+// SetClient does nothing.
+func (ipfs *IPFSConnector) SetClient(c *rpc.Client) {}
+
+// Shutdown does nothing.
+func (ipfs *IPFSConnector) Shutdown() error {
+	return nil
+}
+
+// ID returns the ipfs daemon peer ID given on creation.
+func (ipfs *IPFSConnector) ID() (api.IPFSID, error) {
+	return api.IPFSID{ID: ipfs.id, Reachable: true}, nil
+}
+
+// Pin adds a Cid to the in-memory pinset.
+func (ipfs *IPFSConnector) Pin(ctx context.Context, c *cid.Cid, recursive bool) error {
+	ipfs.mux.Lock()
+	defer ipfs.mux.Unlock()
+	if recursive {
+		ipfs.pins[c.String()] = api.IPFSPinStatusRecursive
+	} else {
+		ipfs.pins[c.String()] = api.IPFSPinStatusDirect
+	}
+	return nil
+}
+
+// Unpin removes a Cid from the in-memory pinset.
+func (ipfs *IPFSConnector) Unpin(ctx context.Context, c *cid.Cid) error {
+	ipfs.mux.Lock()
+	defer ipfs.mux.Unlock()
+	delete(ipfs.pins, c.String())
+	return nil
+}
+
+// PinLsCid returns the pin type of a Cid.
+func (ipfs *IPFSConnector) PinLsCid(ctx context.Context, c *cid.Cid) (api.IPFSPinStatus, error) {
+	ipfs.mux.RLock()
+	defer ipfs.mux.RUnlock()
+	st, ok := ipfs.pins[c.String()]
+	if !ok {
+		return api.IPFSPinStatusUnpinned, nil
+	}
+	return st, nil
+}
+
+// PinLs returns the in-memory pinset. The type filter is ignored.
+func (ipfs *IPFSConnector) PinLs(ctx context.Context, typeFilter string) (map[string]api.IPFSPinStatus, error) {
+	ipfs.mux.RLock()
+	defer ipfs.mux.RUnlock()
+	m := make(map[string]api.IPFSPinStatus, len(ipfs.pins))
+	for k, v := range ipfs.pins {
+		m[k] = v
+	}
+	return m, nil
+}
+
+// ConnectSwarms does nothing.
+func (ipfs *IPFSConnector) ConnectSwarms() error {
+	return nil
+}
+
+// SwarmPeers returns an empty list of peers.
+func (ipfs *IPFSConnector) SwarmPeers() (api.SwarmPeers, error) {
+	return api.SwarmPeers{}, nil
+}
+
+// ConfigKey returns nil for any key.
+func (ipfs *IPFSConnector) ConfigKey(keypath string) (interface{}, error) {
+	return nil, nil
+}
+
+// FreeSpace returns DefaultFreeSpace.
+func (ipfs *IPFSConnector) FreeSpace() (uint64, error) {
+	return DefaultFreeSpace, nil
+}
+
+// RepoSize returns 0.
+func (ipfs *IPFSConnector) RepoSize() (uint64, error) {
+	return 0, nil
+}
+
+// CumulativeSize returns 0.
+func (ipfs *IPFSConnector) CumulativeSize(c *cid.Cid) (uint64, error) {
+	return 0, nil
+}
+
+// BlockSize returns 0.
+func (ipfs *IPFSConnector) BlockSize(c *cid.Cid) (uint64, error) {
+	return 0, nil
+}
+
+// DAGStats returns the stats of a DAG made of a single, empty block.
+func (ipfs *IPFSConnector) DAGStats(c *cid.Cid) (api.DAGStats, error) {
+	return api.DAGStats{Cid: c, Blocks: 1, Depth: 1, LargestBlock: c}, nil
+}
+
+// Prefetch does nothing, as there are no blocks to fetch.
+func (ipfs *IPFSConnector) Prefetch(ctx context.Context, c *cid.Cid) error {
+	return nil
+}
+
+// NewState returns a new, empty, in-memory State.
+func NewState() state.State {
+	return mapstate.NewMapState()
+}
+
+// PinTracker is a PinTracker component which performs pins and
+// unpins synchronously, by calling the IPFSConnector through RPC, and
+// keeps their status in memory. It is thread-safe.
+type PinTracker struct {
+	peerID    peer.ID
+	rpcClient *rpc.Client
+
+	mux    sync.RWMutex
+	status map[string]api.PinInfo
+}
+
+// NewPinTracker returns a new PinTracker for the cluster peer with the
+// given ID.
+func NewPinTracker(pid peer.ID) *PinTracker {
+	return &PinTracker{
+		peerID: pid,
+		status: make(map[string]api.PinInfo),
+	}
+}
+
+// SetClient stores the RPC client.
+func (pt *PinTracker) SetClient(c *rpc.Client) {
+	pt.rpcClient = c
+}
+
+// Shutdown does nothing.
+func (pt *PinTracker) Shutdown() error {
+	return nil
+}
+
+func (pt *PinTracker) set(c *cid.Cid, st api.TrackerStatus, err error) {
+	pi := api.PinInfo{
+		Cid:    c,
+		Peer:   pt.peerID,
+		Status: st,
+		TS:     time.Now(),
+	}
+	if err != nil {
+		pi.Error = err.Error()
+	}
+	pt.mux.Lock()
+	pt.status[c.String()] = pi
+	pt.mux.Unlock()
+}
+
+func (pt *PinTracker) get(c *cid.Cid) api.PinInfo {
+	pt.mux.RLock()
+	defer pt.mux.RUnlock()
+	pi, ok := pt.status[c.String()]
+	if !ok {
+		return api.PinInfo{
+			Cid:    c,
+			Peer:   pt.peerID,
+			Status: api.TrackerStatusUnpinned,
+			TS:     time.Now(),
+		}
+	}
+	return pi
+}
+
+func (pt *PinTracker) isAllocated(pin api.Pin) bool {
+	if pin.ReplicationFactorMin < 0 {
+		return true
+	}
+	for _, p := range pin.Allocations {
+		if p == pt.peerID {
+			return true
+		}
+	}
+	return false
+}
+
+func (pt *PinTracker) pin(pin api.Pin) error {
+	err := pt.rpcClient.Call("",
+		"Cluster",
+		"IPFSPin",
+		pin.ToSerial(),
+		&struct{}{})
+	if err != nil {
+		pt.set(pin.Cid, api.TrackerStatusPinError, err)
+		return err
+	}
+	pt.set(pin.Cid, api.TrackerStatusPinned, nil)
+	return nil
+}
+
+func (pt *PinTracker) unpin(c *cid.Cid) error {
+	err := pt.rpcClient.Call("",
+		"Cluster",
+		"IPFSUnpin",
+		api.PinCid(c).ToSerial(),
+		&struct{}{})
+	if err != nil {
+		pt.set(c, api.TrackerStatusUnpinError, err)
+		return err
+	}
+	pt.mux.Lock()
+	delete(pt.status, c.String())
+	pt.mux.Unlock()
+	return nil
+}
+
+// Track pins the given Cid if it is allocated to this peer, or marks it
+// as remote otherwise.
+func (pt *PinTracker) Track(pin api.Pin) error {
+	if !pt.isAllocated(pin) {
+		pt.set(pin.Cid, api.TrackerStatusRemote, nil)
+		return nil
+	}
+	return pt.pin(pin)
+}
+
+// Untrack unpins the given Cid.
+func (pt *PinTracker) Untrack(c *cid.Cid) error {
+	return pt.unpin(c)
+}
+
+// Restore sets the status of the given Cid without pinning or
+// unpinning it.
+func (pt *PinTracker) Restore(pin api.Pin, st api.TrackerStatus) error {
+	pt.set(pin.Cid, st, nil)
+	return nil
+}
+
+// StatusAll returns the status of all tracked Cids.
+func (pt *PinTracker) StatusAll() []api.PinInfo {
+	pt.mux.RLock()
+	defer pt.mux.RUnlock()
+	pins := make([]api.PinInfo, 0, len(pt.status))
+	for _, pi := range pt.status {
+		pins = append(pins, pi)
+	}
+	return pins
+}
+
+// Status returns the status of the given Cid.
+func (pt *PinTracker) Status(c *cid.Cid) api.PinInfo {
+	return pt.get(c)
+}
+
+// SyncAll checks the status of every tracked Cid against the
+// IPFSConnector and returns those which changed.
+func (pt *PinTracker) SyncAll() ([]api.PinInfo, error) {
+	var changed []api.PinInfo
+	for _, pi := range pt.StatusAll() {
+		before := pi.Status
+		after, err := pt.Sync(pi.Cid)
+		if err != nil {
+			return changed, err
+		}
+		if before != after.Status {
+			changed = append(changed, after)
+		}
+	}
+	return changed, nil
+}
+
+// Sync checks the status of a Cid against the IPFSConnector.
+func (pt *PinTracker) Sync(c *cid.Cid) (api.PinInfo, error) {
+	pi := pt.get(c)
+	if pi.Status != api.TrackerStatusPinned {
+		return pi, nil
+	}
+
+	var ips api.IPFSPinStatus
+	err := pt.rpcClient.Call("",
+		"Cluster",
+		"IPFSPinLsCid",
+		api.PinCid(c).ToSerial(),
+		&ips)
+	if err != nil {
+		return pi, err
+	}
+	if !ips.IsPinned() {
+		pt.set(c, api.TrackerStatusPinError, errNotPinned)
+	}
+	return pt.get(c), nil
+}
+
+// RecoverAll calls Recover on every tracked Cid.
+func (pt *PinTracker) RecoverAll() ([]api.PinInfo, error) {
+	var pins []api.PinInfo
+	for _, pi := range pt.StatusAll() {
+		res, err := pt.Recover(pi.Cid)
+		if err != nil {
+			return pins, err
+		}
+		pins = append(pins, res)
+	}
+	return pins, nil
+}
+
+// Recover retries pinning or unpinning a Cid in error state.
+func (pt *PinTracker) Recover(c *cid.Cid) (api.PinInfo, error) {
+	pi := pt.get(c)
+	var err error
+	switch pi.Status {
+	case api.TrackerStatusPinError:
+		err = pt.pin(api.PinCid(c))
+	case api.TrackerStatusUnpinError:
+		err = pt.unpin(c)
+	}
+	return pt.get(c), err
+}
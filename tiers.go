@@ -0,0 +1,326 @@
+package ipfscluster
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+
+	"github.com/ipfs/ipfs-cluster/api"
+)
+
+// TierPolicy describes when pins should be moved from one peer group to
+// another (see Config.PeerGroups), i.e. from "ssd-tier" to "archive-tier"
+// after 90 days. A pin in the From group is migrated when it satisfies all
+// the conditions set in the policy.
+type TierPolicy struct {
+	From string
+	To   string
+
+	// After migrates pins which were added longer than this ago.
+	After time.Duration
+
+	// MetadataKey and MetadataValue migrate pins whose metadata has
+	// the given value for the given key (i.e. "access": "rare").
+	MetadataKey   string
+	MetadataValue string
+}
+
+type tierPolicyJSON struct {
+	From          string `json:"from"`
+	To            string `json:"to"`
+	After         string `json:"after,omitempty"`
+	MetadataKey   string `json:"metadata_key,omitempty"`
+	MetadataValue string `json:"metadata_value,omitempty"`
+}
+
+func (tp TierPolicy) toJSON() tierPolicyJSON {
+	jtp := tierPolicyJSON{
+		From:          tp.From,
+		To:            tp.To,
+		MetadataKey:   tp.MetadataKey,
+		MetadataValue: tp.MetadataValue,
+	}
+	if tp.After > 0 {
+		jtp.After = tp.After.String()
+	}
+	return jtp
+}
+
+func (jtp tierPolicyJSON) toTierPolicy() (TierPolicy, error) {
+	tp := TierPolicy{
+		From:          jtp.From,
+		To:            jtp.To,
+		MetadataKey:   jtp.MetadataKey,
+		MetadataValue: jtp.MetadataValue,
+	}
+	if jtp.After != "" {
+		d, err := time.ParseDuration(jtp.After)
+		if err != nil {
+			return tp, fmt.Errorf("error parsing cluster.tier_policies: %s", err)
+		}
+		tp.After = d
+	}
+	return tp, nil
+}
+
+func (tp TierPolicy) validate(groups map[string][]peer.ID) error {
+	if _, ok := groups[tp.From]; !ok {
+		return fmt.Errorf("cluster.tier_policies: unknown peer group %q", tp.From)
+	}
+	if _, ok := groups[tp.To]; !ok {
+		return fmt.Errorf("cluster.tier_policies: unknown peer group %q", tp.To)
+	}
+	if tp.From == tp.To {
+		return errors.New("cluster.tier_policies: from and to groups must differ")
+	}
+	if tp.After < 0 {
+		return errors.New("cluster.tier_policies: after is invalid")
+	}
+	if tp.After == 0 && tp.MetadataKey == "" {
+		return errors.New("cluster.tier_policies: policies need an age or a metadata condition")
+	}
+	return nil
+}
+
+// validateTierPolicies validates every policy and checks that they do not
+// form cycles (i.e. "a" to "b" and "b" to "a"), which would move pins
+// around forever.
+func validateTierPolicies(policies []TierPolicy, groups map[string][]peer.ID) error {
+	next := make(map[string][]string)
+	for _, tp := range policies {
+		if err := tp.validate(groups); err != nil {
+			return err
+		}
+		next[tp.From] = append(next[tp.From], tp.To)
+	}
+
+	// Depth-first search. A group found again while its
+	// descendants are being visited closes a cycle.
+	const (
+		visiting = iota + 1
+		visited
+	)
+	state := make(map[string]int)
+	var visit func(g string) error
+	visit = func(g string) error {
+		switch state[g] {
+		case visiting:
+			return fmt.Errorf("cluster.tier_policies: policies form a cycle through group %q", g)
+		case visited:
+			return nil
+		}
+		state[g] = visiting
+		for _, to := range next[g] {
+			if err := visit(to); err != nil {
+				return err
+			}
+		}
+		state[g] = visited
+		return nil
+	}
+	for _, tp := range policies {
+		if err := visit(tp.From); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matches returns true when the given pin should be migrated.
+func (tp TierPolicy) matches(pin api.Pin, now time.Time) bool {
+	if pin.Group != tp.From {
+		return false
+	}
+	if tp.After > 0 && (pin.Timestamp.IsZero() || now.Sub(pin.Timestamp) < tp.After) {
+		return false
+	}
+	if tp.MetadataKey != "" && pin.Metadata[tp.MetadataKey] != tp.MetadataValue {
+		return false
+	}
+	return true
+}
+
+// tierMigrations returns up to max pins which should be moved to a
+// different group according to the given policies, with their new
+// group set. The first matching policy applies.
+func tierMigrations(pins []api.Pin, policies []TierPolicy, max int, now time.Time) []api.Pin {
+	sort.Slice(pins, func(i, j int) bool {
+		return pins[i].Cid.String() < pins[j].Cid.String()
+	})
+
+	var migrations []api.Pin
+	for _, pin := range pins {
+		if len(migrations) >= max {
+			break
+		}
+		for _, tp := range policies {
+			if tp.matches(pin, now) {
+				pin.Group = tp.To
+				migrations = append(migrations, pin)
+				break
+			}
+		}
+	}
+	return migrations
+}
+
+// migrateTiers re-pins, in their new group, a batch of the pins which
+// should be migrated according to Config.TierPolicies. It returns the
+// number of migrations started.
+//
+// Migrations happen in two steps so that pins do not lose replicas while
+// being moved. First, the pin is allocated to members of its new group
+// while keeping its current allocations. Then, once all the new
+// allocations have pinned it, the old ones are dropped (see
+// finishTierMigrations).
+func (c *Cluster) migrateTiers() (int, error) {
+	cState, err := c.consensus.State()
+	if err != nil {
+		return 0, err
+	}
+	pins := cState.List()
+
+	c.finishTierMigrations(pins)
+
+	migrations := tierMigrations(
+		pins,
+		c.config.TierPolicies,
+		c.config.TierMigrationBatch,
+		time.Now(),
+	)
+
+	var n int
+	for _, pin := range migrations {
+		err := c.startTierMigration(pin)
+		if err != nil {
+			c.logger.Errorf("migrating %s to group %s: %s", pin.Cid, pin.Group, err)
+			continue
+		}
+		c.logger.Infof("migrating %s to group %s", pin.Cid, pin.Group)
+		n++
+	}
+	return n, nil
+}
+
+// startTierMigration commits the given pin, whose Group is already the one
+// it migrates to, allocated to members of that group in addition to its
+// current allocations.
+func (c *Cluster) startTierMigration(pin api.Pin) error {
+	members, err := c.peerGroup(pin.Group)
+	if err != nil {
+		return err
+	}
+	outside, err := c.peersOutsideGroup(members)
+	if err != nil {
+		return err
+	}
+
+	everywhere := api.ReplicationFactorEverywhere
+	var allocs []peer.ID
+	if pin.ReplicationFactorMin == everywhere && pin.ReplicationFactorMax == everywhere {
+		allocs = members
+	} else {
+		allocs, err = c.allocate(
+			pin.Cid,
+			pin.ReplicationFactorMin,
+			pin.ReplicationFactorMax,
+			outside,
+			[]peer.ID{},
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, p := range pin.Allocations {
+		if !containsPeer(allocs, p) {
+			allocs = append(allocs, p)
+		}
+	}
+	pin.Allocations = allocs
+	return c.consensus.LogPin(pin.StripRequest())
+}
+
+// finishTierMigrations drops the allocations outside their group of the
+// given pins, as left by startTierMigration, once the allocations in the
+// group have pinned them. Up to Config.TierMigrationBatch pins are
+// checked every time.
+func (c *Cluster) finishTierMigrations(pins []api.Pin) {
+	var checked int
+	for _, pin := range pins {
+		if checked >= c.config.TierMigrationBatch {
+			return
+		}
+		if pin.Group == "" {
+			continue
+		}
+		members, err := c.peerGroup(pin.Group)
+		if err != nil {
+			continue
+		}
+		var inGroup []peer.ID
+		for _, p := range pin.Allocations {
+			if containsPeer(members, p) {
+				inGroup = append(inGroup, p)
+			}
+		}
+		if len(inGroup) == 0 || len(inGroup) == len(pin.Allocations) {
+			continue // not migrating
+		}
+		checked++
+
+		gpi, err := c.Status(pin.Cid)
+		if err != nil {
+			c.logger.Error(err)
+			continue
+		}
+		pinned := true
+		for _, p := range inGroup {
+			if gpi.PeerMap[p].Status != api.TrackerStatusPinned {
+				pinned = false
+				break
+			}
+		}
+		if !pinned {
+			continue
+		}
+
+		pin.Allocations = inGroup
+		err = c.consensus.LogPin(pin.StripRequest())
+		if err != nil {
+			c.logger.Errorf("finishing the migration of %s to group %s: %s", pin.Cid, pin.Group, err)
+			continue
+		}
+		c.logger.Infof("migrated %s to group %s", pin.Cid, pin.Group)
+	}
+}
+
+// watchTiers regularly migrates pins between peer groups following
+// Config.TierPolicies. Only the leader does this, in batches of
+// Config.TierMigrationBatch pins, so that migrations happen gradually.
+func (c *Cluster) watchTiers() {
+	if len(c.config.TierPolicies) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(c.config.TierMigrationInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			leader, err := c.consensus.Leader()
+			if err != nil || leader != c.id {
+				continue
+			}
+			if _, err := c.migrateTiers(); err != nil {
+				c.logger.Error(err)
+			}
+		}
+	}
+}
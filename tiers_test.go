@@ -0,0 +1,90 @@
+package ipfscluster
+
+import (
+	"testing"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	peer "github.com/libp2p/go-libp2p-peer"
+
+	"github.com/ipfs/ipfs-cluster/api"
+	"github.com/ipfs/ipfs-cluster/test"
+)
+
+func TestTierMigrations(t *testing.T) {
+	now := time.Now()
+	h1, _ := cid.Decode(test.TestCid1)
+	h2, _ := cid.Decode(test.TestCid2)
+	h3, _ := cid.Decode(test.TestCid3)
+
+	old := api.PinCid(h1)
+	old.Group = "ssd"
+	old.Timestamp = now.Add(-100 * 24 * time.Hour)
+
+	recent := api.PinCid(h2)
+	recent.Group = "ssd"
+	recent.Timestamp = now.Add(-time.Hour)
+
+	hinted := api.PinCid(h3)
+	hinted.Group = "ssd"
+	hinted.Timestamp = now
+	hinted.Metadata = map[string]string{"access": "rare"}
+
+	policies := []TierPolicy{
+		{From: "ssd", To: "archive", After: 90 * 24 * time.Hour},
+		{From: "ssd", To: "cold", MetadataKey: "access", MetadataValue: "rare"},
+	}
+
+	migrations := tierMigrations([]api.Pin{recent, hinted, old}, policies, 10, now)
+	if len(migrations) != 2 {
+		t.Fatalf("expected 2 migrations, got %d", len(migrations))
+	}
+	for _, m := range migrations {
+		switch {
+		case m.Cid.Equals(h1):
+			if m.Group != "archive" {
+				t.Errorf("%s should move to archive, not %s", m.Cid, m.Group)
+			}
+		case m.Cid.Equals(h3):
+			if m.Group != "cold" {
+				t.Errorf("%s should move to cold, not %s", m.Cid, m.Group)
+			}
+		default:
+			t.Errorf("%s should not be migrated", m.Cid)
+		}
+	}
+
+	migrations = tierMigrations([]api.Pin{recent, hinted, old}, policies, 1, now)
+	if len(migrations) != 1 {
+		t.Errorf("expected a batch of 1 migration, got %d", len(migrations))
+	}
+
+	// Pins without a timestamp are never too old
+	old.Timestamp = time.Time{}
+	migrations = tierMigrations([]api.Pin{old}, policies, 10, now)
+	if len(migrations) != 0 {
+		t.Error("pins without a timestamp should not be migrated by age")
+	}
+}
+
+func TestValidateTierPolicies(t *testing.T) {
+	groups := map[string][]peer.ID{
+		"ssd":     {test.TestPeerID1},
+		"hdd":     {test.TestPeerID2},
+		"archive": {test.TestPeerID3},
+	}
+
+	policies := []TierPolicy{
+		{From: "ssd", To: "hdd", After: time.Hour},
+		{From: "hdd", To: "archive", After: time.Hour},
+		{From: "ssd", To: "archive", MetadataKey: "access", MetadataValue: "rare"},
+	}
+	if err := validateTierPolicies(policies, groups); err != nil {
+		t.Error(err)
+	}
+
+	policies = append(policies, TierPolicy{From: "archive", To: "ssd", MetadataKey: "access", MetadataValue: "hot"})
+	if err := validateTierPolicies(policies, groups); err == nil {
+		t.Error("expected an error for policies forming a cycle")
+	}
+}
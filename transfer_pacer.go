@@ -0,0 +1,52 @@
+package ipfscluster
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// transferPacer spaces state transfers to other peers so that, on
+// average, they do not exceed a number of bytes per second (see
+// Config.StateTransferLimit). Every transfer reserves the time that
+// sending it should take at the given rate, delaying the transfers which
+// come after it. A single large transfer is never split: it goes out
+// when its turn arrives.
+type transferPacer struct {
+	logger *instanceLogger
+	limit  uint64 // bytes per second. 0 means no limit.
+
+	mux  sync.Mutex
+	next time.Time // transfers cannot start before this time
+}
+
+// wait blocks until a transfer of the given size can start or the
+// context is cancelled.
+func (p *transferPacer) wait(ctx context.Context, size int) error {
+	if p.limit == 0 || size <= 0 {
+		return nil
+	}
+
+	p.mux.Lock()
+	now := time.Now()
+	if p.next.Before(now) {
+		p.next = now
+	}
+	d := p.next.Sub(now)
+	p.next = p.next.Add(time.Duration(float64(size) / float64(p.limit) * float64(time.Second)))
+	p.mux.Unlock()
+
+	if d <= 0 {
+		return nil
+	}
+
+	p.logger.Debugf("state transfer limit reached. Waiting %s", d)
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
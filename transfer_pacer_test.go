@@ -0,0 +1,45 @@
+package ipfscluster
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTransferPacer(t *testing.T) {
+	ctx := context.Background()
+
+	p := &transferPacer{}
+	p.wait(ctx, 1024*1024)
+	start := time.Now()
+	p.wait(ctx, 1024*1024)
+	if time.Since(start) > 100*time.Millisecond {
+		t.Error("no limit should not delay transfers")
+	}
+
+	p = &transferPacer{limit: 1000}
+	start = time.Now()
+	err := p.wait(ctx, 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) > 100*time.Millisecond {
+		t.Error("the first transfer should not wait")
+	}
+
+	err = p.wait(ctx, 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) < 400*time.Millisecond {
+		t.Error("the second transfer should have waited")
+	}
+
+	p.wait(ctx, 10000)
+	cctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	err = p.wait(cctx, 10)
+	if err == nil {
+		t.Error("expected an error when the context is cancelled")
+	}
+}
@@ -0,0 +1,105 @@
+package ipfscluster
+
+import (
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+// RPC methods which result in consensus operations (LogPin, LogUnpin,
+// LogAddPeer...) or which modify the cluster configuration, the pinset
+// tracked by a peer, its IPFS pins and connections, its peerstore or the
+// metrics used for allocations when called by other peers. Only trusted
+// peers may call them when Config.TrustedPeers is set. Methods which fan
+// out to other peers (i.e. Prefetch) are included too, as the fanned out
+// calls are made by this peer.
+var consensusOriginatingMethods = map[string]struct{}{
+	"Cluster.Pin":               struct{}{},
+	"Cluster.Unpin":             struct{}{},
+	"Cluster.UnpinMatching":     struct{}{},
+	"Cluster.Join":              struct{}{},
+	"Cluster.PeerAdd":           struct{}{},
+	"Cluster.PeerRemove":        struct{}{},
+	"Cluster.PeerRotate":        struct{}{},
+	"Cluster.SetSharedConfig":   struct{}{},
+	"Cluster.ApplySharedConfig": struct{}{},
+	"Cluster.Pause":             struct{}{},
+	"Cluster.Resume":            struct{}{},
+	"Cluster.OrphanPins":        struct{}{},
+	"Cluster.OrphanPinsLocal":   struct{}{},
+	"Cluster.RepairState":       struct{}{},
+	"Cluster.ConsensusLogPin":   struct{}{},
+	"Cluster.ConsensusLogUnpin": struct{}{},
+	"Cluster.ConsensusAddPeer":  struct{}{},
+	"Cluster.ConsensusRmPeer":   struct{}{},
+
+	"Cluster.ConsensusLogSharedConfig": struct{}{},
+
+	"Cluster.TransferLeadership":          struct{}{},
+	"Cluster.PreloadState":                struct{}{},
+	"Cluster.ConsensusTransferLeadership": struct{}{},
+	"Cluster.PeerRemoveForce":             struct{}{},
+	"Cluster.PeerBlacklistAdd":            struct{}{},
+	"Cluster.PeerBlacklistRemove":         struct{}{},
+	"Cluster.PeerstoreAdd":                struct{}{},
+	"Cluster.PeerstoreRemove":             struct{}{},
+
+	"Cluster.RotateSecret":          struct{}{},
+	"Cluster.PrepareSecretRotation": struct{}{},
+	"Cluster.CommitSecretRotation":  struct{}{},
+	"Cluster.AbortSecretRotation":   struct{}{},
+
+	"Cluster.Track":             struct{}{},
+	"Cluster.Untrack":           struct{}{},
+	"Cluster.IPFSPin":           struct{}{},
+	"Cluster.IPFSUnpin":         struct{}{},
+	"Cluster.IPFSConnectSwarms": struct{}{},
+	"Cluster.Prefetch":          struct{}{},
+	"Cluster.PrefetchLocal":     struct{}{},
+	"Cluster.StartJob":          struct{}{},
+	"Cluster.CancelJob":         struct{}{},
+
+	"Cluster.Recover":           struct{}{},
+	"Cluster.RecoverLocal":      struct{}{},
+	"Cluster.RecoverAll":        struct{}{},
+	"Cluster.RecoverAllLocal":   struct{}{},
+	"Cluster.TrackerRecover":    struct{}{},
+	"Cluster.TrackerRecoverAll": struct{}{},
+	"Cluster.Sync":              struct{}{},
+	"Cluster.SyncLocal":         struct{}{},
+	"Cluster.SyncAll":           struct{}{},
+	"Cluster.SyncAllLocal":      struct{}{},
+
+	"Cluster.PeerManagerAddPeer":         struct{}{},
+	"Cluster.PeerManagerImportAddresses": struct{}{},
+	"Cluster.PeerMonitorLogMetric":       struct{}{},
+}
+
+// RPC methods which other peers may never call, as they are only meant
+// to be used through the APIs of this peer.
+var localOnlyMethods = map[string]struct{}{
+	"Cluster.InjectFaults": struct{}{},
+}
+
+// isTrustedPeer returns true when no trusted peers are configured
+// or when the given peer is among them.
+func (c *Cluster) isTrustedPeer(p peer.ID) bool {
+	if len(c.config.TrustedPeers) == 0 {
+		return true
+	}
+	return p == c.id || containsPeer(c.config.TrustedPeers, p)
+}
+
+// authorizeRPC decides whether a remote peer is allowed to call the
+// given RPC method on this peer. Blacklisted peers are not allowed to
+// call any.
+func (c *Cluster) authorizeRPC(p peer.ID, method string) bool {
+	if !c.acceptPeer(p) {
+		return false
+	}
+	if _, ok := localOnlyMethods[method]; ok {
+		return p == c.id
+	}
+	if _, ok := consensusOriginatingMethods[method]; !ok {
+		return true
+	}
+	return c.isTrustedPeer(p)
+}
@@ -1,8 +1,7 @@
 package ipfscluster
 
 import (
-	"errors"
-	"fmt"
+	"sort"
 
 	"github.com/ipfs/ipfs-cluster/api"
 
@@ -79,12 +78,6 @@ func GlobalPinInfoSliceToSerial(gpi []api.GlobalPinInfo) []api.GlobalPinInfoSeri
 	return gpis
 }
 
-func logError(fmtstr string, args ...interface{}) error {
-	msg := fmt.Sprintf(fmtstr, args...)
-	logger.Error(msg)
-	return errors.New(msg)
-}
-
 func containsPeer(list []peer.ID, peer peer.ID) bool {
 	for _, p := range list {
 		if p == peer {
@@ -94,6 +87,26 @@ func containsPeer(list []peer.ID, peer peer.ID) bool {
 	return false
 }
 
+// samePeers returns true when both lists contain the same peers,
+// regardless of their order. Lists should not contain duplicates.
+func samePeers(list1, list2 []peer.ID) bool {
+	if len(list1) != len(list2) {
+		return false
+	}
+	for _, p := range list1 {
+		if !containsPeer(list2, p) {
+			return false
+		}
+	}
+	return true
+}
+
+func sortPeers(peers []peer.ID) {
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i] < peers[j]
+	})
+}
+
 func minInt(x, y int) int {
 	if x < y {
 		return x
@@ -4,5 +4,8 @@ package ipfscluster
 // components, apis and tools ensures compatibility among them.
 const Version = "0.4.0"
 
-// Commit is the current build commit of cluster. See Makefile.
+// Commit is the current build commit of cluster. See Makefile. It is a
+// package variable because "-ldflags -X" can only set those. Every
+// Cluster keeps the value that Commit had when it was created (see
+// NewCluster), so it only needs to be set before creating peers.
 var Commit = "00000000" // actual commit set during builds.